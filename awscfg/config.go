@@ -30,6 +30,9 @@ type Config struct {
 	SharedCredentialsFiles []string
 	// LoadOptions holds additional aws-sdk-go-v2/config.LoadOptions functions.
 	LoadOptions []func(*config.LoadOptions) error
+	// HTTPClient is an optional HTTP client used for every request made with
+	// this config (useful for proxies, custom TLS, or in-process fakes).
+	HTTPClient aws.HTTPClient
 }
 
 // NewConfig creates a new Config with the given region.
@@ -71,6 +74,11 @@ func (c *Config) SetSharedCredentialsFiles(files ...string) {
 	c.SharedCredentialsFiles = files
 }
 
+// SetHTTPClient sets the HTTP client used by SDK clients built from this config.
+func (c *Config) SetHTTPClient(client aws.HTTPClient) {
+	c.HTTPClient = client
+}
+
 // AddLoadOption appends a custom config.LoadOptions function.
 func (c *Config) AddLoadOption(opt func(*config.LoadOptions) error) {
 	c.LoadOptions = append(c.LoadOptions, opt)
@@ -84,7 +92,7 @@ func (c *Config) SetCredentialFile(filePath string) {
 }
 
 // LoadAWSConfig builds and returns an aws.Config using the configured options.
-// It applies region, profile, credentials, endpoint, and any additional load options.
+// It applies region, profile, credentials, HTTP client, and any additional load options.
 func (c *Config) LoadAWSConfig(ctx context.Context) (aws.Config, error) {
 	opts := make([]func(*config.LoadOptions) error, 0, len(c.LoadOptions)+5)
 
@@ -113,7 +121,18 @@ func (c *Config) LoadAWSConfig(ctx context.Context) (aws.Config, error) {
 	// Append any custom load options provided by the caller.
 	opts = append(opts, c.LoadOptions...)
 
-	return config.LoadDefaultConfig(ctx, opts...)
+	awsCfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return awsCfg, err
+	}
+
+	// The HTTP client is applied after loading rather than via
+	// config.WithHTTPClient: the SDK rejects non-buildable clients when
+	// AWS_CA_BUNDLE is set, and a caller-supplied client owns its own TLS.
+	if c.HTTPClient != nil {
+		awsCfg.HTTPClient = c.HTTPClient
+	}
+	return awsCfg, nil
 }
 
 // Manager is an item manager for Config instances, allowing named registration and retrieval.
@@ -2,6 +2,7 @@ package awscfg
 
 import (
 	"context"
+	"net/http"
 	"net/url"
 	"testing"
 )
@@ -64,6 +65,20 @@ func TestLoadAWSConfig(t *testing.T) {
 	}
 }
 
+func TestSetHTTPClient(t *testing.T) {
+	client := &http.Client{}
+	cfg := NewConfig("us-east-1")
+	cfg.SetHTTPClient(client)
+
+	awsCfg, err := cfg.LoadAWSConfig(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error loading config: %v", err)
+	}
+	if awsCfg.HTTPClient != client {
+		t.Errorf("expected HTTP client to be applied, got %T", awsCfg.HTTPClient)
+	}
+}
+
 func TestManagerRegisterAndGet(t *testing.T) {
 	cfg := NewConfig("ap-south-1")
 	Manager.Register("test-svc", cfg)
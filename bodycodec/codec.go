@@ -0,0 +1,144 @@
+package bodycodec
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"unicode/utf8"
+)
+
+// AttrEncoding is the message attribute that names the encoding of an
+// encoded body. Bodies sent as-is carry no such attribute.
+const AttrEncoding = "golly-body-encoding"
+
+// Built-in encodings.
+const (
+	// Raw sends the body unchanged. Bodies SQS cannot carry are rejected
+	// by the service. It is the default.
+	Raw = "raw"
+	// Base64 sends the body as standard base64.
+	Base64 = "base64"
+	// Auto sends bodies that are valid message text unchanged and base64
+	// encodes everything else.
+	Auto = "auto"
+)
+
+// ErrUnknownEncoding is returned for an encoding that is not registered in
+// this process.
+var ErrUnknownEncoding = errors.New("bodycodec: unknown encoding")
+
+// Codec turns arbitrary bytes into message text and back.
+type Codec interface {
+	// Name identifies the codec in the AttrEncoding attribute.
+	Name() string
+	// Encode returns the text to send for body.
+	Encode(body []byte) (string, error)
+	// Decode recovers the body from received text.
+	Decode(text string) ([]byte, error)
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{}
+)
+
+func init() {
+	Register(base64Codec{})
+}
+
+// Register makes a codec available by name. Registering a name again
+// replaces it. The names Raw and Auto are reserved, and like an empty name
+// they make Register panic.
+func Register(c Codec) {
+	switch name := c.Name(); name {
+	case "", Raw, Auto:
+		panic(fmt.Sprintf("bodycodec: codec name %q is reserved", name))
+	}
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[c.Name()] = c
+}
+
+// Get returns the codec registered under name.
+func Get(name string) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[name]
+	return c, ok
+}
+
+// Encode encodes body with the named encoding. It returns the text to send
+// and the value for the AttrEncoding attribute, which is empty when the
+// body is sent unchanged.
+func Encode(encoding string, body []byte) (text, marker string, err error) {
+	switch encoding {
+	case "", Raw:
+		return string(body), "", nil
+	case Auto:
+		if IsText(body) {
+			return string(body), "", nil
+		}
+		encoding = Base64
+	}
+	c, ok := Get(encoding)
+	if !ok {
+		return "", "", fmt.Errorf("%w %q", ErrUnknownEncoding, encoding)
+	}
+	text, err = c.Encode(body)
+	if err != nil {
+		return "", "", fmt.Errorf("bodycodec: %s encode: %w", encoding, err)
+	}
+	return text, c.Name(), nil
+}
+
+// Decode reverses Encode for a body received with the given AttrEncoding
+// value. An empty marker returns text unchanged.
+func Decode(marker, text string) ([]byte, error) {
+	if marker == "" || marker == Raw {
+		return []byte(text), nil
+	}
+	c, ok := Get(marker)
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownEncoding, marker)
+	}
+	body, err := c.Decode(text)
+	if err != nil {
+		return nil, fmt.Errorf("bodycodec: %s decode: %w", marker, err)
+	}
+	return body, nil
+}
+
+// IsText reports whether body can be sent unchanged: valid UTF-8 made only
+// of the characters SQS allows (#x9, #xA, #xD, #x20 to #xD7FF, #xE000 to
+// #xFFFD and #x10000 to #x10FFFF).
+func IsText(body []byte) bool {
+	for len(body) > 0 {
+		r, size := utf8.DecodeRune(body)
+		if r == utf8.RuneError && size <= 1 {
+			return false
+		}
+		switch {
+		case r == 0x9, r == 0xA, r == 0xD:
+		case r >= 0x20 && r <= 0xD7FF:
+		case r >= 0xE000 && r <= 0xFFFD:
+		case r >= 0x10000 && r <= 0x10FFFF:
+		default:
+			return false
+		}
+		body = body[size:]
+	}
+	return true
+}
+
+type base64Codec struct{}
+
+func (base64Codec) Name() string { return Base64 }
+
+func (base64Codec) Encode(body []byte) (string, error) {
+	return base64.StdEncoding.EncodeToString(body), nil
+}
+
+func (base64Codec) Decode(text string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(text)
+}
@@ -0,0 +1,93 @@
+package bodycodec
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+type hexCodec struct{}
+
+func (hexCodec) Name() string                       { return "hex" }
+func (hexCodec) Encode(body []byte) (string, error) { return hex.EncodeToString(body), nil }
+func (hexCodec) Decode(text string) ([]byte, error) { return hex.DecodeString(text) }
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	Register(hexCodec{})
+	binary := []byte{0x0a, 0x03, 0x00, 0xff, 0xfe, 'x'}
+	for _, tc := range []struct {
+		encoding   string
+		body       []byte
+		wantMarker string
+	}{
+		{Raw, []byte("plain text"), ""},
+		{"", []byte("plain text"), ""},
+		{Base64, binary, Base64},
+		{Auto, []byte("héllo\n"), ""},
+		{Auto, binary, Base64},
+		{"hex", binary, "hex"},
+	} {
+		text, marker, err := Encode(tc.encoding, tc.body)
+		if err != nil || marker != tc.wantMarker {
+			t.Errorf("Encode(%q) marker = %q, %v", tc.encoding, marker, err)
+			continue
+		}
+		if marker != "" && !IsText([]byte(text)) {
+			t.Errorf("Encode(%q) produced non-text %q", tc.encoding, text)
+		}
+		got, err := Decode(marker, text)
+		if err != nil || !bytes.Equal(got, tc.body) {
+			t.Errorf("Decode(%q) = %v, %v", marker, got, err)
+		}
+	}
+}
+
+func TestUnknownEncoding(t *testing.T) {
+	if _, _, err := Encode("zstd", []byte("x")); !errors.Is(err, ErrUnknownEncoding) {
+		t.Fatalf("Encode = %v", err)
+	}
+	if _, err := Decode("zstd", "x"); !errors.Is(err, ErrUnknownEncoding) {
+		t.Fatalf("Decode = %v", err)
+	}
+}
+
+func TestIsText(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want bool
+	}{
+		{"hello\tworld\r\n", true},
+		{"emoji 🎉 and \uFFFD", true},
+		{"nul \x00", false},
+		{"bell \x07", false},
+		{"\xff\xfe", false},
+		{"\uFFFE", false},
+	} {
+		if got := IsText([]byte(tc.in)); got != tc.want {
+			t.Errorf("IsText(%q) = %v, want %v", tc.in, got, tc.want)
+		}
+	}
+}
+
+type namedCodec string
+
+func (c namedCodec) Name() string                { return string(c) }
+func (namedCodec) Encode([]byte) (string, error) { return "", nil }
+func (namedCodec) Decode(string) ([]byte, error) { return nil, nil }
+
+func TestRegisterReservedName(t *testing.T) {
+	for _, name := range []string{Raw, Auto, ""} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Register(%q) did not panic", name)
+				}
+			}()
+			Register(namedCodec(name))
+		}()
+		if _, ok := Get(name); ok {
+			t.Errorf("codec %q was registered", name)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+// Package bodycodec carries binary message bodies through the text-only
+// bodies of SQS and SNS.
+//
+// SQS accepts only a restricted set of Unicode characters in a message body
+// and SNS expects UTF-8 text, so protobuf, Avro or other raw bytes must be
+// encoded before they are sent. The sqs and sns providers use this package
+// to encode bodies according to their BodyEncoding option and to record the
+// encoding in the AttrEncoding message attribute; the sqs provider decodes
+// received bodies carrying that attribute, including SNS notifications
+// delivered to a subscribed queue.
+//
+// Usage:
+//
+//	opts := messaging.NewOptionsBuilder().Add("BodyEncoding", bodycodec.Base64).Build()
+//	_ = mgr.Send(u, msg, opts...)
+//
+// Custom encodings are registered by name and must be registered in both
+// the sending and the receiving process:
+//
+//	bodycodec.Register(myBase85Codec{})
+package bodycodec
@@ -0,0 +1,205 @@
+// Package batchsend holds the resend loop and the errors of the batch
+// sends of the sqs and sns providers, which use the same entry-by-entry
+// protocol: a request of up to ten entries succeeds as a whole, and the
+// service reports each entry it rejected.
+package batchsend
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"oss.nandlabs.io/golly/messaging"
+)
+
+// Service names the service and action of a batch in its errors, e.g.
+// {"sqs", "send"}.
+type Service struct {
+	Name   string
+	Action string
+}
+
+// EntryError describes one message a batch could not deliver.
+type EntryError struct {
+	// Index is the position of the message in the slice passed to the
+	// batch send.
+	Index int
+	// Message is the message that was not delivered.
+	Message messaging.Message
+	// Code and Reason are the error code and message the service reported.
+	Code   string
+	Reason string
+	// SenderFault reports whether the service blamed the request rather
+	// than itself.
+	SenderFault bool
+	// Attempts is the number of times the entry was sent; zero when it was
+	// never sent because an earlier step of the batch failed.
+	Attempts int
+	// Err is the cause when the service never reported on the entry: the
+	// failed step or request that stopped the batch, the failed request of
+	// a resend, or the end of ctx while waiting to resend. Code is empty
+	// then.
+	Err error
+
+	service Service
+}
+
+func (e *EntryError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("%smessage %d failed after %d attempt(s): %s", e.service.prefix(), e.Index, e.Attempts, e.Reason)
+	}
+	return fmt.Sprintf("%smessage %d failed after %d attempt(s): %s: %s", e.service.prefix(), e.Index, e.Attempts, e.Code, e.Reason)
+}
+
+// Unwrap returns the cause of an entry the service never reported on.
+func (e *EntryError) Unwrap() error { return e.Err }
+
+// Error is returned when some messages of a batch were delivered and
+// others were not. Failed is in input order; every message not listed
+// there was delivered.
+type Error struct {
+	Failed []*EntryError
+	Total  int
+}
+
+func (e *Error) Error() string {
+	first := e.Failed[0]
+	action := "batch"
+	if first.service.Action != "" {
+		action = "batch " + first.service.Action
+	}
+	if first.Code == "" {
+		return fmt.Sprintf("%s%d of %d messages failed in %s, first: %s",
+			first.service.prefix(), len(e.Failed), e.Total, action, first.Reason)
+	}
+	return fmt.Sprintf("%s%d of %d messages failed in %s, first: %s: %s",
+		first.service.prefix(), len(e.Failed), e.Total, action, first.Code, first.Reason)
+}
+
+// Unwrap exposes the per-message errors to errors.Is and errors.As.
+func (e *Error) Unwrap() []error {
+	errs := make([]error, len(e.Failed))
+	for i, f := range e.Failed {
+		errs[i] = f
+	}
+	return errs
+}
+
+func (s Service) prefix() string {
+	if s.Name == "" {
+		return ""
+	}
+	return s.Name + ": "
+}
+
+// Unsent returns the error of a message the service never reported on,
+// because err stopped the batch after attempts sends of it.
+func (s Service) Unsent(index int, msg messaging.Message, attempts int, err error) *EntryError {
+	return &EntryError{Index: index, Message: msg, Reason: err.Error(), Attempts: attempts, Err: err, service: s}
+}
+
+// Rejection is an entry the service rejected in a batch response.
+type Rejection struct {
+	ID          string
+	Code        string
+	Reason      string
+	SenderFault bool
+}
+
+// Chunk is one batch request of up to ten entries of type E and the
+// messages they carry.
+type Chunk[E any] struct {
+	Service Service
+	// Offset is the position of the chunk in the caller's slice, and
+	// Messages[j] the message of Entries[j].
+	Offset   int
+	Messages []messaging.Message
+	Entries  []E
+	// ID returns the batch entry ID of an entry.
+	ID func(E) string
+	// Send paces and makes one request with the pending entries, and
+	// returns the entries the service rejected.
+	Send func(ctx context.Context, pending []E) ([]Rejection, error)
+	// Retriable reports whether a rejected entry is worth resending.
+	Retriable func(Rejection) bool
+	// Retries is the number of resends, Backoff the delay before the first
+	// one, which doubles on every further resend.
+	Retries int
+	Backoff time.Duration
+	// OnResend, when set, is called before waiting to resend n entries.
+	OnResend func(n int)
+}
+
+// Run sends the chunk, resending the entries rejected for a retriable
+// reason. It returns the number of times each entry was sent and the
+// error of each entry that was not delivered, nil for delivered ones. A
+// failure of the first request is returned as err; nothing was sent then.
+func (c *Chunk[E]) Run(ctx context.Context) (attempts []int, errs []*EntryError, err error) {
+	index := make(map[string]int, len(c.Entries))
+	for j, e := range c.Entries {
+		index[c.ID(e)] = j
+	}
+	attempts = make([]int, len(c.Entries))
+	errs = make([]*EntryError, len(c.Entries))
+	// unsent records cause as the error of the pending entries.
+	unsent := func(pending []E, attempt int, cause error) {
+		for _, e := range pending {
+			j := index[c.ID(e)]
+			attempts[j] = attempt
+			errs[j] = c.Service.Unsent(c.Offset+j, c.Messages[j], attempt, cause)
+		}
+	}
+	pending := c.Entries
+	backoff := c.Backoff
+
+	for attempt := 1; len(pending) > 0; attempt++ {
+		rejected, err := c.Send(ctx, pending)
+		if err != nil {
+			if attempt == 1 {
+				return nil, nil, err
+			}
+			unsent(pending, attempt, err)
+			break
+		}
+		for _, e := range pending {
+			attempts[index[c.ID(e)]] = attempt
+		}
+
+		var retry []E
+		for _, r := range rejected {
+			j, ok := index[r.ID]
+			if !ok {
+				continue
+			}
+			if c.Retriable(r) && attempt <= c.Retries {
+				retry = append(retry, c.Entries[j])
+				continue
+			}
+			errs[j] = &EntryError{
+				Index:       c.Offset + j,
+				Message:     c.Messages[j],
+				Code:        r.Code,
+				Reason:      r.Reason,
+				SenderFault: r.SenderFault,
+				Attempts:    attempt,
+				service:     c.Service,
+			}
+		}
+		pending = retry
+		if len(pending) == 0 {
+			break
+		}
+
+		if c.OnResend != nil {
+			c.OnResend(len(pending))
+		}
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			unsent(pending, attempt, ctx.Err())
+			pending = nil
+		}
+	}
+	return attempts, errs, nil
+}
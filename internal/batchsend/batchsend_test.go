@@ -0,0 +1,60 @@
+package batchsend
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"oss.nandlabs.io/golly/messaging"
+)
+
+func TestChunk_Run(t *testing.T) {
+	errDown := errors.New("connection reset")
+	calls := 0
+	msgs := []messaging.Message{nil, nil, nil}
+	c := &Chunk[string]{
+		Service:   Service{Name: "svc", Action: "send"},
+		Offset:    10,
+		Messages:  msgs,
+		Entries:   []string{"a", "b", "c"},
+		ID:        func(e string) string { return e },
+		Retriable: func(r Rejection) bool { return r.Code == "Throttled" },
+		Retries:   2,
+		Send: func(_ context.Context, pending []string) ([]Rejection, error) {
+			calls++
+			switch calls {
+			case 1:
+				// b is rejected for good, c is resent.
+				return []Rejection{{ID: "b", Code: "Invalid", Reason: "bad", SenderFault: true}, {ID: "c", Code: "Throttled"}}, nil
+			default:
+				return nil, errDown
+			}
+		},
+	}
+	attempts, errs, err := c.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if want := []int{1, 1, 2}; attempts[0] != want[0] || attempts[1] != want[1] || attempts[2] != want[2] {
+		t.Fatalf("attempts = %v, want %v", attempts, want)
+	}
+	if errs[0] != nil {
+		t.Fatalf("delivered entry has error %v", errs[0])
+	}
+	if e := errs[1]; e.Index != 11 || e.Code != "Invalid" || e.Err != nil ||
+		e.Error() != "svc: message 11 failed after 1 attempt(s): Invalid: bad" {
+		t.Fatalf("rejected entry = %+v, %q", e, e.Error())
+	}
+	if e := errs[2]; e.Index != 12 || !errors.Is(e, errDown) || e.Attempts != 2 {
+		t.Fatalf("resent entry = %+v", e)
+	}
+	batchErr := &Error{Failed: errs[1:], Total: 13}
+	if got, want := batchErr.Error(), "svc: 2 of 13 messages failed in batch send, first: Invalid: bad"; got != want {
+		t.Fatalf("Error() = %q, want %q", got, want)
+	}
+
+	// A failure of the first request is returned as is.
+	if _, _, err := c.Run(context.Background()); !errors.Is(err, errDown) {
+		t.Fatalf("Run = %v, want the request error", err)
+	}
+}
@@ -0,0 +1,23 @@
+// Package outbox implements the transactional outbox pattern on DynamoDB
+// for the sns and sqs providers: a message is published if and only if
+// the business data written alongside it was committed.
+//
+// Producers add an Entry to the outbox table inside the same
+// TransactWriteItems call as their business data, with Outbox.Put or
+// Outbox.Transact. A Relay reads the pending entries, publishes them
+// through the messaging manager (and so the sns and sqs providers) and
+// deletes them once published.
+//
+// The relay polls the table's status index, oldest entry first. A
+// ChangeFeed, typically a reader of the table's DynamoDB stream, lets it
+// publish new entries without a message group without waiting for the
+// next poll; the poll still publishes grouped entries, in order, and picks
+// up anything the feed missed. Delivery is at least once. The
+// IdempotencyKey of an entry is sent as the MessageDeduplicationId on FIFO
+// topics and queues, which drop redeliveries within their deduplication
+// window, and as the HeaderIdempotencyKey header everywhere.
+//
+// Several relay instances can run for availability: with
+// RelayOptions.LockTable set, they elect a leader with a
+// chrono/dynamolock elector and only the leader publishes.
+package outbox
@@ -0,0 +1,248 @@
+package outbox
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Attribute names of the outbox item schema. The table's partition key
+// MUST be named "id" (string). See README.md for the full schema.
+const (
+	attrID          = "id"
+	attrDestination = "destination"
+	attrBody        = "body"
+	attrHeaders     = "headers"
+	attrGroupID     = "group_id"
+	attrDedupID     = "dedup_id"
+	attrCreatedAt   = "created_at"
+	attrStatus      = "status"
+	attrAttempts    = "attempts"
+	attrLastError   = "last_error"
+
+	// GSIStatusIndex is the name of the Global Secondary Index the relay
+	// polls. Create it with partition key status (string) and sort key
+	// created_at (number), projecting all attributes.
+	GSIStatusIndex = "status-index"
+
+	// StatusPending marks entries waiting to be published, and
+	// StatusFailed entries the relay gave up on after MaxAttempts.
+	StatusPending = "pending"
+	StatusFailed  = "failed"
+
+	// maxTransactItems is the DynamoDB limit of items per
+	// TransactWriteItems call.
+	maxTransactItems = 100
+)
+
+// Entry is one message waiting in the outbox.
+type Entry struct {
+	// ID identifies the entry in the table. Put generates one when empty.
+	ID string
+	// Destination is the sns:// or sqs:// URL the message is published
+	// to, as accepted by the sns and sqs providers.
+	Destination string
+	// Body is the message body.
+	Body []byte
+	// Headers become string headers of the published message.
+	Headers map[string]string
+	// GroupID is the MessageGroupId of the message, required for FIFO
+	// topics and queues.
+	GroupID string
+	// IdempotencyKey identifies the message to consumers. It is sent as
+	// the MessageDeduplicationId on FIFO destinations and as the
+	// HeaderIdempotencyKey header everywhere. Defaults to ID.
+	IdempotencyKey string
+	// CreatedAt is when the entry was written; the relay publishes in
+	// this order. Put sets it when zero.
+	CreatedAt time.Time
+
+	// Status, Attempts and LastError are set on entries read back from
+	// the table: the entry's state, the number of failed publishes and
+	// the error of the last one.
+	Status    string
+	Attempts  int
+	LastError string
+}
+
+// dedupID returns the idempotency key of e, or its ID.
+func (e *Entry) dedupID() string {
+	if e.IdempotencyKey != "" {
+		return e.IdempotencyKey
+	}
+	return e.ID
+}
+
+// TransactWriter is the subset of the DynamoDB client Transact uses. The
+// concrete *dynamodb.Client satisfies it.
+type TransactWriter interface {
+	TransactWriteItems(ctx context.Context, in *dynamodb.TransactWriteItemsInput, opts ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error)
+}
+
+// Outbox writes entries into an outbox table as part of the caller's
+// DynamoDB transactions, so a message is published if and only if the
+// business data written alongside it was committed.
+type Outbox struct {
+	table string
+	now   func() time.Time
+}
+
+// New returns an Outbox writing to table. The caller is responsible for
+// creating the table with the schema documented in README.md.
+func New(table string) *Outbox {
+	if table == "" {
+		panic("outbox: empty table name")
+	}
+	return &Outbox{table: table, now: time.Now}
+}
+
+// Put returns the TransactWriteItem that adds e to the outbox. Append it
+// to the TransactItems of the transaction that writes the business data:
+//
+//	put, err := ob.Put(&outbox.Entry{Destination: "sns://orders", Body: payload})
+//	_, err = client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+//		TransactItems: []ddbtypes.TransactWriteItem{orderPut, put},
+//	})
+//
+// Put fills in e.ID and e.CreatedAt when they are empty. The write fails
+// the transaction if an entry with the same ID is already in the outbox.
+func (o *Outbox) Put(e *Entry) (ddbtypes.TransactWriteItem, error) {
+	if e == nil {
+		return ddbtypes.TransactWriteItem{}, errors.New("outbox: nil entry")
+	}
+	u, err := url.Parse(e.Destination)
+	if err != nil || u.Scheme == "" {
+		return ddbtypes.TransactWriteItem{}, fmt.Errorf("outbox: destination %q is not a messaging URL", e.Destination)
+	}
+	if e.ID == "" {
+		if e.ID, err = newID(); err != nil {
+			return ddbtypes.TransactWriteItem{}, err
+		}
+	}
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = o.now()
+	}
+	e.Status = StatusPending
+	return ddbtypes.TransactWriteItem{
+		Put: &ddbtypes.Put{
+			TableName:           aws.String(o.table),
+			Item:                encodeEntry(e),
+			ConditionExpression: aws.String("attribute_not_exists(#id)"),
+			ExpressionAttributeNames: map[string]string{
+				"#id": attrID,
+			},
+		},
+	}, nil
+}
+
+// Transact runs one TransactWriteItems call with items, the caller's own
+// writes, followed by a Put of every entry. DynamoDB allows at most 100
+// items per transaction.
+func (o *Outbox) Transact(ctx context.Context, client TransactWriter, items []ddbtypes.TransactWriteItem, entries ...*Entry) error {
+	if n := len(items) + len(entries); n > maxTransactItems {
+		return fmt.Errorf("outbox: %d items exceed the limit of %d per transaction", n, maxTransactItems)
+	}
+	all := make([]ddbtypes.TransactWriteItem, 0, len(items)+len(entries))
+	all = append(all, items...)
+	for _, e := range entries {
+		put, err := o.Put(e)
+		if err != nil {
+			return err
+		}
+		all = append(all, put)
+	}
+	if _, err := client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: all}); err != nil {
+		return fmt.Errorf("outbox: transaction failed: %w", err)
+	}
+	return nil
+}
+
+// newID returns a random 128-bit hex entry ID.
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("outbox: failed to generate entry id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// encodeEntry marshals an entry into a DynamoDB item. created_at is stored
+// in Unix nanoseconds so entries written in the same millisecond keep
+// their order.
+func encodeEntry(e *Entry) map[string]ddbtypes.AttributeValue {
+	item := map[string]ddbtypes.AttributeValue{
+		attrID:          &ddbtypes.AttributeValueMemberS{Value: e.ID},
+		attrDestination: &ddbtypes.AttributeValueMemberS{Value: e.Destination},
+		attrBody:        &ddbtypes.AttributeValueMemberB{Value: e.Body},
+		attrDedupID:     &ddbtypes.AttributeValueMemberS{Value: e.dedupID()},
+		attrCreatedAt:   &ddbtypes.AttributeValueMemberN{Value: strconv.FormatInt(e.CreatedAt.UnixNano(), 10)},
+		attrStatus:      &ddbtypes.AttributeValueMemberS{Value: e.Status},
+	}
+	if e.GroupID != "" {
+		item[attrGroupID] = &ddbtypes.AttributeValueMemberS{Value: e.GroupID}
+	}
+	if len(e.Headers) > 0 {
+		headers := make(map[string]ddbtypes.AttributeValue, len(e.Headers))
+		for k, v := range e.Headers {
+			headers[k] = &ddbtypes.AttributeValueMemberS{Value: v}
+		}
+		item[attrHeaders] = &ddbtypes.AttributeValueMemberM{Value: headers}
+	}
+	return item
+}
+
+// decodeEntry unmarshals an outbox item. It fails on items without an id,
+// a destination or a created_at, which the relay cannot publish.
+func decodeEntry(item map[string]ddbtypes.AttributeValue) (*Entry, error) {
+	e := &Entry{
+		ID:             stringAttr(item, attrID),
+		Destination:    stringAttr(item, attrDestination),
+		GroupID:        stringAttr(item, attrGroupID),
+		IdempotencyKey: stringAttr(item, attrDedupID),
+		Status:         stringAttr(item, attrStatus),
+		LastError:      stringAttr(item, attrLastError),
+	}
+	if e.ID == "" || e.Destination == "" {
+		return nil, errors.New("outbox: item without id or destination")
+	}
+	if b, ok := item[attrBody].(*ddbtypes.AttributeValueMemberB); ok {
+		e.Body = b.Value
+	}
+	n, ok := item[attrCreatedAt].(*ddbtypes.AttributeValueMemberN)
+	if !ok {
+		return nil, fmt.Errorf("outbox: item %s without created_at", e.ID)
+	}
+	nanos, err := strconv.ParseInt(n.Value, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("outbox: item %s has an invalid created_at: %w", e.ID, err)
+	}
+	e.CreatedAt = time.Unix(0, nanos)
+	if a, ok := item[attrAttempts].(*ddbtypes.AttributeValueMemberN); ok {
+		e.Attempts, _ = strconv.Atoi(a.Value)
+	}
+	if m, ok := item[attrHeaders].(*ddbtypes.AttributeValueMemberM); ok {
+		e.Headers = make(map[string]string, len(m.Value))
+		for k, v := range m.Value {
+			if s, ok := v.(*ddbtypes.AttributeValueMemberS); ok {
+				e.Headers[k] = s.Value
+			}
+		}
+	}
+	return e, nil
+}
+
+func stringAttr(item map[string]ddbtypes.AttributeValue, name string) string {
+	if s, ok := item[name].(*ddbtypes.AttributeValueMemberS); ok {
+		return s.Value
+	}
+	return ""
+}
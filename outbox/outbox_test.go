@@ -0,0 +1,205 @@
+package outbox
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// fakeTable is an in-memory outbox table implementing the DynamoDB calls
+// of the outbox and the relay, including the status index.
+type fakeTable struct {
+	mu    sync.Mutex
+	items map[string]map[string]ddbtypes.AttributeValue
+	other []ddbtypes.TransactWriteItem // non-outbox items of transactions
+	pages []int                        // items returned per Query
+}
+
+func newFakeTable() *fakeTable {
+	return &fakeTable{items: map[string]map[string]ddbtypes.AttributeValue{}}
+}
+
+func (f *fakeTable) TransactWriteItems(_ context.Context, in *dynamodb.TransactWriteItemsInput, _ ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, it := range in.TransactItems {
+		if it.Put != nil && *it.Put.TableName == "outbox" {
+			if _, ok := f.items[stringAttr(it.Put.Item, attrID)]; ok {
+				return nil, &ddbtypes.TransactionCanceledException{Message: strPtr("ConditionalCheckFailed")}
+			}
+		}
+	}
+	for _, it := range in.TransactItems {
+		if it.Put != nil && *it.Put.TableName == "outbox" {
+			f.items[stringAttr(it.Put.Item, attrID)] = it.Put.Item
+			continue
+		}
+		f.other = append(f.other, it)
+	}
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+func (f *fakeTable) Query(_ context.Context, in *dynamodb.QueryInput, _ ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	status := in.ExpressionAttributeValues[":pending"].(*ddbtypes.AttributeValueMemberS).Value
+	// The relay's lookup of older entries of a group adds
+	// created_at < :c, destination = :d and group_id = :g.
+	before := int64(-1)
+	if c, ok := in.ExpressionAttributeValues[":c"].(*ddbtypes.AttributeValueMemberN); ok {
+		before, _ = strconv.ParseInt(c.Value, 10, 64)
+	}
+	var matched []map[string]ddbtypes.AttributeValue
+	for _, item := range f.items {
+		if stringAttr(item, attrStatus) != status || (before >= 0 && createdAt(item) >= before) {
+			continue
+		}
+		if d, ok := in.ExpressionAttributeValues[":d"].(*ddbtypes.AttributeValueMemberS); ok && stringAttr(item, attrDestination) != d.Value {
+			continue
+		}
+		if g, ok := in.ExpressionAttributeValues[":g"].(*ddbtypes.AttributeValueMemberS); ok && stringAttr(item, attrGroupID) != g.Value {
+			continue
+		}
+		matched = append(matched, item)
+	}
+	slices.SortFunc(matched, func(a, b map[string]ddbtypes.AttributeValue) int {
+		return cmp.Compare(createdAt(a), createdAt(b))
+	})
+	if in.ExclusiveStartKey != nil {
+		// Like DynamoDB, resume after the position of the start key,
+		// whether or not its item still exists.
+		last := createdAt(in.ExclusiveStartKey)
+		matched = slices.DeleteFunc(matched, func(item map[string]ddbtypes.AttributeValue) bool { return createdAt(item) <= last })
+	}
+	out := &dynamodb.QueryOutput{}
+	if n := int(*in.Limit); len(matched) > n {
+		matched = matched[:n]
+		out.LastEvaluatedKey = map[string]ddbtypes.AttributeValue{
+			attrID:        matched[n-1][attrID],
+			attrCreatedAt: matched[n-1][attrCreatedAt],
+		}
+	}
+	out.Items = matched
+	f.pages = append(f.pages, len(matched))
+	return out, nil
+}
+
+func (f *fakeTable) UpdateItem(_ context.Context, in *dynamodb.UpdateItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	item, ok := f.items[stringAttr(in.Key, attrID)]
+	if !ok {
+		return nil, &ddbtypes.ConditionalCheckFailedException{}
+	}
+	// The relay only issues SET #a = :a, #le = :le[, #s = :failed].
+	for name, value := range map[string]string{"#a": ":a", "#le": ":le", "#s": ":failed"} {
+		if v, ok := in.ExpressionAttributeValues[value]; ok {
+			item[in.ExpressionAttributeNames[name]] = v
+		}
+	}
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func (f *fakeTable) DeleteItem(_ context.Context, in *dynamodb.DeleteItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.items, stringAttr(in.Key, attrID))
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func (f *fakeTable) entry(t *testing.T, id string) *Entry {
+	t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	item, ok := f.items[id]
+	if !ok {
+		return nil
+	}
+	e, err := decodeEntry(item)
+	if err != nil {
+		t.Fatalf("decodeEntry(%s): %v", id, err)
+	}
+	return e
+}
+
+func (f *fakeTable) len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.items)
+}
+
+func createdAt(item map[string]ddbtypes.AttributeValue) int64 {
+	n, _ := strconv.ParseInt(item[attrCreatedAt].(*ddbtypes.AttributeValueMemberN).Value, 10, 64)
+	return n
+}
+
+func strPtr(s string) *string { return &s }
+
+func TestOutbox_PutAndTransact(t *testing.T) {
+	ob := New("outbox")
+	clock := time.Unix(1700000000, 0)
+	ob.now = func() time.Time { return clock }
+
+	e := &Entry{
+		Destination:    "sqs://ledger.fifo",
+		Body:           []byte{0xff, 0x00, 'x'},
+		Headers:        map[string]string{"type": "order.created"},
+		GroupID:        "order-1",
+		IdempotencyKey: "order-1-created",
+	}
+	put, err := ob.Put(e)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if len(e.ID) != 32 || !e.CreatedAt.Equal(clock) {
+		t.Fatalf("Put left ID %q and CreatedAt %v", e.ID, e.CreatedAt)
+	}
+	if *put.Put.TableName != "outbox" || *put.Put.ConditionExpression != "attribute_not_exists(#id)" {
+		t.Fatalf("unexpected put %+v", put.Put)
+	}
+	got, err := decodeEntry(put.Put.Item)
+	if err != nil {
+		t.Fatalf("decodeEntry: %v", err)
+	}
+	if got.ID != e.ID || got.Destination != e.Destination || string(got.Body) != string(e.Body) ||
+		got.Headers["type"] != "order.created" || got.GroupID != "order-1" ||
+		got.IdempotencyKey != "order-1-created" || !got.CreatedAt.Equal(clock) || got.Status != StatusPending {
+		t.Fatalf("round trip = %+v, want %+v", got, e)
+	}
+
+	if _, err := ob.Put(&Entry{Destination: "orders"}); err == nil {
+		t.Fatalf("expected an error for a destination without a scheme")
+	}
+
+	table := newFakeTable()
+	order := ddbtypes.TransactWriteItem{Put: &ddbtypes.Put{TableName: strPtr("orders")}}
+	first := &Entry{Destination: "sns://orders", Body: []byte("1")}
+	ctx := context.Background()
+	if err := ob.Transact(ctx, table, []ddbtypes.TransactWriteItem{order}, first); err != nil {
+		t.Fatalf("Transact: %v", err)
+	}
+	if table.len() != 1 || len(table.other) != 1 {
+		t.Fatalf("transaction wrote %d entries and %d other items", table.len(), len(table.other))
+	}
+	if got := table.entry(t, first.ID); got == nil || got.IdempotencyKey != first.ID {
+		t.Fatalf("stored entry = %+v, want the ID as idempotency key", got)
+	}
+
+	// An entry that is already in the outbox cancels the whole transaction.
+	err = ob.Transact(ctx, table, []ddbtypes.TransactWriteItem{order}, first)
+	var canceled *ddbtypes.TransactionCanceledException
+	if !errors.As(err, &canceled) || len(table.other) != 1 {
+		t.Fatalf("duplicate entry: err %v, %d other items", err, len(table.other))
+	}
+	if err := ob.Transact(ctx, table, make([]ddbtypes.TransactWriteItem, 100), first); err == nil {
+		t.Fatalf("expected an error for 101 transaction items")
+	}
+}
@@ -0,0 +1,5 @@
+package outbox
+
+import "oss.nandlabs.io/golly/l3"
+
+var logger = l3.Get()
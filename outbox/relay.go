@@ -0,0 +1,493 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"oss.nandlabs.io/golly-aws/chrono/dynamolock"
+	"oss.nandlabs.io/golly/messaging"
+)
+
+const (
+	// HeaderIdempotencyKey is the header carrying Entry.IdempotencyKey on
+	// every published message, for consumers of standard topics and
+	// queues to deduplicate redeliveries.
+	HeaderIdempotencyKey = "outbox-idempotency-key"
+
+	// Option keys of the sns and sqs providers the relay sets.
+	optMessageGroupId         = "MessageGroupId"
+	optMessageDeduplicationId = "MessageDeduplicationId"
+
+	defaultPollInterval = 5 * time.Second
+	defaultBatchSize    = 100
+	defaultMaxAttempts  = 10
+	defaultLockLease    = 15 * time.Second
+
+	// recentSize is the number of published entry IDs the relay
+	// remembers, so an entry seen by both the change feed and a poll is
+	// published once.
+	recentSize = 4096
+)
+
+// Event names of change feed records, as used by DynamoDB Streams.
+const (
+	EventInsert = "INSERT"
+	EventModify = "MODIFY"
+	EventRemove = "REMOVE"
+)
+
+// ddbAPI is the subset of the DynamoDB client used by the relay.
+// Extracted so tests can substitute an in-memory fake.
+type ddbAPI interface {
+	Query(ctx context.Context, in *dynamodb.QueryInput, opts ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	UpdateItem(ctx context.Context, in *dynamodb.UpdateItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	DeleteItem(ctx context.Context, in *dynamodb.DeleteItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+}
+
+// Publisher is what the relay publishes entries through. The messaging
+// manager returned by messaging.GetManager satisfies it, as do the sns and
+// sqs providers; a Publisher that also implements messaging.ProducerCtx is
+// sent to with the relay's context.
+type Publisher interface {
+	NewMessage(scheme string, options ...messaging.Option) (messaging.Message, error)
+	Send(u *url.URL, msg messaging.Message, options ...messaging.Option) error
+}
+
+// LeaderElector decides which of several relay instances publishes.
+// *dynamolock.Elector satisfies it.
+type LeaderElector interface {
+	IsLeader(ctx context.Context) bool
+}
+
+// lockElector is a LeaderElector the relay starts and resigns itself;
+// *dynamolock.Elector satisfies it.
+type lockElector interface {
+	LeaderElector
+	Start(ctx context.Context) error
+	Resign(ctx context.Context) error
+}
+
+// Record is one change of the outbox table in the shape of a DynamoDB
+// Streams record: EventName is EventInsert, EventModify or EventRemove
+// and NewImage the item after the change (absent for removals). The
+// stream view type must include new images.
+type Record struct {
+	EventName string
+	NewImage  map[string]ddbtypes.AttributeValue
+}
+
+// ChangeFeed delivers the changes of the outbox table, typically read
+// from its DynamoDB stream, so entries are published without waiting for
+// the next poll.
+//
+// Next blocks until records are available or ctx ends. The relay calls
+// Next again only after it handled the previous records, so a feed may
+// advance its checkpoint then. Records the relay missed are still
+// published by the polling fallback.
+type ChangeFeed interface {
+	Next(ctx context.Context) ([]Record, error)
+}
+
+// RelayOptions configures a Relay.
+type RelayOptions struct {
+	// Table is the outbox table. It MUST have the GSIStatusIndex index.
+	Table string
+	// Publisher publishes the entries. Default: messaging.GetManager().
+	Publisher Publisher
+	// Feed is an optional change feed of the table. Without one the relay
+	// only polls.
+	Feed ChangeFeed
+	// PollInterval is the time between polls of the status index.
+	// Default: 5s.
+	PollInterval time.Duration
+	// BatchSize is the number of entries read per Query page.
+	// Default: 100.
+	BatchSize int32
+	// MaxAttempts is the number of failed publishes after which an entry
+	// is marked StatusFailed and no longer retried. Default: 10.
+	MaxAttempts int
+	// Elector, when set, lets only its leader publish. The caller starts
+	// and stops it.
+	Elector LeaderElector
+	// LockTable, when set and Elector is not, makes the relay elect a
+	// leader among its instances with a chrono/dynamolock elector on that
+	// table, keyed "outbox:<Table>". Each Run starts one and resigns it
+	// on return.
+	LockTable string
+	// LockLease is the lease of that elector. Default: 15s.
+	LockLease time.Duration
+	// Owner identifies this instance to the elector. Default: hostname
+	// and process ID.
+	Owner string
+}
+
+// Relay publishes outbox entries through the sns and sqs providers and
+// deletes them once published. Delivery is at least once: an entry is
+// deleted only after its publish succeeded, so a crash in between
+// publishes it again. FIFO destinations deduplicate such redeliveries by
+// the entry's IdempotencyKey within their five-minute deduplication
+// window.
+//
+// Entries are published in CreatedAt order. When an entry of a message
+// group fails, later entries of that group wait until it is published or
+// marked StatusFailed. The change feed only publishes entries without a
+// GroupID; the poll publishes the grouped ones.
+type Relay struct {
+	api     ddbAPI
+	opts    RelayOptions
+	pub     Publisher
+	elector LeaderElector
+	newLock func() lockElector
+
+	// mu serialises passes, which keeps the order of entries within
+	// their groups and guards recent.
+	mu     sync.Mutex
+	recent *recentSet
+}
+
+// NewRelay returns a relay reading the outbox with client. The relay
+// does not own the client — the caller manages its lifecycle.
+func NewRelay(client *dynamodb.Client, opts RelayOptions) (*Relay, error) {
+	r, err := newRelayWithBackend(client, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Elector == nil && opts.LockTable != "" {
+		r.newLock = func() lockElector {
+			return dynamolock.New(client, dynamolock.Options{
+				Table: opts.LockTable,
+				Key:   "outbox:" + opts.Table,
+				Owner: r.opts.Owner,
+				Lease: r.opts.LockLease,
+			})
+		}
+	}
+	return r, nil
+}
+
+// newRelayWithBackend is the unit-test constructor; production callers
+// should use NewRelay.
+func newRelayWithBackend(api ddbAPI, opts RelayOptions) (*Relay, error) {
+	if opts.Table == "" {
+		return nil, errors.New("outbox: Table required")
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaultPollInterval
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBatchSize
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaultMaxAttempts
+	}
+	if opts.LockLease <= 0 {
+		opts.LockLease = defaultLockLease
+	}
+	if opts.Owner == "" {
+		host, _ := os.Hostname()
+		opts.Owner = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	r := &Relay{api: api, opts: opts, pub: opts.Publisher, elector: opts.Elector, recent: newRecentSet(recentSize)}
+	if r.pub == nil {
+		r.pub = messaging.GetManager()
+	}
+	return r, nil
+}
+
+// Run relays entries until ctx is done, then returns ctx.Err(). While
+// this instance is the leader it polls the status index every
+// PollInterval and, between polls, handles the records of the change
+// feed. With LockTable set, each Run starts an elector of its own and
+// resigns it on return, so Run can be called again after it returned.
+func (r *Relay) Run(ctx context.Context) error {
+	elector := r.elector
+	if r.newLock != nil {
+		lock := r.newLock()
+		if err := lock.Start(ctx); err != nil {
+			return fmt.Errorf("outbox: failed to start leader election: %w", err)
+		}
+		defer func() { _ = lock.Resign(context.Background()) }()
+		elector = lock
+	}
+	var lastPoll time.Time
+	for ctx.Err() == nil {
+		if !isLeader(ctx, elector) {
+			lastPoll = time.Time{}
+			sleep(ctx, r.opts.PollInterval)
+			continue
+		}
+		if time.Since(lastPoll) >= r.opts.PollInterval {
+			lastPoll = time.Now()
+			if _, err := r.Poll(ctx); err != nil && ctx.Err() == nil {
+				logger.WarnF("outbox: poll of %s failed: %v", r.opts.Table, err)
+			}
+		}
+		untilPoll := time.Until(lastPoll.Add(r.opts.PollInterval))
+		if r.opts.Feed == nil {
+			sleep(ctx, untilPoll)
+			continue
+		}
+		feedCtx, cancel := context.WithTimeout(ctx, untilPoll)
+		records, err := r.opts.Feed.Next(feedCtx)
+		cancel()
+		if err != nil {
+			if ctx.Err() == nil && !errors.Is(err, context.DeadlineExceeded) {
+				logger.WarnF("outbox: change feed of %s failed: %v", r.opts.Table, err)
+				sleep(ctx, time.Until(lastPoll.Add(r.opts.PollInterval)))
+			}
+			continue
+		}
+		if _, err := r.Handle(ctx, records); err != nil && ctx.Err() == nil {
+			logger.WarnF("outbox: handling change feed records of %s failed: %v", r.opts.Table, err)
+		}
+	}
+	return ctx.Err()
+}
+
+// isLeader reports whether this instance may publish; always true
+// without an elector.
+func isLeader(ctx context.Context, elector LeaderElector) bool {
+	return elector == nil || elector.IsLeader(ctx)
+}
+
+// Poll publishes every pending entry of the status index, oldest first,
+// and returns the number published. It does not consult the elector, so
+// it can also run from a scheduled job.
+func (r *Relay) Poll(ctx context.Context) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	blocked := map[string]bool{}
+	published := 0
+	var start map[string]ddbtypes.AttributeValue
+	for {
+		out, err := r.api.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(r.opts.Table),
+			IndexName:              aws.String(GSIStatusIndex),
+			KeyConditionExpression: aws.String("#s = :pending"),
+			ExpressionAttributeNames: map[string]string{
+				"#s": attrStatus,
+			},
+			ExpressionAttributeValues: map[string]ddbtypes.AttributeValue{
+				":pending": &ddbtypes.AttributeValueMemberS{Value: StatusPending},
+			},
+			Limit:             aws.Int32(r.opts.BatchSize),
+			ExclusiveStartKey: start,
+		})
+		if err != nil {
+			return published, fmt.Errorf("outbox: query of pending entries failed: %w", err)
+		}
+		for _, item := range out.Items {
+			if ctx.Err() != nil {
+				return published, ctx.Err()
+			}
+			e, err := decodeEntry(item)
+			if err != nil {
+				logger.WarnF("outbox: skipping entry of %s: %v", r.opts.Table, err)
+				continue
+			}
+			if r.relay(ctx, e, blocked) {
+				published++
+			}
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			return published, nil
+		}
+		start = out.LastEvaluatedKey
+	}
+}
+
+// Handle publishes the pending entries inserted by records, in order, and
+// returns the number published. Other records, and entries with a
+// GroupID, which the poll publishes in order, are ignored. Like Poll it
+// does not consult the elector, so it can also serve as the body of a
+// stream-triggered function.
+func (r *Relay) Handle(ctx context.Context, records []Record) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	blocked := map[string]bool{}
+	published := 0
+	for _, rec := range records {
+		if ctx.Err() != nil {
+			return published, ctx.Err()
+		}
+		if rec.EventName != EventInsert || len(rec.NewImage) == 0 {
+			continue
+		}
+		e, err := decodeEntry(rec.NewImage)
+		if err != nil {
+			logger.WarnF("outbox: skipping record of %s: %v", r.opts.Table, err)
+			continue
+		}
+		if e.Status != StatusPending {
+			continue
+		}
+		if e.GroupID != "" {
+			// Stream records can arrive out of order and the status
+			// index lags behind, so only the poll knows which entries
+			// of the group come first.
+			continue
+		}
+		if r.relay(ctx, e, blocked) {
+			published++
+		}
+	}
+	return published, nil
+}
+
+// relay publishes e and deletes it from the table, unless it was
+// published recently or an earlier entry of its group failed in this
+// pass. It reports whether e was published.
+func (r *Relay) relay(ctx context.Context, e *Entry, blocked map[string]bool) bool {
+	if (e.GroupID != "" && blocked[groupKey(e)]) || r.recent.has(e.ID) {
+		return false
+	}
+	if err := r.publish(ctx, e); err != nil {
+		if e.GroupID != "" {
+			blocked[groupKey(e)] = true
+		}
+		r.failed(ctx, e, err)
+		return false
+	}
+	r.recent.add(e.ID)
+	_, err := r.api.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(r.opts.Table),
+		Key: map[string]ddbtypes.AttributeValue{
+			attrID: &ddbtypes.AttributeValueMemberS{Value: e.ID},
+		},
+	})
+	if err != nil {
+		// The entry stays pending; the recent set keeps this instance
+		// from publishing it again, another leader may.
+		logger.WarnF("outbox: failed to delete published entry %s of %s: %v", e.ID, r.opts.Table, err)
+	}
+	return true
+}
+
+// groupKey identifies the message group of e within its destination.
+func groupKey(e *Entry) string {
+	return e.Destination + "\x00" + e.GroupID
+}
+
+// publish sends e to its destination.
+func (r *Relay) publish(ctx context.Context, e *Entry) error {
+	u, err := url.Parse(e.Destination)
+	if err != nil {
+		return fmt.Errorf("outbox: invalid destination %q: %w", e.Destination, err)
+	}
+	msg, err := r.pub.NewMessage(u.Scheme)
+	if err != nil {
+		return fmt.Errorf("outbox: failed to create %s message: %w", u.Scheme, err)
+	}
+	if _, err := msg.SetBodyBytes(e.Body); err != nil {
+		return fmt.Errorf("outbox: failed to set message body: %w", err)
+	}
+	for k, v := range e.Headers {
+		msg.SetStrHeader(k, v)
+	}
+	msg.SetStrHeader(HeaderIdempotencyKey, e.dedupID())
+
+	opts := messaging.NewOptionsBuilder()
+	if e.GroupID != "" {
+		opts.Add(optMessageGroupId, e.GroupID)
+	}
+	if isFIFO(u) {
+		opts.Add(optMessageDeduplicationId, e.dedupID())
+	}
+	if pc, ok := r.pub.(messaging.ProducerCtx); ok {
+		return pc.SendCtx(ctx, u, msg, opts.Build()...)
+	}
+	return r.pub.Send(u, msg, opts.Build()...)
+}
+
+// failed records a failed publish of e, marking it StatusFailed once it
+// reached MaxAttempts. The condition keeps an entry deleted meanwhile
+// from being recreated.
+func (r *Relay) failed(ctx context.Context, e *Entry, cause error) {
+	attempts := e.Attempts + 1
+	update := "SET #a = :a, #le = :le"
+	values := map[string]ddbtypes.AttributeValue{
+		":a":  &ddbtypes.AttributeValueMemberN{Value: strconv.Itoa(attempts)},
+		":le": &ddbtypes.AttributeValueMemberS{Value: cause.Error()},
+	}
+	names := map[string]string{"#id": attrID, "#a": attrAttempts, "#le": attrLastError}
+	if attempts >= r.opts.MaxAttempts {
+		update += ", #s = :failed"
+		names["#s"] = attrStatus
+		values[":failed"] = &ddbtypes.AttributeValueMemberS{Value: StatusFailed}
+		logger.ErrorF("outbox: giving up on entry %s of %s after %d attempts: %v", e.ID, r.opts.Table, attempts, cause)
+	} else {
+		logger.WarnF("outbox: publishing entry %s of %s failed (attempt %d): %v", e.ID, r.opts.Table, attempts, cause)
+	}
+	_, err := r.api.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(r.opts.Table),
+		Key: map[string]ddbtypes.AttributeValue{
+			attrID: &ddbtypes.AttributeValueMemberS{Value: e.ID},
+		},
+		UpdateExpression:          aws.String(update),
+		ConditionExpression:       aws.String("attribute_exists(#id)"),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	})
+	var cf *ddbtypes.ConditionalCheckFailedException
+	if err != nil && !errors.As(err, &cf) {
+		logger.WarnF("outbox: failed to record the failed publish of entry %s: %v", e.ID, err)
+	}
+}
+
+// isFIFO reports whether u names a FIFO topic or queue, by host or by the
+// topic ARN in its path.
+func isFIFO(u *url.URL) bool {
+	return strings.HasSuffix(u.Host, ".fifo") || strings.HasSuffix(u.Path, ".fifo")
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
+
+// recentSet is a bounded set of entry IDs that forgets the oldest ID
+// once full.
+type recentSet struct {
+	ids  map[string]struct{}
+	ring []string
+	next int
+}
+
+func newRecentSet(size int) *recentSet {
+	return &recentSet{ids: make(map[string]struct{}, size), ring: make([]string, size)}
+}
+
+func (s *recentSet) has(id string) bool {
+	_, ok := s.ids[id]
+	return ok
+}
+
+func (s *recentSet) add(id string) {
+	if s.has(id) {
+		return
+	}
+	if old := s.ring[s.next]; old != "" {
+		delete(s.ids, old)
+	}
+	s.ring[s.next] = id
+	s.ids[id] = struct{}{}
+	s.next = (s.next + 1) % len(s.ring)
+}
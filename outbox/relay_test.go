@@ -0,0 +1,336 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"oss.nandlabs.io/golly/messaging"
+)
+
+// sent is one message published through fakePublisher.
+type sent struct {
+	dest, body, key, group, dedup string
+}
+
+// fakePublisher records sends; failFn, when set, fails matching ones.
+type fakePublisher struct {
+	mu     sync.Mutex
+	sent   []sent
+	failFn func(body string) error
+}
+
+func (p *fakePublisher) NewMessage(string, ...messaging.Option) (messaging.Message, error) {
+	return messaging.NewBaseMessage()
+}
+
+func (p *fakePublisher) Send(u *url.URL, msg messaging.Message, opts ...messaging.Option) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failFn != nil {
+		if err := p.failFn(msg.ReadAsStr()); err != nil {
+			return err
+		}
+	}
+	r := messaging.NewOptionsResolver(opts...)
+	group, _ := messaging.ResolveOptValue[string](optMessageGroupId, r)
+	dedup, _ := messaging.ResolveOptValue[string](optMessageDeduplicationId, r)
+	key, _ := msg.GetStrHeader(HeaderIdempotencyKey)
+	p.sent = append(p.sent, sent{dest: u.String(), body: msg.ReadAsStr(), key: key, group: group, dedup: dedup})
+	return nil
+}
+
+func (p *fakePublisher) bodies() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var bodies []string
+	for _, s := range p.sent {
+		bodies = append(bodies, s.body)
+	}
+	return bodies
+}
+
+// entryClock orders the entries of addEntries.
+var entryClock atomic.Int64
+
+// addEntries writes entries with the given bodies, one millisecond apart,
+// and returns them. Bodies starting with s go to a standard topic, the
+// others to a FIFO queue, grouped by their first letter.
+func addEntries(t *testing.T, table *fakeTable, bodies ...string) []*Entry {
+	t.Helper()
+	ob := New("outbox")
+	ob.now = func() time.Time {
+		return time.Unix(1700000000, 0).Add(time.Duration(entryClock.Add(1)) * time.Millisecond)
+	}
+	var entries []*Entry
+	for _, b := range bodies {
+		e := &Entry{Destination: "sqs://ledger.fifo", Body: []byte(b), GroupID: b[:1]}
+		if b[0] == 's' {
+			e.Destination, e.GroupID = "sns://audit", ""
+		}
+		entries = append(entries, e)
+	}
+	if err := ob.Transact(context.Background(), table, nil, entries...); err != nil {
+		t.Fatalf("Transact: %v", err)
+	}
+	return entries
+}
+
+func TestRelay_Poll(t *testing.T) {
+	table := newFakeTable()
+	pub := &fakePublisher{}
+	r, err := newRelayWithBackend(table, RelayOptions{Table: "outbox", Publisher: pub, BatchSize: 2, MaxAttempts: 2})
+	if err != nil {
+		t.Fatalf("newRelayWithBackend: %v", err)
+	}
+	// Group a's first entry fails once; b and the standard topic carry on.
+	entries := addEntries(t, table, "a1", "b1", "a2", "s1", "b2")
+	pub.failFn = func(body string) error {
+		if body == "a1" {
+			return errors.New("broker down")
+		}
+		return nil
+	}
+	ctx := context.Background()
+
+	n, err := r.Poll(ctx)
+	if err != nil || n != 3 {
+		t.Fatalf("Poll = %d, %v; want 3 published", n, err)
+	}
+	if want := []string{"b1", "s1", "b2"}; !slices.Equal(pub.bodies(), want) {
+		t.Fatalf("published %v, want %v", pub.bodies(), want)
+	}
+	if want := []int{2, 2, 1}; !slices.Equal(table.pages, want) {
+		t.Fatalf("query pages %v, want %v", table.pages, want)
+	}
+	// FIFO messages carry their group and the idempotency key as the
+	// deduplication ID; standard ones only the header.
+	if got := pub.sent[0]; got.dest != "sqs://ledger.fifo" || got.group != "b" || got.dedup != entries[1].ID || got.key != entries[1].ID {
+		t.Fatalf("FIFO message = %+v", got)
+	}
+	if got := pub.sent[1]; got.dest != "sns://audit" || got.group != "" || got.dedup != "" || got.key != entries[3].ID {
+		t.Fatalf("standard message = %+v", got)
+	}
+	failed := table.entry(t, entries[0].ID)
+	if failed == nil || failed.Attempts != 1 || failed.LastError != "broker down" || failed.Status != StatusPending {
+		t.Fatalf("failed entry = %+v", failed)
+	}
+	if table.len() != 2 {
+		t.Fatalf("%d entries left, want a1 and a2", table.len())
+	}
+
+	// Once the broker recovers, group a is published in order.
+	pub.failFn = nil
+	if n, err := r.Poll(ctx); err != nil || n != 2 {
+		t.Fatalf("second Poll = %d, %v", n, err)
+	}
+	if want := []string{"b1", "s1", "b2", "a1", "a2"}; !slices.Equal(pub.bodies(), want) {
+		t.Fatalf("published %v, want %v", pub.bodies(), want)
+	}
+	if table.len() != 0 {
+		t.Fatalf("%d entries left after publishing", table.len())
+	}
+
+	// An entry failing MaxAttempts times is parked as failed.
+	poison := addEntries(t, table, "s-poison")[0]
+	pub.failFn = func(string) error { return errors.New("rejected") }
+	for range 3 {
+		_, _ = r.Poll(ctx)
+	}
+	if got := table.entry(t, poison.ID); got == nil || got.Status != StatusFailed || got.Attempts != 2 {
+		t.Fatalf("poison entry = %+v, want failed after 2 attempts", got)
+	}
+}
+
+// fakeFeed hands out queued record batches, then blocks until ctx ends.
+type fakeFeed struct {
+	batches chan []Record
+}
+
+func (f *fakeFeed) Next(ctx context.Context) ([]Record, error) {
+	select {
+	case b := <-f.batches:
+		return b, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type fakeElector struct{ leader atomic.Bool }
+
+func (e *fakeElector) IsLeader(context.Context) bool { return e.leader.Load() }
+
+func TestRelay_HandleAndRun(t *testing.T) {
+	table := newFakeTable()
+	pub := &fakePublisher{}
+	feed := &fakeFeed{batches: make(chan []Record, 4)}
+	elector := &fakeElector{}
+	r, err := newRelayWithBackend(table, RelayOptions{
+		Table: "outbox", Publisher: pub, Feed: feed, Elector: elector, PollInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("newRelayWithBackend: %v", err)
+	}
+	entries := addEntries(t, table, "s1", "s2")
+	insert := func(e *Entry) Record {
+		return Record{EventName: EventInsert, NewImage: encodeEntry(e)}
+	}
+
+	// Handle publishes inserted entries once, and ignores other events.
+	n, err := r.Handle(context.Background(), []Record{
+		insert(entries[0]), insert(entries[0]), {EventName: EventRemove},
+		{EventName: EventModify, NewImage: encodeEntry(entries[1])},
+	})
+	if err != nil || n != 1 {
+		t.Fatalf("Handle = %d, %v; want 1 published", n, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- r.Run(ctx) }()
+
+	// A follower neither polls nor reads the feed.
+	time.Sleep(50 * time.Millisecond)
+	table.mu.Lock()
+	polls := len(table.pages)
+	table.mu.Unlock()
+	if polls != 0 || len(pub.bodies()) != 1 {
+		t.Fatalf("follower polled %d times, published %v", polls, pub.bodies())
+	}
+	// The leader polls, and relays feed records as they arrive.
+	elector.leader.Store(true)
+	later := addEntries(t, table, "s3")[0]
+	feed.batches <- []Record{insert(later)}
+	deadline := time.Now().Add(2 * time.Second)
+	for table.len() != 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run = %v, want context.Canceled", err)
+	}
+	if want := []string{"s1", "s2", "s3"}; !slices.Equal(pub.bodies(), want) {
+		t.Fatalf("published %v, want %v", pub.bodies(), want)
+	}
+}
+
+func TestRelay_HandleLeavesGroupsToPoll(t *testing.T) {
+	table := newFakeTable()
+	pub := &fakePublisher{}
+	r, err := newRelayWithBackend(table, RelayOptions{Table: "outbox", Publisher: pub})
+	if err != nil {
+		t.Fatalf("newRelayWithBackend: %v", err)
+	}
+	insert := func(e *Entry) Record {
+		return Record{EventName: EventInsert, NewImage: encodeEntry(e)}
+	}
+	ctx := context.Background()
+
+	// a1 fails in a poll and stays pending.
+	addEntries(t, table, "a1")
+	pub.failFn = func(string) error { return errors.New("broker down") }
+	if n, _ := r.Poll(ctx); n != 0 {
+		t.Fatalf("Poll published %d entries while the broker is down", n)
+	}
+	pub.failFn = nil
+
+	// a2 of the same group arrives from the feed and must not overtake
+	// a1; the ungrouped s1 goes ahead.
+	later := addEntries(t, table, "a2", "s1")
+	n, err := r.Handle(ctx, []Record{insert(later[0]), insert(later[1])})
+	if err != nil || n != 1 {
+		t.Fatalf("Handle = %d, %v; want 1 published", n, err)
+	}
+	if want := []string{"s1"}; !slices.Equal(pub.bodies(), want) {
+		t.Fatalf("published %v, want %v", pub.bodies(), want)
+	}
+	if table.entry(t, later[0].ID) == nil {
+		t.Fatalf("a2 was deleted before a1 was published")
+	}
+
+	// Grouped entries wait for the poll even when nothing is ahead of
+	// them, which then publishes the group in order.
+	a3 := addEntries(t, table, "a3")[0]
+	if n, err := r.Handle(ctx, []Record{insert(a3)}); err != nil || n != 0 {
+		t.Fatalf("Handle = %d, %v; want none published", n, err)
+	}
+	if n, err := r.Poll(ctx); err != nil || n != 3 {
+		t.Fatalf("Poll = %d, %v; want 3 published", n, err)
+	}
+	if want := []string{"s1", "a1", "a2", "a3"}; !slices.Equal(pub.bodies(), want) {
+		t.Fatalf("published %v, want %v", pub.bodies(), want)
+	}
+}
+
+// fakeLock is a lockElector that is always leader once started.
+type fakeLock struct {
+	started, resigned atomic.Bool
+}
+
+func (l *fakeLock) IsLeader(context.Context) bool { return l.started.Load() && !l.resigned.Load() }
+
+func (l *fakeLock) Start(context.Context) error {
+	l.started.Store(true)
+	return nil
+}
+
+func (l *fakeLock) Resign(context.Context) error {
+	l.resigned.Store(true)
+	return nil
+}
+
+func TestRelay_RunAgainWithLock(t *testing.T) {
+	table := newFakeTable()
+	pub := &fakePublisher{}
+	r, err := newRelayWithBackend(table, RelayOptions{Table: "outbox", Publisher: pub, PollInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("newRelayWithBackend: %v", err)
+	}
+	var locks []*fakeLock
+	r.newLock = func() lockElector {
+		l := &fakeLock{}
+		locks = append(locks, l)
+		return l
+	}
+
+	// Each Run elects with a fresh lock, so a second Run leads again.
+	for i, body := range []string{"s1", "s2"} {
+		addEntries(t, table, body)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- r.Run(ctx) }()
+		deadline := time.Now().Add(2 * time.Second)
+		for table.len() != 0 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		cancel()
+		if err := <-done; !errors.Is(err, context.Canceled) {
+			t.Fatalf("Run %d = %v, want context.Canceled", i, err)
+		}
+		if len(locks) != i+1 || !locks[i].resigned.Load() {
+			t.Fatalf("Run %d: %d locks created, resigned %v", i, len(locks), len(locks) > i && locks[i].resigned.Load())
+		}
+	}
+	if want := []string{"s1", "s2"}; !slices.Equal(pub.bodies(), want) {
+		t.Fatalf("published %v, want %v", pub.bodies(), want)
+	}
+}
+
+func TestNewRelay_Validation(t *testing.T) {
+	if _, err := newRelayWithBackend(newFakeTable(), RelayOptions{}); err == nil {
+		t.Fatalf("expected an error without a table")
+	}
+	r, err := newRelayWithBackend(newFakeTable(), RelayOptions{Table: "outbox"})
+	if err != nil {
+		t.Fatalf("newRelayWithBackend: %v", err)
+	}
+	if r.opts.PollInterval != defaultPollInterval || r.opts.BatchSize != defaultBatchSize ||
+		r.opts.MaxAttempts != defaultMaxAttempts || r.opts.Owner == "" || r.pub == nil {
+		t.Fatalf("defaults not applied: %+v", r.opts)
+	}
+}
@@ -0,0 +1,22 @@
+// Package ratelimit paces the messages the sns and sqs providers send.
+//
+// SNS limits publishes per second per account and region, and SQS FIFO
+// queues limit their throughput per queue and per message group. Bursty
+// senders that exceed those limits are throttled, and the AWS SDK's retries
+// only make the burst longer. A Limiter is a token bucket that spaces sends
+// out instead: Send and SendBatch wait, honouring their context, until the
+// limiter admits their messages. When AWS reports throttling anyway, the
+// limiter halves its rates and then recovers them gradually.
+//
+// Each provider has a Registry of limiter configs keyed by topic or queue
+// name. Every topic ARN or queue URL gets a limiter of its own, so
+// same-named topics or queues of different accounts or regions do not share
+// one:
+//
+//	sns.RateLimits.Set("orders", ratelimit.Config{Rate: 100, Burst: 20})
+//	sqs.RateLimits.Set("ledger.fifo", ratelimit.Config{Rate: 3000, GroupRate: 300})
+//	sqs.RateLimits.Set(ratelimit.Default, ratelimit.Config{Rate: 500})
+//
+// An observer installed on a provider that also implements Observer is
+// told how long every send waited and when throttling lowered a rate.
+package ratelimit
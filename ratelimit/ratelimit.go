@@ -0,0 +1,393 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+// Default is the Registry name whose Config applies to every name that has
+// none of its own. Each name still gets a limiter of its own.
+const Default = "*"
+
+const (
+	// DefaultMinFactor is the share of the configured rates throttling
+	// lowers a limiter to at most, when Config.MinFactor is zero.
+	DefaultMinFactor = 0.1
+	// DefaultRecovery is the share of the configured rates a throttled
+	// limiter regains per second, when Config.Recovery is zero.
+	DefaultRecovery = 0.05
+
+	// maxIdleGroups is the number of message groups a limiter tracks
+	// before it forgets the groups whose buckets are full again.
+	maxIdleGroups = 1024
+)
+
+// throttleCodes are the AWS error codes that report throttling.
+var throttleCodes = map[string]bool{
+	"Throttling":                true,
+	"Throttled":                 true,
+	"ThrottlingException":       true,
+	"ThrottledException":        true,
+	"RequestThrottled":          true,
+	"RequestThrottledException": true,
+	"TooManyRequestsException":  true,
+	"RequestLimitExceeded":      true,
+	"KMSThrottling":             true,
+	"KMSThrottlingException":    true,
+	"KmsThrottled":              true,
+	"KMS.ThrottlingException":   true,
+	"SlowDown":                  true,
+}
+
+// Config is the pacing of one topic or queue. Zero rates leave the
+// corresponding limit off.
+type Config struct {
+	// Rate is the number of messages per second sent in total.
+	Rate float64
+	// Burst is the number of messages that may be sent at once after an
+	// idle period. Zero means Rate rounded up, but at least 1.
+	Burst int
+	// GroupRate is the number of messages per second sent to each FIFO
+	// message group, and GroupBurst its burst.
+	GroupRate  float64
+	GroupBurst int
+	// MinFactor is the share of the configured rates that throttling
+	// lowers the limiter to at most. Zero means DefaultMinFactor.
+	MinFactor float64
+	// Recovery is the share of the configured rates a throttled limiter
+	// regains per second. Zero means DefaultRecovery.
+	Recovery float64
+	// Fixed keeps the configured rates when AWS reports throttling.
+	Fixed bool
+}
+
+// Validate reports an error for negative or out-of-range settings.
+func (c Config) Validate() error {
+	switch {
+	case c.Rate < 0 || c.GroupRate < 0 || math.IsNaN(c.Rate) || math.IsNaN(c.GroupRate):
+		return fmt.Errorf("ratelimit: rates must not be negative")
+	case c.Rate == 0 && c.GroupRate == 0:
+		return fmt.Errorf("ratelimit: Rate or GroupRate is required")
+	case c.Burst < 0 || c.GroupBurst < 0:
+		return fmt.Errorf("ratelimit: bursts must not be negative")
+	case c.MinFactor < 0 || c.MinFactor > 1:
+		return fmt.Errorf("ratelimit: MinFactor must be between 0 and 1")
+	case c.Recovery < 0:
+		return fmt.Errorf("ratelimit: Recovery must not be negative")
+	}
+	return nil
+}
+
+// burst returns the burst of a bucket with rate and configured burst.
+func burst(rate float64, configured int) float64 {
+	if configured > 0 {
+		return float64(configured)
+	}
+	return max(math.Ceil(rate), 1)
+}
+
+// Limiter is an adaptive token bucket, with a bucket per FIFO message group
+// when Config.GroupRate is set. It is safe for concurrent use; a nil
+// Limiter admits everything at once.
+type Limiter struct {
+	cfg Config
+	now func() time.Time
+
+	mu        sync.Mutex
+	factor    float64 // share of the configured rates in effect
+	recovered time.Time
+	total     bucket
+	groups    map[string]*bucket
+}
+
+// bucket holds the tokens of one rate; a negative balance is owed by
+// callers still waiting.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a limiter for cfg, with full buckets.
+func NewLimiter(cfg Config) (*Limiter, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.MinFactor == 0 {
+		cfg.MinFactor = DefaultMinFactor
+	}
+	if cfg.Recovery == 0 {
+		cfg.Recovery = DefaultRecovery
+	}
+	l := &Limiter{cfg: cfg, now: time.Now, factor: 1, groups: map[string]*bucket{}}
+	l.recovered = l.now()
+	l.total = bucket{tokens: burst(cfg.Rate, cfg.Burst), last: l.recovered}
+	return l, nil
+}
+
+// Config returns the configuration of the limiter.
+func (l *Limiter) Config() Config {
+	return l.cfg
+}
+
+// Wait blocks until the limiter admits one message of group, which is
+// empty for messages without one, or ctx is done. It returns how long it
+// waited.
+func (l *Limiter) Wait(ctx context.Context, group string) (time.Duration, error) {
+	return l.WaitBatch(ctx, []string{group})
+}
+
+// WaitBatch blocks until the limiter admits a batch of messages, given by
+// their groups, or ctx is done. It returns how long it waited. Batches
+// larger than the burst are admitted, and the tokens they overdraw delay
+// the messages after them. A cancelled wait gives its tokens back.
+func (l *Limiter) WaitBatch(ctx context.Context, groups []string) (time.Duration, error) {
+	if l == nil || len(groups) == 0 {
+		return 0, nil
+	}
+	l.mu.Lock()
+	now := l.now()
+	l.recover(now)
+	delay := l.total.take(now, float64(len(groups)), l.cfg.Rate*l.factor, burst(l.cfg.Rate, l.cfg.Burst))
+	counts := map[string]int{}
+	if l.cfg.GroupRate > 0 {
+		for _, g := range groups {
+			if g != "" {
+				counts[g]++
+			}
+		}
+		rate, size := l.cfg.GroupRate*l.factor, burst(l.cfg.GroupRate, l.cfg.GroupBurst)
+		for g, n := range counts {
+			delay = max(delay, l.group(g, now, size).take(now, float64(n), rate, size))
+		}
+	}
+	l.mu.Unlock()
+	if delay <= 0 {
+		return 0, nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return delay, nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		l.total.give(float64(len(groups)), burst(l.cfg.Rate, l.cfg.Burst))
+		for g, n := range counts {
+			if b, ok := l.groups[g]; ok {
+				b.give(float64(n), burst(l.cfg.GroupRate, l.cfg.GroupBurst))
+			}
+		}
+		l.mu.Unlock()
+		return 0, ctx.Err()
+	}
+}
+
+// take refills the bucket at rate, takes n tokens and returns how long the
+// caller must wait for the tokens it overdrew. A zero rate is unlimited.
+func (b *bucket) take(now time.Time, n, rate, size float64) time.Duration {
+	if rate <= 0 {
+		return 0
+	}
+	b.refill(now, rate, size)
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
+
+func (b *bucket) refill(now time.Time, rate, size float64) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = min(size, b.tokens+elapsed*rate)
+	}
+	b.last = now
+}
+
+func (b *bucket) give(n, size float64) {
+	b.tokens = min(size, b.tokens+n)
+}
+
+// group returns the bucket of a message group; l.mu must be held. When
+// many groups are tracked, those whose buckets are full are forgotten.
+func (l *Limiter) group(name string, now time.Time, size float64) *bucket {
+	if b, ok := l.groups[name]; ok {
+		return b
+	}
+	if len(l.groups) >= maxIdleGroups {
+		rate := l.cfg.GroupRate * l.factor
+		for g, b := range l.groups {
+			if b.refill(now, rate, size); b.tokens >= size {
+				delete(l.groups, g)
+			}
+		}
+	}
+	b := &bucket{tokens: size, last: now}
+	l.groups[name] = b
+	return b
+}
+
+// recover raises the factor of a throttled limiter; l.mu must be held.
+func (l *Limiter) recover(now time.Time) {
+	if elapsed := now.Sub(l.recovered).Seconds(); elapsed > 0 && l.factor < 1 {
+		l.factor = min(1, l.factor+elapsed*l.cfg.Recovery)
+	}
+	l.recovered = now
+}
+
+// Throttled halves the rates of the limiter, down to Config.MinFactor of
+// the configured ones, after AWS reported throttling. It reports whether
+// the rates changed; they do not for a Fixed limiter.
+func (l *Limiter) Throttled() bool {
+	if l == nil || l.cfg.Fixed {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.recover(l.now())
+	factor := max(l.factor/2, l.cfg.MinFactor)
+	if factor == l.factor {
+		return false
+	}
+	l.factor = factor
+	return true
+}
+
+// Rate returns the total rate in effect, in messages per second; zero when
+// only message groups are limited.
+func (l *Limiter) Rate() float64 {
+	return l.rate(l.cfg.Rate)
+}
+
+// GroupRate returns the per-group rate in effect, in messages per second;
+// zero when message groups are not limited.
+func (l *Limiter) GroupRate() float64 {
+	return l.rate(l.cfg.GroupRate)
+}
+
+func (l *Limiter) rate(configured float64) float64 {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.recover(l.now())
+	return configured * l.factor
+}
+
+// Registry holds the limiter configs of topic and queue names. Names
+// without a Config of their own use the one set for Default. Each name gets
+// a limiter of its own, and so does each instance key passed to LimiterFor,
+// so that same-named topics or queues of different accounts or regions do
+// not share one.
+type Registry struct {
+	mu       sync.Mutex
+	configs  map[string]Config
+	limiters map[limiterKey]*Limiter
+}
+
+// limiterKey identifies a limiter built from the config of name.
+type limiterKey struct {
+	name     string
+	instance any
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{configs: map[string]Config{}, limiters: map[limiterKey]*Limiter{}}
+}
+
+// Set configures the limiter of name, or of every name without a config
+// of its own for Default. It replaces the limiters the previous config
+// created.
+func (r *Registry) Set(name string, cfg Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.configs[name] = cfg
+	r.forget(name)
+	return nil
+}
+
+// Remove stops pacing name, or the names Default applied to.
+func (r *Registry) Remove(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.configs, name)
+	r.forget(name)
+}
+
+// forget drops the limiters built from the config of name; r.mu must be
+// held.
+func (r *Registry) forget(name string) {
+	for k := range r.limiters {
+		if k.name == name {
+			delete(r.limiters, k)
+		} else if _, own := r.configs[k.name]; name == Default && !own {
+			delete(r.limiters, k)
+		}
+	}
+}
+
+// Limiter returns the limiter of name, creating it on first use, or nil
+// when neither name nor Default is configured.
+func (r *Registry) Limiter(name string) *Limiter {
+	return r.LimiterFor(name, nil)
+}
+
+// LimiterFor returns the limiter of one instance of name, such as a queue
+// URL or topic ARN, creating it on first use from the config of name, or
+// nil when neither name nor Default is configured. instance must be
+// comparable; instances of the same name get limiters of their own.
+func (r *Registry) LimiterFor(name string, instance any) *Limiter {
+	key := limiterKey{name: name, instance: instance}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if l, ok := r.limiters[key]; ok {
+		return l
+	}
+	cfg, ok := r.configs[name]
+	if !ok {
+		if cfg, ok = r.configs[Default]; !ok {
+			return nil
+		}
+	}
+	l, _ := NewLimiter(cfg)
+	r.limiters[key] = l
+	return l
+}
+
+// IsThrottle reports whether err is, or wraps, an AWS error reporting
+// throttling.
+func IsThrottle(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && IsThrottleCode(apiErr.ErrorCode())
+}
+
+// IsThrottleCode reports whether an AWS error code, e.g. of a failed batch
+// entry, reports throttling.
+func IsThrottleCode(code string) bool {
+	return throttleCodes[code]
+}
+
+// Observer is an optional extension of messaging.Observer. An observer
+// installed with SetObserver on the sns or sqs provider that also
+// implements it is told about the pacing of sends to rate-limited topics
+// and queues.
+type Observer interface {
+	// OnRateWait is called each time a limiter admitted n messages, after
+	// waiting for waited, with the total and per-group rates in effect.
+	OnRateWait(u *url.URL, n int, waited time.Duration, rate, groupRate float64)
+	// OnRateThrottled is called when throttling reported by AWS lowered
+	// the rates of a limiter to rate and groupRate.
+	OnRateThrottled(u *url.URL, rate, groupRate float64)
+}
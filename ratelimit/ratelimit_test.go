@@ -0,0 +1,170 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+// fakeClock is a settable clock for limiters.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestLimiter(t *testing.T, cfg Config) (*Limiter, *fakeClock) {
+	t.Helper()
+	l, err := NewLimiter(cfg)
+	if err != nil {
+		t.Fatalf("NewLimiter: %v", err)
+	}
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	l.now = clock.now
+	l.recovered = clock.t
+	l.total.last = clock.t
+	return l, clock
+}
+
+func TestLimiter_PacesBeyondBurst(t *testing.T) {
+	l, err := NewLimiter(Config{Rate: 100, Burst: 2})
+	if err != nil {
+		t.Fatalf("NewLimiter: %v", err)
+	}
+	ctx := context.Background()
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		if _, err := l.Wait(ctx, ""); err != nil {
+			t.Fatalf("Wait: %v", err)
+		}
+	}
+	// Two messages go at once, the other four at 10ms intervals.
+	if elapsed := time.Since(start); elapsed < 35*time.Millisecond {
+		t.Fatalf("6 messages at 100/s with a burst of 2 took %v", elapsed)
+	}
+
+	var nilLimiter *Limiter
+	if waited, err := nilLimiter.WaitBatch(ctx, []string{"", ""}); waited != 0 || err != nil {
+		t.Fatalf("nil limiter waited %v, %v", waited, err)
+	}
+}
+
+func TestLimiter_GroupsAndCancellation(t *testing.T) {
+	l, clock := newTestLimiter(t, Config{GroupRate: 1, GroupBurst: 1})
+	ctx := context.Background()
+
+	// Every group has its own bucket; the total is unlimited.
+	if waited, _ := l.WaitBatch(ctx, []string{"a", "b", "c"}); waited != 0 {
+		t.Fatalf("first message of each group waited %v", waited)
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := l.Wait(cancelled, "a"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Wait with a cancelled context = %v", err)
+	}
+	// The cancelled wait gave its token back, so a second later group a
+	// has a full bucket again.
+	clock.advance(time.Second)
+	if waited, _ := l.Wait(ctx, "a"); waited != 0 {
+		t.Fatalf("group a waited %v after refilling", waited)
+	}
+	if got := l.Rate(); got != 0 {
+		t.Fatalf("Rate = %v, want 0 without a total limit", got)
+	}
+}
+
+func TestLimiter_ThrottledAndRecovery(t *testing.T) {
+	l, clock := newTestLimiter(t, Config{Rate: 100, GroupRate: 10, MinFactor: 0.25, Recovery: 0.1})
+	for _, want := range []float64{50, 25, 25} {
+		l.Throttled()
+		if got := l.Rate(); got != want {
+			t.Fatalf("Rate after throttling = %v, want %v", got, want)
+		}
+	}
+	if got := l.GroupRate(); got != 2.5 {
+		t.Fatalf("GroupRate = %v, want 2.5", got)
+	}
+	if l.Throttled() {
+		t.Fatalf("Throttled reported a change at MinFactor")
+	}
+	clock.advance(5 * time.Second)
+	if got := l.Rate(); got != 75 {
+		t.Fatalf("Rate after 5s = %v, want 75", got)
+	}
+	clock.advance(time.Minute)
+	if got := l.Rate(); got != 100 {
+		t.Fatalf("Rate after recovery = %v, want 100", got)
+	}
+
+	fixed, _ := newTestLimiter(t, Config{Rate: 100, Fixed: true})
+	if fixed.Throttled() || fixed.Rate() != 100 {
+		t.Fatalf("fixed limiter changed its rate to %v", fixed.Rate())
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	if r.Limiter("orders") != nil {
+		t.Fatalf("unconfigured name has a limiter")
+	}
+	if err := r.Set("orders", Config{Burst: 5}); err == nil {
+		t.Fatalf("expected an error for a config without rates")
+	}
+	if err := r.Set(Default, Config{Rate: 10}); err != nil {
+		t.Fatalf("Set default: %v", err)
+	}
+	if err := r.Set("orders", Config{Rate: 50}); err != nil {
+		t.Fatalf("Set orders: %v", err)
+	}
+	orders, audit, billing := r.Limiter("orders"), r.Limiter("audit"), r.Limiter("billing")
+	if orders.Rate() != 50 || audit.Rate() != 10 || audit == billing {
+		t.Fatalf("limiters: orders %v, audit %v, shared default %v", orders.Rate(), audit.Rate(), audit == billing)
+	}
+	if r.Limiter("orders") != orders {
+		t.Fatalf("Limiter returned a new limiter for a configured name")
+	}
+
+	_ = r.Set(Default, Config{Rate: 20})
+	if got := r.Limiter("audit").Rate(); got != 20 || r.Limiter("orders") != orders {
+		t.Fatalf("after replacing the default: audit %v, orders kept %v", got, r.Limiter("orders") == orders)
+	}
+	r.Remove(Default)
+	if r.Limiter("audit") != nil {
+		t.Fatalf("removed default still applies")
+	}
+}
+
+func TestRegistry_LimiterFor(t *testing.T) {
+	r := NewRegistry()
+	if err := r.Set("orders", Config{Rate: 50}); err != nil {
+		t.Fatalf("Set orders: %v", err)
+	}
+	east := r.LimiterFor("orders", "arn:aws:sns:us-east-1:111111111111:orders")
+	west := r.LimiterFor("orders", "arn:aws:sns:us-west-2:111111111111:orders")
+	if east == nil || west == nil || east == west || east == r.Limiter("orders") {
+		t.Fatalf("instances of orders share a limiter")
+	}
+	if east.Rate() != 50 || r.LimiterFor("orders", "arn:aws:sns:us-east-1:111111111111:orders") != east {
+		t.Fatalf("LimiterFor: rate %v, reused %v", east.Rate(), r.LimiterFor("orders", "arn:aws:sns:us-east-1:111111111111:orders") == east)
+	}
+	_ = r.Set("orders", Config{Rate: 5})
+	if got := r.LimiterFor("orders", "arn:aws:sns:us-east-1:111111111111:orders"); got == east || got.Rate() != 5 {
+		t.Fatalf("Set kept the limiter of an instance")
+	}
+}
+
+func TestIsThrottle(t *testing.T) {
+	throttle := &smithy.GenericAPIError{Code: "Throttling", Message: "Rate exceeded"}
+	if !IsThrottle(fmt.Errorf("sns: publish failed: %w", throttle)) {
+		t.Fatalf("wrapped Throttling error not recognised")
+	}
+	if IsThrottle(&smithy.GenericAPIError{Code: "InvalidParameter"}) || IsThrottle(errors.New("Throttling")) {
+		t.Fatalf("non-throttling error recognised")
+	}
+	if !IsThrottleCode("KMSThrottling") || IsThrottleCode("InternalError") {
+		t.Fatalf("IsThrottleCode")
+	}
+}
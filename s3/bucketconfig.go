@@ -0,0 +1,410 @@
+package s3
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// LifecycleRule is a single bucket lifecycle rule. Zero-valued day counts
+// leave the corresponding action unset.
+type LifecycleRule struct {
+	// ID identifies the rule (up to 255 characters).
+	ID string
+	// Prefix restricts the rule to keys with this prefix. Empty applies the
+	// rule to the whole bucket.
+	Prefix string
+	// Enabled reports whether the rule is active.
+	Enabled bool
+	// ExpirationDays deletes current object versions this many days after
+	// creation.
+	ExpirationDays int32
+	// NoncurrentVersionExpirationDays deletes noncurrent versions this many
+	// days after they become noncurrent (versioned buckets only).
+	NoncurrentVersionExpirationDays int32
+	// AbortIncompleteUploadDays aborts multipart uploads left incomplete
+	// this many days after initiation.
+	AbortIncompleteUploadDays int32
+	// Transitions moves current versions to other storage classes.
+	Transitions []LifecycleTransition
+}
+
+// LifecycleTransition moves objects to StorageClass Days after creation.
+type LifecycleTransition struct {
+	Days         int32
+	StorageClass string
+}
+
+// CORSRule is a single bucket CORS rule.
+type CORSRule struct {
+	ID             string
+	AllowedOrigins []string
+	AllowedMethods []string
+	AllowedHeaders []string
+	ExposeHeaders  []string
+	MaxAgeSeconds  int32
+}
+
+// VersioningStatus is the versioning state of a bucket.
+type VersioningStatus string
+
+const (
+	// VersioningUnversioned is the state of a bucket that has never had
+	// versioning enabled. A bucket cannot return to it once versioned.
+	VersioningUnversioned VersioningStatus = ""
+	// VersioningEnabled keeps every version of every object.
+	VersioningEnabled VersioningStatus = "Enabled"
+	// VersioningSuspended stops creating new versions but keeps old ones.
+	VersioningSuspended VersioningStatus = "Suspended"
+)
+
+const (
+	// EncryptionAES256 selects SSE-S3 (S3-managed keys).
+	EncryptionAES256 = "AES256"
+	// EncryptionKMS selects SSE-KMS.
+	EncryptionKMS = "aws:kms"
+)
+
+// BucketEncryption is the default server-side encryption of a bucket.
+type BucketEncryption struct {
+	// Algorithm is EncryptionAES256 or EncryptionKMS.
+	Algorithm string
+	// KMSKeyID is the KMS key ID or ARN (EncryptionKMS only; empty uses the
+	// AWS managed key).
+	KMSKeyID string
+	// BucketKeyEnabled enables S3 Bucket Keys to reduce KMS request costs.
+	BucketKeyEnabled bool
+}
+
+// PublicAccessBlock is a bucket's public access block configuration.
+type PublicAccessBlock struct {
+	BlockPublicAcls       bool
+	IgnorePublicAcls      bool
+	BlockPublicPolicy     bool
+	RestrictPublicBuckets bool
+}
+
+// PolicyVersion is the current IAM policy language version, used when a
+// BucketPolicy does not set one.
+const PolicyVersion = "2012-10-17"
+
+// BucketPolicy is a bucket policy document. It marshals to the standard
+// IAM JSON policy grammar.
+type BucketPolicy struct {
+	Version    string            `json:"Version"`
+	ID         string            `json:"Id,omitempty"`
+	Statements []PolicyStatement `json:"Statement"`
+}
+
+// PolicyStatement is a single statement in a BucketPolicy.
+type PolicyStatement struct {
+	Sid       string                             `json:"Sid,omitempty"`
+	Effect    string                             `json:"Effect"`
+	Principal *PolicyPrincipal                   `json:"Principal,omitempty"`
+	Action    PolicyValues                       `json:"Action,omitempty"`
+	Resource  PolicyValues                       `json:"Resource,omitempty"`
+	Condition map[string]map[string]PolicyValues `json:"Condition,omitempty"`
+}
+
+// PolicyPrincipal is the Principal element of a policy statement. Set
+// Anyone for the "*" principal; otherwise list principals by type.
+type PolicyPrincipal struct {
+	Anyone        bool
+	AWS           PolicyValues
+	Service       PolicyValues
+	Federated     PolicyValues
+	CanonicalUser PolicyValues
+}
+
+// policyPrincipalJSON is the object form of a policy principal.
+type policyPrincipalJSON struct {
+	AWS           PolicyValues `json:"AWS,omitempty"`
+	Service       PolicyValues `json:"Service,omitempty"`
+	Federated     PolicyValues `json:"Federated,omitempty"`
+	CanonicalUser PolicyValues `json:"CanonicalUser,omitempty"`
+}
+
+// MarshalJSON encodes the principal as "*" or as an object keyed by
+// principal type.
+func (p PolicyPrincipal) MarshalJSON() ([]byte, error) {
+	if p.Anyone {
+		return json.Marshal("*")
+	}
+	return json.Marshal(policyPrincipalJSON{
+		AWS:           p.AWS,
+		Service:       p.Service,
+		Federated:     p.Federated,
+		CanonicalUser: p.CanonicalUser,
+	})
+}
+
+// UnmarshalJSON accepts both the "*" and the object forms.
+func (p *PolicyPrincipal) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		if s != "*" {
+			return fmt.Errorf("s3: invalid policy principal %q", s)
+		}
+		*p = PolicyPrincipal{Anyone: true}
+		return nil
+	}
+	var obj policyPrincipalJSON
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	*p = PolicyPrincipal{
+		AWS:           obj.AWS,
+		Service:       obj.Service,
+		Federated:     obj.Federated,
+		CanonicalUser: obj.CanonicalUser,
+	}
+	if len(p.AWS) == 1 && p.AWS[0] == "*" && len(p.Service)+len(p.Federated)+len(p.CanonicalUser) == 0 {
+		// {"AWS": "*"} is equivalent to "*".
+		*p = PolicyPrincipal{Anyone: true}
+	}
+	return nil
+}
+
+// PolicyValues is a policy element that may be written as a single string
+// or as an array of strings.
+type PolicyValues []string
+
+// MarshalJSON writes a single value as a string and several as an array.
+func (v PolicyValues) MarshalJSON() ([]byte, error) {
+	if len(v) == 1 {
+		return json.Marshal(v[0])
+	}
+	return json.Marshal([]string(v))
+}
+
+// UnmarshalJSON accepts a string or an array of strings.
+func (v *PolicyValues) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*v = PolicyValues{s}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*v = list
+	return nil
+}
+
+// ParseBucketPolicy decodes a JSON bucket policy document.
+func ParseBucketPolicy(doc string) (*BucketPolicy, error) {
+	var p BucketPolicy
+	if err := json.Unmarshal([]byte(doc), &p); err != nil {
+		return nil, fmt.Errorf("s3: invalid bucket policy: %w", err)
+	}
+	return &p, nil
+}
+
+// String returns the JSON encoding of the policy.
+func (p *BucketPolicy) String() string {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// validate checks the parts of a policy S3 would otherwise reject with a
+// less helpful MalformedPolicy error.
+func (p *BucketPolicy) validate() error {
+	if len(p.Statements) == 0 {
+		return errors.New("s3: bucket policy must contain at least one statement")
+	}
+	for i, st := range p.Statements {
+		if st.Effect != "Allow" && st.Effect != "Deny" {
+			return fmt.Errorf("s3: bucket policy statement %d: Effect must be Allow or Deny", i)
+		}
+		if st.Principal == nil {
+			return fmt.Errorf("s3: bucket policy statement %d: Principal is required", i)
+		}
+		if len(st.Action) == 0 || len(st.Resource) == 0 {
+			return fmt.Errorf("s3: bucket policy statement %d: Action and Resource are required", i)
+		}
+	}
+	return nil
+}
+
+// withDefaults returns a copy of the policy with Version filled in.
+func (p *BucketPolicy) withDefaults() *BucketPolicy {
+	out := *p
+	if out.Version == "" {
+		out.Version = PolicyVersion
+	}
+	return &out
+}
+
+// ---- SDK conversions --------------------------------------------------------
+
+func lifecycleRulesToSDK(rules []LifecycleRule) []s3types.LifecycleRule {
+	out := make([]s3types.LifecycleRule, 0, len(rules))
+	for _, r := range rules {
+		rule := s3types.LifecycleRule{
+			ID:     aws.String(r.ID),
+			Status: s3types.ExpirationStatusDisabled,
+			Filter: &s3types.LifecycleRuleFilter{Prefix: aws.String(r.Prefix)},
+		}
+		if r.Enabled {
+			rule.Status = s3types.ExpirationStatusEnabled
+		}
+		if r.ExpirationDays > 0 {
+			rule.Expiration = &s3types.LifecycleExpiration{Days: aws.Int32(r.ExpirationDays)}
+		}
+		if r.NoncurrentVersionExpirationDays > 0 {
+			rule.NoncurrentVersionExpiration = &s3types.NoncurrentVersionExpiration{
+				NoncurrentDays: aws.Int32(r.NoncurrentVersionExpirationDays),
+			}
+		}
+		if r.AbortIncompleteUploadDays > 0 {
+			rule.AbortIncompleteMultipartUpload = &s3types.AbortIncompleteMultipartUpload{
+				DaysAfterInitiation: aws.Int32(r.AbortIncompleteUploadDays),
+			}
+		}
+		for _, t := range r.Transitions {
+			rule.Transitions = append(rule.Transitions, s3types.Transition{
+				Days:         aws.Int32(t.Days),
+				StorageClass: s3types.TransitionStorageClass(t.StorageClass),
+			})
+		}
+		out = append(out, rule)
+	}
+	return out
+}
+
+func lifecycleRulesFromSDK(rules []s3types.LifecycleRule) []LifecycleRule {
+	var out []LifecycleRule
+	for _, r := range rules {
+		rule := LifecycleRule{
+			ID:      aws.ToString(r.ID),
+			Prefix:  aws.ToString(r.Prefix),
+			Enabled: r.Status == s3types.ExpirationStatusEnabled,
+		}
+		if r.Filter != nil {
+			if r.Filter.Prefix != nil {
+				rule.Prefix = aws.ToString(r.Filter.Prefix)
+			} else if r.Filter.And != nil {
+				rule.Prefix = aws.ToString(r.Filter.And.Prefix)
+			}
+		}
+		if r.Expiration != nil {
+			rule.ExpirationDays = aws.ToInt32(r.Expiration.Days)
+		}
+		if r.NoncurrentVersionExpiration != nil {
+			rule.NoncurrentVersionExpirationDays = aws.ToInt32(r.NoncurrentVersionExpiration.NoncurrentDays)
+		}
+		if r.AbortIncompleteMultipartUpload != nil {
+			rule.AbortIncompleteUploadDays = aws.ToInt32(r.AbortIncompleteMultipartUpload.DaysAfterInitiation)
+		}
+		for _, t := range r.Transitions {
+			rule.Transitions = append(rule.Transitions, LifecycleTransition{
+				Days:         aws.ToInt32(t.Days),
+				StorageClass: string(t.StorageClass),
+			})
+		}
+		out = append(out, rule)
+	}
+	return out
+}
+
+func corsRulesToSDK(rules []CORSRule) []s3types.CORSRule {
+	out := make([]s3types.CORSRule, 0, len(rules))
+	for _, r := range rules {
+		rule := s3types.CORSRule{
+			AllowedOrigins: r.AllowedOrigins,
+			AllowedMethods: r.AllowedMethods,
+			AllowedHeaders: r.AllowedHeaders,
+			ExposeHeaders:  r.ExposeHeaders,
+		}
+		if r.ID != "" {
+			rule.ID = aws.String(r.ID)
+		}
+		if r.MaxAgeSeconds > 0 {
+			rule.MaxAgeSeconds = aws.Int32(r.MaxAgeSeconds)
+		}
+		out = append(out, rule)
+	}
+	return out
+}
+
+func corsRulesFromSDK(rules []s3types.CORSRule) []CORSRule {
+	var out []CORSRule
+	for _, r := range rules {
+		out = append(out, CORSRule{
+			ID:             aws.ToString(r.ID),
+			AllowedOrigins: nonEmpty(r.AllowedOrigins),
+			AllowedMethods: nonEmpty(r.AllowedMethods),
+			AllowedHeaders: nonEmpty(r.AllowedHeaders),
+			ExposeHeaders:  nonEmpty(r.ExposeHeaders),
+			MaxAgeSeconds:  aws.ToInt32(r.MaxAgeSeconds),
+		})
+	}
+	return out
+}
+
+func encryptionToSDK(enc *BucketEncryption) *s3types.ServerSideEncryptionConfiguration {
+	def := &s3types.ServerSideEncryptionByDefault{SSEAlgorithm: s3types.ServerSideEncryption(enc.Algorithm)}
+	if enc.KMSKeyID != "" {
+		def.KMSMasterKeyID = aws.String(enc.KMSKeyID)
+	}
+	return &s3types.ServerSideEncryptionConfiguration{
+		Rules: []s3types.ServerSideEncryptionRule{{
+			ApplyServerSideEncryptionByDefault: def,
+			BucketKeyEnabled:                   aws.Bool(enc.BucketKeyEnabled),
+		}},
+	}
+}
+
+func encryptionFromSDK(conf *s3types.ServerSideEncryptionConfiguration) *BucketEncryption {
+	if conf == nil {
+		return nil
+	}
+	for _, r := range conf.Rules {
+		if r.ApplyServerSideEncryptionByDefault == nil {
+			continue
+		}
+		return &BucketEncryption{
+			Algorithm:        string(r.ApplyServerSideEncryptionByDefault.SSEAlgorithm),
+			KMSKeyID:         aws.ToString(r.ApplyServerSideEncryptionByDefault.KMSMasterKeyID),
+			BucketKeyEnabled: aws.ToBool(r.BucketKeyEnabled),
+		}
+	}
+	return nil
+}
+
+func publicAccessBlockToSDK(pab *PublicAccessBlock) *s3types.PublicAccessBlockConfiguration {
+	return &s3types.PublicAccessBlockConfiguration{
+		BlockPublicAcls:       aws.Bool(pab.BlockPublicAcls),
+		IgnorePublicAcls:      aws.Bool(pab.IgnorePublicAcls),
+		BlockPublicPolicy:     aws.Bool(pab.BlockPublicPolicy),
+		RestrictPublicBuckets: aws.Bool(pab.RestrictPublicBuckets),
+	}
+}
+
+func publicAccessBlockFromSDK(conf *s3types.PublicAccessBlockConfiguration) *PublicAccessBlock {
+	if conf == nil {
+		return nil
+	}
+	return &PublicAccessBlock{
+		BlockPublicAcls:       aws.ToBool(conf.BlockPublicAcls),
+		IgnorePublicAcls:      aws.ToBool(conf.IgnorePublicAcls),
+		BlockPublicPolicy:     aws.ToBool(conf.BlockPublicPolicy),
+		RestrictPublicBuckets: aws.ToBool(conf.RestrictPublicBuckets),
+	}
+}
+
+// nonEmpty normalises empty slices to nil so configs read back from S3
+// compare equal to the ones that were written.
+func nonEmpty(v []string) []string {
+	if len(v) == 0 {
+		return nil
+	}
+	return v
+}
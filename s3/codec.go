@@ -0,0 +1,342 @@
+package s3
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"oss.nandlabs.io/golly/managers"
+)
+
+// User metadata keys written by the codec layer. S3 returns user metadata
+// lower-cased, so the keys are lower-case too.
+const (
+	// MetaCodec records the codec chain applied to an object, in the order
+	// the codecs were applied on write (e.g. "gzip,aes256-gcm").
+	MetaCodec = "golly-codec"
+	// MetaLogicalSize records the size of the object before encoding.
+	MetaLogicalSize = "golly-logical-size"
+)
+
+// CompressionGzip is the name (and Content-Encoding) of the built-in gzip
+// compression codec.
+const CompressionGzip = "gzip"
+
+// ErrUnknownCodec is returned when an object names a codec that is not
+// registered in this process.
+var ErrUnknownCodec = errors.New("s3: unknown codec")
+
+// Codec transforms object bytes on their way to and from S3. Compression
+// codecs are stateless; the encryption codec stores its per-object key
+// material in meta when writing and reads it back when reading.
+type Codec interface {
+	// Name identifies the codec in the object metadata. For compression
+	// codecs it is also the Content-Encoding value.
+	Name() string
+	// NewWriter returns a writer that encodes into w. Closing it must flush
+	// everything but must not close w.
+	NewWriter(ctx context.Context, w io.Writer, meta map[string]string) (io.WriteCloser, error)
+	// NewReader returns a reader that decodes r.
+	NewReader(ctx context.Context, r io.Reader, meta map[string]string) (io.ReadCloser, error)
+}
+
+// CodecOptions configures the codec layer for a bucket. Register it with
+// CodecManager under the bucket name:
+//
+//	s3.CodecManager.Register("my-logs", &s3.CodecOptions{ByExtension: true})
+//
+// Objects written through the codec layer carry their codec chain in
+// metadata, so reading them back is automatic as long as the codecs (and,
+// for encrypted objects, the KeyProvider) are available.
+type CodecOptions struct {
+	// Compression names a registered compression applied to every object
+	// written. It takes precedence over ByExtension.
+	Compression string
+	// ByExtension picks the compression from the key extension on write
+	// (".gz" → gzip) and, on read, decodes objects without codec metadata
+	// whose Content-Encoding or extension names a registered compression.
+	ByExtension bool
+	// KeyProvider enables client-side envelope encryption of written
+	// objects and is required to read encrypted objects back.
+	KeyProvider KeyProvider
+}
+
+// CodecManager holds the CodecOptions for each bucket, keyed by bucket name.
+var CodecManager = managers.NewItemManager[*CodecOptions]()
+
+var (
+	compressionMu    sync.RWMutex
+	compressions     = map[string]Codec{}
+	compressionByExt = map[string]string{}
+)
+
+func init() {
+	RegisterCompression(NewCompression(CompressionGzip,
+		func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+		func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+	), ".gz", ".gzip")
+}
+
+// RegisterCompression makes a compression codec available by name and,
+// optionally, by key extension. Registering a name again replaces it.
+//
+// Only gzip is built in. Other formats such as zstd can be plugged in
+// with NewCompression around an implementation of your choice:
+//
+//	s3.RegisterCompression(s3.NewCompression("zstd", newZstdWriter, newZstdReader), ".zst", ".zstd")
+func RegisterCompression(c Codec, extensions ...string) {
+	compressionMu.Lock()
+	defer compressionMu.Unlock()
+	compressions[c.Name()] = c
+	for _, ext := range extensions {
+		compressionByExt[strings.ToLower(ext)] = c.Name()
+	}
+}
+
+// NewCompression builds a compression Codec from a writer and reader
+// constructor pair.
+func NewCompression(name string, newWriter func(io.Writer) (io.WriteCloser, error),
+	newReader func(io.Reader) (io.ReadCloser, error)) Codec {
+	return &compressionCodec{name: name, newWriter: newWriter, newReader: newReader}
+}
+
+type compressionCodec struct {
+	name      string
+	newWriter func(io.Writer) (io.WriteCloser, error)
+	newReader func(io.Reader) (io.ReadCloser, error)
+}
+
+func (c *compressionCodec) Name() string { return c.name }
+
+func (c *compressionCodec) NewWriter(_ context.Context, w io.Writer, _ map[string]string) (io.WriteCloser, error) {
+	return c.newWriter(w)
+}
+
+func (c *compressionCodec) NewReader(_ context.Context, r io.Reader, _ map[string]string) (io.ReadCloser, error) {
+	return c.newReader(r)
+}
+
+// lookupCompression returns the registered compression codec called name.
+func lookupCompression(name string) (Codec, bool) {
+	compressionMu.RLock()
+	defer compressionMu.RUnlock()
+	c, ok := compressions[name]
+	return c, ok
+}
+
+// compressionForKey returns the compression registered for key's extension.
+func compressionForKey(key string) string {
+	compressionMu.RLock()
+	defer compressionMu.RUnlock()
+	return compressionByExt[strings.ToLower(path.Ext(key))]
+}
+
+// codecOptionsFor returns the CodecOptions registered for the bucket, or nil.
+func codecOptionsFor(opts *urlOpts) *CodecOptions {
+	return CodecManager.Get(opts.Bucket)
+}
+
+// resolveCodec maps a codec name from the chain to its implementation.
+func resolveCodec(name string, co *CodecOptions) (Codec, error) {
+	if name == EncryptionAES256GCM {
+		if co == nil || co.KeyProvider == nil {
+			return nil, ErrNoKeyProvider
+		}
+		return &envelopeCodec{keys: co.KeyProvider}, nil
+	}
+	if c, ok := lookupCompression(name); ok {
+		return c, nil
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownCodec, name)
+}
+
+// writeChain returns the codec names to apply to a new object at key.
+func writeChain(key string, co *CodecOptions) []string {
+	if co == nil {
+		return nil
+	}
+	var chain []string
+	switch {
+	case co.Compression != "":
+		chain = append(chain, co.Compression)
+	case co.ByExtension:
+		if name := compressionForKey(key); name != "" {
+			chain = append(chain, name)
+		}
+	}
+	if co.KeyProvider != nil {
+		chain = append(chain, EncryptionAES256GCM)
+	}
+	return chain
+}
+
+// encodedObject is the result of running a write buffer through the codecs.
+type encodedObject struct {
+	data            []byte
+	contentEncoding string
+	metadata        map[string]string
+}
+
+// encodeObject applies the codec chain configured for the bucket to data.
+// It returns (nil, nil) when no codec applies.
+func encodeObject(ctx context.Context, opts *urlOpts, data []byte) (*encodedObject, error) {
+	co := codecOptionsFor(opts)
+	chain := writeChain(opts.Key, co)
+	if len(chain) == 0 {
+		return nil, nil
+	}
+	meta := map[string]string{
+		MetaCodec:       strings.Join(chain, ","),
+		MetaLogicalSize: strconv.Itoa(len(data)),
+	}
+	var out bytes.Buffer
+	var w io.Writer = &out
+	var writers []io.WriteCloser
+	for i := len(chain) - 1; i >= 0; i-- {
+		c, err := resolveCodec(chain[i], co)
+		if err != nil {
+			return nil, err
+		}
+		wc, err := c.NewWriter(ctx, w, meta)
+		if err != nil {
+			return nil, fmt.Errorf("s3: %s encode: %w", chain[i], err)
+		}
+		writers = append(writers, wc)
+		w = wc
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, fmt.Errorf("s3: encode: %w", err)
+	}
+	// Close outermost first so each layer flushes into the next.
+	for i := len(writers) - 1; i >= 0; i-- {
+		if err := writers[i].Close(); err != nil {
+			return nil, fmt.Errorf("s3: %s encode: %w", chain[len(chain)-1-i], err)
+		}
+	}
+	enc := &encodedObject{data: out.Bytes(), metadata: meta}
+	// Content-Encoding only describes the bytes when compression is the
+	// whole story; an encrypted body is opaque to HTTP clients.
+	if co.KeyProvider == nil {
+		enc.contentEncoding = strings.Join(chain, ",")
+	}
+	return enc, nil
+}
+
+// readChain works out which codecs an existing object was written with.
+// Without codec metadata an empty object is never decoded, as a plain
+// zero-byte marker is not a valid compressed stream.
+func readChain(key, contentEncoding string, size int64, meta map[string]string, co *CodecOptions) []string {
+	if v := meta[MetaCodec]; v != "" {
+		return strings.Split(v, ",")
+	}
+	if co == nil || !co.ByExtension || size == 0 {
+		return nil
+	}
+	if contentEncoding != "" {
+		var chain []string
+		for _, enc := range strings.Split(contentEncoding, ",") {
+			enc = strings.TrimSpace(enc)
+			if _, ok := lookupCompression(enc); !ok {
+				return nil
+			}
+			chain = append(chain, enc)
+		}
+		return chain
+	}
+	if name := compressionForKey(key); name != "" {
+		return []string{name}
+	}
+	return nil
+}
+
+// isEncoded reports whether reading the object goes through any decoder.
+func isEncoded(opts *urlOpts, contentEncoding string, size int64, meta map[string]string) bool {
+	return len(readChain(opts.Key, contentEncoding, size, meta, codecOptionsFor(opts))) > 0
+}
+
+// decodeObject wraps body with the decoders for the object's codec chain.
+// It returns body unchanged when the object is not encoded.
+func decodeObject(ctx context.Context, opts *urlOpts, body io.ReadCloser, contentEncoding string,
+	size int64, meta map[string]string) (io.ReadCloser, error) {
+	co := codecOptionsFor(opts)
+	chain := readChain(opts.Key, contentEncoding, size, meta, co)
+	if len(chain) == 0 {
+		return body, nil
+	}
+	dr := &decodeReader{closers: []io.Closer{body}}
+	var r io.Reader = body
+	for i := len(chain) - 1; i >= 0; i-- {
+		c, err := resolveCodec(chain[i], co)
+		if err != nil {
+			_ = dr.Close()
+			return nil, err
+		}
+		rc, err := c.NewReader(ctx, r, meta)
+		if err != nil {
+			_ = dr.Close()
+			return nil, fmt.Errorf("s3: %s decode: %w", chain[i], err)
+		}
+		dr.closers = append(dr.closers, rc)
+		r = rc
+	}
+	dr.Reader = r
+	return dr, nil
+}
+
+// sameCodecs reports whether an object stored at src reads back the same
+// at dst, so its bytes and codec metadata can be copied as they are.
+func sameCodecs(src, dst *urlOpts) bool {
+	return codecOptionsFor(src) == codecOptionsFor(dst) && compressionForKey(src.Key) == compressionForKey(dst.Key)
+}
+
+// userMetadata returns meta without the codec keys, which describe one
+// encoding of the object and must not outlive it.
+func userMetadata(meta map[string]string) map[string]string {
+	out := make(map[string]string, len(meta))
+	for k, v := range meta {
+		switch k {
+		case MetaCodec, MetaLogicalSize, MetaWrappedKey, MetaKeyID, MetaIV:
+			continue
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// logicalSize returns the pre-encoding size recorded in meta, if any.
+func logicalSize(meta map[string]string) (int64, bool) {
+	v, ok := meta[MetaLogicalSize]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// decodeReader reads from the outermost decoder and closes every layer,
+// innermost (the response body) last.
+type decodeReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (d *decodeReader) Close() error {
+	var err error
+	for i := len(d.closers) - 1; i >= 0; i-- {
+		if cerr := d.closers[i].Close(); err == nil {
+			err = cerr
+		}
+	}
+	d.closers = nil
+	return err
+}
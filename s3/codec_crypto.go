@@ -0,0 +1,274 @@
+package s3
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// EncryptionAES256GCM is the codec name of client-side envelope encryption.
+// Each object is encrypted with its own random 256-bit data key in
+// AES-GCM; the data key is stored in metadata wrapped by a KeyProvider.
+const EncryptionAES256GCM = "aes256-gcm"
+
+// Metadata keys holding the envelope of an encrypted object.
+const (
+	// MetaWrappedKey is the base64 data key as wrapped by the KeyProvider.
+	MetaWrappedKey = "golly-wrapped-key"
+	// MetaKeyID identifies the key the data key was wrapped with.
+	MetaKeyID = "golly-key-id"
+	// MetaIV is the base64 nonce prefix of the object's GCM segments.
+	MetaIV = "golly-iv"
+)
+
+// ErrNoKeyProvider is returned when an object needs encrypting or
+// decrypting but no KeyProvider is configured for its bucket.
+var ErrNoKeyProvider = errors.New("s3: no key provider configured")
+
+// encSegmentSize is the plaintext size of one GCM segment. Objects are
+// sealed in segments so reads can stream without buffering the object.
+const encSegmentSize = 64 * 1024
+
+// KeyProvider issues and unwraps data keys for envelope encryption. A KMS
+// backed implementation maps GenerateDataKey and UnwrapDataKey onto the
+// KMS GenerateDataKey and Decrypt calls.
+type KeyProvider interface {
+	// GenerateDataKey returns a fresh 32-byte data key, its wrapped form
+	// and the id of the key that wrapped it.
+	GenerateDataKey(ctx context.Context) (plaintext, wrapped []byte, keyID string, err error)
+	// UnwrapDataKey recovers a data key produced by GenerateDataKey.
+	UnwrapDataKey(ctx context.Context, wrapped []byte, keyID string) ([]byte, error)
+}
+
+// StaticKeyProvider wraps data keys with in-process AES-256 key
+// encryption keys. New data keys are wrapped with the active key; older
+// keys can be added so objects written before a rotation stay readable.
+type StaticKeyProvider struct {
+	mu     sync.RWMutex
+	active string
+	keys   map[string]cipher.AEAD
+}
+
+// NewStaticKeyProvider returns a StaticKeyProvider whose active key
+// encryption key is kek (32 bytes), identified by keyID.
+func NewStaticKeyProvider(keyID string, kek []byte) (*StaticKeyProvider, error) {
+	p := &StaticKeyProvider{active: keyID, keys: make(map[string]cipher.AEAD)}
+	if err := p.AddKey(keyID, kek); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// AddKey registers an additional key encryption key for unwrapping.
+func (p *StaticKeyProvider) AddKey(keyID string, kek []byte) error {
+	if len(kek) != 32 {
+		return fmt.Errorf("s3: key %q must be 32 bytes, got %d", keyID, len(kek))
+	}
+	aead, err := newGCM(kek)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.keys[keyID] = aead
+	p.mu.Unlock()
+	return nil
+}
+
+// GenerateDataKey implements KeyProvider.
+func (p *StaticKeyProvider) GenerateDataKey(_ context.Context) ([]byte, []byte, string, error) {
+	p.mu.RLock()
+	aead, keyID := p.keys[p.active], p.active
+	p.mu.RUnlock()
+	dataKey := make([]byte, 32)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, "", err
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, "", err
+	}
+	wrapped := aead.Seal(nonce, nonce, dataKey, []byte(keyID))
+	return dataKey, wrapped, keyID, nil
+}
+
+// UnwrapDataKey implements KeyProvider.
+func (p *StaticKeyProvider) UnwrapDataKey(_ context.Context, wrapped []byte, keyID string) ([]byte, error) {
+	p.mu.RLock()
+	aead, ok := p.keys[keyID]
+	p.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("s3: unknown key id %q", keyID)
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("s3: wrapped data key too short")
+	}
+	n := aead.NonceSize()
+	dataKey, err := aead.Open(nil, wrapped[:n], wrapped[n:], []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("s3: unwrap data key: %w", err)
+	}
+	return dataKey, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// envelopeCodec implements EncryptionAES256GCM. The ciphertext is a
+// sequence of GCM segments of encSegmentSize plaintext bytes each; the
+// last segment is shorter (possibly empty) and is sealed with a "final"
+// flag as additional data, so truncation and reordering are detected.
+type envelopeCodec struct {
+	keys KeyProvider
+}
+
+func (c *envelopeCodec) Name() string { return EncryptionAES256GCM }
+
+func (c *envelopeCodec) NewWriter(ctx context.Context, w io.Writer, meta map[string]string) (io.WriteCloser, error) {
+	dataKey, wrapped, keyID, err := c.keys.GenerateDataKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	iv := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	meta[MetaWrappedKey] = base64.StdEncoding.EncodeToString(wrapped)
+	meta[MetaKeyID] = keyID
+	meta[MetaIV] = base64.StdEncoding.EncodeToString(iv)
+	return &segmentWriter{w: w, aead: aead, iv: iv}, nil
+}
+
+func (c *envelopeCodec) NewReader(ctx context.Context, r io.Reader, meta map[string]string) (io.ReadCloser, error) {
+	wrapped, err := base64.StdEncoding.DecodeString(meta[MetaWrappedKey])
+	if err != nil || len(wrapped) == 0 {
+		return nil, errors.New("s3: encrypted object has no wrapped data key")
+	}
+	iv, err := base64.StdEncoding.DecodeString(meta[MetaIV])
+	if err != nil {
+		return nil, errors.New("s3: encrypted object has an invalid iv")
+	}
+	dataKey, err := c.keys.UnwrapDataKey(ctx, wrapped, meta[MetaKeyID])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != aead.NonceSize() {
+		return nil, errors.New("s3: encrypted object has an invalid iv")
+	}
+	return &segmentReader{r: r, aead: aead, iv: iv, buf: make([]byte, encSegmentSize+aead.Overhead())}, nil
+}
+
+// segmentNonce derives the nonce of segment seq from the object iv.
+func segmentNonce(iv []byte, seq uint64) []byte {
+	nonce := make([]byte, len(iv))
+	copy(nonce, iv)
+	tail := binary.BigEndian.Uint64(nonce[len(nonce)-8:])
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], tail^seq)
+	return nonce
+}
+
+var (
+	segmentMore  = []byte{0}
+	segmentFinal = []byte{1}
+)
+
+type segmentWriter struct {
+	w    io.Writer
+	aead cipher.AEAD
+	iv   []byte
+	seq  uint64
+	buf  []byte
+}
+
+func (s *segmentWriter) Write(p []byte) (int, error) {
+	s.buf = append(s.buf, p...)
+	for len(s.buf) >= encSegmentSize {
+		if err := s.seal(s.buf[:encSegmentSize], segmentMore); err != nil {
+			return 0, err
+		}
+		s.buf = s.buf[encSegmentSize:]
+	}
+	return len(p), nil
+}
+
+func (s *segmentWriter) Close() error {
+	err := s.seal(s.buf, segmentFinal)
+	s.buf = nil
+	return err
+}
+
+func (s *segmentWriter) seal(plain, flag []byte) error {
+	sealed := s.aead.Seal(nil, segmentNonce(s.iv, s.seq), plain, flag)
+	s.seq++
+	_, err := s.w.Write(sealed)
+	return err
+}
+
+type segmentReader struct {
+	r     io.Reader
+	aead  cipher.AEAD
+	iv    []byte
+	seq   uint64
+	buf   []byte
+	plain []byte
+	done  bool
+}
+
+func (s *segmentReader) Read(p []byte) (int, error) {
+	for len(s.plain) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		if err := s.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, s.plain)
+	s.plain = s.plain[n:]
+	return n, nil
+}
+
+// next reads and opens one segment. A full-size segment is never the last
+// one; a short read marks the final segment.
+func (s *segmentReader) next() error {
+	n, err := io.ReadFull(s.r, s.buf)
+	flag := segmentMore
+	switch {
+	case err == nil:
+	case errors.Is(err, io.ErrUnexpectedEOF) && n >= s.aead.Overhead():
+		flag = segmentFinal
+		s.done = true
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return errors.New("s3: encrypted object is truncated")
+	default:
+		return err
+	}
+	plain, err := s.aead.Open(s.buf[:0], segmentNonce(s.iv, s.seq), s.buf[:n], flag)
+	if err != nil {
+		return fmt.Errorf("s3: decrypt segment %d: %w", s.seq, err)
+	}
+	s.seq++
+	s.plain = plain
+	return nil
+}
+
+func (s *segmentReader) Close() error { return nil }
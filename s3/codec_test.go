@@ -0,0 +1,277 @@
+package s3
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"oss.nandlabs.io/golly-aws/awscfg"
+)
+
+// withCodecOptions registers co for bucket for the duration of the test.
+func withCodecOptions(t *testing.T, bucket string, co *CodecOptions) {
+	t.Helper()
+	CodecManager.Register(bucket, co)
+	t.Cleanup(func() { CodecManager.Unregister(bucket) })
+}
+
+func writeVFS(t *testing.T, raw, content string) {
+	t.Helper()
+	f, err := storageFs.CreateRaw(raw)
+	if err != nil {
+		t.Fatalf("Create %s: %v", raw, err)
+	}
+	if _, err := f.Write([]byte(content)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+func readVFS(t *testing.T, raw string) (string, error) {
+	t.Helper()
+	f, err := storageFs.OpenRaw(raw)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+	b, err := io.ReadAll(f)
+	return string(b), err
+}
+
+func testKeyProvider(t *testing.T, keyID string, fill byte) *StaticKeyProvider {
+	t.Helper()
+	p, err := NewStaticKeyProvider(keyID, bytes.Repeat([]byte{fill}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestCodec_GzipByExtension(t *testing.T) {
+	_, client := newMemoryClient(t, "codec-gz")
+	withCodecOptions(t, "codec-gz", &CodecOptions{ByExtension: true})
+	ctx := context.Background()
+	content := strings.Repeat("GET /index.html 200\n", 500)
+
+	writeVFS(t, "s3://codec-gz/logs/access.log.gz", content)
+
+	head, err := client.HeadObject(ctx, &awss3.HeadObjectInput{Bucket: aws.String("codec-gz"), Key: aws.String("logs/access.log.gz")})
+	if err != nil {
+		t.Fatalf("HeadObject: %v", err)
+	}
+	if aws.ToString(head.ContentEncoding) != "gzip" || head.Metadata[MetaCodec] != "gzip" {
+		t.Fatalf("encoding = %q, metadata = %v", aws.ToString(head.ContentEncoding), head.Metadata)
+	}
+	if aws.ToInt64(head.ContentLength) >= int64(len(content)) {
+		t.Fatalf("object was not compressed: %d bytes", aws.ToInt64(head.ContentLength))
+	}
+
+	got, err := readVFS(t, "s3://codec-gz/logs/access.log.gz")
+	if err != nil || got != content {
+		t.Fatalf("read back %d bytes, %v", len(got), err)
+	}
+	f, _ := storageFs.OpenRaw("s3://codec-gz/logs/access.log.gz")
+	info, err := f.Info()
+	if err != nil || info.Size() != int64(len(content)) {
+		t.Fatalf("Info().Size() = %d, %v", info.Size(), err)
+	}
+
+	// Keys without a known extension are stored as-is.
+	writeVFS(t, "s3://codec-gz/plain.txt", "plain")
+	if got := getString(t, client, &awss3.GetObjectInput{Bucket: aws.String("codec-gz"), Key: aws.String("plain.txt")}); got != "plain" {
+		t.Fatalf("plain.txt = %q", got)
+	}
+}
+
+func TestCodec_DecodesForeignContentEncoding(t *testing.T) {
+	_, client := newMemoryClient(t, "codec-foreign")
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, _ = zw.Write([]byte("written elsewhere"))
+	_ = zw.Close()
+	_, err := client.PutObject(context.Background(), &awss3.PutObjectInput{
+		Bucket:          aws.String("codec-foreign"),
+		Key:             aws.String("data.json"),
+		Body:            bytes.NewReader(gz.Bytes()),
+		ContentEncoding: aws.String("gzip"),
+	})
+	if err != nil {
+		t.Fatalf("PutObject: %v", err)
+	}
+
+	// Without codec options the stored bytes come back untouched.
+	raw, err := readVFS(t, "s3://codec-foreign/data.json")
+	if err != nil || raw != gz.String() {
+		t.Fatalf("raw read = %q, %v", raw, err)
+	}
+	withCodecOptions(t, "codec-foreign", &CodecOptions{ByExtension: true})
+	got, err := readVFS(t, "s3://codec-foreign/data.json")
+	if err != nil || got != "written elsewhere" {
+		t.Fatalf("decoded read = %q, %v", got, err)
+	}
+}
+
+func TestCodec_EnvelopeEncryption(t *testing.T) {
+	_, client := newMemoryClient(t, "codec-enc")
+	keys := testKeyProvider(t, "key-1", 0x11)
+	withCodecOptions(t, "codec-enc", &CodecOptions{Compression: CompressionGzip, KeyProvider: keys})
+	ctx := context.Background()
+	// Spans several GCM segments.
+	content := strings.Repeat("sensitive record\n", 3*encSegmentSize/17*4)
+
+	writeVFS(t, "s3://codec-enc/records.bin", content)
+
+	out, err := client.GetObject(ctx, &awss3.GetObjectInput{Bucket: aws.String("codec-enc"), Key: aws.String("records.bin")})
+	if err != nil {
+		t.Fatalf("GetObject: %v", err)
+	}
+	stored, _ := io.ReadAll(out.Body)
+	_ = out.Body.Close()
+	if bytes.Contains(stored, []byte("sensitive")) || out.ContentEncoding != nil {
+		t.Fatalf("object stored in the clear or with Content-Encoding %q", aws.ToString(out.ContentEncoding))
+	}
+	if out.Metadata[MetaCodec] != "gzip,aes256-gcm" || out.Metadata[MetaKeyID] != "key-1" || out.Metadata[MetaWrappedKey] == "" {
+		t.Fatalf("metadata = %v", out.Metadata)
+	}
+
+	got, err := readVFS(t, "s3://codec-enc/records.bin")
+	if err != nil || got != content {
+		t.Fatalf("read back %d bytes, %v", len(got), err)
+	}
+	f, _ := storageFs.OpenRaw("s3://codec-enc/records.bin")
+	part, err := f.(*S3File).ReadRange(ctx, 17, 16)
+	if err != nil || string(part) != "sensitive record" {
+		t.Fatalf("ReadRange = %q, %v", part, err)
+	}
+
+	// After rotation the old key still unwraps existing objects.
+	rotated := testKeyProvider(t, "key-2", 0x22)
+	if err := rotated.AddKey("key-1", bytes.Repeat([]byte{0x11}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	withCodecOptions(t, "codec-enc", &CodecOptions{KeyProvider: rotated})
+	if got, err := readVFS(t, "s3://codec-enc/records.bin"); err != nil || got != content {
+		t.Fatalf("read after rotation: %d bytes, %v", len(got), err)
+	}
+
+	CodecManager.Unregister("codec-enc")
+	if _, err := readVFS(t, "s3://codec-enc/records.bin"); !errors.Is(err, ErrNoKeyProvider) {
+		t.Fatalf("read without key provider = %v", err)
+	}
+}
+
+func TestCodec_SegmentTamperingDetected(t *testing.T) {
+	keys := testKeyProvider(t, "k", 0x33)
+	c := &envelopeCodec{keys: keys}
+	ctx := context.Background()
+	meta := map[string]string{}
+	var buf bytes.Buffer
+	w, err := c.NewWriter(ctx, &buf, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = w.Write(bytes.Repeat([]byte("x"), encSegmentSize+10))
+	_ = w.Close()
+	sealed := buf.Bytes()
+
+	decode := func(b []byte) error {
+		r, err := c.NewReader(ctx, bytes.NewReader(b), meta)
+		if err != nil {
+			return err
+		}
+		_, err = io.ReadAll(r)
+		return err
+	}
+	if err := decode(sealed); err != nil {
+		t.Fatalf("intact stream: %v", err)
+	}
+	// Dropping the final segment must not look like a shorter object.
+	if err := decode(sealed[:encSegmentSize+16]); err == nil {
+		t.Fatalf("truncated stream decoded without error")
+	}
+	flipped := append([]byte(nil), sealed...)
+	flipped[10] ^= 1
+	if err := decode(flipped); err == nil {
+		t.Fatalf("modified stream decoded without error")
+	}
+}
+
+func TestCodec_UnknownCodec(t *testing.T) {
+	_, client := newMemoryClient(t, "codec-unknown")
+	_, err := client.PutObject(context.Background(), &awss3.PutObjectInput{
+		Bucket:   aws.String("codec-unknown"),
+		Key:      aws.String("f.zst"),
+		Body:     strings.NewReader("?"),
+		Metadata: map[string]string{MetaCodec: "zstd"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readVFS(t, "s3://codec-unknown/f.zst"); !errors.Is(err, ErrUnknownCodec) {
+		t.Fatalf("read = %v", err)
+	}
+}
+
+// storedObject returns the bytes and metadata of an object as stored.
+func storedObject(t *testing.T, client *awss3.Client, bucket, key string) (string, map[string]string) {
+	t.Helper()
+	out, err := client.GetObject(context.Background(), &awss3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		t.Fatalf("GetObject %s/%s: %v", bucket, key, err)
+	}
+	defer func() { _ = out.Body.Close() }()
+	b, _ := io.ReadAll(out.Body)
+	return string(b), out.Metadata
+}
+
+func TestCodec_CopyEncryptedObject(t *testing.T) {
+	mem, client := newMemoryClient(t, "copy-enc")
+	if err := mem.CreateBucket("copy-plain"); err != nil {
+		t.Fatal(err)
+	}
+	awscfg.Manager.Register("copy-plain", mem.Config("us-east-1"))
+	t.Cleanup(func() { awscfg.Manager.Unregister("copy-plain") })
+	withCodecOptions(t, "copy-enc", &CodecOptions{Compression: CompressionGzip, KeyProvider: testKeyProvider(t, "key-1", 0x11)})
+	content := strings.Repeat("card 4111 1111 1111 1111\n", 100)
+	writeVFS(t, "s3://copy-enc/in/a.txt", content)
+
+	// Within the bucket the stored object and its envelope are copied.
+	if err := storageFs.CopyRaw("s3://copy-enc/in/a.txt", "s3://copy-enc/in/b.txt"); err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+	if stored, meta := storedObject(t, client, "copy-enc", "in/b.txt"); strings.Contains(stored, "card") || meta[MetaWrappedKey] == "" {
+		t.Fatalf("copy stored in the clear or without its envelope: %v", meta)
+	}
+	if got, err := readVFS(t, "s3://copy-enc/in/b.txt"); err != nil || got != content {
+		t.Fatalf("read copy: %d bytes, %v", len(got), err)
+	}
+
+	// Into a bucket without codecs the object is decrypted and written
+	// plain; a directory copy goes through the same path.
+	if err := storageFs.CopyRaw("s3://copy-enc/in/", "s3://copy-plain/out/"); err != nil {
+		t.Fatalf("Copy to plain bucket: %v", err)
+	}
+	stored, meta := storedObject(t, client, "copy-plain", "out/a.txt")
+	if stored != content || meta[MetaCodec] != "" || meta[MetaWrappedKey] != "" {
+		t.Fatalf("plain copy = %d bytes, metadata %v", len(stored), meta)
+	}
+
+	// And back, it is encrypted again under a fresh data key.
+	if err := storageFs.CopyRaw("s3://copy-plain/out/a.txt", "s3://copy-enc/c.txt"); err != nil {
+		t.Fatalf("Copy to encrypted bucket: %v", err)
+	}
+	if stored, meta := storedObject(t, client, "copy-enc", "c.txt"); strings.Contains(stored, "card") || meta[MetaCodec] != "gzip,aes256-gcm" {
+		t.Fatalf("copy back stored in the clear: %v", meta)
+	}
+	if got, err := readVFS(t, "s3://copy-enc/c.txt"); err != nil || got != content {
+		t.Fatalf("read copy back: %d bytes, %v", len(got), err)
+	}
+}
@@ -11,4 +11,11 @@
 //
 //	// Then use via the VFS manager:
 //	file, err := vfs.GetManager().OpenRaw("s3://my-bucket/path/to/file.txt")
+//
+// For hermetic tests, register a MemoryBackend config instead of a real
+// endpoint:
+//
+//	mem := s3.NewMemoryBackend()
+//	_ = mem.CreateBucket("my-bucket")
+//	awscfg.Manager.Register("my-bucket", mem.Config("us-east-1"))
 package s3
@@ -0,0 +1,1399 @@
+package s3
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"oss.nandlabs.io/golly-aws/awscfg"
+)
+
+const (
+	// MemoryEndpoint is the base endpoint a MemoryBackend config points SDK
+	// clients at. Requests never leave the process — the backend's HTTP
+	// client serves them in-memory — so the host is purely cosmetic.
+	MemoryEndpoint = "http://s3.memory.local"
+
+	// memMinPartSize is the S3 minimum size for every part of a multipart
+	// upload except the last one.
+	memMinPartSize = 5 * 1024 * 1024
+	// memMaxKeys is the S3 page-size cap for ListObjectsV2.
+	memMaxKeys = 1000
+	// memDefaultRegion is the region recorded for buckets created without
+	// a LocationConstraint (matching S3's us-east-1 convention).
+	memDefaultRegion = "us-east-1"
+
+	s3XMLNamespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+)
+
+// bucketNamePattern matches the general-purpose S3 bucket naming rules:
+// 3-63 chars, lowercase letters, digits, dots and hyphens, starting and
+// ending with a letter or digit.
+var bucketNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// MemoryBackend is an in-memory, S3-compatible object store. It speaks the
+// S3 REST protocol (path-style addressing) as an http.Handler, so the real
+// AWS SDK client — and therefore the whole s3 VFS — can run against it
+// without LocalStack or network access.
+//
+// Supported: bucket create/delete/head/list/location, bucket lifecycle,
+// CORS, versioning, encryption, policy and public-access-block documents,
+// object put/get/head/delete/copy with user metadata, ETags (MD5,
+// multipart-style for completed uploads), ranged GETs, If-Match /
+// If-None-Match / If-(Un)Modified-Since preconditions, multi-object delete,
+// multipart uploads, ListObjectsV2 pagination with prefixes,
+// delimiters, StartAfter and continuation tokens, access points (by ARN
+// or alias), directory bucket sessions and requester-pays buckets.
+//
+// Register it through awscfg so the s3 package resolves it like any other
+// endpoint:
+//
+//	mem := s3.NewMemoryBackend()
+//	_ = mem.CreateBucket("my-bucket")
+//	awscfg.Manager.Register("s3", mem.Config("us-east-1"))
+//
+// A MemoryBackend is safe for concurrent use. It can also be mounted on a
+// real listener (for example httptest.NewServer(mem)) when another process
+// needs to reach it.
+type MemoryBackend struct {
+	mu      sync.Mutex
+	buckets map[string]*memBucket
+	uploads map[string]*memUpload
+	// accessPoints maps access point ARNs, aliases and virtual host labels
+	// ("name-account") to the bucket they front.
+	accessPoints map[string]string
+	seq          int64
+	now          func() time.Time
+}
+
+// memBucket is a single in-memory bucket.
+type memBucket struct {
+	name    string
+	region  string
+	created time.Time
+	objects map[string]*memObject
+	// configs holds bucket sub-resource documents (lifecycle, cors, …)
+	// exactly as they were PUT; S3 returns the same shapes on GET.
+	configs map[string][]byte
+	// requesterPays rejects requests without x-amz-request-payer.
+	requesterPays bool
+}
+
+// memObject is a stored object version (the backend keeps only the latest).
+type memObject struct {
+	data         []byte
+	etag         string
+	lastModified time.Time
+	headers      memObjectHeaders
+	metadata     map[string]string
+}
+
+// memObjectHeaders are the standard HTTP headers S3 persists with an object.
+type memObjectHeaders struct {
+	contentType        string
+	contentEncoding    string
+	contentDisposition string
+	contentLanguage    string
+	cacheControl       string
+}
+
+// memUpload is an in-progress multipart upload.
+type memUpload struct {
+	bucket   string
+	key      string
+	headers  memObjectHeaders
+	metadata map[string]string
+	parts    map[int]*memPart
+}
+
+// memPart is a single uploaded part.
+type memPart struct {
+	data []byte
+	etag string
+}
+
+// NewMemoryBackend creates an empty in-memory S3 backend.
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		buckets:      make(map[string]*memBucket),
+		uploads:      make(map[string]*memUpload),
+		accessPoints: make(map[string]string),
+		now:          time.Now,
+	}
+}
+
+// CreateBucket creates a bucket directly, without going through the S3 API.
+// Handy for test setup. Returns an error if the name is invalid or taken.
+func (b *MemoryBackend) CreateBucket(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if e := b.createBucketLocked(name, ""); e != nil {
+		return fmt.Errorf("s3: %s: %s", e.code, e.message)
+	}
+	return nil
+}
+
+// HTTPClient returns an HTTP client that serves every request in-process
+// from this backend, regardless of the request host.
+func (b *MemoryBackend) HTTPClient() *http.Client {
+	return &http.Client{Transport: &handlerTransport{handler: b}}
+}
+
+// Config returns an awscfg.Config wired to this backend: the memory
+// endpoint (which also switches the s3 package to path-style addressing),
+// static dummy credentials, and the in-process HTTP client. Register the
+// result with awscfg.Manager under "s3" or a bucket name.
+func (b *MemoryBackend) Config(region string) *awscfg.Config {
+	cfg := awscfg.NewConfig(region)
+	cfg.SetEndpoint(MemoryEndpoint)
+	cfg.SetStaticCredentials("memory", "memory", "")
+	cfg.SetHTTPClient(b.HTTPClient())
+	return cfg
+}
+
+// handlerTransport is an http.RoundTripper that dispatches requests to an
+// http.Handler in-process.
+type handlerTransport struct {
+	handler http.Handler
+}
+
+// RoundTrip serves req from the wrapped handler and returns the recorded
+// response.
+func (t *handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+	rec := &responseRecorder{header: make(http.Header)}
+	t.handler.ServeHTTP(rec, req)
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	contentLength := int64(rec.body.Len())
+	if v := rec.header.Get("Content-Length"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			contentLength = n
+		}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", rec.status, http.StatusText(rec.status)),
+		StatusCode:    rec.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        rec.header,
+		Body:          io.NopCloser(bytes.NewReader(rec.body.Bytes())),
+		ContentLength: contentLength,
+		Request:       req,
+	}, nil
+}
+
+// responseRecorder is a minimal http.ResponseWriter that buffers the
+// response for handlerTransport.
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) Header() http.Header { return r.header }
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(p)
+}
+
+// memError is an S3 error response.
+type memError struct {
+	status  int
+	code    string
+	message string
+}
+
+func newMemError(status int, code, message string) *memError {
+	return &memError{status: status, code: code, message: message}
+}
+
+func errNoSuchBucket(bucket string) *memError {
+	return newMemError(http.StatusNotFound, "NoSuchBucket", fmt.Sprintf("The specified bucket %s does not exist", bucket))
+}
+
+func errNoSuchKey() *memError {
+	return newMemError(http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+}
+
+func errNoSuchUpload() *memError {
+	return newMemError(http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist.")
+}
+
+func errPreconditionFailed() *memError {
+	return newMemError(http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold")
+}
+
+// ServeHTTP implements http.Handler using S3 path-style routing:
+// "/" for the service, "/bucket" for bucket operations and "/bucket/key"
+// for object operations. Sub-resources are selected by query parameters.
+// Virtual-hosted requests to a subdomain of the memory endpoint (used for
+// access point ARNs and directory buckets) are routed the same way.
+func (b *MemoryBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key := b.routeRequest(r)
+	q := r.URL.Query()
+
+	var e *memError
+	if bucket != "" {
+		e = b.checkRequestPayer(w, r, bucket)
+	}
+	switch {
+	case e != nil:
+	case bucket == "":
+		if r.Method != http.MethodGet {
+			e = newMemError(http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource.")
+			break
+		}
+		e = b.listBuckets(w)
+	case key == "":
+		e = b.serveBucket(w, r, bucket, q)
+	default:
+		e = b.serveObject(w, r, bucket, key, q)
+	}
+	if e != nil {
+		writeMemError(w, r, e)
+	}
+}
+
+// serveBucket dispatches bucket-level operations.
+func (b *MemoryBackend) serveBucket(w http.ResponseWriter, r *http.Request, bucket string, q url.Values) *memError {
+	for name := range memBucketConfigs {
+		if q.Has(name) {
+			return b.serveBucketConfig(w, r, bucket, name)
+		}
+	}
+	switch r.Method {
+	case http.MethodPut:
+		return b.createBucket(w, r, bucket)
+	case http.MethodDelete:
+		return b.deleteBucket(w, bucket)
+	case http.MethodHead:
+		return b.headBucket(w, bucket)
+	case http.MethodPost:
+		if q.Has("delete") {
+			return b.deleteObjects(w, r, bucket)
+		}
+	case http.MethodGet:
+		if q.Has("location") {
+			return b.getBucketLocation(w, bucket)
+		}
+		if q.Has("session") {
+			return b.createSession(w, bucket)
+		}
+		return b.listObjectsV2(w, bucket, q)
+	}
+	return newMemError(http.StatusNotImplemented, "NotImplemented", "A header or query you provided implies functionality that is not implemented.")
+}
+
+// serveObject dispatches object-level operations.
+func (b *MemoryBackend) serveObject(w http.ResponseWriter, r *http.Request, bucket, key string, q url.Values) *memError {
+	switch r.Method {
+	case http.MethodPut:
+		if q.Has("uploadId") {
+			return b.uploadPart(w, r, bucket, key, q)
+		}
+		if r.Header.Get("x-amz-copy-source") != "" {
+			return b.copyObject(w, r, bucket, key)
+		}
+		return b.putObject(w, r, bucket, key)
+	case http.MethodGet, http.MethodHead:
+		return b.getObject(w, r, bucket, key)
+	case http.MethodDelete:
+		if q.Has("uploadId") {
+			return b.abortMultipartUpload(w, bucket, key, q.Get("uploadId"))
+		}
+		return b.deleteObject(w, r, bucket, key)
+	case http.MethodPost:
+		if q.Has("uploads") {
+			return b.createMultipartUpload(w, r, bucket, key)
+		}
+		if q.Has("uploadId") {
+			return b.completeMultipartUpload(w, r, bucket, key, q.Get("uploadId"))
+		}
+	}
+	return newMemError(http.StatusNotImplemented, "NotImplemented", "A header or query you provided implies functionality that is not implemented.")
+}
+
+// ---- Buckets ----------------------------------------------------------------
+
+type memCreateBucketConfiguration struct {
+	LocationConstraint string `xml:"LocationConstraint"`
+}
+
+func (b *MemoryBackend) createBucket(w http.ResponseWriter, r *http.Request, bucket string) *memError {
+	body, e := readMemBody(r)
+	if e != nil {
+		return e
+	}
+	var conf memCreateBucketConfiguration
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := xml.Unmarshal(body, &conf); err != nil {
+			return newMemError(http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed.")
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if e := b.createBucketLocked(bucket, conf.LocationConstraint); e != nil {
+		return e
+	}
+	w.Header().Set("Location", "/"+bucket)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (b *MemoryBackend) createBucketLocked(bucket, region string) *memError {
+	if !bucketNamePattern.MatchString(bucket) || strings.Contains(bucket, "..") {
+		return newMemError(http.StatusBadRequest, "InvalidBucketName", "The specified bucket is not valid.")
+	}
+	if _, ok := b.buckets[bucket]; ok {
+		return newMemError(http.StatusConflict, "BucketAlreadyOwnedByYou", "Your previous request to create the named bucket succeeded and you already own it.")
+	}
+	if region == "" {
+		region = memDefaultRegion
+	}
+	b.buckets[bucket] = &memBucket{
+		name:    bucket,
+		region:  region,
+		created: b.now().UTC(),
+		objects: make(map[string]*memObject),
+		configs: make(map[string][]byte),
+	}
+	return nil
+}
+
+func (b *MemoryBackend) deleteBucket(w http.ResponseWriter, bucket string) *memError {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bk, ok := b.buckets[bucket]
+	if !ok {
+		return errNoSuchBucket(bucket)
+	}
+	if len(bk.objects) > 0 {
+		return newMemError(http.StatusConflict, "BucketNotEmpty", "The bucket you tried to delete is not empty")
+	}
+	delete(b.buckets, bucket)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (b *MemoryBackend) headBucket(w http.ResponseWriter, bucket string) *memError {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bk, ok := b.buckets[bucket]
+	if !ok {
+		return errNoSuchBucket(bucket)
+	}
+	w.Header().Set("x-amz-bucket-region", bk.region)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+type memLocationConstraint struct {
+	XMLName xml.Name `xml:"LocationConstraint"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Value   string   `xml:",chardata"`
+}
+
+func (b *MemoryBackend) getBucketLocation(w http.ResponseWriter, bucket string) *memError {
+	b.mu.Lock()
+	bk, ok := b.buckets[bucket]
+	var region string
+	if ok {
+		region = bk.region
+	}
+	b.mu.Unlock()
+	if !ok {
+		return errNoSuchBucket(bucket)
+	}
+	if region == memDefaultRegion {
+		// S3 reports us-east-1 as an empty constraint.
+		region = ""
+	}
+	writeMemXML(w, http.StatusOK, memLocationConstraint{Xmlns: s3XMLNamespace, Value: region})
+	return nil
+}
+
+type memListBucketsResult struct {
+	XMLName xml.Name        `xml:"ListAllMyBucketsResult"`
+	Xmlns   string          `xml:"xmlns,attr"`
+	Owner   memOwner        `xml:"Owner"`
+	Buckets []memBucketInfo `xml:"Buckets>Bucket"`
+}
+
+type memOwner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+type memBucketInfo struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+	BucketRegion string `xml:"BucketRegion"`
+}
+
+func (b *MemoryBackend) listBuckets(w http.ResponseWriter) *memError {
+	b.mu.Lock()
+	result := memListBucketsResult{Xmlns: s3XMLNamespace, Owner: memOwner{ID: "memory", DisplayName: "memory"}}
+	for _, bk := range b.buckets {
+		result.Buckets = append(result.Buckets, memBucketInfo{
+			Name:         bk.name,
+			CreationDate: formatMemTime(bk.created),
+			BucketRegion: bk.region,
+		})
+	}
+	b.mu.Unlock()
+	sort.Slice(result.Buckets, func(i, j int) bool { return result.Buckets[i].Name < result.Buckets[j].Name })
+	writeMemXML(w, http.StatusOK, result)
+	return nil
+}
+
+// memBucketConfig describes how a bucket sub-resource behaves when unset.
+type memBucketConfig struct {
+	// missing is returned by GET when nothing is stored; nil means the
+	// sub-resource reports an empty document instead.
+	missing *memError
+	// empty is the GET body for unset sub-resources that never 404.
+	empty       string
+	contentType string
+	deletable   bool
+}
+
+// memBucketConfigs are the bucket sub-resources the backend stores.
+var memBucketConfigs = map[string]memBucketConfig{
+	"lifecycle": {
+		missing:     newMemError(http.StatusNotFound, "NoSuchLifecycleConfiguration", "The lifecycle configuration does not exist"),
+		contentType: "application/xml",
+		deletable:   true,
+	},
+	"cors": {
+		missing:     newMemError(http.StatusNotFound, "NoSuchCORSConfiguration", "The CORS configuration does not exist"),
+		contentType: "application/xml",
+		deletable:   true,
+	},
+	"encryption": {
+		missing:     newMemError(http.StatusNotFound, "ServerSideEncryptionConfigurationNotFoundError", "The server side encryption configuration was not found"),
+		contentType: "application/xml",
+		deletable:   true,
+	},
+	"policy": {
+		missing:     newMemError(http.StatusNotFound, "NoSuchBucketPolicy", "The bucket policy does not exist"),
+		contentType: "application/json",
+		deletable:   true,
+	},
+	"publicAccessBlock": {
+		missing:     newMemError(http.StatusNotFound, "NoSuchPublicAccessBlockConfiguration", "The public access block configuration was not found"),
+		contentType: "application/xml",
+		deletable:   true,
+	},
+	"versioning": {
+		empty:       xml.Header + `<VersioningConfiguration xmlns="` + s3XMLNamespace + `"></VersioningConfiguration>`,
+		contentType: "application/xml",
+	},
+}
+
+// serveBucketConfig handles GET/PUT/DELETE on a bucket sub-resource.
+func (b *MemoryBackend) serveBucketConfig(w http.ResponseWriter, r *http.Request, bucket, name string) *memError {
+	conf := memBucketConfigs[name]
+	var body []byte
+	if r.Method == http.MethodPut {
+		var e *memError
+		if body, e = readMemBody(r); e != nil {
+			return e
+		}
+		if name == "policy" {
+			if !json.Valid(body) {
+				return newMemError(http.StatusBadRequest, "MalformedPolicy", "Policies must be valid JSON")
+			}
+		} else if err := xml.Unmarshal(body, new(struct{})); err != nil {
+			return newMemError(http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed.")
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bk, ok := b.buckets[bucket]
+	if !ok {
+		return errNoSuchBucket(bucket)
+	}
+	switch r.Method {
+	case http.MethodGet:
+		doc, ok := bk.configs[name]
+		if !ok {
+			if conf.missing != nil {
+				return conf.missing
+			}
+			doc = []byte(conf.empty)
+		}
+		w.Header().Set("Content-Type", conf.contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(doc)))
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(doc)
+		return nil
+	case http.MethodPut:
+		bk.configs[name] = body
+		w.WriteHeader(http.StatusOK)
+		return nil
+	case http.MethodDelete:
+		if conf.deletable {
+			delete(bk.configs, name)
+			w.WriteHeader(http.StatusNoContent)
+			return nil
+		}
+	}
+	return newMemError(http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource.")
+}
+
+// ---- Listing ----------------------------------------------------------------
+
+type memListObjectsV2Result struct {
+	XMLName               xml.Name          `xml:"ListBucketResult"`
+	Xmlns                 string            `xml:"xmlns,attr"`
+	Name                  string            `xml:"Name"`
+	Prefix                string            `xml:"Prefix"`
+	Delimiter             string            `xml:"Delimiter,omitempty"`
+	MaxKeys               int               `xml:"MaxKeys"`
+	KeyCount              int               `xml:"KeyCount"`
+	IsTruncated           bool              `xml:"IsTruncated"`
+	ContinuationToken     string            `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string            `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string            `xml:"StartAfter,omitempty"`
+	EncodingType          string            `xml:"EncodingType,omitempty"`
+	Contents              []memListEntry    `xml:"Contents"`
+	CommonPrefixes        []memCommonPrefix `xml:"CommonPrefixes"`
+}
+
+type memListEntry struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type memCommonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+func (b *MemoryBackend) listObjectsV2(w http.ResponseWriter, bucket string, q url.Values) *memError {
+	prefix := q.Get("prefix")
+	delimiter := q.Get("delimiter")
+	token := q.Get("continuation-token")
+	startAfter := q.Get("start-after")
+	encodingType := q.Get("encoding-type")
+
+	maxKeys := memMaxKeys
+	if v := q.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return newMemError(http.StatusBadRequest, "InvalidArgument", "Provided max-keys not an integer or within integer range")
+		}
+		if n < maxKeys {
+			maxKeys = n
+		}
+	}
+
+	// marker is the last key (or common prefix) already returned; listing
+	// resumes strictly after it.
+	marker := startAfter
+	if token != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil {
+			return newMemError(http.StatusBadRequest, "InvalidArgument", "The continuation token provided is incorrect")
+		}
+		marker = string(decoded)
+	}
+
+	b.mu.Lock()
+	bk, ok := b.buckets[bucket]
+	if !ok {
+		b.mu.Unlock()
+		return errNoSuchBucket(bucket)
+	}
+	keys := make([]string, 0, len(bk.objects))
+	for k := range bk.objects {
+		if strings.HasPrefix(k, prefix) && k > marker {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	result := memListObjectsV2Result{
+		Xmlns:             s3XMLNamespace,
+		Name:              bucket,
+		Prefix:            encodeMemKey(prefix, encodingType),
+		Delimiter:         encodeMemKey(delimiter, encodingType),
+		MaxKeys:           maxKeys,
+		ContinuationToken: token,
+		StartAfter:        encodeMemKey(startAfter, encodingType),
+		EncodingType:      encodingType,
+	}
+	lastPrefix := ""
+	last := ""
+	for _, k := range keys {
+		item := k
+		isPrefix := false
+		if delimiter != "" {
+			if idx := strings.Index(k[len(prefix):], delimiter); idx >= 0 {
+				item = k[:len(prefix)+idx+len(delimiter)]
+				isPrefix = true
+			}
+		}
+		if isPrefix && (item == lastPrefix || item <= marker) {
+			// Already emitted this common prefix (on this or a previous page).
+			continue
+		}
+		if result.KeyCount == maxKeys {
+			result.IsTruncated = true
+			break
+		}
+		if isPrefix {
+			result.CommonPrefixes = append(result.CommonPrefixes, memCommonPrefix{Prefix: encodeMemKey(item, encodingType)})
+			lastPrefix = item
+		} else {
+			obj := bk.objects[k]
+			result.Contents = append(result.Contents, memListEntry{
+				Key:          encodeMemKey(k, encodingType),
+				LastModified: formatMemTime(obj.lastModified),
+				ETag:         obj.etag,
+				Size:         int64(len(obj.data)),
+				StorageClass: "STANDARD",
+			})
+		}
+		result.KeyCount++
+		last = item
+	}
+	b.mu.Unlock()
+
+	if result.IsTruncated {
+		result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+	}
+	writeMemXML(w, http.StatusOK, result)
+	return nil
+}
+
+// ---- Objects ----------------------------------------------------------------
+
+func (b *MemoryBackend) putObject(w http.ResponseWriter, r *http.Request, bucket, key string) *memError {
+	body, e := readMemBody(r)
+	if e != nil {
+		return e
+	}
+	if e := checkContentMD5(r, body); e != nil {
+		return e
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bk, ok := b.buckets[bucket]
+	if !ok {
+		return errNoSuchBucket(bucket)
+	}
+	if e := checkWritePreconditions(r, bk.objects[key]); e != nil {
+		return e
+	}
+	obj := &memObject{
+		data:         body,
+		etag:         md5ETag(body),
+		lastModified: b.now().UTC(),
+		headers:      objectHeadersFromRequest(r),
+		metadata:     metadataFromRequest(r),
+	}
+	bk.objects[key] = obj
+	w.Header().Set("ETag", obj.etag)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (b *MemoryBackend) getObject(w http.ResponseWriter, r *http.Request, bucket, key string) *memError {
+	b.mu.Lock()
+	bk, ok := b.buckets[bucket]
+	if !ok {
+		b.mu.Unlock()
+		return errNoSuchBucket(bucket)
+	}
+	obj, ok := bk.objects[key]
+	b.mu.Unlock()
+	if !ok {
+		return errNoSuchKey()
+	}
+
+	if status := checkReadPreconditions(r, obj); status == http.StatusNotModified {
+		w.Header().Set("ETag", obj.etag)
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	} else if status != 0 {
+		return errPreconditionFailed()
+	}
+
+	h := w.Header()
+	writeObjectHeaders(h, obj)
+	h.Set("Accept-Ranges", "bytes")
+
+	data := obj.data
+	status := http.StatusOK
+	if strings.EqualFold(r.Header.Get("x-amz-checksum-mode"), "ENABLED") && r.Header.Get("Range") == "" {
+		// Full-object reads carry a checksum so the SDK can validate them.
+		h.Set("x-amz-checksum-crc32", crc32Checksum(obj.data))
+	}
+	if rng := r.Header.Get("Range"); rng != "" {
+		start, end, ok := parseMemRange(rng, int64(len(obj.data)))
+		if !ok {
+			return newMemError(http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable")
+		}
+		data = obj.data[start : end+1]
+		h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(obj.data)))
+		status = http.StatusPartialContent
+	}
+	h.Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(data)
+	}
+	return nil
+}
+
+func (b *MemoryBackend) deleteObject(w http.ResponseWriter, r *http.Request, bucket, key string) *memError {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bk, ok := b.buckets[bucket]
+	if !ok {
+		return errNoSuchBucket(bucket)
+	}
+	obj, exists := bk.objects[key]
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if !exists {
+			return errNoSuchKey()
+		}
+		if !etagMatches(ifMatch, obj.etag) {
+			return errPreconditionFailed()
+		}
+	}
+	delete(bk.objects, key)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+type memDeleteRequest struct {
+	Quiet   bool `xml:"Quiet"`
+	Objects []struct {
+		Key string `xml:"Key"`
+	} `xml:"Object"`
+}
+
+type memDeleteResult struct {
+	XMLName xml.Name          `xml:"DeleteResult"`
+	Xmlns   string            `xml:"xmlns,attr"`
+	Deleted []memDeletedEntry `xml:"Deleted"`
+}
+
+type memDeletedEntry struct {
+	Key string `xml:"Key"`
+}
+
+func (b *MemoryBackend) deleteObjects(w http.ResponseWriter, r *http.Request, bucket string) *memError {
+	body, e := readMemBody(r)
+	if e != nil {
+		return e
+	}
+	var req memDeleteRequest
+	if err := xml.Unmarshal(body, &req); err != nil {
+		return newMemError(http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed.")
+	}
+	if len(req.Objects) > memMaxKeys {
+		return newMemError(http.StatusBadRequest, "MalformedXML", "The request must contain no more than 1000 keys.")
+	}
+	b.mu.Lock()
+	bk, ok := b.buckets[bucket]
+	if !ok {
+		b.mu.Unlock()
+		return errNoSuchBucket(bucket)
+	}
+	result := memDeleteResult{Xmlns: s3XMLNamespace}
+	for _, o := range req.Objects {
+		delete(bk.objects, o.Key)
+		if !req.Quiet {
+			result.Deleted = append(result.Deleted, memDeletedEntry{Key: o.Key})
+		}
+	}
+	b.mu.Unlock()
+	writeMemXML(w, http.StatusOK, result)
+	return nil
+}
+
+type memCopyObjectResult struct {
+	XMLName      xml.Name `xml:"CopyObjectResult"`
+	Xmlns        string   `xml:"xmlns,attr"`
+	LastModified string   `xml:"LastModified"`
+	ETag         string   `xml:"ETag"`
+}
+
+func (b *MemoryBackend) copyObject(w http.ResponseWriter, r *http.Request, bucket, key string) *memError {
+	srcBucket, srcKey, ok := parseCopySource(r.Header.Get("x-amz-copy-source"))
+	if !ok {
+		return newMemError(http.StatusBadRequest, "InvalidArgument", "Copy Source must mention the source bucket and key: sourcebucket/sourcekey")
+	}
+	replace := strings.EqualFold(r.Header.Get("x-amz-metadata-directive"), "REPLACE")
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if target, ok := b.accessPoints[srcBucket]; ok {
+		srcBucket = target
+	}
+	srcBk, ok := b.buckets[srcBucket]
+	if !ok {
+		return errNoSuchBucket(srcBucket)
+	}
+	src, ok := srcBk.objects[srcKey]
+	if !ok {
+		return errNoSuchKey()
+	}
+	dstBk, ok := b.buckets[bucket]
+	if !ok {
+		return errNoSuchBucket(bucket)
+	}
+	if srcBucket == bucket && srcKey == key && !replace {
+		return newMemError(http.StatusBadRequest, "InvalidRequest", "This copy request is illegal because it is trying to copy an object to itself without changing the object's metadata, storage class, website redirect location or encryption attributes.")
+	}
+	if v := r.Header.Get("x-amz-copy-source-if-match"); v != "" && !etagMatches(v, src.etag) {
+		return errPreconditionFailed()
+	}
+	if v := r.Header.Get("x-amz-copy-source-if-none-match"); v != "" && etagMatches(v, src.etag) {
+		return errPreconditionFailed()
+	}
+	if e := checkWritePreconditions(r, dstBk.objects[key]); e != nil {
+		return e
+	}
+
+	obj := &memObject{
+		data:         src.data,
+		etag:         src.etag,
+		lastModified: b.now().UTC(),
+		headers:      src.headers,
+		metadata:     copyMetadata(src.metadata),
+	}
+	if replace {
+		obj.headers = objectHeadersFromRequest(r)
+		obj.metadata = metadataFromRequest(r)
+	}
+	dstBk.objects[key] = obj
+	writeMemXML(w, http.StatusOK, memCopyObjectResult{
+		Xmlns:        s3XMLNamespace,
+		LastModified: formatMemTime(obj.lastModified),
+		ETag:         obj.etag,
+	})
+	return nil
+}
+
+// ---- Multipart uploads ------------------------------------------------------
+
+type memInitiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+func (b *MemoryBackend) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) *memError {
+	b.mu.Lock()
+	if _, ok := b.buckets[bucket]; !ok {
+		b.mu.Unlock()
+		return errNoSuchBucket(bucket)
+	}
+	b.seq++
+	uploadID := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s/%s/%d", bucket, key, b.seq)))
+	b.uploads[uploadID] = &memUpload{
+		bucket:   bucket,
+		key:      key,
+		headers:  objectHeadersFromRequest(r),
+		metadata: metadataFromRequest(r),
+		parts:    make(map[int]*memPart),
+	}
+	b.mu.Unlock()
+	writeMemXML(w, http.StatusOK, memInitiateMultipartUploadResult{
+		Xmlns:    s3XMLNamespace,
+		Bucket:   bucket,
+		Key:      key,
+		UploadID: uploadID,
+	})
+	return nil
+}
+
+func (b *MemoryBackend) uploadPart(w http.ResponseWriter, r *http.Request, bucket, key string, q url.Values) *memError {
+	partNumber, err := strconv.Atoi(q.Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > 10000 {
+		return newMemError(http.StatusBadRequest, "InvalidArgument", "Part number must be an integer between 1 and 10000, inclusive")
+	}
+	body, e := readMemBody(r)
+	if e != nil {
+		return e
+	}
+	if e := checkContentMD5(r, body); e != nil {
+		return e
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	up, ok := b.uploads[q.Get("uploadId")]
+	if !ok || up.bucket != bucket || up.key != key {
+		return errNoSuchUpload()
+	}
+	part := &memPart{data: body, etag: md5ETag(body)}
+	up.parts[partNumber] = part
+	w.Header().Set("ETag", part.etag)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+type memCompleteMultipartUpload struct {
+	Parts []struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	} `xml:"Part"`
+}
+
+type memCompleteMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
+
+func (b *MemoryBackend) completeMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key, uploadID string) *memError {
+	body, e := readMemBody(r)
+	if e != nil {
+		return e
+	}
+	var req memCompleteMultipartUpload
+	if err := xml.Unmarshal(body, &req); err != nil || len(req.Parts) == 0 {
+		return newMemError(http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema.")
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	up, ok := b.uploads[uploadID]
+	if !ok || up.bucket != bucket || up.key != key {
+		return errNoSuchUpload()
+	}
+	bk, ok := b.buckets[bucket]
+	if !ok {
+		return errNoSuchBucket(bucket)
+	}
+
+	var data bytes.Buffer
+	digests := md5.New()
+	prev := 0
+	for i, p := range req.Parts {
+		if p.PartNumber <= prev {
+			return newMemError(http.StatusBadRequest, "InvalidPartOrder", "The list of parts was not in ascending order. Parts must be ordered by part number.")
+		}
+		prev = p.PartNumber
+		part, ok := up.parts[p.PartNumber]
+		if !ok || !etagMatches(p.ETag, part.etag) {
+			return newMemError(http.StatusBadRequest, "InvalidPart", "One or more of the specified parts could not be found. The part might not have been uploaded, or the specified entity tag might not have matched the part's entity tag.")
+		}
+		if i < len(req.Parts)-1 && len(part.data) < memMinPartSize {
+			return newMemError(http.StatusBadRequest, "EntityTooSmall", "Your proposed upload is smaller than the minimum allowed object size.")
+		}
+		sum := md5.Sum(part.data)
+		digests.Write(sum[:])
+		data.Write(part.data)
+	}
+	if e := checkWritePreconditions(r, bk.objects[key]); e != nil {
+		return e
+	}
+
+	obj := &memObject{
+		data:         data.Bytes(),
+		etag:         fmt.Sprintf("\"%s-%d\"", hex.EncodeToString(digests.Sum(nil)), len(req.Parts)),
+		lastModified: b.now().UTC(),
+		headers:      up.headers,
+		metadata:     up.metadata,
+	}
+	bk.objects[key] = obj
+	delete(b.uploads, uploadID)
+	writeMemXML(w, http.StatusOK, memCompleteMultipartUploadResult{
+		Xmlns:    s3XMLNamespace,
+		Location: fmt.Sprintf("%s/%s/%s", MemoryEndpoint, bucket, key),
+		Bucket:   bucket,
+		Key:      key,
+		ETag:     obj.etag,
+	})
+	return nil
+}
+
+func (b *MemoryBackend) abortMultipartUpload(w http.ResponseWriter, bucket, key, uploadID string) *memError {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	up, ok := b.uploads[uploadID]
+	if !ok || up.bucket != bucket || up.key != key {
+		return errNoSuchUpload()
+	}
+	delete(b.uploads, uploadID)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// ---- Helpers ----------------------------------------------------------------
+
+// splitMemPath splits a path-style request path into bucket and key.
+func splitMemPath(p string) (bucket, key string) {
+	p = strings.TrimPrefix(p, "/")
+	if idx := strings.Index(p, "/"); idx >= 0 {
+		return p[:idx], p[idx+1:]
+	}
+	return p, ""
+}
+
+// parseCopySource parses an x-amz-copy-source header ("bucket/key" or
+// "<access-point-arn>/object/key", URL encoded, optionally with a leading
+// slash and a versionId query).
+func parseCopySource(v string) (bucket, key string, ok bool) {
+	if idx := strings.Index(v, "?"); idx >= 0 {
+		v = v[:idx]
+	}
+	if unescaped, err := url.PathUnescape(v); err == nil {
+		v = unescaped
+	}
+	v = strings.TrimPrefix(v, "/")
+	if strings.HasPrefix(v, "arn:") {
+		if idx := strings.Index(v, "/object/"); idx >= 0 {
+			bucket, key = v[:idx], v[idx+len("/object/"):]
+			return bucket, key, key != ""
+		}
+		return "", "", false
+	}
+	bucket, key = splitMemPath(v)
+	return bucket, key, bucket != "" && key != ""
+}
+
+// readMemBody reads the request payload, decoding the aws-chunked framing
+// the SDK uses for streaming uploads with trailing checksums.
+func readMemBody(r *http.Request) ([]byte, *memError) {
+	if r.Body == nil {
+		return nil, nil
+	}
+	raw, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, newMemError(http.StatusBadRequest, "IncompleteBody", "You did not provide the number of bytes specified by the Content-Length HTTP header.")
+	}
+	if !strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked") &&
+		!strings.HasPrefix(r.Header.Get("x-amz-content-sha256"), "STREAMING-") {
+		return raw, nil
+	}
+	decoded, err := decodeAWSChunked(raw)
+	if err != nil {
+		return nil, newMemError(http.StatusBadRequest, "IncompleteBody", err.Error())
+	}
+	return decoded, nil
+}
+
+// decodeAWSChunked strips aws-chunked framing: "<hex-size>[;ext]\r\n<data>\r\n"
+// repeated, terminated by a zero-size chunk and optional trailer headers.
+func decodeAWSChunked(raw []byte) ([]byte, error) {
+	var out bytes.Buffer
+	rd := bufio.NewReader(bytes.NewReader(raw))
+	for {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return nil, errors.New("malformed aws-chunked payload")
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if idx := strings.Index(line, ";"); idx >= 0 {
+			line = line[:idx]
+		}
+		size, err := strconv.ParseInt(strings.TrimSpace(line), 16, 64)
+		if err != nil {
+			return nil, errors.New("malformed aws-chunked chunk size")
+		}
+		if size == 0 {
+			return out.Bytes(), nil
+		}
+		if _, err := io.CopyN(&out, rd, size); err != nil {
+			return nil, errors.New("truncated aws-chunked payload")
+		}
+		if _, err := rd.ReadString('\n'); err != nil {
+			return nil, errors.New("malformed aws-chunked chunk terminator")
+		}
+	}
+}
+
+// checkContentMD5 verifies the optional Content-MD5 header against body.
+func checkContentMD5(r *http.Request, body []byte) *memError {
+	v := r.Header.Get("Content-MD5")
+	if v == "" {
+		return nil
+	}
+	sum := md5.Sum(body)
+	if v != base64.StdEncoding.EncodeToString(sum[:]) {
+		return newMemError(http.StatusBadRequest, "BadDigest", "The Content-MD5 you specified did not match what we received.")
+	}
+	return nil
+}
+
+// checkWritePreconditions applies S3 conditional-write semantics:
+// If-None-Match: * fails when the key exists; If-Match fails when the key
+// is missing or its ETag differs.
+func checkWritePreconditions(r *http.Request, existing *memObject) *memError {
+	if v := r.Header.Get("If-None-Match"); v != "" && existing != nil {
+		if strings.TrimSpace(v) == "*" || etagMatches(v, existing.etag) {
+			return errPreconditionFailed()
+		}
+	}
+	if v := r.Header.Get("If-Match"); v != "" {
+		if existing == nil {
+			return errNoSuchKey()
+		}
+		if !etagMatches(v, existing.etag) {
+			return errPreconditionFailed()
+		}
+	}
+	return nil
+}
+
+// checkReadPreconditions evaluates GET/HEAD conditional headers. It returns
+// 0 when the request should proceed, 304 for "not modified", or 412.
+func checkReadPreconditions(r *http.Request, obj *memObject) int {
+	lastModified := obj.lastModified.Truncate(time.Second)
+	if v := r.Header.Get("If-Match"); v != "" {
+		if !etagMatches(v, obj.etag) {
+			return http.StatusPreconditionFailed
+		}
+	} else if v := r.Header.Get("If-Unmodified-Since"); v != "" {
+		if t, err := http.ParseTime(v); err == nil && lastModified.After(t) {
+			return http.StatusPreconditionFailed
+		}
+	}
+	if v := r.Header.Get("If-None-Match"); v != "" {
+		if etagMatches(v, obj.etag) {
+			return http.StatusNotModified
+		}
+	} else if v := r.Header.Get("If-Modified-Since"); v != "" {
+		if t, err := http.ParseTime(v); err == nil && !lastModified.After(t) {
+			return http.StatusNotModified
+		}
+	}
+	return 0
+}
+
+// etagMatches compares a (possibly comma-separated, possibly unquoted)
+// conditional-header value against an object's quoted ETag.
+func etagMatches(header, etag string) bool {
+	want := strings.Trim(etag, `"`)
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" || strings.Trim(strings.TrimPrefix(v, "W/"), `"`) == want {
+			return true
+		}
+	}
+	return false
+}
+
+// md5ETag returns the quoted hex MD5 ETag S3 assigns to single-part objects.
+func md5ETag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// crc32Checksum returns the base64 CRC32 checksum S3 reports in
+// x-amz-checksum-crc32.
+func crc32Checksum(data []byte) string {
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.ChecksumIEEE(data))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// parseMemRange parses a single "bytes=" range against an object of the
+// given size, returning inclusive start/end offsets.
+func parseMemRange(v string, size int64) (start, end int64, ok bool) {
+	spec, found := strings.CutPrefix(strings.TrimSpace(v), "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	first, last, found := strings.Cut(spec, "-")
+	if !found {
+		return 0, 0, false
+	}
+	var err error
+	switch {
+	case first == "":
+		// Suffix range: the final N bytes.
+		n, perr := strconv.ParseInt(last, 10, 64)
+		if perr != nil || n <= 0 || size == 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, true
+	default:
+		start, err = strconv.ParseInt(first, 10, 64)
+		if err != nil || start < 0 || start >= size {
+			return 0, 0, false
+		}
+		end = size - 1
+		if last != "" {
+			end, err = strconv.ParseInt(last, 10, 64)
+			if err != nil || end < start {
+				return 0, 0, false
+			}
+			if end > size-1 {
+				end = size - 1
+			}
+		}
+		return start, end, true
+	}
+}
+
+// objectHeadersFromRequest captures the standard object headers from a
+// PUT / CreateMultipartUpload / REPLACE-copy request.
+func objectHeadersFromRequest(r *http.Request) memObjectHeaders {
+	ct := r.Header.Get("Content-Type")
+	if ct == "" {
+		ct = "binary/octet-stream"
+	}
+	// The SDK's aws-chunked framing is transport-level, not part of the
+	// stored object's encoding.
+	var encodings []string
+	for _, enc := range strings.Split(r.Header.Get("Content-Encoding"), ",") {
+		if enc = strings.TrimSpace(enc); enc != "" && enc != "aws-chunked" {
+			encodings = append(encodings, enc)
+		}
+	}
+	return memObjectHeaders{
+		contentType:        ct,
+		contentEncoding:    strings.Join(encodings, ","),
+		contentDisposition: r.Header.Get("Content-Disposition"),
+		contentLanguage:    r.Header.Get("Content-Language"),
+		cacheControl:       r.Header.Get("Cache-Control"),
+	}
+}
+
+// metadataFromRequest collects x-amz-meta-* headers (lower-cased, as S3
+// stores them).
+func metadataFromRequest(r *http.Request) map[string]string {
+	md := make(map[string]string)
+	for k, v := range r.Header {
+		lk := strings.ToLower(k)
+		if name, ok := strings.CutPrefix(lk, "x-amz-meta-"); ok && len(v) > 0 {
+			md[name] = v[0]
+		}
+	}
+	return md
+}
+
+func copyMetadata(md map[string]string) map[string]string {
+	out := make(map[string]string, len(md))
+	for k, v := range md {
+		out[k] = v
+	}
+	return out
+}
+
+// writeObjectHeaders sets the stored object headers on a GET/HEAD response.
+func writeObjectHeaders(h http.Header, obj *memObject) {
+	h.Set("ETag", obj.etag)
+	h.Set("Last-Modified", obj.lastModified.Format(http.TimeFormat))
+	h.Set("Content-Type", obj.headers.contentType)
+	if obj.headers.contentEncoding != "" {
+		h.Set("Content-Encoding", obj.headers.contentEncoding)
+	}
+	if obj.headers.contentDisposition != "" {
+		h.Set("Content-Disposition", obj.headers.contentDisposition)
+	}
+	if obj.headers.contentLanguage != "" {
+		h.Set("Content-Language", obj.headers.contentLanguage)
+	}
+	if obj.headers.cacheControl != "" {
+		h.Set("Cache-Control", obj.headers.cacheControl)
+	}
+	for k, v := range obj.metadata {
+		h.Set("x-amz-meta-"+k, v)
+	}
+}
+
+// encodeMemKey applies ListObjectsV2 encoding-type=url to a key.
+func encodeMemKey(k, encodingType string) string {
+	if encodingType != "url" {
+		return k
+	}
+	return strings.ReplaceAll(url.QueryEscape(k), "%2F", "/")
+}
+
+// formatMemTime formats a timestamp the way S3 XML bodies do.
+func formatMemTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// writeMemXML writes an XML document response.
+func writeMemXML(w http.ResponseWriter, status int, v any) {
+	body, err := xml.Marshal(v)
+	if err != nil {
+		writeMemError(w, nil, newMemError(http.StatusInternalServerError, "InternalError", err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Length", strconv.Itoa(len(xml.Header)+len(body)))
+	w.WriteHeader(status)
+	_, _ = io.WriteString(w, xml.Header)
+	_, _ = w.Write(body)
+}
+
+type memErrorBody struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	RequestID string   `xml:"RequestId"`
+}
+
+// writeMemError writes an S3 error response. HEAD responses carry no body,
+// so the SDK falls back to the status code (e.g. 404 → NotFound).
+func writeMemError(w http.ResponseWriter, r *http.Request, e *memError) {
+	if r != nil && r.Method == http.MethodHead {
+		w.WriteHeader(e.status)
+		return
+	}
+	body, _ := xml.Marshal(memErrorBody{Code: e.code, Message: e.message, RequestID: "memory"})
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Length", strconv.Itoa(len(xml.Header)+len(body)))
+	w.WriteHeader(e.status)
+	_, _ = io.WriteString(w, xml.Header)
+	_, _ = w.Write(body)
+}
+
+// Compile-time check that MemoryBackend is an http.Handler.
+var _ http.Handler = (*MemoryBackend)(nil)
+
+// Compile-time check that handlerTransport is an http.RoundTripper.
+var _ http.RoundTripper = (*handlerTransport)(nil)
@@ -0,0 +1,141 @@
+package s3
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+)
+
+// memSessionTTL is how long CreateSession credentials stay valid.
+const memSessionTTL = 5 * time.Minute
+
+// CreateAccessPoint creates an access point called name in front of bucket,
+// owned by accountID in region. It returns the access point ARN and its
+// alias; both can be used as the bucket of a request (the ARN through
+// AccessPointURL), the ARN being served virtual-hosted as
+// "<name>-<account>.s3.memory.local".
+func (b *MemoryBackend) CreateAccessPoint(bucket, name, accountID, region string) (apARN, alias string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.buckets[bucket]; !ok {
+		return "", "", fmt.Errorf("s3: NoSuchBucket: %s", bucket)
+	}
+	apARN = arn.ARN{
+		Partition: "aws",
+		Service:   "s3",
+		Region:    region,
+		AccountID: accountID,
+		Resource:  "accesspoint/" + name,
+	}.String()
+	sum := md5.Sum([]byte(apARN))
+	alias = name + "-" + hex.EncodeToString(sum[:])[:26] + "-s3alias"
+	b.accessPoints[apARN] = bucket
+	b.accessPoints[alias] = bucket
+	b.accessPoints[name+"-"+accountID] = bucket
+	return apARN, alias, nil
+}
+
+// SetRequesterPays turns requester-pays on or off for bucket. While on,
+// requests without "x-amz-request-payer: requester" are denied.
+func (b *MemoryBackend) SetRequesterPays(bucket string, enabled bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bk, ok := b.buckets[bucket]
+	if !ok {
+		return fmt.Errorf("s3: NoSuchBucket: %s", bucket)
+	}
+	bk.requesterPays = enabled
+	return nil
+}
+
+// routeRequest returns the bucket and key a request addresses. Requests
+// to a subdomain of the memory endpoint are virtual-hosted; everything
+// else is path-style. Access point names resolve to their bucket.
+func (b *MemoryBackend) routeRequest(r *http.Request) (bucket, key string) {
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if label, ok := strings.CutSuffix(host, "."+memoryHost()); ok && label != "" {
+		bucket, key = label, strings.TrimPrefix(r.URL.Path, "/")
+	} else {
+		bucket, key = splitMemPath(r.URL.Path)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if target, ok := b.accessPoints[bucket]; ok {
+		bucket = target
+	}
+	return bucket, key
+}
+
+// memoryHost is the host name of MemoryEndpoint.
+func memoryHost() string {
+	u, _ := url.Parse(MemoryEndpoint)
+	return u.Host
+}
+
+// checkRequestPayer enforces requester-pays and acknowledges the charge.
+func (b *MemoryBackend) checkRequestPayer(w http.ResponseWriter, r *http.Request, bucket string) *memError {
+	b.mu.Lock()
+	bk, ok := b.buckets[bucket]
+	pays := ok && bk.requesterPays
+	b.mu.Unlock()
+	if !pays {
+		return nil
+	}
+	if !strings.EqualFold(r.Header.Get(requestPayerHeader), "requester") {
+		return newMemError(http.StatusForbidden, "AccessDenied", "Access Denied")
+	}
+	w.Header().Set("x-amz-request-charged", "requester")
+	return nil
+}
+
+type memCreateSessionResult struct {
+	XMLName     xml.Name              `xml:"CreateSessionResult"`
+	Xmlns       string                `xml:"xmlns,attr"`
+	Credentials memSessionCredentials `xml:"Credentials"`
+}
+
+type memSessionCredentials struct {
+	AccessKeyID     string `xml:"AccessKeyId"`
+	SecretAccessKey string `xml:"SecretAccessKey"`
+	SessionToken    string `xml:"SessionToken"`
+	Expiration      string `xml:"Expiration"`
+}
+
+// createSession answers the S3 Express CreateSession call the SDK makes
+// before talking to a directory bucket. Session credentials are not
+// checked afterwards.
+func (b *MemoryBackend) createSession(w http.ResponseWriter, bucket string) *memError {
+	b.mu.Lock()
+	_, ok := b.buckets[bucket]
+	b.seq++
+	seq := b.seq
+	now := b.now()
+	b.mu.Unlock()
+	if !ok {
+		return errNoSuchBucket(bucket)
+	}
+	writeMemXML(w, http.StatusOK, memCreateSessionResult{
+		Xmlns: s3XMLNamespace,
+		Credentials: memSessionCredentials{
+			AccessKeyID:     "memory-session",
+			SecretAccessKey: "memory-session",
+			SessionToken:    fmt.Sprintf("memory-session-%d", seq),
+			Expiration:      now.Add(memSessionTTL).UTC().Format(time.RFC3339),
+		},
+	})
+	return nil
+}
@@ -0,0 +1,83 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"oss.nandlabs.io/golly-aws/awscfg"
+	"oss.nandlabs.io/golly/vfs"
+)
+
+func TestMemoryBackend_AccessPointsThroughVFS(t *testing.T) {
+	mem, client := newMemoryClient(t, "ap-data")
+	apARN, alias, err := mem.CreateAccessPoint("ap-data", "reports", "123456789012", "us-west-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	apURL, err := AccessPointURL(apARN, "2024/q1.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	awscfg.Manager.Register(apURL.Host, mem.Config("us-east-1"))
+	awscfg.Manager.Register(alias, mem.Config("us-east-1"))
+	t.Cleanup(func() {
+		awscfg.Manager.Unregister(apURL.Host)
+		awscfg.Manager.Unregister(alias)
+	})
+
+	writeVFS(t, apURL.String(), "q1")
+	if got := getString(t, client, &awss3.GetObjectInput{Bucket: aws.String("ap-data"), Key: aws.String("2024/q1.csv")}); got != "q1" {
+		t.Fatalf("object behind access point = %q", got)
+	}
+	if got, err := readVFS(t, "s3://"+alias+"/2024/q1.csv"); err != nil || got != "q1" {
+		t.Fatalf("read via alias = %q, %v", got, err)
+	}
+
+	// Listing keeps the access point host, so children resolve the same way.
+	dir, _ := AccessPointURL(apARN, "2024/")
+	children, err := storageFs.List(dir)
+	if err != nil || len(children) != 1 || children[0].Url().Host != apURL.Host {
+		t.Fatalf("List = %v, %v", children, err)
+	}
+	dst, _ := AccessPointURL(apARN, "2024/q1-copy.csv")
+	if err := storageFs.Copy(apURL, dst); err != nil {
+		t.Fatalf("Copy through access point: %v", err)
+	}
+	if got := getString(t, client, &awss3.GetObjectInput{Bucket: aws.String("ap-data"), Key: aws.String("2024/q1-copy.csv")}); got != "q1" {
+		t.Fatalf("copied object = %q", got)
+	}
+}
+
+func TestMemoryBackend_DirectoryBucket(t *testing.T) {
+	mem := registerMemoryBucket(t, "fast--usw2-az1--x-s3", "us-west-2")
+	if err := mem.CreateBucket("fast--usw2-az1--x-s3"); err != nil {
+		t.Fatal(err)
+	}
+	writeVFS(t, "s3://fast--usw2-az1--x-s3/shard/0001", "hot")
+	if got, err := readVFS(t, "s3://fast--usw2-az1--x-s3/shard/0001"); err != nil || got != "hot" {
+		t.Fatalf("read = %q, %v", got, err)
+	}
+}
+
+func TestMemoryBackend_RequesterPays(t *testing.T) {
+	mem, _ := newMemoryClient(t, "rp-bucket")
+	if err := mem.SetRequesterPays("rp-bucket", true); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	plain, _ := url.Parse("s3://rp-bucket/data.txt")
+	if _, err := storageFs.CreateCtx(ctx, plain); err == nil {
+		t.Fatalf("write without requestPayer should be denied")
+	} else if !errors.Is(err, vfs.ErrPermission) {
+		t.Fatalf("expected permission error, got %v", err)
+	}
+
+	writeVFS(t, "s3://rp-bucket/data.txt?requestPayer=requester", "paid")
+	if got, err := readVFS(t, "s3://rp-bucket/data.txt?requestPayer=true"); err != nil || got != "paid" {
+		t.Fatalf("read = %q, %v", got, err)
+	}
+}
@@ -0,0 +1,340 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"oss.nandlabs.io/golly-aws/awscfg"
+	"oss.nandlabs.io/golly/vfs"
+)
+
+// newMemoryClient registers a fresh MemoryBackend under bucket and returns
+// an SDK client resolved the same way the filesystem resolves it.
+func newMemoryClient(t *testing.T, bucket string) (*MemoryBackend, *awss3.Client) {
+	t.Helper()
+	mem := NewMemoryBackend()
+	if err := mem.CreateBucket(bucket); err != nil {
+		t.Fatalf("CreateBucket: %v", err)
+	}
+	awscfg.Manager.Register(bucket, mem.Config("us-east-1"))
+	t.Cleanup(func() { awscfg.Manager.Unregister(bucket) })
+
+	client, err := getS3Client(&urlOpts{u: &url.URL{Scheme: S3Scheme, Host: bucket}, Bucket: bucket})
+	if err != nil {
+		t.Fatalf("getS3Client: %v", err)
+	}
+	return mem, client
+}
+
+func apiErrorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return ""
+}
+
+func putString(t *testing.T, client *awss3.Client, bucket, key, body string) *awss3.PutObjectOutput {
+	t.Helper()
+	out, err := client.PutObject(context.Background(), &awss3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   strings.NewReader(body),
+	})
+	if err != nil {
+		t.Fatalf("PutObject %s: %v", key, err)
+	}
+	return out
+}
+
+func getString(t *testing.T, client *awss3.Client, in *awss3.GetObjectInput) string {
+	t.Helper()
+	out, err := client.GetObject(context.Background(), in)
+	if err != nil {
+		t.Fatalf("GetObject: %v", err)
+	}
+	defer func() { _ = out.Body.Close() }()
+	b, err := io.ReadAll(out.Body)
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+	return string(b)
+}
+
+func TestMemoryBackend_PutGetHeadDelete(t *testing.T) {
+	_, client := newMemoryClient(t, "mem-basic")
+	ctx := context.Background()
+
+	_, err := client.PutObject(ctx, &awss3.PutObjectInput{
+		Bucket:      aws.String("mem-basic"),
+		Key:         aws.String("dir/hello.txt"),
+		Body:        strings.NewReader("hello world"),
+		ContentType: aws.String("text/plain"),
+		Metadata:    map[string]string{"Owner": "alice"},
+	})
+	if err != nil {
+		t.Fatalf("PutObject: %v", err)
+	}
+
+	got := getString(t, client, &awss3.GetObjectInput{Bucket: aws.String("mem-basic"), Key: aws.String("dir/hello.txt")})
+	if got != "hello world" {
+		t.Fatalf("body = %q", got)
+	}
+
+	head, err := client.HeadObject(ctx, &awss3.HeadObjectInput{Bucket: aws.String("mem-basic"), Key: aws.String("dir/hello.txt")})
+	if err != nil {
+		t.Fatalf("HeadObject: %v", err)
+	}
+	if aws.ToInt64(head.ContentLength) != 11 || aws.ToString(head.ContentType) != "text/plain" {
+		t.Fatalf("head = len %d type %q", aws.ToInt64(head.ContentLength), aws.ToString(head.ContentType))
+	}
+	if head.Metadata["owner"] != "alice" {
+		t.Fatalf("metadata = %v", head.Metadata)
+	}
+	if aws.ToString(head.ETag) != `"5eb63bbbe01eeed093cb22bb8f5acdc3"` {
+		t.Fatalf("etag = %s", aws.ToString(head.ETag))
+	}
+
+	if _, err := client.DeleteObject(ctx, &awss3.DeleteObjectInput{Bucket: aws.String("mem-basic"), Key: aws.String("dir/hello.txt")}); err != nil {
+		t.Fatalf("DeleteObject: %v", err)
+	}
+	_, err = client.GetObject(ctx, &awss3.GetObjectInput{Bucket: aws.String("mem-basic"), Key: aws.String("dir/hello.txt")})
+	var nsk *s3types.NoSuchKey
+	if !errors.As(err, &nsk) {
+		t.Fatalf("expected NoSuchKey, got %v", err)
+	}
+	if !errors.Is(mapS3Err(err), vfs.ErrNotExist) {
+		t.Fatalf("mapS3Err should map to ErrNotExist, got %v", mapS3Err(err))
+	}
+}
+
+func TestMemoryBackend_RangeAndPreconditions(t *testing.T) {
+	_, client := newMemoryClient(t, "mem-cond")
+	ctx := context.Background()
+	put := putString(t, client, "mem-cond", "k", "0123456789")
+
+	got := getString(t, client, &awss3.GetObjectInput{Bucket: aws.String("mem-cond"), Key: aws.String("k"), Range: aws.String("bytes=2-5")})
+	if got != "2345" {
+		t.Fatalf("range body = %q", got)
+	}
+	got = getString(t, client, &awss3.GetObjectInput{Bucket: aws.String("mem-cond"), Key: aws.String("k"), Range: aws.String("bytes=-3")})
+	if got != "789" {
+		t.Fatalf("suffix range body = %q", got)
+	}
+	_, err := client.GetObject(ctx, &awss3.GetObjectInput{Bucket: aws.String("mem-cond"), Key: aws.String("k"), Range: aws.String("bytes=50-")})
+	if apiErrorCode(err) != "InvalidRange" {
+		t.Fatalf("expected InvalidRange, got %v", err)
+	}
+
+	// If-None-Match: * refuses to overwrite an existing key.
+	_, err = client.PutObject(ctx, &awss3.PutObjectInput{
+		Bucket: aws.String("mem-cond"), Key: aws.String("k"),
+		Body: strings.NewReader("x"), IfNoneMatch: aws.String("*"),
+	})
+	if apiErrorCode(err) != "PreconditionFailed" {
+		t.Fatalf("expected PreconditionFailed, got %v", err)
+	}
+	// If-Match with a stale ETag fails; with the current ETag it succeeds.
+	_, err = client.PutObject(ctx, &awss3.PutObjectInput{
+		Bucket: aws.String("mem-cond"), Key: aws.String("k"),
+		Body: strings.NewReader("x"), IfMatch: aws.String(`"deadbeef"`),
+	})
+	if apiErrorCode(err) != "PreconditionFailed" {
+		t.Fatalf("expected PreconditionFailed, got %v", err)
+	}
+	_, err = client.PutObject(ctx, &awss3.PutObjectInput{
+		Bucket: aws.String("mem-cond"), Key: aws.String("k"),
+		Body: strings.NewReader("x"), IfMatch: put.ETag,
+	})
+	if err != nil {
+		t.Fatalf("conditional PutObject: %v", err)
+	}
+}
+
+func TestMemoryBackend_ListObjectsV2Pagination(t *testing.T) {
+	_, client := newMemoryClient(t, "mem-list")
+	for _, k := range []string{"a/1", "a/2", "a/b/3", "c/4", "d", "e"} {
+		putString(t, client, "mem-list", k, k)
+	}
+
+	var keys, prefixes []string
+	pages := 0
+	p := awss3.NewListObjectsV2Paginator(client, &awss3.ListObjectsV2Input{
+		Bucket:    aws.String("mem-list"),
+		Delimiter: aws.String("/"),
+		MaxKeys:   aws.Int32(1),
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(context.Background())
+		if err != nil {
+			t.Fatalf("NextPage: %v", err)
+		}
+		pages++
+		for _, o := range page.Contents {
+			keys = append(keys, aws.ToString(o.Key))
+		}
+		for _, cp := range page.CommonPrefixes {
+			prefixes = append(prefixes, aws.ToString(cp.Prefix))
+		}
+	}
+	if strings.Join(keys, ",") != "d,e" || strings.Join(prefixes, ",") != "a/,c/" || pages != 4 {
+		t.Fatalf("keys=%v prefixes=%v pages=%d", keys, prefixes, pages)
+	}
+
+	out, err := client.ListObjectsV2(context.Background(), &awss3.ListObjectsV2Input{
+		Bucket:     aws.String("mem-list"),
+		Prefix:     aws.String("a/"),
+		StartAfter: aws.String("a/1"),
+	})
+	if err != nil {
+		t.Fatalf("ListObjectsV2: %v", err)
+	}
+	if aws.ToInt32(out.KeyCount) != 2 || aws.ToString(out.Contents[0].Key) != "a/2" {
+		t.Fatalf("start-after listing = %+v", out.Contents)
+	}
+}
+
+func TestMemoryBackend_MultipartUpload(t *testing.T) {
+	_, client := newMemoryClient(t, "mem-mpu")
+	ctx := context.Background()
+
+	created, err := client.CreateMultipartUpload(ctx, &awss3.CreateMultipartUploadInput{
+		Bucket: aws.String("mem-mpu"), Key: aws.String("big"),
+	})
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload: %v", err)
+	}
+	part1 := bytes.Repeat([]byte("a"), memMinPartSize)
+	part2 := []byte("tail")
+	var completed []s3types.CompletedPart
+	for i, data := range [][]byte{part1, part2} {
+		out, err := client.UploadPart(ctx, &awss3.UploadPartInput{
+			Bucket: aws.String("mem-mpu"), Key: aws.String("big"),
+			UploadId: created.UploadId, PartNumber: aws.Int32(int32(i + 1)),
+			Body: bytes.NewReader(data),
+		})
+		if err != nil {
+			t.Fatalf("UploadPart %d: %v", i+1, err)
+		}
+		completed = append(completed, s3types.CompletedPart{ETag: out.ETag, PartNumber: aws.Int32(int32(i + 1))})
+	}
+	done, err := client.CompleteMultipartUpload(ctx, &awss3.CompleteMultipartUploadInput{
+		Bucket: aws.String("mem-mpu"), Key: aws.String("big"), UploadId: created.UploadId,
+		MultipartUpload: &s3types.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		t.Fatalf("CompleteMultipartUpload: %v", err)
+	}
+	if !strings.HasSuffix(aws.ToString(done.ETag), `-2"`) {
+		t.Fatalf("multipart etag = %s", aws.ToString(done.ETag))
+	}
+	head, err := client.HeadObject(ctx, &awss3.HeadObjectInput{Bucket: aws.String("mem-mpu"), Key: aws.String("big")})
+	if err != nil {
+		t.Fatalf("HeadObject: %v", err)
+	}
+	if aws.ToInt64(head.ContentLength) != int64(len(part1)+len(part2)) {
+		t.Fatalf("size = %d", aws.ToInt64(head.ContentLength))
+	}
+}
+
+func TestMemoryBackend_CopyAndBucketLifecycle(t *testing.T) {
+	_, client := newMemoryClient(t, "mem-copy")
+	ctx := context.Background()
+	putString(t, client, "mem-copy", "src", "payload")
+
+	_, err := client.CopyObject(ctx, &awss3.CopyObjectInput{
+		Bucket: aws.String("mem-copy"), Key: aws.String("dst"),
+		CopySource: aws.String("mem-copy/src"),
+	})
+	if err != nil {
+		t.Fatalf("CopyObject: %v", err)
+	}
+	if got := getString(t, client, &awss3.GetObjectInput{Bucket: aws.String("mem-copy"), Key: aws.String("dst")}); got != "payload" {
+		t.Fatalf("copied body = %q", got)
+	}
+
+	_, err = client.DeleteBucket(ctx, &awss3.DeleteBucketInput{Bucket: aws.String("mem-copy")})
+	if apiErrorCode(err) != "BucketNotEmpty" {
+		t.Fatalf("expected BucketNotEmpty, got %v", err)
+	}
+
+	_, err = client.CreateBucket(ctx, &awss3.CreateBucketInput{
+		Bucket: aws.String("mem-copy-eu"),
+		CreateBucketConfiguration: &s3types.CreateBucketConfiguration{
+			LocationConstraint: s3types.BucketLocationConstraintEuWest1,
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateBucket: %v", err)
+	}
+	loc, err := client.GetBucketLocation(ctx, &awss3.GetBucketLocationInput{Bucket: aws.String("mem-copy-eu")})
+	if err != nil {
+		t.Fatalf("GetBucketLocation: %v", err)
+	}
+	if loc.LocationConstraint != s3types.BucketLocationConstraintEuWest1 {
+		t.Fatalf("location = %q", loc.LocationConstraint)
+	}
+}
+
+// TestMemoryBackend_ThroughVFS drives the S3 filesystem end to end against
+// the in-memory backend, resolved via awscfg like a real endpoint.
+func TestMemoryBackend_ThroughVFS(t *testing.T) {
+	newMemoryClient(t, "mem-vfs")
+	fs := &S3FS{}
+	fs.BaseVFS = &vfs.BaseVFS{VFileSystem: fs}
+
+	u, _ := url.Parse("s3://mem-vfs/docs/readme.txt")
+	f, err := fs.Create(u)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := f.Write([]byte("from vfs")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := fs.Create(u); err == nil {
+		t.Fatalf("Create on existing key should fail")
+	}
+
+	r, err := fs.Open(u)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	b, err := io.ReadAll(r)
+	_ = r.Close()
+	if err != nil || string(b) != "from vfs" {
+		t.Fatalf("read = %q, %v", b, err)
+	}
+
+	dst, _ := url.Parse("s3://mem-vfs/archive/readme.txt")
+	if err := fs.Copy(u, dst); err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+	root, _ := url.Parse("s3://mem-vfs/")
+	children, err := fs.List(root)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(children) != 2 {
+		t.Fatalf("expected 2 top-level prefixes, got %d", len(children))
+	}
+
+	if err := fs.Delete(dst); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	missing, _ := fs.Open(dst)
+	if _, err := missing.Info(); !errors.Is(err, vfs.ErrNotExist) {
+		t.Fatalf("expected ErrNotExist after delete, got %v", err)
+	}
+}
@@ -3,7 +3,10 @@ package s3
 import (
 	"context"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
 	"oss.nandlabs.io/golly-aws/awscfg"
 	"oss.nandlabs.io/golly/l3"
 	"oss.nandlabs.io/golly/vfs"
@@ -11,8 +14,10 @@ import (
 
 var logger = l3.Get()
 
+// storageFs is the filesystem instance registered with the VFS manager.
+var storageFs = &S3FS{}
+
 func init() {
-	storageFs := &S3FS{}
 	storageFs.BaseVFS = &vfs.BaseVFS{VFileSystem: storageFs}
 	vfs.GetManager().Register(storageFs)
 }
@@ -26,7 +31,7 @@ func getS3Client(opts *urlOpts) (*awss3.Client, error) {
 		if err != nil {
 			return nil, err
 		}
-		return awss3.NewFromConfig(awsCfg), nil
+		return awss3.NewFromConfig(awsCfg, routingOptions(opts, "")), nil
 	}
 
 	awsCfg, err := cfg.LoadAWSConfig(context.Background())
@@ -34,13 +39,31 @@ func getS3Client(opts *urlOpts) (*awss3.Client, error) {
 		return nil, err
 	}
 
-	var s3Opts []func(*awss3.Options)
-	if cfg.Endpoint != "" {
-		s3Opts = append(s3Opts, func(o *awss3.Options) {
-			o.BaseEndpoint = &cfg.Endpoint
-			o.UsePathStyle = true
-		})
-	}
+	return awss3.NewFromConfig(awsCfg, routingOptions(opts, cfg.Endpoint)), nil
+}
 
-	return awss3.NewFromConfig(awsCfg, s3Opts...), nil
+// routingOptions applies the addressing and signing settings the bucket
+// kind needs. Custom endpoints use path-style addressing where the kind
+// allows it; access point ARNs and directory buckets are always
+// virtual-hosted. Access point requests are signed for the ARN's region
+// (SigV4A for Multi-Region Access Points, chosen by the SDK), and
+// directory buckets use S3 Express session auth.
+func routingOptions(opts *urlOpts, endpoint string) func(*awss3.Options) {
+	return func(o *awss3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = opts.Kind.pathStyleCapable()
+		}
+		if opts.Kind.isARN() {
+			o.UseARNRegion = true
+		}
+		if opts.RequestPayer {
+			o.APIOptions = append(o.APIOptions, smithyhttp.SetHeaderValue(requestPayerHeader, string(s3types.RequestPayerRequester)))
+		}
+	}
 }
+
+// requestPayerHeader is the header requester-pays buckets require on
+// every request. Setting it on the transport covers all operations,
+// including paginators and the multipart calls made by Sync.
+const requestPayerHeader = "x-amz-request-payer"
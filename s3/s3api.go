@@ -0,0 +1,74 @@
+package s3
+
+import (
+	"context"
+
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// s3API is the subset of the AWS S3 client surface the filesystem relies on.
+// It exists so tests can inject a fake without spinning up LocalStack — the
+// concrete *awss3.Client already satisfies this interface. It also satisfies
+// awss3.ListObjectsV2APIClient, so the SDK paginators accept it directly.
+type s3API interface {
+	HeadObject(ctx context.Context, params *awss3.HeadObjectInput, optFns ...func(*awss3.Options)) (*awss3.HeadObjectOutput, error)
+	GetObject(ctx context.Context, params *awss3.GetObjectInput, optFns ...func(*awss3.Options)) (*awss3.GetObjectOutput, error)
+	PutObject(ctx context.Context, params *awss3.PutObjectInput, optFns ...func(*awss3.Options)) (*awss3.PutObjectOutput, error)
+	CopyObject(ctx context.Context, params *awss3.CopyObjectInput, optFns ...func(*awss3.Options)) (*awss3.CopyObjectOutput, error)
+	DeleteObject(ctx context.Context, params *awss3.DeleteObjectInput, optFns ...func(*awss3.Options)) (*awss3.DeleteObjectOutput, error)
+	ListObjectsV2(ctx context.Context, params *awss3.ListObjectsV2Input, optFns ...func(*awss3.Options)) (*awss3.ListObjectsV2Output, error)
+	DeleteObjects(ctx context.Context, params *awss3.DeleteObjectsInput, optFns ...func(*awss3.Options)) (*awss3.DeleteObjectsOutput, error)
+	CreateMultipartUpload(ctx context.Context, params *awss3.CreateMultipartUploadInput, optFns ...func(*awss3.Options)) (*awss3.CreateMultipartUploadOutput, error)
+	UploadPart(ctx context.Context, params *awss3.UploadPartInput, optFns ...func(*awss3.Options)) (*awss3.UploadPartOutput, error)
+	CompleteMultipartUpload(ctx context.Context, params *awss3.CompleteMultipartUploadInput, optFns ...func(*awss3.Options)) (*awss3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(ctx context.Context, params *awss3.AbortMultipartUploadInput, optFns ...func(*awss3.Options)) (*awss3.AbortMultipartUploadOutput, error)
+}
+
+// Compile-time check that the SDK client satisfies the narrow interface.
+var _ s3API = (*awss3.Client)(nil)
+
+// resolveClient returns the S3 API client for the given urlOpts. It is a
+// package-level var so tests can inject a fake client without touching the
+// AWS SDK. Production callers get the awscfg-resolved client via getS3Client;
+// for a hermetic backend that still exercises the SDK end to end, register
+// a MemoryBackend config with awscfg instead.
+var resolveClient = func(opts *urlOpts) (s3API, error) {
+	return getS3Client(opts)
+}
+
+// s3AdminAPI is the bucket-level subset of the S3 client used by the
+// BucketAdmin operations on S3FS. It is kept separate from s3API so object
+// fakes do not have to stub out bucket management.
+type s3AdminAPI interface {
+	Options() awss3.Options
+	CreateBucket(ctx context.Context, params *awss3.CreateBucketInput, optFns ...func(*awss3.Options)) (*awss3.CreateBucketOutput, error)
+	DeleteBucket(ctx context.Context, params *awss3.DeleteBucketInput, optFns ...func(*awss3.Options)) (*awss3.DeleteBucketOutput, error)
+	HeadBucket(ctx context.Context, params *awss3.HeadBucketInput, optFns ...func(*awss3.Options)) (*awss3.HeadBucketOutput, error)
+	GetBucketLifecycleConfiguration(ctx context.Context, params *awss3.GetBucketLifecycleConfigurationInput, optFns ...func(*awss3.Options)) (*awss3.GetBucketLifecycleConfigurationOutput, error)
+	PutBucketLifecycleConfiguration(ctx context.Context, params *awss3.PutBucketLifecycleConfigurationInput, optFns ...func(*awss3.Options)) (*awss3.PutBucketLifecycleConfigurationOutput, error)
+	DeleteBucketLifecycle(ctx context.Context, params *awss3.DeleteBucketLifecycleInput, optFns ...func(*awss3.Options)) (*awss3.DeleteBucketLifecycleOutput, error)
+	GetBucketCors(ctx context.Context, params *awss3.GetBucketCorsInput, optFns ...func(*awss3.Options)) (*awss3.GetBucketCorsOutput, error)
+	PutBucketCors(ctx context.Context, params *awss3.PutBucketCorsInput, optFns ...func(*awss3.Options)) (*awss3.PutBucketCorsOutput, error)
+	DeleteBucketCors(ctx context.Context, params *awss3.DeleteBucketCorsInput, optFns ...func(*awss3.Options)) (*awss3.DeleteBucketCorsOutput, error)
+	GetBucketVersioning(ctx context.Context, params *awss3.GetBucketVersioningInput, optFns ...func(*awss3.Options)) (*awss3.GetBucketVersioningOutput, error)
+	PutBucketVersioning(ctx context.Context, params *awss3.PutBucketVersioningInput, optFns ...func(*awss3.Options)) (*awss3.PutBucketVersioningOutput, error)
+	GetBucketEncryption(ctx context.Context, params *awss3.GetBucketEncryptionInput, optFns ...func(*awss3.Options)) (*awss3.GetBucketEncryptionOutput, error)
+	PutBucketEncryption(ctx context.Context, params *awss3.PutBucketEncryptionInput, optFns ...func(*awss3.Options)) (*awss3.PutBucketEncryptionOutput, error)
+	DeleteBucketEncryption(ctx context.Context, params *awss3.DeleteBucketEncryptionInput, optFns ...func(*awss3.Options)) (*awss3.DeleteBucketEncryptionOutput, error)
+	GetBucketPolicy(ctx context.Context, params *awss3.GetBucketPolicyInput, optFns ...func(*awss3.Options)) (*awss3.GetBucketPolicyOutput, error)
+	PutBucketPolicy(ctx context.Context, params *awss3.PutBucketPolicyInput, optFns ...func(*awss3.Options)) (*awss3.PutBucketPolicyOutput, error)
+	DeleteBucketPolicy(ctx context.Context, params *awss3.DeleteBucketPolicyInput, optFns ...func(*awss3.Options)) (*awss3.DeleteBucketPolicyOutput, error)
+	GetPublicAccessBlock(ctx context.Context, params *awss3.GetPublicAccessBlockInput, optFns ...func(*awss3.Options)) (*awss3.GetPublicAccessBlockOutput, error)
+	PutPublicAccessBlock(ctx context.Context, params *awss3.PutPublicAccessBlockInput, optFns ...func(*awss3.Options)) (*awss3.PutPublicAccessBlockOutput, error)
+	DeletePublicAccessBlock(ctx context.Context, params *awss3.DeletePublicAccessBlockInput, optFns ...func(*awss3.Options)) (*awss3.DeletePublicAccessBlockOutput, error)
+}
+
+// Compile-time check that the SDK client satisfies the admin interface.
+var _ s3AdminAPI = (*awss3.Client)(nil)
+
+// resolveAdminClient returns the bucket admin client for the given
+// urlOpts. Like resolveClient, it is a package-level var for test
+// injection and resolves through the same awscfg mapping.
+var resolveAdminClient = func(opts *urlOpts) (s3AdminAPI, error) {
+	return getS3Client(opts)
+}
@@ -17,7 +17,7 @@ import (
 // S3File implements the vfs.VFile interface for S3 objects.
 type S3File struct {
 	*vfs.BaseFile
-	client  *awss3.Client
+	client  s3API
 	fs      *S3FS
 	urlOpts *urlOpts
 	// reader/writer state
@@ -25,6 +25,17 @@ type S3File struct {
 	writeBuffer *bytes.Buffer
 	offset      int64
 	contentType string
+	// ctx is the context of the OpenCtx or CreateCtx call that returned
+	// the file, used by its reads and its upload on Close.
+	ctx context.Context
+}
+
+// context returns the context the file's reads and writes run with.
+func (f *S3File) context() context.Context {
+	if f.ctx != nil {
+		return f.ctx
+	}
+	return context.Background()
 }
 
 // Read reads from the S3 object.
@@ -34,11 +45,18 @@ func (f *S3File) Read(b []byte) (n int, err error) {
 			Bucket: aws.String(f.urlOpts.Bucket),
 			Key:    aws.String(f.urlOpts.Key),
 		}
-		result, getErr := f.client.GetObject(context.Background(), input)
+		ctx := f.context()
+		result, getErr := f.client.GetObject(ctx, input)
 		if getErr != nil {
 			return 0, mapS3Err(getErr)
 		}
-		f.reader = result.Body
+		body, decErr := decodeObject(ctx, f.urlOpts, result.Body, aws.ToString(result.ContentEncoding),
+			aws.ToInt64(result.ContentLength), result.Metadata)
+		if decErr != nil {
+			_ = result.Body.Close()
+			return 0, decErr
+		}
+		f.reader = body
 		if result.ContentType != nil {
 			f.contentType = *result.ContentType
 		}
@@ -72,23 +90,14 @@ func (f *S3File) Seek(offset int64, whence int) (int64, error) {
 	return f.offset, fmt.Errorf("seek not fully supported on S3 objects")
 }
 
-// Close flushes any buffered writes to S3 and closes open readers.
+// Close flushes any buffered writes to S3 and closes open readers. The
+// buffer is run through the codecs configured for the bucket (see
+// CodecOptions) before upload.
 func (f *S3File) Close() error {
 	var err error
 	// Flush write buffer to S3
 	if f.writeBuffer != nil && f.writeBuffer.Len() > 0 {
-		ct := f.contentType
-		if ct == "" {
-			ct = "application/octet-stream"
-		}
-		input := &awss3.PutObjectInput{
-			Bucket:      aws.String(f.urlOpts.Bucket),
-			Key:         aws.String(f.urlOpts.Key),
-			Body:        f.writeBuffer,
-			ContentType: aws.String(ct),
-		}
-		_, err = f.client.PutObject(context.Background(), input)
-		err = mapS3Err(err)
+		err = f.flush()
 		f.writeBuffer = nil
 	}
 	// Close reader
@@ -102,6 +111,34 @@ func (f *S3File) Close() error {
 	return err
 }
 
+// flush encodes and uploads the write buffer.
+func (f *S3File) flush() error {
+	ctx := f.context()
+	ct := f.contentType
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+	input := &awss3.PutObjectInput{
+		Bucket:      aws.String(f.urlOpts.Bucket),
+		Key:         aws.String(f.urlOpts.Key),
+		Body:        bytes.NewReader(f.writeBuffer.Bytes()),
+		ContentType: aws.String(ct),
+	}
+	enc, err := encodeObject(ctx, f.urlOpts, f.writeBuffer.Bytes())
+	if err != nil {
+		return err
+	}
+	if enc != nil {
+		input.Body = bytes.NewReader(enc.data)
+		input.Metadata = enc.metadata
+		if enc.contentEncoding != "" {
+			input.ContentEncoding = aws.String(enc.contentEncoding)
+		}
+	}
+	_, err = f.client.PutObject(ctx, input)
+	return mapS3Err(err)
+}
+
 // ListAll lists all objects under this S3 prefix.
 func (f *S3File) ListAll() (files []vfs.VFile, err error) {
 	prefix := f.urlOpts.Key
@@ -127,12 +164,7 @@ func (f *S3File) ListAll() (files []vfs.VFile, err error) {
 			if key == prefix || key == f.urlOpts.Key {
 				continue
 			}
-			u := &url.URL{
-				Scheme: S3Scheme,
-				Host:   f.urlOpts.Bucket,
-				Path:   "/" + key,
-			}
-			child, openErr := f.fs.Open(u)
+			child, openErr := f.fs.Open(f.urlOpts.childURL(key))
 			if openErr != nil {
 				return nil, openErr
 			}
@@ -142,12 +174,7 @@ func (f *S3File) ListAll() (files []vfs.VFile, err error) {
 		// Also include common prefixes (virtual directories)
 		for _, cp := range page.CommonPrefixes {
 			cpKey := aws.ToString(cp.Prefix)
-			u := &url.URL{
-				Scheme: S3Scheme,
-				Host:   f.urlOpts.Bucket,
-				Path:   "/" + cpKey,
-			}
-			child, openErr := f.fs.Open(u)
+			child, openErr := f.fs.Open(f.urlOpts.childURL(cpKey))
 			if openErr != nil {
 				return nil, openErr
 			}
@@ -233,13 +260,18 @@ func (f *S3File) Info() (vfs.VFileInfo, error) {
 	if result.ContentType != nil {
 		ct = *result.ContentType
 	}
+	// Encoded objects report the size callers will actually read.
+	size := aws.ToInt64(result.ContentLength)
+	if n, ok := logicalSize(result.Metadata); ok {
+		size = n
+	}
 
 	return &S3FileInfo{
 		fs:           f.fs,
 		isDir:        false,
 		key:          f.urlOpts.Key,
 		lastModified: aws.ToTime(result.LastModified),
-		size:         aws.ToInt64(result.ContentLength),
+		size:         size,
 		contentType:  ct,
 	}, nil
 }
@@ -252,12 +284,7 @@ func (f *S3File) Parent() (vfs.VFile, error) {
 	if idx > 0 {
 		parentKey = key[:idx+1]
 	}
-	u := &url.URL{
-		Scheme: S3Scheme,
-		Host:   f.urlOpts.Bucket,
-		Path:   "/" + parentKey,
-	}
-	return f.fs.Open(u)
+	return f.fs.Open(f.urlOpts.childURL(parentKey))
 }
 
 // Url returns the URL of this file.
@@ -293,11 +320,12 @@ func (f *S3File) AddProperty(name, value string) error {
 	}
 	metadata[name] = value
 
-	copySource := f.urlOpts.Bucket + "/" + f.urlOpts.Key
 	copyInput := &awss3.CopyObjectInput{
 		Bucket:            aws.String(f.urlOpts.Bucket),
 		Key:               aws.String(f.urlOpts.Key),
-		CopySource:        aws.String(copySource),
+		CopySource:        aws.String(f.urlOpts.copySource()),
+		ContentType:       headResult.ContentType,
+		ContentEncoding:   headResult.ContentEncoding,
 		Metadata:          metadata,
 		MetadataDirective: "REPLACE",
 	}
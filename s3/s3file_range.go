@@ -2,6 +2,7 @@ package s3
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 
@@ -48,10 +49,27 @@ func (f *S3File) ReadRange(ctx context.Context, off, length int64) ([]byte, erro
 		Range:  aws.String(rangeHeader),
 	})
 	if err != nil {
+		// An offset past the stored bytes may still be inside the
+		// decoded bytes of a compressed object.
+		if isAPIErrorCode(err, "InvalidRange") {
+			head, headErr := f.client.HeadObject(ctx, &awss3.HeadObjectInput{
+				Bucket: aws.String(f.urlOpts.Bucket),
+				Key:    aws.String(f.urlOpts.Key),
+			})
+			if headErr == nil && isEncoded(f.urlOpts, aws.ToString(head.ContentEncoding), aws.ToInt64(head.ContentLength), head.Metadata) {
+				return f.readDecodedRange(ctx, off, length)
+			}
+		}
 		return nil, err
 	}
 	defer func() { _ = resp.Body.Close() }()
 
+	// Offsets of an encoded object refer to the decoded bytes, which
+	// cannot be addressed by an HTTP range; decode from the start instead.
+	if isEncoded(f.urlOpts, aws.ToString(resp.ContentEncoding), aws.ToInt64(resp.ContentLength), resp.Metadata) {
+		return f.readDecodedRange(ctx, off, length)
+	}
+
 	buf, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
@@ -64,3 +82,41 @@ func (f *S3File) ReadRange(ctx context.Context, off, length int64) ([]byte, erro
 	}
 	return buf, nil
 }
+
+// readDecodedRange serves ReadRange for objects written through the codec
+// layer by streaming the whole object through its decoders.
+func (f *S3File) readDecodedRange(ctx context.Context, off, length int64) ([]byte, error) {
+	resp, err := f.client.GetObject(ctx, &awss3.GetObjectInput{
+		Bucket: aws.String(f.urlOpts.Bucket),
+		Key:    aws.String(f.urlOpts.Key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	body, err := decodeObject(ctx, f.urlOpts, resp.Body, aws.ToString(resp.ContentEncoding),
+		aws.ToInt64(resp.ContentLength), resp.Metadata)
+	if err != nil {
+		_ = resp.Body.Close()
+		return nil, err
+	}
+	defer func() { _ = body.Close() }()
+
+	if _, err := io.CopyN(io.Discard, body, off); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	var r io.Reader = body
+	if length > 0 {
+		r = io.LimitReader(body, length)
+	}
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) == 0 {
+		return nil, io.EOF
+	}
+	return buf, nil
+}
@@ -1,8 +1,10 @@
 package s3
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"net/url"
 	"strings"
 
@@ -31,7 +33,7 @@ func (fs *S3FS) Create(u *url.URL) (vfs.VFile, error) {
 		return nil, err
 	}
 
-	client, err := getS3Client(opts)
+	client, err := resolveClient(opts)
 	if err != nil {
 		return nil, err
 	}
@@ -73,7 +75,7 @@ func (fs *S3FS) MkdirAll(u *url.URL) (vfs.VFile, error) {
 		return nil, err
 	}
 
-	client, err := getS3Client(opts)
+	client, err := resolveClient(opts)
 	if err != nil {
 		return nil, err
 	}
@@ -94,15 +96,7 @@ func (fs *S3FS) MkdirAll(u *url.URL) (vfs.VFile, error) {
 	}
 
 	// Update opts with directory key
-	dirOpts := &urlOpts{
-		u: &url.URL{
-			Scheme: S3Scheme,
-			Host:   opts.Bucket,
-			Path:   "/" + key,
-		},
-		Bucket: opts.Bucket,
-		Key:    key,
-	}
+	dirOpts := opts.withKey(key)
 
 	return newS3File(client, fs, dirOpts), nil
 }
@@ -114,7 +108,7 @@ func (fs *S3FS) Open(u *url.URL) (vfs.VFile, error) {
 		return nil, err
 	}
 
-	client, err := getS3Client(opts)
+	client, err := resolveClient(opts)
 	if err != nil {
 		return nil, err
 	}
@@ -123,7 +117,7 @@ func (fs *S3FS) Open(u *url.URL) (vfs.VFile, error) {
 }
 
 // newS3File creates a new S3File instance.
-func newS3File(client *awss3.Client, fs *S3FS, opts *urlOpts) *S3File {
+func newS3File(client s3API, fs *S3FS, opts *urlOpts) *S3File {
 	f := &S3File{
 		client:  client,
 		fs:      fs,
@@ -145,7 +139,7 @@ func (fs *S3FS) Copy(src, dst *url.URL) error {
 		return err
 	}
 
-	client, err := getS3Client(srcOpts)
+	client, err := resolveClient(srcOpts)
 	if err != nil {
 		return err
 	}
@@ -177,19 +171,15 @@ func (fs *S3FS) Copy(src, dst *url.URL) error {
 		relativePath := strings.TrimPrefix(childKey, srcOpts.Key)
 		dstKey := dstOpts.Key + relativePath
 
-		childDstURL := &url.URL{
-			Scheme: S3Scheme,
-			Host:   dstOpts.Bucket,
-			Path:   "/" + dstKey,
-		}
+		childDstURL := dstOpts.childURL(dstKey)
 
 		if childInfo.IsDir() {
 			if copyErr := fs.Copy(child.Url(), childDstURL); copyErr != nil {
 				return copyErr
 			}
 		} else {
-			childSrcOpts := &urlOpts{u: child.Url(), Bucket: srcOpts.Bucket, Key: childKey}
-			childDstOpts := &urlOpts{u: childDstURL, Bucket: dstOpts.Bucket, Key: dstKey}
+			childSrcOpts := srcOpts.withKey(childKey)
+			childDstOpts := dstOpts.withKey(dstKey)
 			if copyErr := fs.copySingleObject(client, childSrcOpts, childDstOpts); copyErr != nil {
 				return copyErr
 			}
@@ -200,13 +190,17 @@ func (fs *S3FS) Copy(src, dst *url.URL) error {
 }
 
 // copySingleObject copies a single S3 object using server-side copy if same region, otherwise streams.
-func (fs *S3FS) copySingleObject(client *awss3.Client, src, dst *urlOpts) error {
-	// Use S3 server-side copy
-	copySource := src.Bucket + "/" + src.Key
+// Objects whose codecs differ between src and dst are always streamed so
+// they can be decoded and encoded again.
+func (fs *S3FS) copySingleObject(client s3API, src, dst *urlOpts) error {
+	if !sameCodecs(src, dst) {
+		return fs.streamCopy(client, src, dst)
+	}
+	// Use S3 server-side copy; it keeps the metadata and Content-Encoding.
 	input := &awss3.CopyObjectInput{
 		Bucket:     aws.String(dst.Bucket),
 		Key:        aws.String(dst.Key),
-		CopySource: aws.String(copySource),
+		CopySource: aws.String(src.copySource()),
 	}
 	_, err := client.CopyObject(context.Background(), input)
 	if err != nil {
@@ -217,12 +211,15 @@ func (fs *S3FS) copySingleObject(client *awss3.Client, src, dst *urlOpts) error
 }
 
 // streamCopy reads from source and writes to destination (for cross-region copies).
-func (fs *S3FS) streamCopy(client *awss3.Client, src, dst *urlOpts) error {
+// The metadata and Content-Encoding go along with the bytes, or the object
+// is decoded and encoded for dst when the codecs of the two differ.
+func (fs *S3FS) streamCopy(client s3API, src, dst *urlOpts) error {
+	ctx := context.Background()
 	getInput := &awss3.GetObjectInput{
 		Bucket: aws.String(src.Bucket),
 		Key:    aws.String(src.Key),
 	}
-	getResult, err := client.GetObject(context.Background(), getInput)
+	getResult, err := client.GetObject(ctx, getInput)
 	if err != nil {
 		return mapS3Err(err)
 	}
@@ -231,15 +228,61 @@ func (fs *S3FS) streamCopy(client *awss3.Client, src, dst *urlOpts) error {
 	}()
 
 	putInput := &awss3.PutObjectInput{
-		Bucket:      aws.String(dst.Bucket),
-		Key:         aws.String(dst.Key),
-		Body:        getResult.Body,
-		ContentType: getResult.ContentType,
+		Bucket:          aws.String(dst.Bucket),
+		Key:             aws.String(dst.Key),
+		Body:            getResult.Body,
+		ContentLength:   getResult.ContentLength,
+		ContentType:     getResult.ContentType,
+		ContentEncoding: getResult.ContentEncoding,
+		Metadata:        getResult.Metadata,
+	}
+	if !sameCodecs(src, dst) {
+		if err = recodeObject(ctx, src, dst, getResult, putInput); err != nil {
+			return err
+		}
 	}
-	_, err = client.PutObject(context.Background(), putInput)
+	_, err = client.PutObject(ctx, putInput)
 	return mapS3Err(err)
 }
 
+// recodeObject decodes the object in get, read from src, and sets the body
+// of put to its content encoded for dst. User metadata is kept; the codec
+// metadata is that of the new encoding.
+func recodeObject(ctx context.Context, src, dst *urlOpts, get *awss3.GetObjectOutput, put *awss3.PutObjectInput) error {
+	body, err := decodeObject(ctx, src, get.Body, aws.ToString(get.ContentEncoding),
+		aws.ToInt64(get.ContentLength), get.Metadata)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = body.Close() }()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("s3: decode %s: %w", src.Key, err)
+	}
+	if body != get.Body {
+		// The Content-Encoding described the codecs just removed.
+		put.ContentEncoding = nil
+	}
+	put.Metadata = userMetadata(get.Metadata)
+	enc, err := encodeObject(ctx, dst, data)
+	if err != nil {
+		return err
+	}
+	if enc != nil {
+		data = enc.data
+		for k, v := range enc.metadata {
+			put.Metadata[k] = v
+		}
+		put.ContentEncoding = nil
+		if enc.contentEncoding != "" {
+			put.ContentEncoding = aws.String(enc.contentEncoding)
+		}
+	}
+	put.Body = bytes.NewReader(data)
+	put.ContentLength = aws.Int64(int64(len(data)))
+	return nil
+}
+
 // Delete deletes the object at the given URL. If it's a directory, deletes all children.
 func (fs *S3FS) Delete(src *url.URL) error {
 	srcOpts, err := parseURL(src)
@@ -247,7 +290,7 @@ func (fs *S3FS) Delete(src *url.URL) error {
 		return err
 	}
 
-	client, err := getS3Client(srcOpts)
+	client, err := resolveClient(srcOpts)
 	if err != nil {
 		return err
 	}
@@ -269,7 +312,7 @@ func (fs *S3FS) List(u *url.URL) ([]vfs.VFile, error) {
 		return nil, err
 	}
 
-	client, err := getS3Client(opts)
+	client, err := resolveClient(opts)
 	if err != nil {
 		return nil, err
 	}
@@ -298,11 +341,7 @@ func (fs *S3FS) List(u *url.URL) ([]vfs.VFile, error) {
 			if key == prefix {
 				continue
 			}
-			childURL := &url.URL{
-				Scheme: S3Scheme,
-				Host:   opts.Bucket,
-				Path:   "/" + key,
-			}
+			childURL := opts.childURL(key)
 			child, openErr := fs.Open(childURL)
 			if openErr != nil {
 				return nil, openErr
@@ -312,11 +351,7 @@ func (fs *S3FS) List(u *url.URL) ([]vfs.VFile, error) {
 
 		for _, cp := range page.CommonPrefixes {
 			cpKey := aws.ToString(cp.Prefix)
-			childURL := &url.URL{
-				Scheme: S3Scheme,
-				Host:   opts.Bucket,
-				Path:   "/" + cpKey,
-			}
+			childURL := opts.childURL(cpKey)
 			child, openErr := fs.Open(childURL)
 			if openErr != nil {
 				return nil, openErr
@@ -335,7 +370,7 @@ func (fs *S3FS) Walk(u *url.URL, fn vfs.WalkFn) error {
 		return err
 	}
 
-	client, err := getS3Client(opts)
+	client, err := resolveClient(opts)
 	if err != nil {
 		return err
 	}
@@ -362,11 +397,7 @@ func (fs *S3FS) Walk(u *url.URL, fn vfs.WalkFn) error {
 			if key == prefix {
 				continue
 			}
-			childURL := &url.URL{
-				Scheme: S3Scheme,
-				Host:   opts.Bucket,
-				Path:   "/" + key,
-			}
+			childURL := opts.childURL(key)
 			child, openErr := fs.Open(childURL)
 			if openErr != nil {
 				return openErr
@@ -0,0 +1,594 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// BucketAdmin is the bucket-level management capability of S3FS. Every
+// method takes an s3://bucket URL (any key part is ignored) and resolves
+// its client through the same awscfg mapping as object operations, so a
+// per-bucket config registered for data access also applies here.
+//
+// Get* methods return the zero value (nil slice or nil pointer) when the
+// bucket has no such configuration. Ensure* methods are idempotent: they
+// read the current state, write only when it differs from the desired
+// state, and report whether anything changed.
+type BucketAdmin interface {
+	CreateBucket(ctx context.Context, u *url.URL) error
+	DeleteBucket(ctx context.Context, u *url.URL) error
+	BucketExists(ctx context.Context, u *url.URL) (bool, error)
+	EnsureBucket(ctx context.Context, u *url.URL) (bool, error)
+
+	GetLifecycle(ctx context.Context, u *url.URL) ([]LifecycleRule, error)
+	PutLifecycle(ctx context.Context, u *url.URL, rules []LifecycleRule) error
+	DeleteLifecycle(ctx context.Context, u *url.URL) error
+	EnsureLifecycle(ctx context.Context, u *url.URL, rules []LifecycleRule) (bool, error)
+
+	GetCORS(ctx context.Context, u *url.URL) ([]CORSRule, error)
+	PutCORS(ctx context.Context, u *url.URL, rules []CORSRule) error
+	DeleteCORS(ctx context.Context, u *url.URL) error
+	EnsureCORS(ctx context.Context, u *url.URL, rules []CORSRule) (bool, error)
+
+	GetVersioning(ctx context.Context, u *url.URL) (VersioningStatus, error)
+	PutVersioning(ctx context.Context, u *url.URL, status VersioningStatus) error
+	EnsureVersioning(ctx context.Context, u *url.URL, status VersioningStatus) (bool, error)
+
+	GetEncryption(ctx context.Context, u *url.URL) (*BucketEncryption, error)
+	PutEncryption(ctx context.Context, u *url.URL, enc *BucketEncryption) error
+	DeleteEncryption(ctx context.Context, u *url.URL) error
+	EnsureEncryption(ctx context.Context, u *url.URL, enc *BucketEncryption) (bool, error)
+
+	GetPolicy(ctx context.Context, u *url.URL) (*BucketPolicy, error)
+	PutPolicy(ctx context.Context, u *url.URL, policy *BucketPolicy) error
+	DeletePolicy(ctx context.Context, u *url.URL) error
+	EnsurePolicy(ctx context.Context, u *url.URL, policy *BucketPolicy) (bool, error)
+
+	GetPublicAccessBlock(ctx context.Context, u *url.URL) (*PublicAccessBlock, error)
+	PutPublicAccessBlock(ctx context.Context, u *url.URL, pab *PublicAccessBlock) error
+	DeletePublicAccessBlock(ctx context.Context, u *url.URL) error
+	EnsurePublicAccessBlock(ctx context.Context, u *url.URL, pab *PublicAccessBlock) (bool, error)
+}
+
+// Compile-time check that S3FS exposes the admin capability.
+var _ BucketAdmin = (*S3FS)(nil)
+
+// Admin returns the BucketAdmin of the S3 filesystem registered with the
+// golly VFS manager.
+func Admin() BucketAdmin {
+	return storageFs
+}
+
+// adminClient parses u and resolves the admin client for its bucket.
+func adminClient(u *url.URL) (s3AdminAPI, string, error) {
+	opts, err := parseURL(u)
+	if err != nil {
+		return nil, "", err
+	}
+	client, err := resolveAdminClient(opts)
+	if err != nil {
+		return nil, "", err
+	}
+	return client, opts.Bucket, nil
+}
+
+// isAPIErrorCode reports whether err is an S3 API error with one of codes.
+func isAPIErrorCode(err error, codes ...string) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	for _, c := range codes {
+		if apiErr.ErrorCode() == c {
+			return true
+		}
+	}
+	return false
+}
+
+// ---- Buckets ----------------------------------------------------------------
+
+// CreateBucket creates the bucket named by u in the region of its resolved
+// config. Outside us-east-1 the region is sent as the LocationConstraint,
+// as S3 requires. Directory buckets are created in the zone named by
+// their "--zone-id--x-s3" suffix. Access points cannot be created here.
+func (fs *S3FS) CreateBucket(ctx context.Context, u *url.URL) error {
+	opts, err := parseURL(u)
+	if err != nil {
+		return err
+	}
+	client, err := resolveAdminClient(opts)
+	if err != nil {
+		return err
+	}
+	input, err := createBucketInput(opts, client.Options().Region)
+	if err != nil {
+		return err
+	}
+	_, err = client.CreateBucket(ctx, input)
+	return mapS3Err(err)
+}
+
+// createBucketInput builds the CreateBucket request for opts in region.
+func createBucketInput(opts *urlOpts, region string) (*awss3.CreateBucketInput, error) {
+	input := &awss3.CreateBucketInput{Bucket: aws.String(opts.Bucket)}
+	switch opts.Kind {
+	case BucketGeneral:
+		if region != "" && region != "us-east-1" {
+			input.CreateBucketConfiguration = &s3types.CreateBucketConfiguration{
+				LocationConstraint: s3types.BucketLocationConstraint(region),
+			}
+		}
+	case BucketDirectory:
+		input.CreateBucketConfiguration = directoryBucketConfiguration(opts.Bucket)
+	default:
+		return nil, fmt.Errorf("s3: cannot create %s %q, only buckets and directory buckets", opts.Kind, opts.Bucket)
+	}
+	return input, nil
+}
+
+// directoryBucketConfiguration returns the configuration of the directory
+// bucket "name--zone-id--x-s3". Availability Zone IDs have two segments
+// ("use1-az4"); Local Zone IDs have three ("usw2-lax1-az1").
+func directoryBucketConfiguration(bucket string) *s3types.CreateBucketConfiguration {
+	name := strings.TrimSuffix(bucket, "--x-s3")
+	zoneID := name[strings.LastIndex(name, "--")+2:]
+	location, redundancy := s3types.LocationTypeAvailabilityZone, s3types.DataRedundancySingleAvailabilityZone
+	if strings.Count(zoneID, "-") > 1 {
+		location, redundancy = s3types.LocationTypeLocalZone, s3types.DataRedundancySingleLocalZone
+	}
+	return &s3types.CreateBucketConfiguration{
+		Location: &s3types.LocationInfo{Type: location, Name: aws.String(zoneID)},
+		Bucket:   &s3types.BucketInfo{Type: s3types.BucketTypeDirectory, DataRedundancy: redundancy},
+	}
+}
+
+// DeleteBucket deletes the bucket named by u. S3 refuses to delete a
+// bucket that still contains objects.
+func (fs *S3FS) DeleteBucket(ctx context.Context, u *url.URL) error {
+	client, bucket, err := adminClient(u)
+	if err != nil {
+		return err
+	}
+	_, err = client.DeleteBucket(ctx, &awss3.DeleteBucketInput{Bucket: aws.String(bucket)})
+	return mapS3Err(err)
+}
+
+// BucketExists reports whether the bucket named by u exists and is
+// accessible with the resolved credentials.
+func (fs *S3FS) BucketExists(ctx context.Context, u *url.URL) (bool, error) {
+	client, bucket, err := adminClient(u)
+	if err != nil {
+		return false, err
+	}
+	return bucketExists(ctx, client, bucket)
+}
+
+func bucketExists(ctx context.Context, client s3AdminAPI, bucket string) (bool, error) {
+	_, err := client.HeadBucket(ctx, &awss3.HeadBucketInput{Bucket: aws.String(bucket)})
+	if err == nil {
+		return true, nil
+	}
+	var notFound *s3types.NotFound
+	if errors.As(err, &notFound) || isAPIErrorCode(err, "NoSuchBucket", "NotFound") {
+		return false, nil
+	}
+	return false, mapS3Err(err)
+}
+
+// EnsureBucket creates the bucket named by u unless it already exists. It
+// reports whether the bucket was created.
+func (fs *S3FS) EnsureBucket(ctx context.Context, u *url.URL) (bool, error) {
+	client, bucket, err := adminClient(u)
+	if err != nil {
+		return false, err
+	}
+	exists, err := bucketExists(ctx, client, bucket)
+	if err != nil || exists {
+		return false, err
+	}
+	err = fs.CreateBucket(ctx, u)
+	var owned *s3types.BucketAlreadyOwnedByYou
+	if errors.As(err, &owned) {
+		// Lost a race with a concurrent EnsureBucket — still ours.
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ---- Lifecycle --------------------------------------------------------------
+
+// GetLifecycle returns the bucket's lifecycle rules.
+func (fs *S3FS) GetLifecycle(ctx context.Context, u *url.URL) ([]LifecycleRule, error) {
+	client, bucket, err := adminClient(u)
+	if err != nil {
+		return nil, err
+	}
+	out, err := client.GetBucketLifecycleConfiguration(ctx, &awss3.GetBucketLifecycleConfigurationInput{Bucket: aws.String(bucket)})
+	if isAPIErrorCode(err, "NoSuchLifecycleConfiguration") {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, mapS3Err(err)
+	}
+	return lifecycleRulesFromSDK(out.Rules), nil
+}
+
+// PutLifecycle replaces the bucket's lifecycle rules. An empty rule set
+// removes the lifecycle configuration.
+func (fs *S3FS) PutLifecycle(ctx context.Context, u *url.URL, rules []LifecycleRule) error {
+	if len(rules) == 0 {
+		return fs.DeleteLifecycle(ctx, u)
+	}
+	client, bucket, err := adminClient(u)
+	if err != nil {
+		return err
+	}
+	_, err = client.PutBucketLifecycleConfiguration(ctx, &awss3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 aws.String(bucket),
+		LifecycleConfiguration: &s3types.BucketLifecycleConfiguration{Rules: lifecycleRulesToSDK(rules)},
+	})
+	return mapS3Err(err)
+}
+
+// DeleteLifecycle removes the bucket's lifecycle configuration.
+func (fs *S3FS) DeleteLifecycle(ctx context.Context, u *url.URL) error {
+	client, bucket, err := adminClient(u)
+	if err != nil {
+		return err
+	}
+	_, err = client.DeleteBucketLifecycle(ctx, &awss3.DeleteBucketLifecycleInput{Bucket: aws.String(bucket)})
+	return mapS3Err(err)
+}
+
+// EnsureLifecycle makes the bucket's lifecycle rules equal to rules.
+func (fs *S3FS) EnsureLifecycle(ctx context.Context, u *url.URL, rules []LifecycleRule) (bool, error) {
+	current, err := fs.GetLifecycle(ctx, u)
+	if err != nil {
+		return false, err
+	}
+	if reflect.DeepEqual(current, lifecycleRulesFromSDK(lifecycleRulesToSDK(rules))) {
+		return false, nil
+	}
+	if err := fs.PutLifecycle(ctx, u, rules); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ---- CORS -------------------------------------------------------------------
+
+// GetCORS returns the bucket's CORS rules.
+func (fs *S3FS) GetCORS(ctx context.Context, u *url.URL) ([]CORSRule, error) {
+	client, bucket, err := adminClient(u)
+	if err != nil {
+		return nil, err
+	}
+	out, err := client.GetBucketCors(ctx, &awss3.GetBucketCorsInput{Bucket: aws.String(bucket)})
+	if isAPIErrorCode(err, "NoSuchCORSConfiguration") {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, mapS3Err(err)
+	}
+	return corsRulesFromSDK(out.CORSRules), nil
+}
+
+// PutCORS replaces the bucket's CORS rules. An empty rule set removes the
+// CORS configuration.
+func (fs *S3FS) PutCORS(ctx context.Context, u *url.URL, rules []CORSRule) error {
+	if len(rules) == 0 {
+		return fs.DeleteCORS(ctx, u)
+	}
+	client, bucket, err := adminClient(u)
+	if err != nil {
+		return err
+	}
+	_, err = client.PutBucketCors(ctx, &awss3.PutBucketCorsInput{
+		Bucket:            aws.String(bucket),
+		CORSConfiguration: &s3types.CORSConfiguration{CORSRules: corsRulesToSDK(rules)},
+	})
+	return mapS3Err(err)
+}
+
+// DeleteCORS removes the bucket's CORS configuration.
+func (fs *S3FS) DeleteCORS(ctx context.Context, u *url.URL) error {
+	client, bucket, err := adminClient(u)
+	if err != nil {
+		return err
+	}
+	_, err = client.DeleteBucketCors(ctx, &awss3.DeleteBucketCorsInput{Bucket: aws.String(bucket)})
+	return mapS3Err(err)
+}
+
+// EnsureCORS makes the bucket's CORS rules equal to rules.
+func (fs *S3FS) EnsureCORS(ctx context.Context, u *url.URL, rules []CORSRule) (bool, error) {
+	current, err := fs.GetCORS(ctx, u)
+	if err != nil {
+		return false, err
+	}
+	if reflect.DeepEqual(current, corsRulesFromSDK(corsRulesToSDK(rules))) {
+		return false, nil
+	}
+	if err := fs.PutCORS(ctx, u, rules); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ---- Versioning -------------------------------------------------------------
+
+// GetVersioning returns the bucket's versioning status.
+func (fs *S3FS) GetVersioning(ctx context.Context, u *url.URL) (VersioningStatus, error) {
+	client, bucket, err := adminClient(u)
+	if err != nil {
+		return VersioningUnversioned, err
+	}
+	out, err := client.GetBucketVersioning(ctx, &awss3.GetBucketVersioningInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		return VersioningUnversioned, mapS3Err(err)
+	}
+	return VersioningStatus(out.Status), nil
+}
+
+// PutVersioning sets the bucket's versioning status. A versioned bucket
+// can only be suspended, never returned to VersioningUnversioned.
+func (fs *S3FS) PutVersioning(ctx context.Context, u *url.URL, status VersioningStatus) error {
+	if status != VersioningEnabled && status != VersioningSuspended {
+		return fmt.Errorf("s3: versioning status must be %q or %q", VersioningEnabled, VersioningSuspended)
+	}
+	client, bucket, err := adminClient(u)
+	if err != nil {
+		return err
+	}
+	_, err = client.PutBucketVersioning(ctx, &awss3.PutBucketVersioningInput{
+		Bucket:                  aws.String(bucket),
+		VersioningConfiguration: &s3types.VersioningConfiguration{Status: s3types.BucketVersioningStatus(status)},
+	})
+	return mapS3Err(err)
+}
+
+// EnsureVersioning makes the bucket's versioning status equal to status.
+// Ensuring VersioningUnversioned succeeds only on a bucket that has never
+// been versioned.
+func (fs *S3FS) EnsureVersioning(ctx context.Context, u *url.URL, status VersioningStatus) (bool, error) {
+	current, err := fs.GetVersioning(ctx, u)
+	if err != nil {
+		return false, err
+	}
+	if current == status {
+		return false, nil
+	}
+	if status == VersioningUnversioned {
+		return false, fmt.Errorf("s3: versioning is %q and cannot be removed; use %q", current, VersioningSuspended)
+	}
+	if current == VersioningUnversioned && status == VersioningSuspended {
+		// Suspending a never-versioned bucket is a no-op in effect.
+		return false, nil
+	}
+	if err := fs.PutVersioning(ctx, u, status); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ---- Encryption -------------------------------------------------------------
+
+// GetEncryption returns the bucket's default encryption, or nil when none
+// is configured.
+func (fs *S3FS) GetEncryption(ctx context.Context, u *url.URL) (*BucketEncryption, error) {
+	client, bucket, err := adminClient(u)
+	if err != nil {
+		return nil, err
+	}
+	out, err := client.GetBucketEncryption(ctx, &awss3.GetBucketEncryptionInput{Bucket: aws.String(bucket)})
+	if isAPIErrorCode(err, "ServerSideEncryptionConfigurationNotFoundError") {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, mapS3Err(err)
+	}
+	return encryptionFromSDK(out.ServerSideEncryptionConfiguration), nil
+}
+
+// PutEncryption sets the bucket's default encryption.
+func (fs *S3FS) PutEncryption(ctx context.Context, u *url.URL, enc *BucketEncryption) error {
+	if enc == nil || enc.Algorithm == "" {
+		return errors.New("s3: encryption algorithm is required")
+	}
+	client, bucket, err := adminClient(u)
+	if err != nil {
+		return err
+	}
+	_, err = client.PutBucketEncryption(ctx, &awss3.PutBucketEncryptionInput{
+		Bucket:                            aws.String(bucket),
+		ServerSideEncryptionConfiguration: encryptionToSDK(enc),
+	})
+	return mapS3Err(err)
+}
+
+// DeleteEncryption removes the bucket's default encryption configuration.
+func (fs *S3FS) DeleteEncryption(ctx context.Context, u *url.URL) error {
+	client, bucket, err := adminClient(u)
+	if err != nil {
+		return err
+	}
+	_, err = client.DeleteBucketEncryption(ctx, &awss3.DeleteBucketEncryptionInput{Bucket: aws.String(bucket)})
+	return mapS3Err(err)
+}
+
+// EnsureEncryption makes the bucket's default encryption equal to enc. A
+// nil enc removes the configuration.
+func (fs *S3FS) EnsureEncryption(ctx context.Context, u *url.URL, enc *BucketEncryption) (bool, error) {
+	current, err := fs.GetEncryption(ctx, u)
+	if err != nil {
+		return false, err
+	}
+	if reflect.DeepEqual(current, enc) {
+		return false, nil
+	}
+	if enc == nil {
+		if err := fs.DeleteEncryption(ctx, u); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	if err := fs.PutEncryption(ctx, u, enc); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ---- Policy -----------------------------------------------------------------
+
+// GetPolicy returns the bucket policy, or nil when none is attached.
+func (fs *S3FS) GetPolicy(ctx context.Context, u *url.URL) (*BucketPolicy, error) {
+	client, bucket, err := adminClient(u)
+	if err != nil {
+		return nil, err
+	}
+	out, err := client.GetBucketPolicy(ctx, &awss3.GetBucketPolicyInput{Bucket: aws.String(bucket)})
+	if isAPIErrorCode(err, "NoSuchBucketPolicy") {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, mapS3Err(err)
+	}
+	return ParseBucketPolicy(aws.ToString(out.Policy))
+}
+
+// PutPolicy attaches policy to the bucket, replacing any existing policy.
+// An empty Version defaults to PolicyVersion.
+func (fs *S3FS) PutPolicy(ctx context.Context, u *url.URL, policy *BucketPolicy) error {
+	if policy == nil {
+		return errors.New("s3: bucket policy is required")
+	}
+	policy = policy.withDefaults()
+	if err := policy.validate(); err != nil {
+		return err
+	}
+	client, bucket, err := adminClient(u)
+	if err != nil {
+		return err
+	}
+	_, err = client.PutBucketPolicy(ctx, &awss3.PutBucketPolicyInput{
+		Bucket: aws.String(bucket),
+		Policy: aws.String(policy.String()),
+	})
+	return mapS3Err(err)
+}
+
+// DeletePolicy removes the bucket policy.
+func (fs *S3FS) DeletePolicy(ctx context.Context, u *url.URL) error {
+	client, bucket, err := adminClient(u)
+	if err != nil {
+		return err
+	}
+	_, err = client.DeleteBucketPolicy(ctx, &awss3.DeleteBucketPolicyInput{Bucket: aws.String(bucket)})
+	return mapS3Err(err)
+}
+
+// EnsurePolicy makes the bucket policy equal to policy. Documents are
+// compared structurally, so formatting and single-value-vs-array
+// differences do not trigger a write. A nil policy removes it.
+func (fs *S3FS) EnsurePolicy(ctx context.Context, u *url.URL, policy *BucketPolicy) (bool, error) {
+	current, err := fs.GetPolicy(ctx, u)
+	if err != nil {
+		return false, err
+	}
+	if policy == nil {
+		if current == nil {
+			return false, nil
+		}
+		if err := fs.DeletePolicy(ctx, u); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	if current != nil && reflect.DeepEqual(current, policy.withDefaults()) {
+		return false, nil
+	}
+	if err := fs.PutPolicy(ctx, u, policy); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ---- Public access block ----------------------------------------------------
+
+// GetPublicAccessBlock returns the bucket's public access block, or nil
+// when none is configured.
+func (fs *S3FS) GetPublicAccessBlock(ctx context.Context, u *url.URL) (*PublicAccessBlock, error) {
+	client, bucket, err := adminClient(u)
+	if err != nil {
+		return nil, err
+	}
+	out, err := client.GetPublicAccessBlock(ctx, &awss3.GetPublicAccessBlockInput{Bucket: aws.String(bucket)})
+	if isAPIErrorCode(err, "NoSuchPublicAccessBlockConfiguration") {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, mapS3Err(err)
+	}
+	return publicAccessBlockFromSDK(out.PublicAccessBlockConfiguration), nil
+}
+
+// PutPublicAccessBlock sets the bucket's public access block.
+func (fs *S3FS) PutPublicAccessBlock(ctx context.Context, u *url.URL, pab *PublicAccessBlock) error {
+	if pab == nil {
+		return errors.New("s3: public access block is required")
+	}
+	client, bucket, err := adminClient(u)
+	if err != nil {
+		return err
+	}
+	_, err = client.PutPublicAccessBlock(ctx, &awss3.PutPublicAccessBlockInput{
+		Bucket:                         aws.String(bucket),
+		PublicAccessBlockConfiguration: publicAccessBlockToSDK(pab),
+	})
+	return mapS3Err(err)
+}
+
+// DeletePublicAccessBlock removes the bucket's public access block.
+func (fs *S3FS) DeletePublicAccessBlock(ctx context.Context, u *url.URL) error {
+	client, bucket, err := adminClient(u)
+	if err != nil {
+		return err
+	}
+	_, err = client.DeletePublicAccessBlock(ctx, &awss3.DeletePublicAccessBlockInput{Bucket: aws.String(bucket)})
+	return mapS3Err(err)
+}
+
+// EnsurePublicAccessBlock makes the bucket's public access block equal to
+// pab. A nil pab removes it.
+func (fs *S3FS) EnsurePublicAccessBlock(ctx context.Context, u *url.URL, pab *PublicAccessBlock) (bool, error) {
+	current, err := fs.GetPublicAccessBlock(ctx, u)
+	if err != nil {
+		return false, err
+	}
+	if reflect.DeepEqual(current, pab) {
+		return false, nil
+	}
+	if pab == nil {
+		if err := fs.DeletePublicAccessBlock(ctx, u); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	if err := fs.PutPublicAccessBlock(ctx, u, pab); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,251 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"oss.nandlabs.io/golly-aws/awscfg"
+	"oss.nandlabs.io/golly/vfs"
+)
+
+// registerMemoryBucket points bucket at a fresh MemoryBackend in region
+// without creating it.
+func registerMemoryBucket(t *testing.T, bucket, region string) *MemoryBackend {
+	t.Helper()
+	mem := NewMemoryBackend()
+	awscfg.Manager.Register(bucket, mem.Config(region))
+	t.Cleanup(func() { awscfg.Manager.Unregister(bucket) })
+	return mem
+}
+
+func bucketURL(bucket string) *url.URL {
+	return &url.URL{Scheme: S3Scheme, Host: bucket}
+}
+
+func TestAdmin_EnsureBucketCreatesOnceWithRegion(t *testing.T) {
+	mem := registerMemoryBucket(t, "admin-eu", "eu-west-1")
+	ctx := context.Background()
+	admin := Admin()
+	u := bucketURL("admin-eu")
+
+	created, err := admin.EnsureBucket(ctx, u)
+	if err != nil || !created {
+		t.Fatalf("first EnsureBucket = %v, %v", created, err)
+	}
+	created, err = admin.EnsureBucket(ctx, u)
+	if err != nil || created {
+		t.Fatalf("second EnsureBucket = %v, %v", created, err)
+	}
+
+	client := awss3.NewFromConfig(aws.Config{
+		Region:      "eu-west-1",
+		HTTPClient:  mem.HTTPClient(),
+		Credentials: aws.AnonymousCredentials{},
+	}, func(o *awss3.Options) {
+		o.BaseEndpoint = aws.String(MemoryEndpoint)
+		o.UsePathStyle = true
+	})
+	loc, err := client.GetBucketLocation(ctx, &awss3.GetBucketLocationInput{Bucket: aws.String("admin-eu")})
+	if err != nil {
+		t.Fatalf("GetBucketLocation: %v", err)
+	}
+	if loc.LocationConstraint != s3types.BucketLocationConstraintEuWest1 {
+		t.Fatalf("LocationConstraint = %q", loc.LocationConstraint)
+	}
+
+	if err := admin.DeleteBucket(ctx, u); err != nil {
+		t.Fatalf("DeleteBucket: %v", err)
+	}
+	exists, err := admin.BucketExists(ctx, u)
+	if err != nil || exists {
+		t.Fatalf("BucketExists after delete = %v, %v", exists, err)
+	}
+	if err := admin.DeleteBucket(ctx, u); !errors.Is(err, vfs.ErrNotExist) {
+		t.Fatalf("DeleteBucket on missing bucket = %v", err)
+	}
+}
+
+func TestAdmin_EnsureLifecycleAndCORS(t *testing.T) {
+	newMemoryClient(t, "admin-rules")
+	ctx := context.Background()
+	admin := Admin()
+	u := bucketURL("admin-rules")
+
+	rules, err := admin.GetLifecycle(ctx, u)
+	if err != nil || rules != nil {
+		t.Fatalf("GetLifecycle on fresh bucket = %v, %v", rules, err)
+	}
+	want := []LifecycleRule{{
+		ID:                        "logs",
+		Prefix:                    "logs/",
+		Enabled:                   true,
+		ExpirationDays:            30,
+		AbortIncompleteUploadDays: 7,
+		Transitions:               []LifecycleTransition{{Days: 10, StorageClass: "STANDARD_IA"}},
+	}}
+	changed, err := admin.EnsureLifecycle(ctx, u, want)
+	if err != nil || !changed {
+		t.Fatalf("EnsureLifecycle = %v, %v", changed, err)
+	}
+	changed, err = admin.EnsureLifecycle(ctx, u, want)
+	if err != nil || changed {
+		t.Fatalf("repeat EnsureLifecycle = %v, %v", changed, err)
+	}
+	changed, err = admin.EnsureLifecycle(ctx, u, nil)
+	if err != nil || !changed {
+		t.Fatalf("EnsureLifecycle(nil) = %v, %v", changed, err)
+	}
+	if rules, _ := admin.GetLifecycle(ctx, u); rules != nil {
+		t.Fatalf("lifecycle not removed: %v", rules)
+	}
+
+	cors := []CORSRule{{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"GET", "HEAD"}, MaxAgeSeconds: 300}}
+	changed, err = admin.EnsureCORS(ctx, u, cors)
+	if err != nil || !changed {
+		t.Fatalf("EnsureCORS = %v, %v", changed, err)
+	}
+	changed, err = admin.EnsureCORS(ctx, u, cors)
+	if err != nil || changed {
+		t.Fatalf("repeat EnsureCORS = %v, %v", changed, err)
+	}
+}
+
+func TestAdmin_EnsureVersioningEncryptionAndAccessBlock(t *testing.T) {
+	newMemoryClient(t, "admin-flags")
+	ctx := context.Background()
+	admin := Admin()
+	u := bucketURL("admin-flags")
+
+	status, err := admin.GetVersioning(ctx, u)
+	if err != nil || status != VersioningUnversioned {
+		t.Fatalf("GetVersioning = %q, %v", status, err)
+	}
+	if changed, err := admin.EnsureVersioning(ctx, u, VersioningEnabled); err != nil || !changed {
+		t.Fatalf("EnsureVersioning = %v, %v", changed, err)
+	}
+	if changed, err := admin.EnsureVersioning(ctx, u, VersioningEnabled); err != nil || changed {
+		t.Fatalf("repeat EnsureVersioning = %v, %v", changed, err)
+	}
+	if _, err := admin.EnsureVersioning(ctx, u, VersioningUnversioned); err == nil {
+		t.Fatalf("expected error un-versioning a versioned bucket")
+	}
+
+	enc := &BucketEncryption{Algorithm: EncryptionKMS, KMSKeyID: "alias/data", BucketKeyEnabled: true}
+	if changed, err := admin.EnsureEncryption(ctx, u, enc); err != nil || !changed {
+		t.Fatalf("EnsureEncryption = %v, %v", changed, err)
+	}
+	if changed, err := admin.EnsureEncryption(ctx, u, enc); err != nil || changed {
+		t.Fatalf("repeat EnsureEncryption = %v, %v", changed, err)
+	}
+
+	pab := &PublicAccessBlock{BlockPublicAcls: true, IgnorePublicAcls: true, BlockPublicPolicy: true, RestrictPublicBuckets: true}
+	if changed, err := admin.EnsurePublicAccessBlock(ctx, u, pab); err != nil || !changed {
+		t.Fatalf("EnsurePublicAccessBlock = %v, %v", changed, err)
+	}
+	got, err := admin.GetPublicAccessBlock(ctx, u)
+	if err != nil || *got != *pab {
+		t.Fatalf("GetPublicAccessBlock = %+v, %v", got, err)
+	}
+	if changed, err := admin.EnsurePublicAccessBlock(ctx, u, nil); err != nil || !changed {
+		t.Fatalf("EnsurePublicAccessBlock(nil) = %v, %v", changed, err)
+	}
+}
+
+func TestAdmin_EnsurePolicy(t *testing.T) {
+	newMemoryClient(t, "admin-policy")
+	ctx := context.Background()
+	admin := Admin()
+	u := bucketURL("admin-policy")
+
+	policy := &BucketPolicy{Statements: []PolicyStatement{{
+		Sid:       "DenyInsecure",
+		Effect:    "Deny",
+		Principal: &PolicyPrincipal{Anyone: true},
+		Action:    PolicyValues{"s3:*"},
+		Resource:  PolicyValues{"arn:aws:s3:::admin-policy", "arn:aws:s3:::admin-policy/*"},
+		Condition: map[string]map[string]PolicyValues{"Bool": {"aws:SecureTransport": {"false"}}},
+	}}}
+	if changed, err := admin.EnsurePolicy(ctx, u, policy); err != nil || !changed {
+		t.Fatalf("EnsurePolicy = %v, %v", changed, err)
+	}
+	if changed, err := admin.EnsurePolicy(ctx, u, policy); err != nil || changed {
+		t.Fatalf("repeat EnsurePolicy = %v, %v", changed, err)
+	}
+	got, err := admin.GetPolicy(ctx, u)
+	if err != nil {
+		t.Fatalf("GetPolicy: %v", err)
+	}
+	if got.Version != PolicyVersion || got.Statements[0].Sid != "DenyInsecure" || !got.Statements[0].Principal.Anyone {
+		t.Fatalf("GetPolicy = %s", got)
+	}
+
+	if err := admin.PutPolicy(ctx, u, &BucketPolicy{}); err == nil {
+		t.Fatalf("expected validation error for empty policy")
+	}
+}
+
+func TestParseBucketPolicy_AcceptsStringOrArrayForms(t *testing.T) {
+	p, err := ParseBucketPolicy(`{
+		"Version": "2012-10-17",
+		"Statement": [{
+			"Effect": "Allow",
+			"Principal": {"AWS": ["arn:aws:iam::111122223333:root"], "Service": "logging.s3.amazonaws.com"},
+			"Action": "s3:PutObject",
+			"Resource": ["arn:aws:s3:::b/*"]
+		}]
+	}`)
+	if err != nil {
+		t.Fatalf("ParseBucketPolicy: %v", err)
+	}
+	st := p.Statements[0]
+	if len(st.Action) != 1 || st.Action[0] != "s3:PutObject" {
+		t.Fatalf("Action = %v", st.Action)
+	}
+	if st.Principal.Anyone || st.Principal.Service[0] != "logging.s3.amazonaws.com" || len(st.Principal.AWS) != 1 {
+		t.Fatalf("Principal = %+v", st.Principal)
+	}
+	want := `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"AWS":"arn:aws:iam::111122223333:root","Service":"logging.s3.amazonaws.com"},"Action":"s3:PutObject","Resource":"arn:aws:s3:::b/*"}]}`
+	if p.String() != want {
+		t.Fatalf("String() = %s", p.String())
+	}
+}
+
+func TestCreateBucketInput_ByKind(t *testing.T) {
+	in, err := createBucketInput(&urlOpts{Bucket: "logs", Kind: BucketGeneral}, "eu-west-1")
+	if err != nil || in.CreateBucketConfiguration.LocationConstraint != s3types.BucketLocationConstraintEuWest1 {
+		t.Fatalf("general bucket = %+v, %v", in, err)
+	}
+	if in, err = createBucketInput(&urlOpts{Bucket: "logs", Kind: BucketGeneral}, "us-east-1"); err != nil || in.CreateBucketConfiguration != nil {
+		t.Fatalf("us-east-1 bucket = %+v, %v", in, err)
+	}
+
+	for _, tc := range []struct {
+		bucket, zone string
+		location     s3types.LocationType
+		redundancy   s3types.DataRedundancy
+	}{
+		{"logs--use1-az4--x-s3", "use1-az4", s3types.LocationTypeAvailabilityZone, s3types.DataRedundancySingleAvailabilityZone},
+		{"my--logs--usw2-lax1-az1--x-s3", "usw2-lax1-az1", s3types.LocationTypeLocalZone, s3types.DataRedundancySingleLocalZone},
+	} {
+		in, err := createBucketInput(&urlOpts{Bucket: tc.bucket, Kind: BucketDirectory}, "us-east-1")
+		if err != nil {
+			t.Fatalf("%s: %v", tc.bucket, err)
+		}
+		conf := in.CreateBucketConfiguration
+		if conf.LocationConstraint != "" || aws.ToString(conf.Location.Name) != tc.zone || conf.Location.Type != tc.location ||
+			conf.Bucket.Type != s3types.BucketTypeDirectory || conf.Bucket.DataRedundancy != tc.redundancy {
+			t.Fatalf("%s: configuration = %+v %+v %+v", tc.bucket, conf, conf.Location, conf.Bucket)
+		}
+	}
+
+	if _, err := createBucketInput(&urlOpts{Bucket: "orders-ap-abc123-s3alias", Kind: BucketAccessPointAlias}, "us-east-1"); err == nil ||
+		!strings.Contains(err.Error(), "cannot create access point alias") {
+		t.Fatalf("access point alias = %v", err)
+	}
+}
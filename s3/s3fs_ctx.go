@@ -20,11 +20,20 @@ var _ vfs.VFileSystemCtx = (*S3FS)(nil)
 // OpenCtx is the context-aware variant of Open. It does not validate
 // existence; the ctx is forwarded to the underlying SDK call when the
 // returned VFile reads or writes.
-func (fs *S3FS) OpenCtx(_ context.Context, u *url.URL) (vfs.VFile, error) {
+func (fs *S3FS) OpenCtx(ctx context.Context, u *url.URL) (vfs.VFile, error) {
 	// Open itself doesn't make a remote call — it just constructs a
-	// reference. Reads/writes on the returned VFile pick up ctx from
-	// their own call sites.
-	return fs.Open(u)
+	// reference that reads and writes with ctx.
+	opts, err := parseURL(u)
+	if err != nil {
+		return nil, err
+	}
+	client, err := resolveClient(opts)
+	if err != nil {
+		return nil, err
+	}
+	f := newS3File(client, fs, opts)
+	f.ctx = ctx
+	return f, nil
 }
 
 // CreateCtx is the context-aware variant of Create.
@@ -33,7 +42,7 @@ func (fs *S3FS) CreateCtx(ctx context.Context, u *url.URL) (vfs.VFile, error) {
 	if err != nil {
 		return nil, err
 	}
-	client, err := getS3Client(opts)
+	client, err := resolveClient(opts)
 	if err != nil {
 		return nil, err
 	}
@@ -52,7 +61,9 @@ func (fs *S3FS) CreateCtx(ctx context.Context, u *url.URL) (vfs.VFile, error) {
 	}); err != nil {
 		return nil, mapS3Err(err)
 	}
-	return newS3File(client, fs, opts), nil
+	f := newS3File(client, fs, opts)
+	f.ctx = ctx
+	return f, nil
 }
 
 // MkdirAllCtx is the context-aware variant of MkdirAll.
@@ -61,7 +72,7 @@ func (fs *S3FS) MkdirAllCtx(ctx context.Context, u *url.URL) (vfs.VFile, error)
 	if err != nil {
 		return nil, err
 	}
-	client, err := getS3Client(opts)
+	client, err := resolveClient(opts)
 	if err != nil {
 		return nil, err
 	}
@@ -76,15 +87,7 @@ func (fs *S3FS) MkdirAllCtx(ctx context.Context, u *url.URL) (vfs.VFile, error)
 	}); err != nil {
 		return nil, mapS3Err(err)
 	}
-	dirOpts := &urlOpts{
-		u: &url.URL{
-			Scheme: S3Scheme,
-			Host:   opts.Bucket,
-			Path:   "/" + key,
-		},
-		Bucket: opts.Bucket,
-		Key:    key,
-	}
+	dirOpts := opts.withKey(key)
 	return newS3File(client, fs, dirOpts), nil
 }
 
@@ -96,7 +99,7 @@ func (fs *S3FS) DeleteCtx(ctx context.Context, src *url.URL) error {
 	if err != nil {
 		return err
 	}
-	client, err := getS3Client(srcOpts)
+	client, err := resolveClient(srcOpts)
 	if err != nil {
 		return err
 	}
@@ -128,7 +131,7 @@ func (fs *S3FS) ListCtx(ctx context.Context, u *url.URL) ([]vfs.VFile, error) {
 	if err != nil {
 		return nil, err
 	}
-	client, err := getS3Client(opts)
+	client, err := resolveClient(opts)
 	if err != nil {
 		return nil, err
 	}
@@ -152,18 +155,14 @@ func (fs *S3FS) ListCtx(ctx context.Context, u *url.URL) ([]vfs.VFile, error) {
 			if key == prefix {
 				continue
 			}
-			child, openErr := fs.Open(&url.URL{
-				Scheme: S3Scheme, Host: opts.Bucket, Path: "/" + key,
-			})
+			child, openErr := fs.Open(opts.childURL(key))
 			if openErr != nil {
 				return nil, openErr
 			}
 			files = append(files, child)
 		}
 		for _, cp := range page.CommonPrefixes {
-			child, openErr := fs.Open(&url.URL{
-				Scheme: S3Scheme, Host: opts.Bucket, Path: "/" + aws.ToString(cp.Prefix),
-			})
+			child, openErr := fs.Open(opts.childURL(aws.ToString(cp.Prefix)))
 			if openErr != nil {
 				return nil, openErr
 			}
@@ -181,7 +180,7 @@ func (fs *S3FS) WalkCtx(ctx context.Context, u *url.URL, fn vfs.WalkFn) error {
 	if err != nil {
 		return err
 	}
-	client, err := getS3Client(opts)
+	client, err := resolveClient(opts)
 	if err != nil {
 		return err
 	}
@@ -206,9 +205,7 @@ func (fs *S3FS) WalkCtx(ctx context.Context, u *url.URL, fn vfs.WalkFn) error {
 			if key == prefix {
 				continue
 			}
-			child, openErr := fs.Open(&url.URL{
-				Scheme: S3Scheme, Host: opts.Bucket, Path: "/" + key,
-			})
+			child, openErr := fs.Open(opts.childURL(key))
 			if openErr != nil {
 				return openErr
 			}
@@ -241,7 +238,7 @@ func (fs *S3FS) CopyCtx(ctx context.Context, src, dst *url.URL) error {
 	if err != nil {
 		return err
 	}
-	client, err := getS3Client(srcOpts)
+	client, err := resolveClient(srcOpts)
 	if err != nil {
 		return err
 	}
@@ -267,14 +264,14 @@ func (fs *S3FS) CopyCtx(ctx context.Context, src, dst *url.URL) error {
 		childKey := strings.TrimPrefix(child.Url().Path, "/")
 		relativePath := strings.TrimPrefix(childKey, srcOpts.Key)
 		dstKey := dstOpts.Key + relativePath
-		childDstURL := &url.URL{Scheme: S3Scheme, Host: dstOpts.Bucket, Path: "/" + dstKey}
+		childDstURL := dstOpts.childURL(dstKey)
 		if childInfo.IsDir() {
 			if copyErr := fs.CopyCtx(ctx, child.Url(), childDstURL); copyErr != nil {
 				return copyErr
 			}
 		} else {
-			childSrcOpts := &urlOpts{u: child.Url(), Bucket: srcOpts.Bucket, Key: childKey}
-			childDstOpts := &urlOpts{u: childDstURL, Bucket: dstOpts.Bucket, Key: dstKey}
+			childSrcOpts := srcOpts.withKey(childKey)
+			childDstOpts := dstOpts.withKey(dstKey)
 			if copyErr := fs.copySingleObjectCtx(ctx, client, childSrcOpts, childDstOpts); copyErr != nil {
 				return copyErr
 			}
@@ -283,12 +280,11 @@ func (fs *S3FS) CopyCtx(ctx context.Context, src, dst *url.URL) error {
 	return nil
 }
 
-func (fs *S3FS) copySingleObjectCtx(ctx context.Context, client *awss3.Client, src, dst *urlOpts) error {
-	copySource := src.Bucket + "/" + src.Key
+func (fs *S3FS) copySingleObjectCtx(ctx context.Context, client s3API, src, dst *urlOpts) error {
 	_, err := client.CopyObject(ctx, &awss3.CopyObjectInput{
 		Bucket:     aws.String(dst.Bucket),
 		Key:        aws.String(dst.Key),
-		CopySource: aws.String(copySource),
+		CopySource: aws.String(src.copySource()),
 	})
 	if err == nil {
 		return nil
@@ -26,7 +26,7 @@ func (fs *S3FS) ListIter(ctx context.Context, u *url.URL) (vfs.FileIterator, err
 	if err != nil {
 		return nil, err
 	}
-	client, err := getS3Client(opts)
+	client, err := resolveClient(opts)
 	if err != nil {
 		return nil, err
 	}
@@ -41,7 +41,7 @@ func (fs *S3FS) ListIter(ctx context.Context, u *url.URL) (vfs.FileIterator, err
 	})
 	return &s3FileIterator{
 		fs:        fs,
-		bucket:    opts.Bucket,
+		opts:      opts,
 		prefix:    prefix,
 		client:    client,
 		paginator: paginator,
@@ -52,9 +52,9 @@ func (fs *S3FS) ListIter(ctx context.Context, u *url.URL) (vfs.FileIterator, err
 // Not safe for concurrent use.
 type s3FileIterator struct {
 	fs        *S3FS
-	bucket    string
+	opts      *urlOpts
 	prefix    string
-	client    *awss3.Client
+	client    s3API
 	paginator *awss3.ListObjectsV2Paginator
 
 	// Buffer of pending VFiles from the current page (Contents + CommonPrefixes).
@@ -85,18 +85,14 @@ func (it *s3FileIterator) Next(ctx context.Context) (vfs.VFile, error) {
 			if key == it.prefix {
 				continue
 			}
-			child, openErr := it.fs.Open(&url.URL{
-				Scheme: S3Scheme, Host: it.bucket, Path: "/" + key,
-			})
+			child, openErr := it.fs.Open(it.opts.childURL(key))
 			if openErr != nil {
 				return nil, openErr
 			}
 			it.buf = append(it.buf, child)
 		}
 		for _, cp := range page.CommonPrefixes {
-			child, openErr := it.fs.Open(&url.URL{
-				Scheme: S3Scheme, Host: it.bucket, Path: "/" + aws.ToString(cp.Prefix),
-			})
+			child, openErr := it.fs.Open(it.opts.childURL(aws.ToString(cp.Prefix)))
 			if openErr != nil {
 				return nil, openErr
 			}
@@ -0,0 +1,439 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SyncCompare selects how Sync decides whether a file present on both
+// sides has changed. Files whose sizes differ are always transferred.
+type SyncCompare int
+
+const (
+	// CompareChecksum compares content digests whenever both sides can
+	// provide one: S3 ETags (including multipart ETags) on either side,
+	// MD5 computed by reading non-S3 files. When no digest comparison is
+	// conclusive it falls back to CompareModTime.
+	CompareChecksum SyncCompare = iota
+	// CompareModTime transfers a file when the source is newer than the
+	// destination.
+	CompareModTime
+	// CompareSize transfers a file only when the sizes differ.
+	CompareSize
+)
+
+const (
+	// DefaultSyncParallelism is the number of concurrent transfers used
+	// when SyncOptions.Parallelism is not set.
+	DefaultSyncParallelism = 8
+	// DefaultSyncPartSize is the multipart part size used for uploads when
+	// SyncOptions.PartSize is not set. It matches the AWS CLI default so
+	// ETags of objects uploaded by either tool can be verified locally.
+	DefaultSyncPartSize int64 = 8 * 1024 * 1024
+)
+
+// SyncOptions configures Sync. The zero value copies new and changed
+// files using checksum comparison, never deletes, and runs
+// DefaultSyncParallelism transfers at a time.
+type SyncOptions struct {
+	// Delete removes destination files that do not exist in the source.
+	// Only files selected by Include/Exclude are considered, and nothing is
+	// deleted if any transfer failed.
+	Delete bool
+	// DryRun computes and reports the actions without performing them.
+	DryRun bool
+	// Include limits the sync to paths matching at least one glob. Paths
+	// are relative to the sync roots and use "/" separators. "*" and "?"
+	// do not cross "/", "**" does; a pattern without "/" matches the base
+	// name at any depth, and a pattern ending in "/" matches everything
+	// below that directory.
+	Include []string
+	// Exclude skips paths matching any glob (same syntax as Include).
+	// Exclude wins over Include.
+	Exclude []string
+	// Parallelism is the number of concurrent comparisons and transfers.
+	Parallelism int
+	// Compare selects the change detection strategy.
+	Compare SyncCompare
+	// PartSize is the multipart upload part size for S3 destinations.
+	// Files larger than PartSize are uploaded in parts.
+	PartSize int64
+	// Output, if set, receives one line per copy, update or delete action
+	// (prefixed with "(dry run)" in dry-run mode).
+	Output io.Writer
+}
+
+// SyncOp is the kind of action Sync takes for a path.
+type SyncOp string
+
+const (
+	// SyncCopy copies a file missing at the destination.
+	SyncCopy SyncOp = "copy"
+	// SyncUpdate overwrites a destination file that differs from the source.
+	SyncUpdate SyncOp = "update"
+	// SyncDelete removes an extraneous destination file.
+	SyncDelete SyncOp = "delete"
+	// SyncSkip leaves an unchanged file alone.
+	SyncSkip SyncOp = "skip"
+)
+
+// SyncAction describes what Sync did (or, in dry-run mode, would do) for a
+// single path.
+type SyncAction struct {
+	Op SyncOp
+	// Path is relative to the sync roots.
+	Path string
+	// Size is the source size for copies and updates, the destination size
+	// for deletes. Objects stored through codecs report their logical size,
+	// or -1 when it was not recorded.
+	Size int64
+	// Reason explains why the action was chosen.
+	Reason string
+	// Err is set when the action failed.
+	Err error
+}
+
+// SyncResult summarises a Sync run.
+type SyncResult struct {
+	// Actions lists every path considered, sorted by path.
+	Actions []SyncAction
+	// Copied, Updated, Deleted and Unchanged count successful actions.
+	Copied    int
+	Updated   int
+	Deleted   int
+	Unchanged int
+	// Failed counts actions with a non-nil Err.
+	Failed int
+	// Bytes is the number of bytes transferred.
+	Bytes int64
+}
+
+// Sync mirrors the tree at src into dst. Either side may be any scheme
+// registered with the golly VFS manager; S3 locations are listed and
+// transferred with the S3 API directly (one ListObjectsV2 scan, ETag
+// comparison, server-side copy between buckets, multipart uploads).
+//
+// src and dst are treated as directory roots: s3://bucket/prefix maps
+// s3://bucket/prefix/a.txt to <dst>/a.txt. A partially failed run still
+// returns its SyncResult together with an error joining every failure.
+func Sync(ctx context.Context, src, dst *url.URL, opts *SyncOptions) (*SyncResult, error) {
+	if opts == nil {
+		opts = &SyncOptions{}
+	}
+	filter, err := newSyncFilter(opts.Include, opts.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	srcLoc, err := newSyncLocation(src)
+	if err != nil {
+		return nil, fmt.Errorf("s3: sync source: %w", err)
+	}
+	dstLoc, err := newSyncLocation(dst)
+	if err != nil {
+		return nil, fmt.Errorf("s3: sync destination: %w", err)
+	}
+
+	var srcEntries, dstEntries map[string]*syncEntry
+	var srcErr, dstErr error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); srcEntries, srcErr = srcLoc.list(ctx) }()
+	go func() { defer wg.Done(); dstEntries, dstErr = dstLoc.list(ctx) }()
+	wg.Wait()
+	if srcErr != nil {
+		return nil, fmt.Errorf("s3: sync list %s: %w", src, srcErr)
+	}
+	if dstErr != nil {
+		return nil, fmt.Errorf("s3: sync list %s: %w", dst, dstErr)
+	}
+
+	s := &syncer{src: srcLoc, dst: dstLoc, opts: opts, partSize: opts.PartSize}
+	if s.partSize < memMinPartSize {
+		s.partSize = DefaultSyncPartSize
+	}
+	return s.run(ctx, filter, srcEntries, dstEntries)
+}
+
+// syncer carries the state of one Sync run.
+type syncer struct {
+	src, dst *syncLocation
+	opts     *SyncOptions
+	partSize int64
+	outMu    sync.Mutex
+}
+
+func (s *syncer) run(ctx context.Context, filter *syncFilter, srcEntries, dstEntries map[string]*syncEntry) (*SyncResult, error) {
+	var paths []string
+	for rel := range srcEntries {
+		if filter.match(rel) {
+			paths = append(paths, rel)
+		}
+	}
+	sort.Strings(paths)
+
+	actions := make([]SyncAction, len(paths))
+	parallelism := s.opts.Parallelism
+	if parallelism <= 0 {
+		parallelism = DefaultSyncParallelism
+	}
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < parallelism; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				rel := paths[i]
+				actions[i] = s.syncFile(ctx, rel, srcEntries[rel], dstEntries[rel])
+			}
+		}()
+	}
+	for i := range paths {
+		if ctx.Err() != nil {
+			actions[i] = SyncAction{Op: SyncCopy, Path: paths[i], Err: ctx.Err()}
+			continue
+		}
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	transferFailed := false
+	for _, a := range actions {
+		if a.Err != nil {
+			transferFailed = true
+			break
+		}
+	}
+
+	if s.opts.Delete {
+		var extraneous []*syncEntry
+		for rel, e := range dstEntries {
+			if _, ok := srcEntries[rel]; !ok && filter.match(rel) {
+				extraneous = append(extraneous, e)
+			}
+		}
+		sort.Slice(extraneous, func(i, j int) bool { return extraneous[i].rel < extraneous[j].rel })
+		actions = append(actions, s.deleteExtraneous(ctx, extraneous, transferFailed)...)
+	}
+
+	sort.SliceStable(actions, func(i, j int) bool { return actions[i].Path < actions[j].Path })
+	result := &SyncResult{Actions: actions}
+	var errs []error
+	for _, a := range actions {
+		if a.Err != nil {
+			result.Failed++
+			errs = append(errs, fmt.Errorf("%s %s: %w", a.Op, a.Path, a.Err))
+			continue
+		}
+		switch a.Op {
+		case SyncCopy:
+			result.Copied++
+		case SyncUpdate:
+			result.Updated++
+		case SyncDelete:
+			result.Deleted++
+		case SyncSkip:
+			result.Unchanged++
+		}
+		if !s.opts.DryRun && (a.Op == SyncCopy || a.Op == SyncUpdate) && a.Size > 0 {
+			result.Bytes += a.Size
+		}
+	}
+	if len(errs) > 0 {
+		return result, fmt.Errorf("s3: sync: %w", errors.Join(errs...))
+	}
+	return result, nil
+}
+
+// syncFile compares one source file with its destination counterpart and
+// transfers it when needed.
+func (s *syncer) syncFile(ctx context.Context, rel string, se, de *syncEntry) SyncAction {
+	action := SyncAction{Path: rel}
+	action.Op, action.Reason, action.Err = s.decide(ctx, se, de)
+	action.Size = se.size
+	if action.Err != nil || action.Op == SyncSkip {
+		return action
+	}
+	s.report(action)
+	if !s.opts.DryRun {
+		action.Err = s.transfer(ctx, se)
+	}
+	return action
+}
+
+// decide picks the action for a source entry given its destination
+// counterpart (nil when missing). Entries stored through codecs are
+// compared by their decoded content.
+func (s *syncer) decide(ctx context.Context, se, de *syncEntry) (SyncOp, string, error) {
+	if err := s.src.resolve(ctx, se); err != nil {
+		return SyncCopy, "", err
+	}
+	if de == nil {
+		return SyncCopy, "missing at destination", nil
+	}
+	if err := s.dst.resolve(ctx, de); err != nil {
+		return SyncUpdate, "", err
+	}
+	sizeKnown := se.size >= 0 && de.size >= 0
+	if sizeKnown && se.size != de.size {
+		return SyncUpdate, "size differs", nil
+	}
+	switch s.opts.Compare {
+	case CompareSize:
+		if !sizeKnown {
+			return SyncUpdate, "size unknown", nil
+		}
+		return SyncSkip, "same size", nil
+	case CompareChecksum:
+		same, known, err := s.sameContent(ctx, se, de)
+		if err != nil {
+			return SyncUpdate, "", err
+		}
+		if known {
+			if same {
+				return SyncSkip, "checksum matches", nil
+			}
+			return SyncUpdate, "checksum differs", nil
+		}
+	}
+	if se.modTime.After(de.modTime) {
+		return SyncUpdate, "source is newer", nil
+	}
+	return SyncSkip, "destination is up to date", nil
+}
+
+// sameContent compares digests of two same-sized entries. known is false
+// when neither ETags nor computed hashes can settle the question (for
+// example, two multipart uploads with different part sizes).
+func (s *syncer) sameContent(ctx context.Context, se, de *syncEntry) (same, known bool, err error) {
+	switch {
+	case se.etag != "" && de.etag != "":
+		if se.etag == de.etag {
+			return true, true, nil
+		}
+		if !isMultipartETag(se.etag) && !isMultipartETag(de.etag) {
+			return false, true, nil
+		}
+		return false, false, nil
+	case se.etag != "":
+		return s.matchesETag(ctx, s.dst, de, se.etag, se.size)
+	case de.etag != "":
+		return s.matchesETag(ctx, s.src, se, de.etag, de.size)
+	default:
+		srcSum, err := s.src.md5(ctx, se)
+		if err != nil {
+			return false, false, err
+		}
+		dstSum, err := s.dst.md5(ctx, de)
+		if err != nil {
+			return false, false, err
+		}
+		return srcSum == dstSum, true, nil
+	}
+}
+
+// matchesETag hashes a non-S3 entry and compares it with an S3 ETag. For
+// multipart ETags the part size is unknown, so likely part sizes are
+// tried; no match among them is inconclusive rather than a difference.
+func (s *syncer) matchesETag(ctx context.Context, loc *syncLocation, e *syncEntry, etag string, size int64) (same, known bool, err error) {
+	if !isMultipartETag(etag) {
+		sum, err := loc.md5(ctx, e)
+		if err != nil {
+			return false, false, err
+		}
+		return sum == etag, true, nil
+	}
+	candidates := candidatePartSizes(size, multipartETagParts(etag), s.partSize)
+	if len(candidates) == 0 {
+		return false, false, nil
+	}
+	etags, err := loc.multipartETags(ctx, e, candidates)
+	if err != nil {
+		return false, false, err
+	}
+	for _, got := range etags {
+		if got == etag {
+			return true, true, nil
+		}
+	}
+	return false, false, nil
+}
+
+// transfer copies a source entry to the destination. Objects are decoded
+// on the way out and encoded with the destination's codecs, except for
+// server-side copies between buckets with the same codecs.
+func (s *syncer) transfer(ctx context.Context, se *syncEntry) error {
+	if s.src.isS3() && s.dst.isS3() && se.size <= maxCopyObjectSize &&
+		sameCodecs(s.src.objectOpts(se.rel), s.dst.objectOpts(se.rel)) {
+		err := s.dst.serverSideCopy(ctx, s.src, se)
+		if err == nil {
+			return nil
+		}
+		// Cross-account or cross-region: fall back to streaming.
+		logger.DebugF("s3: sync server-side copy of %s failed, streaming: %v", se.rel, err)
+	}
+	r, contentType, size, err := s.src.open(ctx, se)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = r.Close() }()
+	return s.dst.write(ctx, se.rel, r, size, contentType, s.partSize)
+}
+
+// deleteExtraneous removes destination entries missing from the source.
+// When a transfer failed, deletes are reported but skipped so a broken run
+// never leaves the destination with less than it started with.
+func (s *syncer) deleteExtraneous(ctx context.Context, entries []*syncEntry, transferFailed bool) []SyncAction {
+	actions := make([]SyncAction, len(entries))
+	rels := make([]string, len(entries))
+	for i, e := range entries {
+		actions[i] = SyncAction{Op: SyncDelete, Path: e.rel, Size: e.size, Reason: "not in source"}
+		rels[i] = e.rel
+		if transferFailed {
+			actions[i].Err = errors.New("skipped: transfers failed")
+			continue
+		}
+		s.report(actions[i])
+	}
+	if transferFailed || s.opts.DryRun || len(entries) == 0 {
+		return actions
+	}
+	failed := s.dst.delete(ctx, rels)
+	for i := range actions {
+		if err, ok := failed[actions[i].Path]; ok {
+			actions[i].Err = err
+		}
+	}
+	return actions
+}
+
+// report writes an action line to SyncOptions.Output.
+func (s *syncer) report(a SyncAction) {
+	if s.opts.Output == nil {
+		return
+	}
+	prefix := ""
+	if s.opts.DryRun {
+		prefix = "(dry run) "
+	}
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	_, _ = fmt.Fprintf(s.opts.Output, "%s%s %s (%s)\n", prefix, a.Op, a.Path, a.Reason)
+}
+
+// syncEntry is a file found under a sync root.
+type syncEntry struct {
+	rel     string
+	size    int64
+	modTime time.Time
+	// etag is the unquoted S3 ETag; empty for non-S3 entries and for
+	// objects stored through codecs, whose size is their logical size.
+	etag string
+}
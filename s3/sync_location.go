@@ -0,0 +1,506 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"oss.nandlabs.io/golly/textutils"
+	"oss.nandlabs.io/golly/vfs"
+)
+
+const (
+	// maxCopyObjectSize is the largest object CopyObject accepts (5 GiB).
+	maxCopyObjectSize int64 = 5 * 1024 * 1024 * 1024
+	// maxUploadParts is the S3 limit on parts per multipart upload.
+	maxUploadParts = 10000
+	// maxDeleteObjects is the S3 limit on keys per DeleteObjects call.
+	maxDeleteObjects = 1000
+)
+
+// syncLocation is one side of a Sync: an S3 prefix accessed through the
+// S3 API, or a directory on any other VFS scheme accessed through the
+// golly VFS manager.
+type syncLocation struct {
+	root *url.URL
+	// S3 locations only.
+	bucket string
+	prefix string
+	client s3API
+	opts   *urlOpts
+}
+
+func newSyncLocation(u *url.URL) (*syncLocation, error) {
+	if u == nil {
+		return nil, errors.New("url cannot be nil")
+	}
+	loc := &syncLocation{root: u}
+	if u.Scheme != S3Scheme {
+		if !vfs.GetManager().IsSupported(u.Scheme) {
+			return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+		}
+		return loc, nil
+	}
+	opts, err := parseURL(u)
+	if err != nil {
+		return nil, err
+	}
+	client, err := resolveClient(opts)
+	if err != nil {
+		return nil, err
+	}
+	loc.bucket = opts.Bucket
+	loc.prefix = opts.Key
+	if loc.prefix != "" && !strings.HasSuffix(loc.prefix, textutils.ForwardSlashStr) {
+		loc.prefix += textutils.ForwardSlashStr
+	}
+	loc.client = client
+	loc.opts = opts
+	return loc, nil
+}
+
+func (l *syncLocation) isS3() bool { return l.client != nil }
+
+// key returns the S3 key for a relative path.
+func (l *syncLocation) key(rel string) string { return l.prefix + rel }
+
+// objectOpts returns the URL options of the S3 object at a relative path,
+// which select the codecs applied to it.
+func (l *syncLocation) objectOpts(rel string) *urlOpts { return l.opts.withKey(l.key(rel)) }
+
+// url returns the VFS URL for a relative path.
+func (l *syncLocation) url(rel string) *url.URL {
+	u := *l.root
+	u.Path = path.Join(l.root.Path, rel)
+	u.RawPath = ""
+	return &u
+}
+
+// list returns every file under the root keyed by relative path. A missing
+// root is an empty location.
+func (l *syncLocation) list(ctx context.Context) (map[string]*syncEntry, error) {
+	if l.isS3() {
+		return l.listS3(ctx)
+	}
+	return l.listVFS(ctx)
+}
+
+func (l *syncLocation) listS3(ctx context.Context) (map[string]*syncEntry, error) {
+	entries := make(map[string]*syncEntry)
+	paginator := awss3.NewListObjectsV2Paginator(l.client, &awss3.ListObjectsV2Input{
+		Bucket: aws.String(l.bucket),
+		Prefix: aws.String(l.prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, mapS3Err(err)
+		}
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			if strings.HasSuffix(key, textutils.ForwardSlashStr) {
+				// Directory marker.
+				continue
+			}
+			rel := strings.TrimPrefix(key, l.prefix)
+			entries[rel] = &syncEntry{
+				rel:     rel,
+				size:    aws.ToInt64(obj.Size),
+				modTime: aws.ToTime(obj.LastModified),
+				etag:    strings.Trim(aws.ToString(obj.ETag), `"`),
+			}
+		}
+	}
+	return entries, nil
+}
+
+func (l *syncLocation) listVFS(ctx context.Context) (map[string]*syncEntry, error) {
+	entries := make(map[string]*syncEntry)
+	rootPath := l.root.Path
+	if !strings.HasSuffix(rootPath, textutils.ForwardSlashStr) {
+		rootPath += textutils.ForwardSlashStr
+	}
+	err := vfs.GetManager().Walk(l.root, func(file vfs.VFile) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		info, err := file.Info()
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel := strings.TrimPrefix(file.Url().Path, rootPath)
+		if rel == "" || rel == file.Url().Path {
+			return nil
+		}
+		entries[rel] = &syncEntry{rel: rel, size: info.Size(), modTime: info.ModTime()}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, vfs.ErrNotExist) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// resolve replaces the listed size and ETag of an S3 entry with what its
+// decoded content is known by when the object is stored through codecs:
+// the logical size (-1 when not recorded) and no ETag. Only buckets with
+// CodecOptions are checked, with one HeadObject per entry.
+func (l *syncLocation) resolve(ctx context.Context, e *syncEntry) error {
+	if !l.isS3() || codecOptionsFor(l.opts) == nil {
+		return nil
+	}
+	opts := l.objectOpts(e.rel)
+	out, err := l.client.HeadObject(ctx, &awss3.HeadObjectInput{
+		Bucket: aws.String(l.bucket),
+		Key:    aws.String(opts.Key),
+	})
+	if err != nil {
+		return mapS3Err(err)
+	}
+	if !isEncoded(opts, aws.ToString(out.ContentEncoding), aws.ToInt64(out.ContentLength), out.Metadata) {
+		return nil
+	}
+	e.etag = ""
+	e.size = -1
+	if n, ok := logicalSize(out.Metadata); ok {
+		e.size = n
+	}
+	return nil
+}
+
+// open returns a reader for an entry's decoded content, its content type
+// (if known) and the number of bytes the reader yields, -1 when unknown.
+func (l *syncLocation) open(ctx context.Context, e *syncEntry) (io.ReadCloser, string, int64, error) {
+	if l.isS3() {
+		opts := l.objectOpts(e.rel)
+		out, err := l.client.GetObject(ctx, &awss3.GetObjectInput{
+			Bucket: aws.String(l.bucket),
+			Key:    aws.String(opts.Key),
+		})
+		if err != nil {
+			return nil, "", 0, mapS3Err(err)
+		}
+		size := aws.ToInt64(out.ContentLength)
+		body, err := decodeObject(ctx, opts, out.Body, aws.ToString(out.ContentEncoding), size, out.Metadata)
+		if err != nil {
+			_ = out.Body.Close()
+			return nil, "", 0, err
+		}
+		if body != out.Body {
+			size = -1
+			if n, ok := logicalSize(out.Metadata); ok {
+				size = n
+			}
+		}
+		return body, aws.ToString(out.ContentType), size, nil
+	}
+	f, err := vfs.GetManager().Open(l.url(e.rel))
+	if err != nil {
+		return nil, "", 0, err
+	}
+	return f, f.ContentType(), e.size, nil
+}
+
+// md5 returns the hex MD5 of an entry's content.
+func (l *syncLocation) md5(ctx context.Context, e *syncEntry) (string, error) {
+	r, _, _, err := l.open(ctx, e)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = r.Close() }()
+	h := md5.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// multipartETags reads an entry once and returns the multipart ETag it
+// would have for each of the given part sizes.
+func (l *syncLocation) multipartETags(ctx context.Context, e *syncEntry, partSizes []int64) ([]string, error) {
+	r, _, _, err := l.open(ctx, e)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = r.Close() }()
+
+	hashers := make([]*partHasher, len(partSizes))
+	for i, ps := range partSizes {
+		hashers[i] = &partHasher{partSize: ps, part: md5.New()}
+	}
+	buf := make([]byte, 256*1024)
+	for {
+		n, rerr := r.Read(buf)
+		for _, h := range hashers {
+			h.write(buf[:n])
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return nil, rerr
+		}
+	}
+	etags := make([]string, len(hashers))
+	for i, h := range hashers {
+		etags[i] = h.etag()
+	}
+	return etags, nil
+}
+
+// partHasher computes a multipart ETag (MD5 of the concatenated part MD5s,
+// suffixed with the part count) for a fixed part size.
+type partHasher struct {
+	partSize int64
+	inPart   int64
+	part     hash.Hash
+	sums     []byte
+	parts    int
+}
+
+func (h *partHasher) write(p []byte) {
+	for len(p) > 0 {
+		n := int64(len(p))
+		if room := h.partSize - h.inPart; n > room {
+			n = room
+		}
+		h.part.Write(p[:n])
+		h.inPart += n
+		p = p[n:]
+		if h.inPart == h.partSize {
+			h.flush()
+		}
+	}
+}
+
+func (h *partHasher) flush() {
+	h.sums = h.part.Sum(h.sums)
+	h.parts++
+	h.part.Reset()
+	h.inPart = 0
+}
+
+func (h *partHasher) etag() string {
+	if h.inPart > 0 || h.parts == 0 {
+		h.flush()
+	}
+	sum := md5.Sum(h.sums)
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(sum[:]), h.parts)
+}
+
+// serverSideCopy copies an entry of another S3 location into this one
+// with CopyObject, issued against the destination bucket's client. The
+// metadata and Content-Encoding are copied along, so the caller must check
+// that both locations apply the same codecs.
+func (l *syncLocation) serverSideCopy(ctx context.Context, src *syncLocation, e *syncEntry) error {
+	_, err := l.client.CopyObject(ctx, &awss3.CopyObjectInput{
+		Bucket:     aws.String(l.bucket),
+		Key:        aws.String(l.key(e.rel)),
+		CopySource: aws.String(copySourcePath(src.bucket, src.key(e.rel))),
+	})
+	return mapS3Err(err)
+}
+
+// write stores size bytes from r at rel, replacing any existing file. S3
+// objects are encoded with the codecs of the bucket; they are read into
+// memory first when the bucket has codecs or size is unknown (-1).
+func (l *syncLocation) write(ctx context.Context, rel string, r io.Reader, size int64, contentType string, partSize int64) error {
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(rel))
+	}
+	if l.isS3() {
+		opts := l.objectOpts(rel)
+		attrs := objectAttrs{contentType: contentType}
+		if size < 0 || len(writeChain(opts.Key, codecOptionsFor(opts))) > 0 {
+			data, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			enc, err := encodeObject(ctx, opts, data)
+			if err != nil {
+				return err
+			}
+			if enc != nil {
+				data = enc.data
+				attrs.contentEncoding = enc.contentEncoding
+				attrs.metadata = enc.metadata
+			}
+			r, size = bytes.NewReader(data), int64(len(data))
+		}
+		return l.upload(ctx, opts.Key, r, size, attrs, partSize)
+	}
+
+	mgr := vfs.GetManager()
+	u := l.url(rel)
+	parent := *u
+	parent.Path = path.Dir(u.Path)
+	if _, err := mgr.MkdirAll(&parent); err != nil {
+		return err
+	}
+	f, err := mgr.Create(u)
+	if err != nil {
+		// Some filesystems refuse to create over an existing file.
+		if delErr := mgr.Delete(u); delErr != nil {
+			return err
+		}
+		if f, err = mgr.Create(u); err != nil {
+			return err
+		}
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// objectAttrs are the headers an uploaded object is stored with.
+type objectAttrs struct {
+	contentType     string
+	contentEncoding string
+	metadata        map[string]string
+}
+
+// upload writes r to key, using a multipart upload when the content is
+// larger than one part.
+func (l *syncLocation) upload(ctx context.Context, key string, r io.Reader, size int64, attrs objectAttrs, partSize int64) error {
+	if parts := (size + partSize - 1) / partSize; parts > maxUploadParts {
+		// Grow the part size (in whole MiB) to stay within the part limit.
+		const mib = 1024 * 1024
+		partSize = ((size/maxUploadParts)/mib + 1) * mib
+	}
+	var ct, ce *string
+	if attrs.contentType != "" {
+		ct = aws.String(attrs.contentType)
+	}
+	if attrs.contentEncoding != "" {
+		ce = aws.String(attrs.contentEncoding)
+	}
+
+	if size <= partSize {
+		body, err := io.ReadAll(io.LimitReader(r, size+1))
+		if err != nil {
+			return err
+		}
+		_, err = l.client.PutObject(ctx, &awss3.PutObjectInput{
+			Bucket:          aws.String(l.bucket),
+			Key:             aws.String(key),
+			Body:            bytes.NewReader(body),
+			ContentLength:   aws.Int64(int64(len(body))),
+			ContentType:     ct,
+			ContentEncoding: ce,
+			Metadata:        attrs.metadata,
+		})
+		return mapS3Err(err)
+	}
+
+	created, err := l.client.CreateMultipartUpload(ctx, &awss3.CreateMultipartUploadInput{
+		Bucket:          aws.String(l.bucket),
+		Key:             aws.String(key),
+		ContentType:     ct,
+		ContentEncoding: ce,
+		Metadata:        attrs.metadata,
+	})
+	if err != nil {
+		return mapS3Err(err)
+	}
+	abort := func(cause error) error {
+		_, _ = l.client.AbortMultipartUpload(context.WithoutCancel(ctx), &awss3.AbortMultipartUploadInput{
+			Bucket:   aws.String(l.bucket),
+			Key:      aws.String(key),
+			UploadId: created.UploadId,
+		})
+		return cause
+	}
+
+	var completed []s3types.CompletedPart
+	buf := make([]byte, partSize)
+	for partNumber := int32(1); ; partNumber++ {
+		n, rerr := io.ReadFull(r, buf)
+		if n > 0 {
+			out, err := l.client.UploadPart(ctx, &awss3.UploadPartInput{
+				Bucket:        aws.String(l.bucket),
+				Key:           aws.String(key),
+				UploadId:      created.UploadId,
+				PartNumber:    aws.Int32(partNumber),
+				Body:          bytes.NewReader(buf[:n]),
+				ContentLength: aws.Int64(int64(n)),
+			})
+			if err != nil {
+				return abort(mapS3Err(err))
+			}
+			completed = append(completed, s3types.CompletedPart{ETag: out.ETag, PartNumber: aws.Int32(partNumber)})
+		}
+		if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+			break
+		}
+		if rerr != nil {
+			return abort(rerr)
+		}
+	}
+	_, err = l.client.CompleteMultipartUpload(ctx, &awss3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(l.bucket),
+		Key:             aws.String(key),
+		UploadId:        created.UploadId,
+		MultipartUpload: &s3types.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		return abort(mapS3Err(err))
+	}
+	return nil
+}
+
+// delete removes the given relative paths and returns the failures keyed
+// by path. S3 locations use batched DeleteObjects calls.
+func (l *syncLocation) delete(ctx context.Context, rels []string) map[string]error {
+	failed := make(map[string]error)
+	if !l.isS3() {
+		for _, rel := range rels {
+			if err := vfs.GetManager().Delete(l.url(rel)); err != nil {
+				failed[rel] = err
+			}
+		}
+		return failed
+	}
+	for start := 0; start < len(rels); start += maxDeleteObjects {
+		batch := rels[start:min(start+maxDeleteObjects, len(rels))]
+		ids := make([]s3types.ObjectIdentifier, len(batch))
+		for i, rel := range batch {
+			ids[i] = s3types.ObjectIdentifier{Key: aws.String(l.key(rel))}
+		}
+		out, err := l.client.DeleteObjects(ctx, &awss3.DeleteObjectsInput{
+			Bucket: aws.String(l.bucket),
+			Delete: &s3types.Delete{Objects: ids, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			for _, rel := range batch {
+				failed[rel] = mapS3Err(err)
+			}
+			continue
+		}
+		for _, e := range out.Errors {
+			rel := strings.TrimPrefix(aws.ToString(e.Key), l.prefix)
+			failed[rel] = fmt.Errorf("%s: %s", aws.ToString(e.Code), aws.ToString(e.Message))
+		}
+	}
+	return failed
+}
@@ -0,0 +1,166 @@
+package s3
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// syncFilter applies SyncOptions.Include / Exclude to relative paths.
+type syncFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+func newSyncFilter(include, exclude []string) (*syncFilter, error) {
+	f := &syncFilter{}
+	for _, p := range include {
+		re, err := globToRegexp(p)
+		if err != nil {
+			return nil, err
+		}
+		f.include = append(f.include, re)
+	}
+	for _, p := range exclude {
+		re, err := globToRegexp(p)
+		if err != nil {
+			return nil, err
+		}
+		f.exclude = append(f.exclude, re)
+	}
+	return f, nil
+}
+
+// match reports whether rel is selected by the filter.
+func (f *syncFilter) match(rel string) bool {
+	for _, re := range f.exclude {
+		if re.MatchString(rel) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, re := range f.include {
+		if re.MatchString(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// globToRegexp compiles a sync glob. "*" and "?" stay within one path
+// segment, "**" spans segments, and "[...]" is a character class. A
+// pattern without "/" matches the base name at any depth; a trailing "/"
+// matches everything below that directory.
+func globToRegexp(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("s3: empty sync glob")
+	}
+	// Validate the single-segment syntax the same way path.Match does.
+	if _, err := path.Match(strings.ReplaceAll(pattern, "**", "*"), ""); err != nil {
+		return nil, fmt.Errorf("s3: invalid sync glob %q: %w", pattern, err)
+	}
+	p := pattern
+	if strings.HasSuffix(p, "/") {
+		p += "**"
+	}
+	anchored := strings.Contains(strings.TrimSuffix(p, "/**"), "/")
+	p = strings.TrimPrefix(p, "/")
+
+	var sb strings.Builder
+	if anchored {
+		sb.WriteString("^")
+	} else {
+		sb.WriteString("^(?:.*/)?")
+	}
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		switch c {
+		case '*':
+			if i+1 < len(p) && p[i+1] == '*' {
+				i++
+				if i+1 < len(p) && p[i+1] == '/' {
+					// "**/" matches zero or more whole segments.
+					i++
+					sb.WriteString("(?:.*/)?")
+				} else {
+					sb.WriteString(".*")
+				}
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(p[i+1:], ']')
+			class := p[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + class + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(p) {
+				i++
+				sb.WriteString(regexp.QuoteMeta(string(p[i])))
+			}
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.Compile(sb.String())
+}
+
+// isMultipartETag reports whether an (unquoted) ETag was produced by a
+// multipart upload ("<md5-of-md5s>-<parts>").
+func isMultipartETag(etag string) bool {
+	return multipartETagParts(etag) > 0
+}
+
+// multipartETagParts returns the part count of a multipart ETag, or 0.
+func multipartETagParts(etag string) int64 {
+	idx := strings.LastIndexByte(etag, '-')
+	if idx < 0 {
+		return 0
+	}
+	n, err := strconv.ParseInt(etag[idx+1:], 10, 64)
+	if err != nil || n <= 0 {
+		return 0
+	}
+	return n
+}
+
+// candidatePartSizes returns the part sizes that could have produced a
+// multipart upload of size bytes in parts parts: the configured size, the
+// defaults of common tools, and the smallest whole-MiB size that fits.
+func candidatePartSizes(size, parts, configured int64) []int64 {
+	const mib = 1024 * 1024
+	seen := make(map[int64]bool)
+	var out []int64
+	add := func(ps int64) {
+		if ps < memMinPartSize || seen[ps] {
+			return
+		}
+		if (size+ps-1)/ps != parts {
+			return
+		}
+		seen[ps] = true
+		out = append(out, ps)
+	}
+	add(configured)
+	for _, ps := range []int64{8 * mib, 5 * mib, 16 * mib, 15 * mib, 32 * mib, 64 * mib, 100 * mib, 128 * mib, 256 * mib, 512 * mib} {
+		add(ps)
+	}
+	if parts > 0 {
+		exact := (size + parts - 1) / parts
+		add(exact)
+		add((exact + mib - 1) / mib * mib)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
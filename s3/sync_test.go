@@ -0,0 +1,316 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"oss.nandlabs.io/golly-aws/awscfg"
+)
+
+func writeLocalFile(t *testing.T, root, rel, content string) {
+	t.Helper()
+	p := filepath.Join(root, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func fileURL(p string) *url.URL {
+	return &url.URL{Scheme: "file", Path: filepath.ToSlash(p)}
+}
+
+func mustParse(t *testing.T, raw string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func listKeys(t *testing.T, client *awss3.Client, bucket string) []string {
+	t.Helper()
+	out, err := client.ListObjectsV2(context.Background(), &awss3.ListObjectsV2Input{Bucket: aws.String(bucket)})
+	if err != nil {
+		t.Fatalf("ListObjectsV2: %v", err)
+	}
+	var keys []string
+	for _, o := range out.Contents {
+		keys = append(keys, aws.ToString(o.Key))
+	}
+	return keys
+}
+
+func TestSync_LocalToS3TransfersOnlyDifferences(t *testing.T) {
+	_, client := newMemoryClient(t, "sync-up")
+	ctx := context.Background()
+	root := t.TempDir()
+	writeLocalFile(t, root, "a.txt", "alpha")
+	writeLocalFile(t, root, "docs/b.md", "bravo")
+	writeLocalFile(t, root, "tmp/scratch.log", "ignored")
+
+	src := fileURL(root)
+	dst := mustParse(t, "s3://sync-up/mirror")
+	opts := &SyncOptions{Exclude: []string{"*.log"}}
+
+	res, err := Sync(ctx, src, dst, opts)
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if res.Copied != 2 || res.Bytes != 10 {
+		t.Fatalf("first run = %+v", res)
+	}
+	if got := strings.Join(listKeys(t, client, "sync-up"), ","); got != "mirror/a.txt,mirror/docs/b.md" {
+		t.Fatalf("keys = %s", got)
+	}
+
+	res, err = Sync(ctx, src, dst, opts)
+	if err != nil {
+		t.Fatalf("second Sync: %v", err)
+	}
+	if res.Unchanged != 2 || res.Copied+res.Updated != 0 {
+		t.Fatalf("second run = %+v", res)
+	}
+
+	// Same size, different content: only the checksum can tell.
+	writeLocalFile(t, root, "a.txt", "ALPHA")
+	if err := os.Remove(filepath.Join(root, "docs", "b.md")); err != nil {
+		t.Fatal(err)
+	}
+	opts.Delete = true
+	res, err = Sync(ctx, src, dst, opts)
+	if err != nil {
+		t.Fatalf("third Sync: %v", err)
+	}
+	if res.Updated != 1 || res.Deleted != 1 {
+		t.Fatalf("third run = %+v", res)
+	}
+	if got := getString(t, client, &awss3.GetObjectInput{Bucket: aws.String("sync-up"), Key: aws.String("mirror/a.txt")}); got != "ALPHA" {
+		t.Fatalf("a.txt = %q", got)
+	}
+	if got := strings.Join(listKeys(t, client, "sync-up"), ","); got != "mirror/a.txt" {
+		t.Fatalf("keys after delete = %s", got)
+	}
+}
+
+func TestSync_DryRunReportsWithoutChanging(t *testing.T) {
+	_, client := newMemoryClient(t, "sync-dry")
+	putString(t, client, "sync-dry", "extra.txt", "stale")
+	root := t.TempDir()
+	writeLocalFile(t, root, "new.txt", "fresh")
+
+	var out bytes.Buffer
+	res, err := Sync(context.Background(), fileURL(root), mustParse(t, "s3://sync-dry"), &SyncOptions{
+		DryRun: true,
+		Delete: true,
+		Output: &out,
+	})
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if res.Copied != 1 || res.Deleted != 1 || res.Bytes != 0 {
+		t.Fatalf("result = %+v", res)
+	}
+	want := "(dry run) copy new.txt (missing at destination)\n(dry run) delete extra.txt (not in source)\n"
+	if out.String() != want {
+		t.Fatalf("output = %q", out.String())
+	}
+	if got := strings.Join(listKeys(t, client, "sync-dry"), ","); got != "extra.txt" {
+		t.Fatalf("dry run modified bucket: %s", got)
+	}
+}
+
+func TestSync_MultipartETagIsRecognised(t *testing.T) {
+	_, client := newMemoryClient(t, "sync-mpu")
+	ctx := context.Background()
+	root := t.TempDir()
+	big := strings.Repeat("0123456789abcdef", 11*1024*1024/16)
+	writeLocalFile(t, root, "big.bin", big)
+
+	res, err := Sync(ctx, fileURL(root), mustParse(t, "s3://sync-mpu"), &SyncOptions{PartSize: memMinPartSize})
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if res.Copied != 1 {
+		t.Fatalf("result = %+v", res)
+	}
+	head, err := client.HeadObject(ctx, &awss3.HeadObjectInput{Bucket: aws.String("sync-mpu"), Key: aws.String("big.bin")})
+	if err != nil {
+		t.Fatalf("HeadObject: %v", err)
+	}
+	if !strings.HasSuffix(aws.ToString(head.ETag), `-3"`) {
+		t.Fatalf("expected a 3-part upload, etag %s", aws.ToString(head.ETag))
+	}
+
+	// A later run with a different configured part size still verifies the
+	// object by trying likely part sizes.
+	res, err = Sync(ctx, fileURL(root), mustParse(t, "s3://sync-mpu"), &SyncOptions{})
+	if err != nil {
+		t.Fatalf("second Sync: %v", err)
+	}
+	if res.Unchanged != 1 || res.Actions[0].Reason != "checksum matches" {
+		t.Fatalf("second run = %+v", res)
+	}
+}
+
+func TestSync_S3ToS3AndBackToLocal(t *testing.T) {
+	mem, client := newMemoryClient(t, "sync-a")
+	if err := mem.CreateBucket("sync-b"); err != nil {
+		t.Fatal(err)
+	}
+	awscfg.Manager.Register("sync-b", mem.Config("us-east-1"))
+	t.Cleanup(func() { awscfg.Manager.Unregister("sync-b") })
+	putString(t, client, "sync-a", "data/one.txt", "1")
+	putString(t, client, "sync-a", "data/nested/two.txt", "22")
+	ctx := context.Background()
+
+	res, err := Sync(ctx, mustParse(t, "s3://sync-a/data/"), mustParse(t, "s3://sync-b/copy"), nil)
+	if err != nil {
+		t.Fatalf("Sync S3→S3: %v", err)
+	}
+	if res.Copied != 2 {
+		t.Fatalf("S3→S3 result = %+v", res)
+	}
+	res, err = Sync(ctx, mustParse(t, "s3://sync-a/data"), mustParse(t, "s3://sync-b/copy"), nil)
+	if err != nil || res.Unchanged != 2 {
+		t.Fatalf("repeat S3→S3 = %+v, %v", res, err)
+	}
+
+	root := t.TempDir()
+	res, err = Sync(ctx, mustParse(t, "s3://sync-b/copy"), fileURL(root), &SyncOptions{Include: []string{"nested/"}})
+	if err != nil {
+		t.Fatalf("Sync S3→local: %v", err)
+	}
+	if res.Copied != 1 {
+		t.Fatalf("S3→local result = %+v", res)
+	}
+	b, err := os.ReadFile(filepath.Join(root, "nested", "two.txt"))
+	if err != nil || string(b) != "22" {
+		t.Fatalf("downloaded = %q, %v", b, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "one.txt")); !os.IsNotExist(err) {
+		t.Fatalf("one.txt should have been filtered out")
+	}
+}
+
+func TestSync_ModTimeCompare(t *testing.T) {
+	newMemoryClient(t, "sync-mtime")
+	ctx := context.Background()
+	root := t.TempDir()
+	writeLocalFile(t, root, "f.txt", "one")
+	dst := mustParse(t, "s3://sync-mtime")
+	if _, err := Sync(ctx, fileURL(root), dst, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Older source with different content of the same size is left alone.
+	writeLocalFile(t, root, "f.txt", "two")
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(root, "f.txt"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	res, err := Sync(ctx, fileURL(root), dst, &SyncOptions{Compare: CompareModTime})
+	if err != nil || res.Unchanged != 1 {
+		t.Fatalf("mtime compare = %+v, %v", res, err)
+	}
+}
+
+func TestGlobToRegexp(t *testing.T) {
+	cases := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"*.log", "a.log", true},
+		{"*.log", "dir/sub/a.log", true},
+		{"*.log", "a.log.gz", false},
+		{"logs/*.txt", "logs/a.txt", true},
+		{"logs/*.txt", "logs/x/a.txt", false},
+		{"logs/**/*.txt", "logs/x/y/a.txt", true},
+		{"logs/**/*.txt", "logs/a.txt", true},
+		{"build/", "build/out/app", true},
+		{"build/", "src/build/app", true},
+		{"/build/", "src/build/app", false},
+		{"file?.csv", "file1.csv", true},
+		{"file[!0-9].csv", "file1.csv", false},
+		{"file[!0-9].csv", "fileA.csv", true},
+	}
+	for _, c := range cases {
+		re, err := globToRegexp(c.pattern)
+		if err != nil {
+			t.Fatalf("globToRegexp(%q): %v", c.pattern, err)
+		}
+		if got := re.MatchString(c.path); got != c.want {
+			t.Errorf("%q matches %q = %v, want %v", c.pattern, c.path, got, c.want)
+		}
+	}
+	if _, err := globToRegexp("[unclosed"); err == nil {
+		t.Fatalf("expected error for malformed glob")
+	}
+}
+
+func TestSync_EncryptedObjects(t *testing.T) {
+	mem, client := newMemoryClient(t, "sync-enc")
+	if err := mem.CreateBucket("sync-plain"); err != nil {
+		t.Fatal(err)
+	}
+	awscfg.Manager.Register("sync-plain", mem.Config("us-east-1"))
+	t.Cleanup(func() { awscfg.Manager.Unregister("sync-plain") })
+	withCodecOptions(t, "sync-enc", &CodecOptions{Compression: CompressionGzip, KeyProvider: testKeyProvider(t, "key-1", 0x11)})
+	ctx := context.Background()
+	content := strings.Repeat("secret ", 1000)
+	root := t.TempDir()
+	writeLocalFile(t, root, "a.txt", content)
+	writeLocalFile(t, root, "docs/b.txt", "bravo")
+
+	// Uploads are encrypted with the bucket's codecs.
+	if _, err := Sync(ctx, fileURL(root), mustParse(t, "s3://sync-enc/data"), nil); err != nil {
+		t.Fatalf("Sync local→S3: %v", err)
+	}
+	stored, meta := storedObject(t, client, "sync-enc", "data/a.txt")
+	if strings.Contains(stored, "secret") || meta[MetaCodec] != "gzip,aes256-gcm" || meta[MetaLogicalSize] != "7000" {
+		t.Fatalf("uploaded object stored in the clear: %v", meta)
+	}
+
+	// Encoded objects compare by their logical size and decoded content.
+	res, err := Sync(ctx, fileURL(root), mustParse(t, "s3://sync-enc/data"), nil)
+	if err != nil || res.Unchanged != 2 || res.Actions[0].Reason != "checksum matches" {
+		t.Fatalf("repeat local→S3 = %+v, %v", res, err)
+	}
+	writeLocalFile(t, root, "docs/b.txt", "brava")
+	res, err = Sync(ctx, fileURL(root), mustParse(t, "s3://sync-enc/data"), nil)
+	if err != nil || res.Updated != 1 || res.Actions[1].Reason != "checksum differs" {
+		t.Fatalf("changed local→S3 = %+v, %v", res, err)
+	}
+
+	// Downloads and copies to a bucket without codecs are decrypted.
+	down := t.TempDir()
+	res, err = Sync(ctx, mustParse(t, "s3://sync-enc/data"), fileURL(down), nil)
+	if err != nil || res.Copied != 2 || res.Bytes != 7005 {
+		t.Fatalf("Sync S3→local = %+v, %v", res, err)
+	}
+	if b, err := os.ReadFile(filepath.Join(down, "a.txt")); err != nil || string(b) != content {
+		t.Fatalf("downloaded %d bytes, %v", len(b), err)
+	}
+	if _, err := Sync(ctx, mustParse(t, "s3://sync-enc/data"), mustParse(t, "s3://sync-plain/copy"), nil); err != nil {
+		t.Fatalf("Sync S3→S3: %v", err)
+	}
+	if stored, meta := storedObject(t, client, "sync-plain", "copy/a.txt"); stored != content || meta[MetaCodec] != "" {
+		t.Fatalf("plain copy = %d bytes, metadata %v", len(stored), meta)
+	}
+	res, err = Sync(ctx, mustParse(t, "s3://sync-enc/data"), mustParse(t, "s3://sync-plain/copy"), nil)
+	if err != nil || res.Unchanged != 2 {
+		t.Fatalf("repeat S3→S3 = %+v, %v", res, err)
+	}
+}
@@ -2,8 +2,13 @@ package s3
 
 import (
 	"errors"
+	"fmt"
 	"net/url"
+	"regexp"
 	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"oss.nandlabs.io/golly/textutils"
 )
 
 const (
@@ -11,31 +16,242 @@ const (
 	S3Scheme = "s3"
 )
 
+// RequestPayerParam is the URL query parameter that marks requests as
+// requester-pays: s3://bucket/key?requestPayer=requester ("true" is
+// accepted too). Child URLs produced by List, Walk and friends keep it.
+const RequestPayerParam = "requestPayer"
+
+// BucketKind classifies what the host of an s3:// URL addresses.
+type BucketKind int
+
+const (
+	// BucketGeneral is a general purpose bucket name.
+	BucketGeneral BucketKind = iota
+	// BucketAccessPointAlias is an access point alias ("...-s3alias"). It is
+	// used like a bucket name.
+	BucketAccessPointAlias
+	// BucketAccessPoint is a single-region access point, addressed by ARN.
+	BucketAccessPoint
+	// BucketMultiRegionAccessPoint is a Multi-Region Access Point, addressed
+	// by ARN and signed with SigV4A.
+	BucketMultiRegionAccessPoint
+	// BucketDirectory is an S3 Express One Zone directory bucket
+	// ("name--azid--x-s3").
+	BucketDirectory
+)
+
+// String returns a readable name for the kind.
+func (k BucketKind) String() string {
+	switch k {
+	case BucketAccessPointAlias:
+		return "access point alias"
+	case BucketAccessPoint:
+		return "access point"
+	case BucketMultiRegionAccessPoint:
+		return "multi-region access point"
+	case BucketDirectory:
+		return "directory bucket"
+	default:
+		return "bucket"
+	}
+}
+
+// isARN reports whether the kind is addressed by ARN.
+func (k BucketKind) isARN() bool {
+	return k == BucketAccessPoint || k == BucketMultiRegionAccessPoint
+}
+
+// pathStyleCapable reports whether requests for the kind may use
+// path-style addressing (needed by custom endpoints such as MinIO).
+func (k BucketKind) pathStyleCapable() bool {
+	return k == BucketGeneral || k == BucketAccessPointAlias
+}
+
 // urlOpts holds parsed S3 URL components.
 type urlOpts struct {
 	u      *url.URL
 	Bucket string
 	Key    string
+	// Kind says what Bucket addresses. For access points Bucket holds the ARN.
+	Kind BucketKind
+	// RequestPayer marks every request as paid for by the requester.
+	RequestPayer bool
 }
 
+var (
+	// accessPointHostRe matches "<name>-<account>.s3-accesspoint[.dualstack].<region>.amazonaws.com[.cn]".
+	accessPointHostRe = regexp.MustCompile(`^([a-z0-9][a-z0-9-]{1,48}[a-z0-9])-(\d{12})\.s3-accesspoint(?:\.dualstack)?\.([a-z0-9-]+)\.amazonaws\.com(\.cn)?$`)
+	// mrapHostRe matches "<alias>.mrap" and its global endpoint host name.
+	mrapHostRe = regexp.MustCompile(`^([a-z0-9]+\.mrap)(?:\.accesspoint\.s3-global\.amazonaws\.com)?$`)
+	// directoryBucketRe matches "<base>--<zone-id>--x-s3".
+	directoryBucketRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*[a-z0-9]--[a-z0-9-]+--x-s3$`)
+)
+
 // parseURL parses an S3 URL into its bucket and key components.
 // Expected format: s3://bucket-name/key/path
+//
+// Besides bucket names the host may be an access point alias, a directory
+// bucket name, an access point host name
+// (s3://my-ap-123456789012.s3-accesspoint.us-west-2.amazonaws.com/key), or a
+// Multi-Region Access Point alias with the owning account as user info
+// (s3://123456789012@mfzwi23gnjvgw.mrap/key). URLs built in code may also
+// carry an access point ARN as Host; see AccessPointURL.
 func parseURL(u *url.URL) (opts *urlOpts, err error) {
 	if err = validateURL(u); err != nil {
 		return
 	}
 
-	bucket := u.Host
+	bucket, kind, err := resolveBucket(u)
+	if err != nil {
+		return nil, err
+	}
 	key := strings.TrimPrefix(u.Path, "/")
 
 	opts = &urlOpts{
 		u:      u,
 		Bucket: bucket,
 		Key:    key,
+		Kind:   kind,
+	}
+	if v := u.Query().Get(RequestPayerParam); v != "" {
+		switch strings.ToLower(v) {
+		case "requester", "true":
+			opts.RequestPayer = true
+		case "false":
+		default:
+			return nil, fmt.Errorf("s3: invalid %s %q, expected requester", RequestPayerParam, v)
+		}
 	}
 	return
 }
 
+// resolveBucket works out the API bucket name (or ARN) and its kind from
+// the URL host.
+func resolveBucket(u *url.URL) (string, BucketKind, error) {
+	host := strings.ToLower(u.Host)
+	if arn.IsARN(u.Host) {
+		return parseAccessPointARN(u.Host)
+	}
+	if m := accessPointHostRe.FindStringSubmatch(host); m != nil {
+		partition := "aws"
+		if m[4] != "" {
+			partition = "aws-cn"
+		}
+		return arn.ARN{
+			Partition: partition,
+			Service:   "s3",
+			Region:    m[3],
+			AccountID: m[2],
+			Resource:  "accesspoint/" + m[1],
+		}.String(), BucketAccessPoint, nil
+	}
+	if m := mrapHostRe.FindStringSubmatch(host); m != nil {
+		account := u.User.Username()
+		if account == "" {
+			return "", 0, fmt.Errorf("s3: multi-region access point %s needs the account id as user info, e.g. s3://123456789012@%s/key", m[1], m[1])
+		}
+		return arn.ARN{
+			Partition: "aws",
+			Service:   "s3",
+			AccountID: account,
+			Resource:  "accesspoint/" + m[1],
+		}.String(), BucketMultiRegionAccessPoint, nil
+	}
+	switch {
+	case strings.HasSuffix(host, "-s3alias"), strings.HasSuffix(host, "--op-s3"):
+		return u.Host, BucketAccessPointAlias, nil
+	case strings.HasSuffix(host, "--x-s3"):
+		if !directoryBucketRe.MatchString(host) {
+			return "", 0, fmt.Errorf("s3: invalid directory bucket name %q, expected name--zone-id--x-s3", u.Host)
+		}
+		return u.Host, BucketDirectory, nil
+	}
+	return u.Host, BucketGeneral, nil
+}
+
+// parseAccessPointARN validates an S3 access point ARN.
+func parseAccessPointARN(s string) (string, BucketKind, error) {
+	a, err := arn.Parse(s)
+	if err != nil {
+		return "", 0, fmt.Errorf("s3: invalid ARN %q: %w", s, err)
+	}
+	name, ok := strings.CutPrefix(a.Resource, "accesspoint/")
+	if a.Service != "s3" || !ok || name == "" || strings.Contains(name, "/") {
+		return "", 0, fmt.Errorf("s3: unsupported ARN %q, expected an S3 access point", s)
+	}
+	if a.AccountID == "" {
+		return "", 0, fmt.Errorf("s3: access point ARN %q has no account id", s)
+	}
+	if a.Region == "" {
+		if !strings.HasSuffix(name, ".mrap") {
+			return "", 0, fmt.Errorf("s3: access point ARN %q has no region", s)
+		}
+		return s, BucketMultiRegionAccessPoint, nil
+	}
+	return s, BucketAccessPoint, nil
+}
+
+// AccessPointURL returns an s3:// URL for key under the access point or
+// Multi-Region Access Point with the given ARN. The URL uses the host name
+// form, so its String() can be parsed back with url.Parse.
+func AccessPointURL(accessPointARN, key string) (*url.URL, error) {
+	_, kind, err := parseAccessPointARN(accessPointARN)
+	if err != nil {
+		return nil, err
+	}
+	a, _ := arn.Parse(accessPointARN)
+	name := strings.TrimPrefix(a.Resource, "accesspoint/")
+	u := &url.URL{Scheme: S3Scheme, Path: "/" + strings.TrimPrefix(key, "/")}
+	if kind == BucketMultiRegionAccessPoint {
+		u.User = url.User(a.AccountID)
+		u.Host = name
+		return u, nil
+	}
+	u.Host = fmt.Sprintf("%s-%s.s3-accesspoint.%s.amazonaws.com", name, a.AccountID, a.Region)
+	if a.Partition == "aws-cn" {
+		u.Host += ".cn"
+	}
+	return u, nil
+}
+
+// childURL returns the URL of key in the same bucket, keeping the host
+// form, user info and query of the original URL.
+func (o *urlOpts) childURL(key string) *url.URL {
+	return &url.URL{
+		Scheme:   S3Scheme,
+		User:     o.u.User,
+		Host:     o.u.Host,
+		Path:     "/" + key,
+		RawQuery: o.u.RawQuery,
+	}
+}
+
+// withKey returns a copy of o addressing key.
+func (o *urlOpts) withKey(key string) *urlOpts {
+	c := *o
+	c.Key = key
+	c.u = o.childURL(key)
+	return &c
+}
+
+// copySource returns the x-amz-copy-source value for this object.
+func (o *urlOpts) copySource() string {
+	return copySourcePath(o.Bucket, o.Key)
+}
+
+// copySourcePath builds the URL-encoded x-amz-copy-source value. Access
+// point ARNs use the "<arn>/object/<key>" form.
+func copySourcePath(bucket, key string) string {
+	segments := strings.Split(key, textutils.ForwardSlashStr)
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	if arn.IsARN(bucket) {
+		bucket += "/object"
+	}
+	return bucket + textutils.ForwardSlashStr + strings.Join(segments, textutils.ForwardSlashStr)
+}
+
 // validateURL checks that the URL is a valid S3 URL.
 func validateURL(u *url.URL) error {
 	if u == nil {
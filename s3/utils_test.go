@@ -0,0 +1,105 @@
+package s3
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestParseURL_BucketKinds(t *testing.T) {
+	cases := []struct {
+		raw    string
+		bucket string
+		key    string
+		kind   BucketKind
+	}{
+		{"s3://plain-bucket/a/b.txt", "plain-bucket", "a/b.txt", BucketGeneral},
+		{"s3://my-ap-hrzrlukc5m36ft7okagglf3gmwluquse1b-s3alias/k", "my-ap-hrzrlukc5m36ft7okagglf3gmwluquse1b-s3alias", "k", BucketAccessPointAlias},
+		{"s3://logs--usw2-az1--x-s3/2024/k", "logs--usw2-az1--x-s3", "2024/k", BucketDirectory},
+		{"s3://my-ap-123456789012.s3-accesspoint.us-west-2.amazonaws.com/k",
+			"arn:aws:s3:us-west-2:123456789012:accesspoint/my-ap", "k", BucketAccessPoint},
+		{"s3://cn-ap-123456789012.s3-accesspoint.cn-north-1.amazonaws.com.cn/k",
+			"arn:aws-cn:s3:cn-north-1:123456789012:accesspoint/cn-ap", "k", BucketAccessPoint},
+		{"s3://123456789012@mfzwi23gnjvgw.mrap/k",
+			"arn:aws:s3::123456789012:accesspoint/mfzwi23gnjvgw.mrap", "k", BucketMultiRegionAccessPoint},
+		{"s3://123456789012@mfzwi23gnjvgw.mrap.accesspoint.s3-global.amazonaws.com/k",
+			"arn:aws:s3::123456789012:accesspoint/mfzwi23gnjvgw.mrap", "k", BucketMultiRegionAccessPoint},
+	}
+	for _, c := range cases {
+		u, err := url.Parse(c.raw)
+		if err != nil {
+			t.Fatalf("url.Parse(%q): %v", c.raw, err)
+		}
+		opts, err := parseURL(u)
+		if err != nil {
+			t.Fatalf("parseURL(%q): %v", c.raw, err)
+		}
+		if opts.Bucket != c.bucket || opts.Key != c.key || opts.Kind != c.kind {
+			t.Errorf("parseURL(%q) = %q %q %v, want %q %q %v", c.raw, opts.Bucket, opts.Key, opts.Kind, c.bucket, c.key, c.kind)
+		}
+	}
+
+	// URLs built in code may carry the ARN itself.
+	opts, err := parseURL(&url.URL{Scheme: S3Scheme, Host: "arn:aws:s3:eu-west-1:123456789012:accesspoint/direct", Path: "/k"})
+	if err != nil || opts.Kind != BucketAccessPoint || opts.Bucket != "arn:aws:s3:eu-west-1:123456789012:accesspoint/direct" {
+		t.Fatalf("ARN host = %+v, %v", opts, err)
+	}
+
+	for _, raw := range []string{
+		"s3://mfzwi23gnjvgw.mrap/k",   // no account
+		"s3://bad---x-s3/k",           // malformed directory bucket
+		"s3://b/k?requestPayer=owner", // unknown payer
+	} {
+		u, _ := url.Parse(raw)
+		if _, err := parseURL(u); err == nil {
+			t.Errorf("parseURL(%q) should fail", raw)
+		}
+	}
+	for _, bad := range []string{
+		"arn:aws:s3:::bucket-name",
+		"arn:aws:sqs:us-east-1:123456789012:queue",
+		"arn:aws:s3::123456789012:accesspoint/not-mrap",
+	} {
+		if _, err := parseURL(&url.URL{Scheme: S3Scheme, Host: bad}); err == nil {
+			t.Errorf("ARN host %q should fail", bad)
+		}
+	}
+}
+
+func TestParseURL_RequestPayerAndChildURLs(t *testing.T) {
+	u, _ := url.Parse("s3://123456789012@mfzwi23gnjvgw.mrap/dir/?requestPayer=requester")
+	opts, err := parseURL(u)
+	if err != nil || !opts.RequestPayer {
+		t.Fatalf("parseURL = %+v, %v", opts, err)
+	}
+	child := opts.childURL("dir/file.txt")
+	if child.String() != "s3://123456789012@mfzwi23gnjvgw.mrap/dir/file.txt?requestPayer=requester" {
+		t.Fatalf("childURL = %s", child)
+	}
+	if again, err := parseURL(child); err != nil || again.Bucket != opts.Bucket || !again.RequestPayer {
+		t.Fatalf("child does not round-trip: %+v, %v", again, err)
+	}
+}
+
+func TestAccessPointURL_RoundTrips(t *testing.T) {
+	for _, a := range []string{
+		"arn:aws:s3:us-west-2:123456789012:accesspoint/my-ap",
+		"arn:aws-cn:s3:cn-north-1:123456789012:accesspoint/cn-ap",
+		"arn:aws:s3::123456789012:accesspoint/mfzwi23gnjvgw.mrap",
+	} {
+		u, err := AccessPointURL(a, "x/y.txt")
+		if err != nil {
+			t.Fatalf("AccessPointURL(%q): %v", a, err)
+		}
+		parsed, err := url.Parse(u.String())
+		if err != nil {
+			t.Fatalf("url.Parse(%s): %v", u, err)
+		}
+		opts, err := parseURL(parsed)
+		if err != nil || opts.Bucket != a || opts.Key != "x/y.txt" {
+			t.Fatalf("round trip of %q = %+v, %v", a, opts, err)
+		}
+	}
+	if got := copySourcePath("arn:aws:s3:us-west-2:123456789012:accesspoint/my-ap", "a b/c"); got != "arn:aws:s3:us-west-2:123456789012:accesspoint/my-ap/object/a%20b/c" {
+		t.Fatalf("copySourcePath = %s", got)
+	}
+}
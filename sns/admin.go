@@ -0,0 +1,780 @@
+package sns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// sqsScheme is the URL scheme of the SQS queues subscribed to topics.
+const sqsScheme = "sqs"
+
+// TopicAdmin manages SNS topics, addressed by the same sns:// URLs the
+// provider publishes to, and their subscriptions. Every method resolves its
+// client through the awscfg mapping of the URL, so a per-topic config
+// registered for messaging also applies here. A topic whose name ends in
+// .fifo is a FIFO topic.
+//
+// EnsureTopic, DeleteTopic, Subscribe and Unsubscribe are idempotent: they
+// read the current state, write only when it differs from the desired state,
+// and report whether anything changed, so services can bootstrap their
+// topology on every start.
+type TopicAdmin interface {
+	TopicExists(ctx context.Context, u *url.URL) (bool, error)
+	TopicArn(ctx context.Context, u *url.URL) (string, error)
+	GetTopicConfig(ctx context.Context, u *url.URL) (*TopicConfig, error)
+	EnsureTopic(ctx context.Context, u *url.URL, cfg *TopicConfig) (bool, error)
+	DeleteTopic(ctx context.Context, u *url.URL) (bool, error)
+
+	Subscribe(ctx context.Context, u *url.URL, sub *Subscription) (string, bool, error)
+	ListSubscriptions(ctx context.Context, u *url.URL) ([]*SubscriptionInfo, error)
+	Unsubscribe(ctx context.Context, u *url.URL, endpoint string) (bool, error)
+}
+
+// Admin returns the SNS TopicAdmin.
+func Admin() TopicAdmin {
+	return topicAdmin{}
+}
+
+// TopicConfig is the desired configuration of a topic. Zero and nil fields
+// are left as they are on an existing topic and at the SNS default on a new
+// one.
+type TopicConfig struct {
+	DisplayName string
+	// KMSKeyId enables SSE-KMS with the given key id, ARN or alias.
+	KMSKeyId string
+	// ContentBasedDeduplication derives deduplication IDs from the body
+	// (FIFO only).
+	ContentBasedDeduplication *bool
+	// DataProtectionPolicy is the JSON data protection policy that audits,
+	// masks or blocks sensitive data published to the topic.
+	DataProtectionPolicy string
+}
+
+// SubscriptionProtocol is the delivery protocol of a subscription.
+type SubscriptionProtocol string
+
+const (
+	// ProtocolSQS delivers to an SQS queue.
+	ProtocolSQS SubscriptionProtocol = "sqs"
+	// ProtocolHTTP delivers by POST to an http:// endpoint.
+	ProtocolHTTP SubscriptionProtocol = "http"
+	// ProtocolHTTPS delivers by POST to an https:// endpoint.
+	ProtocolHTTPS SubscriptionProtocol = "https"
+	// ProtocolLambda invokes a Lambda function.
+	ProtocolLambda SubscriptionProtocol = "lambda"
+	// ProtocolEmail sends the message text by email.
+	ProtocolEmail SubscriptionProtocol = "email"
+	// ProtocolEmailJSON sends the JSON notification by email.
+	ProtocolEmailJSON SubscriptionProtocol = "email-json"
+)
+
+// FilterPolicyScope selects what a subscription filter policy matches.
+type FilterPolicyScope string
+
+const (
+	// FilterOnAttributes matches the message attributes (the SNS default).
+	FilterOnAttributes FilterPolicyScope = "MessageAttributes"
+	// FilterOnBody matches the JSON message body.
+	FilterOnBody FilterPolicyScope = "MessageBody"
+)
+
+// Subscription is the desired subscription of an endpoint to a topic. Zero
+// and nil fields are left as they are on an existing subscription and at
+// the SNS default on a new one.
+type Subscription struct {
+	// Endpoint is an sqs:// URL or queue ARN, an http(s):// URL, a Lambda
+	// function ARN or an email address.
+	Endpoint string
+	// Protocol defaults to the one Endpoint implies; set it to
+	// ProtocolEmailJSON for JSON email.
+	Protocol SubscriptionProtocol
+	// FilterPolicy is the JSON filter policy of the subscription.
+	FilterPolicy      string
+	FilterPolicyScope FilterPolicyScope
+	// RawMessageDelivery delivers the message body without the SNS JSON
+	// envelope (SQS and HTTP(S) only).
+	RawMessageDelivery *bool
+	// DeadLetter is the SQS queue, as an sqs:// URL or a queue ARN, that
+	// keeps messages SNS could not deliver. A FIFO topic needs a FIFO
+	// dead-letter queue and a standard topic a standard one.
+	DeadLetter string
+	// SkipQueuePolicy leaves the access policies of the SQS endpoint and
+	// dead-letter queues alone. By default they are extended to let the
+	// topic send to them.
+	SkipQueuePolicy bool
+}
+
+// SubscriptionInfo describes an existing subscription of a topic.
+type SubscriptionInfo struct {
+	// Arn is empty while the subscription is pending confirmation.
+	Arn      string
+	Protocol SubscriptionProtocol
+	Endpoint string
+	Owner    string
+	// Pending reports that the endpoint has not confirmed the subscription
+	// yet. SNS drops unconfirmed subscriptions after three days.
+	Pending bool
+}
+
+// snsAdminAPI is the topic-management subset of the SNS client used by
+// TopicAdmin.
+type snsAdminAPI interface {
+	topicAPI
+	SetTopicAttributes(ctx context.Context, params *sns.SetTopicAttributesInput, optFns ...func(*sns.Options)) (*sns.SetTopicAttributesOutput, error)
+	DeleteTopic(ctx context.Context, params *sns.DeleteTopicInput, optFns ...func(*sns.Options)) (*sns.DeleteTopicOutput, error)
+	GetDataProtectionPolicy(ctx context.Context, params *sns.GetDataProtectionPolicyInput, optFns ...func(*sns.Options)) (*sns.GetDataProtectionPolicyOutput, error)
+	PutDataProtectionPolicy(ctx context.Context, params *sns.PutDataProtectionPolicyInput, optFns ...func(*sns.Options)) (*sns.PutDataProtectionPolicyOutput, error)
+	Subscribe(ctx context.Context, params *sns.SubscribeInput, optFns ...func(*sns.Options)) (*sns.SubscribeOutput, error)
+	Unsubscribe(ctx context.Context, params *sns.UnsubscribeInput, optFns ...func(*sns.Options)) (*sns.UnsubscribeOutput, error)
+	ListSubscriptionsByTopic(ctx context.Context, params *sns.ListSubscriptionsByTopicInput, optFns ...func(*sns.Options)) (*sns.ListSubscriptionsByTopicOutput, error)
+	GetSubscriptionAttributes(ctx context.Context, params *sns.GetSubscriptionAttributesInput, optFns ...func(*sns.Options)) (*sns.GetSubscriptionAttributesOutput, error)
+	SetSubscriptionAttributes(ctx context.Context, params *sns.SetSubscriptionAttributesInput, optFns ...func(*sns.Options)) (*sns.SetSubscriptionAttributesOutput, error)
+}
+
+// QueueAPI is the subset of the SQS client used to look up subscribed
+// queues and extend their access policies. See UseClient.
+type QueueAPI interface {
+	GetQueueUrl(ctx context.Context, params *sqs.GetQueueUrlInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueUrlOutput, error)
+	GetQueueAttributes(ctx context.Context, params *sqs.GetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error)
+	SetQueueAttributes(ctx context.Context, params *sqs.SetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.SetQueueAttributesOutput, error)
+}
+
+// Compile-time checks that the SDK clients satisfy the admin interfaces.
+var (
+	_ snsAdminAPI = (*sns.Client)(nil)
+	_ QueueAPI    = (*sqs.Client)(nil)
+)
+
+// resolveAdminClient and resolveQueueClient return the clients for u;
+// region, when set, overrides the region of the queue client's config.
+// They are package-level vars for test injection.
+var (
+	resolveAdminClient = func(u *url.URL) (snsAdminAPI, error) {
+		return getSNSClient(u)
+	}
+	resolveQueueClient = func(u *url.URL, region string) (QueueAPI, error) {
+		return getSQSClient(u, region)
+	}
+)
+
+type topicAdmin struct{}
+
+// adminTopic resolves the admin client of u and looks up its topic ARN,
+// bypassing the ARN cache. topicArn is empty when the topic does not exist.
+func adminTopic(ctx context.Context, u *url.URL) (client snsAdminAPI, topicArn string, err error) {
+	arn, isARN := urlARN(u)
+	if !isARN && u.Host == "" {
+		return nil, "", fmt.Errorf("sns: topic name (URL host) is required")
+	}
+	client, err = resolveAdminClient(u)
+	if err != nil {
+		return nil, "", err
+	}
+	if !isARN {
+		arn, err = lookupTopicARN(ctx, client, u, u.Host)
+		if errors.Is(err, errTopicMissing) {
+			return client, "", nil
+		}
+		return client, arn, err
+	}
+	_, err = client.GetTopicAttributes(ctx, &sns.GetTopicAttributesInput{TopicArn: &arn})
+	if isTopicMissing(err) {
+		return client, "", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("sns: failed to get attributes of topic %s: %w", arn, err)
+	}
+	return client, arn, nil
+}
+
+// existingTopic is adminTopic for methods that need the topic to exist.
+func existingTopic(ctx context.Context, u *url.URL) (snsAdminAPI, string, error) {
+	client, topicArn, err := adminTopic(ctx, u)
+	if err == nil && topicArn == "" {
+		err = fmt.Errorf("sns: topic %q does not exist", topicName(u))
+	}
+	return client, topicArn, err
+}
+
+// TopicExists reports whether the topic named by u exists.
+func (topicAdmin) TopicExists(ctx context.Context, u *url.URL) (bool, error) {
+	_, topicArn, err := adminTopic(ctx, u)
+	return topicArn != "", err
+}
+
+// TopicArn returns the ARN of the topic named by u.
+func (topicAdmin) TopicArn(ctx context.Context, u *url.URL) (string, error) {
+	_, topicArn, err := existingTopic(ctx, u)
+	return topicArn, err
+}
+
+// GetTopicConfig returns the current configuration of the topic named by u.
+func (topicAdmin) GetTopicConfig(ctx context.Context, u *url.URL) (*TopicConfig, error) {
+	client, topicArn, err := existingTopic(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := topicAttributes(ctx, client, topicArn)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &TopicConfig{DisplayName: attrs["DisplayName"], KMSKeyId: attrs["KmsMasterKeyId"]}
+	if v, ok := attrs["ContentBasedDeduplication"]; ok {
+		cbd := v == "true"
+		cfg.ContentBasedDeduplication = &cbd
+	}
+	cfg.DataProtectionPolicy, err = dataProtectionPolicy(ctx, client, topicArn)
+	return cfg, err
+}
+
+// EnsureTopic creates the topic named by u with cfg unless it exists, and
+// otherwise updates the attributes that differ from cfg. It reports whether
+// the topic was created or changed. A nil cfg only ensures the topic exists.
+// A topic addressed by ARN is never created.
+func (topicAdmin) EnsureTopic(ctx context.Context, u *url.URL, cfg *TopicConfig) (bool, error) {
+	if cfg == nil {
+		cfg = &TopicConfig{}
+	}
+	name := topicName(u)
+	if err := cfg.validate(isFIFOTopic(name)); err != nil {
+		return false, fmt.Errorf("sns: topic %q: %w", name, err)
+	}
+	client, topicArn, err := adminTopic(ctx, u)
+	if err != nil {
+		return false, err
+	}
+	desired := cfg.attributes()
+
+	if topicArn == "" {
+		if _, isARN := urlARN(u); isARN {
+			return false, fmt.Errorf("sns: topic %s does not exist and cannot be created from its ARN", name)
+		}
+		if isFIFOTopic(name) {
+			desired["FifoTopic"] = "true"
+		}
+		input := &sns.CreateTopicInput{Name: &name, Attributes: desired}
+		if cfg.DataProtectionPolicy != "" {
+			input.DataProtectionPolicy = &cfg.DataProtectionPolicy
+		}
+		out, err := client.CreateTopic(ctx, input)
+		if err != nil {
+			return false, fmt.Errorf("sns: failed to create topic %q: %w", name, err)
+		}
+		topicARNsMu.Lock()
+		topicARNs[topicKeyOf(u)] = safeDeref(out.TopicArn)
+		topicARNsMu.Unlock()
+		return true, nil
+	}
+
+	changed := false
+	if len(desired) > 0 {
+		current, err := topicAttributes(ctx, client, topicArn)
+		if err != nil {
+			return false, err
+		}
+		for _, attr := range slices.Sorted(maps.Keys(desired)) {
+			want := desired[attr]
+			if current[attr] == want {
+				continue
+			}
+			_, err = client.SetTopicAttributes(ctx, &sns.SetTopicAttributesInput{
+				TopicArn: &topicArn, AttributeName: strPtr(attr), AttributeValue: &want,
+			})
+			if err != nil {
+				return changed, fmt.Errorf("sns: failed to set %s of topic %q: %w", attr, name, err)
+			}
+			changed = true
+		}
+	}
+	if cfg.DataProtectionPolicy != "" {
+		current, err := dataProtectionPolicy(ctx, client, topicArn)
+		if err != nil {
+			return changed, err
+		}
+		if !jsonEqual(current, cfg.DataProtectionPolicy) {
+			_, err = client.PutDataProtectionPolicy(ctx, &sns.PutDataProtectionPolicyInput{
+				ResourceArn: &topicArn, DataProtectionPolicy: &cfg.DataProtectionPolicy,
+			})
+			if err != nil {
+				return changed, fmt.Errorf("sns: failed to put data protection policy of topic %q: %w", name, err)
+			}
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// DeleteTopic deletes the topic named by u and its subscriptions. It
+// reports whether a topic was deleted; a missing topic is not an error.
+func (topicAdmin) DeleteTopic(ctx context.Context, u *url.URL) (bool, error) {
+	client, topicArn, err := adminTopic(ctx, u)
+	if err != nil || topicArn == "" {
+		return false, err
+	}
+	forgetTopicARN(u)
+	_, err = client.DeleteTopic(ctx, &sns.DeleteTopicInput{TopicArn: &topicArn})
+	if isTopicMissing(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("sns: failed to delete topic %q: %w", topicName(u), err)
+	}
+	return true, nil
+}
+
+// Subscribe subscribes sub.Endpoint to the topic named by u unless it is
+// subscribed, and otherwise updates the subscription attributes that differ
+// from sub. SQS endpoint and dead-letter queues get a policy statement that
+// lets the topic send to them. It returns the subscription ARN and reports
+// whether anything was created or changed.
+//
+// HTTP(S) and email endpoints must confirm a new subscription before it
+// delivers messages; until then the attributes of a pending subscription
+// cannot be changed.
+func (topicAdmin) Subscribe(ctx context.Context, u *url.URL, sub *Subscription) (string, bool, error) {
+	if sub == nil {
+		return "", false, fmt.Errorf("sns: subscription is required")
+	}
+	protocol, err := sub.validate(isFIFOTopic(topicName(u)))
+	if err != nil {
+		return "", false, fmt.Errorf("sns: subscription of %q to topic %q: %w", sub.Endpoint, topicName(u), err)
+	}
+	client, topicArn, err := existingTopic(ctx, u)
+	if err != nil {
+		return "", false, err
+	}
+
+	endpoint := sub.Endpoint
+	changed := false
+	var queues []*subscribedQueue
+	if protocol == ProtocolSQS {
+		q, err := lookupQueue(ctx, sub.Endpoint)
+		if err != nil {
+			return "", false, err
+		}
+		endpoint = q.arn
+		queues = append(queues, q)
+	}
+	desired := make(map[string]string)
+	if sub.FilterPolicy != "" {
+		desired["FilterPolicy"] = sub.FilterPolicy
+	}
+	if sub.FilterPolicyScope != "" {
+		desired["FilterPolicyScope"] = string(sub.FilterPolicyScope)
+	}
+	if sub.RawMessageDelivery != nil {
+		desired["RawMessageDelivery"] = strconv.FormatBool(*sub.RawMessageDelivery)
+	}
+	if sub.DeadLetter != "" {
+		dlq, err := lookupQueue(ctx, sub.DeadLetter)
+		if err != nil {
+			return "", false, err
+		}
+		raw, _ := json.Marshal(map[string]string{"deadLetterTargetArn": dlq.arn})
+		desired["RedrivePolicy"] = string(raw)
+		queues = append(queues, dlq)
+	}
+	if !sub.SkipQueuePolicy {
+		for _, q := range queues {
+			added, err := q.allowTopic(ctx, topicArn)
+			if err != nil {
+				return "", changed, err
+			}
+			changed = changed || added
+		}
+	}
+
+	existing, err := findSubscription(ctx, client, topicArn, protocol, endpoint)
+	if err != nil {
+		return "", changed, err
+	}
+	if existing == nil || existing.Pending {
+		out, err := client.Subscribe(ctx, &sns.SubscribeInput{
+			TopicArn:              &topicArn,
+			Protocol:              strPtr(string(protocol)),
+			Endpoint:              &endpoint,
+			Attributes:            desired,
+			ReturnSubscriptionArn: true,
+		})
+		if err != nil {
+			return "", changed, fmt.Errorf("sns: failed to subscribe %q to topic %q: %w", sub.Endpoint, topicName(u), err)
+		}
+		return safeDeref(out.SubscriptionArn), changed || existing == nil, nil
+	}
+
+	if len(desired) == 0 {
+		return existing.Arn, changed, nil
+	}
+	out, err := client.GetSubscriptionAttributes(ctx, &sns.GetSubscriptionAttributesInput{SubscriptionArn: &existing.Arn})
+	if err != nil {
+		return "", changed, fmt.Errorf("sns: failed to get attributes of subscription %s: %w", existing.Arn, err)
+	}
+	for _, attr := range slices.Sorted(maps.Keys(desired)) {
+		want := desired[attr]
+		if subscriptionAttributeEqual(attr, out.Attributes[attr], want) {
+			continue
+		}
+		_, err = client.SetSubscriptionAttributes(ctx, &sns.SetSubscriptionAttributesInput{
+			SubscriptionArn: &existing.Arn, AttributeName: strPtr(attr), AttributeValue: &want,
+		})
+		if err != nil {
+			return "", changed, fmt.Errorf("sns: failed to set %s of subscription %s: %w", attr, existing.Arn, err)
+		}
+		changed = true
+	}
+	return existing.Arn, changed, nil
+}
+
+// ListSubscriptions returns the subscriptions of the topic named by u.
+func (topicAdmin) ListSubscriptions(ctx context.Context, u *url.URL) ([]*SubscriptionInfo, error) {
+	client, topicArn, err := existingTopic(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	return listSubscriptions(ctx, client, topicArn)
+}
+
+// Unsubscribe removes the confirmed subscriptions of endpoint (as accepted
+// by Subscription.Endpoint) from the topic named by u. It reports whether
+// a subscription was removed; a missing topic or subscription is not an
+// error. Pending subscriptions cannot be removed and expire instead.
+func (topicAdmin) Unsubscribe(ctx context.Context, u *url.URL, endpoint string) (bool, error) {
+	client, topicArn, err := adminTopic(ctx, u)
+	if err != nil || topicArn == "" {
+		return false, err
+	}
+	subs, err := listSubscriptions(ctx, client, topicArn)
+	if err != nil {
+		return false, err
+	}
+	removed := false
+	for _, s := range subs {
+		if s.Pending || !endpointMatches(s, endpoint) {
+			continue
+		}
+		_, err = client.Unsubscribe(ctx, &sns.UnsubscribeInput{SubscriptionArn: &s.Arn})
+		if err != nil && !isTopicMissing(err) {
+			return removed, fmt.Errorf("sns: failed to unsubscribe %s: %w", s.Arn, err)
+		}
+		removed = removed || err == nil
+	}
+	return removed, nil
+}
+
+func topicAttributes(ctx context.Context, client snsAdminAPI, topicArn string) (map[string]string, error) {
+	out, err := client.GetTopicAttributes(ctx, &sns.GetTopicAttributesInput{TopicArn: &topicArn})
+	if err != nil {
+		return nil, fmt.Errorf("sns: failed to get attributes of topic %s: %w", topicArn, err)
+	}
+	return out.Attributes, nil
+}
+
+func dataProtectionPolicy(ctx context.Context, client snsAdminAPI, topicArn string) (string, error) {
+	out, err := client.GetDataProtectionPolicy(ctx, &sns.GetDataProtectionPolicyInput{ResourceArn: &topicArn})
+	if err != nil {
+		return "", fmt.Errorf("sns: failed to get data protection policy of topic %s: %w", topicArn, err)
+	}
+	return safeDeref(out.DataProtectionPolicy), nil
+}
+
+func listSubscriptions(ctx context.Context, client snsAdminAPI, topicArn string) ([]*SubscriptionInfo, error) {
+	var subs []*SubscriptionInfo
+	input := &sns.ListSubscriptionsByTopicInput{TopicArn: &topicArn}
+	for {
+		out, err := client.ListSubscriptionsByTopic(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("sns: failed to list subscriptions of topic %s: %w", topicArn, err)
+		}
+		for _, s := range out.Subscriptions {
+			subs = append(subs, subscriptionInfo(s))
+		}
+		if out.NextToken == nil {
+			return subs, nil
+		}
+		input.NextToken = out.NextToken
+	}
+}
+
+func subscriptionInfo(s types.Subscription) *SubscriptionInfo {
+	info := &SubscriptionInfo{
+		Arn:      safeDeref(s.SubscriptionArn),
+		Protocol: SubscriptionProtocol(safeDeref(s.Protocol)),
+		Endpoint: safeDeref(s.Endpoint),
+		Owner:    safeDeref(s.Owner),
+	}
+	// Unconfirmed subscriptions are listed with a placeholder ARN.
+	if !strings.HasPrefix(info.Arn, "arn:") {
+		info.Arn, info.Pending = "", true
+	}
+	return info
+}
+
+// findSubscription returns the subscription of endpoint to the topic, or
+// nil when there is none.
+func findSubscription(ctx context.Context, client snsAdminAPI, topicArn string, protocol SubscriptionProtocol, endpoint string) (*SubscriptionInfo, error) {
+	subs, err := listSubscriptions(ctx, client, topicArn)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range subs {
+		if s.Protocol == protocol && s.Endpoint == endpoint {
+			return s, nil
+		}
+	}
+	return nil, nil
+}
+
+// endpointMatches reports whether s subscribes endpoint. An sqs:// URL
+// matches by queue name, so a subscription of a deleted queue can still be
+// removed.
+func endpointMatches(s *SubscriptionInfo, endpoint string) bool {
+	if u, err := url.Parse(endpoint); err == nil && u.Scheme == sqsScheme {
+		return s.Protocol == ProtocolSQS && strings.HasSuffix(s.Endpoint, ":"+u.Host)
+	}
+	return s.Endpoint == endpoint
+}
+
+// validate checks the parts of cfg SNS would otherwise reject with a less
+// helpful message.
+func (cfg *TopicConfig) validate(fifo bool) error {
+	if !fifo && cfg.ContentBasedDeduplication != nil && *cfg.ContentBasedDeduplication {
+		return errors.New("ContentBasedDeduplication requires a FIFO topic")
+	}
+	if cfg.DataProtectionPolicy != "" && !json.Valid([]byte(cfg.DataProtectionPolicy)) {
+		return errors.New("DataProtectionPolicy is not valid JSON")
+	}
+	return nil
+}
+
+// attributes returns the SNS topic attributes cfg sets.
+func (cfg *TopicConfig) attributes() map[string]string {
+	attrs := make(map[string]string)
+	if cfg.DisplayName != "" {
+		attrs["DisplayName"] = cfg.DisplayName
+	}
+	if cfg.KMSKeyId != "" {
+		attrs["KmsMasterKeyId"] = cfg.KMSKeyId
+	}
+	if cfg.ContentBasedDeduplication != nil {
+		attrs["ContentBasedDeduplication"] = strconv.FormatBool(*cfg.ContentBasedDeduplication)
+	}
+	return attrs
+}
+
+// validate checks sub and returns its protocol.
+func (sub *Subscription) validate(fifo bool) (SubscriptionProtocol, error) {
+	if sub.Endpoint == "" {
+		return "", errors.New("Endpoint is required")
+	}
+	protocol := sub.Protocol
+	if protocol == "" {
+		protocol = endpointProtocol(sub.Endpoint)
+		if protocol == "" {
+			return "", errors.New("Protocol is required for this endpoint")
+		}
+	}
+	if sub.FilterPolicy != "" && !json.Valid([]byte(sub.FilterPolicy)) {
+		return "", errors.New("FilterPolicy is not valid JSON")
+	}
+	switch sub.FilterPolicyScope {
+	case "", FilterOnAttributes, FilterOnBody:
+	default:
+		return "", fmt.Errorf("FilterPolicyScope %q is not recognized", sub.FilterPolicyScope)
+	}
+	if sub.RawMessageDelivery != nil && *sub.RawMessageDelivery {
+		switch protocol {
+		case ProtocolSQS, ProtocolHTTP, ProtocolHTTPS:
+		default:
+			return "", fmt.Errorf("RawMessageDelivery is not supported by protocol %q", protocol)
+		}
+	}
+	if sub.DeadLetter != "" && isFIFOQueueRef(sub.DeadLetter) != fifo {
+		return "", fmt.Errorf("dead-letter queue %s must be of the same type (FIFO or standard) as the topic", sub.DeadLetter)
+	}
+	return protocol, nil
+}
+
+// endpointProtocol returns the protocol an endpoint implies, or "".
+func endpointProtocol(endpoint string) SubscriptionProtocol {
+	switch {
+	case strings.HasPrefix(endpoint, sqsScheme+"://"):
+		return ProtocolSQS
+	case strings.HasPrefix(endpoint, "https://"):
+		return ProtocolHTTPS
+	case strings.HasPrefix(endpoint, "http://"):
+		return ProtocolHTTP
+	case strings.HasPrefix(endpoint, "arn:"):
+		// arn:<partition>:<service>:...
+		if parts := strings.SplitN(endpoint, ":", 4); len(parts) == 4 {
+			switch parts[2] {
+			case "sqs":
+				return ProtocolSQS
+			case "lambda":
+				return ProtocolLambda
+			}
+		}
+	case strings.Contains(endpoint, "@"):
+		return ProtocolEmail
+	}
+	return ""
+}
+
+func subscriptionAttributeEqual(name, current, desired string) bool {
+	switch name {
+	case "FilterPolicy", "RedrivePolicy":
+		return jsonEqual(current, desired)
+	case "FilterPolicyScope":
+		// SNS reports no scope for attribute filtering set by default.
+		return current == desired || (current == "" && desired == string(FilterOnAttributes))
+	}
+	return current == desired
+}
+
+// jsonEqual compares two JSON documents by content, since AWS does not
+// preserve the formatting of policies.
+func jsonEqual(a, b string) bool {
+	var va, vb any
+	if json.Unmarshal([]byte(a), &va) != nil || json.Unmarshal([]byte(b), &vb) != nil {
+		return a == b
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+// subscribedQueue is an SQS queue an endpoint or dead-letter queue refers
+// to.
+type subscribedQueue struct {
+	client   QueueAPI
+	queueURL string
+	arn      string
+}
+
+// lookupQueue resolves an sqs:// URL or queue ARN to its queue. The queue
+// of an ARN is looked up in the ARN's region, with the awscfg config of
+// its name.
+func lookupQueue(ctx context.Context, ref string) (*subscribedQueue, error) {
+	name, owner, region, arn := "", "", "", ""
+	if strings.HasPrefix(ref, "arn:") {
+		// arn:<partition>:sqs:<region>:<account>:<name>
+		parts := strings.Split(ref, ":")
+		if len(parts) != 6 || parts[2] != "sqs" || parts[3] == "" {
+			return nil, fmt.Errorf("sns: %q is not an SQS queue ARN", ref)
+		}
+		name, region, owner, arn = parts[5], parts[3], parts[4], ref
+	} else {
+		u, err := url.Parse(ref)
+		if err != nil || u.Scheme != sqsScheme || u.Host == "" {
+			return nil, fmt.Errorf("sns: %q is neither a queue ARN nor an %s:// URL", ref, sqsScheme)
+		}
+		name, owner = u.Host, strings.Trim(u.Path, "/")
+	}
+	client, err := resolveQueueClient(&url.URL{Scheme: sqsScheme, Host: name}, region)
+	if err != nil {
+		return nil, err
+	}
+	input := &sqs.GetQueueUrlInput{QueueName: &name}
+	if owner != "" {
+		input.QueueOwnerAWSAccountId = &owner
+	}
+	out, err := client.GetQueueUrl(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("sns: failed to get queue URL for %q: %w", name, err)
+	}
+	q := &subscribedQueue{client: client, queueURL: safeDeref(out.QueueUrl), arn: arn}
+	if q.arn == "" {
+		attrs, err := q.attributes(ctx, sqstypes.QueueAttributeNameQueueArn)
+		if err != nil {
+			return nil, err
+		}
+		q.arn = attrs[string(sqstypes.QueueAttributeNameQueueArn)]
+	}
+	return q, nil
+}
+
+func (q *subscribedQueue) attributes(ctx context.Context, names ...sqstypes.QueueAttributeName) (map[string]string, error) {
+	out, err := q.client.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{QueueUrl: &q.queueURL, AttributeNames: names})
+	if err != nil {
+		return nil, fmt.Errorf("sns: failed to get attributes of queue %s: %w", q.queueURL, err)
+	}
+	return out.Attributes, nil
+}
+
+// allowTopic adds a statement that lets the topic send messages to the
+// queue to the queue's access policy, keeping the statements already in
+// it. It reports whether the policy changed.
+func (q *subscribedQueue) allowTopic(ctx context.Context, topicArn string) (bool, error) {
+	attrs, err := q.attributes(ctx, sqstypes.QueueAttributeNamePolicy)
+	if err != nil {
+		return false, err
+	}
+	policy := map[string]any{"Version": "2012-10-17"}
+	if raw := attrs[string(sqstypes.QueueAttributeNamePolicy)]; raw != "" {
+		if err := json.Unmarshal([]byte(raw), &policy); err != nil {
+			return false, fmt.Errorf("sns: policy of queue %s is not valid JSON: %w", q.arn, err)
+		}
+	}
+	var statements []any
+	switch s := policy["Statement"].(type) {
+	case []any:
+		statements = s
+	case map[string]any:
+		statements = []any{s}
+	}
+
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(topicArn))
+	sid := fmt.Sprintf("AllowSNSTopic%x", h.Sum64())
+	var want any
+	raw, _ := json.Marshal(map[string]any{
+		"Sid":       sid,
+		"Effect":    "Allow",
+		"Principal": map[string]any{"Service": "sns.amazonaws.com"},
+		"Action":    "sqs:SendMessage",
+		"Resource":  q.arn,
+		"Condition": map[string]any{"ArnEquals": map[string]any{"aws:SourceArn": topicArn}},
+	})
+	_ = json.Unmarshal(raw, &want)
+
+	kept := statements[:0:0]
+	for _, s := range statements {
+		if m, ok := s.(map[string]any); ok && m["Sid"] == sid {
+			if reflect.DeepEqual(m, want) {
+				return false, nil
+			}
+			continue
+		}
+		kept = append(kept, s)
+	}
+	policy["Statement"] = append(kept, want)
+	updated, _ := json.Marshal(policy)
+	_, err = q.client.SetQueueAttributes(ctx, &sqs.SetQueueAttributesInput{
+		QueueUrl:   &q.queueURL,
+		Attributes: map[string]string{string(sqstypes.QueueAttributeNamePolicy): string(updated)},
+	})
+	if err != nil {
+		return false, fmt.Errorf("sns: failed to set policy of queue %s: %w", q.arn, err)
+	}
+	return true, nil
+}
+
+// isFIFOQueueRef reports whether an sqs:// URL or queue ARN names a FIFO
+// queue.
+func isFIFOQueueRef(ref string) bool {
+	if u, err := url.Parse(ref); err == nil && u.Scheme == sqsScheme {
+		return strings.HasSuffix(u.Host, ".fifo")
+	}
+	return strings.HasSuffix(ref, ".fifo")
+}
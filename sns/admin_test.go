@@ -0,0 +1,454 @@
+package sns
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awssns "github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+	awssqs "github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const testARNPrefix = "arn:aws:sns:us-east-1:123456789012:"
+
+// fakeAdminClient keeps topics and subscriptions in memory, the way SNS
+// reports them back. Email and HTTP(S) subscriptions stay pending.
+type fakeAdminClient struct {
+	mu         sync.Mutex
+	topics     map[string]map[string]string
+	protection map[string]string
+	subs       map[string]*fakeSubscription
+	creates    []*awssns.CreateTopicInput
+	setCalls   []string
+	subscribes []*awssns.SubscribeInput
+}
+
+type fakeSubscription struct {
+	info  types.Subscription
+	attrs map[string]string
+}
+
+func newFakeAdminClient() *fakeAdminClient {
+	return &fakeAdminClient{
+		topics:     map[string]map[string]string{},
+		protection: map[string]string{},
+		subs:       map[string]*fakeSubscription{},
+	}
+}
+
+func (f *fakeAdminClient) CreateTopic(ctx context.Context, in *awssns.CreateTopicInput, _ ...func(*awssns.Options)) (*awssns.CreateTopicOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.creates = append(f.creates, in)
+	arn := testARNPrefix + *in.Name
+	attrs := map[string]string{"TopicArn": arn}
+	for k, v := range in.Attributes {
+		attrs[k] = v
+	}
+	f.topics[arn] = attrs
+	if in.DataProtectionPolicy != nil {
+		f.protection[arn] = *in.DataProtectionPolicy
+	}
+	return &awssns.CreateTopicOutput{TopicArn: &arn}, nil
+}
+
+func (f *fakeAdminClient) GetTopicAttributes(ctx context.Context, in *awssns.GetTopicAttributesInput, _ ...func(*awssns.Options)) (*awssns.GetTopicAttributesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	attrs, ok := f.topics[*in.TopicArn]
+	if !ok {
+		return nil, &types.NotFoundException{Message: aws.String("Topic does not exist")}
+	}
+	out := make(map[string]string)
+	for k, v := range attrs {
+		out[k] = v
+	}
+	return &awssns.GetTopicAttributesOutput{Attributes: out}, nil
+}
+
+func (f *fakeAdminClient) ListTopics(ctx context.Context, in *awssns.ListTopicsInput, _ ...func(*awssns.Options)) (*awssns.ListTopicsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := &awssns.ListTopicsOutput{}
+	for arn := range f.topics {
+		out.Topics = append(out.Topics, types.Topic{TopicArn: aws.String(arn)})
+	}
+	return out, nil
+}
+
+func (f *fakeAdminClient) SetTopicAttributes(ctx context.Context, in *awssns.SetTopicAttributesInput, _ ...func(*awssns.Options)) (*awssns.SetTopicAttributesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.setCalls = append(f.setCalls, *in.AttributeName)
+	f.topics[*in.TopicArn][*in.AttributeName] = *in.AttributeValue
+	return &awssns.SetTopicAttributesOutput{}, nil
+}
+
+func (f *fakeAdminClient) DeleteTopic(ctx context.Context, in *awssns.DeleteTopicInput, _ ...func(*awssns.Options)) (*awssns.DeleteTopicOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.topics, *in.TopicArn)
+	for arn, s := range f.subs {
+		if *s.info.TopicArn == *in.TopicArn {
+			delete(f.subs, arn)
+		}
+	}
+	return &awssns.DeleteTopicOutput{}, nil
+}
+
+func (f *fakeAdminClient) GetDataProtectionPolicy(ctx context.Context, in *awssns.GetDataProtectionPolicyInput, _ ...func(*awssns.Options)) (*awssns.GetDataProtectionPolicyOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &awssns.GetDataProtectionPolicyOutput{DataProtectionPolicy: aws.String(f.protection[*in.ResourceArn])}, nil
+}
+
+func (f *fakeAdminClient) PutDataProtectionPolicy(ctx context.Context, in *awssns.PutDataProtectionPolicyInput, _ ...func(*awssns.Options)) (*awssns.PutDataProtectionPolicyOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.setCalls = append(f.setCalls, "DataProtectionPolicy")
+	f.protection[*in.ResourceArn] = *in.DataProtectionPolicy
+	return &awssns.PutDataProtectionPolicyOutput{}, nil
+}
+
+func (f *fakeAdminClient) Subscribe(ctx context.Context, in *awssns.SubscribeInput, _ ...func(*awssns.Options)) (*awssns.SubscribeOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.subscribes = append(f.subscribes, in)
+	arn := *in.TopicArn + ":" + *in.Protocol + "-" + *in.Endpoint
+	listed := arn
+	switch *in.Protocol {
+	case "email", "email-json", "http", "https":
+		listed = "PendingConfirmation"
+	}
+	attrs := map[string]string{}
+	for k, v := range in.Attributes {
+		attrs[k] = v
+	}
+	f.subs[arn] = &fakeSubscription{
+		info: types.Subscription{
+			SubscriptionArn: aws.String(listed),
+			TopicArn:        in.TopicArn,
+			Protocol:        in.Protocol,
+			Endpoint:        in.Endpoint,
+			Owner:           aws.String("123456789012"),
+		},
+		attrs: attrs,
+	}
+	return &awssns.SubscribeOutput{SubscriptionArn: &arn}, nil
+}
+
+func (f *fakeAdminClient) Unsubscribe(ctx context.Context, in *awssns.UnsubscribeInput, _ ...func(*awssns.Options)) (*awssns.UnsubscribeOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.subs, *in.SubscriptionArn)
+	return &awssns.UnsubscribeOutput{}, nil
+}
+
+func (f *fakeAdminClient) ListSubscriptionsByTopic(ctx context.Context, in *awssns.ListSubscriptionsByTopicInput, _ ...func(*awssns.Options)) (*awssns.ListSubscriptionsByTopicOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := &awssns.ListSubscriptionsByTopicOutput{}
+	for _, s := range f.subs {
+		if *s.info.TopicArn == *in.TopicArn {
+			out.Subscriptions = append(out.Subscriptions, s.info)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeAdminClient) GetSubscriptionAttributes(ctx context.Context, in *awssns.GetSubscriptionAttributesInput, _ ...func(*awssns.Options)) (*awssns.GetSubscriptionAttributesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	attrs := map[string]string{}
+	for k, v := range f.subs[*in.SubscriptionArn].attrs {
+		attrs[k] = v
+	}
+	return &awssns.GetSubscriptionAttributesOutput{Attributes: attrs}, nil
+}
+
+func (f *fakeAdminClient) SetSubscriptionAttributes(ctx context.Context, in *awssns.SetSubscriptionAttributesInput, _ ...func(*awssns.Options)) (*awssns.SetSubscriptionAttributesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.setCalls = append(f.setCalls, *in.AttributeName)
+	f.subs[*in.SubscriptionArn].attrs[*in.AttributeName] = *in.AttributeValue
+	return &awssns.SetSubscriptionAttributesOutput{}, nil
+}
+
+// fakeQueueClient keeps the access policies of existing queues.
+type fakeQueueClient struct {
+	mu       sync.Mutex
+	policies map[string]string
+	setCalls int
+}
+
+func (f *fakeQueueClient) GetQueueUrl(ctx context.Context, in *awssqs.GetQueueUrlInput, _ ...func(*awssqs.Options)) (*awssqs.GetQueueUrlOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.policies[*in.QueueName]; !ok {
+		return nil, &sqstypes.QueueDoesNotExist{Message: aws.String("no such queue")}
+	}
+	return &awssqs.GetQueueUrlOutput{QueueUrl: aws.String("http://fake/123456789012/" + *in.QueueName)}, nil
+}
+
+func (f *fakeQueueClient) GetQueueAttributes(ctx context.Context, in *awssqs.GetQueueAttributesInput, _ ...func(*awssqs.Options)) (*awssqs.GetQueueAttributesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := (*in.QueueUrl)[strings.LastIndex(*in.QueueUrl, "/")+1:]
+	attrs := map[string]string{"QueueArn": "arn:aws:sqs:us-east-1:123456789012:" + name}
+	if p := f.policies[name]; p != "" {
+		attrs["Policy"] = p
+	}
+	return &awssqs.GetQueueAttributesOutput{Attributes: attrs}, nil
+}
+
+func (f *fakeQueueClient) SetQueueAttributes(ctx context.Context, in *awssqs.SetQueueAttributesInput, _ ...func(*awssqs.Options)) (*awssqs.SetQueueAttributesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.setCalls++
+	f.policies[(*in.QueueUrl)[strings.LastIndex(*in.QueueUrl, "/")+1:]] = in.Attributes["Policy"]
+	return &awssqs.SetQueueAttributesOutput{}, nil
+}
+
+func withFakeAdmin(t *testing.T, client snsAdminAPI, queues QueueAPI) {
+	t.Helper()
+	prevAdmin, prevQueue, prevPrefix := resolveAdminClient, resolveQueueClient, topicARNPrefix
+	resolveAdminClient = func(*url.URL) (snsAdminAPI, error) { return client, nil }
+	resolveQueueClient = func(*url.URL, string) (QueueAPI, error) { return queues, nil }
+	topicARNPrefix = func(context.Context, *url.URL) (string, error) { return testARNPrefix, nil }
+	t.Cleanup(func() {
+		resolveAdminClient, resolveQueueClient, topicARNPrefix = prevAdmin, prevQueue, prevPrefix
+		topicARNsMu.Lock()
+		clear(topicARNs)
+		topicARNsMu.Unlock()
+	})
+}
+
+func TestAdmin_EnsureTopicCreatesThenReconciles(t *testing.T) {
+	fake := newFakeAdminClient()
+	withFakeAdmin(t, fake, nil)
+	ctx := context.Background()
+	u, _ := url.Parse("sns://orders.fifo")
+	cfg := &TopicConfig{
+		KMSKeyId:                  "alias/aws/sns",
+		ContentBasedDeduplication: aws.Bool(true),
+		DataProtectionPolicy:      `{"Name":"p","Version":"2021-06-01","Statement":[]}`,
+	}
+
+	if exists, err := Admin().TopicExists(ctx, u); err != nil || exists {
+		t.Fatalf("TopicExists before EnsureTopic = %v, %v", exists, err)
+	}
+	changed, err := Admin().EnsureTopic(ctx, u, cfg)
+	if err != nil || !changed {
+		t.Fatalf("first EnsureTopic = %v, %v", changed, err)
+	}
+	attrs := fake.creates[0].Attributes
+	if attrs["FifoTopic"] != "true" || attrs["ContentBasedDeduplication"] != "true" || attrs["KmsMasterKeyId"] != "alias/aws/sns" {
+		t.Fatalf("CreateTopic attributes = %v", attrs)
+	}
+	if fake.creates[0].DataProtectionPolicy == nil {
+		t.Fatalf("CreateTopic without data protection policy")
+	}
+
+	// Same content, other formatting: nothing to do.
+	cfg.DataProtectionPolicy = `{"Version": "2021-06-01", "Name": "p", "Statement": []}`
+	changed, err = Admin().EnsureTopic(ctx, u, cfg)
+	if err != nil || changed || len(fake.setCalls) != 0 {
+		t.Fatalf("second EnsureTopic = %v, %v (sets %v)", changed, err, fake.setCalls)
+	}
+
+	cfg.DisplayName = "Orders"
+	changed, err = Admin().EnsureTopic(ctx, u, cfg)
+	if err != nil || !changed || len(fake.setCalls) != 1 || fake.setCalls[0] != "DisplayName" {
+		t.Fatalf("third EnsureTopic = %v, %v (sets %v)", changed, err, fake.setCalls)
+	}
+
+	got, err := Admin().GetTopicConfig(ctx, u)
+	if err != nil || got.DisplayName != "Orders" || !*got.ContentBasedDeduplication || got.DataProtectionPolicy == "" {
+		t.Fatalf("GetTopicConfig = %+v, %v", got, err)
+	}
+	if arn, err := Admin().TopicArn(ctx, u); err != nil || arn != testARNPrefix+"orders.fifo" {
+		t.Fatalf("TopicArn = %q, %v", arn, err)
+	}
+}
+
+func TestAdmin_SubscribeQueueAppliesPolicy(t *testing.T) {
+	fake := newFakeAdminClient()
+	queues := &fakeQueueClient{policies: map[string]string{
+		"orders-q":   `{"Version":"2012-10-17","Statement":{"Sid":"Existing","Effect":"Allow","Principal":"*","Action":"sqs:ReceiveMessage"}}`,
+		"orders-dlq": "",
+	}}
+	withFakeAdmin(t, fake, queues)
+	ctx := context.Background()
+	u, _ := url.Parse("sns://orders")
+	if _, err := Admin().EnsureTopic(ctx, u, nil); err != nil {
+		t.Fatalf("EnsureTopic: %v", err)
+	}
+	sub := &Subscription{
+		Endpoint:           "sqs://orders-q",
+		FilterPolicy:       `{"type":["order"]}`,
+		FilterPolicyScope:  FilterOnBody,
+		RawMessageDelivery: aws.Bool(true),
+		DeadLetter:         "sqs://orders-dlq",
+	}
+
+	arn, changed, err := Admin().Subscribe(ctx, u, sub)
+	if err != nil || !changed || arn == "" {
+		t.Fatalf("first Subscribe = %q, %v, %v", arn, changed, err)
+	}
+	in := fake.subscribes[0]
+	if *in.Protocol != "sqs" || *in.Endpoint != "arn:aws:sqs:us-east-1:123456789012:orders-q" || !in.ReturnSubscriptionArn {
+		t.Fatalf("Subscribe input = %s %s", *in.Protocol, *in.Endpoint)
+	}
+	if in.Attributes["FilterPolicyScope"] != "MessageBody" || in.Attributes["RawMessageDelivery"] != "true" ||
+		!strings.Contains(in.Attributes["RedrivePolicy"], "orders-dlq") {
+		t.Fatalf("Subscribe attributes = %v", in.Attributes)
+	}
+	for _, q := range []string{"orders-q", "orders-dlq"} {
+		var policy struct{ Statement []map[string]any }
+		if err := json.Unmarshal([]byte(queues.policies[q]), &policy); err != nil {
+			t.Fatalf("%s policy: %v", q, err)
+		}
+		last := policy.Statement[len(policy.Statement)-1]
+		cond, _ := json.Marshal(last["Condition"])
+		if last["Action"] != "sqs:SendMessage" || !strings.Contains(string(cond), testARNPrefix+"orders") {
+			t.Fatalf("%s policy = %s", q, queues.policies[q])
+		}
+	}
+	if !strings.Contains(queues.policies["orders-q"], `"Existing"`) {
+		t.Fatalf("existing statement dropped: %s", queues.policies["orders-q"])
+	}
+
+	// Reformatted filter policy: nothing to do.
+	sub.FilterPolicy = `{ "type": [ "order" ] }`
+	arn2, changed, err := Admin().Subscribe(ctx, u, sub)
+	if err != nil || changed || arn2 != arn || len(fake.subscribes) != 1 || queues.setCalls != 2 || len(fake.setCalls) != 0 {
+		t.Fatalf("second Subscribe = %q, %v, %v (%d subscribes, %d policy sets, sets %v)",
+			arn2, changed, err, len(fake.subscribes), queues.setCalls, fake.setCalls)
+	}
+
+	sub.FilterPolicy = `{"type":["refund"]}`
+	if _, changed, err = Admin().Subscribe(ctx, u, sub); err != nil || !changed || len(fake.setCalls) != 1 || fake.setCalls[0] != "FilterPolicy" {
+		t.Fatalf("third Subscribe = %v, %v (sets %v)", changed, err, fake.setCalls)
+	}
+}
+
+func TestAdmin_SubscriptionsOfOtherProtocols(t *testing.T) {
+	fake := newFakeAdminClient()
+	withFakeAdmin(t, fake, &fakeQueueClient{policies: map[string]string{"audit": ""}})
+	ctx := context.Background()
+	u, _ := url.Parse("sns://orders")
+	if _, err := Admin().EnsureTopic(ctx, u, nil); err != nil {
+		t.Fatalf("EnsureTopic: %v", err)
+	}
+
+	for _, sub := range []*Subscription{
+		{Endpoint: "https://hooks.example.com/orders"},
+		{Endpoint: "arn:aws:lambda:us-east-1:123456789012:function:fulfil"},
+		{Endpoint: "ops@example.com", Protocol: ProtocolEmailJSON},
+		{Endpoint: "arn:aws:sqs:us-east-1:123456789012:audit", SkipQueuePolicy: true},
+	} {
+		if _, _, err := Admin().Subscribe(ctx, u, sub); err != nil {
+			t.Fatalf("Subscribe(%s): %v", sub.Endpoint, err)
+		}
+	}
+	subs, err := Admin().ListSubscriptions(ctx, u)
+	if err != nil || len(subs) != 4 {
+		t.Fatalf("ListSubscriptions = %d, %v", len(subs), err)
+	}
+	protocols := map[string]SubscriptionProtocol{}
+	pending := 0
+	for _, s := range subs {
+		protocols[s.Endpoint] = s.Protocol
+		if s.Pending {
+			pending++
+		}
+	}
+	if protocols["https://hooks.example.com/orders"] != ProtocolHTTPS || protocols["ops@example.com"] != ProtocolEmailJSON ||
+		protocols["arn:aws:lambda:us-east-1:123456789012:function:fulfil"] != ProtocolLambda || pending != 2 {
+		t.Fatalf("subscriptions = %v (%d pending)", protocols, pending)
+	}
+
+	if removed, err := Admin().Unsubscribe(ctx, u, "sqs://audit"); err != nil || !removed {
+		t.Fatalf("Unsubscribe(sqs://audit) = %v, %v", removed, err)
+	}
+	if removed, err := Admin().Unsubscribe(ctx, u, "sqs://audit"); err != nil || removed {
+		t.Fatalf("second Unsubscribe = %v, %v", removed, err)
+	}
+
+	if deleted, err := Admin().DeleteTopic(ctx, u); err != nil || !deleted {
+		t.Fatalf("DeleteTopic = %v, %v", deleted, err)
+	}
+	if deleted, err := Admin().DeleteTopic(ctx, u); err != nil || deleted {
+		t.Fatalf("second DeleteTopic = %v, %v", deleted, err)
+	}
+	if _, _, err := Admin().Subscribe(ctx, u, &Subscription{Endpoint: "ops@example.com"}); err == nil {
+		t.Fatalf("Subscribe to a deleted topic succeeded")
+	}
+}
+
+func TestLookupQueue_ARNRegion(t *testing.T) {
+	queues := &fakeQueueClient{policies: map[string]string{"audit": ""}}
+	withFakeAdmin(t, newFakeAdminClient(), queues)
+	var regions []string
+	resolveQueueClient = func(u *url.URL, region string) (QueueAPI, error) {
+		if u.Host != "audit" {
+			t.Errorf("queue client resolved for %s", u)
+		}
+		regions = append(regions, region)
+		return queues, nil
+	}
+	ctx := context.Background()
+	for _, ref := range []string{"arn:aws:sqs:eu-west-1:123456789012:audit", "sqs://audit"} {
+		if _, err := lookupQueue(ctx, ref); err != nil {
+			t.Fatalf("lookupQueue(%s): %v", ref, err)
+		}
+	}
+	if want := []string{"eu-west-1", ""}; !slices.Equal(regions, want) {
+		t.Fatalf("queue client regions = %q, want %q", regions, want)
+	}
+	if _, err := lookupQueue(ctx, "arn:aws:sqs::123456789012:audit"); err == nil {
+		t.Fatalf("expected an error for an ARN without a region")
+	}
+
+	client, err := getSQSClient(&url.URL{Scheme: sqsScheme, Host: "audit"}, "eu-west-1")
+	if err != nil {
+		t.Fatalf("getSQSClient: %v", err)
+	}
+	if got := client.Options().Region; got != "eu-west-1" {
+		t.Fatalf("client region = %q, want eu-west-1", got)
+	}
+}
+
+func TestAdmin_Validation(t *testing.T) {
+	withFakeAdmin(t, newFakeAdminClient(), nil)
+	ctx := context.Background()
+	std, _ := url.Parse("sns://orders")
+	for name, cfg := range map[string]*TopicConfig{
+		"dedup on standard topic": {ContentBasedDeduplication: aws.Bool(true)},
+		"bad data protection":     {DataProtectionPolicy: "{"},
+	} {
+		if _, err := Admin().EnsureTopic(ctx, std, cfg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	for name, sub := range map[string]*Subscription{
+		"no endpoint":             {},
+		"unknown endpoint":        {Endpoint: "sms-ish"},
+		"bad filter policy":       {Endpoint: "sqs://q", FilterPolicy: "{"},
+		"bad filter scope":        {Endpoint: "sqs://q", FilterPolicyScope: "Headers"},
+		"raw delivery to email":   {Endpoint: "ops@example.com", RawMessageDelivery: aws.Bool(true)},
+		"FIFO DLQ of std topic":   {Endpoint: "sqs://q", DeadLetter: "sqs://q-dlq.fifo"},
+		"subscription to missing": {Endpoint: "sqs://q"},
+	} {
+		if _, _, err := Admin().Subscribe(ctx, std, sub); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
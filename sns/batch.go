@@ -0,0 +1,179 @@
+package sns
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"oss.nandlabs.io/golly-aws/internal/batchsend"
+	"oss.nandlabs.io/golly-aws/ratelimit"
+	"oss.nandlabs.io/golly/messaging"
+)
+
+const (
+	// OptBatchRetries is the number of times SendBatch resends entries that
+	// failed for a retriable reason (throttling, internal errors). Default: 3.
+	OptBatchRetries = "BatchRetries"
+
+	defaultBatchRetries = 3
+)
+
+// batchRetryBackoff is the delay before the first resend of failed batch
+// entries; it doubles on every further attempt. A var so tests can shorten it.
+var batchRetryBackoff = 100 * time.Millisecond
+
+// retriableEntryCodes are PublishBatch entry error codes worth resending
+// even when SNS reports them as the sender's fault.
+var retriableEntryCodes = map[string]bool{
+	"Throttling":             true,
+	"Throttled":              true,
+	"ThrottlingException":    true,
+	"KMSThrottling":          true,
+	"KMSThrottlingException": true,
+	"InternalError":          true,
+	"InternalFailure":        true,
+	"ServiceUnavailable":     true,
+}
+
+// PublishResult is the outcome of publishing one message of a batch.
+type PublishResult struct {
+	// Index is the position of the message in the slice passed to
+	// SendBatchResults.
+	Index   int
+	Message messaging.Message
+	// MessageId is the ID SNS assigned to the message, and SequenceNumber
+	// its sequence number on a FIFO topic. Both are empty when Err is set.
+	MessageId      string
+	SequenceNumber string
+	// Err is the reason the message was not published; a *BatchEntryError
+	// when SNS rejected the entry.
+	Err error
+	// Attempts is the number of times the entry was sent.
+	Attempts int
+}
+
+// BatchEntryError describes one message SendBatch could not publish: its
+// Index in the slice passed to SendBatch, the Message, the Code, Reason and
+// SenderFault SNS reported and the number of Attempts. Err is the cause
+// when SNS never reported on the entry: the failed request of a resend or
+// the end of ctx while waiting to resend. Code is empty then, and Unwrap
+// returns Err.
+type BatchEntryError = batchsend.EntryError
+
+// BatchError is returned by SendBatch when some messages were published and
+// others were not. Failed is in input order; every message not listed there
+// was published.
+type BatchError = batchsend.Error
+
+// batchService labels the errors of SendBatch.
+var batchService = batchsend.Service{Name: "sns", Action: "publish"}
+
+// isRetriableEntry reports whether a rejected batch entry is worth
+// resending.
+func isRetriableEntry(r batchsend.Rejection) bool {
+	return !r.SenderFault || retriableEntryCodes[r.Code]
+}
+
+// entryGroups returns the message group of each entry, empty for none.
+func entryGroups(entries []types.PublishBatchRequestEntry) []string {
+	groups := make([]string, len(entries))
+	for j, e := range entries {
+		groups[j] = safeDeref(e.MessageGroupId)
+	}
+	return groups
+}
+
+// publishChunk publishes one PublishBatch request of up to ten entries,
+// resending entries that fail for a retriable reason up to retries times
+// and pacing every attempt with the topic's rate limiter, and fills in
+// results[j] for batch[j], the message of entries[j]. Message IDs and
+// sequence numbers are stored on published MessageSNS messages, and
+// OnSend fires once per message with its final outcome. A request-level
+// failure of the first attempt is returned as an error (nothing was
+// published), and left to the caller to report; everything else is
+// reported per entry.
+func (p *Provider) publishChunk(ctx context.Context, client publishAPI, u *url.URL, topicARN string,
+	batch []messaging.Message, entries []types.PublishBatchRequestEntry, retries int, results []*PublishResult) error {
+	index := make(map[string]int, len(entries))
+	for j, e := range entries {
+		index[*e.Id] = j
+	}
+	start := time.Now()
+	chunk := &batchsend.Chunk[types.PublishBatchRequestEntry]{
+		Service:   batchService,
+		Offset:    results[0].Index,
+		Messages:  batch,
+		Entries:   entries,
+		ID:        func(e types.PublishBatchRequestEntry) string { return *e.Id },
+		Retriable: isRetriableEntry,
+		Retries:   retries,
+		Backoff:   batchRetryBackoff,
+		OnResend: func(n int) {
+			logger.WarnF("SNS batch publish to %s: resending %d throttled or failed entries", topicARN, n)
+		},
+		Send: func(ctx context.Context, pending []types.PublishBatchRequestEntry) ([]batchsend.Rejection, error) {
+			limiter, err := p.pace(ctx, u, topicARN, entryGroups(pending))
+			if err != nil {
+				return nil, err
+			}
+			output, err := client.PublishBatch(ctx, &sns.PublishBatchInput{
+				TopicArn:                   &topicARN,
+				PublishBatchRequestEntries: pending,
+			})
+			if err != nil {
+				if isTopicMissing(err) {
+					forgetTopicARN(u)
+				}
+				if ratelimit.IsThrottle(err) {
+					p.throttled(u, limiter)
+				}
+				return nil, fmt.Errorf("sns: batch publish failed: %w", err)
+			}
+			for _, s := range output.Successful {
+				j, ok := index[safeDeref(s.Id)]
+				if !ok {
+					continue
+				}
+				results[j].MessageId = safeDeref(s.MessageId)
+				results[j].SequenceNumber = safeDeref(s.SequenceNumber)
+				if snsMsg, ok := batch[j].(*MessageSNS); ok {
+					snsMsg.messageId = results[j].MessageId
+					snsMsg.sequenceNumber = results[j].SequenceNumber
+				}
+			}
+			rejected := make([]batchsend.Rejection, 0, len(output.Failed))
+			throttled := false
+			for _, f := range output.Failed {
+				throttled = throttled || ratelimit.IsThrottleCode(safeDeref(f.Code))
+				rejected = append(rejected, batchsend.Rejection{
+					ID:          safeDeref(f.Id),
+					Code:        safeDeref(f.Code),
+					Reason:      safeDeref(f.Message),
+					SenderFault: f.SenderFault,
+				})
+			}
+			if throttled {
+				p.throttled(u, limiter)
+			}
+			return rejected, nil
+		},
+	}
+	attempts, errs, err := chunk.Run(ctx)
+	if err != nil {
+		return err
+	}
+
+	for j, m := range batch {
+		results[j].Attempts = attempts[j]
+		var err error
+		if errs[j] != nil {
+			results[j].Err = errs[j]
+			err = errs[j]
+		}
+		p.fireOnSend(u, m, err, start)
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package sns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"oss.nandlabs.io/golly-aws/bodycodec"
+	"oss.nandlabs.io/golly/messaging"
+)
+
+// fakeBatchClient answers PublishBatch with batchFn and records the calls.
+// Topic ARN URLs need none of the other methods.
+type fakeBatchClient struct {
+	publishAPI
+	batchFn    func(in *sns.PublishBatchInput) (*sns.PublishBatchOutput, error)
+	batchCalls []*sns.PublishBatchInput
+}
+
+func (c *fakeBatchClient) PublishBatch(_ context.Context, in *sns.PublishBatchInput, _ ...func(*sns.Options)) (*sns.PublishBatchOutput, error) {
+	c.batchCalls = append(c.batchCalls, in)
+	return c.batchFn(in)
+}
+
+func withFakeBatchClient(t *testing.T, client *fakeBatchClient) {
+	t.Helper()
+	prevClient, prevBackoff := resolvePublishClient, batchRetryBackoff
+	resolvePublishClient = func(*url.URL) (publishAPI, error) { return client, nil }
+	batchRetryBackoff = 0
+	t.Cleanup(func() { resolvePublishClient, batchRetryBackoff = prevClient, prevBackoff })
+}
+
+// sendRecord is one OnSend call.
+type sendRecord struct {
+	msg messaging.Message
+	err error
+}
+
+// sendsObserver records every OnSend call.
+type sendsObserver struct {
+	recordingObserver
+	mu    sync.Mutex
+	sends []sendRecord
+}
+
+func (o *sendsObserver) OnSend(_ *url.URL, msg messaging.Message, err error, _ time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.sends = append(o.sends, sendRecord{msg: msg, err: err})
+}
+
+func newBatchMessages(t *testing.T, p *Provider, n int) []messaging.Message {
+	t.Helper()
+	msgs := make([]messaging.Message, n)
+	for i := range msgs {
+		msg, err := p.NewMessage(SNSScheme)
+		if err != nil {
+			t.Fatalf("NewMessage: %v", err)
+		}
+		_, _ = msg.SetBodyStr(fmt.Sprintf("m%d", i))
+		msgs[i] = msg
+	}
+	return msgs
+}
+
+func TestSendBatchCtx_PartialFailureAndRetry(t *testing.T) {
+	client := &fakeBatchClient{}
+	client.batchFn = func(in *sns.PublishBatchInput) (*sns.PublishBatchOutput, error) {
+		out := &sns.PublishBatchOutput{}
+		for _, e := range in.PublishBatchRequestEntries {
+			switch {
+			case *e.Id == "msg-1" && len(client.batchCalls) == 1:
+				out.Failed = append(out.Failed, types.BatchResultErrorEntry{
+					Id: e.Id, Code: strPtr("Throttling"), Message: strPtr("Rate exceeded"), SenderFault: true,
+				})
+			case *e.Id == "msg-2" && len(client.batchCalls) <= 2:
+				out.Failed = append(out.Failed, types.BatchResultErrorEntry{
+					Id: e.Id, Code: strPtr("KMSThrottling"), Message: strPtr("KMS rate exceeded"), SenderFault: true,
+				})
+			case *e.Id == "msg-3":
+				out.Failed = append(out.Failed, types.BatchResultErrorEntry{
+					Id: e.Id, Code: strPtr("InvalidParameter"), Message: strPtr("bad attribute"), SenderFault: true,
+				})
+			default:
+				out.Successful = append(out.Successful, types.PublishBatchResultEntry{
+					Id: e.Id, MessageId: strPtr("id-" + *e.Id), SequenceNumber: strPtr("seq-" + *e.Id),
+				})
+			}
+		}
+		return out, nil
+	}
+	withFakeBatchClient(t, client)
+	p := &Provider{}
+	obs := &sendsObserver{}
+	p.SetObserver(obs)
+	u, _ := url.Parse("sns:///arn:aws:sns:us-east-1:123456789012:orders.fifo")
+	msgs := newBatchMessages(t, p, 4)
+
+	err := p.SendBatchCtx(context.Background(), u, msgs)
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("expected *BatchError, got %v", err)
+	}
+	if len(batchErr.Failed) != 1 || batchErr.Total != 4 {
+		t.Fatalf("failed = %d of %d", len(batchErr.Failed), batchErr.Total)
+	}
+	f := batchErr.Failed[0]
+	if f.Index != 3 || f.Message != msgs[3] || f.Code != "InvalidParameter" || f.Attempts != 1 || !f.SenderFault {
+		t.Fatalf("failure = %+v", f)
+	}
+	if len(client.batchCalls) != 3 {
+		t.Fatalf("expected 3 PublishBatch calls, got %d", len(client.batchCalls))
+	}
+	if retried := client.batchCalls[2].PublishBatchRequestEntries; len(retried) != 1 || *retried[0].Id != "msg-2" {
+		t.Fatalf("last retried entries = %v", retried)
+	}
+
+	for i, m := range msgs[:3] {
+		snsMsg := m.(*MessageSNS)
+		if want := fmt.Sprintf("id-msg-%d", i); snsMsg.SNSMessageId() != want || snsMsg.SequenceNumber() != fmt.Sprintf("seq-msg-%d", i) {
+			t.Errorf("message %d: id %q, sequence %q", i, snsMsg.SNSMessageId(), snsMsg.SequenceNumber())
+		}
+	}
+	if id := msgs[3].(*MessageSNS).SNSMessageId(); id != "" {
+		t.Errorf("failed message got id %q", id)
+	}
+
+	if len(obs.sends) != 4 {
+		t.Fatalf("expected one OnSend per message, got %d", len(obs.sends))
+	}
+	for _, s := range obs.sends {
+		if (s.msg == msgs[3]) != (s.err != nil) {
+			t.Errorf("OnSend for %s: err = %v", s.msg.ReadAsStr(), s.err)
+		}
+	}
+}
+
+func TestSendBatchResults(t *testing.T) {
+	client := &fakeBatchClient{}
+	client.batchFn = func(in *sns.PublishBatchInput) (*sns.PublishBatchOutput, error) {
+		if len(client.batchCalls) == 2 {
+			return nil, &types.InternalErrorException{Message: strPtr("boom")}
+		}
+		out := &sns.PublishBatchOutput{}
+		for _, e := range in.PublishBatchRequestEntries {
+			if *e.Id == "msg-0" {
+				out.Failed = append(out.Failed, types.BatchResultErrorEntry{
+					Id: e.Id, Code: strPtr("Throttling"), Message: strPtr("Rate exceeded"), SenderFault: true,
+				})
+				continue
+			}
+			out.Successful = append(out.Successful, types.PublishBatchResultEntry{Id: e.Id, MessageId: strPtr("id-" + *e.Id)})
+		}
+		return out, nil
+	}
+	withFakeBatchClient(t, client)
+	p := &Provider{}
+	u, _ := url.Parse("sns:///arn:aws:sns:us-east-1:123456789012:orders")
+	msgs := newBatchMessages(t, p, 12)
+
+	// Without retries the throttled entry fails at once; the request of
+	// the second chunk fails as a whole.
+	opts := messaging.NewOptionsBuilder().Add(OptBatchRetries, 0).Build()
+	results, err := p.SendBatchResults(context.Background(), u, msgs, opts...)
+	if err == nil {
+		t.Fatalf("expected the failed request to be returned")
+	}
+	if len(results) != len(msgs) {
+		t.Fatalf("got %d results for %d messages", len(results), len(msgs))
+	}
+	var entryErr *BatchEntryError
+	if r := results[0]; !errors.As(r.Err, &entryErr) || entryErr.Code != "Throttling" || r.Attempts != 1 {
+		t.Fatalf("result 0 = %+v", r)
+	}
+	for _, r := range results[1:10] {
+		if r.Err != nil || r.MessageId != fmt.Sprintf("id-msg-%d", r.Index) || r.Message != msgs[r.Index] {
+			t.Fatalf("result %d = %+v", r.Index, r)
+		}
+	}
+	for _, r := range results[10:] {
+		if !errors.Is(r.Err, err) || r.MessageId != "" {
+			t.Fatalf("result %d = %+v, want the request error", r.Index, r)
+		}
+	}
+}
+
+// failingCodec encodes bodies unchanged and fails on the body fail.
+type failingCodec struct{ fail string }
+
+func (failingCodec) Name() string { return "sns-test-failing" }
+
+func (c failingCodec) Encode(body []byte) (string, error) {
+	if string(body) == c.fail {
+		return "", errors.New("cannot encode " + c.fail)
+	}
+	return string(body), nil
+}
+
+func (failingCodec) Decode(text string) ([]byte, error) { return []byte(text), nil }
+
+func TestSendBatchResults_EncodeFailureFiresOnSend(t *testing.T) {
+	bodycodec.Register(failingCodec{fail: "m12"})
+	client := &fakeBatchClient{}
+	client.batchFn = func(in *sns.PublishBatchInput) (*sns.PublishBatchOutput, error) {
+		out := &sns.PublishBatchOutput{}
+		for _, e := range in.PublishBatchRequestEntries {
+			out.Successful = append(out.Successful, types.PublishBatchResultEntry{Id: e.Id, MessageId: e.Id})
+		}
+		return out, nil
+	}
+	withFakeBatchClient(t, client)
+	p := &Provider{}
+	obs := &sendsObserver{}
+	p.SetObserver(obs)
+	u, _ := url.Parse("sns:///arn:aws:sns:us-east-1:123456789012:orders")
+	msgs := newBatchMessages(t, p, 15)
+
+	// The first chunk is published; encoding the second one fails.
+	opts := messaging.NewOptionsBuilder().Add(OptBodyEncoding, "sns-test-failing").Build()
+	results, err := p.SendBatchResults(context.Background(), u, msgs, opts...)
+	if err == nil || len(client.batchCalls) != 1 {
+		t.Fatalf("SendBatchResults = %v after %d requests, want the encoding error after 1", err, len(client.batchCalls))
+	}
+	if len(obs.sends) != len(msgs) {
+		t.Fatalf("got %d OnSend calls for %d messages", len(obs.sends), len(msgs))
+	}
+	for i, s := range obs.sends {
+		if s.msg != msgs[i] || (i >= 10) != (s.err != nil) || (s.err != nil && s.err != results[i].Err) {
+			t.Errorf("OnSend %d = %+v, result %+v", i, s, results[i])
+		}
+	}
+}
+
+func TestSendBatchResults_ResendFailureKeepsCause(t *testing.T) {
+	client := &fakeBatchClient{}
+	client.batchFn = func(in *sns.PublishBatchInput) (*sns.PublishBatchOutput, error) {
+		if len(client.batchCalls) > 1 {
+			return nil, &types.InternalErrorException{Message: strPtr("boom")}
+		}
+		return &sns.PublishBatchOutput{
+			Successful: []types.PublishBatchResultEntry{{Id: in.PublishBatchRequestEntries[0].Id}},
+			Failed:     []types.BatchResultErrorEntry{{Id: in.PublishBatchRequestEntries[1].Id, Code: strPtr("Throttling")}},
+		}, nil
+	}
+	withFakeBatchClient(t, client)
+	p := &Provider{}
+	u, _ := url.Parse("sns:///arn:aws:sns:us-east-1:123456789012:orders")
+
+	err := p.SendBatchCtx(context.Background(), u, newBatchMessages(t, p, 2))
+	var internal *types.InternalErrorException
+	var entryErr *BatchEntryError
+	if !errors.As(err, &internal) || !errors.As(err, &entryErr) || entryErr.Index != 1 || entryErr.Attempts != 2 {
+		t.Fatalf("SendBatchCtx = %v, want entry 1 to wrap the failed resend", err)
+	}
+}
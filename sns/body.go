@@ -0,0 +1,37 @@
+package sns
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"oss.nandlabs.io/golly-aws/bodycodec"
+	"oss.nandlabs.io/golly/messaging"
+)
+
+// encodeBody returns the message text to publish msg with and attrs plus
+// the bodycodec.AttrEncoding attribute when the body was encoded.
+func encodeBody(msg messaging.Message, encoding string, attrs map[string]types.MessageAttributeValue) (string, map[string]types.MessageAttributeValue, error) {
+	text, marker, err := bodycodec.Encode(encoding, msg.ReadBytes())
+	if err != nil {
+		return "", nil, err
+	}
+	if marker != "" {
+		if attrs == nil {
+			attrs = make(map[string]types.MessageAttributeValue, 1)
+		}
+		attrs[bodycodec.AttrEncoding] = types.MessageAttributeValue{
+			DataType:    strPtr("String"),
+			StringValue: &marker,
+		}
+	}
+	return text, attrs, nil
+}
+
+// checkStructureEncoding rejects encoded bodies for MessageStructure=json,
+// where SNS must be able to parse the body itself.
+func checkStructureEncoding(structure string, attrs map[string]types.MessageAttributeValue) error {
+	if _, encoded := attrs[bodycodec.AttrEncoding]; encoded && structure == "json" {
+		return fmt.Errorf("sns: %s=json cannot be combined with an encoded body", OptMessageStructure)
+	}
+	return nil
+}
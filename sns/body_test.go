@@ -0,0 +1,45 @@
+package sns
+
+import (
+	"context"
+	"encoding/base64"
+	"net/url"
+	"testing"
+
+	"oss.nandlabs.io/golly-aws/bodycodec"
+	"oss.nandlabs.io/golly/messaging"
+)
+
+func TestSendCtx_BodyEncoding(t *testing.T) {
+	srv := newSNSFakeServer()
+	defer srv.Close()
+	registerFakeSNS(t, "sns", srv.URL)
+
+	p := &Provider{}
+	msg, _ := p.NewMessage(SNSScheme)
+	payload := []byte{0x00, 0xff, 0x10, 0x80}
+	_, _ = msg.SetBodyBytes(payload)
+	u, _ := url.Parse("sns:///arn:aws:sns:us-east-1:123456789012:my-topic")
+	opts := messaging.NewOptionsBuilder().Add(OptBodyEncoding, bodycodec.Base64).Build()
+
+	if err := p.SendCtx(context.Background(), u, msg, opts...); err != nil {
+		t.Fatalf("SendCtx: %v", err)
+	}
+	form := srv.captured()[0].Form
+	if got := form.Get("Message"); got != base64.StdEncoding.EncodeToString(payload) {
+		t.Fatalf("Message = %q", got)
+	}
+	if form.Get("MessageAttributes.entry.1.Name") != bodycodec.AttrEncoding ||
+		form.Get("MessageAttributes.entry.1.Value.StringValue") != bodycodec.Base64 {
+		t.Fatalf("encoding attribute missing: %v", form)
+	}
+
+	// An encoded body cannot be a per-protocol JSON structure.
+	both := messaging.NewOptionsBuilder().
+		Add(OptBodyEncoding, bodycodec.Base64).
+		Add(OptMessageStructure, "json").
+		Build()
+	if err := p.SendCtx(context.Background(), u, msg, both...); err == nil {
+		t.Fatalf("expected an error for MessageStructure=json with an encoded body")
+	}
+}
@@ -1,9 +1,21 @@
 // Package sns implements the golly messaging Provider interface for AWS SNS.
 //
-// SNS is a publish-only service. The Receive, ReceiveBatch, and AddListener
-// methods return an unsupported operation error. For receiving messages
+// Send and SendBatch publish to topics, phone numbers and endpoint ARNs.
+// AddListener receives through an HTTP(S) push subscription: it serves an
+// endpoint that confirms the subscription, verifies the SNS signature of
+// every request and delivers notifications as messages. Receive and
+// ReceiveBatch return an unsupported operation error; to pull messages
 // published via SNS, use the SNS→SQS fan-out pattern with the sqs package.
 //
+// Admin returns a TopicAdmin that creates topics and subscribes endpoints to
+// them idempotently, granting subscribed SQS queues access to the topic.
+// ParseFilterPolicy evaluates subscription filter policies locally. Mobile
+// returns a MobileAdmin that registers platform applications and device
+// endpoints and manages the SMS opt-out list. UseClient routes the provider
+// and admin calls to another client, such as the in-memory Emulator of the
+// snstest package.
+// RateLimits paces publishes to each topic with a ratelimit.Limiter.
+//
 // Import this package with a blank identifier to auto-register the SNS provider:
 //
 //	import _ "oss.nandlabs.io/golly-aws/sns"
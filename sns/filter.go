@@ -0,0 +1,664 @@
+package sns
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"oss.nandlabs.io/golly/messaging"
+)
+
+// FilterPolicy is a parsed SNS subscription filter policy that can be
+// evaluated locally, to test policies and see why a subscriber would or
+// would not receive a message.
+//
+// It implements the SNS filter policy language: exact string, number,
+// boolean and null matching, prefix, suffix, equals-ignore-case,
+// anything-but, numeric ranges, exists, cidr, $or and, in MessageBody
+// scope, nested keys. The size limits SNS puts on policies are not
+// enforced.
+//
+// In MessageAttributes scope the policy keys name message headers:
+//   - string headers are String attributes, and one holding a JSON array
+//     is a String.Array attribute; as in SNS, they never match numeric
+//     conditions, even when they hold a number
+//   - int and float headers are Number attributes, and so are string
+//     headers set with MessageSNS.SetNumberHeader, as pushed messages and
+//     the emulator carry Number attributes
+//   - byte headers are Binary attributes, which filter policies ignore
+type FilterPolicy struct {
+	scope FilterPolicyScope
+	root  *filterNode
+}
+
+// FilterResult is the outcome of evaluating a filter policy.
+type FilterResult struct {
+	Matched bool
+	// Reason explains a mismatch, naming the first key whose conditions
+	// were not met.
+	Reason string
+}
+
+// filterNode is an object of a policy: all its fields and $or groups must
+// match.
+type filterNode struct {
+	fields []*filterField
+	// or holds the alternatives of each $or key of the object.
+	or [][]*filterNode
+}
+
+// filterField is one key of a policy object, with either the conditions
+// of its value array or a nested object.
+type filterField struct {
+	key    string
+	conds  []*filterCond
+	nested *filterNode
+	// raw is the JSON of the value array, for reasons.
+	raw string
+}
+
+type condKind int
+
+const (
+	condString condKind = iota
+	condNumber
+	condBool
+	condNull
+	condPrefix
+	condSuffix
+	condEqualsIgnoreCase
+	condAnythingBut
+	condNumeric
+	condExists
+	condCIDR
+)
+
+type filterCond struct {
+	kind condKind
+	str  string
+	num  float64
+	b    bool
+	// ranges are the comparisons of a numeric condition.
+	ranges []numericRange
+	// except are the excluded values of anything-but.
+	except []*filterCond
+	cidr   *net.IPNet
+}
+
+type numericRange struct {
+	op    string
+	bound float64
+}
+
+// filterValue is one value an attribute or body key holds.
+type filterValue struct {
+	str    string
+	num    float64
+	b      bool
+	isStr  bool
+	isNum  bool
+	isBool bool
+	isNull bool
+	// numericText marks a String attribute that reads as a number.
+	numericText bool
+}
+
+// ParseFilterPolicy parses a filter policy for the given scope. An empty
+// scope means FilterOnAttributes, as in SNS.
+func ParseFilterPolicy(policy string, scope FilterPolicyScope) (*FilterPolicy, error) {
+	if scope == "" {
+		scope = FilterOnAttributes
+	}
+	if scope != FilterOnAttributes && scope != FilterOnBody {
+		return nil, fmt.Errorf("sns: filter policy scope %q is not recognized", scope)
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(policy), &obj); err != nil {
+		return nil, fmt.Errorf("sns: filter policy must be a JSON object: %w", err)
+	}
+	root, err := parseFilterNode(obj, scope == FilterOnBody, "")
+	if err != nil {
+		return nil, fmt.Errorf("sns: invalid filter policy: %w", err)
+	}
+	return &FilterPolicy{scope: scope, root: root}, nil
+}
+
+// MatchFilterPolicy parses policy and evaluates it against msg.
+func MatchFilterPolicy(policy string, scope FilterPolicyScope, msg messaging.Message) (FilterResult, error) {
+	fp, err := ParseFilterPolicy(policy, scope)
+	if err != nil {
+		return FilterResult{}, err
+	}
+	return fp.Match(msg), nil
+}
+
+// Scope returns the scope the policy was parsed for.
+func (fp *FilterPolicy) Scope() FilterPolicyScope {
+	return fp.scope
+}
+
+// Match evaluates the policy against the headers or the JSON body of msg.
+func (fp *FilterPolicy) Match(msg messaging.Message) FilterResult {
+	e := &filterEval{msg: msg, body: fp.scope == FilterOnBody}
+	var ctx []any
+	if e.body {
+		var body any
+		dec := json.NewDecoder(bytes.NewReader(msg.ReadBytes()))
+		dec.UseNumber()
+		if err := dec.Decode(&body); err != nil {
+			return FilterResult{Reason: "message body is not JSON"}
+		}
+		if _, ok := body.(map[string]any); !ok {
+			return FilterResult{Reason: "message body is not a JSON object"}
+		}
+		ctx = []any{body}
+	}
+	if reason := fp.root.match(e, ctx, ""); reason != "" {
+		return FilterResult{Reason: reason}
+	}
+	return FilterResult{Matched: true}
+}
+
+func parseFilterNode(obj map[string]json.RawMessage, nestedOK bool, path string) (*filterNode, error) {
+	if len(obj) == 0 {
+		return nil, fmt.Errorf("%sempty object", pathPrefix(path))
+	}
+	node := &filterNode{}
+	for _, key := range slices.Sorted(maps.Keys(obj)) {
+		raw := obj[key]
+		if key == "$or" {
+			var alts []map[string]json.RawMessage
+			if err := json.Unmarshal(raw, &alts); err != nil || len(alts) < 2 {
+				return nil, fmt.Errorf("%s$or must be an array of at least two objects", pathPrefix(path))
+			}
+			group := make([]*filterNode, 0, len(alts))
+			for _, alt := range alts {
+				n, err := parseFilterNode(alt, nestedOK, path)
+				if err != nil {
+					return nil, err
+				}
+				group = append(group, n)
+			}
+			node.or = append(node.or, group)
+			continue
+		}
+		var compact bytes.Buffer
+		_ = json.Compact(&compact, raw)
+		field := &filterField{key: key, raw: compact.String()}
+		trimmed := bytes.TrimSpace(raw)
+		switch {
+		case len(trimmed) > 0 && trimmed[0] == '{':
+			if !nestedOK {
+				return nil, fmt.Errorf("key %q: nested keys require the %s scope", joinPath(path, key), FilterOnBody)
+			}
+			var sub map[string]json.RawMessage
+			if err := json.Unmarshal(raw, &sub); err != nil {
+				return nil, fmt.Errorf("key %q: %w", joinPath(path, key), err)
+			}
+			nested, err := parseFilterNode(sub, nestedOK, joinPath(path, key))
+			if err != nil {
+				return nil, err
+			}
+			field.nested = nested
+		case len(trimmed) > 0 && trimmed[0] == '[':
+			var items []json.RawMessage
+			if err := json.Unmarshal(raw, &items); err != nil || len(items) == 0 {
+				return nil, fmt.Errorf("key %q: conditions must be a non-empty array", joinPath(path, key))
+			}
+			for _, item := range items {
+				c, err := parseFilterCond(item)
+				if err != nil {
+					return nil, fmt.Errorf("key %q: %w", joinPath(path, key), err)
+				}
+				field.conds = append(field.conds, c)
+			}
+		default:
+			return nil, fmt.Errorf("key %q: value must be an array of conditions or an object", joinPath(path, key))
+		}
+		node.fields = append(node.fields, field)
+	}
+	return node, nil
+}
+
+// parseFilterCond parses one element of a condition array.
+func parseFilterCond(raw json.RawMessage) (*filterCond, error) {
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	switch t := v.(type) {
+	case nil:
+		return &filterCond{kind: condNull}, nil
+	case string:
+		return &filterCond{kind: condString, str: t}, nil
+	case bool:
+		return &filterCond{kind: condBool, b: t}, nil
+	case json.Number:
+		n, err := t.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("number %s: %w", t, err)
+		}
+		return &filterCond{kind: condNumber, num: n}, nil
+	case map[string]any:
+		if len(t) != 1 {
+			return nil, fmt.Errorf("condition %s must have exactly one operator", raw)
+		}
+		for op, arg := range t {
+			return parseOperator(op, arg)
+		}
+	}
+	return nil, fmt.Errorf("condition %s is not supported", raw)
+}
+
+func parseOperator(op string, arg any) (*filterCond, error) {
+	switch op {
+	case "prefix", "suffix", "equals-ignore-case":
+		s, ok := arg.(string)
+		if !ok || s == "" {
+			return nil, fmt.Errorf("%s needs a non-empty string", op)
+		}
+		kind := map[string]condKind{"prefix": condPrefix, "suffix": condSuffix, "equals-ignore-case": condEqualsIgnoreCase}[op]
+		return &filterCond{kind: kind, str: s}, nil
+	case "exists":
+		b, ok := arg.(bool)
+		if !ok {
+			return nil, errors.New("exists needs true or false")
+		}
+		return &filterCond{kind: condExists, b: b}, nil
+	case "cidr":
+		s, _ := arg.(string)
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("cidr %q: %w", s, err)
+		}
+		return &filterCond{kind: condCIDR, cidr: ipNet}, nil
+	case "numeric":
+		return parseNumeric(arg)
+	case "anything-but":
+		c := &filterCond{kind: condAnythingBut}
+		items, ok := arg.([]any)
+		if !ok {
+			items = []any{arg}
+		}
+		for _, item := range items {
+			var except *filterCond
+			switch t := item.(type) {
+			case string:
+				except = &filterCond{kind: condString, str: t}
+			case json.Number:
+				n, err := t.Float64()
+				if err != nil {
+					return nil, fmt.Errorf("anything-but %s: %w", t, err)
+				}
+				except = &filterCond{kind: condNumber, num: n}
+			case map[string]any:
+				if len(t) != 1 {
+					return nil, errors.New("anything-but takes one prefix or suffix operator")
+				}
+				for subOp, subArg := range t {
+					if subOp != "prefix" && subOp != "suffix" {
+						return nil, fmt.Errorf("anything-but does not support %s", subOp)
+					}
+					var err error
+					if except, err = parseOperator(subOp, subArg); err != nil {
+						return nil, err
+					}
+				}
+			default:
+				return nil, errors.New("anything-but takes strings, numbers, or a prefix or suffix operator")
+			}
+			c.except = append(c.except, except)
+		}
+		if len(c.except) == 0 {
+			return nil, errors.New("anything-but needs at least one value")
+		}
+		return c, nil
+	}
+	return nil, fmt.Errorf("operator %q is not supported", op)
+}
+
+// parseNumeric parses ["=", 5] or up to two comparisons such as
+// [">", 0, "<=", 10].
+func parseNumeric(arg any) (*filterCond, error) {
+	items, ok := arg.([]any)
+	if !ok || len(items) == 0 || len(items) > 4 || len(items)%2 != 0 {
+		return nil, errors.New("numeric needs one or two operator and number pairs")
+	}
+	c := &filterCond{kind: condNumeric}
+	for i := 0; i < len(items); i += 2 {
+		op, _ := items[i].(string)
+		num, ok := items[i+1].(json.Number)
+		if !ok {
+			return nil, fmt.Errorf("numeric %s needs a number", op)
+		}
+		bound, err := num.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("numeric %s %s: %w", op, num, err)
+		}
+		switch op {
+		case "=", "<", "<=", ">", ">=":
+		default:
+			return nil, fmt.Errorf("numeric operator %q is not supported", op)
+		}
+		if op == "=" && len(items) > 2 {
+			return nil, errors.New(`numeric "=" cannot be combined with a range`)
+		}
+		c.ranges = append(c.ranges, numericRange{op: op, bound: bound})
+	}
+	if len(c.ranges) == 2 {
+		lower, upper := c.ranges[0], c.ranges[1]
+		if !strings.HasPrefix(lower.op, ">") || !strings.HasPrefix(upper.op, "<") || lower.bound >= upper.bound {
+			return nil, errors.New("numeric range must be a lower bound followed by a greater upper bound")
+		}
+	}
+	return c, nil
+}
+
+// filterEval evaluates a policy against one message.
+type filterEval struct {
+	msg  messaging.Message
+	body bool
+}
+
+// values returns the values of key: those of the header key, or in body
+// scope those in the JSON objects of ctx.
+func (e *filterEval) values(ctx []any, key string) []any {
+	if e.body {
+		return bodyValues(ctx, key)
+	}
+	return headerValues(e.msg, key)
+}
+
+// describe names a key in a reason.
+func (e *filterEval) describe(name string) string {
+	if e.body {
+		return fmt.Sprintf("body key %q", name)
+	}
+	return fmt.Sprintf("attribute %q", name)
+}
+
+// match returns "" when the node matches the values in ctx, and the reason
+// otherwise.
+func (n *filterNode) match(e *filterEval, ctx []any, path string) string {
+	for _, f := range n.fields {
+		name := joinPath(path, f.key)
+		values := e.values(ctx, f.key)
+		if f.nested != nil {
+			var objs []any
+			for _, v := range values {
+				if _, ok := v.(map[string]any); ok {
+					objs = append(objs, v)
+				}
+			}
+			if len(objs) == 0 {
+				return fmt.Sprintf("%s is missing", e.describe(name))
+			}
+			if reason := f.nested.match(e, objs, name); reason != "" {
+				return reason
+			}
+			continue
+		}
+		if reason := f.matchValues(values, e.describe(name)); reason != "" {
+			return reason
+		}
+	}
+	for _, group := range n.or {
+		var reasons []string
+		for _, alt := range group {
+			reason := alt.match(e, ctx, path)
+			if reason == "" {
+				reasons = nil
+				break
+			}
+			reasons = append(reasons, reason)
+		}
+		if reasons != nil {
+			return "no $or alternative matched: " + strings.Join(reasons, "; ")
+		}
+	}
+	return ""
+}
+
+// matchValues reports why none of the field's conditions accepts the
+// values, or "" when one does.
+func (f *filterField) matchValues(values []any, name string) string {
+	var scalars []filterValue
+	for _, v := range values {
+		if fv, ok := toFilterValue(v); ok {
+			scalars = append(scalars, fv)
+		}
+	}
+	for _, c := range f.conds {
+		if c.kind == condExists {
+			if (len(scalars) > 0) == c.b {
+				return ""
+			}
+			continue
+		}
+		for _, v := range scalars {
+			if c.matches(v) {
+				return ""
+			}
+		}
+	}
+	if len(scalars) == 0 {
+		return fmt.Sprintf("%s is missing; want %s", name, f.raw)
+	}
+	shown := make([]string, len(scalars))
+	numericText := false
+	for i, v := range scalars {
+		shown[i] = v.String()
+		numericText = numericText || v.numericText
+	}
+	if numericText && f.hasNumericCond() {
+		return fmt.Sprintf("%s = %s is a String attribute, which numeric conditions never match; want %s",
+			name, strings.Join(shown, ", "), f.raw)
+	}
+	return fmt.Sprintf("%s = %s matches none of %s", name, strings.Join(shown, ", "), f.raw)
+}
+
+// hasNumericCond reports whether the field has a number or numeric
+// condition.
+func (f *filterField) hasNumericCond() bool {
+	for _, c := range f.conds {
+		if c.kind == condNumber || c.kind == condNumeric {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *filterCond) matches(v filterValue) bool {
+	switch c.kind {
+	case condString:
+		return v.isStr && v.str == c.str
+	case condNumber:
+		return v.isNum && v.num == c.num
+	case condBool:
+		return v.isBool && v.b == c.b
+	case condNull:
+		return v.isNull
+	case condPrefix:
+		return v.isStr && strings.HasPrefix(v.str, c.str)
+	case condSuffix:
+		return v.isStr && strings.HasSuffix(v.str, c.str)
+	case condEqualsIgnoreCase:
+		return v.isStr && strings.EqualFold(v.str, c.str)
+	case condAnythingBut:
+		if !v.isStr && !v.isNum {
+			return false
+		}
+		for _, except := range c.except {
+			if except.matches(v) {
+				return false
+			}
+		}
+		return true
+	case condNumeric:
+		if !v.isNum {
+			return false
+		}
+		for _, r := range c.ranges {
+			ok := false
+			switch r.op {
+			case "=":
+				ok = v.num == r.bound
+			case "<":
+				ok = v.num < r.bound
+			case "<=":
+				ok = v.num <= r.bound
+			case ">":
+				ok = v.num > r.bound
+			case ">=":
+				ok = v.num >= r.bound
+			}
+			if !ok {
+				return false
+			}
+		}
+		return true
+	case condCIDR:
+		ip := net.ParseIP(v.str)
+		return v.isStr && ip != nil && c.cidr.Contains(ip)
+	}
+	return false
+}
+
+// headerValues returns the attribute values of the header key.
+func headerValues(msg messaging.Message, key string) []any {
+	if s, ok := msg.GetStrHeader(key); ok {
+		trimmed := strings.TrimSpace(s)
+		if strings.HasPrefix(trimmed, "[") {
+			var arr []any
+			dec := json.NewDecoder(strings.NewReader(trimmed))
+			dec.UseNumber()
+			if dec.Decode(&arr) == nil {
+				return arr
+			}
+		}
+		if h, ok := msg.(interface{ isNumberHeader(key string) bool }); ok && h.isNumberHeader(key) {
+			return []any{headerNumber(s)}
+		}
+		return []any{headerString(s)}
+	}
+	if n, ok := msg.GetIntHeader(key); ok {
+		return []any{float64(n)}
+	}
+	if h, ok := msg.(interface {
+		GetInt64Header(key string) (int64, bool)
+	}); ok {
+		if n, ok := h.GetInt64Header(key); ok {
+			return []any{float64(n)}
+		}
+	}
+	if h, ok := msg.(interface {
+		GetFloat64Header(key string) (float64, bool)
+	}); ok {
+		if n, ok := h.GetFloat64Header(key); ok {
+			return []any{n}
+		}
+	}
+	if b, ok := msg.GetBoolHeader(key); ok {
+		return []any{strconv.FormatBool(b)}
+	}
+	return nil
+}
+
+// headerString marks a String attribute, and headerNumber a Number
+// attribute carried in a string header.
+type (
+	headerString string
+	headerNumber string
+)
+
+// bodyValues returns the values of key in the objects of ctx, flattening
+// arrays.
+func bodyValues(ctx []any, key string) []any {
+	var values []any
+	var flatten func(v any)
+	flatten = func(v any) {
+		if arr, ok := v.([]any); ok {
+			for _, e := range arr {
+				flatten(e)
+			}
+			return
+		}
+		values = append(values, v)
+	}
+	for _, c := range ctx {
+		if obj, ok := c.(map[string]any); ok {
+			if v, present := obj[key]; present {
+				flatten(v)
+			}
+		}
+	}
+	return values
+}
+
+func toFilterValue(v any) (filterValue, bool) {
+	switch t := v.(type) {
+	case nil:
+		return filterValue{isNull: true}, true
+	case string:
+		return filterValue{str: t, isStr: true}, true
+	case headerString:
+		_, isNum := parseNumber(string(t))
+		return filterValue{str: string(t), isStr: true, numericText: isNum}, true
+	case headerNumber:
+		fv := filterValue{str: string(t), isStr: true}
+		fv.num, fv.isNum = parseNumber(string(t))
+		return fv, true
+	case bool:
+		return filterValue{b: t, isBool: true}, true
+	case float64:
+		return filterValue{num: t, isNum: true}, true
+	case json.Number:
+		n, err := t.Float64()
+		return filterValue{str: t.String(), num: n, isNum: true}, err == nil
+	}
+	return filterValue{}, false
+}
+
+// parseNumber parses the finite number s holds.
+func parseNumber(s string) (float64, bool) {
+	n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+		return 0, false
+	}
+	return n, true
+}
+
+func (v filterValue) String() string {
+	switch {
+	case v.isNull:
+		return "null"
+	case v.isBool:
+		return strconv.FormatBool(v.b)
+	case v.isStr:
+		return strconv.Quote(v.str)
+	}
+	return strconv.FormatFloat(v.num, 'g', -1, 64)
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func pathPrefix(path string) string {
+	if path == "" {
+		return ""
+	}
+	return fmt.Sprintf("key %q: ", path)
+}
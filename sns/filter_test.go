@@ -0,0 +1,168 @@
+package sns
+
+import (
+	"strings"
+	"testing"
+
+	"oss.nandlabs.io/golly/messaging"
+)
+
+func attrMessage(t *testing.T) messaging.Message {
+	t.Helper()
+	p := &Provider{}
+	m, _ := p.NewMessage(SNSScheme)
+	msg := m.(*MessageSNS)
+	msg.SetStrHeader("store", "example_corp")
+	msg.SetStrHeader("event", "order_placed")
+	msg.SetStrHeader("customer_interests", `["soccer", "rugby", "hockey"]`)
+	msg.SetNumberHeader("price_usd", "210.75")
+	msg.SetStrHeader("zip", "10115")
+	msg.SetIntHeader("quantity", 3)
+	msg.SetStrHeader("source_ip", "10.0.0.42")
+	msg.SetHeader("blob", []byte{1})
+	return msg
+}
+
+func TestFilterPolicy_Attributes(t *testing.T) {
+	msg := attrMessage(t)
+	for _, tc := range []struct {
+		policy string
+		want   bool
+	}{
+		{`{"store": ["example_corp"]}`, true},
+		{`{"store": ["other_corp", "example_corp"], "event": ["order_placed"]}`, true},
+		{`{"store": ["example_corp"], "event": ["order_cancelled"]}`, false},
+		{`{"customer_interests": ["rugby"]}`, true},
+		{`{"customer_interests": ["golf"]}`, false},
+		{`{"event": [{"prefix": "order_"}]}`, true},
+		{`{"event": [{"suffix": "_placed"}]}`, true},
+		{`{"store": [{"equals-ignore-case": "EXAMPLE_CORP"}]}`, true},
+		{`{"store": [{"anything-but": ["example_corp", "other"]}]}`, false},
+		{`{"store": [{"anything-but": {"prefix": "other"}}]}`, true},
+		{`{"quantity": [{"anything-but": [1, 2]}]}`, true},
+		{`{"price_usd": [{"numeric": [">=", 100, "<", 300]}]}`, true},
+		{`{"price_usd": [{"numeric": ["<", 100]}]}`, false},
+		{`{"quantity": [3]}`, true},
+		{`{"quantity": ["3"]}`, false},
+		{`{"quantity": [{"numeric": ["=", 3]}]}`, true},
+		{`{"zip": ["10115"]}`, true},
+		{`{"zip": [10115]}`, false},
+		{`{"zip": [{"numeric": [">", 10000]}]}`, false},
+		{`{"source_ip": [{"cidr": "10.0.0.0/24"}]}`, true},
+		{`{"source_ip": [{"cidr": "192.168.0.0/16"}]}`, false},
+		{`{"coupon": [{"exists": false}]}`, true},
+		{`{"coupon": [{"exists": true}]}`, false},
+		{`{"blob": [{"exists": true}]}`, false},
+		{`{"store": ["nope"], "$or": [{"event": ["order_placed"]}, {"quantity": [3]}]}`, false},
+		{`{"$or": [{"store": ["nope"]}, {"quantity": [{"numeric": [">", 2]}]}]}`, true},
+		{`{"$or": [{"store": ["nope"]}, {"event": ["nope"]}]}`, false},
+	} {
+		res, err := MatchFilterPolicy(tc.policy, "", msg)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.policy, err)
+		}
+		if res.Matched != tc.want {
+			t.Errorf("%s: matched = %v (%s), want %v", tc.policy, res.Matched, res.Reason, tc.want)
+		}
+		if !res.Matched && res.Reason == "" {
+			t.Errorf("%s: mismatch without a reason", tc.policy)
+		}
+	}
+}
+
+func TestFilterPolicy_Body(t *testing.T) {
+	p := &Provider{}
+	msg, _ := p.NewMessage(SNSScheme)
+	_ = msg.WriteJSON(map[string]any{
+		"type": "order",
+		"paid": true,
+		"note": nil,
+		"order": map[string]any{
+			"total": 42.5,
+			"items": []any{
+				map[string]any{"sku": "A-1", "qty": 1},
+				map[string]any{"sku": "B-2", "qty": 4},
+			},
+			"customer": map[string]any{"country": "NL"},
+		},
+	})
+	for _, tc := range []struct {
+		policy string
+		want   bool
+	}{
+		{`{"type": ["order"], "paid": [true]}`, true},
+		{`{"note": [null]}`, true},
+		{`{"order": {"total": [{"numeric": [">", 40]}], "customer": {"country": ["NL", "BE"]}}}`, true},
+		{`{"order": {"customer": {"country": ["DE"]}}}`, false},
+		{`{"order": {"items": {"sku": [{"prefix": "B-"}]}}}`, true},
+		{`{"order": {"items": {"qty": [{"numeric": [">", 10]}]}}}`, false},
+		{`{"order": {"coupon": {"code": ["X"]}}}`, false},
+		{`{"order": {"$or": [{"total": [1]}, {"customer": {"country": ["NL"]}}]}}`, true},
+	} {
+		res, err := MatchFilterPolicy(tc.policy, FilterOnBody, msg)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.policy, err)
+		}
+		if res.Matched != tc.want {
+			t.Errorf("%s: matched = %v (%s), want %v", tc.policy, res.Matched, res.Reason, tc.want)
+		}
+	}
+
+	res, _ := MatchFilterPolicy(`{"order": {"customer": {"country": ["DE"]}}}`, FilterOnBody, msg)
+	if want := `body key "order.customer.country" = "NL" matches none of ["DE"]`; res.Reason != want {
+		t.Fatalf("Reason = %q, want %q", res.Reason, want)
+	}
+	_, _ = msg.SetBodyStr("not json")
+	if res, _ := MatchFilterPolicy(`{"type": ["order"]}`, FilterOnBody, msg); res.Matched || !strings.Contains(res.Reason, "not JSON") {
+		t.Fatalf("non-JSON body = %+v", res)
+	}
+}
+
+func TestFilterPolicy_Invalid(t *testing.T) {
+	for _, policy := range []string{
+		`[]`,
+		`{}`,
+		`{"a": "b"}`,
+		`{"a": []}`,
+		`{"a": [{"prefix": 1}]}`,
+		`{"a": [{"numeric": ["<", 10, ">", 0]}]}`,
+		`{"a": [{"numeric": ["~", 1]}]}`,
+		`{"a": [{"cidr": "10.0.0.0"}]}`,
+		`{"a": [{"wildcard": "x*"}]}`,
+		`{"a": [{"anything-but": {"equals-ignore-case": "x"}}]}`,
+		`{"$or": [{"a": ["b"]}]}`,
+		`{"a": {"b": ["c"]}}`,
+	} {
+		if _, err := ParseFilterPolicy(policy, FilterOnAttributes); err == nil {
+			t.Errorf("%s: expected an error", policy)
+		}
+	}
+	if _, err := ParseFilterPolicy(`{"a": ["b"]}`, "Headers"); err == nil {
+		t.Errorf("expected an error for an unknown scope")
+	}
+}
+
+func TestFilterPolicy_NumericStringReason(t *testing.T) {
+	res, err := MatchFilterPolicy(`{"zip": [{"numeric": [">", 10000]}]}`, "", attrMessage(t))
+	if err != nil || res.Matched || !strings.Contains(res.Reason, `"zip" = "10115" is a String attribute`) {
+		t.Fatalf("result = %+v, %v", res, err)
+	}
+}
+
+func TestFilterPolicy_PushedNumberAttributes(t *testing.T) {
+	env := notification("m1", "hello")
+	env.MessageAttributes["amount"] = pushAttribute{Type: "Number", Value: "42.5"}
+	env.MessageAttributes["zip"] = pushAttribute{Type: "String", Value: "10115"}
+	msg, err := (&Provider{}).pushedMessage(nil, env)
+	if err != nil {
+		t.Fatalf("pushedMessage: %v", err)
+	}
+	for policy, want := range map[string]bool{
+		`{"amount": [{"numeric": [">", 40]}]}`: true,
+		`{"zip": [{"numeric": [">", 40]}]}`:    false,
+	} {
+		if res, _ := MatchFilterPolicy(policy, "", msg); res.Matched != want {
+			t.Errorf("%s: matched = %v (%s), want %v", policy, res.Matched, res.Reason, want)
+		}
+	}
+}
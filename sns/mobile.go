@@ -0,0 +1,479 @@
+package sns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+)
+
+// MobileAdmin manages SNS mobile push and the SMS opt-out list.
+//
+// Platform applications are addressed as sns://app-name, looked up by name,
+// or as sns:///arn:aws:sns:region:account:app/PLATFORM/app-name. For the
+// endpoint and opt-out methods the URL only selects the awscfg config, the
+// same way it does for Send.
+//
+// RegisterDevice follows the registration flow AWS recommends for mobile
+// push: it creates the endpoint of a device token, or finds the existing
+// one, and re-enables it and updates its token when SNS disabled it or the
+// token changed. Apps call it whenever they obtain a token, and publish to
+// the returned ARN with OptTargetArn.
+type MobileAdmin interface {
+	EnsurePlatformApplication(ctx context.Context, u *url.URL, app *PlatformApplication) (string, bool, error)
+	PlatformApplicationArn(ctx context.Context, u *url.URL) (string, error)
+	DeletePlatformApplication(ctx context.Context, u *url.URL) (bool, error)
+
+	RegisterDevice(ctx context.Context, u *url.URL, token, userData string) (string, error)
+	ListEndpoints(ctx context.Context, u *url.URL) ([]*PlatformEndpoint, error)
+	GetEndpoint(ctx context.Context, u *url.URL, endpointArn string) (*PlatformEndpoint, error)
+	SetEndpointEnabled(ctx context.Context, u *url.URL, endpointArn string, enabled bool) error
+	DeleteEndpoint(ctx context.Context, u *url.URL, endpointArn string) error
+
+	IsOptedOut(ctx context.Context, u *url.URL, phone string) (bool, error)
+	ListOptedOut(ctx context.Context, u *url.URL) ([]string, error)
+	OptIn(ctx context.Context, u *url.URL, phone string) error
+}
+
+// Mobile returns the SNS MobileAdmin.
+func Mobile() MobileAdmin {
+	return mobileAdmin{}
+}
+
+// Platform is the push notification service of a platform application.
+type Platform string
+
+const (
+	// PlatformAPNS is the Apple Push Notification service.
+	PlatformAPNS Platform = "APNS"
+	// PlatformAPNSSandbox is the APNs development environment.
+	PlatformAPNSSandbox Platform = "APNS_SANDBOX"
+	// PlatformFCM is Firebase Cloud Messaging, which SNS calls GCM.
+	PlatformFCM Platform = "GCM"
+	// PlatformADM is Amazon Device Messaging.
+	PlatformADM Platform = "ADM"
+	// PlatformBaidu is Baidu Cloud Push.
+	PlatformBaidu Platform = "BAIDU"
+	// PlatformMPNS is the Microsoft Push Notification Service.
+	PlatformMPNS Platform = "MPNS"
+	// PlatformWNS is the Windows Push Notification Services.
+	PlatformWNS Platform = "WNS"
+)
+
+// PlatformApplication is the desired configuration of a platform
+// application.
+type PlatformApplication struct {
+	Platform Platform
+	// Credential is the PlatformCredential: the APNs private key or token
+	// signing key, the FCM service account JSON or the API key of the
+	// platform.
+	Credential string
+	// Principal is the PlatformPrincipal: the APNs certificate or signing
+	// key ID, or the client ID of the platform.
+	Principal string
+	// Attributes holds further attributes, e.g. "EventEndpointCreated" or
+	// "SuccessFeedbackRoleArn". They are reconciled on an existing
+	// application.
+	Attributes map[string]string
+	// RotateCredentials sets Credential and Principal on an existing
+	// application too. SNS does not return them, so by default they are
+	// only used to create the application.
+	RotateCredentials bool
+}
+
+// PlatformEndpoint describes the endpoint of a device.
+type PlatformEndpoint struct {
+	Arn   string
+	Token string
+	// Enabled is false when SNS disabled the endpoint, typically because
+	// the platform reported its token as invalid. Publishing to a disabled
+	// endpoint fails with ErrEndpointDisabled.
+	Enabled        bool
+	CustomUserData string
+}
+
+// mobileAPI is the mobile push and SMS subset of the SNS client used by
+// MobileAdmin.
+type mobileAPI interface {
+	optOutAPI
+	CreatePlatformApplication(ctx context.Context, params *sns.CreatePlatformApplicationInput, optFns ...func(*sns.Options)) (*sns.CreatePlatformApplicationOutput, error)
+	GetPlatformApplicationAttributes(ctx context.Context, params *sns.GetPlatformApplicationAttributesInput, optFns ...func(*sns.Options)) (*sns.GetPlatformApplicationAttributesOutput, error)
+	SetPlatformApplicationAttributes(ctx context.Context, params *sns.SetPlatformApplicationAttributesInput, optFns ...func(*sns.Options)) (*sns.SetPlatformApplicationAttributesOutput, error)
+	DeletePlatformApplication(ctx context.Context, params *sns.DeletePlatformApplicationInput, optFns ...func(*sns.Options)) (*sns.DeletePlatformApplicationOutput, error)
+	ListPlatformApplications(ctx context.Context, params *sns.ListPlatformApplicationsInput, optFns ...func(*sns.Options)) (*sns.ListPlatformApplicationsOutput, error)
+	CreatePlatformEndpoint(ctx context.Context, params *sns.CreatePlatformEndpointInput, optFns ...func(*sns.Options)) (*sns.CreatePlatformEndpointOutput, error)
+	GetEndpointAttributes(ctx context.Context, params *sns.GetEndpointAttributesInput, optFns ...func(*sns.Options)) (*sns.GetEndpointAttributesOutput, error)
+	SetEndpointAttributes(ctx context.Context, params *sns.SetEndpointAttributesInput, optFns ...func(*sns.Options)) (*sns.SetEndpointAttributesOutput, error)
+	DeleteEndpoint(ctx context.Context, params *sns.DeleteEndpointInput, optFns ...func(*sns.Options)) (*sns.DeleteEndpointOutput, error)
+	ListEndpointsByPlatformApplication(ctx context.Context, params *sns.ListEndpointsByPlatformApplicationInput, optFns ...func(*sns.Options)) (*sns.ListEndpointsByPlatformApplicationOutput, error)
+	ListPhoneNumbersOptedOut(ctx context.Context, params *sns.ListPhoneNumbersOptedOutInput, optFns ...func(*sns.Options)) (*sns.ListPhoneNumbersOptedOutOutput, error)
+	OptInPhoneNumber(ctx context.Context, params *sns.OptInPhoneNumberInput, optFns ...func(*sns.Options)) (*sns.OptInPhoneNumberOutput, error)
+}
+
+// Compile-time check that the SDK client satisfies mobileAPI.
+var _ mobileAPI = (*sns.Client)(nil)
+
+// resolveMobileClient returns the client for u. It is a package-level var
+// for test injection.
+var resolveMobileClient = func(u *url.URL) (mobileAPI, error) {
+	return getSNSClient(u)
+}
+
+// existingEndpointPattern finds the ARN in the error CreatePlatformEndpoint
+// returns for a token registered with different attributes.
+var existingEndpointPattern = regexp.MustCompile(`Endpoint (arn:aws[^ ]*:sns:[^ ]+) already exists`)
+
+type mobileAdmin struct{}
+
+// lookupPlatformApplication returns the client of u and the ARN of its
+// platform application, empty when it does not exist. A platform narrows
+// the lookup by name to the applications of that platform.
+func lookupPlatformApplication(ctx context.Context, u *url.URL, platform Platform) (mobileAPI, string, error) {
+	arn, isARN := urlARN(u)
+	if !isARN && u.Host == "" {
+		return nil, "", fmt.Errorf("sns: platform application name (URL host) is required")
+	}
+	client, err := resolveMobileClient(u)
+	if err != nil {
+		return nil, "", err
+	}
+	if isARN {
+		_, err := client.GetPlatformApplicationAttributes(ctx, &sns.GetPlatformApplicationAttributesInput{PlatformApplicationArn: &arn})
+		var notFound *types.NotFoundException
+		if errors.As(err, &notFound) {
+			return client, "", nil
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("sns: failed to get attributes of platform application %s: %w", arn, err)
+		}
+		return client, arn, nil
+	}
+
+	var found []string
+	input := &sns.ListPlatformApplicationsInput{}
+	for {
+		out, err := client.ListPlatformApplications(ctx, input)
+		if err != nil {
+			return nil, "", fmt.Errorf("sns: failed to list platform applications: %w", err)
+		}
+		for _, app := range out.PlatformApplications {
+			appPlatform, name := platformApplicationName(safeDeref(app.PlatformApplicationArn))
+			if name == u.Host && (platform == "" || appPlatform == platform) {
+				found = append(found, *app.PlatformApplicationArn)
+			}
+		}
+		if out.NextToken == nil || *out.NextToken == "" {
+			break
+		}
+		input.NextToken = out.NextToken
+	}
+	switch len(found) {
+	case 0:
+		return client, "", nil
+	case 1:
+		return client, found[0], nil
+	default:
+		return nil, "", fmt.Errorf("sns: platform application name %q is ambiguous: %s", u.Host, strings.Join(found, ", "))
+	}
+}
+
+// existingPlatformApplication is lookupPlatformApplication for methods that
+// need the application to exist.
+func existingPlatformApplication(ctx context.Context, u *url.URL) (mobileAPI, string, error) {
+	client, arn, err := lookupPlatformApplication(ctx, u, "")
+	if err == nil && arn == "" {
+		err = fmt.Errorf("sns: platform application %q does not exist", topicName(u))
+	}
+	return client, arn, err
+}
+
+// platformApplicationName splits the app/PLATFORM/name resource of a
+// platform application ARN.
+func platformApplicationName(arn string) (Platform, string) {
+	resource := arn[strings.LastIndex(arn, ":")+1:]
+	parts := strings.SplitN(resource, "/", 3)
+	if len(parts) != 3 || parts[0] != "app" {
+		return "", ""
+	}
+	return Platform(parts[1]), parts[2]
+}
+
+// EnsurePlatformApplication creates the platform application named by u, or
+// reconciles the attributes of an existing one, and returns its ARN and
+// whether anything changed.
+func (mobileAdmin) EnsurePlatformApplication(ctx context.Context, u *url.URL, app *PlatformApplication) (string, bool, error) {
+	if app == nil || app.Platform == "" {
+		return "", false, errors.New("sns: platform application needs a Platform")
+	}
+	client, arn, err := lookupPlatformApplication(ctx, u, app.Platform)
+	if err != nil {
+		return "", false, err
+	}
+	credentials := map[string]string{}
+	if app.Credential != "" {
+		credentials["PlatformCredential"] = app.Credential
+	}
+	if app.Principal != "" {
+		credentials["PlatformPrincipal"] = app.Principal
+	}
+
+	if arn == "" {
+		if _, isARN := urlARN(u); isARN {
+			return "", false, fmt.Errorf("sns: platform application %s does not exist; name it by sns://app-name to create it", topicName(u))
+		}
+		attrs := maps.Clone(app.Attributes)
+		if attrs == nil {
+			attrs = map[string]string{}
+		}
+		maps.Copy(attrs, credentials)
+		out, err := client.CreatePlatformApplication(ctx, &sns.CreatePlatformApplicationInput{
+			Name:       &u.Host,
+			Platform:   strPtr(string(app.Platform)),
+			Attributes: attrs,
+		})
+		if err != nil {
+			return "", false, fmt.Errorf("sns: failed to create platform application %s: %w", u.Host, err)
+		}
+		logger.InfoF("SNS platform application created: %s", safeDeref(out.PlatformApplicationArn))
+		return safeDeref(out.PlatformApplicationArn), true, nil
+	}
+
+	if platform, _ := platformApplicationName(arn); platform != app.Platform {
+		return "", false, fmt.Errorf("sns: platform application %s is on platform %s, not %s", arn, platform, app.Platform)
+	}
+	current, err := client.GetPlatformApplicationAttributes(ctx, &sns.GetPlatformApplicationAttributesInput{PlatformApplicationArn: &arn})
+	if err != nil {
+		return "", false, fmt.Errorf("sns: failed to get attributes of platform application %s: %w", arn, err)
+	}
+	changes := map[string]string{}
+	for k, v := range app.Attributes {
+		if current.Attributes[k] != v {
+			changes[k] = v
+		}
+	}
+	if app.RotateCredentials {
+		maps.Copy(changes, credentials)
+	}
+	if len(changes) == 0 {
+		return arn, false, nil
+	}
+	if _, err := client.SetPlatformApplicationAttributes(ctx, &sns.SetPlatformApplicationAttributesInput{
+		PlatformApplicationArn: &arn,
+		Attributes:             changes,
+	}); err != nil {
+		return "", false, fmt.Errorf("sns: failed to set attributes of platform application %s: %w", arn, err)
+	}
+	return arn, true, nil
+}
+
+// PlatformApplicationArn returns the ARN of the platform application named
+// by u.
+func (mobileAdmin) PlatformApplicationArn(ctx context.Context, u *url.URL) (string, error) {
+	_, arn, err := existingPlatformApplication(ctx, u)
+	return arn, err
+}
+
+// DeletePlatformApplication deletes the platform application named by u and
+// its endpoints. It reports false when the application did not exist.
+func (mobileAdmin) DeletePlatformApplication(ctx context.Context, u *url.URL) (bool, error) {
+	client, arn, err := lookupPlatformApplication(ctx, u, "")
+	if err != nil || arn == "" {
+		return false, err
+	}
+	if _, err := client.DeletePlatformApplication(ctx, &sns.DeletePlatformApplicationInput{PlatformApplicationArn: &arn}); err != nil {
+		return false, fmt.Errorf("sns: failed to delete platform application %s: %w", arn, err)
+	}
+	return true, nil
+}
+
+// RegisterDevice returns the ARN of the endpoint of token in the platform
+// application named by u. userData is stored as CustomUserData of a new
+// endpoint, e.g. the user the device belongs to.
+func (mobileAdmin) RegisterDevice(ctx context.Context, u *url.URL, token, userData string) (string, error) {
+	if token == "" {
+		return "", errors.New("sns: device token is required")
+	}
+	client, appArn, err := existingPlatformApplication(ctx, u)
+	if err != nil {
+		return "", err
+	}
+	input := &sns.CreatePlatformEndpointInput{PlatformApplicationArn: &appArn, Token: &token}
+	if userData != "" {
+		input.CustomUserData = &userData
+	}
+	var endpointArn string
+	out, err := client.CreatePlatformEndpoint(ctx, input)
+	if err != nil {
+		// The token is registered already, with other attributes such as
+		// CustomUserData; SNS names the existing endpoint in the error.
+		var invalid *types.InvalidParameterException
+		m := existingEndpointPattern.FindStringSubmatch(err.Error())
+		if !errors.As(err, &invalid) || m == nil {
+			return "", fmt.Errorf("sns: failed to create endpoint in %s: %w", appArn, err)
+		}
+		endpointArn = m[1]
+	} else {
+		endpointArn = safeDeref(out.EndpointArn)
+	}
+
+	endpoint, err := getEndpoint(ctx, client, endpointArn)
+	var notFound *types.NotFoundException
+	if errors.As(err, &notFound) {
+		// Deleted since it was found; create it again.
+		out, err := client.CreatePlatformEndpoint(ctx, input)
+		if err != nil {
+			return "", fmt.Errorf("sns: failed to create endpoint in %s: %w", appArn, err)
+		}
+		return safeDeref(out.EndpointArn), nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if endpoint.Token != token || !endpoint.Enabled {
+		if _, err := client.SetEndpointAttributes(ctx, &sns.SetEndpointAttributesInput{
+			EndpointArn: &endpointArn,
+			Attributes:  map[string]string{"Token": token, "Enabled": "true"},
+		}); err != nil {
+			return "", fmt.Errorf("sns: failed to update endpoint %s: %w", endpointArn, err)
+		}
+		logger.InfoF("SNS endpoint %s re-enabled for its current token", endpointArn)
+	}
+	return endpointArn, nil
+}
+
+// ListEndpoints returns the endpoints of the platform application named by
+// u.
+func (mobileAdmin) ListEndpoints(ctx context.Context, u *url.URL) ([]*PlatformEndpoint, error) {
+	client, appArn, err := existingPlatformApplication(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	var endpoints []*PlatformEndpoint
+	input := &sns.ListEndpointsByPlatformApplicationInput{PlatformApplicationArn: &appArn}
+	for {
+		out, err := client.ListEndpointsByPlatformApplication(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("sns: failed to list endpoints of %s: %w", appArn, err)
+		}
+		for _, e := range out.Endpoints {
+			endpoints = append(endpoints, platformEndpoint(safeDeref(e.EndpointArn), e.Attributes))
+		}
+		if out.NextToken == nil || *out.NextToken == "" {
+			return endpoints, nil
+		}
+		input.NextToken = out.NextToken
+	}
+}
+
+// GetEndpoint returns the endpoint with the given ARN.
+func (mobileAdmin) GetEndpoint(ctx context.Context, u *url.URL, endpointArn string) (*PlatformEndpoint, error) {
+	client, err := resolveMobileClient(u)
+	if err != nil {
+		return nil, err
+	}
+	return getEndpoint(ctx, client, endpointArn)
+}
+
+// SetEndpointEnabled enables or disables the endpoint with the given ARN.
+// SNS drops messages published to a disabled endpoint.
+func (mobileAdmin) SetEndpointEnabled(ctx context.Context, u *url.URL, endpointArn string, enabled bool) error {
+	client, err := resolveMobileClient(u)
+	if err != nil {
+		return err
+	}
+	if _, err := client.SetEndpointAttributes(ctx, &sns.SetEndpointAttributesInput{
+		EndpointArn: &endpointArn,
+		Attributes:  map[string]string{"Enabled": strconv.FormatBool(enabled)},
+	}); err != nil {
+		return fmt.Errorf("sns: failed to update endpoint %s: %w", endpointArn, err)
+	}
+	return nil
+}
+
+// DeleteEndpoint deletes the endpoint with the given ARN. Deleting an
+// endpoint that does not exist succeeds.
+func (mobileAdmin) DeleteEndpoint(ctx context.Context, u *url.URL, endpointArn string) error {
+	client, err := resolveMobileClient(u)
+	if err != nil {
+		return err
+	}
+	if _, err := client.DeleteEndpoint(ctx, &sns.DeleteEndpointInput{EndpointArn: &endpointArn}); err != nil {
+		return fmt.Errorf("sns: failed to delete endpoint %s: %w", endpointArn, err)
+	}
+	return nil
+}
+
+// IsOptedOut reports whether phone opted out of receiving SMS from the
+// account.
+func (mobileAdmin) IsOptedOut(ctx context.Context, u *url.URL, phone string) (bool, error) {
+	client, err := resolveMobileClient(u)
+	if err != nil {
+		return false, err
+	}
+	err = checkOptedOut(ctx, client, phone)
+	if errors.Is(err, ErrOptedOut) {
+		return true, nil
+	}
+	return false, err
+}
+
+// ListOptedOut returns the phone numbers that opted out of receiving SMS
+// from the account.
+func (mobileAdmin) ListOptedOut(ctx context.Context, u *url.URL) ([]string, error) {
+	client, err := resolveMobileClient(u)
+	if err != nil {
+		return nil, err
+	}
+	var phones []string
+	input := &sns.ListPhoneNumbersOptedOutInput{}
+	for {
+		out, err := client.ListPhoneNumbersOptedOut(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("sns: failed to list opted-out phone numbers: %w", err)
+		}
+		phones = append(phones, out.PhoneNumbers...)
+		if out.NextToken == nil || *out.NextToken == "" {
+			return phones, nil
+		}
+		input.NextToken = out.NextToken
+	}
+}
+
+// OptIn opts phone back in to receiving SMS. SNS allows this once every 30
+// days per number.
+func (mobileAdmin) OptIn(ctx context.Context, u *url.URL, phone string) error {
+	client, err := resolveMobileClient(u)
+	if err != nil {
+		return err
+	}
+	if _, err := client.OptInPhoneNumber(ctx, &sns.OptInPhoneNumberInput{PhoneNumber: &phone}); err != nil {
+		return fmt.Errorf("sns: failed to opt in %s: %w", phone, err)
+	}
+	return nil
+}
+
+// getEndpoint returns the endpoint with the given ARN. A missing endpoint
+// is returned as the SDK's NotFoundException, wrapped.
+func getEndpoint(ctx context.Context, client mobileAPI, endpointArn string) (*PlatformEndpoint, error) {
+	out, err := client.GetEndpointAttributes(ctx, &sns.GetEndpointAttributesInput{EndpointArn: &endpointArn})
+	if err != nil {
+		return nil, fmt.Errorf("sns: failed to get attributes of endpoint %s: %w", endpointArn, err)
+	}
+	return platformEndpoint(endpointArn, out.Attributes), nil
+}
+
+func platformEndpoint(arn string, attrs map[string]string) *PlatformEndpoint {
+	return &PlatformEndpoint{
+		Arn:            arn,
+		Token:          attrs["Token"],
+		Enabled:        attrs["Enabled"] == "true",
+		CustomUserData: attrs["CustomUserData"],
+	}
+}
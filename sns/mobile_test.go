@@ -0,0 +1,295 @@
+package sns
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awssns "github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+)
+
+// fakeMobileClient keeps platform applications, endpoints and the opt-out
+// list in memory, and answers CreatePlatformEndpoint for a known token the
+// way SNS does.
+type fakeMobileClient struct {
+	mu        sync.Mutex
+	apps      map[string]map[string]string
+	endpoints map[string]map[string]string
+	optedOut  []string
+	appSets   []map[string]string
+	endSets   []map[string]string
+	seq       int
+}
+
+func newFakeMobileClient() *fakeMobileClient {
+	return &fakeMobileClient{apps: map[string]map[string]string{}, endpoints: map[string]map[string]string{}}
+}
+
+func withFakeMobile(t *testing.T, client mobileAPI) {
+	t.Helper()
+	prev := resolveMobileClient
+	resolveMobileClient = func(*url.URL) (mobileAPI, error) { return client, nil }
+	t.Cleanup(func() { resolveMobileClient = prev })
+}
+
+func (f *fakeMobileClient) CheckIfPhoneNumberIsOptedOut(ctx context.Context, in *awssns.CheckIfPhoneNumberIsOptedOutInput, _ ...func(*awssns.Options)) (*awssns.CheckIfPhoneNumberIsOptedOutOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &awssns.CheckIfPhoneNumberIsOptedOutOutput{IsOptedOut: slices.Contains(f.optedOut, *in.PhoneNumber)}, nil
+}
+
+func (f *fakeMobileClient) CreatePlatformApplication(ctx context.Context, in *awssns.CreatePlatformApplicationInput, _ ...func(*awssns.Options)) (*awssns.CreatePlatformApplicationOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	arn := testARNPrefix + "app/" + *in.Platform + "/" + *in.Name
+	f.apps[arn] = maps.Clone(in.Attributes)
+	return &awssns.CreatePlatformApplicationOutput{PlatformApplicationArn: &arn}, nil
+}
+
+func (f *fakeMobileClient) GetPlatformApplicationAttributes(ctx context.Context, in *awssns.GetPlatformApplicationAttributesInput, _ ...func(*awssns.Options)) (*awssns.GetPlatformApplicationAttributesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	attrs, ok := f.apps[*in.PlatformApplicationArn]
+	if !ok {
+		return nil, &types.NotFoundException{Message: aws.String("PlatformApplication does not exist")}
+	}
+	// Like SNS, never return the credentials.
+	out := maps.Clone(attrs)
+	delete(out, "PlatformCredential")
+	delete(out, "PlatformPrincipal")
+	return &awssns.GetPlatformApplicationAttributesOutput{Attributes: out}, nil
+}
+
+func (f *fakeMobileClient) SetPlatformApplicationAttributes(ctx context.Context, in *awssns.SetPlatformApplicationAttributesInput, _ ...func(*awssns.Options)) (*awssns.SetPlatformApplicationAttributesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.appSets = append(f.appSets, in.Attributes)
+	maps.Copy(f.apps[*in.PlatformApplicationArn], in.Attributes)
+	return &awssns.SetPlatformApplicationAttributesOutput{}, nil
+}
+
+func (f *fakeMobileClient) DeletePlatformApplication(ctx context.Context, in *awssns.DeletePlatformApplicationInput, _ ...func(*awssns.Options)) (*awssns.DeletePlatformApplicationOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.apps, *in.PlatformApplicationArn)
+	return &awssns.DeletePlatformApplicationOutput{}, nil
+}
+
+func (f *fakeMobileClient) ListPlatformApplications(ctx context.Context, in *awssns.ListPlatformApplicationsInput, _ ...func(*awssns.Options)) (*awssns.ListPlatformApplicationsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// One application per page, to exercise paging.
+	arns := slices.Sorted(maps.Keys(f.apps))
+	i := 0
+	if in.NextToken != nil {
+		fmt.Sscan(*in.NextToken, &i)
+	}
+	out := &awssns.ListPlatformApplicationsOutput{}
+	if i < len(arns) {
+		out.PlatformApplications = []types.PlatformApplication{{PlatformApplicationArn: aws.String(arns[i])}}
+	}
+	if i+1 < len(arns) {
+		out.NextToken = aws.String(fmt.Sprint(i + 1))
+	}
+	return out, nil
+}
+
+func (f *fakeMobileClient) CreatePlatformEndpoint(ctx context.Context, in *awssns.CreatePlatformEndpointInput, _ ...func(*awssns.Options)) (*awssns.CreatePlatformEndpointOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for arn, attrs := range f.endpoints {
+		if attrs["Token"] != *in.Token {
+			continue
+		}
+		if attrs["CustomUserData"] != aws.ToString(in.CustomUserData) {
+			return nil, &types.InvalidParameterException{Message: aws.String(
+				"Invalid parameter: Token Reason: Endpoint " + arn + " already exists with the same Token, but different attributes.")}
+		}
+		return &awssns.CreatePlatformEndpointOutput{EndpointArn: aws.String(arn)}, nil
+	}
+	f.seq++
+	_, name := platformApplicationName(*in.PlatformApplicationArn)
+	arn := fmt.Sprintf("%sendpoint/GCM/%s/%d", testARNPrefix, name, f.seq)
+	f.endpoints[arn] = map[string]string{"Token": *in.Token, "Enabled": "true", "CustomUserData": aws.ToString(in.CustomUserData)}
+	return &awssns.CreatePlatformEndpointOutput{EndpointArn: &arn}, nil
+}
+
+func (f *fakeMobileClient) GetEndpointAttributes(ctx context.Context, in *awssns.GetEndpointAttributesInput, _ ...func(*awssns.Options)) (*awssns.GetEndpointAttributesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	attrs, ok := f.endpoints[*in.EndpointArn]
+	if !ok {
+		return nil, &types.NotFoundException{Message: aws.String("Endpoint does not exist")}
+	}
+	return &awssns.GetEndpointAttributesOutput{Attributes: maps.Clone(attrs)}, nil
+}
+
+func (f *fakeMobileClient) SetEndpointAttributes(ctx context.Context, in *awssns.SetEndpointAttributesInput, _ ...func(*awssns.Options)) (*awssns.SetEndpointAttributesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.endSets = append(f.endSets, in.Attributes)
+	maps.Copy(f.endpoints[*in.EndpointArn], in.Attributes)
+	return &awssns.SetEndpointAttributesOutput{}, nil
+}
+
+func (f *fakeMobileClient) DeleteEndpoint(ctx context.Context, in *awssns.DeleteEndpointInput, _ ...func(*awssns.Options)) (*awssns.DeleteEndpointOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.endpoints, *in.EndpointArn)
+	return &awssns.DeleteEndpointOutput{}, nil
+}
+
+func (f *fakeMobileClient) ListEndpointsByPlatformApplication(ctx context.Context, in *awssns.ListEndpointsByPlatformApplicationInput, _ ...func(*awssns.Options)) (*awssns.ListEndpointsByPlatformApplicationOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := &awssns.ListEndpointsByPlatformApplicationOutput{}
+	for _, arn := range slices.Sorted(maps.Keys(f.endpoints)) {
+		out.Endpoints = append(out.Endpoints, types.Endpoint{EndpointArn: aws.String(arn), Attributes: maps.Clone(f.endpoints[arn])})
+	}
+	return out, nil
+}
+
+func (f *fakeMobileClient) ListPhoneNumbersOptedOut(ctx context.Context, in *awssns.ListPhoneNumbersOptedOutInput, _ ...func(*awssns.Options)) (*awssns.ListPhoneNumbersOptedOutOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &awssns.ListPhoneNumbersOptedOutOutput{PhoneNumbers: slices.Clone(f.optedOut)}, nil
+}
+
+func (f *fakeMobileClient) OptInPhoneNumber(ctx context.Context, in *awssns.OptInPhoneNumberInput, _ ...func(*awssns.Options)) (*awssns.OptInPhoneNumberOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.optedOut = slices.DeleteFunc(f.optedOut, func(p string) bool { return p == *in.PhoneNumber })
+	return &awssns.OptInPhoneNumberOutput{}, nil
+}
+
+func TestMobile_EnsurePlatformApplication(t *testing.T) {
+	fake := newFakeMobileClient()
+	withFakeMobile(t, fake)
+	ctx := context.Background()
+	u, _ := url.Parse("sns://shop-app")
+	app := &PlatformApplication{
+		Platform:   PlatformFCM,
+		Credential: `{"type":"service_account"}`,
+		Attributes: map[string]string{"SuccessFeedbackSampleRate": "100"},
+	}
+
+	arn, changed, err := Mobile().EnsurePlatformApplication(ctx, u, app)
+	if err != nil || !changed || arn != testARNPrefix+"app/GCM/shop-app" {
+		t.Fatalf("create = %q, %v, %v", arn, changed, err)
+	}
+	if fake.apps[arn]["PlatformCredential"] != app.Credential {
+		t.Fatalf("credential not set on create: %v", fake.apps[arn])
+	}
+	if _, changed, err := Mobile().EnsurePlatformApplication(ctx, u, app); err != nil || changed {
+		t.Fatalf("second ensure = %v, %v; want no change", changed, err)
+	}
+
+	app.Attributes["SuccessFeedbackSampleRate"] = "10"
+	app.RotateCredentials = true
+	if _, changed, err := Mobile().EnsurePlatformApplication(ctx, u, app); err != nil || !changed {
+		t.Fatalf("reconcile = %v, %v", changed, err)
+	}
+	if want := (map[string]string{"SuccessFeedbackSampleRate": "10", "PlatformCredential": app.Credential}); !maps.Equal(fake.appSets[0], want) {
+		t.Fatalf("set attributes = %v, want %v", fake.appSets[0], want)
+	}
+
+	// A name on two platforms is ambiguous unless the platform is known.
+	if _, _, err := Mobile().EnsurePlatformApplication(ctx, u, &PlatformApplication{Platform: PlatformAPNS}); err != nil {
+		t.Fatalf("create APNS app: %v", err)
+	}
+	if _, err := Mobile().PlatformApplicationArn(ctx, u); err == nil {
+		t.Fatalf("expected an ambiguous name error")
+	}
+	byArn, _ := url.Parse("sns:///" + arn)
+	if got, err := Mobile().PlatformApplicationArn(ctx, byArn); err != nil || got != arn {
+		t.Fatalf("PlatformApplicationArn = %q, %v", got, err)
+	}
+	if deleted, err := Mobile().DeletePlatformApplication(ctx, byArn); err != nil || !deleted {
+		t.Fatalf("delete = %v, %v", deleted, err)
+	}
+	if deleted, err := Mobile().DeletePlatformApplication(ctx, byArn); err != nil || deleted {
+		t.Fatalf("second delete = %v, %v; want false", deleted, err)
+	}
+}
+
+func TestMobile_RegisterDevice(t *testing.T) {
+	fake := newFakeMobileClient()
+	withFakeMobile(t, fake)
+	ctx := context.Background()
+	u, _ := url.Parse("sns://shop-app")
+
+	if _, err := Mobile().RegisterDevice(ctx, u, "token-1", ""); err == nil {
+		t.Fatalf("expected an error for a missing application")
+	}
+	if _, _, err := Mobile().EnsurePlatformApplication(ctx, u, &PlatformApplication{Platform: PlatformFCM}); err != nil {
+		t.Fatal(err)
+	}
+
+	arn, err := Mobile().RegisterDevice(ctx, u, "token-1", "user-7")
+	if err != nil {
+		t.Fatalf("RegisterDevice: %v", err)
+	}
+	if again, err := Mobile().RegisterDevice(ctx, u, "token-1", "user-7"); err != nil || again != arn {
+		t.Fatalf("re-register = %q, %v; want %q", again, err, arn)
+	}
+	if len(fake.endSets) != 0 {
+		t.Fatalf("healthy endpoint was updated: %v", fake.endSets)
+	}
+
+	// SNS disabled the endpoint; registering the token again, even with
+	// other user data, finds and re-enables it.
+	if err := Mobile().SetEndpointEnabled(ctx, u, arn, false); err != nil {
+		t.Fatal(err)
+	}
+	if ep, err := Mobile().GetEndpoint(ctx, u, arn); err != nil || ep.Enabled {
+		t.Fatalf("GetEndpoint = %+v, %v; want disabled", ep, err)
+	}
+	if again, err := Mobile().RegisterDevice(ctx, u, "token-1", "user-8"); err != nil || again != arn {
+		t.Fatalf("re-register disabled = %q, %v; want %q", again, err, arn)
+	}
+	ep, err := Mobile().GetEndpoint(ctx, u, arn)
+	if err != nil || !ep.Enabled || ep.Token != "token-1" || ep.CustomUserData != "user-7" {
+		t.Fatalf("GetEndpoint = %+v, %v", ep, err)
+	}
+
+	if _, err := Mobile().RegisterDevice(ctx, u, "token-2", ""); err != nil {
+		t.Fatal(err)
+	}
+	endpoints, err := Mobile().ListEndpoints(ctx, u)
+	if err != nil || len(endpoints) != 2 {
+		t.Fatalf("ListEndpoints = %v, %v", endpoints, err)
+	}
+	if err := Mobile().DeleteEndpoint(ctx, u, arn); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Mobile().GetEndpoint(ctx, u, arn); err == nil {
+		t.Fatalf("expected an error for a deleted endpoint")
+	}
+}
+
+func TestMobile_OptOut(t *testing.T) {
+	fake := newFakeMobileClient()
+	fake.optedOut = []string{"+15555550100", "+15555550101"}
+	withFakeMobile(t, fake)
+	ctx := context.Background()
+	u, _ := url.Parse("sns://sms")
+
+	if out, err := Mobile().IsOptedOut(ctx, u, "+15555550100"); err != nil || !out {
+		t.Fatalf("IsOptedOut = %v, %v; want true", out, err)
+	}
+	if err := Mobile().OptIn(ctx, u, "+15555550100"); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := Mobile().IsOptedOut(ctx, u, "+15555550100"); err != nil || out {
+		t.Fatalf("IsOptedOut after OptIn = %v, %v; want false", out, err)
+	}
+	if phones, err := Mobile().ListOptedOut(ctx, u); err != nil || !slices.Equal(phones, []string{"+15555550101"}) {
+		t.Fatalf("ListOptedOut = %v, %v", phones, err)
+	}
+}
@@ -0,0 +1,372 @@
+package sns
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"oss.nandlabs.io/golly-aws/bodycodec"
+	"oss.nandlabs.io/golly/messaging"
+)
+
+// Option keys for HTTP(S) push subscribers (AddListener and PushHandler).
+const (
+	// OptListenAddr makes AddListener host the endpoint on its own server
+	// listening on this address (e.g. ":8443"). Listeners with the same
+	// address share the server.
+	OptListenAddr = "ListenAddr"
+	// OptTLSCertFile and OptTLSKeyFile make the OptListenAddr server serve
+	// HTTPS. Without them it serves plain HTTP, for use behind a
+	// TLS-terminating load balancer.
+	OptTLSCertFile = "TLSCertFile"
+	OptTLSKeyFile  = "TLSKeyFile"
+	// OptServeMux mounts the endpoint on an existing *http.ServeMux
+	// instead of hosting a server.
+	OptServeMux = "ServeMux"
+	// OptEndpointPath is the path the endpoint is served on. Default: "/"
+	// followed by the topic name.
+	OptEndpointPath = "EndpointPath"
+	// OptSkipVerification disables signature and URL checks, for local
+	// SNS emulators that do not sign messages. Never set it in production:
+	// anyone could then post messages to the endpoint.
+	OptSkipVerification = "SkipVerification"
+)
+
+// maxPushBody bounds the request bodies a push endpoint reads. SNS
+// messages are at most 256 KiB before JSON escaping.
+const maxPushBody = 1 << 20
+
+// shutdownTimeout bounds how long Close waits for push requests in flight.
+const shutdownTimeout = 30 * time.Second
+
+// pushServer is an http.Server hosting push endpoints on one address.
+type pushServer struct {
+	srv *http.Server
+	mux *http.ServeMux
+}
+
+// pushHandler serves the HTTP(S) endpoint of one SNS subscription.
+type pushHandler struct {
+	p          *Provider
+	u          *url.URL
+	listener   func(msg messaging.Message)
+	skipVerify bool
+	stopped    atomic.Bool
+}
+
+// AddListener receives the messages of the topic through an HTTP(S) push
+// subscription: it hosts the endpoint on its own server (OptListenAddr) or
+// mounts it on an existing mux (OptServeMux). See PushHandler for how
+// requests are handled. The subscription itself, pointing SNS at the
+// endpoint, is created separately. Mounting a second endpoint on the same
+// path of a mux or address fails.
+//
+// Supported options: ListenAddr, TLSCertFile, TLSKeyFile, ServeMux,
+// EndpointPath, SkipVerification.
+func (p *Provider) AddListener(u *url.URL, listener func(msg messaging.Message), options ...messaging.Option) error {
+	optResolver := messaging.NewOptionsResolver(options...)
+	h, err := p.newPushHandler(u, listener, optResolver)
+	if err != nil {
+		return err
+	}
+	path, _ := messaging.ResolveOptValue[string](OptEndpointPath, optResolver)
+	if path == "" {
+		path = "/" + topicName(u)
+	}
+
+	if v, ok := optResolver.Get(OptServeMux); ok {
+		mux, ok := v.(*http.ServeMux)
+		if !ok {
+			return fmt.Errorf("sns: %s: expected *http.ServeMux, got %T", OptServeMux, v)
+		}
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.mount(mux, path, h)
+	}
+	addr, _ := messaging.ResolveOptValue[string](OptListenAddr, optResolver)
+	if addr == "" {
+		return fmt.Errorf("sns: add listener requires the %s or %s option; SNS pushes to HTTP(S) endpoints", OptListenAddr, OptServeMux)
+	}
+	certFile, _ := messaging.ResolveOptValue[string](OptTLSCertFile, optResolver)
+	keyFile, _ := messaging.ResolveOptValue[string](OptTLSKeyFile, optResolver)
+	return p.mountOnServer(addr, path, certFile, keyFile, h)
+}
+
+// newPushHandler builds the handler of a push endpoint. The caller
+// registers it with the provider once it is in use.
+func (p *Provider) newPushHandler(u *url.URL, listener func(msg messaging.Message), optResolver *messaging.OptionsResolver) (*pushHandler, error) {
+	if topicName(u) == "" {
+		return nil, fmt.Errorf("sns: topic name or ARN is required")
+	}
+	if p.closed.Load() {
+		return nil, fmt.Errorf("sns: provider is closed")
+	}
+	skip, _ := messaging.ResolveOptValue[bool](OptSkipVerification, optResolver)
+	return &pushHandler{p: p, u: u, listener: listener, skipVerify: skip}, nil
+}
+
+// PushHandler returns the HTTP(S) endpoint of a push subscription to the
+// topic, for mounting on any router. It:
+//
+//   - verifies the signature of every request (SignatureVersion 1 and 2)
+//     against the SNS signing certificate, which is fetched once per URL
+//     and only from SNS hosts, and rejects messages of other topics;
+//   - confirms SubscriptionConfirmation requests by visiting their
+//     SubscribeURL, and logs UnsubscribeConfirmation requests;
+//   - delivers each Notification to listener as a *MessageSNS, with the
+//     message attributes as headers, and answers 200 once listener
+//     returns, or 500, so that SNS retries, if listener nacked it with
+//     Rsvp(false).
+//
+// Close stops the handler. Supported options: SkipVerification.
+func (p *Provider) PushHandler(u *url.URL, listener func(msg messaging.Message), options ...messaging.Option) (http.Handler, error) {
+	h, err := p.newPushHandler(u, listener, messaging.NewOptionsResolver(options...))
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	p.handlers = append(p.handlers, h)
+	p.mu.Unlock()
+	return h, nil
+}
+
+// mount serves h on path of mux and registers it so Close stops it. It
+// fails when the provider already mounted an endpoint there, or when mux
+// rejects the pattern. The caller holds p.mu.
+func (p *Provider) mount(mux *http.ServeMux, path string, h *pushHandler) (err error) {
+	if p.closed.Load() {
+		return fmt.Errorf("sns: provider is closed")
+	}
+	if p.mounts[mux][path] {
+		return fmt.Errorf("sns: a push endpoint is already mounted on %s", path)
+	}
+	defer func() {
+		// ServeMux.Handle panics on invalid or conflicting patterns.
+		if r := recover(); r != nil {
+			err = fmt.Errorf("sns: cannot mount push endpoint on %s: %v", path, r)
+		}
+	}()
+	mux.Handle(path, h)
+	if p.mounts == nil {
+		p.mounts = make(map[*http.ServeMux]map[string]bool)
+	}
+	if p.mounts[mux] == nil {
+		p.mounts[mux] = make(map[string]bool)
+	}
+	p.mounts[mux][path] = true
+	p.handlers = append(p.handlers, h)
+	return nil
+}
+
+// mountOnServer serves h on the server for addr, starting it if needed.
+func (p *Provider) mountOnServer(addr, path, certFile, keyFile string, h *pushHandler) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if s, ok := p.servers[addr]; ok {
+		return p.mount(s.mux, path, h)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("sns: failed to listen on %s: %w", addr, err)
+	}
+	s := &pushServer{mux: http.NewServeMux()}
+	if err := p.mount(s.mux, path, h); err != nil {
+		_ = ln.Close()
+		return err
+	}
+	s.srv = &http.Server{Handler: s.mux, ReadHeaderTimeout: 10 * time.Second}
+	if p.servers == nil {
+		p.servers = make(map[string]*pushServer)
+	}
+	p.servers[addr] = s
+	go func() {
+		var err error
+		if certFile != "" || keyFile != "" {
+			err = s.srv.ServeTLS(ln, certFile, keyFile)
+		} else {
+			err = s.srv.Serve(ln)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.ErrorF("SNS push endpoint on %s stopped: %v", addr, err)
+		}
+	}()
+	logger.InfoF("SNS push endpoint listening on %s", addr)
+	return nil
+}
+
+// Close stops every push endpoint and shuts down the servers hosting
+// them, waiting up to 30s for requests in flight.
+func (p *Provider) Close() error {
+	p.closed.Store(true)
+	p.mu.Lock()
+	handlers, servers := p.handlers, p.servers
+	p.handlers, p.servers, p.mounts = nil, nil, nil
+	p.mu.Unlock()
+
+	for _, h := range handlers {
+		h.stopped.Store(true)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	var errs []error
+	for addr, s := range servers {
+		if err := s.srv.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("sns: failed to shut down push endpoint on %s: %w", addr, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (h *pushHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.stopped.Load() {
+		http.Error(w, "listener closed", http.StatusServiceUnavailable)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var env pushEnvelope
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxPushBody)).Decode(&env); err != nil {
+		h.reject(w, http.StatusBadRequest, fmt.Errorf("sns: malformed push request: %w", err))
+		return
+	}
+	if t := r.Header.Get("x-amz-sns-message-type"); t != "" && t != env.Type {
+		h.reject(w, http.StatusBadRequest, fmt.Errorf("sns: message type header %q does not match %q", t, env.Type))
+		return
+	}
+	if !h.matchesTopic(env.TopicArn) {
+		h.reject(w, http.StatusForbidden, fmt.Errorf("sns: push from topic %s rejected by the endpoint of %s", env.TopicArn, h.u))
+		return
+	}
+	if !h.skipVerify {
+		if err := verifySignature(r.Context(), &env); err != nil {
+			h.reject(w, http.StatusForbidden, err)
+			return
+		}
+	}
+
+	switch env.Type {
+	case "SubscriptionConfirmation":
+		if !h.skipVerify {
+			if err := checkSNSURL(env.SubscribeURL); err != nil {
+				h.reject(w, http.StatusForbidden, fmt.Errorf("sns: SubscribeURL: %w", err))
+				return
+			}
+		}
+		if err := confirmSubscription(r.Context(), env.SubscribeURL); err != nil {
+			// SNS resends the confirmation request on failure.
+			h.reject(w, http.StatusInternalServerError, fmt.Errorf("sns: failed to confirm subscription to %s: %w", env.TopicArn, err))
+			return
+		}
+		logger.InfoF("SNS subscription to %s confirmed", env.TopicArn)
+	case "UnsubscribeConfirmation":
+		logger.InfoF("SNS endpoint for %s unsubscribed", env.TopicArn)
+	case "Notification":
+		msg, err := h.p.pushedMessage(h.u, &env)
+		if err != nil {
+			h.reject(w, http.StatusBadRequest, err)
+			return
+		}
+		h.p.fireOnReceive(h.u, msg, nil)
+		h.listener(msg)
+		if msg.nacked.Load() {
+			http.Error(w, "message rejected", http.StatusInternalServerError)
+			return
+		}
+	default:
+		h.reject(w, http.StatusBadRequest, fmt.Errorf("sns: unknown message type %q", env.Type))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// reject answers a request with status, reporting err to the observer.
+func (h *pushHandler) reject(w http.ResponseWriter, status int, err error) {
+	logger.WarnF("SNS push endpoint for %s: %v", h.u, err)
+	h.p.fireOnReceive(h.u, nil, err)
+	http.Error(w, http.StatusText(status), status)
+}
+
+// matchesTopic reports whether topicARN is the topic of the endpoint URL:
+// the same ARN for ARN URLs, or the same topic name.
+func (h *pushHandler) matchesTopic(topicARN string) bool {
+	if arn, ok := urlARN(h.u); ok {
+		return topicARN == arn
+	}
+	return topicARN != "" && topicARN[strings.LastIndex(topicARN, ":")+1:] == h.u.Host
+}
+
+// pushedMessage converts a Notification to a MessageSNS, decoding an
+// encoded body and turning message attributes into headers.
+func (p *Provider) pushedMessage(u *url.URL, env *pushEnvelope) (*MessageSNS, error) {
+	baseMsg, err := messaging.NewBaseMessage()
+	if err != nil {
+		return nil, err
+	}
+	ts, _ := time.Parse(time.RFC3339, env.Timestamp)
+	msg := &MessageSNS{
+		BaseMessage: baseMsg,
+		messageId:   env.MessageId,
+		provider:    p,
+		source:      u,
+		topicArn:    env.TopicArn,
+		subject:     env.Subject,
+		timestamp:   ts,
+	}
+	body := []byte(env.Message)
+	for k, a := range env.MessageAttributes {
+		switch {
+		case k == bodycodec.AttrEncoding:
+			if body, err = bodycodec.Decode(a.Value, env.Message); err != nil {
+				return nil, err
+			}
+		case a.Type == "Binary":
+			b, err := base64.StdEncoding.DecodeString(a.Value)
+			if err != nil {
+				return nil, fmt.Errorf("sns: malformed binary attribute %s: %w", k, err)
+			}
+			msg.SetHeader(k, b)
+		case strings.HasPrefix(a.Type, "Number"):
+			msg.SetNumberHeader(k, a.Value)
+		default:
+			msg.SetStrHeader(k, a.Value)
+		}
+	}
+	if _, err := msg.SetBodyBytes(body); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (p *Provider) fireOnReceive(u *url.URL, msg messaging.Message, err error) {
+	if obs := p.loadObserver(); obs != nil {
+		obs.OnReceive(u, msg, err)
+	}
+}
+
+// urlARN returns the topic ARN of an sns:///arn:... URL.
+func urlARN(u *url.URL) (string, bool) {
+	path := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" && strings.HasPrefix(path, "arn:") {
+		return path, true
+	}
+	return "", false
+}
+
+// topicName returns the topic name of an sns:// URL.
+func topicName(u *url.URL) string {
+	if arn, ok := urlARN(u); ok {
+		return arn[strings.LastIndex(arn, ":")+1:]
+	}
+	return u.Host
+}
@@ -0,0 +1,335 @@
+package sns
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"oss.nandlabs.io/golly/messaging"
+)
+
+const (
+	testTopicARN = "arn:aws:sns:us-east-1:123456789012:orders"
+	testCertURL  = "https://sns.us-east-1.amazonaws.com/SimpleNotificationService-test.pem"
+)
+
+// testSigner signs push envelopes with a self-signed certificate served in
+// place of the SNS one.
+type testSigner struct {
+	key     *rsa.PrivateKey
+	mu      sync.Mutex
+	fetches int
+	confirm []string
+}
+
+func newTestSigner(t *testing.T) *testSigner {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.amazonaws.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	s := &testSigner{key: key}
+	prevFetch, prevConfirm := fetchSigningCert, confirmSubscription
+	fetchSigningCert = func(_ context.Context, certURL string) ([]byte, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.fetches++
+		return certPEM, nil
+	}
+	confirmSubscription = func(_ context.Context, subscribeURL string) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.confirm = append(s.confirm, subscribeURL)
+		return nil
+	}
+	t.Cleanup(func() {
+		fetchSigningCert, confirmSubscription = prevFetch, prevConfirm
+		certCacheMu.Lock()
+		clear(certCache)
+		certCacheMu.Unlock()
+	})
+	return s
+}
+
+// sign fills in the signature fields of e with the given SignatureVersion.
+func (s *testSigner) sign(t *testing.T, e *pushEnvelope, version string) {
+	t.Helper()
+	e.SignatureVersion, e.SigningCertURL = version, testCertURL
+	var sig []byte
+	var err error
+	if version == "1" {
+		sum := sha1.Sum([]byte(e.stringToSign()))
+		sig, err = rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA1, sum[:])
+	} else {
+		sum := sha256.Sum256([]byte(e.stringToSign()))
+		sig, err = rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, sum[:])
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Signature = base64.StdEncoding.EncodeToString(sig)
+}
+
+func notification(id, body string) *pushEnvelope {
+	return &pushEnvelope{
+		Type:      "Notification",
+		MessageId: id,
+		TopicArn:  testTopicARN,
+		Subject:   "order",
+		Message:   body,
+		Timestamp: "2026-01-02T03:04:05.000Z",
+		MessageAttributes: map[string]pushAttribute{
+			"source": {Type: "String", Value: "checkout"},
+			"blob":   {Type: "Binary", Value: base64.StdEncoding.EncodeToString([]byte{0, 1, 2})},
+		},
+	}
+}
+
+func post(t *testing.T, h http.Handler, e *pushEnvelope) int {
+	t.Helper()
+	body, _ := json.Marshal(e)
+	req := httptest.NewRequest(http.MethodPost, "/orders", bytes.NewReader(body))
+	req.Header.Set("x-amz-sns-message-type", e.Type)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestPush_VerifiesAndDeliversNotifications(t *testing.T) {
+	signer := newTestSigner(t)
+	p := &Provider{}
+	u, _ := url.Parse("sns://orders")
+	var got []*MessageSNS
+	h, err := p.PushHandler(u, func(msg messaging.Message) {
+		got = append(got, msg.(*MessageSNS))
+	})
+	if err != nil {
+		t.Fatalf("PushHandler: %v", err)
+	}
+
+	for i, version := range []string{"1", "2"} {
+		e := notification("m"+version, "hello")
+		signer.sign(t, e, version)
+		if code := post(t, h, e); code != http.StatusOK || len(got) != i+1 {
+			t.Fatalf("SignatureVersion %s: status %d, %d delivered", version, code, len(got))
+		}
+	}
+	msg := got[0]
+	if msg.ReadAsStr() != "hello" || msg.SNSMessageId() != "m1" || msg.TopicArn() != testTopicARN || msg.Subject() != "order" || msg.Timestamp().IsZero() {
+		t.Fatalf("message = %q %s %s %s %s", msg.ReadAsStr(), msg.SNSMessageId(), msg.TopicArn(), msg.Subject(), msg.Timestamp())
+	}
+	if v, _ := msg.GetStrHeader("source"); v != "checkout" {
+		t.Fatalf("source header = %q", v)
+	}
+	if v, _ := msg.GetHeader("blob"); !bytes.Equal(v, []byte{0, 1, 2}) {
+		t.Fatalf("blob header = %v", v)
+	}
+	if signer.fetches != 1 {
+		t.Fatalf("certificate fetched %d times, want 1", signer.fetches)
+	}
+
+	tampered := notification("m3", "hello")
+	signer.sign(t, tampered, "2")
+	tampered.Message = "goodbye"
+	if code := post(t, h, tampered); code != http.StatusForbidden {
+		t.Fatalf("tampered message: status %d", code)
+	}
+	other := notification("m4", "hello")
+	other.TopicArn = "arn:aws:sns:us-east-1:999999999999:orders-evil"
+	signer.sign(t, other, "2")
+	if code := post(t, h, other); code != http.StatusForbidden {
+		t.Fatalf("foreign topic: status %d", code)
+	}
+	if len(got) != 2 {
+		t.Fatalf("%d messages delivered, want 2", len(got))
+	}
+}
+
+func TestPush_RejectsForeignCertificateURL(t *testing.T) {
+	signer := newTestSigner(t)
+	p := &Provider{}
+	u, _ := url.Parse("sns:///" + testTopicARN)
+	h, _ := p.PushHandler(u, func(messaging.Message) { t.Fatalf("delivered") })
+
+	for _, certURL := range []string{
+		"http://sns.us-east-1.amazonaws.com/cert.pem",
+		"https://sns.us-east-1.amazonaws.com.evil.example/cert.pem",
+		"https://evil.example/sns.us-east-1.amazonaws.com.pem",
+	} {
+		e := notification("m1", "hello")
+		signer.sign(t, e, "1")
+		e.SigningCertURL = certURL
+		if code := post(t, h, e); code != http.StatusForbidden {
+			t.Fatalf("%s: status %d", certURL, code)
+		}
+	}
+	if signer.fetches != 0 {
+		t.Fatalf("fetched a certificate from a foreign host")
+	}
+}
+
+func TestPush_SubscriptionLifecycle(t *testing.T) {
+	signer := newTestSigner(t)
+	p := &Provider{}
+	u, _ := url.Parse("sns://orders")
+	h, _ := p.PushHandler(u, func(messaging.Message) { t.Fatalf("delivered") })
+
+	confirm := &pushEnvelope{
+		Type:         "SubscriptionConfirmation",
+		MessageId:    "c1",
+		Token:        "tok",
+		TopicArn:     testTopicARN,
+		Message:      "You have chosen to subscribe",
+		SubscribeURL: "https://sns.us-east-1.amazonaws.com/?Action=ConfirmSubscription&Token=tok",
+		Timestamp:    "2026-01-02T03:04:05.000Z",
+	}
+	signer.sign(t, confirm, "2")
+	if code := post(t, h, confirm); code != http.StatusOK {
+		t.Fatalf("confirmation: status %d", code)
+	}
+	if len(signer.confirm) != 1 || signer.confirm[0] != confirm.SubscribeURL {
+		t.Fatalf("confirmed %v", signer.confirm)
+	}
+
+	forged := *confirm
+	forged.SubscribeURL = "https://internal.example/admin"
+	signer.sign(t, &forged, "2")
+	if code := post(t, h, &forged); code != http.StatusForbidden || len(signer.confirm) != 1 {
+		t.Fatalf("forged confirmation: status %d, confirmed %v", code, signer.confirm)
+	}
+
+	unsub := *confirm
+	unsub.Type = "UnsubscribeConfirmation"
+	signer.sign(t, &unsub, "2")
+	if code := post(t, h, &unsub); code != http.StatusOK || len(signer.confirm) != 1 {
+		t.Fatalf("unsubscribe: status %d", code)
+	}
+}
+
+func TestAddListener_ServeMuxNackAndClose(t *testing.T) {
+	signer := newTestSigner(t)
+	p := &Provider{}
+	u, _ := url.Parse("sns://orders")
+	mux := http.NewServeMux()
+	opts := messaging.NewOptionsBuilder().Add(OptServeMux, mux).Build()
+	err := p.AddListener(u, func(msg messaging.Message) {
+		_ = msg.Rsvp(msg.ReadAsStr() != "bad")
+	}, opts...)
+	if err != nil {
+		t.Fatalf("AddListener: %v", err)
+	}
+
+	good, bad := notification("m1", "good"), notification("m2", "bad")
+	signer.sign(t, good, "2")
+	signer.sign(t, bad, "2")
+	if code := post(t, mux, good); code != http.StatusOK {
+		t.Fatalf("acked message: status %d", code)
+	}
+	if code := post(t, mux, bad); code != http.StatusInternalServerError {
+		t.Fatalf("nacked message: status %d", code)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if code := post(t, mux, good); code != http.StatusServiceUnavailable {
+		t.Fatalf("after Close: status %d", code)
+	}
+	if err := p.AddListener(u, func(messaging.Message) {}, opts...); err == nil {
+		t.Fatalf("AddListener succeeded on a closed provider")
+	}
+}
+
+func TestAddListener_DuplicatePath(t *testing.T) {
+	p := &Provider{}
+	u, _ := url.Parse("sns://orders")
+	mux := http.NewServeMux()
+	mux.Handle("/health", http.NotFoundHandler())
+	opts := messaging.NewOptionsBuilder().Add(OptServeMux, mux).Build()
+	if err := p.AddListener(u, func(messaging.Message) {}, opts...); err != nil {
+		t.Fatalf("AddListener: %v", err)
+	}
+	err := p.AddListener(u, func(messaging.Message) {}, opts...)
+	if err == nil || !strings.Contains(err.Error(), "already mounted on /orders") {
+		t.Fatalf("second AddListener = %v", err)
+	}
+	// A path the application registered itself is refused as well.
+	clash := messaging.NewOptionsBuilder().Add(OptServeMux, mux).Add(OptEndpointPath, "/health").Build()
+	if err := p.AddListener(u, func(messaging.Message) {}, clash...); err == nil {
+		t.Fatalf("AddListener on an application path succeeded")
+	}
+	// Only the mounted endpoint is registered for Close.
+	if len(p.handlers) != 1 {
+		t.Fatalf("registered handlers = %d, want 1", len(p.handlers))
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+func TestAddListener_ListenAddr(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+
+	p := &Provider{}
+	u, _ := url.Parse("sns://orders")
+	delivered := make(chan string, 1)
+	opts := messaging.NewOptionsBuilder().
+		Add(OptListenAddr, addr).
+		Add(OptEndpointPath, "/hooks/orders").
+		Add(OptSkipVerification, true).
+		Build()
+	if err := p.AddListener(u, func(msg messaging.Message) { delivered <- msg.ReadAsStr() }, opts...); err != nil {
+		t.Fatalf("AddListener: %v", err)
+	}
+	body, _ := json.Marshal(notification("m1", "hello"))
+	resp, err := http.Post("http://"+addr+"/hooks/orders", "text/plain", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || <-delivered != "hello" {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := http.Post("http://"+addr+"/hooks/orders", "text/plain", bytes.NewReader(body)); err == nil {
+		t.Fatalf("endpoint still served after Close")
+	}
+}
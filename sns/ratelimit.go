@@ -0,0 +1,51 @@
+package sns
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"oss.nandlabs.io/golly-aws/ratelimit"
+)
+
+// RateLimits holds the rate limiter configs of topics, keyed by topic name
+// (the sns:// URL host, or the name in a topic ARN). Send and SendBatch
+// wait for the limiter of their topic, if any, before publishing:
+//
+//	sns.RateLimits.Set("orders", ratelimit.Config{Rate: 100, Burst: 20})
+//
+// Each topic ARN gets a limiter of its own, so same-named topics of
+// different accounts or regions are paced apart. Messages sent to a
+// PhoneNumber or TargetArn are paced by the config of their URL too, with a
+// limiter per target ARN, or per AWS config for phone numbers.
+var RateLimits = ratelimit.NewRegistry()
+
+// pace waits until the limiter of target, the topic or target ARN the
+// messages go to or the topicKey of u for phone numbers, admits messages of
+// the given groups, one per message, and tells a ratelimit.Observer about
+// it. It returns the limiter, nil when the topic is not rate limited.
+func (p *Provider) pace(ctx context.Context, u *url.URL, target any, groups []string) (*ratelimit.Limiter, error) {
+	limiter := RateLimits.LimiterFor(topicName(u), target)
+	if limiter == nil {
+		return nil, nil
+	}
+	waited, err := limiter.WaitBatch(ctx, groups)
+	if err != nil {
+		return limiter, fmt.Errorf("sns: rate limit wait for %s failed: %w", topicName(u), err)
+	}
+	if ro, ok := p.loadObserver().(ratelimit.Observer); ok {
+		ro.OnRateWait(u, len(groups), waited, limiter.Rate(), limiter.GroupRate())
+	}
+	return limiter, nil
+}
+
+// throttled lowers the rates of limiter after SNS reported throttling.
+func (p *Provider) throttled(u *url.URL, limiter *ratelimit.Limiter) {
+	if !limiter.Throttled() {
+		return
+	}
+	logger.WarnF("SNS throttled publishing to %s; lowered the rate to %.2f/s", topicName(u), limiter.Rate())
+	if ro, ok := p.loadObserver().(ratelimit.Observer); ok {
+		ro.OnRateThrottled(u, limiter.Rate(), limiter.GroupRate())
+	}
+}
@@ -0,0 +1,78 @@
+package sns
+
+import (
+	"context"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"oss.nandlabs.io/golly-aws/ratelimit"
+)
+
+// rateObserver records the rate limiting hooks on top of sendsObserver.
+type rateObserver struct {
+	sendsObserver
+	waits     []int
+	throttled []float64
+}
+
+func (o *rateObserver) OnRateWait(_ *url.URL, n int, _ time.Duration, _, _ float64) {
+	o.waits = append(o.waits, n)
+}
+
+func (o *rateObserver) OnRateThrottled(_ *url.URL, rate, _ float64) {
+	o.throttled = append(o.throttled, rate)
+}
+
+func TestSendBatchCtx_RateLimit(t *testing.T) {
+	if err := RateLimits.Set("paced", ratelimit.Config{Rate: 200, Burst: 2}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	t.Cleanup(func() { RateLimits.Remove("paced") })
+	client := &fakeBatchClient{}
+	client.batchFn = func(in *sns.PublishBatchInput) (*sns.PublishBatchOutput, error) {
+		out := &sns.PublishBatchOutput{}
+		for _, e := range in.PublishBatchRequestEntries {
+			if len(client.batchCalls) == 1 && *e.Id == "msg-1" {
+				out.Failed = append(out.Failed, types.BatchResultErrorEntry{
+					Id: e.Id, Code: strPtr("Throttling"), Message: strPtr("Rate exceeded"), SenderFault: true,
+				})
+				continue
+			}
+			out.Successful = append(out.Successful, types.PublishBatchResultEntry{Id: e.Id, MessageId: e.Id})
+		}
+		return out, nil
+	}
+	withFakeBatchClient(t, client)
+	p := &Provider{}
+	obs := &rateObserver{}
+	p.SetObserver(obs)
+	u, _ := url.Parse("sns:///arn:aws:sns:us-east-1:123456789012:paced")
+
+	// Four messages with a burst of 2 overdraw the bucket by two tokens,
+	// which the resent entry waits for: 10ms at 200/s, or more once the
+	// throttling halved the rate.
+	start := time.Now()
+	if err := p.SendBatchCtx(context.Background(), u, newBatchMessages(t, p, 4)); err != nil {
+		t.Fatalf("SendBatchCtx: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 10*time.Millisecond {
+		t.Fatalf("resent entry was not paced: %v", elapsed)
+	}
+	if want := []int{4, 1}; !slices.Equal(obs.waits, want) {
+		t.Fatalf("OnRateWait batch sizes = %v, want %v", obs.waits, want)
+	}
+	if len(obs.throttled) != 1 || obs.throttled[0] < 100 || obs.throttled[0] > 105 {
+		t.Fatalf("OnRateThrottled = %v, want about [100]", obs.throttled)
+	}
+	// A topic of the same name in another region keeps its own rates.
+	if got := RateLimits.LimiterFor("paced", "arn:aws:sns:us-west-2:123456789012:paced").Rate(); got != 200 {
+		t.Fatalf("rate of the us-west-2 paced topic = %v, want 200", got)
+	}
+	if got := RateLimits.LimiterFor("paced", "arn:aws:sns:us-east-1:123456789012:paced").Rate(); got >= 200 {
+		t.Fatalf("rate of the throttled topic = %v", got)
+	}
+}
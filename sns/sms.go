@@ -0,0 +1,162 @@
+package sns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"oss.nandlabs.io/golly/messaging"
+)
+
+// SMS delivery options. They apply to SMS sent to OptPhoneNumber and to the
+// SMS subscribers of a topic.
+const (
+	// OptSMSSenderID is the alphanumeric sender ID shown on the recipient's
+	// device: 1-11 letters and digits, at least one of them a letter. Not
+	// every country supports sender IDs.
+	OptSMSSenderID = "SMSSenderID"
+	// OptSMSType is the SMSType of the message: SMSTransactional or
+	// SMSPromotional. It overrides the account's DefaultSMSType.
+	OptSMSType = "SMSType"
+	// OptSMSOriginationNumber is the E.164 number, registered with the
+	// account, to send the message from.
+	OptSMSOriginationNumber = "SMSOriginationNumber"
+	// OptSMSMaxPrice is the most, in USD, to spend on the message as a
+	// float64. SNS does not send messages that would cost more.
+	OptSMSMaxPrice = "SMSMaxPrice"
+	// OptCheckOptOut makes Send look the OptPhoneNumber up in the opt-out
+	// list before publishing, and fail with ErrOptedOut for a number that
+	// opted out, instead of SNS dropping the message silently.
+	OptCheckOptOut = "CheckOptOut"
+)
+
+// SMSType is the delivery class of an SMS message.
+type SMSType string
+
+const (
+	// SMSTransactional is for critical messages such as one-time
+	// passwords; they are delivered with the highest reliability.
+	SMSTransactional SMSType = "Transactional"
+	// SMSPromotional is for non-critical messages such as marketing; they
+	// are delivered at the lowest cost.
+	SMSPromotional SMSType = "Promotional"
+)
+
+// SNS message attributes that carry the SMS delivery options.
+const (
+	attrSMSSenderID          = "AWS.SNS.SMS.SenderID"
+	attrSMSType              = "AWS.SNS.SMS.SMSType"
+	attrSMSOriginationNumber = "AWS.MM.SMS.OriginationNumber"
+	attrSMSMaxPrice          = "AWS.SNS.SMS.MaxPrice"
+)
+
+var (
+	// ErrOptedOut is returned by Send, with OptCheckOptOut, for a phone
+	// number that opted out of receiving SMS.
+	ErrOptedOut = errors.New("sns: phone number has opted out of SMS")
+	// ErrEndpointDisabled is returned by Send to an OptTargetArn platform
+	// endpoint that is disabled, e.g. because the platform reported its
+	// device token as invalid. Register the device again to enable it.
+	ErrEndpointDisabled = errors.New("sns: platform endpoint is disabled")
+)
+
+var (
+	senderIDPattern = regexp.MustCompile(`^[A-Za-z0-9]{1,11}$`)
+	letterPattern   = regexp.MustCompile(`[A-Za-z]`)
+	e164Pattern     = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+)
+
+// smsAttributes returns the message attributes of the SMS options, or nil
+// when none is set.
+func smsAttributes(optResolver *messaging.OptionsResolver) (map[string]types.MessageAttributeValue, error) {
+	attrs := map[string]types.MessageAttributeValue{}
+	if v, ok := optResolver.Get(OptSMSSenderID); ok {
+		id, _ := v.(string)
+		if !senderIDPattern.MatchString(id) || !letterPattern.MatchString(id) {
+			return nil, fmt.Errorf("sns: %s %q must be 1-11 letters and digits with at least one letter", OptSMSSenderID, id)
+		}
+		attrs[attrSMSSenderID] = stringAttribute(id)
+	}
+	if v, ok := optResolver.Get(OptSMSType); ok {
+		var smsType SMSType
+		switch t := v.(type) {
+		case SMSType:
+			smsType = t
+		case string:
+			smsType = SMSType(t)
+		}
+		if smsType != SMSTransactional && smsType != SMSPromotional {
+			return nil, fmt.Errorf("sns: %s must be %s or %s, got %v", OptSMSType, SMSTransactional, SMSPromotional, v)
+		}
+		attrs[attrSMSType] = stringAttribute(string(smsType))
+	}
+	if v, ok := optResolver.Get(OptSMSOriginationNumber); ok {
+		number, _ := v.(string)
+		if !e164Pattern.MatchString(number) {
+			return nil, fmt.Errorf("sns: %s %q is not an E.164 phone number", OptSMSOriginationNumber, number)
+		}
+		attrs[attrSMSOriginationNumber] = stringAttribute(number)
+	}
+	if v, ok := optResolver.Get(OptSMSMaxPrice); ok {
+		price, isFloat := v.(float64)
+		if !isFloat || price <= 0 {
+			return nil, fmt.Errorf("sns: %s must be a positive float64, got %v", OptSMSMaxPrice, v)
+		}
+		attrs[attrSMSMaxPrice] = types.MessageAttributeValue{
+			DataType:    strPtr("Number"),
+			StringValue: strPtr(strconv.FormatFloat(price, 'f', -1, 64)),
+		}
+	}
+	if len(attrs) == 0 {
+		return nil, nil
+	}
+	return attrs, nil
+}
+
+// withSMSAttributes adds the SMS attributes to attrs, which may be nil or
+// shared with other entries, and returns the result.
+func withSMSAttributes(attrs, sms map[string]types.MessageAttributeValue) map[string]types.MessageAttributeValue {
+	if len(sms) == 0 {
+		return attrs
+	}
+	out := make(map[string]types.MessageAttributeValue, len(attrs)+len(sms))
+	maps.Copy(out, attrs)
+	maps.Copy(out, sms)
+	return out
+}
+
+func stringAttribute(s string) types.MessageAttributeValue {
+	return types.MessageAttributeValue{DataType: strPtr("String"), StringValue: &s}
+}
+
+// optOutAPI is the subset of the SNS client used to check the opt-out list.
+type optOutAPI interface {
+	CheckIfPhoneNumberIsOptedOut(ctx context.Context, params *sns.CheckIfPhoneNumberIsOptedOutInput, optFns ...func(*sns.Options)) (*sns.CheckIfPhoneNumberIsOptedOutOutput, error)
+}
+
+// checkOptedOut returns an error wrapping ErrOptedOut when phone opted out.
+func checkOptedOut(ctx context.Context, client optOutAPI, phone string) error {
+	out, err := client.CheckIfPhoneNumberIsOptedOut(ctx, &sns.CheckIfPhoneNumberIsOptedOutInput{PhoneNumber: &phone})
+	if err != nil {
+		return fmt.Errorf("sns: failed to check opt-out of %s: %w", phone, err)
+	}
+	if out.IsOptedOut {
+		return fmt.Errorf("%w: %s", ErrOptedOut, phone)
+	}
+	return nil
+}
+
+// publishError wraps a Publish error, marking one for a disabled platform
+// endpoint with ErrEndpointDisabled.
+func publishError(err error) error {
+	var disabled *types.EndpointDisabledException
+	if errors.As(err, &disabled) {
+		return fmt.Errorf("%w: %w", ErrEndpointDisabled, err)
+	}
+	return fmt.Errorf("sns: publish failed: %w", err)
+}
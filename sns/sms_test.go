@@ -0,0 +1,109 @@
+package sns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"testing"
+
+	"oss.nandlabs.io/golly/messaging"
+)
+
+func TestSendCtx_SMSAttributes(t *testing.T) {
+	srv := newSNSFakeServer()
+	defer srv.Close()
+	registerFakeSNS(t, "sns", srv.URL)
+	p := &Provider{}
+	u, _ := url.Parse("sns:///arn:aws:sns:us-east-1:123456789012:alerts")
+
+	msg, _ := p.NewMessage(SNSScheme)
+	_, _ = msg.SetBodyStr("Your code is 123456")
+	opts := messaging.NewOptionsBuilder().
+		Add(OptPhoneNumber, "+15555550100").
+		Add(OptSMSSenderID, "Golly").
+		Add(OptSMSType, SMSTransactional).
+		Add(OptSMSOriginationNumber, "+15555550199").
+		Add(OptSMSMaxPrice, 0.5).
+		Build()
+	if err := p.SendCtx(context.Background(), u, msg, opts...); err != nil {
+		t.Fatalf("SendCtx: %v", err)
+	}
+	if err := p.SendBatchCtx(context.Background(), u, []messaging.Message{msg}, messaging.NewOptionsBuilder().Add(OptSMSType, "Promotional").Build()...); err != nil {
+		t.Fatalf("SendBatchCtx: %v", err)
+	}
+
+	attrs := map[string][2]string{}
+	form := srv.captured()[0].Form
+	for i := 1; form.Has(fmt.Sprintf("MessageAttributes.entry.%d.Name", i)); i++ {
+		attrs[form.Get(fmt.Sprintf("MessageAttributes.entry.%d.Name", i))] = [2]string{
+			form.Get(fmt.Sprintf("MessageAttributes.entry.%d.Value.DataType", i)),
+			form.Get(fmt.Sprintf("MessageAttributes.entry.%d.Value.StringValue", i)),
+		}
+	}
+	want := map[string][2]string{
+		"AWS.SNS.SMS.SenderID":         {"String", "Golly"},
+		"AWS.SNS.SMS.SMSType":          {"String", "Transactional"},
+		"AWS.MM.SMS.OriginationNumber": {"String", "+15555550199"},
+		"AWS.SNS.SMS.MaxPrice":         {"Number", "0.5"},
+	}
+	for k, v := range want {
+		if attrs[k] != v {
+			t.Errorf("Publish attribute %s = %v, want %v", k, attrs[k], v)
+		}
+	}
+	batch := srv.captured()[1].Form
+	if got := batch.Get("PublishBatchRequestEntries.member.1.MessageAttributes.entry.1.Value.StringValue"); got != "Promotional" {
+		t.Fatalf("PublishBatch SMSType = %q", got)
+	}
+
+	for name, opt := range map[string][2]any{
+		"sender ID too long":    {OptSMSSenderID, "GollyMessaging"},
+		"sender ID digits only": {OptSMSSenderID, "12345"},
+		"unknown SMS type":      {OptSMSType, "Urgent"},
+		"local origination":     {OptSMSOriginationNumber, "5555550199"},
+		"max price as string":   {OptSMSMaxPrice, "0.5"},
+	} {
+		opts := messaging.NewOptionsBuilder().Add(OptPhoneNumber, "+15555550100").Add(opt[0].(string), opt[1]).Build()
+		if err := p.SendCtx(context.Background(), u, msg, opts...); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if n := len(srv.captured()); n != 2 {
+		t.Fatalf("invalid options reached SNS: %d requests", n)
+	}
+}
+
+func TestSendCtx_OptOutAndDisabledEndpoint(t *testing.T) {
+	srv := newSNSFakeServer()
+	srv.optedOut = []string{"+15555550100"}
+	srv.disabledEndpoint = "arn:aws:sns:us-east-1:123456789012:endpoint/GCM/app/1"
+	defer srv.Close()
+	registerFakeSNS(t, "sns", srv.URL)
+	p := &Provider{}
+	u, _ := url.Parse("sns:///arn:aws:sns:us-east-1:123456789012:alerts")
+	msg, _ := p.NewMessage(SNSScheme)
+	_, _ = msg.SetBodyStr("hello")
+	ctx := context.Background()
+
+	err := p.SendCtx(ctx, u, msg, messaging.NewOptionsBuilder().Add(OptCheckOptOut, true).Add(OptPhoneNumber, "+15555550100").Build()...)
+	if !errors.Is(err, ErrOptedOut) {
+		t.Fatalf("opted-out number: err = %v, want ErrOptedOut", err)
+	}
+	if err := p.SendCtx(ctx, u, msg, messaging.NewOptionsBuilder().Add(OptCheckOptOut, true).Add(OptPhoneNumber, "+15555550101").Build()...); err != nil {
+		t.Fatalf("subscribed number: %v", err)
+	}
+	// Without the option the number is not checked.
+	if err := p.SendCtx(ctx, u, msg, messaging.NewOptionsBuilder().Add(OptPhoneNumber, "+15555550100").Build()...); err != nil {
+		t.Fatalf("unchecked number: %v", err)
+	}
+	if got, want := srv.actions(), []string{"CheckIfPhoneNumberIsOptedOut", "CheckIfPhoneNumberIsOptedOut", "Publish", "Publish"}; !slices.Equal(got, want) {
+		t.Fatalf("actions = %v, want %v", got, want)
+	}
+
+	err = p.SendCtx(ctx, u, msg, messaging.NewOptionsBuilder().Add(OptTargetArn, srv.disabledEndpoint).Build()...)
+	if !errors.Is(err, ErrEndpointDisabled) {
+		t.Fatalf("disabled endpoint: err = %v, want ErrEndpointDisabled", err)
+	}
+}
@@ -3,13 +3,16 @@ package sns
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/sns"
 	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"oss.nandlabs.io/golly-aws/ratelimit"
 	"oss.nandlabs.io/golly/messaging"
 )
 
@@ -22,19 +25,69 @@ const (
 	// OptMessageDeduplicationId is the deduplication ID for FIFO topics.
 	OptMessageDeduplicationId = "MessageDeduplicationId"
 	// OptMessageStructure when set to "json" enables per-protocol message formatting.
-	// The message body must be a JSON object with protocol keys (e.g., "default", "sqs", "email").
+	// The message body must be a JSON object with protocol keys (e.g., "default", "sqs", "email");
+	// MessageSNS.SetProtocolMessages builds one and sets the option implicitly.
 	OptMessageStructure = "MessageStructure"
 	// OptPhoneNumber publishes an SMS message directly to a phone number (instead of a topic).
 	OptPhoneNumber = "PhoneNumber"
 	// OptTargetArn publishes to a specific subscription ARN (endpoint ARN).
 	OptTargetArn = "TargetArn"
+	// OptBodyEncoding selects how message bodies are published:
+	// bodycodec.Raw (the default), bodycodec.Base64, bodycodec.Auto or the
+	// name of a codec registered with bodycodec.Register. The sqs provider
+	// decodes such bodies when they reach a subscribed queue.
+	OptBodyEncoding = "BodyEncoding"
 )
 
 var snsSchemes = []string{SNSScheme}
 
+// publishAPI is the subset of the SNS client used by Send and SendBatch.
+// The concrete *sns.Client satisfies it.
+type publishAPI interface {
+	topicAPI
+	optOutAPI
+	Publish(ctx context.Context, params *sns.PublishInput, optFns ...func(*sns.Options)) (*sns.PublishOutput, error)
+	PublishBatch(ctx context.Context, params *sns.PublishBatchInput, optFns ...func(*sns.Options)) (*sns.PublishBatchOutput, error)
+}
+
+// resolvePublishClient returns the client Send and SendBatch publish with.
+// It is a package-level var for test injection.
+var resolvePublishClient = func(u *url.URL) (publishAPI, error) {
+	return getSNSClient(u)
+}
+
+// API is the subset of the AWS SNS client surface the provider and
+// TopicAdmin rely on. The concrete *sns.Client satisfies it. See UseClient.
+type API interface {
+	publishAPI
+	snsAdminAPI
+}
+
+var _ API = (*sns.Client)(nil)
+
+// UseClient makes every Provider and the TopicAdmin publish and manage
+// topics through client, and look up subscribed queues through queues,
+// instead of the AWS SDK, until restore is called. Topic names resolve to
+// ARNs starting with arnPrefix ("arn:aws:sns:<region>:<account>:"). It is
+// meant for tests, e.g. with the Emulator of the snstest package, and is
+// not safe to call while messages are in flight.
+func UseClient(client API, queues QueueAPI, arnPrefix string) (restore func()) {
+	prevPublish, prevAdmin, prevQueue, prevPrefix := resolvePublishClient, resolveAdminClient, resolveQueueClient, topicARNPrefix
+	resolvePublishClient = func(*url.URL) (publishAPI, error) { return client, nil }
+	resolveAdminClient = func(*url.URL) (snsAdminAPI, error) { return client, nil }
+	resolveQueueClient = func(*url.URL, string) (QueueAPI, error) { return queues, nil }
+	topicARNPrefix = func(context.Context, *url.URL) (string, error) { return arnPrefix, nil }
+	clearTopicARNs()
+	return func() {
+		resolvePublishClient, resolveAdminClient, resolveQueueClient, topicARNPrefix = prevPublish, prevAdmin, prevQueue, prevPrefix
+		clearTopicARNs()
+	}
+}
+
 // Provider implements the messaging.Provider interface for AWS SNS.
-// SNS is a publish-only service, so Receive, ReceiveBatch, and AddListener
-// return an unsupported operation error.
+// SNS pushes messages rather than letting them be pulled, so Receive and
+// ReceiveBatch return an unsupported operation error, while AddListener
+// serves an HTTP(S) push subscription endpoint.
 //
 // Provider additionally implements the following v1.7.0 messaging
 // capabilities:
@@ -53,12 +106,19 @@ var snsSchemes = []string{SNSScheme}
 // (non-FIFO) topics the routing key is ignored silently.
 //
 // The ReceiverCtx capability is intentionally not implemented — SNS
-// has no publisher-side receive semantics; use the SNS→SQS fan-out
-// pattern with the sqs package for context-aware receives.
+// pushes messages to subscribers; use AddListener or PushHandler for an
+// HTTP(S) subscription, or the SNS→SQS fan-out pattern with the sqs
+// package for context-aware receives.
 type Provider struct {
 	// observer is loaded atomically at each hook site so SetObserver
 	// is safe to call concurrently with in-flight Publish calls.
 	observer atomic.Value // holds messaging.Observer (may be nil)
+
+	closed   atomic.Bool
+	mu       sync.Mutex
+	handlers []*pushHandler                     // push endpoints, stopped by Close
+	servers  map[string]*pushServer             // listen address → push server
+	mounts   map[*http.ServeMux]map[string]bool // paths of the mounted push endpoints
 }
 
 // SetObserver installs (or clears, with nil) the metrics / tracing
@@ -140,11 +200,12 @@ func (p *Provider) NewMessage(scheme string, options ...messaging.Option) (messa
 //
 // URL formats:
 //
-//	sns://topic-name                           → resolves ARN via CreateTopic (idempotent)
+//	sns://topic-name                           → looks the ARN up (cached); see OptCreateTopic
 //	sns:///arn:aws:sns:region:account:topic     → uses ARN directly
 //
 // Supported options: Subject, MessageGroupId, MessageDeduplicationId,
-// MessageStructure, PhoneNumber, TargetArn.
+// MessageStructure, PhoneNumber, TargetArn, CreateTopic, the SMS options
+// (SMSSenderID, SMSType, SMSOriginationNumber, SMSMaxPrice) and CheckOptOut.
 //
 // Send delegates to SendCtx with a background context. Callers that
 // need cancellation / deadline support should call SendCtx directly.
@@ -171,7 +232,7 @@ func (p *Provider) SendCtx(ctx context.Context, u *url.URL, msg messaging.Messag
 // sendCtx implements the Publish flow; SendCtx wraps it with observer
 // hooks so early returns are still observed.
 func (p *Provider) sendCtx(ctx context.Context, u *url.URL, msg messaging.Message, options ...messaging.Option) error {
-	client, err := getSNSClient(u)
+	client, err := resolvePublishClient(u)
 	if err != nil {
 		return err
 	}
@@ -186,30 +247,51 @@ func (p *Provider) sendCtx(ctx context.Context, u *url.URL, msg messaging.Messag
 		return err
 	}
 
+	// Encode the body and build message attributes from headers
+	encoding, _ := messaging.ResolveOptValue[string](OptBodyEncoding, optResolver)
+	body, attrs, err := encodeBody(msg, encoding, buildMessageAttributes(msg))
+	if err != nil {
+		return err
+	}
+	sms, err := smsAttributes(optResolver)
+	if err != nil {
+		return err
+	}
+	attrs = withSMSAttributes(attrs, sms)
 	input := &sns.PublishInput{
-		Message: strPtr(msg.ReadAsStr()),
+		Message:           &body,
+		MessageAttributes: attrs,
 	}
 
-	// Build message attributes from headers
-	input.MessageAttributes = buildMessageAttributes(msg)
-
 	// Determine target: phone number, target ARN, or topic ARN. Only
 	// the topic ARN path can be FIFO, so Keyed → MessageGroupId
 	// mapping only applies there.
 	var topicARN string
+	var target any = topicKeyOf(u)
 	usingTopic := false
 	if v, ok := optResolver.Get(OptPhoneNumber); ok {
 		phone := v.(string)
 		input.PhoneNumber = &phone
+		if check, _ := messaging.ResolveOptValue[bool](OptCheckOptOut, optResolver); check {
+			if err := checkOptedOut(ctx, client, phone); err != nil {
+				return err
+			}
+		}
 	} else if v, ok := optResolver.Get(OptTargetArn); ok {
 		targetArn := v.(string)
 		input.TargetArn = &targetArn
+		target = targetArn
 	} else {
-		topicARN, err = resolveTopicARN(client, u)
+		create, err := resolveCreateTopic(optResolver)
+		if err != nil {
+			return err
+		}
+		topicARN, err = resolveTopicARN(ctx, client, u, create)
 		if err != nil {
 			return err
 		}
 		input.TopicArn = &topicARN
+		target = topicARN
 		usingTopic = true
 	}
 
@@ -226,9 +308,11 @@ func (p *Provider) sendCtx(ctx context.Context, u *url.URL, msg messaging.Messag
 		dedupId := v.(string)
 		input.MessageDeduplicationId = &dedupId
 	}
-	if v, ok := optResolver.Get(OptMessageStructure); ok {
-		structure := v.(string)
+	if structure, ok := messageStructure(msg, optResolver); ok {
 		input.MessageStructure = &structure
+		if err := checkStructureEncoding(structure, attrs); err != nil {
+			return err
+		}
 	}
 
 	// Keyed → FIFO MessageGroupId. Explicit option wins; otherwise map
@@ -241,14 +325,25 @@ func (p *Provider) sendCtx(ctx context.Context, u *url.URL, msg messaging.Messag
 		input.MessageGroupId = &groupId
 	}
 
+	limiter, err := p.pace(ctx, u, target, []string{safeDeref(input.MessageGroupId)})
+	if err != nil {
+		return err
+	}
 	output, err := client.Publish(ctx, input)
 	if err != nil {
-		return fmt.Errorf("sns: publish failed: %w", err)
+		if usingTopic && isTopicMissing(err) {
+			forgetTopicARN(u)
+		}
+		if ratelimit.IsThrottle(err) {
+			p.throttled(u, limiter)
+		}
+		return publishError(err)
 	}
 
 	// Store the SNS message ID on the message if it's a MessageSNS
 	if snsMsg, ok := msg.(*MessageSNS); ok && output.MessageId != nil {
 		snsMsg.messageId = *output.MessageId
+		snsMsg.sequenceNumber = safeDeref(output.SequenceNumber)
 	}
 
 	logger.InfoF("SNS message published, MessageId: %s", safeDeref(output.MessageId))
@@ -279,30 +374,87 @@ func (p *Provider) SendBatch(u *url.URL, msgs []messaging.Message, options ...me
 // to "default" when empty). An explicit MessageGroupId option applies
 // uniformly to every entry and wins over the Keyed value.
 //
-// The observer's OnSend fires once per underlying PublishBatch call
-// (i.e. per chunk of up to 10 messages) with the batch's first
-// message so callers can attribute the latency to the URL.
+// Entries that SNS rejects for a retriable reason, such as throttling, are
+// resent up to OptBatchRetries times. If some messages still fail, every
+// chunk is attempted and a *BatchError listing the failed messages is
+// returned. Use SendBatchResults for the outcome of every message.
 func (p *Provider) SendBatchCtx(ctx context.Context, u *url.URL, msgs []messaging.Message, options ...messaging.Option) error {
-	if len(msgs) == 0 {
-		return nil
-	}
-
-	client, err := getSNSClient(u)
+	results, err := p.SendBatchResults(ctx, u, msgs, options...)
 	if err != nil {
 		return err
 	}
+	var failed []*BatchEntryError
+	for _, r := range results {
+		if fe, ok := r.Err.(*BatchEntryError); ok {
+			failed = append(failed, fe)
+		}
+	}
+	if len(failed) > 0 {
+		return &BatchError{Failed: failed, Total: len(msgs)}
+	}
+	return nil
+}
 
-	topicARN, err := resolveTopicARN(client, u)
+// SendBatchResults publishes msgs like SendBatchCtx and returns the
+// outcome of each message, in input order: its message ID and, on FIFO
+// topics, sequence number, or the error it failed with. The IDs are also
+// stored on MessageSNS messages. The observer's OnSend fires once per
+// message with its final outcome.
+//
+// The error is non-nil only when nothing more could be published: on
+// invalid options, a missing topic or a failed PublishBatch request. The
+// results of messages that were not published then carry that error, with
+// zero Attempts for the chunks that were never sent.
+func (p *Provider) SendBatchResults(ctx context.Context, u *url.URL, msgs []messaging.Message, options ...messaging.Option) ([]*PublishResult, error) {
+	if len(msgs) == 0 {
+		return nil, nil
+	}
+	start := time.Now()
+	results := make([]*PublishResult, len(msgs))
+	for i, msg := range msgs {
+		results[i] = &PublishResult{Index: i, Message: msg}
+	}
+	// fail ends the batch at msgs[from] with err, which becomes the
+	// outcome of every message from there on.
+	fail := func(from int, err error) ([]*PublishResult, error) {
+		for _, r := range results[from:] {
+			if r.Err == nil && r.MessageId == "" {
+				r.Err = err
+				p.fireOnSend(u, r.Message, err, start)
+			}
+		}
+		return results, err
+	}
+
+	client, err := resolvePublishClient(u)
 	if err != nil {
-		return err
+		return fail(0, err)
 	}
 
 	optResolver := messaging.NewOptionsResolver(options...)
+	create, err := resolveCreateTopic(optResolver)
+	if err != nil {
+		return fail(0, err)
+	}
+	topicARN, err := resolveTopicARN(ctx, client, u, create)
+	if err != nil {
+		return fail(0, err)
+	}
 	fifo := isFIFOTopic(topicARN)
 
 	// Validate broker-targeted options (golly v1.6.0) once, before batching.
 	if err := parseBrokerOptions(optResolver); err != nil {
-		return err
+		return fail(0, err)
+	}
+
+	encoding, _ := messaging.ResolveOptValue[string](OptBodyEncoding, optResolver)
+	sms, err := smsAttributes(optResolver)
+	if err != nil {
+		return fail(0, err)
+	}
+	retries := defaultBatchRetries
+	if v, ok := messaging.ResolveOptValue[int](OptBatchRetries, optResolver); ok && v >= 0 {
+		retries = v
 	}
 
 	const maxBatchSize = 10
@@ -316,10 +468,14 @@ func (p *Provider) SendBatchCtx(ctx context.Context, u *url.URL, msgs []messagin
 		entries := make([]types.PublishBatchRequestEntry, len(batch))
 		for j, msg := range batch {
 			id := fmt.Sprintf("msg-%d", i+j)
+			body, attrs, err := encodeBody(msg, encoding, buildBatchMessageAttributes(msg))
+			if err != nil {
+				return fail(i, err)
+			}
 			entries[j] = types.PublishBatchRequestEntry{
 				Id:                &id,
-				Message:           strPtr(msg.ReadAsStr()),
-				MessageAttributes: buildBatchMessageAttributes(msg),
+				Message:           &body,
+				MessageAttributes: withSMSAttributes(attrs, sms),
 			}
 			if v, ok := optResolver.Get(OptSubject); ok {
 				subject := v.(string)
@@ -333,9 +489,11 @@ func (p *Provider) SendBatchCtx(ctx context.Context, u *url.URL, msgs []messagin
 				dedupId := v.(string)
 				entries[j].MessageDeduplicationId = &dedupId
 			}
-			if v, ok := optResolver.Get(OptMessageStructure); ok {
-				structure := v.(string)
+			if structure, ok := messageStructure(msg, optResolver); ok {
 				entries[j].MessageStructure = &structure
+				if err := checkStructureEncoding(structure, attrs); err != nil {
+					return fail(i, err)
+				}
 			}
 			// Keyed → FIFO MessageGroupId (per entry). Explicit option
 			// above wins; skip if the field is already populated.
@@ -348,47 +506,31 @@ func (p *Provider) SendBatchCtx(ctx context.Context, u *url.URL, msgs []messagin
 			}
 		}
 
-		start := time.Now()
-		output, err := client.PublishBatch(ctx, &sns.PublishBatchInput{
-			TopicArn:                   &topicARN,
-			PublishBatchRequestEntries: entries,
-		})
-		// Observer sees each underlying PublishBatch call; attribute to
-		// the batch's first message.
-		p.fireOnSend(u, batch[0], err, start)
-		if err != nil {
-			return fmt.Errorf("sns: batch publish failed: %w", err)
+		if err := p.publishChunk(ctx, client, u, topicARN, batch, entries, retries, results[i:end]); err != nil {
+			return fail(i, err)
 		}
-		if len(output.Failed) > 0 {
-			return fmt.Errorf("sns: %d messages failed in batch publish: %s",
-				len(output.Failed), safeDeref(output.Failed[0].Message))
+		published := 0
+		for _, r := range results[i:end] {
+			if r.Err == nil {
+				published++
+			}
 		}
-
-		logger.InfoF("SNS batch published %d messages to %s", len(batch), topicARN)
+		logger.InfoF("SNS batch published %d of %d messages to %s", published, len(batch), topicARN)
 	}
 
-	return nil
+	return results, nil
 }
 
-// Receive is not supported by SNS. SNS is a publish-only service.
-// Use the SQS provider with an SNS→SQS subscription for receiving messages.
+// Receive is not supported by SNS, which pushes messages to subscribers.
+// Use AddListener for an HTTP(S) subscription, or the SQS provider with an
+// SNS→SQS subscription for pulling messages.
 func (p *Provider) Receive(_ *url.URL, _ ...messaging.Option) (messaging.Message, error) {
-	return nil, fmt.Errorf("sns: receive is not supported; SNS is a publish-only service; use SNS→SQS fan-out with the sqs package")
+	return nil, fmt.Errorf("sns: receive is not supported; SNS pushes messages, use AddListener or PushHandler for an HTTP(S) subscription, or SNS→SQS fan-out with the sqs package")
 }
 
-// ReceiveBatch is not supported by SNS.
+// ReceiveBatch is not supported by SNS. See Receive.
 func (p *Provider) ReceiveBatch(_ *url.URL, _ ...messaging.Option) ([]messaging.Message, error) {
-	return nil, fmt.Errorf("sns: receive batch is not supported; SNS is a publish-only service; use SNS→SQS fan-out with the sqs package")
-}
-
-// AddListener is not supported by SNS.
-func (p *Provider) AddListener(_ *url.URL, _ func(msg messaging.Message), _ ...messaging.Option) error {
-	return fmt.Errorf("sns: add listener is not supported; SNS is a publish-only service; use SNS→SQS fan-out with the sqs package")
-}
-
-// Close is a no-op for SNS since there are no background listeners.
-func (p *Provider) Close() error {
-	return nil
+	return nil, fmt.Errorf("sns: receive batch is not supported; SNS pushes messages, use AddListener or PushHandler for an HTTP(S) subscription, or SNS→SQS fan-out with the sqs package")
 }
 
 // buildMessageAttributes converts message string headers to SNS message attributes for Publish.
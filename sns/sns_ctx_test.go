@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -32,7 +33,9 @@ type captured struct {
 
 // snsFakeServer wraps an httptest.Server plus a mutex-protected slice of
 // captured requests. It responds with the minimal valid XML the SDK
-// needs to decode Publish / PublishBatch / CreateTopic without error.
+// needs to decode Publish / PublishBatch / CreateTopic and the topic
+// lookups (GetTopicAttributes, ListTopics, STS GetCallerIdentity) without
+// error.
 type snsFakeServer struct {
 	*httptest.Server
 	mu       sync.Mutex
@@ -40,6 +43,14 @@ type snsFakeServer struct {
 	// delay lets tests stall the handler so cancellation races can be
 	// observed deterministically.
 	delay time.Duration
+	// topics are the names of existing topics; CreateTopic adds to them.
+	topics []string
+	// stsDenied makes GetCallerIdentity fail.
+	stsDenied bool
+	// optedOut are the phone numbers CheckIfPhoneNumberIsOptedOut reports.
+	optedOut []string
+	// disabledEndpoint is a TargetArn Publish fails for as disabled.
+	disabledEndpoint string
 }
 
 func newSNSFakeServer() *snsFakeServer {
@@ -69,6 +80,13 @@ func (s *snsFakeServer) handle(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/xml")
 	switch action {
 	case "Publish":
+		if target := form.Get("TargetArn"); target != "" && target == s.disabledEndpoint {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = fmt.Fprint(w, `<ErrorResponse xmlns="http://sns.amazonaws.com/doc/2010-03-31/">`+
+				`<Error><Type>Sender</Type><Code>EndpointDisabled</Code><Message>Endpoint is disabled</Message></Error>`+
+				`<RequestId>req-1</RequestId></ErrorResponse>`)
+			return
+		}
 		_, _ = fmt.Fprint(w, `<PublishResponse xmlns="http://sns.amazonaws.com/doc/2010-03-31/">`+
 			`<PublishResult><MessageId>test-msg-id</MessageId></PublishResult>`+
 			`<ResponseMetadata><RequestId>req-1</RequestId></ResponseMetadata>`+
@@ -96,16 +114,87 @@ func (s *snsFakeServer) handle(w http.ResponseWriter, r *http.Request) {
 				`</PublishBatchResponse>`, successful.String())
 	case "CreateTopic":
 		name := form.Get("Name")
+		s.mu.Lock()
+		s.topics = append(s.topics, name)
+		s.mu.Unlock()
 		_, _ = fmt.Fprintf(w,
 			`<CreateTopicResponse xmlns="http://sns.amazonaws.com/doc/2010-03-31/">`+
 				`<CreateTopicResult><TopicArn>arn:aws:sns:us-east-1:123456789012:%s</TopicArn></CreateTopicResult>`+
 				`<ResponseMetadata><RequestId>req-1</RequestId></ResponseMetadata>`+
 				`</CreateTopicResponse>`, name)
+	case "GetTopicAttributes":
+		arn := form.Get("TopicArn")
+		if !s.hasTopic(arn[strings.LastIndex(arn, ":")+1:]) {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = fmt.Fprint(w, `<ErrorResponse xmlns="http://sns.amazonaws.com/doc/2010-03-31/">`+
+				`<Error><Type>Sender</Type><Code>NotFound</Code><Message>Topic does not exist</Message></Error>`+
+				`<RequestId>req-1</RequestId></ErrorResponse>`)
+			return
+		}
+		_, _ = fmt.Fprintf(w,
+			`<GetTopicAttributesResponse xmlns="http://sns.amazonaws.com/doc/2010-03-31/">`+
+				`<GetTopicAttributesResult><Attributes><entry><key>TopicArn</key><value>%s</value></entry></Attributes></GetTopicAttributesResult>`+
+				`<ResponseMetadata><RequestId>req-1</RequestId></ResponseMetadata>`+
+				`</GetTopicAttributesResponse>`, arn)
+	case "ListTopics":
+		var topics strings.Builder
+		s.mu.Lock()
+		for _, name := range s.topics {
+			fmt.Fprintf(&topics, `<member><TopicArn>arn:aws:sns:us-east-1:123456789012:%s</TopicArn></member>`, name)
+		}
+		s.mu.Unlock()
+		_, _ = fmt.Fprintf(w,
+			`<ListTopicsResponse xmlns="http://sns.amazonaws.com/doc/2010-03-31/">`+
+				`<ListTopicsResult><Topics>%s</Topics></ListTopicsResult>`+
+				`<ResponseMetadata><RequestId>req-1</RequestId></ResponseMetadata>`+
+				`</ListTopicsResponse>`, topics.String())
+	case "CheckIfPhoneNumberIsOptedOut":
+		s.mu.Lock()
+		optedOut := slices.Contains(s.optedOut, form.Get("phoneNumber"))
+		s.mu.Unlock()
+		_, _ = fmt.Fprintf(w,
+			`<CheckIfPhoneNumberIsOptedOutResponse xmlns="http://sns.amazonaws.com/doc/2010-03-31/">`+
+				`<CheckIfPhoneNumberIsOptedOutResult><isOptedOut>%t</isOptedOut></CheckIfPhoneNumberIsOptedOutResult>`+
+				`<ResponseMetadata><RequestId>req-1</RequestId></ResponseMetadata>`+
+				`</CheckIfPhoneNumberIsOptedOutResponse>`, optedOut)
+	case "GetCallerIdentity":
+		if s.stsDenied {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = fmt.Fprint(w, `<ErrorResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">`+
+				`<Error><Type>Sender</Type><Code>AccessDenied</Code><Message>denied</Message></Error>`+
+				`<RequestId>req-1</RequestId></ErrorResponse>`)
+			return
+		}
+		_, _ = fmt.Fprint(w, `<GetCallerIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">`+
+			`<GetCallerIdentityResult><Arn>arn:aws:iam::123456789012:user/test</Arn>`+
+			`<UserId>AIDTEST</UserId><Account>123456789012</Account></GetCallerIdentityResult>`+
+			`<ResponseMetadata><RequestId>req-1</RequestId></ResponseMetadata>`+
+			`</GetCallerIdentityResponse>`)
 	default:
 		w.WriteHeader(http.StatusBadRequest)
 	}
 }
 
+func (s *snsFakeServer) hasTopic(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.topics {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+// actions returns the actions of the captured requests, in order.
+func (s *snsFakeServer) actions() []string {
+	var out []string
+	for _, c := range s.captured() {
+		out = append(out, c.Action)
+	}
+	return out
+}
+
 func (s *snsFakeServer) captured() []captured {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -1,20 +1,73 @@
 package sns
 
-import "oss.nandlabs.io/golly/messaging"
+import (
+	"net/url"
+	"sync/atomic"
+	"time"
 
-// MessageSNS wraps BaseMessage for SNS. Since SNS is publish-only,
-// Rsvp is a no-op that always returns nil.
+	"oss.nandlabs.io/golly/messaging"
+)
+
+// MessageSNS wraps BaseMessage for SNS. Messages delivered by a push
+// subscriber (AddListener) additionally carry their topic, subject and
+// timestamp.
 type MessageSNS struct {
 	*messaging.BaseMessage
-	// messageId is the SNS message ID returned after publishing (populated after Send).
+	// messageId is the SNS message ID returned after publishing (populated after Send),
+	// or the ID of a pushed message.
 	messageId string
+	// sequenceNumber is the sequence number SNS assigned to a message
+	// published to a FIFO topic.
+	sequenceNumber string
 	// provider is a back-reference to the provider.
 	provider *Provider
+	// source is the sns:// URL of the listener that received a pushed
+	// message; nil for messages created with NewMessage.
+	source    *url.URL
+	topicArn  string
+	subject   string
+	timestamp time.Time
+	// nacked records an Rsvp(false), answered with a 500 so SNS retries.
+	nacked atomic.Bool
+	// structured is the body built by SetProtocolMessages.
+	structured string
+	// numberHeaders holds the string headers that are Number attributes.
+	numberHeaders map[string]bool
+}
+
+// SetNumberHeader sets a string header holding an SNS Number attribute,
+// such as "210.75". Pushed messages carry their Number attributes this
+// way. Filter policies match these headers against numeric conditions;
+// other string headers are String attributes and never match them.
+func (m *MessageSNS) SetNumberHeader(key, value string) {
+	m.SetStrHeader(key, value)
+	if m.numberHeaders == nil {
+		m.numberHeaders = make(map[string]bool)
+	}
+	m.numberHeaders[key] = true
 }
 
-// Rsvp is a no-op for SNS since there is no acknowledgement concept.
-// Always returns nil.
-func (m *MessageSNS) Rsvp(_ bool, _ ...messaging.Option) error {
+// isNumberHeader reports whether the header key was set by SetNumberHeader.
+func (m *MessageSNS) isNumberHeader(key string) bool {
+	return m.numberHeaders[key]
+}
+
+// Rsvp acknowledges or rejects a message delivered by a push subscriber:
+// rejecting it answers the push request with a 500, so that SNS redelivers
+// it according to the subscription's delivery policy. The observer's OnAck
+// or OnNack fires. For other messages Rsvp is a no-op. Always returns nil.
+func (m *MessageSNS) Rsvp(accept bool, _ ...messaging.Option) error {
+	if m.source == nil {
+		return nil
+	}
+	m.nacked.Store(!accept)
+	if obs := m.provider.loadObserver(); obs != nil {
+		if accept {
+			obs.OnAck(m.source, m)
+		} else {
+			obs.OnNack(m.source, m, true)
+		}
+	}
 	return nil
 }
 
@@ -23,3 +76,24 @@ func (m *MessageSNS) Rsvp(_ bool, _ ...messaging.Option) error {
 func (m *MessageSNS) SNSMessageId() string {
 	return m.messageId
 }
+
+// SequenceNumber returns the sequence number SNS assigned to the message
+// when it was published to a FIFO topic, or an empty string.
+func (m *MessageSNS) SequenceNumber() string {
+	return m.sequenceNumber
+}
+
+// TopicArn returns the topic a pushed message was published to.
+func (m *MessageSNS) TopicArn() string {
+	return m.topicArn
+}
+
+// Subject returns the subject a pushed message was published with, if any.
+func (m *MessageSNS) Subject() string {
+	return m.subject
+}
+
+// Timestamp returns when SNS accepted a pushed message.
+func (m *MessageSNS) Timestamp() time.Time {
+	return m.timestamp
+}
@@ -1,6 +1,7 @@
 package sns
 
 import (
+	"context"
 	"net/url"
 	"testing"
 
@@ -142,19 +143,19 @@ func TestReceiveBatchUnsupported(t *testing.T) {
 	}
 }
 
-func TestAddListenerUnsupported(t *testing.T) {
+func TestAddListenerRequiresEndpoint(t *testing.T) {
 	p := &Provider{}
 	u, _ := url.Parse("sns://my-topic")
 	err := p.AddListener(u, func(msg messaging.Message) {})
 	if err == nil {
-		t.Fatal("expected error for AddListener on SNS")
+		t.Fatal("expected error for AddListener without ListenAddr or ServeMux")
 	}
 }
 
 func TestResolveTopicARNDirect(t *testing.T) {
 	arn := "arn:aws:sns:us-east-1:123456789012:my-topic"
 	u, _ := url.Parse("sns:///" + arn)
-	resolved, err := resolveTopicARN(nil, u)
+	resolved, err := resolveTopicARN(context.Background(), nil, u, nil)
 	if err != nil {
 		t.Fatalf("resolveTopicARN failed: %v", err)
 	}
@@ -165,7 +166,7 @@ func TestResolveTopicARNDirect(t *testing.T) {
 
 func TestResolveTopicARNEmptyPath(t *testing.T) {
 	u, _ := url.Parse("sns:///")
-	_, err := resolveTopicARN(nil, u)
+	_, err := resolveTopicARN(context.Background(), nil, u, nil)
 	if err == nil {
 		t.Fatal("expected error for empty path")
 	}
@@ -173,7 +174,7 @@ func TestResolveTopicARNEmptyPath(t *testing.T) {
 
 func TestResolveTopicARNNoHost(t *testing.T) {
 	u := &url.URL{Scheme: "sns"}
-	_, err := resolveTopicARN(nil, u)
+	_, err := resolveTopicARN(context.Background(), nil, u, nil)
 	if err == nil {
 		t.Fatal("expected error for empty host and path")
 	}
@@ -0,0 +1,12 @@
+// Package snstest provides an in-memory SNS emulator for integration tests.
+//
+// An Emulator runs SNS topics and their SQS subscriptions in memory. Install
+// routes the sns provider, the sns topic administration and the sqs provider
+// to it until the returned function is called:
+//
+//	emu := snstest.NewEmulator()
+//	defer emu.Install()()
+//
+// It is a separate package so that importing sns does not register the sqs
+// provider.
+package snstest
@@ -0,0 +1,1145 @@
+package snstest
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	snsprovider "oss.nandlabs.io/golly-aws/sns"
+	sqsprovider "oss.nandlabs.io/golly-aws/sqs"
+)
+
+const (
+	// emulatorARNPrefix is the account and region of emulated topics;
+	// emulated queues use the same ones.
+	emulatorARNPrefix = "arn:aws:sns:us-east-1:000000000000:"
+	emulatorQueueARN  = "arn:aws:sqs:us-east-1:000000000000:"
+	emulatorQueueURL  = "https://sqs.us-east-1.amazonaws.com/000000000000/"
+	// dedupWindow is how long FIFO topics and queues remember
+	// deduplication IDs.
+	dedupWindow = 5 * time.Minute
+	// defaultVisibilityTimeout is the VisibilityTimeout of a queue
+	// created without one.
+	defaultVisibilityTimeout = 30 * time.Second
+	// maxMessageSize is the largest message SNS publishes.
+	maxMessageSize = 256 * 1024
+)
+
+// Emulator is an in-memory SNS→SQS fan-out for integration tests. Install
+// routes the sns package's Provider and TopicAdmin and the sqs package's
+// Provider to it, so a topology is built with Admin, published to with
+// Send and SendBatch and consumed with the sqs provider, without AWS or
+// LocalStack:
+//
+//	emu := snstest.NewEmulator()
+//	defer emu.Install()()
+//	emu.CreateQueue("billing", nil)
+//	sns.Admin().EnsureTopic(ctx, topicURL, nil)
+//	sns.Admin().Subscribe(ctx, topicURL, &sns.Subscription{Endpoint: "sqs://billing"})
+//
+// Publishing delivers to the SQS subscriptions of the topic whose filter
+// policy matches, in the SNS JSON envelope or, with RawMessageDelivery, as
+// the bare message with its attributes. FIFO topics deduplicate and number
+// their messages, and deliver them to FIFO queues in order per message
+// group, where a group is held while one of its messages is in flight.
+// Queues honour delays, visibility timeouts, long polling and redrive
+// policies, and only accept topics their access policy allows, like SQS.
+//
+// Only sqs subscriptions and publishing to topics are emulated; encryption
+// and data protection policies are stored but not applied.
+type Emulator struct {
+	mu     sync.Mutex
+	topics map[string]*emuTopic        // by ARN
+	subs   map[string]*emuSubscription // by ARN
+	queues map[string]*emuQueue        // by name
+	seq    int64
+}
+
+type emuTopic struct {
+	arn        string
+	fifo       bool
+	attrs      map[string]string
+	protection string
+	subs       []string // subscription ARNs, in creation order
+	dedup      map[string]emuDedup
+}
+
+type emuSubscription struct {
+	arn      string
+	topicArn string
+	endpoint string // queue ARN
+	attrs    map[string]string
+}
+
+type emuQueue struct {
+	name    string
+	arn     string
+	fifo    bool
+	attrs   map[string]string
+	msgs    []*emuMessage // in send order
+	dedup   map[string]emuDedup
+	arrived chan struct{} // closed and replaced when a message arrives
+}
+
+type emuMessage struct {
+	id           string
+	body         string
+	attrs        map[string]sqstypes.MessageAttributeValue
+	group        string
+	dedupID      string
+	seq          string
+	sourceArn    string // queue a dead-lettered message came from
+	sent         time.Time
+	visibleAt    time.Time
+	firstReceive time.Time
+	receives     int
+	receipt      string
+}
+
+// emuDedup is a remembered deduplication ID.
+type emuDedup struct {
+	messageID string
+	seq       string
+	at        time.Time
+}
+
+// NewEmulator returns an empty Emulator.
+func NewEmulator() *Emulator {
+	return &Emulator{
+		topics: map[string]*emuTopic{},
+		subs:   map[string]*emuSubscription{},
+		queues: map[string]*emuQueue{},
+	}
+}
+
+// Install makes the sns Provider, Admin and the sqs Provider use the
+// emulator until restore is called, and clears the topic ARN cache. Topic
+// names resolve to ARNs in the emulator's account; queue URLs resolve by
+// their host. Install and restore are not safe to call while messages are
+// in flight.
+func (e *Emulator) Install() (restore func()) {
+	restoreSNS := snsprovider.UseClient(emulatorSNS{e}, emulatorSQS{e}, emulatorARNPrefix)
+	restoreSQS := sqsprovider.UseClient(func(u *url.URL) (sqsprovider.API, string, error) {
+		return emulatorSQS{e}, emulatorQueueURL + u.Host, nil
+	})
+	return func() {
+		restoreSQS()
+		restoreSNS()
+	}
+}
+
+// CreateQueue creates an empty queue with the given SQS attributes, e.g.
+// "VisibilityTimeout", "DelaySeconds", "ContentBasedDeduplication" or
+// "RedrivePolicy". A name ending in .fifo creates a FIFO queue. Creating
+// an existing queue keeps its messages and updates its attributes.
+func (e *Emulator) CreateQueue(name string, attributes map[string]string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	q, ok := e.queues[name]
+	if !ok {
+		q = &emuQueue{
+			name:    name,
+			arn:     emulatorQueueARN + name,
+			fifo:    strings.HasSuffix(name, ".fifo"),
+			attrs:   map[string]string{},
+			dedup:   map[string]emuDedup{},
+			arrived: make(chan struct{}),
+		}
+		e.queues[name] = q
+	}
+	maps.Copy(q.attrs, attributes)
+}
+
+// QueueLength returns the number of messages in the queue named name,
+// including delayed and in-flight ones.
+func (e *Emulator) QueueLength(name string) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if q, ok := e.queues[name]; ok {
+		return len(q.msgs)
+	}
+	return 0
+}
+
+// nextID returns a message ID unique within the emulator.
+func (e *Emulator) nextID() string {
+	e.seq++
+	return fmt.Sprintf("00000000-0000-4000-8000-%012d", e.seq)
+}
+
+// nextSeq returns a FIFO sequence number, increasing within the emulator.
+func (e *Emulator) nextSeq() string {
+	e.seq++
+	return fmt.Sprintf("%020d", e.seq)
+}
+
+// seen returns the remembered message with the deduplication ID, after
+// forgetting the IDs older than the deduplication window.
+func seen(dedup map[string]emuDedup, id string, now time.Time) (emuDedup, bool) {
+	for k, d := range dedup {
+		if now.Sub(d.at) > dedupWindow {
+			delete(dedup, k)
+		}
+	}
+	d, ok := dedup[id]
+	return d, ok
+}
+
+func contentDedupID(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
+
+func snsInvalid(format string, args ...any) error {
+	return &types.InvalidParameterException{Message: aws.String(fmt.Sprintf(format, args...))}
+}
+
+func snsNotFound(format string, args ...any) error {
+	return &types.NotFoundException{Message: aws.String(fmt.Sprintf(format, args...))}
+}
+
+// emulatorSNS is the SNS client of an Emulator.
+type emulatorSNS struct{ e *Emulator }
+
+var _ snsprovider.API = emulatorSNS{}
+
+func (c emulatorSNS) CreateTopic(ctx context.Context, in *sns.CreateTopicInput, _ ...func(*sns.Options)) (*sns.CreateTopicOutput, error) {
+	e := c.e
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	name := aws.ToString(in.Name)
+	fifo := strings.HasSuffix(name, ".fifo")
+	if name == "" || (in.Attributes["FifoTopic"] == "true") != fifo {
+		return nil, snsInvalid("Invalid parameter: Topic Name")
+	}
+	arn := emulatorARNPrefix + name
+	if t, ok := e.topics[arn]; ok {
+		for k, v := range in.Attributes {
+			if t.attrs[k] != v {
+				return nil, snsInvalid("Invalid parameter: Attributes Reason: Topic already exists with different attributes")
+			}
+		}
+		return &sns.CreateTopicOutput{TopicArn: &arn}, nil
+	}
+	t := &emuTopic{arn: arn, fifo: fifo, attrs: maps.Clone(in.Attributes), dedup: map[string]emuDedup{}}
+	if t.attrs == nil {
+		t.attrs = map[string]string{}
+	}
+	t.protection = aws.ToString(in.DataProtectionPolicy)
+	e.topics[arn] = t
+	return &sns.CreateTopicOutput{TopicArn: &arn}, nil
+}
+
+// topic returns the topic with the ARN; e.mu must be held.
+func (e *Emulator) topic(arn *string) (*emuTopic, error) {
+	t, ok := e.topics[aws.ToString(arn)]
+	if !ok {
+		return nil, snsNotFound("Topic does not exist")
+	}
+	return t, nil
+}
+
+func (c emulatorSNS) GetTopicAttributes(ctx context.Context, in *sns.GetTopicAttributesInput, _ ...func(*sns.Options)) (*sns.GetTopicAttributesOutput, error) {
+	e := c.e
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	t, err := e.topic(in.TopicArn)
+	if err != nil {
+		return nil, err
+	}
+	attrs := maps.Clone(t.attrs)
+	attrs["TopicArn"] = t.arn
+	attrs["Owner"] = emulatorAccount()
+	attrs["SubscriptionsConfirmed"] = strconv.Itoa(len(t.subs))
+	attrs["SubscriptionsPending"] = "0"
+	return &sns.GetTopicAttributesOutput{Attributes: attrs}, nil
+}
+
+func emulatorAccount() string {
+	return strings.Split(emulatorARNPrefix, ":")[4]
+}
+
+func (c emulatorSNS) ListTopics(ctx context.Context, in *sns.ListTopicsInput, _ ...func(*sns.Options)) (*sns.ListTopicsOutput, error) {
+	e := c.e
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	out := &sns.ListTopicsOutput{}
+	for _, arn := range slices.Sorted(maps.Keys(e.topics)) {
+		out.Topics = append(out.Topics, types.Topic{TopicArn: aws.String(arn)})
+	}
+	return out, nil
+}
+
+func (c emulatorSNS) SetTopicAttributes(ctx context.Context, in *sns.SetTopicAttributesInput, _ ...func(*sns.Options)) (*sns.SetTopicAttributesOutput, error) {
+	e := c.e
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	t, err := e.topic(in.TopicArn)
+	if err != nil {
+		return nil, err
+	}
+	if name := aws.ToString(in.AttributeName); name == "FifoTopic" {
+		return nil, snsInvalid("Invalid parameter: AttributeName")
+	} else {
+		t.attrs[name] = aws.ToString(in.AttributeValue)
+	}
+	return &sns.SetTopicAttributesOutput{}, nil
+}
+
+func (c emulatorSNS) DeleteTopic(ctx context.Context, in *sns.DeleteTopicInput, _ ...func(*sns.Options)) (*sns.DeleteTopicOutput, error) {
+	e := c.e
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if t, ok := e.topics[aws.ToString(in.TopicArn)]; ok {
+		for _, arn := range t.subs {
+			delete(e.subs, arn)
+		}
+		delete(e.topics, t.arn)
+	}
+	return &sns.DeleteTopicOutput{}, nil
+}
+
+func (c emulatorSNS) GetDataProtectionPolicy(ctx context.Context, in *sns.GetDataProtectionPolicyInput, _ ...func(*sns.Options)) (*sns.GetDataProtectionPolicyOutput, error) {
+	e := c.e
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	t, err := e.topic(in.ResourceArn)
+	if err != nil {
+		return nil, err
+	}
+	return &sns.GetDataProtectionPolicyOutput{DataProtectionPolicy: aws.String(t.protection)}, nil
+}
+
+func (c emulatorSNS) PutDataProtectionPolicy(ctx context.Context, in *sns.PutDataProtectionPolicyInput, _ ...func(*sns.Options)) (*sns.PutDataProtectionPolicyOutput, error) {
+	e := c.e
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	t, err := e.topic(in.ResourceArn)
+	if err != nil {
+		return nil, err
+	}
+	t.protection = aws.ToString(in.DataProtectionPolicy)
+	return &sns.PutDataProtectionPolicyOutput{}, nil
+}
+
+func (c emulatorSNS) Subscribe(ctx context.Context, in *sns.SubscribeInput, _ ...func(*sns.Options)) (*sns.SubscribeOutput, error) {
+	e := c.e
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	t, err := e.topic(in.TopicArn)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := aws.ToString(in.Endpoint)
+	if aws.ToString(in.Protocol) != string(snsprovider.ProtocolSQS) {
+		return nil, snsInvalid("Invalid parameter: Protocol Reason: the emulator supports sqs subscriptions only")
+	}
+	if !strings.HasPrefix(endpoint, "arn:aws:sqs:") {
+		return nil, snsInvalid("Invalid parameter: SQS endpoint ARN")
+	}
+	if strings.HasSuffix(endpoint, ".fifo") && !t.fifo {
+		return nil, snsInvalid("Invalid parameter: Invalid parameter: Endpoint Reason: FIFO SQS Queues can not be subscribed to standard SNS topics")
+	}
+	if err := validSubscriptionAttributes(in.Attributes); err != nil {
+		return nil, err
+	}
+	for _, arn := range t.subs {
+		if s := e.subs[arn]; s.endpoint == endpoint {
+			return &sns.SubscribeOutput{SubscriptionArn: &s.arn}, nil
+		}
+	}
+	s := &emuSubscription{
+		arn:      fmt.Sprintf("%s:%s", t.arn, e.nextID()),
+		topicArn: t.arn,
+		endpoint: endpoint,
+		attrs:    maps.Clone(in.Attributes),
+	}
+	if s.attrs == nil {
+		s.attrs = map[string]string{}
+	}
+	e.subs[s.arn] = s
+	t.subs = append(t.subs, s.arn)
+	return &sns.SubscribeOutput{SubscriptionArn: &s.arn}, nil
+}
+
+// validSubscriptionAttributes checks the filter policy in attrs.
+func validSubscriptionAttributes(attrs map[string]string) error {
+	if policy := attrs["FilterPolicy"]; policy != "" {
+		if _, err := snsprovider.ParseFilterPolicy(policy, snsprovider.FilterPolicyScope(attrs["FilterPolicyScope"])); err != nil {
+			return snsInvalid("Invalid parameter: FilterPolicy: %v", err)
+		}
+	}
+	return nil
+}
+
+func (c emulatorSNS) Unsubscribe(ctx context.Context, in *sns.UnsubscribeInput, _ ...func(*sns.Options)) (*sns.UnsubscribeOutput, error) {
+	e := c.e
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s, ok := e.subs[aws.ToString(in.SubscriptionArn)]
+	if !ok {
+		return nil, snsNotFound("Subscription does not exist")
+	}
+	delete(e.subs, s.arn)
+	if t, ok := e.topics[s.topicArn]; ok {
+		t.subs = slices.DeleteFunc(t.subs, func(arn string) bool { return arn == s.arn })
+	}
+	return &sns.UnsubscribeOutput{}, nil
+}
+
+func (c emulatorSNS) ListSubscriptionsByTopic(ctx context.Context, in *sns.ListSubscriptionsByTopicInput, _ ...func(*sns.Options)) (*sns.ListSubscriptionsByTopicOutput, error) {
+	e := c.e
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	t, err := e.topic(in.TopicArn)
+	if err != nil {
+		return nil, err
+	}
+	out := &sns.ListSubscriptionsByTopicOutput{}
+	for _, arn := range t.subs {
+		s := e.subs[arn]
+		out.Subscriptions = append(out.Subscriptions, types.Subscription{
+			SubscriptionArn: aws.String(s.arn),
+			TopicArn:        aws.String(s.topicArn),
+			Protocol:        aws.String(string(snsprovider.ProtocolSQS)),
+			Endpoint:        aws.String(s.endpoint),
+			Owner:           aws.String(emulatorAccount()),
+		})
+	}
+	return out, nil
+}
+
+func (c emulatorSNS) GetSubscriptionAttributes(ctx context.Context, in *sns.GetSubscriptionAttributesInput, _ ...func(*sns.Options)) (*sns.GetSubscriptionAttributesOutput, error) {
+	e := c.e
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s, ok := e.subs[aws.ToString(in.SubscriptionArn)]
+	if !ok {
+		return nil, snsNotFound("Subscription does not exist")
+	}
+	attrs := maps.Clone(s.attrs)
+	attrs["SubscriptionArn"] = s.arn
+	attrs["TopicArn"] = s.topicArn
+	attrs["Protocol"] = string(snsprovider.ProtocolSQS)
+	attrs["Endpoint"] = s.endpoint
+	attrs["Owner"] = emulatorAccount()
+	attrs["PendingConfirmation"] = "false"
+	return &sns.GetSubscriptionAttributesOutput{Attributes: attrs}, nil
+}
+
+func (c emulatorSNS) SetSubscriptionAttributes(ctx context.Context, in *sns.SetSubscriptionAttributesInput, _ ...func(*sns.Options)) (*sns.SetSubscriptionAttributesOutput, error) {
+	e := c.e
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s, ok := e.subs[aws.ToString(in.SubscriptionArn)]
+	if !ok {
+		return nil, snsNotFound("Subscription does not exist")
+	}
+	attrs := maps.Clone(s.attrs)
+	attrs[aws.ToString(in.AttributeName)] = aws.ToString(in.AttributeValue)
+	if err := validSubscriptionAttributes(attrs); err != nil {
+		return nil, err
+	}
+	s.attrs = attrs
+	return &sns.SetSubscriptionAttributesOutput{}, nil
+}
+
+func (c emulatorSNS) CheckIfPhoneNumberIsOptedOut(ctx context.Context, in *sns.CheckIfPhoneNumberIsOptedOutInput, _ ...func(*sns.Options)) (*sns.CheckIfPhoneNumberIsOptedOutOutput, error) {
+	return &sns.CheckIfPhoneNumberIsOptedOutOutput{}, nil
+}
+
+func (c emulatorSNS) Publish(ctx context.Context, in *sns.PublishInput, _ ...func(*sns.Options)) (*sns.PublishOutput, error) {
+	if in.TopicArn == nil {
+		return nil, snsInvalid("Invalid parameter: TopicArn Reason: the emulator publishes to topics only")
+	}
+	e := c.e
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	id, seq, err := e.publish(&emuPublish{
+		topicArn:  *in.TopicArn,
+		message:   aws.ToString(in.Message),
+		subject:   aws.ToString(in.Subject),
+		structure: aws.ToString(in.MessageStructure),
+		attrs:     in.MessageAttributes,
+		group:     aws.ToString(in.MessageGroupId),
+		dedupID:   aws.ToString(in.MessageDeduplicationId),
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := &sns.PublishOutput{MessageId: &id}
+	if seq != "" {
+		out.SequenceNumber = &seq
+	}
+	return out, nil
+}
+
+func (c emulatorSNS) PublishBatch(ctx context.Context, in *sns.PublishBatchInput, _ ...func(*sns.Options)) (*sns.PublishBatchOutput, error) {
+	if len(in.PublishBatchRequestEntries) == 0 {
+		return nil, &types.EmptyBatchRequestException{Message: aws.String("The batch request doesn't contain any entries")}
+	}
+	if len(in.PublishBatchRequestEntries) > 10 {
+		return nil, &types.TooManyEntriesInBatchRequestException{Message: aws.String("The batch request contains more entries than permissible")}
+	}
+	e := c.e
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, err := e.topic(in.TopicArn); err != nil {
+		return nil, err
+	}
+	out := &sns.PublishBatchOutput{}
+	for _, entry := range in.PublishBatchRequestEntries {
+		id, seq, err := e.publish(&emuPublish{
+			topicArn:  aws.ToString(in.TopicArn),
+			message:   aws.ToString(entry.Message),
+			subject:   aws.ToString(entry.Subject),
+			structure: aws.ToString(entry.MessageStructure),
+			attrs:     entry.MessageAttributes,
+			group:     aws.ToString(entry.MessageGroupId),
+			dedupID:   aws.ToString(entry.MessageDeduplicationId),
+		})
+		if err != nil {
+			out.Failed = append(out.Failed, types.BatchResultErrorEntry{
+				Id: entry.Id, Code: aws.String("InvalidParameter"), Message: aws.String(err.Error()), SenderFault: true,
+			})
+			continue
+		}
+		result := types.PublishBatchResultEntry{Id: entry.Id, MessageId: aws.String(id)}
+		if seq != "" {
+			result.SequenceNumber = aws.String(seq)
+		}
+		out.Successful = append(out.Successful, result)
+	}
+	return out, nil
+}
+
+// emuPublish is a message published to a topic.
+type emuPublish struct {
+	topicArn  string
+	message   string
+	subject   string
+	structure string
+	attrs     map[string]types.MessageAttributeValue
+	group     string
+	dedupID   string
+}
+
+// publish validates a message and delivers it to the subscriptions of its
+// topic; e.mu must be held. It returns the message ID and, on FIFO topics,
+// the sequence number.
+func (e *Emulator) publish(p *emuPublish) (string, string, error) {
+	t, err := e.topic(&p.topicArn)
+	if err != nil {
+		return "", "", err
+	}
+	if p.message == "" {
+		return "", "", snsInvalid("Invalid parameter: Empty message")
+	}
+	if len(p.message) > maxMessageSize {
+		return "", "", snsInvalid("Invalid parameter: Message too long")
+	}
+	texts := map[string]string{}
+	switch p.structure {
+	case "":
+	case "json":
+		if err := json.Unmarshal([]byte(p.message), &texts); err != nil || texts["default"] == "" {
+			return "", "", snsInvalid("Invalid parameter: Message Structure - JSON message body failed to parse or has no default entry")
+		}
+	default:
+		return "", "", snsInvalid("Invalid parameter: MessageStructure")
+	}
+
+	now := time.Now()
+	seq := ""
+	if t.fifo {
+		if p.group == "" {
+			return "", "", snsInvalid("Invalid parameter: The MessageGroupId parameter is required for FIFO topics")
+		}
+		if p.dedupID == "" {
+			if t.attrs["ContentBasedDeduplication"] != "true" {
+				return "", "", snsInvalid("Invalid parameter: The topic should either have ContentBasedDeduplication enabled or MessageDeduplicationId provided explicitly")
+			}
+			p.dedupID = contentDedupID(p.message)
+		}
+		if d, dup := seen(t.dedup, p.dedupID, now); dup {
+			return d.messageID, d.seq, nil
+		}
+		seq = e.nextSeq()
+	}
+	id := e.nextID()
+	if t.fifo {
+		t.dedup[p.dedupID] = emuDedup{messageID: id, seq: seq, at: now}
+	}
+
+	for _, arn := range t.subs {
+		s := e.subs[arn]
+		text := p.message
+		if p.structure == "json" {
+			text = texts["default"]
+			if sqsText, ok := texts["sqs"]; ok {
+				text = sqsText
+			}
+		}
+		if !s.matches(text, p.attrs) {
+			continue
+		}
+		msg := &emuMessage{group: p.group, dedupID: p.dedupID, attrs: map[string]sqstypes.MessageAttributeValue{}}
+		if s.attrs["RawMessageDelivery"] == "true" {
+			msg.body = text
+			for k, v := range p.attrs {
+				msg.attrs[k] = sqstypes.MessageAttributeValue{DataType: v.DataType, StringValue: v.StringValue, BinaryValue: v.BinaryValue}
+			}
+		} else {
+			msg.body = envelope(id, seq, t.arn, s.arn, p.subject, text, p.attrs, now)
+		}
+		e.deliver(s, msg)
+	}
+	return id, seq, nil
+}
+
+// matches reports whether the subscription's filter policy accepts a
+// message with the body text and attributes.
+func (s *emuSubscription) matches(text string, attrs map[string]types.MessageAttributeValue) bool {
+	policy := s.attrs["FilterPolicy"]
+	if policy == "" {
+		return true
+	}
+	fp, err := snsprovider.ParseFilterPolicy(policy, snsprovider.FilterPolicyScope(s.attrs["FilterPolicyScope"]))
+	if err != nil {
+		return false
+	}
+	m, _ := (&snsprovider.Provider{}).NewMessage(snsprovider.SNSScheme)
+	msg := m.(*snsprovider.MessageSNS)
+	_, _ = msg.SetBodyStr(text)
+	for k, v := range attrs {
+		value := aws.ToString(v.StringValue)
+		switch dataType := aws.ToString(v.DataType); {
+		case strings.HasPrefix(dataType, "Binary"):
+			msg.SetHeader(k, v.BinaryValue)
+		case strings.HasPrefix(dataType, "Number"):
+			msg.SetNumberHeader(k, value)
+		default:
+			msg.SetStrHeader(k, value)
+		}
+	}
+	return fp.Match(msg).Matched
+}
+
+// queueEnvelope is the JSON document SNS delivers to a queue without raw
+// message delivery.
+type queueEnvelope struct {
+	Type              string
+	MessageId         string
+	SequenceNumber    string `json:",omitempty"`
+	TopicArn          string
+	Subject           string `json:",omitempty"`
+	Message           string
+	Timestamp         string
+	SignatureVersion  string
+	Signature         string
+	SigningCertURL    string                       `json:"SigningCertURL"`
+	UnsubscribeURL    string                       `json:"UnsubscribeURL"`
+	MessageAttributes map[string]envelopeAttribute `json:",omitempty"`
+}
+
+// envelopeAttribute is a message attribute in an envelope.
+type envelopeAttribute struct {
+	Type  string
+	Value string
+}
+
+func envelope(id, seq, topicArn, subArn, subject, text string, attrs map[string]types.MessageAttributeValue, now time.Time) string {
+	env := queueEnvelope{
+		Type:             "Notification",
+		MessageId:        id,
+		SequenceNumber:   seq,
+		TopicArn:         topicArn,
+		Subject:          subject,
+		Message:          text,
+		Timestamp:        now.UTC().Format("2006-01-02T15:04:05.000Z"),
+		SignatureVersion: "1",
+		Signature:        "EMULATED",
+		SigningCertURL:   "https://sns.us-east-1.amazonaws.com/SimpleNotificationService-emulated.pem",
+		UnsubscribeURL:   "https://sns.us-east-1.amazonaws.com/?Action=Unsubscribe&SubscriptionArn=" + url.QueryEscape(subArn),
+	}
+	if len(attrs) > 0 {
+		env.MessageAttributes = make(map[string]envelopeAttribute, len(attrs))
+		for k, v := range attrs {
+			value := aws.ToString(v.StringValue)
+			if v.BinaryValue != nil {
+				value = base64.StdEncoding.EncodeToString(v.BinaryValue)
+			}
+			env.MessageAttributes[k] = envelopeAttribute{Type: aws.ToString(v.DataType), Value: value}
+		}
+	}
+	raw, _ := json.Marshal(env)
+	return string(raw)
+}
+
+// deliver sends msg to the queue of the subscription, or to its
+// dead-letter queue when the queue is missing or does not allow the topic;
+// e.mu must be held. Undeliverable messages are dropped.
+func (e *Emulator) deliver(s *emuSubscription, msg *emuMessage) {
+	if q := e.queueByARN(s.endpoint); q != nil && q.allows(s.topicArn) {
+		e.enqueue(q, msg)
+		return
+	}
+	var redrive struct {
+		DeadLetterTargetArn string `json:"deadLetterTargetArn"`
+	}
+	_ = json.Unmarshal([]byte(s.attrs["RedrivePolicy"]), &redrive)
+	if dlq := e.queueByARN(redrive.DeadLetterTargetArn); dlq != nil && dlq.allows(s.topicArn) {
+		e.enqueue(dlq, msg)
+		return
+	}
+	logger.WarnF("SNS emulator dropped message for %s: queue %s is missing or does not allow the topic", s.arn, s.endpoint)
+}
+
+func (e *Emulator) queueByARN(arn string) *emuQueue {
+	if !strings.HasPrefix(arn, emulatorQueueARN) {
+		return nil
+	}
+	return e.queues[strings.TrimPrefix(arn, emulatorQueueARN)]
+}
+
+// allows reports whether the access policy of the queue lets the topic
+// send messages to it.
+func (q *emuQueue) allows(topicArn string) bool {
+	type statement struct {
+		Effect    string
+		Principal any
+		Action    any
+		Condition map[string]map[string]any
+	}
+	var policy struct{ Statement json.RawMessage }
+	if json.Unmarshal([]byte(q.attrs["Policy"]), &policy) != nil {
+		return false
+	}
+	var statements []statement
+	if json.Unmarshal(policy.Statement, &statements) != nil {
+		var single statement
+		if json.Unmarshal(policy.Statement, &single) != nil {
+			return false
+		}
+		statements = []statement{single}
+	}
+	for _, st := range statements {
+		if st.Effect != "Allow" || !anyOf(st.Action, "sqs:SendMessage", "sqs:*", "*") {
+			continue
+		}
+		principal, _ := st.Principal.(map[string]any)
+		if st.Principal != "*" && !anyOf(principal["Service"], "sns.amazonaws.com") && !anyOf(principal["AWS"], "*") {
+			continue
+		}
+		source, conditioned := st.Condition["ArnEquals"]["aws:SourceArn"]
+		if !conditioned || anyOf(source, topicArn) {
+			return true
+		}
+	}
+	return false
+}
+
+// anyOf reports whether v, a policy string or list of strings, holds one of
+// want.
+func anyOf(v any, want ...string) bool {
+	switch t := v.(type) {
+	case string:
+		return slices.Contains(want, t)
+	case []any:
+		for _, e := range t {
+			if s, ok := e.(string); ok && slices.Contains(want, s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// enqueue adds msg to the queue, deduplicating on FIFO queues; e.mu must be
+// held.
+func (e *Emulator) enqueue(q *emuQueue, msg *emuMessage) {
+	now := time.Now()
+	if q.fifo {
+		if msg.dedupID == "" {
+			msg.dedupID = contentDedupID(msg.body)
+		}
+		if _, dup := seen(q.dedup, msg.dedupID, now); dup {
+			return
+		}
+		if msg.group == "" {
+			// A standard topic cannot deliver to FIFO queues, so this is
+			// only reached through SendMessage without a group.
+			msg.group = "default"
+		}
+		msg.seq = e.nextSeq()
+		q.dedup[msg.dedupID] = emuDedup{messageID: msg.id, seq: msg.seq, at: now}
+	} else {
+		msg.group, msg.dedupID = "", ""
+	}
+	if msg.id == "" {
+		msg.id = e.nextID()
+	}
+	msg.sent = now
+	if msg.visibleAt.IsZero() {
+		msg.visibleAt = now.Add(q.seconds("DelaySeconds", 0))
+	}
+	q.msgs = append(q.msgs, msg)
+	close(q.arrived)
+	q.arrived = make(chan struct{})
+}
+
+// seconds returns the queue attribute name as a duration, or def.
+func (q *emuQueue) seconds(name string, def time.Duration) time.Duration {
+	if n, err := strconv.Atoi(q.attrs[name]); err == nil {
+		return time.Duration(n) * time.Second
+	}
+	return def
+}
+
+// emulatorSQS is the SQS client of an Emulator.
+type emulatorSQS struct{ e *Emulator }
+
+var (
+	_ sqsprovider.API      = emulatorSQS{}
+	_ snsprovider.QueueAPI = emulatorSQS{}
+)
+
+// queue returns the queue of a queue URL; e.mu must be held.
+func (e *Emulator) queue(queueURL *string) (*emuQueue, error) {
+	name := strings.TrimPrefix(aws.ToString(queueURL), emulatorQueueURL)
+	q, ok := e.queues[name]
+	if !ok {
+		return nil, &sqstypes.QueueDoesNotExist{Message: aws.String("The specified queue does not exist.")}
+	}
+	return q, nil
+}
+
+func (c emulatorSQS) GetQueueUrl(ctx context.Context, in *sqs.GetQueueUrlInput, _ ...func(*sqs.Options)) (*sqs.GetQueueUrlOutput, error) {
+	e := c.e
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	queueURL := emulatorQueueURL + aws.ToString(in.QueueName)
+	if _, err := e.queue(&queueURL); err != nil {
+		return nil, err
+	}
+	return &sqs.GetQueueUrlOutput{QueueUrl: &queueURL}, nil
+}
+
+func (c emulatorSQS) GetQueueAttributes(ctx context.Context, in *sqs.GetQueueAttributesInput, _ ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error) {
+	e := c.e
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	q, err := e.queue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	visible, inFlight, delayed := 0, 0, 0
+	for _, m := range q.msgs {
+		switch {
+		case !m.visibleAt.After(now):
+			visible++
+		case m.receives > 0:
+			inFlight++
+		default:
+			delayed++
+		}
+	}
+	all := maps.Clone(q.attrs)
+	all[string(sqstypes.QueueAttributeNameQueueArn)] = q.arn
+	all[string(sqstypes.QueueAttributeNameApproximateNumberOfMessages)] = strconv.Itoa(visible)
+	all[string(sqstypes.QueueAttributeNameApproximateNumberOfMessagesNotVisible)] = strconv.Itoa(inFlight)
+	all[string(sqstypes.QueueAttributeNameApproximateNumberOfMessagesDelayed)] = strconv.Itoa(delayed)
+	if q.fifo {
+		all[string(sqstypes.QueueAttributeNameFifoQueue)] = "true"
+	}
+	attrs := map[string]string{}
+	for _, name := range in.AttributeNames {
+		if name == sqstypes.QueueAttributeNameAll {
+			attrs = all
+			break
+		}
+		if v, ok := all[string(name)]; ok {
+			attrs[string(name)] = v
+		}
+	}
+	return &sqs.GetQueueAttributesOutput{Attributes: attrs}, nil
+}
+
+func (c emulatorSQS) SetQueueAttributes(ctx context.Context, in *sqs.SetQueueAttributesInput, _ ...func(*sqs.Options)) (*sqs.SetQueueAttributesOutput, error) {
+	e := c.e
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	q, err := e.queue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	maps.Copy(q.attrs, in.Attributes)
+	return &sqs.SetQueueAttributesOutput{}, nil
+}
+
+func (c emulatorSQS) SendMessage(ctx context.Context, in *sqs.SendMessageInput, _ ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	e := c.e
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	q, err := e.queue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := e.sendMessage(q, aws.ToString(in.MessageBody), in.DelaySeconds, in.MessageAttributes, aws.ToString(in.MessageGroupId), aws.ToString(in.MessageDeduplicationId))
+	if err != nil {
+		return nil, err
+	}
+	out := &sqs.SendMessageOutput{MessageId: aws.String(msg.id), MD5OfMessageBody: aws.String(md5Hex(msg.body))}
+	if msg.seq != "" {
+		out.SequenceNumber = aws.String(msg.seq)
+	}
+	return out, nil
+}
+
+func (c emulatorSQS) SendMessageBatch(ctx context.Context, in *sqs.SendMessageBatchInput, _ ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error) {
+	if len(in.Entries) == 0 {
+		return nil, &sqstypes.EmptyBatchRequest{Message: aws.String("There should be at least one SendMessageBatchRequestEntry in the request.")}
+	}
+	if len(in.Entries) > 10 {
+		return nil, &sqstypes.TooManyEntriesInBatchRequest{Message: aws.String("Maximum number of entries per request are 10.")}
+	}
+	e := c.e
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	q, err := e.queue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	out := &sqs.SendMessageBatchOutput{}
+	for _, entry := range in.Entries {
+		msg, err := e.sendMessage(q, aws.ToString(entry.MessageBody), entry.DelaySeconds, entry.MessageAttributes, aws.ToString(entry.MessageGroupId), aws.ToString(entry.MessageDeduplicationId))
+		if err != nil {
+			out.Failed = append(out.Failed, sqstypes.BatchResultErrorEntry{
+				Id: entry.Id, Code: aws.String("InvalidParameterValue"), Message: aws.String(err.Error()), SenderFault: true,
+			})
+			continue
+		}
+		result := sqstypes.SendMessageBatchResultEntry{Id: entry.Id, MessageId: aws.String(msg.id), MD5OfMessageBody: aws.String(md5Hex(msg.body))}
+		if msg.seq != "" {
+			result.SequenceNumber = aws.String(msg.seq)
+		}
+		out.Successful = append(out.Successful, result)
+	}
+	return out, nil
+}
+
+// sendMessage validates and enqueues a message sent to q; e.mu must be
+// held.
+func (e *Emulator) sendMessage(q *emuQueue, body string, delay int32, attrs map[string]sqstypes.MessageAttributeValue, group, dedupID string) (*emuMessage, error) {
+	if body == "" {
+		return nil, fmt.Errorf("sqs emulator: message body must not be empty")
+	}
+	if q.fifo {
+		if group == "" {
+			return nil, fmt.Errorf("sqs emulator: MessageGroupId is required for FIFO queues")
+		}
+		if dedupID == "" && q.attrs["ContentBasedDeduplication"] != "true" {
+			return nil, fmt.Errorf("sqs emulator: FIFO queue %s needs a MessageDeduplicationId or ContentBasedDeduplication", q.name)
+		}
+		if delay != 0 {
+			return nil, fmt.Errorf("sqs emulator: FIFO queues support DelaySeconds only per queue")
+		}
+	}
+	msg := &emuMessage{id: e.nextID(), body: body, attrs: maps.Clone(attrs), group: group, dedupID: dedupID}
+	if delay > 0 {
+		msg.visibleAt = time.Now().Add(time.Duration(delay) * time.Second)
+	}
+	e.enqueue(q, msg)
+	return msg, nil
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c emulatorSQS) ReceiveMessage(ctx context.Context, in *sqs.ReceiveMessageInput, _ ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	e := c.e
+	deadline := time.Now().Add(time.Duration(in.WaitTimeSeconds) * time.Second)
+	for {
+		e.mu.Lock()
+		q, err := e.queue(in.QueueUrl)
+		if err != nil {
+			e.mu.Unlock()
+			return nil, err
+		}
+		msgs, next := e.receive(q, in)
+		arrived := q.arrived
+		e.mu.Unlock()
+
+		wait := time.Until(deadline)
+		if len(msgs) > 0 || wait <= 0 {
+			return &sqs.ReceiveMessageOutput{Messages: msgs}, nil
+		}
+		if !next.IsZero() {
+			wait = min(wait, time.Until(next))
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-arrived:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// receive returns the messages a ReceiveMessage call gets from q now, and
+// when the next hidden message becomes visible; e.mu must be held.
+func (e *Emulator) receive(q *emuQueue, in *sqs.ReceiveMessageInput) ([]sqstypes.Message, time.Time) {
+	now := time.Now()
+	limit := max(int(in.MaxNumberOfMessages), 1)
+	visibility := q.seconds("VisibilityTimeout", defaultVisibilityTimeout)
+	if in.VisibilityTimeout > 0 {
+		visibility = time.Duration(in.VisibilityTimeout) * time.Second
+	}
+	var redrive struct {
+		DeadLetterTargetArn string `json:"deadLetterTargetArn"`
+		MaxReceiveCount     any    `json:"maxReceiveCount"`
+	}
+	_ = json.Unmarshal([]byte(q.attrs["RedrivePolicy"]), &redrive)
+	maxReceives, _ := strconv.Atoi(fmt.Sprint(redrive.MaxReceiveCount))
+	dlq := e.queueByARN(redrive.DeadLetterTargetArn)
+
+	var out []sqstypes.Message
+	var next time.Time
+	blocked := map[string]bool{} // FIFO groups with an earlier message hidden
+	kept := q.msgs[:0]
+	for _, m := range q.msgs {
+		if m.visibleAt.After(now) {
+			if next.IsZero() || m.visibleAt.Before(next) {
+				next = m.visibleAt
+			}
+			if q.fifo {
+				blocked[m.group] = true
+			}
+			kept = append(kept, m)
+			continue
+		}
+		if len(out) >= limit || blocked[m.group] {
+			kept = append(kept, m)
+			continue
+		}
+		if dlq != nil && maxReceives > 0 && m.receives >= maxReceives {
+			moved := *m
+			moved.sourceArn, moved.receives, moved.receipt = q.arn, 0, ""
+			moved.visibleAt, moved.firstReceive = time.Time{}, time.Time{}
+			moved.seq = ""
+			e.enqueue(dlq, &moved)
+			continue
+		}
+		m.receives++
+		if m.firstReceive.IsZero() {
+			m.firstReceive = now
+		}
+		m.visibleAt = now.Add(visibility)
+		m.receipt = fmt.Sprintf("%s#%d", m.id, m.receives)
+		out = append(out, m.sqsMessage())
+		kept = append(kept, m)
+	}
+	q.msgs = kept
+	return out, next
+}
+
+func (m *emuMessage) sqsMessage() sqstypes.Message {
+	attrs := map[string]string{
+		string(sqstypes.MessageSystemAttributeNameSenderId):                         emulatorAccount(),
+		string(sqstypes.MessageSystemAttributeNameSentTimestamp):                    strconv.FormatInt(m.sent.UnixMilli(), 10),
+		string(sqstypes.MessageSystemAttributeNameApproximateReceiveCount):          strconv.Itoa(m.receives),
+		string(sqstypes.MessageSystemAttributeNameApproximateFirstReceiveTimestamp): strconv.FormatInt(m.firstReceive.UnixMilli(), 10),
+	}
+	if m.group != "" {
+		attrs[string(sqstypes.MessageSystemAttributeNameMessageGroupId)] = m.group
+		attrs[string(sqstypes.MessageSystemAttributeNameMessageDeduplicationId)] = m.dedupID
+		attrs[string(sqstypes.MessageSystemAttributeNameSequenceNumber)] = m.seq
+	}
+	if m.sourceArn != "" {
+		attrs[string(sqstypes.MessageSystemAttributeNameDeadLetterQueueSourceArn)] = m.sourceArn
+	}
+	msg := sqstypes.Message{
+		MessageId:     aws.String(m.id),
+		ReceiptHandle: aws.String(m.receipt),
+		Body:          aws.String(m.body),
+		MD5OfBody:     aws.String(md5Hex(m.body)),
+		Attributes:    attrs,
+	}
+	if len(m.attrs) > 0 {
+		msg.MessageAttributes = maps.Clone(m.attrs)
+	}
+	return msg
+}
+
+// inFlight returns the in-flight message with the receipt handle; e.mu
+// must be held.
+func (q *emuQueue) inFlight(receipt string) (int, bool) {
+	now := time.Now()
+	for i, m := range q.msgs {
+		if m.receipt == receipt && m.visibleAt.After(now) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (c emulatorSQS) DeleteMessage(ctx context.Context, in *sqs.DeleteMessageInput, _ ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
+	e := c.e
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	q, err := e.queue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	// Like SQS, deleting with a stale receipt handle succeeds without
+	// deleting a message received again since.
+	for i, m := range q.msgs {
+		if m.receipt == aws.ToString(in.ReceiptHandle) {
+			q.msgs = slices.Delete(q.msgs, i, i+1)
+			break
+		}
+	}
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func (c emulatorSQS) ChangeMessageVisibility(ctx context.Context, in *sqs.ChangeMessageVisibilityInput, _ ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+	e := c.e
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	q, err := e.queue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	i, ok := q.inFlight(aws.ToString(in.ReceiptHandle))
+	if !ok {
+		return nil, &sqstypes.MessageNotInflight{Message: aws.String("Message does not exist or is not available for visibility timeout change.")}
+	}
+	q.msgs[i].visibleAt = time.Now().Add(time.Duration(in.VisibilityTimeout) * time.Second)
+	if in.VisibilityTimeout == 0 {
+		close(q.arrived)
+		q.arrived = make(chan struct{})
+	}
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
@@ -0,0 +1,201 @@
+package snstest
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"oss.nandlabs.io/golly-aws/sns"
+	"oss.nandlabs.io/golly-aws/sqs"
+	"oss.nandlabs.io/golly/messaging"
+)
+
+// receiveAll drains the visible messages of an emulated queue through the
+// sqs provider; an empty queue yields none.
+func receiveAll(t *testing.T, queue string) []*sqs.MessageSQS {
+	t.Helper()
+	u, _ := url.Parse("sqs://" + queue)
+	msgs, err := (&sqs.Provider{}).ReceiveBatchCtx(context.Background(), u,
+		messaging.NewOptionsBuilder().Add(sqs.OptWaitTimeSeconds, 0).Build()...)
+	if err != nil && strings.Contains(err.Error(), "no messages available") {
+		return nil
+	}
+	if err != nil {
+		t.Fatalf("ReceiveBatch %s: %v", queue, err)
+	}
+	out := make([]*sqs.MessageSQS, len(msgs))
+	for i, m := range msgs {
+		out[i] = m.(*sqs.MessageSQS)
+	}
+	return out
+}
+
+func TestEmulator_FanOutWithFilterAndRawDelivery(t *testing.T) {
+	emu := NewEmulator()
+	defer emu.Install()()
+	ctx := context.Background()
+	emu.CreateQueue("orders", nil)
+	emu.CreateQueue("audit", nil)
+	topic, _ := url.Parse("sns://orders-topic")
+	if _, err := sns.Admin().EnsureTopic(ctx, topic, nil); err != nil {
+		t.Fatalf("EnsureTopic: %v", err)
+	}
+	raw := true
+	if _, _, err := sns.Admin().Subscribe(ctx, topic, &sns.Subscription{
+		Endpoint:           "sqs://orders",
+		FilterPolicy:       `{"kind":["order"]}`,
+		FilterPolicyScope:  sns.FilterOnBody,
+		RawMessageDelivery: &raw,
+	}); err != nil {
+		t.Fatalf("Subscribe orders: %v", err)
+	}
+	if _, _, err := sns.Admin().Subscribe(ctx, topic, &sns.Subscription{Endpoint: "sqs://audit"}); err != nil {
+		t.Fatalf("Subscribe audit: %v", err)
+	}
+
+	p := &sns.Provider{}
+	for _, body := range []string{`{"kind":"order","id":1}`, `{"kind":"refund","id":2}`} {
+		msg, _ := p.NewMessage(sns.SNSScheme)
+		_, _ = msg.SetBodyStr(body)
+		if err := p.SendCtx(ctx, topic, msg, messaging.NewOptionsBuilder().Add(sns.OptSubject, "order events").Build()...); err != nil {
+			t.Fatalf("SendCtx: %v", err)
+		}
+	}
+
+	orders := receiveAll(t, "orders")
+	if len(orders) != 1 || orders[0].ReadAsStr() != `{"kind":"order","id":1}` {
+		t.Fatalf("orders queue got %d messages, want the raw order", len(orders))
+	}
+	audit := receiveAll(t, "audit")
+	if len(audit) != 2 {
+		t.Fatalf("audit queue got %d messages, want 2", len(audit))
+	}
+	var env queueEnvelope
+	if err := json.Unmarshal([]byte(audit[1].ReadAsStr()), &env); err != nil {
+		t.Fatalf("audit message is not an envelope: %v", err)
+	}
+	if env.Type != "Notification" || env.TopicArn != emulatorARNPrefix+"orders-topic" ||
+		env.Subject != "order events" || env.Message != `{"kind":"refund","id":2}` {
+		t.Fatalf("envelope = %+v", env)
+	}
+
+	if err := orders[0].Rsvp(true); err != nil {
+		t.Fatalf("Rsvp: %v", err)
+	}
+	if n := emu.QueueLength("orders"); n != 0 {
+		t.Fatalf("orders queue holds %d messages after the ack", n)
+	}
+	if n := emu.QueueLength("audit"); n != 2 {
+		t.Fatalf("audit queue holds %d in-flight messages, want 2", n)
+	}
+}
+
+func TestEmulator_FIFOOrderPerGroup(t *testing.T) {
+	emu := NewEmulator()
+	defer emu.Install()()
+	ctx := context.Background()
+	emu.CreateQueue("ledger.fifo", nil)
+	topic, _ := url.Parse("sns://ledger.fifo")
+	if _, err := sns.Admin().EnsureTopic(ctx, topic, nil); err != nil {
+		t.Fatalf("EnsureTopic: %v", err)
+	}
+	if _, _, err := sns.Admin().Subscribe(ctx, topic, &sns.Subscription{Endpoint: "sqs://ledger.fifo"}); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	p := &sns.Provider{}
+	send := func(group, id string) {
+		t.Helper()
+		msg, _ := p.NewMessage(sns.SNSScheme)
+		_, _ = msg.SetBodyStr(group + id)
+		opts := messaging.NewOptionsBuilder().Add(sns.OptMessageGroupId, group).Add(sns.OptMessageDeduplicationId, group+id).Build()
+		if err := p.SendCtx(ctx, topic, msg, opts...); err != nil {
+			t.Fatalf("SendCtx %s%s: %v", group, id, err)
+		}
+	}
+	send("a", "1")
+	send("b", "1")
+	send("a", "1") // duplicate
+	send("a", "2")
+
+	bodies := func(msgs []*sqs.MessageSQS) []string {
+		var out []string
+		for _, m := range msgs {
+			var env queueEnvelope
+			_ = json.Unmarshal([]byte(m.ReadAsStr()), &env)
+			out = append(out, env.Message)
+		}
+		return out
+	}
+	first := receiveAll(t, "ledger.fifo")
+	if got, want := bodies(first), []string{"a1", "b1", "a2"}; !slices.Equal(got, want) {
+		t.Fatalf("received %v, want %v", got, want)
+	}
+	if first[0].MessageGroupId() != "a" || first[0].SequenceNumber() >= first[2].SequenceNumber() {
+		t.Fatalf("group %q, sequence numbers %s and %s", first[0].MessageGroupId(), first[0].SequenceNumber(), first[2].SequenceNumber())
+	}
+
+	// Group a stays blocked while its messages are in flight.
+	send("a", "3")
+	if got := receiveAll(t, "ledger.fifo"); len(got) != 0 {
+		t.Fatalf("received %v while group a is in flight", bodies(got))
+	}
+	for _, m := range first {
+		if m.MessageGroupId() == "a" {
+			_ = m.Rsvp(true)
+		}
+	}
+	if got, want := bodies(receiveAll(t, "ledger.fifo")), []string{"a3"}; !slices.Equal(got, want) {
+		t.Fatalf("received %v after the ack, want %v", got, want)
+	}
+}
+
+func TestEmulator_QueuePolicyAndRedrive(t *testing.T) {
+	emu := NewEmulator()
+	defer emu.Install()()
+	ctx := context.Background()
+	emu.CreateQueue("jobs-dlq", nil)
+	emu.CreateQueue("jobs", map[string]string{
+		"VisibilityTimeout": "0",
+		"RedrivePolicy":     `{"deadLetterTargetArn":"` + emulatorQueueARN + `jobs-dlq","maxReceiveCount":"1"}`,
+	})
+	emu.CreateQueue("locked", nil)
+	topic, _ := url.Parse("sns://jobs")
+	if _, err := sns.Admin().EnsureTopic(ctx, topic, nil); err != nil {
+		t.Fatalf("EnsureTopic: %v", err)
+	}
+	raw := true
+	for _, sub := range []*sns.Subscription{
+		{Endpoint: "sqs://jobs", RawMessageDelivery: &raw},
+		{Endpoint: "sqs://locked", SkipQueuePolicy: true},
+	} {
+		if _, _, err := sns.Admin().Subscribe(ctx, topic, sub); err != nil {
+			t.Fatalf("Subscribe %s: %v", sub.Endpoint, err)
+		}
+	}
+
+	p := &sns.Provider{}
+	msg, _ := p.NewMessage(sns.SNSScheme)
+	_, _ = msg.SetBodyStr("job-1")
+	if err := p.SendCtx(ctx, topic, msg); err != nil {
+		t.Fatalf("SendCtx: %v", err)
+	}
+	if n := emu.QueueLength("locked"); n != 0 {
+		t.Fatalf("queue without a policy for the topic got %d messages", n)
+	}
+
+	if got := receiveAll(t, "jobs"); len(got) != 1 || got[0].ReceiveCount() != 1 {
+		t.Fatalf("first receive got %d messages", len(got))
+	}
+	// The message is visible again at once and has reached maxReceiveCount.
+	if got := receiveAll(t, "jobs"); len(got) != 0 {
+		t.Fatalf("second receive got %d messages, want the message redriven", len(got))
+	}
+	dead := receiveAll(t, "jobs-dlq")
+	if len(dead) != 1 || dead[0].ReadAsStr() != "job-1" || dead[0].DeadLetterQueueSourceArn() != emulatorQueueARN+"jobs" {
+		t.Fatalf("dead-letter queue got %d messages", len(dead))
+	}
+}
@@ -0,0 +1,5 @@
+package snstest
+
+import "oss.nandlabs.io/golly/l3"
+
+var logger = l3.Get()
@@ -0,0 +1,285 @@
+package sns
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"oss.nandlabs.io/golly/messaging"
+)
+
+const (
+	// maxPublishSize is the largest message SNS accepts, in bytes.
+	maxPublishSize = 256 * 1024
+	// maxPushPayloadSize is the largest APNs and FCM payload, in bytes.
+	maxPushPayloadSize = 4096
+)
+
+// ProtocolMessages is the per-protocol body of a MessageStructure=json
+// publish: each subscriber receives the text for its protocol, or Default.
+// Build escapes the texts and encodes the push payloads, so SQS and
+// Lambda bodies can be JSON documents as they are.
+type ProtocolMessages struct {
+	// Default is delivered to protocols without a text of their own. It is
+	// required.
+	Default   string
+	SQS       string
+	Lambda    string
+	HTTP      string
+	HTTPS     string
+	Email     string
+	EmailJSON string
+	SMS       string
+	Firehose  string
+
+	// APNS and APNSSandbox are delivered to iOS and macOS endpoints of
+	// production and sandbox APNs platform applications.
+	APNS        *APNSPayload
+	APNSSandbox *APNSPayload
+	// FCM is delivered to Android endpoints of FCM (GCM) platform
+	// applications.
+	FCM *FCMPayload
+
+	// Other holds the JSON payloads of further platforms by their SNS key,
+	// e.g. "ADM", "BAIDU" or "WNS".
+	Other map[string]string
+}
+
+// APNSPayload is an Apple push notification. See Apple's "Generating a
+// remote notification" for the meaning of the fields.
+type APNSPayload struct {
+	Alert *APNSAlert
+	Badge *int
+	Sound string
+	// ContentAvailable wakes the app to fetch content in the background.
+	ContentAvailable bool
+	// MutableContent lets a notification service extension modify the
+	// notification.
+	MutableContent bool
+	Category       string
+	ThreadID       string
+	// Data holds custom keys, sent next to "aps".
+	Data map[string]any
+}
+
+// APNSAlert is the visible part of an Apple push notification.
+type APNSAlert struct {
+	Title    string
+	Subtitle string
+	Body     string
+}
+
+// FCMPriority is the Android delivery priority of an FCM message.
+type FCMPriority string
+
+const (
+	// FCMPriorityNormal delivers when the device is awake.
+	FCMPriorityNormal FCMPriority = "NORMAL"
+	// FCMPriorityHigh wakes a sleeping device.
+	FCMPriorityHigh FCMPriority = "HIGH"
+)
+
+// FCMPayload is a Firebase Cloud Messaging message, sent in the FCM HTTP v1
+// format.
+type FCMPayload struct {
+	// Title, Body and Image make up the notification; leave them empty for
+	// a data-only message.
+	Title string
+	Body  string
+	Image string
+	// Data holds custom key-value pairs for the app.
+	Data map[string]string
+
+	Priority FCMPriority
+	// TTL is how long FCM keeps the message for an offline device, in
+	// whole seconds; zero keeps the FCM default of four weeks.
+	TTL time.Duration
+	// CollapseKey replaces undelivered messages with the same key.
+	CollapseKey string
+}
+
+// Build returns the JSON object to publish with MessageStructure=json. It
+// fails when Default is empty or a payload exceeds the SNS or platform size
+// limits.
+func (pm *ProtocolMessages) Build() (string, error) {
+	if pm.Default == "" {
+		return "", errors.New("sns: protocol messages need a Default text")
+	}
+	texts := map[string]string{"default": pm.Default}
+	for key, text := range map[string]string{
+		"sqs":        pm.SQS,
+		"lambda":     pm.Lambda,
+		"http":       pm.HTTP,
+		"https":      pm.HTTPS,
+		"email":      pm.Email,
+		"email-json": pm.EmailJSON,
+		"sms":        pm.SMS,
+		"firehose":   pm.Firehose,
+	} {
+		if text != "" {
+			texts[key] = text
+		}
+	}
+	for key, payload := range map[string]*APNSPayload{"APNS": pm.APNS, "APNS_SANDBOX": pm.APNSSandbox} {
+		if payload == nil {
+			continue
+		}
+		text, err := pushPayload(key, payload.json)
+		if err != nil {
+			return "", err
+		}
+		texts[key] = text
+	}
+	if pm.FCM != nil {
+		text, err := pushPayload("GCM", pm.FCM.json)
+		if err != nil {
+			return "", err
+		}
+		texts["GCM"] = text
+	}
+	for key, text := range pm.Other {
+		if _, taken := texts[key]; taken {
+			return "", fmt.Errorf("sns: protocol messages set %q twice", key)
+		}
+		if !json.Valid([]byte(text)) {
+			return "", fmt.Errorf("sns: %s payload is not valid JSON", key)
+		}
+		texts[key] = text
+	}
+
+	raw, err := json.Marshal(texts)
+	if err != nil {
+		return "", fmt.Errorf("sns: failed to encode protocol messages: %w", err)
+	}
+	if len(raw) > maxPublishSize {
+		return "", fmt.Errorf("sns: protocol messages are %d bytes; SNS accepts at most %d", len(raw), maxPublishSize)
+	}
+	return string(raw), nil
+}
+
+// pushPayload encodes a platform payload and checks its size.
+func pushPayload(key string, encode func() (map[string]any, error)) (string, error) {
+	obj, err := encode()
+	if err != nil {
+		return "", fmt.Errorf("sns: %s payload: %w", key, err)
+	}
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		return "", fmt.Errorf("sns: failed to encode %s payload: %w", key, err)
+	}
+	if len(raw) > maxPushPayloadSize {
+		return "", fmt.Errorf("sns: %s payload is %d bytes; the platform accepts at most %d", key, len(raw), maxPushPayloadSize)
+	}
+	return string(raw), nil
+}
+
+func (p *APNSPayload) json() (map[string]any, error) {
+	aps := map[string]any{}
+	if a := p.Alert; a != nil {
+		alert := map[string]string{}
+		for k, v := range map[string]string{"title": a.Title, "subtitle": a.Subtitle, "body": a.Body} {
+			if v != "" {
+				alert[k] = v
+			}
+		}
+		aps["alert"] = alert
+	}
+	if p.Badge != nil {
+		aps["badge"] = *p.Badge
+	}
+	if p.Sound != "" {
+		aps["sound"] = p.Sound
+	}
+	if p.ContentAvailable {
+		aps["content-available"] = 1
+	}
+	if p.MutableContent {
+		aps["mutable-content"] = 1
+	}
+	if p.Category != "" {
+		aps["category"] = p.Category
+	}
+	if p.ThreadID != "" {
+		aps["thread-id"] = p.ThreadID
+	}
+	if len(aps) == 0 {
+		return nil, errors.New("needs an alert, badge, sound or content-available")
+	}
+	obj := map[string]any{"aps": aps}
+	for k, v := range p.Data {
+		if k == "aps" {
+			return nil, errors.New(`Data cannot use the reserved key "aps"`)
+		}
+		obj[k] = v
+	}
+	return obj, nil
+}
+
+func (p *FCMPayload) json() (map[string]any, error) {
+	message := map[string]any{}
+	notification := map[string]string{}
+	for k, v := range map[string]string{"title": p.Title, "body": p.Body, "image": p.Image} {
+		if v != "" {
+			notification[k] = v
+		}
+	}
+	if len(notification) > 0 {
+		message["notification"] = notification
+	}
+	if len(p.Data) > 0 {
+		message["data"] = p.Data
+	}
+	if len(message) == 0 {
+		return nil, errors.New("needs a notification or data")
+	}
+	android := map[string]string{}
+	switch p.Priority {
+	case "":
+	case FCMPriorityNormal, FCMPriorityHigh:
+		android["priority"] = string(p.Priority)
+	default:
+		return nil, fmt.Errorf("priority %q is not recognized", p.Priority)
+	}
+	if p.TTL < 0 {
+		return nil, errors.New("TTL cannot be negative")
+	}
+	if p.TTL > 0 {
+		android["ttl"] = strconv.FormatInt(int64(p.TTL/time.Second), 10) + "s"
+	}
+	if p.CollapseKey != "" {
+		android["collapse_key"] = p.CollapseKey
+	}
+	if len(android) > 0 {
+		message["android"] = android
+	}
+	return map[string]any{"fcmV1Message": map[string]any{"message": message}}, nil
+}
+
+// SetProtocolMessages builds pm into the body of the message. While the
+// body stays as built, Send and SendBatch publish it with
+// MessageStructure=json without the option being set.
+func (m *MessageSNS) SetProtocolMessages(pm *ProtocolMessages) error {
+	body, err := pm.Build()
+	if err != nil {
+		return err
+	}
+	if _, err := m.SetBodyStr(body); err != nil {
+		return err
+	}
+	m.structured = body
+	return nil
+}
+
+// messageStructure returns the MessageStructure to publish msg with: the
+// option, or "json" for a body built by SetProtocolMessages.
+func messageStructure(msg messaging.Message, optResolver *messaging.OptionsResolver) (string, bool) {
+	if v, ok := optResolver.Get(OptMessageStructure); ok {
+		return v.(string), true
+	}
+	if m, ok := msg.(*MessageSNS); ok && m.structured != "" && m.ReadAsStr() == m.structured {
+		return "json", true
+	}
+	return "", false
+}
@@ -0,0 +1,127 @@
+package sns
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"oss.nandlabs.io/golly/messaging"
+)
+
+func TestProtocolMessages_Build(t *testing.T) {
+	badge := 3
+	pm := &ProtocolMessages{
+		Default: "Order 12345 shipped",
+		SQS:     `{"event":"order.shipped","orderId":"12345"}`,
+		Email:   "Your order #12345 has been shipped!",
+		APNS: &APNSPayload{
+			Alert: &APNSAlert{Title: "Shipped", Body: "Order 12345"},
+			Badge: &badge,
+			Data:  map[string]any{"orderId": "12345"},
+		},
+		FCM: &FCMPayload{
+			Title:    "Shipped",
+			Data:     map[string]string{"orderId": "12345"},
+			Priority: FCMPriorityHigh,
+			TTL:      time.Hour,
+		},
+		Other: map[string]string{"ADM": `{"data":{"message":"shipped"}}`},
+	}
+	raw, err := pm.Build()
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	var texts map[string]string
+	if err := json.Unmarshal([]byte(raw), &texts); err != nil {
+		t.Fatalf("Build output is not a JSON object of strings: %v\n%s", err, raw)
+	}
+	if texts["default"] != pm.Default || texts["sqs"] != pm.SQS || texts["ADM"] == "" {
+		t.Fatalf("texts = %v", texts)
+	}
+	if _, ok := texts["sms"]; ok {
+		t.Fatalf("empty sms text was included")
+	}
+
+	var apns struct {
+		Aps struct {
+			Alert map[string]string `json:"alert"`
+			Badge int               `json:"badge"`
+		} `json:"aps"`
+		OrderID string `json:"orderId"`
+	}
+	if err := json.Unmarshal([]byte(texts["APNS"]), &apns); err != nil || apns.Aps.Alert["title"] != "Shipped" || apns.Aps.Badge != 3 || apns.OrderID != "12345" {
+		t.Fatalf("APNS = %s (%v)", texts["APNS"], err)
+	}
+	var fcm struct {
+		V1 struct {
+			Message struct {
+				Notification map[string]string `json:"notification"`
+				Data         map[string]string `json:"data"`
+				Android      map[string]string `json:"android"`
+			} `json:"message"`
+		} `json:"fcmV1Message"`
+	}
+	if err := json.Unmarshal([]byte(texts["GCM"]), &fcm); err != nil {
+		t.Fatalf("GCM = %s: %v", texts["GCM"], err)
+	}
+	if m := fcm.V1.Message; m.Notification["title"] != "Shipped" || m.Data["orderId"] != "12345" || m.Android["priority"] != "HIGH" || m.Android["ttl"] != "3600s" {
+		t.Fatalf("GCM = %s", texts["GCM"])
+	}
+}
+
+func TestProtocolMessages_Validation(t *testing.T) {
+	for name, pm := range map[string]*ProtocolMessages{
+		"no default":        {SQS: "x"},
+		"empty APNS":        {Default: "x", APNS: &APNSPayload{}},
+		"reserved APNS key": {Default: "x", APNS: &APNSPayload{Sound: "default", Data: map[string]any{"aps": 1}}},
+		"large APNS":        {Default: "x", APNS: &APNSPayload{Alert: &APNSAlert{Body: strings.Repeat("a", maxPushPayloadSize)}}},
+		"empty FCM":         {Default: "x", FCM: &FCMPayload{Priority: FCMPriorityHigh}},
+		"bad FCM priority":  {Default: "x", FCM: &FCMPayload{Body: "b", Priority: "urgent"}},
+		"duplicate key":     {Default: "x", Other: map[string]string{"default": `{}`}},
+		"invalid other":     {Default: "x", Other: map[string]string{"WNS": `<toast/>`}},
+		"too large":         {Default: strings.Repeat("a", maxPublishSize)},
+	} {
+		if _, err := pm.Build(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestSendCtx_ProtocolMessagesSetStructure(t *testing.T) {
+	srv := newSNSFakeServer()
+	defer srv.Close()
+	registerFakeSNS(t, "sns", srv.URL)
+	p := &Provider{}
+	u, _ := url.Parse("sns:///arn:aws:sns:us-east-1:123456789012:orders")
+
+	m, _ := p.NewMessage(SNSScheme)
+	msg := m.(*MessageSNS)
+	if err := msg.SetProtocolMessages(&ProtocolMessages{Default: "d", SMS: "s"}); err != nil {
+		t.Fatalf("SetProtocolMessages: %v", err)
+	}
+	if err := p.SendCtx(context.Background(), u, msg); err != nil {
+		t.Fatalf("SendCtx: %v", err)
+	}
+	if err := p.SendBatchCtx(context.Background(), u, []messaging.Message{msg}); err != nil {
+		t.Fatalf("SendBatchCtx: %v", err)
+	}
+	// A body replaced after building is published as it is.
+	_, _ = msg.SetBodyStr("plain")
+	if err := p.SendCtx(context.Background(), u, msg); err != nil {
+		t.Fatalf("SendCtx: %v", err)
+	}
+
+	reqs := srv.captured()
+	if got := reqs[0].Form.Get("MessageStructure"); got != "json" {
+		t.Fatalf("Publish MessageStructure = %q", got)
+	}
+	if got := reqs[1].Form.Get("PublishBatchRequestEntries.member.1.MessageStructure"); got != "json" {
+		t.Fatalf("PublishBatch MessageStructure = %q", got)
+	}
+	if reqs[2].Form.Has("MessageStructure") || reqs[2].Form.Get("Message") != "plain" {
+		t.Fatalf("replaced body published as %v", reqs[2].Form)
+	}
+}
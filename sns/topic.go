@@ -0,0 +1,229 @@
+package sns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"oss.nandlabs.io/golly-aws/awscfg"
+	"oss.nandlabs.io/golly/messaging"
+)
+
+// OptCreateTopic makes Send and SendBatch create a topic named in the URL
+// that does not exist yet. The value is true, or the map[string]string of
+// topic attributes to create it with (e.g. "KmsMasterKeyId"); FifoTopic is
+// set for ".fifo" names. Without it topics are only looked up, and sending
+// to a missing one fails.
+const OptCreateTopic = "CreateTopic"
+
+// topicAPI is the subset of the SNS client used to resolve topic ARNs.
+// The concrete *sns.Client satisfies it.
+type topicAPI interface {
+	CreateTopic(ctx context.Context, params *sns.CreateTopicInput, optFns ...func(*sns.Options)) (*sns.CreateTopicOutput, error)
+	GetTopicAttributes(ctx context.Context, params *sns.GetTopicAttributesInput, optFns ...func(*sns.Options)) (*sns.GetTopicAttributesOutput, error)
+	ListTopics(ctx context.Context, params *sns.ListTopicsInput, optFns ...func(*sns.Options)) (*sns.ListTopicsOutput, error)
+}
+
+// topicKey identifies a topic name under the awscfg config (nil for the
+// default one) that decides its account and region.
+type topicKey struct {
+	cfg  *awscfg.Config
+	name string
+}
+
+var (
+	topicARNsMu sync.Mutex
+	// topicARNs caches resolved topic ARNs.
+	topicARNs = map[topicKey]string{}
+)
+
+// resolveCreateTopic returns the OptCreateTopic attributes: nil when the
+// option is unset or false, an empty map for true.
+func resolveCreateTopic(optResolver *messaging.OptionsResolver) (map[string]string, error) {
+	v, ok := optResolver.Get(OptCreateTopic)
+	if !ok {
+		return nil, nil
+	}
+	switch c := v.(type) {
+	case bool:
+		if c {
+			return map[string]string{}, nil
+		}
+		return nil, nil
+	case map[string]string:
+		return maps.Clone(c), nil
+	}
+	return nil, fmt.Errorf("sns: %s: expected bool or map[string]string, got %T", OptCreateTopic, v)
+}
+
+// resolveTopicARN returns the SNS topic ARN of the messaging URL.
+//
+// URL formats:
+//
+//	sns://topic-name                              → looked up (or created, see below)
+//	sns:///arn:aws:sns:region:account-id:topic    → ARN in path directly
+//
+// A topic name resolves to an ARN in the account and region of the URL's
+// config, built from STS GetCallerIdentity and confirmed with
+// GetTopicAttributes, or found with ListTopics when STS is unavailable.
+// When create is non-nil, CreateTopic (idempotent) is called with the
+// create attributes instead. Resolved ARNs are cached.
+func resolveTopicARN(ctx context.Context, client topicAPI, u *url.URL, create map[string]string) (string, error) {
+	// Check for ARN in path: sns:///arn:aws:sns:...
+	if u.Host == "" && u.Path != "" {
+		if arn, ok := urlARN(u); ok {
+			return arn, nil
+		}
+		return "", fmt.Errorf("sns: topic name or ARN is required")
+	}
+
+	topicName := u.Host
+	if topicName == "" {
+		return "", fmt.Errorf("sns: topic name (URL host) is required")
+	}
+
+	// Check if host is already a full ARN (shouldn't happen with URL parsing, but be safe)
+	if strings.HasPrefix(topicName, "arn:") {
+		return topicName, nil
+	}
+
+	key := topicKeyOf(u)
+	topicARNsMu.Lock()
+	arn, ok := topicARNs[key]
+	topicARNsMu.Unlock()
+	if ok {
+		return arn, nil
+	}
+
+	var err error
+	if create != nil {
+		arn, err = createTopic(ctx, client, topicName, create)
+	} else {
+		arn, err = lookupTopicARN(ctx, client, u, topicName)
+	}
+	if err != nil {
+		return "", err
+	}
+	topicARNsMu.Lock()
+	topicARNs[key] = arn
+	topicARNsMu.Unlock()
+	return arn, nil
+}
+
+// forgetTopicARN drops the cached ARN of the URL's topic, after the topic
+// turned out to be missing.
+func forgetTopicARN(u *url.URL) {
+	topicARNsMu.Lock()
+	delete(topicARNs, topicKeyOf(u))
+	topicARNsMu.Unlock()
+}
+
+func topicKeyOf(u *url.URL) topicKey {
+	return topicKey{cfg: awscfg.GetConfig(u, SNSScheme), name: u.Host}
+}
+
+// createTopic creates the topic, or returns the ARN of an existing one
+// created with the same attributes.
+func createTopic(ctx context.Context, client topicAPI, name string, attrs map[string]string) (string, error) {
+	if isFIFOTopic(name) {
+		attrs["FifoTopic"] = "true"
+	}
+	input := &sns.CreateTopicInput{Name: &name}
+	if len(attrs) > 0 {
+		input.Attributes = attrs
+	}
+	output, err := client.CreateTopic(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("sns: failed to create topic %q: %w", name, err)
+	}
+	return *output.TopicArn, nil
+}
+
+// lookupTopicARN resolves the ARN of an existing topic without creating it.
+func lookupTopicARN(ctx context.Context, client topicAPI, u *url.URL, name string) (string, error) {
+	prefix, err := topicARNPrefix(ctx, u)
+	if err != nil {
+		logger.WarnF("SNS could not build the ARN of topic %q, listing topics instead: %v", name, err)
+		return findTopicARN(ctx, client, name)
+	}
+	arn := prefix + name
+	_, err = client.GetTopicAttributes(ctx, &sns.GetTopicAttributesInput{TopicArn: &arn})
+	var authErr *types.AuthorizationErrorException
+	switch {
+	case err == nil:
+		return arn, nil
+	case isTopicMissing(err):
+		return "", missingTopicError(name)
+	case errors.As(err, &authErr):
+		// Publishers need not be allowed to read attributes; Publish
+		// reports a missing topic all the same.
+		return arn, nil
+	}
+	return "", fmt.Errorf("sns: failed to resolve topic ARN for %q: %w", name, err)
+}
+
+// clearTopicARNs empties the topic ARN cache.
+func clearTopicARNs() {
+	topicARNsMu.Lock()
+	clear(topicARNs)
+	topicARNsMu.Unlock()
+}
+
+// topicARNPrefix returns "arn:<partition>:sns:<region>:<account>:" for the
+// caller of the URL's config. It is a package-level var for test injection.
+var topicARNPrefix = func(ctx context.Context, u *url.URL) (string, error) {
+	client, region, err := getSTSClient(u)
+	if err != nil {
+		return "", err
+	}
+	out, err := client.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", err
+	}
+	// The caller ARN carries the partition: arn:<partition>:iam::...
+	parts := strings.SplitN(safeDeref(out.Arn), ":", 3)
+	if len(parts) < 3 || safeDeref(out.Account) == "" || region == "" {
+		return "", fmt.Errorf("incomplete caller identity %q in region %q", safeDeref(out.Arn), region)
+	}
+	return fmt.Sprintf("arn:%s:sns:%s:%s:", parts[1], region, *out.Account), nil
+}
+
+// findTopicARN pages through ListTopics for the topic named name.
+func findTopicARN(ctx context.Context, client topicAPI, name string) (string, error) {
+	input := &sns.ListTopicsInput{}
+	for {
+		out, err := client.ListTopics(ctx, input)
+		if err != nil {
+			return "", fmt.Errorf("sns: failed to resolve topic ARN for %q: %w", name, err)
+		}
+		for _, t := range out.Topics {
+			if arn := safeDeref(t.TopicArn); strings.HasSuffix(arn, ":"+name) {
+				return arn, nil
+			}
+		}
+		if out.NextToken == nil {
+			return "", missingTopicError(name)
+		}
+		input.NextToken = out.NextToken
+	}
+}
+
+// errTopicMissing is wrapped by the error of a lookup that found no topic.
+var errTopicMissing = errors.New("topic does not exist")
+
+func missingTopicError(name string) error {
+	return fmt.Errorf("sns: topic %q does not exist; create it, or set the %s option to create it on send: %w", name, OptCreateTopic, errTopicMissing)
+}
+
+// isTopicMissing reports whether err says the topic does not exist.
+func isTopicMissing(err error) bool {
+	var nf *types.NotFoundException
+	return errors.As(err, &nf)
+}
@@ -0,0 +1,100 @@
+package sns
+
+import (
+	"context"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"oss.nandlabs.io/golly/messaging"
+)
+
+func sendTo(t *testing.T, p *Provider, rawURL string, opts ...messaging.Option) error {
+	t.Helper()
+	u, _ := url.Parse(rawURL)
+	msg, _ := p.NewMessage(SNSScheme)
+	_, _ = msg.SetBodyStr("hello")
+	return p.SendCtx(context.Background(), u, msg, opts...)
+}
+
+func TestTopicARN_LookupIsCachedAndNeverCreates(t *testing.T) {
+	srv := newSNSFakeServer()
+	defer srv.Close()
+	srv.topics = []string{"orders"}
+	registerFakeSNS(t, "orders", srv.URL)
+	registerFakeSNS(t, "typo", srv.URL)
+	p := &Provider{}
+
+	for i := 0; i < 2; i++ {
+		if err := sendTo(t, p, "sns://orders"); err != nil {
+			t.Fatalf("Send %d: %v", i, err)
+		}
+	}
+	want := []string{"GetCallerIdentity", "GetTopicAttributes", "Publish", "Publish"}
+	if got := srv.actions(); !slices.Equal(got, want) {
+		t.Fatalf("actions = %v, want %v", got, want)
+	}
+	if arn := srv.captured()[3].Form.Get("TopicArn"); arn != "arn:aws:sns:us-east-1:123456789012:orders" {
+		t.Fatalf("TopicArn = %q", arn)
+	}
+
+	err := sendTo(t, p, "sns://typo")
+	if err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Fatalf("Send to a missing topic = %v", err)
+	}
+	if slices.Contains(srv.actions(), "CreateTopic") {
+		t.Fatalf("missing topic was created: %v", srv.actions())
+	}
+}
+
+func TestTopicARN_CreateIsOptIn(t *testing.T) {
+	srv := newSNSFakeServer()
+	defer srv.Close()
+	registerFakeSNS(t, "jobs.fifo", srv.URL)
+	p := &Provider{}
+
+	opts := messaging.NewOptionsBuilder().
+		Add(OptCreateTopic, map[string]string{"KmsMasterKeyId": "alias/aws/sns"}).
+		Add(OptMessageGroupId, "g").
+		Build()
+	if err := sendTo(t, p, "sns://jobs.fifo", opts...); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if err := sendTo(t, p, "sns://jobs.fifo", opts...); err != nil {
+		t.Fatalf("second Send: %v", err)
+	}
+	if got := srv.actions(); !slices.Equal(got, []string{"CreateTopic", "Publish", "Publish"}) {
+		t.Fatalf("actions = %v", got)
+	}
+	attrs := map[string]string{}
+	form := srv.captured()[0].Form
+	for i := 1; form.Has("Attributes.entry." + strconv.Itoa(i) + ".key"); i++ {
+		n := strconv.Itoa(i)
+		attrs[form.Get("Attributes.entry."+n+".key")] = form.Get("Attributes.entry." + n + ".value")
+	}
+	if attrs["FifoTopic"] != "true" || attrs["KmsMasterKeyId"] != "alias/aws/sns" {
+		t.Fatalf("CreateTopic attributes = %v", attrs)
+	}
+}
+
+func TestTopicARN_ListsTopicsWithoutSTS(t *testing.T) {
+	srv := newSNSFakeServer()
+	defer srv.Close()
+	srv.topics = []string{"orders-archive", "orders"}
+	srv.stsDenied = true
+	registerFakeSNS(t, "orders", srv.URL)
+	p := &Provider{}
+
+	if err := sendTo(t, p, "sns://orders"); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	want := []string{"GetCallerIdentity", "ListTopics", "Publish"}
+	if got := srv.actions(); !slices.Equal(got, want) {
+		t.Fatalf("actions = %v, want %v", got, want)
+	}
+	if arn := srv.captured()[2].Form.Get("TopicArn"); arn != "arn:aws:sns:us-east-1:123456789012:orders" {
+		t.Fatalf("TopicArn = %q", arn)
+	}
+}
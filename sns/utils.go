@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"net/url"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"oss.nandlabs.io/golly-aws/awscfg"
 )
 
@@ -18,73 +21,80 @@ const (
 
 // getSNSClient creates an SNS client using the awscfg config resolved for the given URL.
 func getSNSClient(u *url.URL) (*sns.Client, error) {
-	cfg := awscfg.GetConfig(u, SNSScheme)
-	if cfg == nil {
-		// Fallback: load default AWS config
-		awsCfg, err := (&awscfg.Config{}).LoadAWSConfig(context.Background())
-		if err != nil {
-			return nil, fmt.Errorf("sns: failed to load default AWS config: %w", err)
-		}
-		return sns.NewFromConfig(awsCfg), nil
-	}
-
-	awsCfg, err := cfg.LoadAWSConfig(context.Background())
+	awsCfg, endpoint, err := loadAWSConfig(u, SNSScheme)
 	if err != nil {
-		return nil, fmt.Errorf("sns: failed to load AWS config: %w", err)
+		return nil, err
 	}
 
 	var snsOpts []func(*sns.Options)
-	if cfg.Endpoint != "" {
+	if endpoint != "" {
 		snsOpts = append(snsOpts, func(o *sns.Options) {
-			o.BaseEndpoint = &cfg.Endpoint
+			o.BaseEndpoint = &endpoint
 		})
 	}
 
 	return sns.NewFromConfig(awsCfg, snsOpts...), nil
 }
 
-// resolveTopicARN extracts the SNS topic ARN from the messaging URL.
-//
-// URL formats:
-//
-//	sns://topic-name                              → requires lookup via CreateTopic (idempotent)
-//	sns:///arn:aws:sns:region:account-id:topic    → ARN in path directly
-//
-// When a custom endpoint is set, the topic name is used to call CreateTopic
-// (which is idempotent and returns the ARN for existing topics).
-// For real AWS, if the host looks like a plain topic name, CreateTopic is called.
-// If the path starts with "arn:", it is treated as a literal ARN.
-func resolveTopicARN(client *sns.Client, u *url.URL) (string, error) {
-	// Check for ARN in path: sns:///arn:aws:sns:...
-	if u.Host == "" && u.Path != "" {
-		path := u.Path
-		if len(path) > 0 && path[0] == '/' {
-			path = path[1:]
-		}
-		if len(path) > 4 && path[:4] == "arn:" {
-			return path, nil
-		}
-		return "", fmt.Errorf("sns: topic name or ARN is required")
+// getSTSClient creates an STS client with the same config as getSNSClient,
+// including its custom endpoint (LocalStack serves every API on one).
+func getSTSClient(u *url.URL) (*sts.Client, string, error) {
+	awsCfg, endpoint, err := loadAWSConfig(u, SNSScheme)
+	if err != nil {
+		return nil, "", err
 	}
 
-	topicName := u.Host
-	if topicName == "" {
-		return "", fmt.Errorf("sns: topic name (URL host) is required")
+	var stsOpts []func(*sts.Options)
+	if endpoint != "" {
+		stsOpts = append(stsOpts, func(o *sts.Options) {
+			o.BaseEndpoint = &endpoint
+		})
 	}
 
-	// Check if host is already a full ARN (shouldn't happen with URL parsing, but be safe)
-	if len(topicName) > 4 && topicName[:4] == "arn:" {
-		return topicName, nil
+	return sts.NewFromConfig(awsCfg, stsOpts...), awsCfg.Region, nil
+}
+
+// getSQSClient creates an SQS client using the awscfg config resolved for
+// the given sqs:// URL, to manage the queues subscribed to topics. A
+// non-empty region overrides the config's, for queues named by ARN.
+func getSQSClient(u *url.URL, region string) (*sqs.Client, error) {
+	awsCfg, endpoint, err := loadAWSConfig(u, sqsScheme)
+	if err != nil {
+		return nil, err
+	}
+	if region != "" {
+		awsCfg.Region = region
+	}
+
+	var sqsOpts []func(*sqs.Options)
+	if endpoint != "" {
+		sqsOpts = append(sqsOpts, func(o *sqs.Options) {
+			o.BaseEndpoint = &endpoint
+		})
+	}
+
+	return sqs.NewFromConfig(awsCfg, sqsOpts...), nil
+}
+
+// loadAWSConfig loads the AWS config the awscfg config resolved for u and
+// scheme describes, or the default one, and returns it with its custom
+// endpoint.
+func loadAWSConfig(u *url.URL, scheme string) (aws.Config, string, error) {
+	cfg := awscfg.GetConfig(u, scheme)
+	if cfg == nil {
+		// Fallback: load default AWS config
+		awsCfg, err := (&awscfg.Config{}).LoadAWSConfig(context.Background())
+		if err != nil {
+			return aws.Config{}, "", fmt.Errorf("sns: failed to load default AWS config: %w", err)
+		}
+		return awsCfg, "", nil
 	}
 
-	// Use CreateTopic to resolve name → ARN (idempotent for existing topics)
-	output, err := client.CreateTopic(context.Background(), &sns.CreateTopicInput{
-		Name: &topicName,
-	})
+	awsCfg, err := cfg.LoadAWSConfig(context.Background())
 	if err != nil {
-		return "", fmt.Errorf("sns: failed to resolve topic ARN for %q: %w", topicName, err)
+		return aws.Config{}, "", fmt.Errorf("sns: failed to load AWS config: %w", err)
 	}
-	return *output.TopicArn, nil
+	return awsCfg, cfg.Endpoint, nil
 }
 
 func strPtr(s string) *string {
@@ -0,0 +1,195 @@
+package sns
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// snsHostPattern matches the hosts SNS serves signing certificates and
+// subscription confirmation URLs from.
+var snsHostPattern = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+// pushHTTPClient fetches signing certificates and confirms subscriptions.
+var pushHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// fetchSigningCert downloads the PEM certificate at certURL. Tests replace
+// it to serve their own certificate.
+var fetchSigningCert = func(ctx context.Context, certURL string) ([]byte, error) {
+	return httpGet(ctx, certURL)
+}
+
+// confirmSubscription visits the SubscribeURL of a SubscriptionConfirmation.
+var confirmSubscription = func(ctx context.Context, subscribeURL string) error {
+	_, err := httpGet(ctx, subscribeURL)
+	return err
+}
+
+var (
+	certCacheMu sync.Mutex
+	// certCache holds parsed signing certificates by URL. SNS rotates
+	// certificates rarely and under a new URL, so entries never go stale.
+	certCache = map[string]*x509.Certificate{}
+)
+
+// pushEnvelope is the JSON document SNS POSTs to HTTP(S) subscribers.
+type pushEnvelope struct {
+	Type              string
+	MessageId         string
+	Token             string
+	TopicArn          string
+	Subject           string
+	Message           string
+	Timestamp         string
+	SignatureVersion  string
+	Signature         string
+	SigningCertURL    string `json:"SigningCertURL"`
+	SubscribeURL      string `json:"SubscribeURL"`
+	UnsubscribeURL    string `json:"UnsubscribeURL"`
+	MessageAttributes map[string]pushAttribute
+}
+
+// pushAttribute is a message attribute in a pushEnvelope.
+type pushAttribute struct {
+	Type  string
+	Value string
+}
+
+// stringToSign returns the canonical form SNS signs for e.
+func (e *pushEnvelope) stringToSign() string {
+	var b strings.Builder
+	add := func(k, v string) {
+		b.WriteString(k)
+		b.WriteByte('\n')
+		b.WriteString(v)
+		b.WriteByte('\n')
+	}
+	add("Message", e.Message)
+	add("MessageId", e.MessageId)
+	if e.Type == "Notification" {
+		if e.Subject != "" {
+			add("Subject", e.Subject)
+		}
+	} else {
+		add("SubscribeURL", e.SubscribeURL)
+	}
+	add("Timestamp", e.Timestamp)
+	if e.Type != "Notification" {
+		add("Token", e.Token)
+	}
+	add("TopicArn", e.TopicArn)
+	add("Type", e.Type)
+	return b.String()
+}
+
+// verifySignature checks that e was signed by SNS: SignatureVersion 1
+// (SHA1withRSA) or 2 (SHA256withRSA), with a certificate served by an SNS
+// host over HTTPS.
+func verifySignature(ctx context.Context, e *pushEnvelope) error {
+	var hash crypto.Hash
+	switch e.SignatureVersion {
+	case "1":
+		hash = crypto.SHA1
+	case "2":
+		hash = crypto.SHA256
+	default:
+		return fmt.Errorf("sns: unsupported SignatureVersion %q", e.SignatureVersion)
+	}
+	if err := checkSNSURL(e.SigningCertURL); err != nil {
+		return fmt.Errorf("sns: SigningCertURL: %w", err)
+	}
+	cert, err := signingCert(ctx, e.SigningCertURL)
+	if err != nil {
+		return err
+	}
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("sns: signing certificate has a %T key, want RSA", cert.PublicKey)
+	}
+	sig, err := base64.StdEncoding.DecodeString(e.Signature)
+	if err != nil {
+		return fmt.Errorf("sns: malformed signature: %w", err)
+	}
+	var digest []byte
+	if hash == crypto.SHA1 {
+		sum := sha1.Sum([]byte(e.stringToSign()))
+		digest = sum[:]
+	} else {
+		sum := sha256.Sum256([]byte(e.stringToSign()))
+		digest = sum[:]
+	}
+	if err := rsa.VerifyPKCS1v15(key, hash, digest, sig); err != nil {
+		return fmt.Errorf("sns: signature verification failed: %w", err)
+	}
+	return nil
+}
+
+// checkSNSURL rejects URLs that are not HTTPS URLs on an SNS host, so a
+// forged message cannot make the subscriber fetch arbitrary URLs.
+func checkSNSURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" || !snsHostPattern.MatchString(u.Hostname()) {
+		return fmt.Errorf("%q is not an https URL on an SNS host", raw)
+	}
+	return nil
+}
+
+// signingCert returns the certificate at certURL, from the cache when it
+// was fetched before.
+func signingCert(ctx context.Context, certURL string) (*x509.Certificate, error) {
+	certCacheMu.Lock()
+	cert, ok := certCache[certURL]
+	certCacheMu.Unlock()
+	if !ok {
+		data, err := fetchSigningCert(ctx, certURL)
+		if err != nil {
+			return nil, fmt.Errorf("sns: failed to fetch signing certificate: %w", err)
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("sns: signing certificate at %s is not PEM", certURL)
+		}
+		if cert, err = x509.ParseCertificate(block.Bytes); err != nil {
+			return nil, fmt.Errorf("sns: invalid signing certificate: %w", err)
+		}
+		certCacheMu.Lock()
+		certCache[certURL] = cert
+		certCacheMu.Unlock()
+	}
+	if now := time.Now(); now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return nil, fmt.Errorf("sns: signing certificate at %s is expired or not yet valid", certURL)
+	}
+	return cert, nil
+}
+
+func httpGet(ctx context.Context, rawURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := pushHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", rawURL, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+}
@@ -0,0 +1,538 @@
+package sqs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go"
+)
+
+// QueueAdmin manages SQS queues addressed by the same sqs://queue-name URLs
+// the provider sends to and receives from. Every method resolves its client
+// through the awscfg mapping of the URL, so a per-queue config registered
+// for messaging also applies here. A queue whose name ends in .fifo is a
+// FIFO queue.
+//
+// EnsureQueue and DeleteQueue are idempotent: they read the current state,
+// write only when it differs from the desired state, and report whether
+// anything changed, so services can bootstrap their topology on every start.
+//
+// StartRedrive, ListRedrives and CancelRedrive manage moving messages out
+// of a dead-letter queue.
+type QueueAdmin interface {
+	QueueExists(ctx context.Context, u *url.URL) (bool, error)
+	QueueArn(ctx context.Context, u *url.URL) (string, error)
+	GetQueueConfig(ctx context.Context, u *url.URL) (*QueueConfig, error)
+	EnsureQueue(ctx context.Context, u *url.URL, cfg *QueueConfig) (bool, error)
+	DeleteQueue(ctx context.Context, u *url.URL) (bool, error)
+
+	StartRedrive(ctx context.Context, dlq *url.URL, opts *RedriveOptions) (*RedriveTask, error)
+	ListRedrives(ctx context.Context, dlq *url.URL) ([]*RedriveTask, error)
+	CancelRedrive(ctx context.Context, dlq *url.URL, handle string) (int64, error)
+}
+
+// Admin returns the SQS QueueAdmin.
+func Admin() QueueAdmin {
+	return queueAdmin{}
+}
+
+// RedrivePermission selects which source queues may use a queue as their
+// dead-letter queue.
+type RedrivePermission string
+
+const (
+	// RedriveAllowAll lets any queue in the account use the queue as a DLQ.
+	RedriveAllowAll RedrivePermission = "allowAll"
+	// RedriveDenyAll stops every queue from using the queue as a DLQ.
+	RedriveDenyAll RedrivePermission = "denyAll"
+	// RedriveByQueue lets only the listed source queues use the queue as a DLQ.
+	RedriveByQueue RedrivePermission = "byQueue"
+)
+
+// RedrivePolicy moves messages to a dead-letter queue once they have been
+// received MaxReceiveCount times without being deleted.
+type RedrivePolicy struct {
+	// DeadLetter is the dead-letter queue, as an sqs:// URL or a queue ARN.
+	// A FIFO queue needs a FIFO dead-letter queue and a standard queue a
+	// standard one.
+	DeadLetter string
+	// MaxReceiveCount is the number of receives before a message is moved
+	// (1-1000).
+	MaxReceiveCount int
+}
+
+// RedriveAllowPolicy is set on a dead-letter queue to restrict which
+// source queues may redrive into it.
+type RedriveAllowPolicy struct {
+	Permission RedrivePermission
+	// Sources are the sqs:// URLs or ARNs of the allowed source queues when
+	// Permission is RedriveByQueue (at most 10).
+	Sources []string
+}
+
+// QueueConfig is the desired configuration of a queue. Zero and nil fields
+// are left as they are on an existing queue and at the SQS default on a new
+// one. GetQueueConfig fills every field and reports queue ARNs in
+// RedrivePolicy.DeadLetter and RedriveAllowPolicy.Sources.
+type QueueConfig struct {
+	VisibilityTimeout time.Duration
+	MessageRetention  time.Duration
+	// Delay is the default delivery delay of new messages (at most 15m).
+	Delay           time.Duration
+	ReceiveWaitTime time.Duration
+	// MaxMessageSize is the largest accepted message body in bytes.
+	MaxMessageSize int
+
+	// KMSKeyId enables SSE-KMS with the given key id, ARN or alias.
+	KMSKeyId string
+	// KMSDataKeyReuse is how long SQS reuses a data key (1m-24h).
+	KMSDataKeyReuse time.Duration
+	// SQSManagedSSE toggles SSE-SQS; it cannot be combined with KMSKeyId.
+	SQSManagedSSE *bool
+
+	// ContentBasedDeduplication derives deduplication IDs from the body
+	// (FIFO only).
+	ContentBasedDeduplication *bool
+	// HighThroughput switches a FIFO queue to per-message-group
+	// deduplication and throughput limits (FIFO only).
+	HighThroughput *bool
+
+	Redrive      *RedrivePolicy
+	RedriveAllow *RedriveAllowPolicy
+}
+
+// sqsAdminAPI is the queue-management subset of the SQS client used by
+// QueueAdmin. It is kept separate from API so messaging fakes do not have
+// to stub out queue management.
+type sqsAdminAPI interface {
+	CreateQueue(ctx context.Context, params *sqs.CreateQueueInput, optFns ...func(*sqs.Options)) (*sqs.CreateQueueOutput, error)
+	DeleteQueue(ctx context.Context, params *sqs.DeleteQueueInput, optFns ...func(*sqs.Options)) (*sqs.DeleteQueueOutput, error)
+	GetQueueUrl(ctx context.Context, params *sqs.GetQueueUrlInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueUrlOutput, error)
+	GetQueueAttributes(ctx context.Context, params *sqs.GetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error)
+	SetQueueAttributes(ctx context.Context, params *sqs.SetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.SetQueueAttributesOutput, error)
+	StartMessageMoveTask(ctx context.Context, params *sqs.StartMessageMoveTaskInput, optFns ...func(*sqs.Options)) (*sqs.StartMessageMoveTaskOutput, error)
+	ListMessageMoveTasks(ctx context.Context, params *sqs.ListMessageMoveTasksInput, optFns ...func(*sqs.Options)) (*sqs.ListMessageMoveTasksOutput, error)
+	CancelMessageMoveTask(ctx context.Context, params *sqs.CancelMessageMoveTaskInput, optFns ...func(*sqs.Options)) (*sqs.CancelMessageMoveTaskOutput, error)
+}
+
+// Compile-time check that the SDK client satisfies the admin interface.
+var _ sqsAdminAPI = (*sqs.Client)(nil)
+
+// resolveAdminClient returns the queue admin client for u. Like
+// resolveClient, it is a package-level var for test injection.
+var resolveAdminClient = func(u *url.URL) (sqsAdminAPI, error) {
+	return getSQSClient(u)
+}
+
+type queueAdmin struct{}
+
+// adminQueue resolves the admin client of u and looks up its queue URL.
+// queueURL is empty when the queue does not exist.
+func adminQueue(ctx context.Context, u *url.URL) (client sqsAdminAPI, queueURL string, err error) {
+	if u.Host == "" {
+		return nil, "", fmt.Errorf("sqs: queue name (URL host) is required")
+	}
+	client, err = resolveAdminClient(u)
+	if err != nil {
+		return nil, "", err
+	}
+	queueURL, err = lookupQueueURL(ctx, client, u)
+	return client, queueURL, err
+}
+
+// lookupQueueURL returns the URL of the queue named by u, or "" when it
+// does not exist.
+func lookupQueueURL(ctx context.Context, client sqsAdminAPI, u *url.URL) (string, error) {
+	input := &sqs.GetQueueUrlInput{QueueName: &u.Host}
+	if account := queueOwner(u); account != "" {
+		input.QueueOwnerAWSAccountId = &account
+	}
+	out, err := client.GetQueueUrl(ctx, input)
+	if isQueueMissing(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("sqs: failed to get queue URL for %q: %w", u.Host, err)
+	}
+	return derefStr(out.QueueUrl), nil
+}
+
+// queueOwner returns the account ID in the path of u, if any.
+func queueOwner(u *url.URL) string {
+	return strings.Trim(u.Path, "/")
+}
+
+// isQueueMissing reports whether err says the queue does not exist.
+func isQueueMissing(err error) bool {
+	if err == nil {
+		return false
+	}
+	var missing *types.QueueDoesNotExist
+	if errors.As(err, &missing) {
+		return true
+	}
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) &&
+		(apiErr.ErrorCode() == "AWS.SimpleQueueService.NonExistentQueue" || apiErr.ErrorCode() == "QueueDoesNotExist")
+}
+
+// QueueExists reports whether the queue named by u exists.
+func (queueAdmin) QueueExists(ctx context.Context, u *url.URL) (bool, error) {
+	_, queueURL, err := adminQueue(ctx, u)
+	return queueURL != "", err
+}
+
+// QueueArn returns the ARN of the queue named by u.
+func (queueAdmin) QueueArn(ctx context.Context, u *url.URL) (string, error) {
+	client, queueURL, err := adminQueue(ctx, u)
+	if err != nil {
+		return "", err
+	}
+	if queueURL == "" {
+		return "", fmt.Errorf("sqs: queue %q does not exist", u.Host)
+	}
+	attrs, err := queueAttributes(ctx, client, queueURL, types.QueueAttributeNameQueueArn)
+	if err != nil {
+		return "", err
+	}
+	return attrs[string(types.QueueAttributeNameQueueArn)], nil
+}
+
+// GetQueueConfig returns the current configuration of the queue named by u.
+func (queueAdmin) GetQueueConfig(ctx context.Context, u *url.URL) (*QueueConfig, error) {
+	client, queueURL, err := adminQueue(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	if queueURL == "" {
+		return nil, fmt.Errorf("sqs: queue %q does not exist", u.Host)
+	}
+	attrs, err := queueAttributes(ctx, client, queueURL, types.QueueAttributeNameAll)
+	if err != nil {
+		return nil, err
+	}
+	return configFromAttributes(attrs)
+}
+
+// EnsureQueue creates the queue named by u with cfg unless it exists, and
+// otherwise updates the attributes that differ from cfg. It reports whether
+// the queue was created or changed. A nil cfg only ensures the queue exists.
+func (queueAdmin) EnsureQueue(ctx context.Context, u *url.URL, cfg *QueueConfig) (bool, error) {
+	if cfg == nil {
+		cfg = &QueueConfig{}
+	}
+	fifo := isFIFOQueue(u.Host)
+	if err := cfg.validate(fifo); err != nil {
+		return false, fmt.Errorf("sqs: queue %q: %w", u.Host, err)
+	}
+	client, queueURL, err := adminQueue(ctx, u)
+	if err != nil {
+		return false, err
+	}
+	desired, err := cfg.attributes(ctx, fifo)
+	if err != nil {
+		return false, err
+	}
+
+	if queueURL == "" {
+		if account := queueOwner(u); account != "" {
+			return false, fmt.Errorf("sqs: queue %q does not exist in account %s and cannot be created there", u.Host, account)
+		}
+		create := make(map[string]string, len(desired)+1)
+		for k, v := range desired {
+			create[k] = v
+		}
+		if fifo {
+			create[string(types.QueueAttributeNameFifoQueue)] = "true"
+		}
+		_, err = client.CreateQueue(ctx, &sqs.CreateQueueInput{QueueName: &u.Host, Attributes: create})
+		var exists *types.QueueNameExists
+		if err == nil {
+			return true, nil
+		}
+		if !errors.As(err, &exists) {
+			return false, fmt.Errorf("sqs: failed to create queue %q: %w", u.Host, err)
+		}
+		// Lost a race with a concurrent EnsureQueue that used other
+		// attributes — reconcile the queue it created.
+		if queueURL, err = lookupQueueURL(ctx, client, u); err != nil {
+			return false, err
+		}
+	}
+
+	if len(desired) == 0 {
+		return false, nil
+	}
+	current, err := queueAttributes(ctx, client, queueURL, types.QueueAttributeNameAll)
+	if err != nil {
+		return false, err
+	}
+	changes := make(map[string]string)
+	for name, want := range desired {
+		if !attributeEqual(name, current[name], want) {
+			changes[name] = want
+		}
+	}
+	if len(changes) == 0 {
+		return false, nil
+	}
+	_, err = client.SetQueueAttributes(ctx, &sqs.SetQueueAttributesInput{QueueUrl: &queueURL, Attributes: changes})
+	if err != nil {
+		return false, fmt.Errorf("sqs: failed to set attributes of queue %q: %w", u.Host, err)
+	}
+	return true, nil
+}
+
+// DeleteQueue deletes the queue named by u and its messages. It reports
+// whether a queue was deleted; a missing queue is not an error. SQS refuses
+// to create a queue with the same name for 60 seconds afterwards.
+func (queueAdmin) DeleteQueue(ctx context.Context, u *url.URL) (bool, error) {
+	client, queueURL, err := adminQueue(ctx, u)
+	if err != nil || queueURL == "" {
+		return false, err
+	}
+	_, err = client.DeleteQueue(ctx, &sqs.DeleteQueueInput{QueueUrl: &queueURL})
+	if isQueueMissing(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("sqs: failed to delete queue %q: %w", u.Host, err)
+	}
+	return true, nil
+}
+
+func queueAttributes(ctx context.Context, client sqsAdminAPI, queueURL string, names ...types.QueueAttributeName) (map[string]string, error) {
+	out, err := client.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{QueueUrl: &queueURL, AttributeNames: names})
+	if err != nil {
+		return nil, fmt.Errorf("sqs: failed to get attributes of %s: %w", queueURL, err)
+	}
+	return out.Attributes, nil
+}
+
+// validate checks the parts of cfg SQS would otherwise reject with a less
+// helpful message.
+func (cfg *QueueConfig) validate(fifo bool) error {
+	if !fifo && cfg.ContentBasedDeduplication != nil && *cfg.ContentBasedDeduplication {
+		return errors.New("ContentBasedDeduplication requires a FIFO queue")
+	}
+	if !fifo && cfg.HighThroughput != nil && *cfg.HighThroughput {
+		return errors.New("HighThroughput requires a FIFO queue")
+	}
+	if cfg.KMSKeyId != "" && cfg.SQSManagedSSE != nil && *cfg.SQSManagedSSE {
+		return errors.New("KMSKeyId and SQSManagedSSE are mutually exclusive")
+	}
+	if rp := cfg.Redrive; rp != nil {
+		if rp.DeadLetter == "" {
+			return errors.New("RedrivePolicy.DeadLetter is required")
+		}
+		if rp.MaxReceiveCount < 1 || rp.MaxReceiveCount > 1000 {
+			return fmt.Errorf("RedrivePolicy.MaxReceiveCount must be 1-1000, got %d", rp.MaxReceiveCount)
+		}
+		if isFIFOQueue(queueRefName(rp.DeadLetter)) != fifo {
+			return fmt.Errorf("dead-letter queue %s must be of the same type (FIFO or standard) as the queue", rp.DeadLetter)
+		}
+	}
+	if ra := cfg.RedriveAllow; ra != nil {
+		switch ra.Permission {
+		case RedriveAllowAll, RedriveDenyAll:
+			if len(ra.Sources) > 0 {
+				return fmt.Errorf("RedriveAllowPolicy.Sources requires permission %q", RedriveByQueue)
+			}
+		case RedriveByQueue:
+			if len(ra.Sources) == 0 || len(ra.Sources) > 10 {
+				return fmt.Errorf("RedriveAllowPolicy %q needs 1-10 sources, got %d", RedriveByQueue, len(ra.Sources))
+			}
+		default:
+			return fmt.Errorf("RedriveAllowPolicy permission %q is not recognized", ra.Permission)
+		}
+	}
+	return nil
+}
+
+// attributes returns the SQS attributes cfg sets, resolving queue URLs in
+// the redrive policies to ARNs.
+func (cfg *QueueConfig) attributes(ctx context.Context, fifo bool) (map[string]string, error) {
+	attrs := make(map[string]string)
+	seconds := func(name types.QueueAttributeName, d time.Duration) {
+		if d > 0 {
+			attrs[string(name)] = strconv.FormatInt(int64(d/time.Second), 10)
+		}
+	}
+	boolean := func(name types.QueueAttributeName, b *bool) {
+		if b != nil {
+			attrs[string(name)] = strconv.FormatBool(*b)
+		}
+	}
+	seconds(types.QueueAttributeNameVisibilityTimeout, cfg.VisibilityTimeout)
+	seconds(types.QueueAttributeNameMessageRetentionPeriod, cfg.MessageRetention)
+	seconds(types.QueueAttributeNameDelaySeconds, cfg.Delay)
+	seconds(types.QueueAttributeNameReceiveMessageWaitTimeSeconds, cfg.ReceiveWaitTime)
+	seconds(types.QueueAttributeNameKmsDataKeyReusePeriodSeconds, cfg.KMSDataKeyReuse)
+	if cfg.MaxMessageSize > 0 {
+		attrs[string(types.QueueAttributeNameMaximumMessageSize)] = strconv.Itoa(cfg.MaxMessageSize)
+	}
+	if cfg.KMSKeyId != "" {
+		attrs[string(types.QueueAttributeNameKmsMasterKeyId)] = cfg.KMSKeyId
+	}
+	boolean(types.QueueAttributeNameSqsManagedSseEnabled, cfg.SQSManagedSSE)
+	if fifo {
+		boolean(types.QueueAttributeNameContentBasedDeduplication, cfg.ContentBasedDeduplication)
+		if cfg.HighThroughput != nil {
+			scope, limit := "queue", "perQueue"
+			if *cfg.HighThroughput {
+				scope, limit = "messageGroup", "perMessageGroupId"
+			}
+			attrs[string(types.QueueAttributeNameDeduplicationScope)] = scope
+			attrs[string(types.QueueAttributeNameFifoThroughputLimit)] = limit
+		}
+	}
+
+	if rp := cfg.Redrive; rp != nil {
+		arn, err := resolveQueueRef(ctx, rp.DeadLetter)
+		if err != nil {
+			return nil, err
+		}
+		raw, _ := json.Marshal(redrivePolicyJSON{DeadLetterTargetArn: arn, MaxReceiveCount: rp.MaxReceiveCount})
+		attrs[string(types.QueueAttributeNameRedrivePolicy)] = string(raw)
+	}
+	if ra := cfg.RedriveAllow; ra != nil {
+		policy := redriveAllowJSON{RedrivePermission: string(ra.Permission)}
+		for _, src := range ra.Sources {
+			arn, err := resolveQueueRef(ctx, src)
+			if err != nil {
+				return nil, err
+			}
+			policy.SourceQueueArns = append(policy.SourceQueueArns, arn)
+		}
+		raw, _ := json.Marshal(policy)
+		attrs[string(types.QueueAttributeNameRedriveAllowPolicy)] = string(raw)
+	}
+	return attrs, nil
+}
+
+// resolveQueueRef turns an sqs:// URL into the ARN of its queue. ARNs are
+// returned as they are.
+func resolveQueueRef(ctx context.Context, ref string) (string, error) {
+	if strings.HasPrefix(ref, "arn:") {
+		return ref, nil
+	}
+	u, err := url.Parse(ref)
+	if err != nil || u.Scheme != SQSScheme || u.Host == "" {
+		return "", fmt.Errorf("sqs: %q is neither a queue ARN nor an %s:// URL", ref, SQSScheme)
+	}
+	return queueAdmin{}.QueueArn(ctx, u)
+}
+
+// queueRefName returns the queue name of an sqs:// URL or queue ARN.
+func queueRefName(ref string) string {
+	if u, err := url.Parse(ref); err == nil && u.Scheme == SQSScheme {
+		return u.Host
+	}
+	return ref[strings.LastIndex(ref, ":")+1:]
+}
+
+type redrivePolicyJSON struct {
+	DeadLetterTargetArn string `json:"deadLetterTargetArn"`
+	MaxReceiveCount     int    `json:"maxReceiveCount"`
+}
+
+type redriveAllowJSON struct {
+	RedrivePermission string   `json:"redrivePermission"`
+	SourceQueueArns   []string `json:"sourceQueueArns,omitempty"`
+}
+
+func parseRedrivePolicy(raw string) (*RedrivePolicy, error) {
+	var rp struct {
+		DeadLetterTargetArn string `json:"deadLetterTargetArn"`
+		MaxReceiveCount     any    `json:"maxReceiveCount"`
+	}
+	if err := json.Unmarshal([]byte(raw), &rp); err != nil {
+		return nil, fmt.Errorf("sqs: RedrivePolicy is not valid JSON: %w", err)
+	}
+	n, err := redriveMaxReceiveCount(rp.MaxReceiveCount)
+	if err != nil {
+		return nil, fmt.Errorf("sqs: RedrivePolicy.maxReceiveCount: %w", err)
+	}
+	return &RedrivePolicy{DeadLetter: rp.DeadLetterTargetArn, MaxReceiveCount: n}, nil
+}
+
+func parseRedriveAllowPolicy(raw string) (*RedriveAllowPolicy, error) {
+	var ra redriveAllowJSON
+	if err := json.Unmarshal([]byte(raw), &ra); err != nil {
+		return nil, fmt.Errorf("sqs: RedriveAllowPolicy is not valid JSON: %w", err)
+	}
+	return &RedriveAllowPolicy{Permission: RedrivePermission(ra.RedrivePermission), Sources: ra.SourceQueueArns}, nil
+}
+
+// attributeEqual compares a current attribute value with a desired one.
+// Policies are compared by content, since SQS does not preserve their JSON
+// formatting or source order.
+func attributeEqual(name, current, desired string) bool {
+	switch types.QueueAttributeName(name) {
+	case types.QueueAttributeNameRedrivePolicy:
+		a, errA := parseRedrivePolicy(current)
+		b, errB := parseRedrivePolicy(desired)
+		return errA == nil && errB == nil && *a == *b
+	case types.QueueAttributeNameRedriveAllowPolicy:
+		a, errA := parseRedriveAllowPolicy(current)
+		b, errB := parseRedriveAllowPolicy(desired)
+		if errA != nil || errB != nil || a.Permission != b.Permission {
+			return false
+		}
+		return slices.Equal(slices.Sorted(slices.Values(a.Sources)), slices.Sorted(slices.Values(b.Sources)))
+	}
+	return current == desired
+}
+
+// configFromAttributes is the inverse of QueueConfig.attributes.
+func configFromAttributes(attrs map[string]string) (*QueueConfig, error) {
+	cfg := &QueueConfig{KMSKeyId: attrs[string(types.QueueAttributeNameKmsMasterKeyId)]}
+	seconds := func(name types.QueueAttributeName) time.Duration {
+		n, _ := strconv.ParseInt(attrs[string(name)], 10, 64)
+		return time.Duration(n) * time.Second
+	}
+	boolean := func(name types.QueueAttributeName) *bool {
+		v, ok := attrs[string(name)]
+		if !ok {
+			return nil
+		}
+		b := v == "true"
+		return &b
+	}
+	cfg.VisibilityTimeout = seconds(types.QueueAttributeNameVisibilityTimeout)
+	cfg.MessageRetention = seconds(types.QueueAttributeNameMessageRetentionPeriod)
+	cfg.Delay = seconds(types.QueueAttributeNameDelaySeconds)
+	cfg.ReceiveWaitTime = seconds(types.QueueAttributeNameReceiveMessageWaitTimeSeconds)
+	cfg.KMSDataKeyReuse = seconds(types.QueueAttributeNameKmsDataKeyReusePeriodSeconds)
+	cfg.MaxMessageSize, _ = strconv.Atoi(attrs[string(types.QueueAttributeNameMaximumMessageSize)])
+	cfg.SQSManagedSSE = boolean(types.QueueAttributeNameSqsManagedSseEnabled)
+	cfg.ContentBasedDeduplication = boolean(types.QueueAttributeNameContentBasedDeduplication)
+	if scope, ok := attrs[string(types.QueueAttributeNameDeduplicationScope)]; ok {
+		high := scope == "messageGroup" && attrs[string(types.QueueAttributeNameFifoThroughputLimit)] == "perMessageGroupId"
+		cfg.HighThroughput = &high
+	}
+	if raw := attrs[string(types.QueueAttributeNameRedrivePolicy)]; raw != "" {
+		rp, err := parseRedrivePolicy(raw)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Redrive = rp
+	}
+	if raw := attrs[string(types.QueueAttributeNameRedriveAllowPolicy)]; raw != "" {
+		ra, err := parseRedriveAllowPolicy(raw)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RedriveAllow = ra
+	}
+	return cfg, nil
+}
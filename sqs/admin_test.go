@@ -0,0 +1,264 @@
+package sqs
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awssqs "github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// fakeAdminClient keeps queue attributes in memory, the way SQS reports
+// them back.
+type fakeAdminClient struct {
+	mu       sync.Mutex
+	queues   map[string]map[string]string
+	creates  []*awssqs.CreateQueueInput
+	setCalls []*awssqs.SetQueueAttributesInput
+	moves    []*awssqs.StartMessageMoveTaskInput
+	// moveErr, if set, is returned by StartMessageMoveTask and
+	// ListMessageMoveTasks.
+	moveErr error
+}
+
+func newFakeAdminClient() *fakeAdminClient {
+	return &fakeAdminClient{queues: map[string]map[string]string{}}
+}
+
+func (f *fakeAdminClient) queueName(queueURL *string) string {
+	return (*queueURL)[strings.LastIndex(*queueURL, "/")+1:]
+}
+
+func (f *fakeAdminClient) CreateQueue(ctx context.Context, in *awssqs.CreateQueueInput, _ ...func(*awssqs.Options)) (*awssqs.CreateQueueOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.creates = append(f.creates, in)
+	attrs := map[string]string{
+		"QueueArn":          "arn:aws:sqs:us-east-1:000000000000:" + *in.QueueName,
+		"VisibilityTimeout": "30",
+	}
+	for k, v := range in.Attributes {
+		attrs[k] = v
+	}
+	f.queues[*in.QueueName] = attrs
+	return &awssqs.CreateQueueOutput{QueueUrl: aws.String("http://fake/000000000000/" + *in.QueueName)}, nil
+}
+
+func (f *fakeAdminClient) DeleteQueue(ctx context.Context, in *awssqs.DeleteQueueInput, _ ...func(*awssqs.Options)) (*awssqs.DeleteQueueOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.queues, f.queueName(in.QueueUrl))
+	return &awssqs.DeleteQueueOutput{}, nil
+}
+
+func (f *fakeAdminClient) GetQueueUrl(ctx context.Context, in *awssqs.GetQueueUrlInput, _ ...func(*awssqs.Options)) (*awssqs.GetQueueUrlOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.queues[*in.QueueName]; !ok {
+		return nil, &types.QueueDoesNotExist{Message: aws.String("no such queue")}
+	}
+	return &awssqs.GetQueueUrlOutput{QueueUrl: aws.String("http://fake/000000000000/" + *in.QueueName)}, nil
+}
+
+func (f *fakeAdminClient) GetQueueAttributes(ctx context.Context, in *awssqs.GetQueueAttributesInput, _ ...func(*awssqs.Options)) (*awssqs.GetQueueAttributesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	attrs := make(map[string]string)
+	for k, v := range f.queues[f.queueName(in.QueueUrl)] {
+		attrs[k] = v
+	}
+	return &awssqs.GetQueueAttributesOutput{Attributes: attrs}, nil
+}
+
+func (f *fakeAdminClient) SetQueueAttributes(ctx context.Context, in *awssqs.SetQueueAttributesInput, _ ...func(*awssqs.Options)) (*awssqs.SetQueueAttributesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.setCalls = append(f.setCalls, in)
+	for k, v := range in.Attributes {
+		f.queues[f.queueName(in.QueueUrl)][k] = v
+	}
+	return &awssqs.SetQueueAttributesOutput{}, nil
+}
+
+func (f *fakeAdminClient) StartMessageMoveTask(ctx context.Context, in *awssqs.StartMessageMoveTaskInput, _ ...func(*awssqs.Options)) (*awssqs.StartMessageMoveTaskOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.moveErr != nil {
+		return nil, f.moveErr
+	}
+	f.moves = append(f.moves, in)
+	return &awssqs.StartMessageMoveTaskOutput{TaskHandle: aws.String("task-1")}, nil
+}
+
+func (f *fakeAdminClient) ListMessageMoveTasks(ctx context.Context, in *awssqs.ListMessageMoveTasksInput, _ ...func(*awssqs.Options)) (*awssqs.ListMessageMoveTasksOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.moveErr != nil {
+		return nil, f.moveErr
+	}
+	out := &awssqs.ListMessageMoveTasksOutput{}
+	for _, m := range f.moves {
+		out.Results = append(out.Results, types.ListMessageMoveTasksResultEntry{
+			TaskHandle:     aws.String("task-1"),
+			Status:         aws.String("RUNNING"),
+			SourceArn:      m.SourceArn,
+			DestinationArn: m.DestinationArn,
+		})
+	}
+	return out, nil
+}
+
+func (f *fakeAdminClient) CancelMessageMoveTask(ctx context.Context, in *awssqs.CancelMessageMoveTaskInput, _ ...func(*awssqs.Options)) (*awssqs.CancelMessageMoveTaskOutput, error) {
+	return &awssqs.CancelMessageMoveTaskOutput{ApproximateNumberOfMessagesMoved: 3}, nil
+}
+
+func withFakeAdmin(t *testing.T, client sqsAdminAPI) {
+	t.Helper()
+	prev := resolveAdminClient
+	resolveAdminClient = func(*url.URL) (sqsAdminAPI, error) { return client, nil }
+	t.Cleanup(func() {
+		resolveAdminClient = prev
+		// Forget client-side redrives so they do not leak into later tests.
+		localRedrivesMu.Lock()
+		clear(localRedrives)
+		localRedrivesMu.Unlock()
+	})
+}
+
+func TestAdmin_EnsureQueueCreatesThenReconciles(t *testing.T) {
+	fake := newFakeAdminClient()
+	withFakeAdmin(t, fake)
+	ctx := context.Background()
+	u, _ := url.Parse("sqs://orders.fifo")
+	cfg := &QueueConfig{
+		VisibilityTimeout:         time.Minute,
+		ContentBasedDeduplication: aws.Bool(true),
+		HighThroughput:            aws.Bool(true),
+	}
+
+	changed, err := Admin().EnsureQueue(ctx, u, cfg)
+	if err != nil || !changed {
+		t.Fatalf("first EnsureQueue = %v, %v", changed, err)
+	}
+	attrs := fake.creates[0].Attributes
+	for k, want := range map[string]string{
+		"FifoQueue":                 "true",
+		"VisibilityTimeout":         "60",
+		"ContentBasedDeduplication": "true",
+		"DeduplicationScope":        "messageGroup",
+		"FifoThroughputLimit":       "perMessageGroupId",
+	} {
+		if attrs[k] != want {
+			t.Errorf("CreateQueue %s = %q, want %q", k, attrs[k], want)
+		}
+	}
+
+	changed, err = Admin().EnsureQueue(ctx, u, cfg)
+	if err != nil || changed || len(fake.setCalls) != 0 {
+		t.Fatalf("second EnsureQueue = %v, %v (%d sets)", changed, err, len(fake.setCalls))
+	}
+
+	cfg.VisibilityTimeout = 2 * time.Minute
+	changed, err = Admin().EnsureQueue(ctx, u, cfg)
+	if err != nil || !changed {
+		t.Fatalf("third EnsureQueue = %v, %v", changed, err)
+	}
+	if set := fake.setCalls[0].Attributes; len(set) != 1 || set["VisibilityTimeout"] != "120" {
+		t.Fatalf("SetQueueAttributes = %v", set)
+	}
+
+	got, err := Admin().GetQueueConfig(ctx, u)
+	if err != nil || got.VisibilityTimeout != 2*time.Minute || !*got.HighThroughput || !*got.ContentBasedDeduplication {
+		t.Fatalf("GetQueueConfig = %+v, %v", got, err)
+	}
+}
+
+func TestAdmin_EnsureQueueRedrive(t *testing.T) {
+	fake := newFakeAdminClient()
+	withFakeAdmin(t, fake)
+	ctx := context.Background()
+	src, _ := url.Parse("sqs://orders")
+	dlq, _ := url.Parse("sqs://orders-dlq")
+
+	if _, err := Admin().EnsureQueue(ctx, src, &QueueConfig{
+		Redrive: &RedrivePolicy{DeadLetter: "sqs://orders-dlq", MaxReceiveCount: 5},
+	}); err == nil {
+		t.Fatalf("expected an error for a missing dead-letter queue")
+	}
+	if _, err := Admin().EnsureQueue(ctx, dlq, &QueueConfig{
+		MessageRetention: 14 * 24 * time.Hour,
+		RedriveAllow:     &RedriveAllowPolicy{Permission: RedriveByQueue, Sources: []string{"arn:aws:sqs:us-east-1:000000000000:orders"}},
+	}); err != nil {
+		t.Fatalf("EnsureQueue(dlq): %v", err)
+	}
+	redrive := &QueueConfig{Redrive: &RedrivePolicy{DeadLetter: "sqs://orders-dlq", MaxReceiveCount: 5}}
+	if _, err := Admin().EnsureQueue(ctx, src, redrive); err != nil {
+		t.Fatalf("EnsureQueue(src): %v", err)
+	}
+
+	// SQS reports the policy with its own formatting; it must still match.
+	fake.queues["orders"]["RedrivePolicy"] = `{"maxReceiveCount":"5", "deadLetterTargetArn":"arn:aws:sqs:us-east-1:000000000000:orders-dlq"}`
+	changed, err := Admin().EnsureQueue(ctx, src, redrive)
+	if err != nil || changed {
+		t.Fatalf("EnsureQueue(src) again = %v, %v", changed, err)
+	}
+
+	// The redrive check used by AddListener now passes.
+	bo := &brokerOptions{maxDeliveryAttempts: 5, hasMaxDeliveryAttempts: true, deadLetter: "orders-dlq"}
+	if err := bo.validateRedrivePolicy(ctx, fake, "http://fake/000000000000/orders"); err != nil {
+		t.Fatalf("validateRedrivePolicy: %v", err)
+	}
+
+	got, err := Admin().GetQueueConfig(ctx, dlq)
+	if err != nil || got.RedriveAllow == nil || got.RedriveAllow.Permission != RedriveByQueue {
+		t.Fatalf("GetQueueConfig(dlq) = %+v, %v", got, err)
+	}
+}
+
+func TestAdmin_Validation(t *testing.T) {
+	withFakeAdmin(t, newFakeAdminClient())
+	ctx := context.Background()
+	std, _ := url.Parse("sqs://plain")
+	fifo, _ := url.Parse("sqs://ordered.fifo")
+
+	for name, tc := range map[string]struct {
+		u   *url.URL
+		cfg *QueueConfig
+	}{
+		"content dedup on standard":   {std, &QueueConfig{ContentBasedDeduplication: aws.Bool(true)}},
+		"high throughput on standard": {std, &QueueConfig{HighThroughput: aws.Bool(true)}},
+		"kms with sse-sqs":            {std, &QueueConfig{KMSKeyId: "alias/k", SQSManagedSSE: aws.Bool(true)}},
+		"standard dlq for fifo":       {fifo, &QueueConfig{Redrive: &RedrivePolicy{DeadLetter: "sqs://dlq", MaxReceiveCount: 3}}},
+		"receive count":               {std, &QueueConfig{Redrive: &RedrivePolicy{DeadLetter: "sqs://dlq", MaxReceiveCount: 0}}},
+		"byQueue without sources":     {std, &QueueConfig{RedriveAllow: &RedriveAllowPolicy{Permission: RedriveByQueue}}},
+	} {
+		if _, err := Admin().EnsureQueue(ctx, tc.u, tc.cfg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestAdmin_DeleteQueueIsIdempotent(t *testing.T) {
+	fake := newFakeAdminClient()
+	withFakeAdmin(t, fake)
+	ctx := context.Background()
+	u, _ := url.Parse("sqs://scratch")
+
+	if _, err := Admin().EnsureQueue(ctx, u, nil); err != nil {
+		t.Fatalf("EnsureQueue: %v", err)
+	}
+	if deleted, err := Admin().DeleteQueue(ctx, u); err != nil || !deleted {
+		t.Fatalf("first DeleteQueue = %v, %v", deleted, err)
+	}
+	if deleted, err := Admin().DeleteQueue(ctx, u); err != nil || deleted {
+		t.Fatalf("second DeleteQueue = %v, %v", deleted, err)
+	}
+	if exists, err := Admin().QueueExists(ctx, u); err != nil || exists {
+		t.Fatalf("QueueExists = %v, %v", exists, err)
+	}
+}
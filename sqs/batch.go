@@ -0,0 +1,190 @@
+package sqs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"oss.nandlabs.io/golly-aws/internal/batchsend"
+	"oss.nandlabs.io/golly-aws/ratelimit"
+	"oss.nandlabs.io/golly/messaging"
+)
+
+const (
+	// HeaderMessageDeduplicationId is the message header that carries a
+	// per-message FIFO deduplication ID.
+	HeaderMessageDeduplicationId = "MessageDeduplicationId"
+	// OptContentDeduplication, when true, derives the deduplication ID of
+	// FIFO messages that have no other ID from the SHA-256 of the body, the
+	// way SQS content-based deduplication does, for queues that do not have
+	// it enabled.
+	OptContentDeduplication = "ContentDeduplication"
+	// OptBatchRetries is the number of times SendBatch resends entries that
+	// failed for a retriable reason (throttling, internal errors). Default: 3.
+	OptBatchRetries = "BatchRetries"
+
+	defaultBatchRetries = 3
+)
+
+// batchRetryBackoff is the delay before the first resend of failed batch
+// entries; it doubles on every further attempt. A var so tests can shorten it.
+var batchRetryBackoff = 100 * time.Millisecond
+
+// retriableEntryCodes are SendMessageBatch entry error codes worth resending
+// even when SQS reports them as the sender's fault.
+var retriableEntryCodes = map[string]bool{
+	"ThrottlingException":     true,
+	"RequestThrottled":        true,
+	"KmsThrottled":            true,
+	"InternalError":           true,
+	"InternalFailure":         true,
+	"ServiceUnavailable":      true,
+	"KmsInternalFailure":      true,
+	"KMS.ThrottlingException": true,
+}
+
+// Deduplicated is implemented by messages that carry their own FIFO
+// deduplication ID, in the same way messaging.Keyed messages carry their
+// message group.
+type Deduplicated interface {
+	DeduplicationId() string
+}
+
+// BatchEntryError describes one message SendBatch could not send: its
+// Index in the slice passed to SendBatch, the Message, the Code, Reason and
+// SenderFault SQS reported and the number of Attempts. Err is the cause
+// when SQS never reported on the entry: the failed encoding, offload or
+// SendMessageBatch request that stopped SendBatch, the failed request of a
+// resend, or the end of ctx while waiting to resend. Code is empty then,
+// and Unwrap returns Err.
+type BatchEntryError = batchsend.EntryError
+
+// BatchError is returned by SendBatch when some messages were accepted and
+// others were not. Failed is in input order; every message not listed there
+// was sent.
+type BatchError = batchsend.Error
+
+// batchService labels the errors of SendBatch.
+var batchService = batchsend.Service{Name: "sqs", Action: "send"}
+
+// resolveDedupId returns the MessageDeduplicationId to send msg with. An
+// explicit OptMessageDeduplicationId wins; on FIFO queues the message's
+// HeaderMessageDeduplicationId header, then its Deduplicated interface and
+// finally, with OptContentDeduplication, a hash of body are tried in turn.
+func resolveDedupId(msg messaging.Message, body, queueURL string, explicit *string, contentHash bool) *string {
+	if explicit != nil && *explicit != "" {
+		return explicit
+	}
+	if !isFIFOQueue(queueURL) {
+		return nil
+	}
+	if v, ok := msg.GetStrHeader(HeaderMessageDeduplicationId); ok && v != "" {
+		return &v
+	}
+	if d, ok := msg.(Deduplicated); ok {
+		if id := d.DeduplicationId(); id != "" {
+			return &id
+		}
+	}
+	if contentHash {
+		sum := sha256.Sum256([]byte(body))
+		id := hex.EncodeToString(sum[:])
+		return &id
+	}
+	return nil
+}
+
+// isRetriableEntry reports whether a rejected batch entry is worth
+// resending.
+func isRetriableEntry(r batchsend.Rejection) bool {
+	return !r.SenderFault || retriableEntryCodes[r.Code]
+}
+
+// entryGroups returns the message group of each entry, empty for none.
+func entryGroups(entries []types.SendMessageBatchRequestEntry) []string {
+	groups := make([]string, len(entries))
+	for j, e := range entries {
+		groups[j] = derefStr(e.MessageGroupId)
+	}
+	return groups
+}
+
+// sendChunk sends one SendMessageBatch request of up to ten entries,
+// resending entries that fail for a retriable reason up to retries times
+// and pacing every attempt with the queue's rate limiter.
+// batch[j] is the message of entries[j] and offset the position of batch
+// in the caller's slice. OnSend fires once per message with its final
+// outcome. A request-level failure of the first attempt is returned as an
+// error (nothing was sent); everything else is reported per entry.
+func (p *Provider) sendChunk(ctx context.Context, client API, u *url.URL, queueURL string, offset int,
+	batch []messaging.Message, entries []types.SendMessageBatchRequestEntry, retries int) ([]*BatchEntryError, error) {
+	start := time.Now()
+	chunk := &batchsend.Chunk[types.SendMessageBatchRequestEntry]{
+		Service:   batchService,
+		Offset:    offset,
+		Messages:  batch,
+		Entries:   entries,
+		ID:        func(e types.SendMessageBatchRequestEntry) string { return *e.Id },
+		Retriable: isRetriableEntry,
+		Retries:   retries,
+		Backoff:   batchRetryBackoff,
+		OnResend: func(n int) {
+			logger.WarnF("SQS batch send to %s: resending %d throttled or failed entries", queueURL, n)
+		},
+		Send: func(ctx context.Context, pending []types.SendMessageBatchRequestEntry) ([]batchsend.Rejection, error) {
+			limiter, err := p.pace(ctx, u, queueURL, entryGroups(pending))
+			if err != nil {
+				return nil, err
+			}
+			output, err := client.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{
+				QueueUrl: &queueURL,
+				Entries:  pending,
+			})
+			if err != nil {
+				if ratelimit.IsThrottle(err) {
+					p.throttled(u, limiter)
+				}
+				return nil, fmt.Errorf("sqs: batch send failed: %w", err)
+			}
+			rejected := make([]batchsend.Rejection, 0, len(output.Failed))
+			throttled := false
+			for _, f := range output.Failed {
+				throttled = throttled || ratelimit.IsThrottleCode(derefStr(f.Code))
+				rejected = append(rejected, batchsend.Rejection{
+					ID:          derefStr(f.Id),
+					Code:        derefStr(f.Code),
+					Reason:      derefStr(f.Message),
+					SenderFault: f.SenderFault,
+				})
+			}
+			if throttled {
+				p.throttled(u, limiter)
+			}
+			return rejected, nil
+		},
+	}
+	_, errs, err := chunk.Run(ctx)
+	if err != nil {
+		for _, m := range batch {
+			p.fireOnSend(u, m, err, time.Since(start))
+		}
+		return nil, err
+	}
+
+	latency := time.Since(start)
+	var failed []*BatchEntryError
+	for j, m := range batch {
+		if errs[j] != nil {
+			p.fireOnSend(u, m, errs[j], latency)
+			failed = append(failed, errs[j])
+			continue
+		}
+		p.fireOnSend(u, m, nil, latency)
+	}
+	return failed, nil
+}
@@ -0,0 +1,226 @@
+package sqs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	awssqs "github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"oss.nandlabs.io/golly/messaging"
+)
+
+// dedupMessage is a message that carries its own deduplication ID.
+type dedupMessage struct {
+	*MessageSQS
+	dedupId string
+}
+
+func (m *dedupMessage) DeduplicationId() string { return m.dedupId }
+
+func TestSendBatchCtx_PerMessageDedupIds(t *testing.T) {
+	p := &Provider{}
+	fake := &fakeSQSClient{}
+	withFakeClient(t, fake, "http://fake/orders.fifo")
+	u, _ := url.Parse("sqs://orders.fifo")
+
+	m1 := newProviderMsg(t, p, "m1")
+	m1.SetStrHeader(HeaderMessageDeduplicationId, "from-header")
+	m2 := &dedupMessage{MessageSQS: newProviderMsg(t, p, "m2").(*MessageSQS), dedupId: "from-interface"}
+	m3 := newProviderMsg(t, p, "m3")
+	opts := messaging.NewOptionsBuilder().
+		Add(OptMessageGroupId, "g").
+		Add(OptContentDeduplication, true).
+		Build()
+
+	if err := p.SendBatchCtx(context.Background(), u, []messaging.Message{m1, m2, m3}, opts...); err != nil {
+		t.Fatalf("SendBatchCtx: %v", err)
+	}
+	sum := sha256.Sum256([]byte("m3"))
+	want := []string{"from-header", "from-interface", hex.EncodeToString(sum[:])}
+	for i, e := range fake.batchCalls[0].Entries {
+		if e.MessageDeduplicationId == nil || *e.MessageDeduplicationId != want[i] {
+			t.Errorf("entry %d dedup id = %v, want %s", i, e.MessageDeduplicationId, want[i])
+		}
+	}
+
+	// One explicit ID for several FIFO messages would drop all but one.
+	shared := messaging.NewOptionsBuilder().Add(OptMessageDeduplicationId, "same").Build()
+	if err := p.SendBatchCtx(context.Background(), u, []messaging.Message{m1, m3}, shared...); err == nil {
+		t.Fatalf("expected an error for a shared deduplication id")
+	}
+}
+
+func TestSendBatchCtx_PartialFailureAndRetry(t *testing.T) {
+	prev := batchRetryBackoff
+	batchRetryBackoff = 0
+	t.Cleanup(func() { batchRetryBackoff = prev })
+
+	p := &Provider{}
+	obs := &recordingObserver{}
+	p.SetObserver(obs)
+	fake := &fakeSQSClient{}
+	fake.batchFn = func(ctx context.Context, in *awssqs.SendMessageBatchInput) (*awssqs.SendMessageBatchOutput, error) {
+		out := &awssqs.SendMessageBatchOutput{}
+		for _, e := range in.Entries {
+			switch {
+			case *e.Id == "msg-1" && len(fake.batchCalls) == 1:
+				out.Failed = append(out.Failed, types.BatchResultErrorEntry{
+					Id: e.Id, Code: strPtr("ThrottlingException"), Message: strPtr("slow down"), SenderFault: true,
+				})
+			case *e.Id == "msg-2":
+				out.Failed = append(out.Failed, types.BatchResultErrorEntry{
+					Id: e.Id, Code: strPtr("InvalidParameterValue"), Message: strPtr("bad body"), SenderFault: true,
+				})
+			default:
+				out.Successful = append(out.Successful, types.SendMessageBatchResultEntry{Id: e.Id, MessageId: e.Id})
+			}
+		}
+		return out, nil
+	}
+	withFakeClient(t, fake, "http://fake/q")
+	u, _ := url.Parse("sqs://q")
+	msgs := []messaging.Message{newProviderMsg(t, p, "a"), newProviderMsg(t, p, "b"), newProviderMsg(t, p, "c")}
+
+	err := p.SendBatchCtx(context.Background(), u, msgs)
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("expected *BatchError, got %v", err)
+	}
+	if len(batchErr.Failed) != 1 || batchErr.Total != 3 {
+		t.Fatalf("failed = %d of %d", len(batchErr.Failed), batchErr.Total)
+	}
+	f := batchErr.Failed[0]
+	if f.Index != 2 || f.Message != msgs[2] || f.Code != "InvalidParameterValue" || f.Attempts != 1 || !f.SenderFault {
+		t.Fatalf("failure = %+v", f)
+	}
+	var entryErr *BatchEntryError
+	if !errors.As(err, &entryErr) || entryErr != f {
+		t.Fatalf("errors.As(*BatchEntryError) = %v", entryErr)
+	}
+
+	if len(fake.batchCalls) != 2 {
+		t.Fatalf("expected 2 SendMessageBatch calls, got %d", len(fake.batchCalls))
+	}
+	if retried := fake.batchCalls[1].Entries; len(retried) != 1 || *retried[0].Id != "msg-1" {
+		t.Fatalf("retried entries = %v", retried)
+	}
+
+	if obs.sendCount() != 3 {
+		t.Fatalf("expected one OnSend per message, got %d", obs.sendCount())
+	}
+	for _, s := range obs.sends {
+		if (s.msg == msgs[2]) != (s.err != nil) {
+			t.Errorf("OnSend for %s: err = %v", s.msg.ReadAsStr(), s.err)
+		}
+	}
+}
+
+func TestSendBatchCtx_FailedChunkReportsUnsentMessages(t *testing.T) {
+	p := &Provider{}
+	errDown := errors.New("connection reset")
+	fake := &fakeSQSClient{}
+	fake.batchFn = func(ctx context.Context, in *awssqs.SendMessageBatchInput) (*awssqs.SendMessageBatchOutput, error) {
+		if len(fake.batchCalls) == 2 {
+			return nil, errDown
+		}
+		out := &awssqs.SendMessageBatchOutput{}
+		for _, e := range in.Entries {
+			if *e.Id == "msg-3" {
+				out.Failed = append(out.Failed, types.BatchResultErrorEntry{
+					Id: e.Id, Code: strPtr("InvalidParameterValue"), Message: strPtr("bad body"), SenderFault: true,
+				})
+				continue
+			}
+			out.Successful = append(out.Successful, types.SendMessageBatchResultEntry{Id: e.Id, MessageId: e.Id})
+		}
+		return out, nil
+	}
+	withFakeClient(t, fake, "http://fake/q")
+	u, _ := url.Parse("sqs://q")
+	msgs := make([]messaging.Message, 25)
+	for i := range msgs {
+		msgs[i] = newProviderMsg(t, p, "m")
+	}
+
+	// The first chunk was sent; the second request failed and the third
+	// chunk was never sent.
+	err := p.SendBatchCtx(context.Background(), u, msgs)
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("expected *BatchError, got %v", err)
+	}
+	if !errors.Is(err, errDown) {
+		t.Fatalf("errors.Is(cause) = false for %v", err)
+	}
+	if len(batchErr.Failed) != 16 || batchErr.Total != 25 {
+		t.Fatalf("failed = %d of %d, want 16 of 25", len(batchErr.Failed), batchErr.Total)
+	}
+	if f := batchErr.Failed[0]; f.Index != 3 || f.Code != "InvalidParameterValue" || f.Err != nil {
+		t.Fatalf("rejected entry = %+v", f)
+	}
+	for k, f := range batchErr.Failed[1:] {
+		wantAttempts := 1
+		if k >= 10 {
+			wantAttempts = 0
+		}
+		if f.Index != 10+k || f.Message != msgs[10+k] || f.Attempts != wantAttempts || !errors.Is(f, errDown) {
+			t.Fatalf("unsent entry %d = %+v", k, f)
+		}
+	}
+
+	// When the first chunk fails nothing was sent, and the cause is
+	// returned as is.
+	fake.batchCalls = nil
+	fake.batchFn = func(context.Context, *awssqs.SendMessageBatchInput) (*awssqs.SendMessageBatchOutput, error) {
+		return nil, errDown
+	}
+	err = p.SendBatchCtx(context.Background(), u, msgs)
+	if !errors.Is(err, errDown) || errors.As(err, &batchErr) {
+		t.Fatalf("first chunk failure = %v", err)
+	}
+}
+
+func TestSendBatchCtx_ResendFailuresKeepCause(t *testing.T) {
+	prev := batchRetryBackoff
+	t.Cleanup(func() { batchRetryBackoff = prev })
+	p := &Provider{}
+	errDown := errors.New("connection reset")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fake := &fakeSQSClient{}
+	// The first attempt throttles msg-1; the resend fails as a request,
+	// or ctx ends while waiting for it.
+	resendErr := errDown
+	fake.batchFn = func(_ context.Context, in *awssqs.SendMessageBatchInput) (*awssqs.SendMessageBatchOutput, error) {
+		if len(fake.batchCalls) > 1 {
+			return nil, resendErr
+		}
+		if resendErr == nil {
+			cancel()
+		}
+		return &awssqs.SendMessageBatchOutput{
+			Successful: []types.SendMessageBatchResultEntry{{Id: in.Entries[0].Id}},
+			Failed:     []types.BatchResultErrorEntry{{Id: in.Entries[1].Id, Code: strPtr("ThrottlingException")}},
+		}, nil
+	}
+	withFakeClient(t, fake, "http://fake/q")
+	u, _ := url.Parse("sqs://q")
+	msgs := []messaging.Message{newProviderMsg(t, p, "a"), newProviderMsg(t, p, "b")}
+
+	batchRetryBackoff = 0
+	if err := p.SendBatchCtx(ctx, u, msgs); !errors.Is(err, errDown) {
+		t.Fatalf("failed resend = %v, want it to wrap the request error", err)
+	}
+
+	fake.batchCalls = nil
+	resendErr = nil
+	batchRetryBackoff = time.Hour
+	if err := p.SendBatchCtx(ctx, u, msgs); !errors.Is(err, context.Canceled) {
+		t.Fatalf("canceled resend = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,106 @@
+package sqs
+
+import (
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"oss.nandlabs.io/golly-aws/bodycodec"
+	"oss.nandlabs.io/golly/messaging"
+)
+
+// OptBodyEncoding selects how message bodies are sent: bodycodec.Raw (the
+// default), bodycodec.Base64, bodycodec.Auto or the name of a codec
+// registered with bodycodec.Register. Received bodies are decoded
+// according to their bodycodec.AttrEncoding attribute whatever the option.
+const OptBodyEncoding = "BodyEncoding"
+
+// encodeBody returns the body text and message attributes to send msg with.
+func encodeBody(msg messaging.Message, encoding string) (string, map[string]types.MessageAttributeValue, error) {
+	text, marker, err := bodycodec.Encode(encoding, msg.ReadBytes())
+	if err != nil {
+		return "", nil, err
+	}
+	attrs := buildMessageAttributes(msg)
+	if marker != "" {
+		if attrs == nil {
+			attrs = make(map[string]types.MessageAttributeValue, 1)
+		}
+		attrs[bodycodec.AttrEncoding] = types.MessageAttributeValue{
+			DataType:    strPtr("String"),
+			StringValue: &marker,
+		}
+	}
+	return text, attrs, nil
+}
+
+// snsEnvelope is the part of an SNS notification, as delivered to a
+// subscribed queue without raw message delivery, that decodeBody needs.
+type snsEnvelope struct {
+	Type              string `json:"Type"`
+	Message           string `json:"Message"`
+	MessageAttributes map[string]struct {
+		Type  string `json:"Type"`
+		Value string `json:"Value"`
+	} `json:"MessageAttributes"`
+}
+
+// decodeBody replaces an encoded body with the original bytes. Bodies are
+// recognised by the bodycodec.AttrEncoding message attribute, or, for SNS
+// notifications, by that attribute inside the envelope; an encoded
+// notification is unwrapped to its message and its string attributes.
+func decodeBody(sqsMsg *types.Message) error {
+	if sqsMsg.Body == nil {
+		return nil
+	}
+	if attr, ok := sqsMsg.MessageAttributes[bodycodec.AttrEncoding]; ok {
+		body, err := bodycodec.Decode(derefStr(attr.StringValue), *sqsMsg.Body)
+		if err != nil {
+			return err
+		}
+		text := string(body)
+		sqsMsg.Body = &text
+		attrs := make(map[string]types.MessageAttributeValue, len(sqsMsg.MessageAttributes))
+		for k, v := range sqsMsg.MessageAttributes {
+			if k != bodycodec.AttrEncoding {
+				attrs[k] = v
+			}
+		}
+		sqsMsg.MessageAttributes = attrs
+		return nil
+	}
+
+	var env snsEnvelope
+	if json.Unmarshal([]byte(*sqsMsg.Body), &env) != nil || env.Type != "Notification" {
+		return nil
+	}
+	marker, ok := env.MessageAttributes[bodycodec.AttrEncoding]
+	if !ok {
+		return nil
+	}
+	body, err := bodycodec.Decode(marker.Value, env.Message)
+	if err != nil {
+		return err
+	}
+	text := string(body)
+	sqsMsg.Body = &text
+	attrs := make(map[string]types.MessageAttributeValue, len(sqsMsg.MessageAttributes)+len(env.MessageAttributes))
+	for k, v := range sqsMsg.MessageAttributes {
+		attrs[k] = v
+	}
+	for k, v := range env.MessageAttributes {
+		if k == bodycodec.AttrEncoding || v.Type == "Binary" {
+			continue
+		}
+		value := v.Value
+		attrs[k] = types.MessageAttributeValue{DataType: strPtr(v.Type), StringValue: &value}
+	}
+	sqsMsg.MessageAttributes = attrs
+	return nil
+}
+
+func derefStr(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,76 @@
+package sqs
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"oss.nandlabs.io/golly-aws/bodycodec"
+	"oss.nandlabs.io/golly/messaging"
+)
+
+func TestBodyEncoding_BinaryRoundTrip(t *testing.T) {
+	fake := &fakeSQSClient{}
+	echoSent(fake)
+	withFakeClient(t, fake, "http://fake/bin")
+	p := &Provider{}
+	u, _ := url.Parse("sqs://bin")
+	payload := []byte{0x08, 0x96, 0x01, 0x00, 0xff, 0x12, 0x07}
+
+	m, _ := p.NewMessage(SQSScheme)
+	_, _ = m.SetBodyBytes(payload)
+	opts := messaging.NewOptionsBuilder().Add(OptBodyEncoding, bodycodec.Auto).Build()
+	if err := p.SendCtx(context.Background(), u, m, opts...); err != nil {
+		t.Fatalf("SendCtx: %v", err)
+	}
+	sent := fake.sendCalls[0]
+	if v := sent.MessageAttributes[bodycodec.AttrEncoding]; v.StringValue == nil || *v.StringValue != bodycodec.Base64 {
+		t.Fatalf("encoding attribute = %+v", v)
+	}
+
+	got, err := p.ReceiveCtx(context.Background(), u)
+	if err != nil {
+		t.Fatalf("ReceiveCtx: %v", err)
+	}
+	if !bytes.Equal(got.ReadBytes(), payload) {
+		t.Fatalf("received %v, want %v", got.ReadBytes(), payload)
+	}
+	if _, ok := got.GetStrHeader(bodycodec.AttrEncoding); ok {
+		t.Fatalf("encoding attribute leaked into headers")
+	}
+
+	// Text bodies are left alone in auto mode.
+	if err := p.SendCtx(context.Background(), u, newProviderMsg(t, p, "plain"), opts...); err != nil {
+		t.Fatalf("SendCtx: %v", err)
+	}
+	if fake.sendCalls[1].MessageAttributes != nil || *fake.sendCalls[1].MessageBody != "plain" {
+		t.Fatalf("text body was encoded")
+	}
+}
+
+func TestBodyEncoding_SNSEnvelope(t *testing.T) {
+	envelope := `{"Type":"Notification","MessageId":"m-1","TopicArn":"arn:aws:sns:us-east-1:123456789012:t",` +
+		`"Message":"AP8BAg==","MessageAttributes":{"golly-body-encoding":{"Type":"String","Value":"base64"},` +
+		`"tenant":{"Type":"String","Value":"acme"}}}`
+	p := &Provider{}
+	u, _ := url.Parse("sqs://fanout")
+	msg, err := p.receivedMessage(context.Background(), u, &fakeSQSClient{}, types.Message{Body: &envelope}, "http://fake/fanout")
+	if err != nil {
+		t.Fatalf("receivedMessage: %v", err)
+	}
+	if !bytes.Equal(msg.ReadBytes(), []byte{0x00, 0xff, 0x01, 0x02}) {
+		t.Fatalf("body = %v", msg.ReadBytes())
+	}
+	if v, _ := msg.GetStrHeader("tenant"); v != "acme" {
+		t.Fatalf("tenant header = %q", v)
+	}
+
+	// Notifications without an encoded body are delivered as-is.
+	plain := `{"Type":"Notification","Message":"hi"}`
+	msg, _ = p.receivedMessage(context.Background(), u, &fakeSQSClient{}, types.Message{Body: &plain}, "http://fake/fanout")
+	if msg.ReadAsStr() != plain {
+		t.Fatalf("plain envelope was rewritten: %q", msg.ReadAsStr())
+	}
+}
@@ -140,10 +140,10 @@ func parseBrokerOptions(optResolver *messaging.OptionsResolver, isFIFO bool) (*b
 // it satisfies the caller's MaxDeliveryAttempts / DeadLetter requirements.
 // It is invoked from AddListener / ReceiveBatch when either option is set.
 //
-// This provider deliberately does NOT create the DLQ or attach a redrive
+// Messaging options deliberately do NOT create the DLQ or attach a redrive
 // policy — DLQ topology is an infrastructure decision that belongs at queue
-// creation time. If the queue lacks a RedrivePolicy, an error is returned
-// pointing the caller at queue creation.
+// creation time (see QueueAdmin.EnsureQueue). If the queue lacks a
+// RedrivePolicy, an error is returned pointing the caller at queue creation.
 func (bo *brokerOptions) validateRedrivePolicy(ctx context.Context, client queueAttributesGetter, queueURL string) error {
 	if !bo.hasMaxDeliveryAttempts && bo.deadLetter == "" {
 		return nil
@@ -159,7 +159,7 @@ func (bo *brokerOptions) validateRedrivePolicy(ctx context.Context, client queue
 	}
 	raw, ok := out.Attributes[string(sqsTypes.QueueAttributeNameRedrivePolicy)]
 	if !ok || raw == "" {
-		return fmt.Errorf("sqs: queue %s has no RedrivePolicy; configure a DLQ + maxReceiveCount at queue creation time (this provider does not auto-create DLQs)", queueURL)
+		return fmt.Errorf("sqs: queue %s has no RedrivePolicy; configure a DLQ + maxReceiveCount at queue creation time (see sqs.Admin().EnsureQueue; messaging options do not auto-create DLQs)", queueURL)
 	}
 	var rp struct {
 		DeadLetterTargetArn string `json:"deadLetterTargetArn"`
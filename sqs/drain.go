@@ -0,0 +1,211 @@
+package sqs
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// OptDrainTimeout is how long Close and RemoveListeners wait for a
+// listener's in-flight handlers to return (a time.Duration). Default:
+// DefaultDrainTimeout.
+const OptDrainTimeout = "DrainTimeout"
+
+// DefaultDrainTimeout is the drain timeout of listeners without an
+// OptDrainTimeout.
+const DefaultDrainTimeout = 30 * time.Second
+
+// AbandonedMessage is a message a listener shutdown could not finish
+// cleanly. SQS redelivers it once its visibility timeout expires.
+type AbandonedMessage struct {
+	Queue     string
+	MessageId string
+	// InFlight reports that the handler was still running at the drain
+	// deadline; otherwise the message was received but never dispatched
+	// and resetting its visibility failed with Err.
+	InFlight bool
+	Err      error
+}
+
+// DrainError is returned by Close, RemoveListeners and their Ctx variants
+// when listeners did not drain cleanly.
+type DrainError struct {
+	Abandoned []AbandonedMessage
+}
+
+func (e *DrainError) Error() string {
+	inFlight := 0
+	queues := map[string]bool{}
+	for _, a := range e.Abandoned {
+		if a.InFlight {
+			inFlight++
+		}
+		queues[a.Queue] = true
+	}
+	names := slices.Sorted(maps.Keys(queues))
+	return fmt.Sprintf("sqs: drain abandoned %d in-flight and %d undelivered message(s) on %s",
+		inFlight, len(e.Abandoned)-inFlight, strings.Join(names, ", "))
+}
+
+// listenerState tracks what a listener goroutine holds so a shutdown can
+// wait for it and release what it never dispatched.
+type listenerState struct {
+	queue    string
+	client   API
+	queueURL string
+	timeout  time.Duration
+	// done is closed when the goroutine has exited.
+	done chan struct{}
+
+	mu sync.Mutex
+	// pending are received messages not yet dispatched.
+	pending []types.Message
+	// inFlight are the IDs of the messages being handled.
+	inFlight  map[string]struct{}
+	abandoned []AbandonedMessage
+}
+
+func newListenerState(u *url.URL, client API, queueURL string, timeout time.Duration) *listenerState {
+	return &listenerState{
+		queue:    u.Host,
+		client:   client,
+		queueURL: queueURL,
+		timeout:  timeout,
+		done:     make(chan struct{}),
+		inFlight: make(map[string]struct{}),
+	}
+}
+
+// buffer records a freshly received batch.
+func (s *listenerState) buffer(msgs []types.Message) {
+	s.mu.Lock()
+	s.pending = append(s.pending, msgs...)
+	s.mu.Unlock()
+}
+
+// next pops the next buffered message for dispatch. It returns false when
+// the buffer is empty or the listener is stopping.
+func (s *listenerState) next(ctx context.Context) (types.Message, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pending) == 0 || ctx.Err() != nil {
+		return types.Message{}, false
+	}
+	m := s.pending[0]
+	s.pending = s.pending[1:]
+	s.inFlight[derefStr(m.MessageId)] = struct{}{}
+	return m, true
+}
+
+// take moves the buffered message id to in flight. It returns false when
+// the message is no longer buffered or the listener is stopping.
+func (s *listenerState) take(ctx context.Context, id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ctx.Err() != nil || !s.remove(id) {
+		return false
+	}
+	s.inFlight[id] = struct{}{}
+	return true
+}
+
+// drop removes the buffered message id without dispatching it.
+func (s *listenerState) drop(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.remove(id)
+}
+
+func (s *listenerState) remove(id string) bool {
+	i := slices.IndexFunc(s.pending, func(m types.Message) bool { return derefStr(m.MessageId) == id })
+	if i < 0 {
+		return false
+	}
+	s.pending = slices.Delete(s.pending, i, i+1)
+	return true
+}
+
+// finish marks the dispatched message id as handled.
+func (s *listenerState) finish(id string) {
+	s.mu.Lock()
+	delete(s.inFlight, id)
+	s.mu.Unlock()
+}
+
+// release resets the visibility of every buffered message to 0 so other
+// consumers receive them at once, recording the ones that failed.
+func (s *listenerState) release(p *Provider) {
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+	for _, m := range pending {
+		err := p.changeVisibility(s.client, s.queueURL, derefStr(m.ReceiptHandle), 0)
+		if err != nil {
+			s.mu.Lock()
+			s.abandoned = append(s.abandoned, AbandonedMessage{Queue: s.queue, MessageId: derefStr(m.MessageId), Err: err})
+			s.mu.Unlock()
+		}
+	}
+	if len(pending) > 0 {
+		logger.InfoF("SQS listener for %s released %d undelivered message(s)", s.queueURL, len(pending))
+	}
+}
+
+// wait blocks until the goroutine exits or deadline passes, then returns
+// what was abandoned. Past the deadline it releases the buffer itself and
+// reports the messages of the running handlers.
+func (s *listenerState) wait(p *Provider, deadline <-chan time.Time) []AbandonedMessage {
+	select {
+	case <-s.done:
+	case <-deadline:
+		s.release(p)
+		s.mu.Lock()
+		for _, id := range slices.Sorted(maps.Keys(s.inFlight)) {
+			s.abandoned = append(s.abandoned, AbandonedMessage{Queue: s.queue, MessageId: id, InFlight: true})
+		}
+		s.mu.Unlock()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]AbandonedMessage(nil), s.abandoned...)
+}
+
+// drain cancels entries and waits for them, each up to its own drain
+// timeout and at most until ctx is done.
+func (p *Provider) drain(ctx context.Context, entries []sqsListenerEntry) error {
+	for _, e := range entries {
+		e.cancel()
+	}
+	var abandoned []AbandonedMessage
+	start := time.Now()
+	for _, e := range entries {
+		if e.state == nil {
+			continue
+		}
+		timer := time.NewTimer(max(time.Until(start.Add(e.state.timeout)), 0))
+		deadline := make(chan time.Time, 1)
+		go func() {
+			select {
+			case t := <-timer.C:
+				deadline <- t
+			case <-ctx.Done():
+				deadline <- time.Now()
+			case <-e.state.done:
+			}
+		}()
+		abandoned = append(abandoned, e.state.wait(p, deadline)...)
+		timer.Stop()
+	}
+	if len(abandoned) > 0 {
+		return &DrainError{Abandoned: abandoned}
+	}
+	return nil
+}
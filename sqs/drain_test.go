@@ -0,0 +1,164 @@
+package sqs
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awssqs "github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"oss.nandlabs.io/golly/messaging"
+)
+
+// threeThenBlock makes fake return one batch of three messages, then long
+// poll until cancelled.
+func threeThenBlock(fake *fakeSQSClient) {
+	var once sync.Once
+	fake.recvFn = func(ctx context.Context, in *awssqs.ReceiveMessageInput) (*awssqs.ReceiveMessageOutput, error) {
+		out := &awssqs.ReceiveMessageOutput{}
+		once.Do(func() {
+			for _, id := range []string{"m1", "m2", "m3"} {
+				out.Messages = append(out.Messages, types.Message{
+					MessageId: aws.String(id), Body: aws.String(id), ReceiptHandle: aws.String("rh-" + id),
+				})
+			}
+		})
+		if len(out.Messages) == 0 {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return out, nil
+	}
+}
+
+func TestClose_WaitsForHandlerAndReleasesBuffer(t *testing.T) {
+	fake := &fakeSQSClient{}
+	threeThenBlock(fake)
+	withFakeClient(t, fake, "http://fake/work")
+	p := &Provider{}
+	u, _ := url.Parse("sqs://work")
+
+	started, finish := make(chan struct{}), make(chan struct{})
+	var handled []string
+	err := p.AddListener(u, func(msg messaging.Message) {
+		handled = append(handled, msg.ReadAsStr())
+		close(started)
+		<-finish
+	})
+	if err != nil {
+		t.Fatalf("AddListener: %v", err)
+	}
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("listener did not deliver")
+	}
+
+	closed := make(chan error, 1)
+	go func() { closed <- p.Close() }()
+	select {
+	case err := <-closed:
+		t.Fatalf("Close returned before the handler finished: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(finish)
+	if err := <-closed; err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if len(handled) != 1 {
+		t.Fatalf("handled = %v", handled)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.visCalls) != 2 {
+		t.Fatalf("visibility changes = %+v", fake.visCalls)
+	}
+	for i, rh := range []string{"rh-m2", "rh-m3"} {
+		if c := fake.visCalls[i]; *c.ReceiptHandle != rh || c.VisibilityTimeout != 0 {
+			t.Fatalf("visibility change %d = %+v", i, c)
+		}
+	}
+}
+
+func TestRemoveListeners_ReportsAbandonedHandler(t *testing.T) {
+	fake := &fakeSQSClient{}
+	threeThenBlock(fake)
+	withFakeClient(t, fake, "http://fake/work")
+	p := &Provider{}
+	u, _ := url.Parse("sqs://work")
+
+	started, finish := make(chan struct{}), make(chan struct{})
+	t.Cleanup(func() { close(finish) })
+	opts := messaging.NewOptionsBuilder().Add(OptDrainTimeout, 20*time.Millisecond).Build()
+	err := p.AddListener(u, func(messaging.Message) {
+		close(started)
+		<-finish
+	}, opts...)
+	if err != nil {
+		t.Fatalf("AddListener: %v", err)
+	}
+	<-started
+
+	err = p.RemoveListeners(u)
+	var de *DrainError
+	if !errors.As(err, &de) {
+		t.Fatalf("RemoveListeners = %v, want *DrainError", err)
+	}
+	if len(de.Abandoned) != 1 || !de.Abandoned[0].InFlight || de.Abandoned[0].MessageId != "m1" || de.Abandoned[0].Queue != "work" {
+		t.Fatalf("abandoned = %+v", de.Abandoned)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.visCalls) != 2 {
+		t.Fatalf("undelivered messages not released: %+v", fake.visCalls)
+	}
+}
+
+func TestCloseCtx_DeadlineBoundsDrain(t *testing.T) {
+	fake := &fakeSQSClient{}
+	threeThenBlock(fake)
+	withFakeClient(t, fake, "http://fake/work")
+	p := &Provider{}
+	u, _ := url.Parse("sqs://work")
+
+	started, finish := make(chan struct{}), make(chan struct{})
+	t.Cleanup(func() { close(finish) })
+	if err := p.AddListener(u, func(messaging.Message) {
+		close(started)
+		<-finish
+	}); err != nil {
+		t.Fatalf("AddListener: %v", err)
+	}
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	var de *DrainError
+	if err := p.CloseCtx(ctx); !errors.As(err, &de) {
+		t.Fatalf("CloseCtx = %v, want *DrainError", err)
+	}
+	if d := time.Since(begin); d > 5*time.Second {
+		t.Fatalf("CloseCtx waited %s despite the context deadline", d)
+	}
+}
+
+func TestDrainError_Error(t *testing.T) {
+	err := &DrainError{Abandoned: []AbandonedMessage{
+		{Queue: "orders", MessageId: "1", InFlight: true},
+		{Queue: "audit", MessageId: "2"},
+		{Queue: "billing", MessageId: "3", InFlight: true},
+		{Queue: "audit", MessageId: "4", InFlight: true},
+	}}
+	want := "sqs: drain abandoned 3 in-flight and 1 undelivered message(s) on audit, billing, orders"
+	for range 5 {
+		if got := err.Error(); got != want {
+			t.Fatalf("Error() = %q, want %q", got, want)
+		}
+	}
+}
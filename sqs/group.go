@@ -0,0 +1,142 @@
+package sqs
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"oss.nandlabs.io/golly/messaging"
+)
+
+// OptGroupConcurrency makes a listener on a FIFO queue handle messages on
+// that many workers (an int). Messages are sharded by MessageGroupId, so
+// each group is handled strictly in order on one worker while different
+// groups run in parallel. When a message is nacked, or held back by
+// OptSchedule, the rest of its group in the same receive is released
+// unhandled, so that SQS redelivers the group in order. Without the option
+// a listener handles one message at a time.
+const OptGroupConcurrency = "GroupConcurrency"
+
+// groupQueueDepth is how many messages may wait for each group worker
+// before the listener stops polling.
+const groupQueueDepth = 10
+
+// resolveGroupConcurrency returns the OptGroupConcurrency option, or 0 if
+// unset.
+func resolveGroupConcurrency(optResolver *messaging.OptionsResolver, queueURL string) (int, error) {
+	v, ok := optResolver.Get(OptGroupConcurrency)
+	if !ok {
+		return 0, nil
+	}
+	n, ok := v.(int)
+	if !ok || n < 1 {
+		return 0, fmt.Errorf("sqs: %s: expected positive int, got %v", OptGroupConcurrency, v)
+	}
+	if !isFIFOQueue(queueURL) {
+		return 0, fmt.Errorf("sqs: %s requires a FIFO queue, got %s", OptGroupConcurrency, queueURL)
+	}
+	return n, nil
+}
+
+// groupItem is a received message queued for a group worker. receive
+// numbers the ReceiveMessage call that returned it.
+type groupItem struct {
+	msg     types.Message
+	receive uint64
+}
+
+// groupDispatcher runs the workers of a FIFO group listener.
+type groupDispatcher struct {
+	p        *Provider
+	u        *url.URL
+	client   API
+	queueURL string
+	state    *listenerState
+	retry    *RetryPolicy
+	listener func(msg messaging.Message)
+
+	workers  []chan groupItem
+	receives uint64
+	wg       sync.WaitGroup
+}
+
+// start launches n workers that handle messages until stop is called.
+func (d *groupDispatcher) start(ctx context.Context, n int) {
+	d.workers = make([]chan groupItem, n)
+	for i := range d.workers {
+		d.workers[i] = make(chan groupItem, groupQueueDepth)
+		d.wg.Add(1)
+		go func(items <-chan groupItem) {
+			defer d.wg.Done()
+			d.work(ctx, items)
+		}(d.workers[i])
+	}
+}
+
+// dispatch queues a received batch, in order, on the workers of its
+// groups. It blocks while a worker's queue is full and gives up when ctx
+// is done, leaving the rest buffered for release.
+func (d *groupDispatcher) dispatch(ctx context.Context, msgs []types.Message) {
+	d.receives++
+	for _, m := range msgs {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(messageGroup(m)))
+		select {
+		case d.workers[h.Sum32()%uint32(len(d.workers))] <- groupItem{msg: m, receive: d.receives}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// stop closes the worker queues and waits for the workers to finish.
+func (d *groupDispatcher) stop() {
+	for _, w := range d.workers {
+		close(w)
+	}
+	d.wg.Wait()
+}
+
+func (d *groupDispatcher) work(ctx context.Context, items <-chan groupItem) {
+	// paused maps a group to the receive in which one of its messages was
+	// put back. Messages of that group from the same or an earlier receive
+	// would overtake it; SQS delivers later ones only after it.
+	paused := make(map[string]uint64)
+	for it := range items {
+		group, id := messageGroup(it.msg), derefStr(it.msg.MessageId)
+		if r, ok := paused[group]; ok {
+			if it.receive <= r {
+				d.release(it.msg)
+				continue
+			}
+			delete(paused, group)
+		}
+		if !d.state.take(ctx, id) {
+			continue
+		}
+		putBack := d.p.dispatch(ctx, d.u, d.client, d.queueURL, it.msg, d.retry, d.listener)
+		d.state.finish(id)
+		if putBack {
+			paused[group] = it.receive
+		}
+	}
+}
+
+// release makes a message of a paused group visible again at once.
+func (d *groupDispatcher) release(m types.Message) {
+	if !d.state.drop(derefStr(m.MessageId)) {
+		return
+	}
+	if err := d.p.changeVisibility(d.client, d.queueURL, derefStr(m.ReceiptHandle), 0); err != nil {
+		logger.WarnF("SQS listener could not release message %s of paused group %s on %s: %v",
+			derefStr(m.MessageId), messageGroup(m), d.queueURL, err)
+	}
+}
+
+// messageGroup returns the MessageGroupId system attribute of m.
+func messageGroup(m types.Message) string {
+	return m.Attributes[string(types.MessageSystemAttributeNameMessageGroupId)]
+}
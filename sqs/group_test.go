@@ -0,0 +1,165 @@
+package sqs
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awssqs "github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"oss.nandlabs.io/golly/messaging"
+)
+
+// groupBatch makes fake return one batch with a message per "group/id"
+// spec, then long poll until cancelled.
+func groupBatch(fake *fakeSQSClient, specs ...[2]string) {
+	var once sync.Once
+	fake.recvFn = func(ctx context.Context, in *awssqs.ReceiveMessageInput) (*awssqs.ReceiveMessageOutput, error) {
+		out := &awssqs.ReceiveMessageOutput{}
+		once.Do(func() {
+			for _, s := range specs {
+				out.Messages = append(out.Messages, types.Message{
+					MessageId:     aws.String(s[1]),
+					Body:          aws.String(s[1]),
+					ReceiptHandle: aws.String("rh-" + s[1]),
+					Attributes:    map[string]string{"MessageGroupId": s[0]},
+				})
+			}
+		})
+		if len(out.Messages) == 0 {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return out, nil
+	}
+}
+
+func TestResolveGroupConcurrency(t *testing.T) {
+	for name, tc := range map[string]struct {
+		queue string
+		value any
+		want  int
+		err   bool
+	}{
+		"fifo":     {"http://fake/q.fifo", 4, 4, false},
+		"standard": {"http://fake/q", 4, 0, true},
+		"zero":     {"http://fake/q.fifo", 0, 0, true},
+		"type":     {"http://fake/q.fifo", "4", 0, true},
+	} {
+		opts := messaging.NewOptionsBuilder().Add(OptGroupConcurrency, tc.value).Build()
+		n, err := resolveGroupConcurrency(messaging.NewOptionsResolver(opts...), tc.queue)
+		if n != tc.want || (err != nil) != tc.err {
+			t.Errorf("%s: got %d, %v", name, n, err)
+		}
+	}
+	if n, err := resolveGroupConcurrency(messaging.NewOptionsResolver(), "http://fake/q"); n != 0 || err != nil {
+		t.Errorf("unset: got %d, %v", n, err)
+	}
+}
+
+func TestGroupListener_OrdersGroupsAndRunsThemInParallel(t *testing.T) {
+	fake := &fakeSQSClient{}
+	// With two workers, groups "a" and "b" hash to different ones.
+	groupBatch(fake, [2]string{"a", "a1"}, [2]string{"a", "a2"}, [2]string{"b", "b1"})
+	withFakeClient(t, fake, "http://fake/orders.fifo")
+	p := &Provider{}
+	u, _ := url.Parse("sqs://orders.fifo")
+	t.Cleanup(func() { _ = p.Close() })
+
+	var mu sync.Mutex
+	var order []string
+	b1 := make(chan struct{})
+	done := make(chan struct{})
+	opts := messaging.NewOptionsBuilder().Add(OptGroupConcurrency, 2).Build()
+	err := p.AddListener(u, func(msg messaging.Message) {
+		body := msg.ReadAsStr()
+		switch body {
+		case "a1":
+			// Blocks group "a" until group "b" has run alongside it.
+			select {
+			case <-b1:
+			case <-time.After(5 * time.Second):
+			}
+		case "b1":
+			close(b1)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, body)
+		if len(order) == 3 {
+			close(done)
+		}
+	}, opts...)
+	if err != nil {
+		t.Fatalf("AddListener: %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("listener did not deliver all messages")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if order[0] != "b1" || order[1] != "a1" || order[2] != "a2" {
+		t.Fatalf("order = %v", order)
+	}
+}
+
+func TestGroupListener_NackPausesGroup(t *testing.T) {
+	fake := &fakeSQSClient{}
+	groupBatch(fake, [2]string{"a", "a1"}, [2]string{"a", "a2"}, [2]string{"b", "b1"})
+	withFakeClient(t, fake, "http://fake/orders.fifo")
+	p := &Provider{}
+	u, _ := url.Parse("sqs://orders.fifo")
+
+	var mu sync.Mutex
+	var handled []string
+	opts := messaging.NewOptionsBuilder().Add(OptGroupConcurrency, 2).Build()
+	err := p.AddListener(u, func(msg messaging.Message) {
+		mu.Lock()
+		handled = append(handled, msg.ReadAsStr())
+		mu.Unlock()
+		_ = msg.Rsvp(msg.ReadAsStr() != "a1")
+	}, opts...)
+	if err != nil {
+		t.Fatalf("AddListener: %v", err)
+	}
+
+	// a1 and a2 are both put back: a1 by the nack, a2 by the pause.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		fake.mu.Lock()
+		n := len(fake.visCalls)
+		fake.mu.Unlock()
+		if n >= 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("group was not paused")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, h := range handled {
+		if h == "a2" {
+			t.Fatalf("a2 handled after a1 was nacked: %v", handled)
+		}
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	released := map[string]int32{}
+	for _, c := range fake.visCalls {
+		released[*c.ReceiptHandle] = c.VisibilityTimeout
+	}
+	if v, ok := released["rh-a2"]; !ok || v != 0 {
+		t.Fatalf("a2 not released: %+v", fake.visCalls)
+	}
+}
@@ -0,0 +1,271 @@
+package sqs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"oss.nandlabs.io/golly-aws/s3"
+	"oss.nandlabs.io/golly/managers"
+	"oss.nandlabs.io/golly/vfs"
+)
+
+// DefaultLargePayloadThreshold is the size in bytes above which a message
+// is offloaded when LargePayloadConfig.Threshold is zero. It matches the
+// SQS message size limit (256 KiB).
+const DefaultLargePayloadThreshold = 256 * 1024
+
+const (
+	// extendedPayloadSizeAttr carries the original body size of an
+	// offloaded message. The name and the pointer body are the ones used
+	// by the AWS SQS Extended Client libraries, so either side can be a
+	// Java, Python or golly client.
+	extendedPayloadSizeAttr = "ExtendedPayloadSize"
+	// legacyPayloadSizeAttr is the attribute name used by version 1 of
+	// the Java extended client. It is recognised on receive only.
+	legacyPayloadSizeAttr = "SQSLargePayloadSize"
+	// payloadPointerClass tags the pointer body written by the Java client.
+	payloadPointerClass = "software.amazon.payloadoffloading.PayloadS3Pointer"
+)
+
+// LargePayloadConfig enables S3 offloading of large message bodies for a
+// queue. Register it with LargePayloadManager under the queue name:
+//
+//	sqs.LargePayloadManager.Register("orders", &sqs.LargePayloadConfig{
+//		Location: "s3://my-payload-bucket/orders/",
+//	})
+//
+// Pointer messages are resolved on receive whether or not the receiving
+// queue has a config; the config only decides how they are sent and what
+// happens to the S3 object on acknowledgement.
+type LargePayloadConfig struct {
+	// Location is the s3:// URL of the bucket, and optional key prefix,
+	// payloads are written to.
+	Location string
+	// Threshold is the message size in bytes (body plus attributes) above
+	// which the body is offloaded. Zero means DefaultLargePayloadThreshold.
+	Threshold int
+	// AlwaysThroughS3 offloads every message regardless of its size.
+	AlwaysThroughS3 bool
+	// KeepOnAck leaves the S3 object in place when the message is
+	// acknowledged, for buckets that expire payloads with a lifecycle rule.
+	KeepOnAck bool
+}
+
+// LargePayloadManager holds the LargePayloadConfig for each queue, keyed by
+// queue name (the sqs:// URL host).
+var LargePayloadManager = managers.NewItemManager[*LargePayloadConfig]()
+
+// payloadFS stores offloaded payloads, which are always s3:// objects,
+// with the context of the send, receive or acknowledgement.
+var payloadFS vfs.VFileSystemCtx = &s3.S3FS{}
+
+// payloadPointer is the location of an offloaded body.
+type payloadPointer struct {
+	Bucket string `json:"s3BucketName"`
+	Key    string `json:"s3Key"`
+}
+
+func (pp *payloadPointer) url() *url.URL {
+	return &url.URL{Scheme: "s3", Host: pp.Bucket, Path: "/" + pp.Key}
+}
+
+// messageSize returns the size SQS counts against its limit: the body plus
+// the name, type and value of every attribute.
+func messageSize(body string, attrs map[string]types.MessageAttributeValue) int {
+	size := len(body)
+	for name, v := range attrs {
+		size += len(name)
+		if v.DataType != nil {
+			size += len(*v.DataType)
+		}
+		if v.StringValue != nil {
+			size += len(*v.StringValue)
+		}
+		size += len(v.BinaryValue)
+	}
+	return size
+}
+
+// offloadPayload stores body in S3 when the queue's LargePayloadConfig asks
+// for it. It returns the body and attributes to send in its place, which
+// are the originals when nothing was offloaded, and the pointer to the
+// stored payload, nil when nothing was offloaded. A caller that then fails
+// to send the message discards the payload with discardPayload.
+func offloadPayload(ctx context.Context, u *url.URL, body string, attrs map[string]types.MessageAttributeValue) (string, map[string]types.MessageAttributeValue, *payloadPointer, error) {
+	cfg := LargePayloadManager.Get(u.Host)
+	if cfg == nil {
+		return body, attrs, nil, nil
+	}
+	threshold := cfg.Threshold
+	if threshold <= 0 {
+		threshold = DefaultLargePayloadThreshold
+	}
+	if !cfg.AlwaysThroughS3 && messageSize(body, attrs) <= threshold {
+		return body, attrs, nil, nil
+	}
+	if _, ok := attrs[extendedPayloadSizeAttr]; ok {
+		return "", nil, nil, fmt.Errorf("sqs: message attribute %s is reserved for large payloads", extendedPayloadSizeAttr)
+	}
+
+	loc, err := url.Parse(cfg.Location)
+	if err != nil || loc.Scheme != "s3" || loc.Host == "" {
+		return "", nil, nil, fmt.Errorf("sqs: invalid large payload location %q for queue %s", cfg.Location, u.Host)
+	}
+	key, err := newPayloadKey()
+	if err != nil {
+		return "", nil, nil, err
+	}
+	prefix := strings.TrimPrefix(loc.Path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	ptr := &payloadPointer{Bucket: loc.Host, Key: prefix + key}
+	pointerBody, err := json.Marshal([]any{payloadPointerClass, ptr})
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if err := writePayload(ctx, ptr.url(), body); err != nil {
+		return "", nil, nil, err
+	}
+
+	out := make(map[string]types.MessageAttributeValue, len(attrs)+1)
+	for k, v := range attrs {
+		out[k] = v
+	}
+	out[extendedPayloadSizeAttr] = types.MessageAttributeValue{
+		DataType:    strPtr("Number"),
+		StringValue: strPtr(strconv.Itoa(len(body))),
+	}
+	return string(pointerBody), out, ptr, nil
+}
+
+// discardPayload deletes the payload ptr points to, if any, after its
+// message failed to send. It runs even when ctx is done, since that is
+// often why the send failed; a failed delete is only logged.
+func discardPayload(ctx context.Context, ptr *payloadPointer) {
+	if ptr == nil {
+		return
+	}
+	if err := deletePayload(context.WithoutCancel(ctx), ptr.url()); err != nil {
+		logger.WarnF("sqs: failed to discard the payload of an unsent message: %v", err)
+	}
+}
+
+// resolvePayload replaces the body of a pointer message with the offloaded
+// payload. It returns the pointer (nil for ordinary messages) so the
+// payload can be cleaned up on acknowledgement.
+func resolvePayload(ctx context.Context, sqsMsg *types.Message) (*payloadPointer, error) {
+	attr := extendedPayloadSizeAttr
+	if _, ok := sqsMsg.MessageAttributes[attr]; !ok {
+		attr = legacyPayloadSizeAttr
+		if _, ok := sqsMsg.MessageAttributes[attr]; !ok {
+			return nil, nil
+		}
+	}
+	if sqsMsg.Body == nil {
+		return nil, fmt.Errorf("sqs: large payload message has no pointer body")
+	}
+	ptr, err := parsePayloadPointer(*sqsMsg.Body)
+	if err != nil {
+		return nil, err
+	}
+	body, err := readPayload(ctx, ptr.url())
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := make(map[string]types.MessageAttributeValue, len(sqsMsg.MessageAttributes))
+	for k, v := range sqsMsg.MessageAttributes {
+		if k != attr {
+			attrs[k] = v
+		}
+	}
+	sqsMsg.Body = &body
+	sqsMsg.MessageAttributes = attrs
+	return ptr, nil
+}
+
+// parsePayloadPointer accepts both the Java form
+// ["software.amazon.payloadoffloading.PayloadS3Pointer",{...}] and the
+// bare object written by the Python client.
+func parsePayloadPointer(body string) (*payloadPointer, error) {
+	ptr := &payloadPointer{}
+	var tagged []json.RawMessage
+	if err := json.Unmarshal([]byte(body), &tagged); err == nil {
+		if len(tagged) != 2 {
+			return nil, fmt.Errorf("sqs: invalid large payload pointer %q", body)
+		}
+		if err := json.Unmarshal(tagged[1], ptr); err != nil {
+			return nil, fmt.Errorf("sqs: invalid large payload pointer: %w", err)
+		}
+	} else if err := json.Unmarshal([]byte(body), ptr); err != nil {
+		return nil, fmt.Errorf("sqs: invalid large payload pointer: %w", err)
+	}
+	if ptr.Bucket == "" || ptr.Key == "" {
+		return nil, fmt.Errorf("sqs: invalid large payload pointer %q", body)
+	}
+	return ptr, nil
+}
+
+// keepPayloadOnAck reports whether payloads received from u stay in S3
+// after the message is acknowledged.
+func keepPayloadOnAck(u *url.URL) bool {
+	cfg := LargePayloadManager.Get(u.Host)
+	return cfg != nil && cfg.KeepOnAck
+}
+
+func writePayload(ctx context.Context, u *url.URL, body string) error {
+	f, err := payloadFS.CreateCtx(ctx, u)
+	if err != nil {
+		return fmt.Errorf("sqs: store large payload %s: %w", u, err)
+	}
+	if _, err := io.WriteString(f, body); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("sqs: store large payload %s: %w", u, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("sqs: store large payload %s: %w", u, err)
+	}
+	return nil
+}
+
+func readPayload(ctx context.Context, u *url.URL) (string, error) {
+	f, err := payloadFS.OpenCtx(ctx, u)
+	if err != nil {
+		return "", fmt.Errorf("sqs: load large payload %s: %w", u, err)
+	}
+	defer func() { _ = f.Close() }()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return "", fmt.Errorf("sqs: load large payload %s: %w", u, err)
+	}
+	return string(b), nil
+}
+
+func deletePayload(ctx context.Context, u *url.URL) error {
+	if err := payloadFS.DeleteCtx(ctx, u); err != nil {
+		return fmt.Errorf("sqs: delete large payload %s: %w", u, err)
+	}
+	return nil
+}
+
+// newPayloadKey returns a random UUID-formatted object key, like the keys
+// the AWS extended clients generate.
+func newPayloadKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	h := hex.EncodeToString(b)
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:], nil
+}
@@ -0,0 +1,221 @@
+package sqs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	awssqs "github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"oss.nandlabs.io/golly-aws/awscfg"
+	"oss.nandlabs.io/golly-aws/s3"
+	"oss.nandlabs.io/golly/messaging"
+)
+
+// withPayloadBucket backs bucket with an in-memory S3 and registers cfg for
+// queue.
+func withPayloadBucket(t *testing.T, bucket, queue string, cfg *LargePayloadConfig) {
+	t.Helper()
+	mem := s3.NewMemoryBackend()
+	if err := mem.CreateBucket(bucket); err != nil {
+		t.Fatalf("CreateBucket: %v", err)
+	}
+	awscfg.Manager.Register(bucket, mem.Config("us-east-1"))
+	LargePayloadManager.Register(queue, cfg)
+	t.Cleanup(func() {
+		awscfg.Manager.Unregister(bucket)
+		LargePayloadManager.Unregister(queue)
+	})
+}
+
+// echoSent makes the fake deliver the last message it was sent.
+func echoSent(fake *fakeSQSClient) {
+	fake.recvFn = func(ctx context.Context, in *awssqs.ReceiveMessageInput) (*awssqs.ReceiveMessageOutput, error) {
+		fake.mu.Lock()
+		sent := fake.sendCalls[len(fake.sendCalls)-1]
+		fake.mu.Unlock()
+		rh := "receipt"
+		return &awssqs.ReceiveMessageOutput{Messages: []types.Message{{
+			Body:              sent.MessageBody,
+			MessageAttributes: sent.MessageAttributes,
+			ReceiptHandle:     &rh,
+		}}}, nil
+	}
+}
+
+func TestLargePayload_RoundTrip(t *testing.T) {
+	withPayloadBucket(t, "sqs-payloads", "big-queue", &LargePayloadConfig{
+		Location:  "s3://sqs-payloads/in/",
+		Threshold: 64,
+	})
+	fake := &fakeSQSClient{}
+	echoSent(fake)
+	withFakeClient(t, fake, "http://fake/big-queue")
+	p := &Provider{}
+	u, _ := url.Parse("sqs://big-queue")
+	ctx := context.Background()
+	body := strings.Repeat("x", 100)
+
+	if err := p.SendCtx(ctx, u, newProviderMsg(t, p, body)); err != nil {
+		t.Fatalf("SendCtx: %v", err)
+	}
+	sent := fake.sendCalls[0]
+	var pointer []json.RawMessage
+	if err := json.Unmarshal([]byte(*sent.MessageBody), &pointer); err != nil || len(pointer) != 2 {
+		t.Fatalf("body is not a pointer: %q", *sent.MessageBody)
+	}
+	ptr, err := parsePayloadPointer(*sent.MessageBody)
+	if err != nil || ptr.Bucket != "sqs-payloads" || !strings.HasPrefix(ptr.Key, "in/") {
+		t.Fatalf("pointer = %+v, %v", ptr, err)
+	}
+	if v := sent.MessageAttributes[extendedPayloadSizeAttr]; v.StringValue == nil || *v.StringValue != "100" {
+		t.Fatalf("size attribute = %+v", v)
+	}
+
+	msg, err := p.ReceiveCtx(ctx, u)
+	if err != nil {
+		t.Fatalf("ReceiveCtx: %v", err)
+	}
+	if got := msg.ReadAsStr(); got != body {
+		t.Fatalf("received body = %q", got)
+	}
+	if _, ok := msg.GetStrHeader(extendedPayloadSizeAttr); ok {
+		t.Fatalf("size attribute leaked into headers")
+	}
+	if err := msg.Rsvp(true); err != nil {
+		t.Fatalf("Rsvp: %v", err)
+	}
+	if _, err := readPayload(context.Background(), ptr.url()); err == nil {
+		t.Fatalf("payload still exists after ack")
+	}
+
+	// Small bodies go inline.
+	if err := p.SendCtx(ctx, u, newProviderMsg(t, p, "small")); err != nil {
+		t.Fatalf("SendCtx: %v", err)
+	}
+	if got := *fake.sendCalls[1].MessageBody; got != "small" || fake.sendCalls[1].MessageAttributes != nil {
+		t.Fatalf("small message = %q, %v", got, fake.sendCalls[1].MessageAttributes)
+	}
+}
+
+func TestLargePayload_KeepOnAck(t *testing.T) {
+	withPayloadBucket(t, "sqs-payloads-keep", "keep-queue", &LargePayloadConfig{
+		Location:        "s3://sqs-payloads-keep",
+		AlwaysThroughS3: true,
+		KeepOnAck:       true,
+	})
+	fake := &fakeSQSClient{}
+	echoSent(fake)
+	withFakeClient(t, fake, "http://fake/keep-queue")
+	p := &Provider{}
+	u, _ := url.Parse("sqs://keep-queue")
+
+	if err := p.SendBatchCtx(context.Background(), u, []messaging.Message{newProviderMsg(t, p, "tiny")}); err != nil {
+		t.Fatalf("SendBatchCtx: %v", err)
+	}
+	entry := fake.batchCalls[0].Entries[0]
+	ptr, err := parsePayloadPointer(*entry.MessageBody)
+	if err != nil {
+		t.Fatalf("batch entry was not offloaded: %v", err)
+	}
+	body, err := readPayload(context.Background(), ptr.url())
+	if err != nil || body != "tiny" {
+		t.Fatalf("payload = %q, %v", body, err)
+	}
+
+	msg, err := p.receivedMessage(context.Background(), u, fake, types.Message{Body: entry.MessageBody, MessageAttributes: entry.MessageAttributes}, "http://fake/keep-queue")
+	if err != nil || msg.ReadAsStr() != "tiny" {
+		t.Fatalf("receivedMessage = %v", err)
+	}
+	if err := msg.Rsvp(true); err != nil {
+		t.Fatalf("Rsvp: %v", err)
+	}
+	if _, err := readPayload(context.Background(), ptr.url()); err != nil {
+		t.Fatalf("payload removed despite KeepOnAck: %v", err)
+	}
+}
+
+func TestLargePayload_FailedSendsDiscardPayloads(t *testing.T) {
+	withPayloadBucket(t, "sqs-payloads-failed", "failing-queue", &LargePayloadConfig{
+		Location:        "s3://sqs-payloads-failed",
+		AlwaysThroughS3: true,
+	})
+	fake := &fakeSQSClient{}
+	withFakeClient(t, fake, "http://fake/failing-queue")
+	p := &Provider{}
+	u, _ := url.Parse("sqs://failing-queue")
+	ctx := context.Background()
+	stored := func(body *string) bool {
+		t.Helper()
+		ptr, err := parsePayloadPointer(*body)
+		if err != nil {
+			t.Fatalf("body is not a pointer: %v", err)
+		}
+		_, err = readPayload(ctx, ptr.url())
+		return err == nil
+	}
+
+	fake.sendFn = func(context.Context, *awssqs.SendMessageInput) (*awssqs.SendMessageOutput, error) {
+		return nil, errors.New("queue unavailable")
+	}
+	if err := p.SendCtx(ctx, u, newProviderMsg(t, p, "lost")); err == nil {
+		t.Fatalf("SendCtx succeeded")
+	}
+	if stored(fake.sendCalls[0].MessageBody) {
+		t.Fatalf("payload of a failed send was kept")
+	}
+
+	// The first chunk is accepted except for msg-1; the second request
+	// fails. Only the payloads of accepted messages stay.
+	fake.batchFn = func(_ context.Context, in *awssqs.SendMessageBatchInput) (*awssqs.SendMessageBatchOutput, error) {
+		if *in.Entries[0].Id != "msg-0" {
+			return nil, errors.New("queue unavailable")
+		}
+		out := &awssqs.SendMessageBatchOutput{}
+		for _, e := range in.Entries {
+			if *e.Id == "msg-1" {
+				out.Failed = append(out.Failed, types.BatchResultErrorEntry{Id: e.Id, Code: strPtr("InvalidParameterValue"), SenderFault: true})
+				continue
+			}
+			out.Successful = append(out.Successful, types.SendMessageBatchResultEntry{Id: e.Id})
+		}
+		return out, nil
+	}
+	msgs := make([]messaging.Message, 12)
+	for i := range msgs {
+		msgs[i] = newProviderMsg(t, p, fmt.Sprintf("m%d", i))
+	}
+	var batchErr *BatchError
+	if err := p.SendBatchCtx(ctx, u, msgs); !errors.As(err, &batchErr) || len(batchErr.Failed) != 3 {
+		t.Fatalf("SendBatchCtx = %v, want a BatchError with 3 failed messages", err)
+	}
+	for _, call := range fake.batchCalls {
+		for _, e := range call.Entries {
+			accepted := call == fake.batchCalls[0] && *e.Id != "msg-1"
+			if stored(e.MessageBody) != accepted {
+				t.Errorf("payload of %s stored = %v, want %v", *e.Id, !accepted, accepted)
+			}
+		}
+	}
+}
+
+func TestParsePayloadPointer(t *testing.T) {
+	for _, body := range []string{
+		`["software.amazon.payloadoffloading.PayloadS3Pointer",{"s3BucketName":"b","s3Key":"k/1"}]`,
+		`{"s3BucketName":"b","s3Key":"k/1"}`,
+	} {
+		ptr, err := parsePayloadPointer(body)
+		if err != nil || ptr.url().String() != "s3://b/k/1" {
+			t.Errorf("parsePayloadPointer(%s) = %+v, %v", body, ptr, err)
+		}
+	}
+	for _, body := range []string{`[]`, `{"s3BucketName":"b"}`, `not json`} {
+		if _, err := parsePayloadPointer(body); err == nil {
+			t.Errorf("parsePayloadPointer(%s) succeeded", body)
+		}
+	}
+}
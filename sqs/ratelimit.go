@@ -0,0 +1,47 @@
+package sqs
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"oss.nandlabs.io/golly-aws/ratelimit"
+)
+
+// RateLimits holds the rate limiter configs of queues, keyed by queue name
+// (the sqs:// URL host). Send and SendBatch wait for the limiter of their
+// queue, if any, before sending; on FIFO queues GroupRate limits each
+// message group. Each queue URL gets a limiter of its own, so same-named
+// queues of different accounts or regions are paced apart:
+//
+//	sqs.RateLimits.Set("ledger.fifo", ratelimit.Config{Rate: 3000, GroupRate: 300})
+var RateLimits = ratelimit.NewRegistry()
+
+// pace waits until the limiter of the queue at queueURL admits messages of
+// the given groups, one per message, and tells a ratelimit.Observer about
+// it. It returns the limiter, nil when the queue is not rate limited.
+func (p *Provider) pace(ctx context.Context, u *url.URL, queueURL string, groups []string) (*ratelimit.Limiter, error) {
+	limiter := RateLimits.LimiterFor(u.Host, queueURL)
+	if limiter == nil {
+		return nil, nil
+	}
+	waited, err := limiter.WaitBatch(ctx, groups)
+	if err != nil {
+		return limiter, fmt.Errorf("sqs: rate limit wait for %s failed: %w", u.Host, err)
+	}
+	if ro, ok := p.currentObserver().(ratelimit.Observer); ok {
+		ro.OnRateWait(u, len(groups), waited, limiter.Rate(), limiter.GroupRate())
+	}
+	return limiter, nil
+}
+
+// throttled lowers the rates of limiter after SQS reported throttling.
+func (p *Provider) throttled(u *url.URL, limiter *ratelimit.Limiter) {
+	if !limiter.Throttled() {
+		return
+	}
+	logger.WarnF("SQS throttled sending to %s; lowered the rate to %.2f/s", u.Host, limiter.Rate())
+	if ro, ok := p.currentObserver().(ratelimit.Observer); ok {
+		ro.OnRateThrottled(u, limiter.Rate(), limiter.GroupRate())
+	}
+}
@@ -0,0 +1,107 @@
+package sqs
+
+import (
+	"context"
+	"net/url"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	awssqs "github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go"
+	"oss.nandlabs.io/golly-aws/ratelimit"
+	"oss.nandlabs.io/golly/messaging"
+)
+
+// rateObserver records the rate limiting hooks on top of recordingObserver.
+type rateObserver struct {
+	recordingObserver
+	rmu       sync.Mutex
+	waits     []int
+	throttled []float64
+}
+
+func (o *rateObserver) OnRateWait(_ *url.URL, n int, _ time.Duration, _, _ float64) {
+	o.rmu.Lock()
+	defer o.rmu.Unlock()
+	o.waits = append(o.waits, n)
+}
+
+func (o *rateObserver) OnRateThrottled(_ *url.URL, rate, _ float64) {
+	o.rmu.Lock()
+	defer o.rmu.Unlock()
+	o.throttled = append(o.throttled, rate)
+}
+
+func TestSendCtx_RateLimit(t *testing.T) {
+	prevBackoff := batchRetryBackoff
+	batchRetryBackoff = 0
+	t.Cleanup(func() { batchRetryBackoff = prevBackoff })
+	if err := RateLimits.Set("paced.fifo", ratelimit.Config{Rate: 1000, GroupRate: 100, GroupBurst: 1}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	t.Cleanup(func() { RateLimits.Remove("paced.fifo") })
+
+	p := &Provider{}
+	obs := &rateObserver{}
+	p.SetObserver(obs)
+	fake := &fakeSQSClient{}
+	fake.batchFn = func(ctx context.Context, in *awssqs.SendMessageBatchInput) (*awssqs.SendMessageBatchOutput, error) {
+		out := &awssqs.SendMessageBatchOutput{}
+		for _, e := range in.Entries {
+			if len(fake.batchCalls) == 1 && *e.Id == "msg-0" {
+				out.Failed = append(out.Failed, types.BatchResultErrorEntry{
+					Id: e.Id, Code: strPtr("RequestThrottled"), Message: strPtr("slow down"), SenderFault: true,
+				})
+				continue
+			}
+			out.Successful = append(out.Successful, types.SendMessageBatchResultEntry{Id: e.Id, MessageId: e.Id})
+		}
+		return out, nil
+	}
+	withFakeClient(t, fake, "http://fake/paced.fifo")
+	u, _ := url.Parse("sqs://paced.fifo")
+	ctx := context.Background()
+
+	// Three messages of one group at 100/s with a burst of 1 take 20ms.
+	start := time.Now()
+	opts := messaging.NewOptionsBuilder().Add(OptMessageGroupId, "g").Add(OptContentDeduplication, true).Build()
+	for _, body := range []string{"a", "b", "c"} {
+		if err := p.SendCtx(ctx, u, newProviderMsg(t, p, body), opts...); err != nil {
+			t.Fatalf("SendCtx: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Fatalf("3 messages of one group were not paced: %v", elapsed)
+	}
+
+	// A throttled batch entry halves the rates before it is resent.
+	msgs := []messaging.Message{newProviderMsg(t, p, "d"), newProviderMsg(t, p, "e")}
+	if err := p.SendBatchCtx(ctx, u, msgs, opts...); err != nil {
+		t.Fatalf("SendBatchCtx: %v", err)
+	}
+	// The rates recover from the moment they were lowered.
+	if len(obs.throttled) != 1 || obs.throttled[0] < 500 || obs.throttled[0] > 510 {
+		t.Fatalf("OnRateThrottled = %v, want about [500]", obs.throttled)
+	}
+	if want := []int{1, 1, 1, 2, 1}; !slices.Equal(obs.waits, want) {
+		t.Fatalf("OnRateWait batch sizes = %v, want %v", obs.waits, want)
+	}
+
+	// Throttling errors of single sends lower the rates too.
+	fake.sendFn = func(context.Context, *awssqs.SendMessageInput) (*awssqs.SendMessageOutput, error) {
+		return nil, &smithy.GenericAPIError{Code: "ThrottlingException", Message: "Rate exceeded"}
+	}
+	if err := p.SendCtx(ctx, u, newProviderMsg(t, p, "f"), opts...); err == nil {
+		t.Fatalf("expected the throttling error")
+	}
+	if got := RateLimits.LimiterFor("paced.fifo", "http://fake/paced.fifo").Rate(); got >= 500 {
+		t.Fatalf("rate after a throttled send = %v", got)
+	}
+	// A queue of the same name elsewhere keeps its own rates.
+	if got := RateLimits.LimiterFor("paced.fifo", "http://other/paced.fifo").Rate(); got != 1000 {
+		t.Fatalf("rate of another paced.fifo queue = %v, want 1000", got)
+	}
+}
@@ -0,0 +1,490 @@
+package sqs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go"
+)
+
+// RedriveStatus is the state of a message-move task, as reported by SQS.
+type RedriveStatus string
+
+const (
+	RedriveRunning    RedriveStatus = "RUNNING"
+	RedriveCompleted  RedriveStatus = "COMPLETED"
+	RedriveCancelling RedriveStatus = "CANCELLING"
+	RedriveCancelled  RedriveStatus = "CANCELLED"
+	RedriveFailed     RedriveStatus = "FAILED"
+)
+
+// localTaskPrefix marks the handles of client-side redrive tasks.
+const localTaskPrefix = "local-"
+
+// maxListedTasks is how many recent tasks ListRedrives reports per
+// dead-letter queue, matching ListMessageMoveTasks.
+const maxListedTasks = 10
+
+// RedriveOptions configures StartRedrive. The zero value moves every
+// message back to the queue it was dead-lettered from, as fast as SQS
+// allows.
+type RedriveOptions struct {
+	// Destination is the queue to move messages to, as an sqs:// URL or an
+	// ARN. Empty moves each message back to its original source queue.
+	Destination string
+	// MaxMessagesPerSecond limits the move rate (1-500). Zero leaves the
+	// rate to SQS, or applies no limit client-side.
+	MaxMessagesPerSecond int
+	// ClientSide moves messages by receiving and re-sending them instead of
+	// starting an SQS message-move task. It is used automatically when the
+	// endpoint does not support message-move tasks (ElasticMQ, for example).
+	ClientSide bool
+	// Progress, if set, is called by client-side tasks after every batch
+	// and once more, with Done set, when the task ends.
+	Progress func(RedriveProgress)
+}
+
+// RedriveProgress reports the state of a client-side redrive task.
+type RedriveProgress struct {
+	Handle string
+	Moved  int64
+	// Failed counts messages that could not be re-sent. They stay in the
+	// dead-letter queue.
+	Failed int64
+	Done   bool
+	// Err is the error that stopped the task early, if any.
+	Err error
+}
+
+// RedriveTask describes a message-move task.
+type RedriveTask struct {
+	// Handle identifies the task for CancelRedrive. Client-side tasks have
+	// handles starting with "local-".
+	Handle string
+	Status RedriveStatus
+	// Source is the dead-letter queue ARN. Destination is the destination
+	// ARN, or for client-side tasks the destination as given; it is empty
+	// when messages go back to their original source queues.
+	Source               string
+	Destination          string
+	MaxMessagesPerSecond int
+	Moved                int64
+	// ToMove is the approximate number of messages the task set out to
+	// move; 0 when unknown.
+	ToMove        int64
+	Started       time.Time
+	FailureReason string
+}
+
+// isUnsupportedAction reports whether err says the endpoint does not
+// implement the called action.
+func isUnsupportedAction(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "UnsupportedOperation", "AWS.SimpleQueueService.UnsupportedOperation", "InvalidAction", "NotImplemented":
+		return true
+	}
+	return false
+}
+
+// StartRedrive moves the messages in the dead-letter queue dlq back to
+// their source queues or to opts.Destination. SQS runs the move as an
+// asynchronous message-move task; on endpoints without message-move tasks,
+// or with opts.ClientSide, the provider runs the move itself in the
+// background. Either way the returned task can be listed and cancelled.
+func (queueAdmin) StartRedrive(ctx context.Context, dlq *url.URL, opts *RedriveOptions) (*RedriveTask, error) {
+	if opts == nil {
+		opts = &RedriveOptions{}
+	}
+	if opts.MaxMessagesPerSecond < 0 || opts.MaxMessagesPerSecond > 500 {
+		return nil, fmt.Errorf("sqs: MaxMessagesPerSecond must be 0-500, got %d", opts.MaxMessagesPerSecond)
+	}
+	if opts.ClientSide {
+		return startLocalRedrive(ctx, dlq, opts)
+	}
+	client, queueURL, err := adminQueue(ctx, dlq)
+	if err != nil {
+		return nil, err
+	}
+	if queueURL == "" {
+		return nil, fmt.Errorf("sqs: queue %q does not exist", dlq.Host)
+	}
+	attrs, err := queueAttributes(ctx, client, queueURL, types.QueueAttributeNameQueueArn)
+	if err != nil {
+		return nil, err
+	}
+	task := &RedriveTask{
+		Status:               RedriveRunning,
+		Source:               attrs[string(types.QueueAttributeNameQueueArn)],
+		MaxMessagesPerSecond: opts.MaxMessagesPerSecond,
+		Started:              time.Now(),
+	}
+	input := &sqs.StartMessageMoveTaskInput{SourceArn: &task.Source}
+	if opts.Destination != "" {
+		if task.Destination, err = resolveQueueRef(ctx, opts.Destination); err != nil {
+			return nil, err
+		}
+		input.DestinationArn = &task.Destination
+	}
+	if opts.MaxMessagesPerSecond > 0 {
+		rate := int32(opts.MaxMessagesPerSecond)
+		input.MaxNumberOfMessagesPerSecond = &rate
+	}
+	out, err := client.StartMessageMoveTask(ctx, input)
+	if isUnsupportedAction(err) {
+		logger.WarnF("SQS endpoint for %s does not support message-move tasks, redriving client-side", dlq.Host)
+		return startLocalRedrive(ctx, dlq, opts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("sqs: failed to start message-move task from %q: %w", dlq.Host, err)
+	}
+	task.Handle = derefStr(out.TaskHandle)
+	return task, nil
+}
+
+// ListRedrives returns the most recent redrive tasks from dlq, newest
+// first: SQS message-move tasks followed by client-side ones.
+func (queueAdmin) ListRedrives(ctx context.Context, dlq *url.URL) ([]*RedriveTask, error) {
+	tasks := listLocalRedrives(dlq)
+	client, queueURL, err := adminQueue(ctx, dlq)
+	if err != nil || queueURL == "" {
+		return tasks, err
+	}
+	attrs, err := queueAttributes(ctx, client, queueURL, types.QueueAttributeNameQueueArn)
+	if err != nil {
+		return nil, err
+	}
+	arn := attrs[string(types.QueueAttributeNameQueueArn)]
+	limit := int32(maxListedTasks)
+	out, err := client.ListMessageMoveTasks(ctx, &sqs.ListMessageMoveTasksInput{SourceArn: &arn, MaxResults: &limit})
+	if isUnsupportedAction(err) {
+		return tasks, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("sqs: failed to list message-move tasks of %q: %w", dlq.Host, err)
+	}
+	remote := make([]*RedriveTask, 0, len(out.Results)+len(tasks))
+	for _, r := range out.Results {
+		t := &RedriveTask{
+			Handle:        derefStr(r.TaskHandle),
+			Status:        RedriveStatus(derefStr(r.Status)),
+			Source:        derefStr(r.SourceArn),
+			Destination:   derefStr(r.DestinationArn),
+			Moved:         r.ApproximateNumberOfMessagesMoved,
+			Started:       time.UnixMilli(r.StartedTimestamp),
+			FailureReason: derefStr(r.FailureReason),
+		}
+		if r.ApproximateNumberOfMessagesToMove != nil {
+			t.ToMove = *r.ApproximateNumberOfMessagesToMove
+		}
+		if r.MaxNumberOfMessagesPerSecond != nil {
+			t.MaxMessagesPerSecond = int(*r.MaxNumberOfMessagesPerSecond)
+		}
+		remote = append(remote, t)
+	}
+	return append(remote, tasks...), nil
+}
+
+// CancelRedrive cancels a running redrive task of dlq and returns the
+// approximate number of messages moved so far. Messages already moved are
+// not moved back.
+func (queueAdmin) CancelRedrive(ctx context.Context, dlq *url.URL, handle string) (int64, error) {
+	if strings.HasPrefix(handle, localTaskPrefix) {
+		return cancelLocalRedrive(handle)
+	}
+	client, err := resolveAdminClient(dlq)
+	if err != nil {
+		return 0, err
+	}
+	out, err := client.CancelMessageMoveTask(ctx, &sqs.CancelMessageMoveTaskInput{TaskHandle: &handle})
+	if err != nil {
+		return 0, fmt.Errorf("sqs: failed to cancel message-move task of %q: %w", dlq.Host, err)
+	}
+	return out.ApproximateNumberOfMessagesMoved, nil
+}
+
+// ---- Client-side redrive ---------------------------------------------------
+
+// localRedrive is a redrive task run by this process.
+type localRedrive struct {
+	dlq    string
+	cancel context.CancelFunc
+
+	mu   sync.Mutex
+	task RedriveTask
+}
+
+var (
+	localRedrivesMu sync.Mutex
+	localRedrives   = map[string]*localRedrive{}
+)
+
+func (r *localRedrive) snapshot() *RedriveTask {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t := r.task
+	return &t
+}
+
+func startLocalRedrive(ctx context.Context, dlq *url.URL, opts *RedriveOptions) (*RedriveTask, error) {
+	client, queueURL, err := resolveClient(dlq)
+	if err != nil {
+		return nil, err
+	}
+	out, err := client.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl: &queueURL,
+		AttributeNames: []types.QueueAttributeName{
+			types.QueueAttributeNameQueueArn,
+			types.QueueAttributeNameApproximateNumberOfMessages,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("sqs: failed to get attributes of %s: %w", queueURL, err)
+	}
+	toMove, err := strconv.ParseInt(out.Attributes[string(types.QueueAttributeNameApproximateNumberOfMessages)], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("sqs: invalid ApproximateNumberOfMessages of %s: %w", queueURL, err)
+	}
+	var destURL string
+	if opts.Destination != "" {
+		if destURL, err = localQueueURL(ctx, client, opts.Destination); err != nil {
+			return nil, err
+		}
+	}
+	key, err := newPayloadKey()
+	if err != nil {
+		return nil, fmt.Errorf("sqs: failed to generate task handle: %w", err)
+	}
+
+	runCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	r := &localRedrive{
+		dlq:    dlq.Host,
+		cancel: cancel,
+		task: RedriveTask{
+			Handle:               localTaskPrefix + key,
+			Status:               RedriveRunning,
+			Source:               out.Attributes[string(types.QueueAttributeNameQueueArn)],
+			Destination:          opts.Destination,
+			MaxMessagesPerSecond: opts.MaxMessagesPerSecond,
+			ToMove:               toMove,
+			Started:              time.Now(),
+		},
+	}
+
+	localRedrivesMu.Lock()
+	localRedrives[r.task.Handle] = r
+	pruneLocalRedrives(dlq.Host)
+	localRedrivesMu.Unlock()
+
+	go r.run(runCtx, client, queueURL, destURL, opts)
+	return r.snapshot(), nil
+}
+
+// localQueueURL resolves an sqs:// URL or queue ARN to a queue URL.
+// ARNs are looked up through client, the dead-letter queue's client.
+func localQueueURL(ctx context.Context, client API, ref string) (string, error) {
+	if strings.HasPrefix(ref, "arn:") {
+		parts := strings.Split(ref, ":")
+		if len(parts) != 6 {
+			return "", fmt.Errorf("sqs: %q is not a queue ARN", ref)
+		}
+		input := &sqs.GetQueueUrlInput{QueueName: &parts[5]}
+		if parts[4] != "" {
+			input.QueueOwnerAWSAccountId = &parts[4]
+		}
+		out, err := client.GetQueueUrl(ctx, input)
+		if err != nil {
+			return "", fmt.Errorf("sqs: failed to get queue URL for %q: %w", ref, err)
+		}
+		return derefStr(out.QueueUrl), nil
+	}
+	u, err := url.Parse(ref)
+	if err != nil || u.Scheme != SQSScheme || u.Host == "" {
+		return "", fmt.Errorf("sqs: %q is neither a queue ARN nor an %s:// URL", ref, SQSScheme)
+	}
+	_, queueURL, err := resolveClient(u)
+	return queueURL, err
+}
+
+// run receives from the dead-letter queue until it is empty or the task is
+// cancelled, re-sending every message with its attributes and deleting it
+// once the send succeeded.
+func (r *localRedrive) run(ctx context.Context, client API, queueURL, destURL string, opts *RedriveOptions) {
+	var moved, failed int64
+	var interval time.Duration
+	if opts.MaxMessagesPerSecond > 0 {
+		interval = time.Second / time.Duration(opts.MaxMessagesPerSecond)
+	}
+	destinations := map[string]string{}
+	report := func(done bool, err error) {
+		r.mu.Lock()
+		r.task.Moved = moved
+		if done {
+			switch {
+			case ctx.Err() != nil:
+				r.task.Status = RedriveCancelled
+			case err != nil:
+				r.task.Status = RedriveFailed
+				r.task.FailureReason = err.Error()
+			default:
+				r.task.Status = RedriveCompleted
+			}
+		}
+		r.mu.Unlock()
+		if opts.Progress != nil {
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+			opts.Progress(RedriveProgress{Handle: r.task.Handle, Moved: moved, Failed: failed, Done: done, Err: err})
+		}
+	}
+
+	for ctx.Err() == nil {
+		out, err := client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:                    &queueURL,
+			MaxNumberOfMessages:         10,
+			WaitTimeSeconds:             1,
+			MessageAttributeNames:       []string{"All"},
+			MessageSystemAttributeNames: systemAttributeNames,
+		})
+		if err != nil {
+			if ctx.Err() == nil {
+				report(true, fmt.Errorf("sqs: redrive receive from %s failed: %w", queueURL, err))
+				return
+			}
+			break
+		}
+		if len(out.Messages) == 0 {
+			break
+		}
+		for _, m := range out.Messages {
+			if err := r.move(ctx, client, queueURL, m, destURL, destinations); err != nil {
+				logger.WarnF("SQS redrive %s: message %s not moved: %v", r.task.Handle, derefStr(m.MessageId), err)
+				failed++
+			} else {
+				moved++
+			}
+			if interval > 0 {
+				select {
+				case <-time.After(interval):
+				case <-ctx.Done():
+				}
+			}
+		}
+		report(false, nil)
+	}
+	report(true, nil)
+}
+
+// move re-sends one dead-lettered message and deletes it from the
+// dead-letter queue.
+func (r *localRedrive) move(ctx context.Context, client API, queueURL string, m types.Message, destURL string, destinations map[string]string) error {
+	dest := destURL
+	if dest == "" {
+		source := m.Attributes[string(types.MessageSystemAttributeNameDeadLetterQueueSourceArn)]
+		if source == "" {
+			return errors.New("no destination and no DeadLetterQueueSourceArn")
+		}
+		if dest = destinations[source]; dest == "" {
+			var err error
+			if dest, err = localQueueURL(ctx, client, source); err != nil {
+				return err
+			}
+			destinations[source] = dest
+		}
+	}
+	input := resendInput(m, dest)
+	if isFIFOQueue(dest) {
+		if group := m.Attributes[string(types.MessageSystemAttributeNameMessageGroupId)]; group != "" {
+			input.MessageGroupId = &group
+		} else {
+			input.MessageGroupId = strPtr(defaultFIFOGroupId)
+		}
+		dedup := m.Attributes[string(types.MessageSystemAttributeNameMessageDeduplicationId)]
+		if dedup == "" {
+			dedup = derefStr(m.MessageId)
+		}
+		input.MessageDeduplicationId = &dedup
+	}
+	if _, err := client.SendMessage(ctx, input); err != nil {
+		return err
+	}
+	_, err := client.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: &queueURL, ReceiptHandle: m.ReceiptHandle})
+	return err
+}
+
+// resendInput returns a SendMessage request that sends a received message
+// again to queueURL with its body, message attributes and trace header.
+func resendInput(m types.Message, queueURL string) *sqs.SendMessageInput {
+	input := &sqs.SendMessageInput{
+		QueueUrl:          &queueURL,
+		MessageBody:       m.Body,
+		MessageAttributes: m.MessageAttributes,
+	}
+	if trace, ok := m.Attributes[string(types.MessageSystemAttributeNameAWSTraceHeader)]; ok {
+		input.MessageSystemAttributes = map[string]types.MessageSystemAttributeValue{
+			string(types.MessageSystemAttributeNameForSendsAWSTraceHeader): {DataType: strPtr("String"), StringValue: &trace},
+		}
+	}
+	return input
+}
+
+func listLocalRedrives(dlq *url.URL) []*RedriveTask {
+	localRedrivesMu.Lock()
+	defer localRedrivesMu.Unlock()
+	var tasks []*RedriveTask
+	for _, r := range localRedrives {
+		if r.dlq == dlq.Host {
+			tasks = append(tasks, r.snapshot())
+		}
+	}
+	slices.SortFunc(tasks, func(a, b *RedriveTask) int { return b.Started.Compare(a.Started) })
+	return tasks
+}
+
+// pruneLocalRedrives forgets the oldest finished tasks of dlq beyond
+// maxListedTasks. The caller holds localRedrivesMu.
+func pruneLocalRedrives(dlq string) {
+	var finished []*localRedrive
+	for _, r := range localRedrives {
+		if r.dlq == dlq && r.snapshot().Status != RedriveRunning {
+			finished = append(finished, r)
+		}
+	}
+	if len(finished) <= maxListedTasks {
+		return
+	}
+	slices.SortFunc(finished, func(a, b *localRedrive) int { return b.task.Started.Compare(a.task.Started) })
+	for _, r := range finished[maxListedTasks:] {
+		delete(localRedrives, r.task.Handle)
+	}
+}
+
+func cancelLocalRedrive(handle string) (int64, error) {
+	localRedrivesMu.Lock()
+	r, ok := localRedrives[handle]
+	localRedrivesMu.Unlock()
+	if !ok {
+		return 0, fmt.Errorf("sqs: no redrive task %q", handle)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.task.Status != RedriveRunning {
+		return r.task.Moved, fmt.Errorf("sqs: redrive task %q is %s", handle, r.task.Status)
+	}
+	r.task.Status = RedriveCancelling
+	r.cancel()
+	return r.task.Moved, nil
+}
@@ -0,0 +1,194 @@
+package sqs
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awssqs "github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go"
+)
+
+func TestRedrive_StartsMessageMoveTask(t *testing.T) {
+	fake := newFakeAdminClient()
+	withFakeAdmin(t, fake)
+	ctx := context.Background()
+	dlq, _ := url.Parse("sqs://orders-dlq")
+	for _, u := range []string{"sqs://orders-dlq", "sqs://orders-replay"} {
+		q, _ := url.Parse(u)
+		if _, err := Admin().EnsureQueue(ctx, q, nil); err != nil {
+			t.Fatalf("EnsureQueue(%s): %v", u, err)
+		}
+	}
+
+	task, err := Admin().StartRedrive(ctx, dlq, &RedriveOptions{Destination: "sqs://orders-replay", MaxMessagesPerSecond: 50})
+	if err != nil || task.Handle != "task-1" || task.Status != RedriveRunning {
+		t.Fatalf("StartRedrive = %+v, %v", task, err)
+	}
+	in := fake.moves[0]
+	if *in.SourceArn != "arn:aws:sqs:us-east-1:000000000000:orders-dlq" ||
+		*in.DestinationArn != "arn:aws:sqs:us-east-1:000000000000:orders-replay" ||
+		*in.MaxNumberOfMessagesPerSecond != 50 {
+		t.Fatalf("StartMessageMoveTask input = %+v", in)
+	}
+
+	tasks, err := Admin().ListRedrives(ctx, dlq)
+	if err != nil || len(tasks) != 1 || tasks[0].Handle != "task-1" {
+		t.Fatalf("ListRedrives = %v, %v", tasks, err)
+	}
+	if moved, err := Admin().CancelRedrive(ctx, dlq, "task-1"); err != nil || moved != 3 {
+		t.Fatalf("CancelRedrive = %d, %v", moved, err)
+	}
+
+	if _, err := Admin().StartRedrive(ctx, dlq, &RedriveOptions{MaxMessagesPerSecond: 501}); err == nil {
+		t.Fatalf("expected an error for a rate above 500")
+	}
+}
+
+func TestRedrive_ClientSideFallback(t *testing.T) {
+	admin := newFakeAdminClient()
+	admin.moveErr = &smithy.GenericAPIError{Code: "UnsupportedOperation", Message: "not supported"}
+	withFakeAdmin(t, admin)
+	ctx := context.Background()
+	dlq, _ := url.Parse("sqs://orders-dlq")
+	if _, err := Admin().EnsureQueue(ctx, dlq, nil); err != nil {
+		t.Fatalf("EnsureQueue: %v", err)
+	}
+
+	fake := &fakeSQSClient{}
+	delivered := false
+	fake.recvFn = func(ctx context.Context, in *awssqs.ReceiveMessageInput) (*awssqs.ReceiveMessageOutput, error) {
+		if delivered {
+			return &awssqs.ReceiveMessageOutput{}, nil
+		}
+		delivered = true
+		msg := func(id, body string) types.Message {
+			return types.Message{
+				MessageId:     aws.String(id),
+				Body:          aws.String(body),
+				ReceiptHandle: aws.String("rh-" + id),
+				MessageAttributes: map[string]types.MessageAttributeValue{
+					"tenant": {DataType: aws.String("String"), StringValue: aws.String("t1")},
+				},
+				Attributes: map[string]string{
+					"DeadLetterQueueSourceArn": "arn:aws:sqs:us-east-1:000000000000:orders",
+					"AWSTraceHeader":           "Root=1-abc",
+				},
+			}
+		}
+		return &awssqs.ReceiveMessageOutput{Messages: []types.Message{msg("1", "a"), msg("2", "b")}}, nil
+	}
+	fake.attrs = map[string]string{"ApproximateNumberOfMessages": "2"}
+	withFakeClient(t, fake, "http://fake/orders-dlq")
+
+	done := make(chan RedriveProgress, 4)
+	task, err := Admin().StartRedrive(ctx, dlq, &RedriveOptions{
+		Progress: func(p RedriveProgress) {
+			if p.Done {
+				done <- p
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("StartRedrive: %v", err)
+	}
+	if task.ToMove != 2 {
+		t.Fatalf("ToMove = %d, want 2", task.ToMove)
+	}
+	var p RedriveProgress
+	select {
+	case p = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("redrive did not finish")
+	}
+	if p.Err != nil || p.Moved != 2 || p.Failed != 0 || p.Handle != task.Handle {
+		t.Fatalf("final progress = %+v", p)
+	}
+
+	if len(fake.sendCalls) != 2 || len(fake.delCalls) != 2 {
+		t.Fatalf("sends = %d, deletes = %d", len(fake.sendCalls), len(fake.delCalls))
+	}
+	sent := fake.sendCalls[0]
+	if *sent.QueueUrl != "http://fake/orders" || *sent.MessageBody != "a" || *sent.MessageAttributes["tenant"].StringValue != "t1" {
+		t.Fatalf("re-sent message = %+v", sent)
+	}
+	if v := sent.MessageSystemAttributes["AWSTraceHeader"].StringValue; v == nil || *v != "Root=1-abc" {
+		t.Fatalf("trace header not preserved: %v", sent.MessageSystemAttributes)
+	}
+	if *fake.delCalls[1].QueueUrl != "http://fake/orders-dlq" || *fake.delCalls[1].ReceiptHandle != "rh-2" {
+		t.Fatalf("delete = %+v", fake.delCalls[1])
+	}
+
+	tasks, err := Admin().ListRedrives(ctx, dlq)
+	if err != nil || len(tasks) != 1 || tasks[0].Status != RedriveCompleted || tasks[0].Moved != 2 {
+		t.Fatalf("ListRedrives = %+v, %v", tasks, err)
+	}
+	if _, err := Admin().CancelRedrive(ctx, dlq, task.Handle); err == nil {
+		t.Fatalf("expected an error cancelling a completed task")
+	}
+}
+
+func TestRedrive_CancelClientSide(t *testing.T) {
+	withFakeAdmin(t, newFakeAdminClient())
+	fake := &fakeSQSClient{}
+	fake.recvFn = func(ctx context.Context, in *awssqs.ReceiveMessageInput) (*awssqs.ReceiveMessageOutput, error) {
+		return &awssqs.ReceiveMessageOutput{Messages: []types.Message{{
+			MessageId: aws.String("m"), Body: aws.String("x"), ReceiptHandle: aws.String("rh"),
+		}}}, nil
+	}
+	fake.attrs = map[string]string{"ApproximateNumberOfMessages": "1"}
+	withFakeClient(t, fake, "http://fake/jobs-dlq")
+	dlq, _ := url.Parse("sqs://jobs-dlq")
+
+	done := make(chan RedriveProgress, 1)
+	task, err := Admin().StartRedrive(context.Background(), dlq, &RedriveOptions{
+		Destination:          "sqs://jobs",
+		MaxMessagesPerSecond: 1,
+		ClientSide:           true,
+		Progress: func(p RedriveProgress) {
+			if p.Done {
+				done <- p
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("StartRedrive: %v", err)
+	}
+	if _, err := Admin().CancelRedrive(context.Background(), dlq, task.Handle); err != nil {
+		t.Fatalf("CancelRedrive: %v", err)
+	}
+	select {
+	case p := <-done:
+		if p.Err == nil {
+			t.Fatalf("expected the cancellation to be reported")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("redrive was not cancelled")
+	}
+	tasks := listLocalRedrives(dlq)
+	if len(tasks) != 1 || tasks[0].Status != RedriveCancelled {
+		t.Fatalf("tasks = %+v", tasks)
+	}
+}
+
+func TestRedrive_ClientSideInvalidMessageCount(t *testing.T) {
+	withFakeAdmin(t, newFakeAdminClient())
+	fake := &fakeSQSClient{}
+	withFakeClient(t, fake, "http://fake/jobs-dlq")
+	dlq, _ := url.Parse("sqs://jobs-dlq")
+
+	for _, count := range []string{"", "many"} {
+		fake.attrs = map[string]string{"ApproximateNumberOfMessages": count}
+		_, err := Admin().StartRedrive(context.Background(), dlq, &RedriveOptions{Destination: "sqs://jobs", ClientSide: true})
+		if err == nil || !strings.Contains(err.Error(), "ApproximateNumberOfMessages") {
+			t.Fatalf("StartRedrive with count %q = %v", count, err)
+		}
+	}
+	if tasks := listLocalRedrives(dlq); len(tasks) != 0 {
+		t.Fatalf("failed starts left tasks %+v", tasks)
+	}
+}
@@ -0,0 +1,121 @@
+package sqs
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/url"
+	"time"
+
+	"oss.nandlabs.io/golly/messaging"
+)
+
+// OptRetryPolicy sets the RetryPolicy (or *RetryPolicy) of Receive,
+// ReceiveBatch and AddListener. Messages received with a policy are hidden
+// for the policy's delay when they are nacked with Rsvp(false), instead of
+// being redelivered at once, and a listener backs off failed polls with
+// it. Without the option a nack redelivers immediately and a listener
+// backs off with DefaultRetryPolicy.
+const OptRetryPolicy = "RetryPolicy"
+
+// RetryPolicy is an exponential backoff: attempt n waits
+// BaseDelay*Multiplier^(n-1), capped at MaxDelay, shortened by up to
+// Jitter of itself at random. Zero fields take their defaults.
+type RetryPolicy struct {
+	// BaseDelay is the delay of the first retry. Default: 1s.
+	BaseDelay time.Duration
+	// Multiplier scales the delay of every further retry. Default: 2.
+	Multiplier float64
+	// MaxDelay caps the delay. Default: 15m. Nack delays are also capped
+	// at the 12h SQS visibility timeout limit.
+	MaxDelay time.Duration
+	// Jitter is the fraction (0-1) of the delay that is randomised, so
+	// that consumers failing together do not retry together.
+	Jitter float64
+}
+
+// DefaultRetryPolicy is the poll-error backoff of listeners without an
+// OptRetryPolicy.
+var DefaultRetryPolicy = RetryPolicy{BaseDelay: time.Second, Multiplier: 2, MaxDelay: time.Minute, Jitter: 0.2}
+
+// RetryObserver is an optional extension of messaging.Observer. An
+// observer installed with SetObserver that also implements it is told
+// about every backoff the provider applies.
+type RetryObserver interface {
+	// OnNackBackoff is called when a nacked message is hidden for delay
+	// before delivery attempt attempt+1.
+	OnNackBackoff(u *url.URL, msg messaging.Message, attempt int, delay time.Duration)
+	// OnPollBackoff is called when a listener waits delay after failures
+	// consecutive failed polls.
+	OnPollBackoff(u *url.URL, err error, failures int, delay time.Duration)
+}
+
+// Delay returns the backoff before the retry that follows attempt
+// (1-based).
+func (rp RetryPolicy) Delay(attempt int) time.Duration {
+	base, mult, maxDelay := rp.BaseDelay, rp.Multiplier, rp.MaxDelay
+	if base <= 0 {
+		base = time.Second
+	}
+	if mult < 1 {
+		mult = 2
+	}
+	if maxDelay <= 0 {
+		maxDelay = 15 * time.Minute
+	}
+	if attempt < 1 {
+		attempt = 1
+	}
+	d := float64(base) * math.Pow(mult, float64(attempt-1))
+	if d > float64(maxDelay) {
+		d = float64(maxDelay)
+	}
+	if j := min(max(rp.Jitter, 0), 1); j > 0 {
+		d -= d * j * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// resolveRetryPolicy returns the OptRetryPolicy option, or nil if unset.
+func resolveRetryPolicy(optResolver *messaging.OptionsResolver) (*RetryPolicy, error) {
+	v, ok := optResolver.Get(OptRetryPolicy)
+	if !ok {
+		return nil, nil
+	}
+	switch rp := v.(type) {
+	case RetryPolicy:
+		return &rp, nil
+	case *RetryPolicy:
+		return rp, nil
+	}
+	return nil, fmt.Errorf("sqs: %s: expected sqs.RetryPolicy, got %T", OptRetryPolicy, v)
+}
+
+// visibilitySeconds converts a nack delay to a VisibilityTimeout, rounding
+// up and capping at the 12h SQS maximum.
+func visibilitySeconds(d time.Duration) int32 {
+	return int32(math.Ceil(min(d, maxVisibilityHold).Seconds()))
+}
+
+func (p *Provider) fireOnAck(u *url.URL, msg messaging.Message) {
+	if o := p.currentObserver(); o != nil {
+		o.OnAck(u, msg)
+	}
+}
+
+func (p *Provider) fireOnNack(u *url.URL, msg messaging.Message, attempt int, delay time.Duration) {
+	o := p.currentObserver()
+	if o == nil {
+		return
+	}
+	o.OnNack(u, msg, true)
+	if ro, ok := o.(RetryObserver); ok && delay > 0 {
+		ro.OnNackBackoff(u, msg, attempt, delay)
+	}
+}
+
+func (p *Provider) firePollBackoff(u *url.URL, err error, failures int, delay time.Duration) {
+	if ro, ok := p.currentObserver().(RetryObserver); ok {
+		ro.OnPollBackoff(u, err, failures, delay)
+	}
+}
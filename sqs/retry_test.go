@@ -0,0 +1,167 @@
+package sqs
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awssqs "github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"oss.nandlabs.io/golly/messaging"
+)
+
+// retryObserver records nacks and backoffs on top of recordingObserver.
+type retryObserver struct {
+	recordingObserver
+	mu    sync.Mutex
+	nacks []time.Duration
+	polls []int
+}
+
+func (o *retryObserver) OnNackBackoff(_ *url.URL, _ messaging.Message, attempt int, delay time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.nacks = append(o.nacks, delay)
+}
+
+func (o *retryObserver) OnPollBackoff(_ *url.URL, _ error, failures int, _ time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.polls = append(o.polls, failures)
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	rp := RetryPolicy{BaseDelay: time.Second, Multiplier: 2, MaxDelay: 10 * time.Second}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i, w := range want {
+		if got := rp.Delay(i + 1); got != w {
+			t.Errorf("Delay(%d) = %s, want %s", i+1, got, w)
+		}
+	}
+	rp.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := rp.Delay(3); d < 2*time.Second || d > 4*time.Second {
+			t.Fatalf("jittered Delay(3) = %s", d)
+		}
+	}
+	if d := (RetryPolicy{}).Delay(1); d != time.Second {
+		t.Fatalf("zero policy Delay(1) = %s", d)
+	}
+}
+
+func TestListener_NackBacksOffByReceiveCount(t *testing.T) {
+	p := &Provider{}
+	obs := &retryObserver{}
+	p.SetObserver(obs)
+	fake := &fakeSQSClient{}
+	var once sync.Once
+	fake.recvFn = func(ctx context.Context, in *awssqs.ReceiveMessageInput) (*awssqs.ReceiveMessageOutput, error) {
+		out := &awssqs.ReceiveMessageOutput{}
+		once.Do(func() {
+			out.Messages = []types.Message{{
+				Body:          aws.String("fails"),
+				ReceiptHandle: aws.String("rh"),
+				Attributes:    map[string]string{"ApproximateReceiveCount": "3"},
+			}}
+		})
+		if len(out.Messages) == 0 {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return out, nil
+	}
+	withFakeClient(t, fake, "http://fake/work")
+	u, _ := url.Parse("sqs://work")
+	t.Cleanup(func() { _ = p.Close() })
+
+	nacked := make(chan error, 1)
+	opts := messaging.NewOptionsBuilder().
+		Add(OptRetryPolicy, RetryPolicy{BaseDelay: 10 * time.Second, Multiplier: 3}).
+		Build()
+	err := p.AddListenerCtx(context.Background(), u, func(msg messaging.Message) {
+		nacked <- msg.Rsvp(false)
+	}, opts...)
+	if err != nil {
+		t.Fatalf("AddListenerCtx: %v", err)
+	}
+	select {
+	case err := <-nacked:
+		if err != nil {
+			t.Fatalf("Rsvp(false): %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("listener did not deliver")
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.visCalls) != 1 || fake.visCalls[0].VisibilityTimeout != 90 {
+		t.Fatalf("visibility changes = %+v", fake.visCalls)
+	}
+	obs.mu.Lock()
+	defer obs.mu.Unlock()
+	if len(obs.nacks) != 1 || obs.nacks[0] != 90*time.Second {
+		t.Fatalf("nack backoffs = %v", obs.nacks)
+	}
+}
+
+func TestListener_PollErrorsBackOff(t *testing.T) {
+	p := &Provider{}
+	obs := &retryObserver{}
+	p.SetObserver(obs)
+	fake := &fakeSQSClient{}
+	calls := 0
+	recovered := make(chan struct{})
+	fake.recvFn = func(ctx context.Context, in *awssqs.ReceiveMessageInput) (*awssqs.ReceiveMessageOutput, error) {
+		calls++
+		if calls <= 3 {
+			return nil, errors.New("connection reset")
+		}
+		if calls == 4 {
+			close(recovered)
+		}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	withFakeClient(t, fake, "http://fake/work")
+	u, _ := url.Parse("sqs://work")
+	t.Cleanup(func() { _ = p.Close() })
+
+	opts := messaging.NewOptionsBuilder().
+		Add(OptRetryPolicy, &RetryPolicy{BaseDelay: time.Millisecond}).
+		Build()
+	if err := p.AddListenerCtx(context.Background(), u, func(messaging.Message) {}, opts...); err != nil {
+		t.Fatalf("AddListenerCtx: %v", err)
+	}
+	select {
+	case <-recovered:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("listener did not retry")
+	}
+	obs.mu.Lock()
+	defer obs.mu.Unlock()
+	if len(obs.polls) != 3 || obs.polls[2] != 3 {
+		t.Fatalf("poll backoffs = %v", obs.polls)
+	}
+}
+
+func TestRsvp_WithoutPolicyRedeliversImmediately(t *testing.T) {
+	p := &Provider{}
+	fake := &fakeSQSClient{}
+	msg, err := p.receivedMessage(context.Background(), &url.URL{Scheme: SQSScheme, Host: "q"}, fake, types.Message{
+		Body: aws.String("x"), ReceiptHandle: aws.String("rh"),
+	}, "http://fake/q")
+	if err != nil {
+		t.Fatalf("receivedMessage: %v", err)
+	}
+	if err := msg.Rsvp(false); err != nil {
+		t.Fatalf("Rsvp: %v", err)
+	}
+	if len(fake.visCalls) != 1 || fake.visCalls[0].VisibilityTimeout != 0 {
+		t.Fatalf("visibility changes = %+v", fake.visCalls)
+	}
+}
@@ -0,0 +1,139 @@
+package sqs
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"oss.nandlabs.io/golly/messaging"
+)
+
+const (
+	// OptSchedule delays delivery of sent messages. The value is a
+	// time.Duration from now or an absolute time.Time. Delays up to 15
+	// minutes use the native SQS DelaySeconds; longer ones, and every
+	// schedule on FIFO queues (which have no per-message delay), carry an
+	// AttrDeliverAt attribute that receivers honour. It cannot be combined
+	// with OptDelaySeconds.
+	OptSchedule = "Schedule"
+
+	// AttrDeliverAt is the message attribute holding the delivery time of
+	// a scheduled message, in epoch milliseconds. Receivers put messages
+	// back until that time and never expose the attribute as a header.
+	AttrDeliverAt = "golly-deliver-at"
+
+	// maxNativeDelay is the longest DelaySeconds SQS accepts.
+	maxNativeDelay = 900 * time.Second
+	// maxVisibilityHold is the longest a received message can be hidden.
+	maxVisibilityHold = 12 * time.Hour
+)
+
+// resolveSchedule returns the DelaySeconds and the deliver-at time, if any,
+// to send with on queueURL according to OptDelaySeconds and OptSchedule.
+func resolveSchedule(optResolver *messaging.OptionsResolver, queueURL string) (*int32, time.Time, error) {
+	var delay *int32
+	if v, ok := optResolver.Get(OptDelaySeconds); ok {
+		n, ok := v.(int)
+		if !ok {
+			return nil, time.Time{}, fmt.Errorf("sqs: %s: expected int, got %T", OptDelaySeconds, v)
+		}
+		if n < 0 || n > int(maxNativeDelay/time.Second) {
+			return nil, time.Time{}, fmt.Errorf("sqs: %s must be 0-900, got %d; use %s for longer delays", OptDelaySeconds, n, OptSchedule)
+		}
+		d := int32(n)
+		delay = &d
+	}
+	v, ok := optResolver.Get(OptSchedule)
+	if !ok {
+		return delay, time.Time{}, nil
+	}
+	if delay != nil {
+		return nil, time.Time{}, fmt.Errorf("sqs: %s and %s are mutually exclusive", OptDelaySeconds, OptSchedule)
+	}
+	var at time.Time
+	switch t := v.(type) {
+	case time.Duration:
+		at = time.Now().Add(t)
+	case time.Time:
+		at = t
+	default:
+		return nil, time.Time{}, fmt.Errorf("sqs: %s: expected time.Duration or time.Time, got %T", OptSchedule, v)
+	}
+
+	wait := time.Until(at)
+	switch {
+	case wait <= 0:
+		return nil, time.Time{}, nil
+	case isFIFOQueue(queueURL):
+		return nil, at, nil
+	case wait <= maxNativeDelay:
+		secs := int32(math.Ceil(wait.Seconds()))
+		return &secs, time.Time{}, nil
+	}
+	secs := int32(maxNativeDelay / time.Second)
+	return &secs, at, nil
+}
+
+// withDeliverAt adds the AttrDeliverAt attribute to attrs when at is set.
+func withDeliverAt(attrs map[string]types.MessageAttributeValue, at time.Time) map[string]types.MessageAttributeValue {
+	if at.IsZero() {
+		return attrs
+	}
+	if attrs == nil {
+		attrs = make(map[string]types.MessageAttributeValue, 1)
+	}
+	attrs[AttrDeliverAt] = types.MessageAttributeValue{
+		DataType:    strPtr("Number"),
+		StringValue: strPtr(strconv.FormatInt(at.UnixMilli(), 10)),
+	}
+	return attrs
+}
+
+// holdUntilDue reports whether sqsMsg is scheduled for later, in which case
+// it has been put back instead of being delivered: re-enqueued with up to
+// 15 minutes of delay on standard queues, or hidden for up to 12 hours on
+// FIFO queues, where re-enqueueing would break ordering. A due message has
+// its AttrDeliverAt attribute removed.
+func (p *Provider) holdUntilDue(ctx context.Context, client API, queueURL string, sqsMsg *types.Message) bool {
+	attr, ok := sqsMsg.MessageAttributes[AttrDeliverAt]
+	if !ok {
+		return false
+	}
+	ms, err := strconv.ParseInt(derefStr(attr.StringValue), 10, 64)
+	wait := time.Until(time.UnixMilli(ms))
+	if err != nil || wait <= 0 {
+		attrs := make(map[string]types.MessageAttributeValue, len(sqsMsg.MessageAttributes))
+		for k, v := range sqsMsg.MessageAttributes {
+			if k != AttrDeliverAt {
+				attrs[k] = v
+			}
+		}
+		sqsMsg.MessageAttributes = attrs
+		return false
+	}
+
+	receiptHandle := derefStr(sqsMsg.ReceiptHandle)
+	if isFIFOQueue(queueURL) {
+		secs := int32(math.Ceil(min(wait, maxVisibilityHold).Seconds()))
+		if err := p.changeVisibility(client, queueURL, receiptHandle, secs); err != nil {
+			logger.WarnF("SQS scheduled message %s on %s not held: %v", derefStr(sqsMsg.MessageId), queueURL, err)
+		}
+		return true
+	}
+	input := resendInput(*sqsMsg, queueURL)
+	input.DelaySeconds = int32(math.Ceil(min(wait, maxNativeDelay).Seconds()))
+	if _, err := client.SendMessage(ctx, input); err != nil {
+		// Leave the message; it is redelivered, and held again, once its
+		// visibility timeout expires.
+		logger.WarnF("SQS scheduled message %s on %s not re-enqueued: %v", derefStr(sqsMsg.MessageId), queueURL, err)
+		return true
+	}
+	if _, err := client.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: &queueURL, ReceiptHandle: &receiptHandle}); err != nil {
+		logger.WarnF("SQS scheduled message %s on %s re-enqueued but not deleted: %v", derefStr(sqsMsg.MessageId), queueURL, err)
+	}
+	return true
+}
@@ -0,0 +1,115 @@
+package sqs
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"oss.nandlabs.io/golly/messaging"
+)
+
+func TestResolveSchedule(t *testing.T) {
+	for name, tc := range map[string]struct {
+		queue     string
+		opts      []messaging.Option
+		delay     int32 // -1: no DelaySeconds
+		deliverAt bool
+		err       bool
+	}{
+		"none":             {"http://fake/q", nil, -1, false, false},
+		"delay seconds":    {"http://fake/q", messaging.NewOptionsBuilder().Add(OptDelaySeconds, 30).Build(), 30, false, false},
+		"delay too long":   {"http://fake/q", messaging.NewOptionsBuilder().Add(OptDelaySeconds, 901).Build(), -1, false, true},
+		"native duration":  {"http://fake/q", messaging.NewOptionsBuilder().Add(OptSchedule, 10*time.Minute).Build(), 600, false, false},
+		"chained duration": {"http://fake/q", messaging.NewOptionsBuilder().Add(OptSchedule, 3*time.Hour).Build(), 900, true, false},
+		"past time":        {"http://fake/q", messaging.NewOptionsBuilder().Add(OptSchedule, time.Now().Add(-time.Hour)).Build(), -1, false, false},
+		"fifo":             {"http://fake/q.fifo", messaging.NewOptionsBuilder().Add(OptSchedule, time.Minute).Build(), -1, true, false},
+		"wrong type":       {"http://fake/q", messaging.NewOptionsBuilder().Add(OptSchedule, "1h").Build(), -1, false, true},
+		"both": {"http://fake/q", messaging.NewOptionsBuilder().
+			Add(OptDelaySeconds, 5).Add(OptSchedule, time.Hour).Build(), -1, false, true},
+	} {
+		delay, at, err := resolveSchedule(messaging.NewOptionsResolver(tc.opts...), tc.queue)
+		if (err != nil) != tc.err {
+			t.Errorf("%s: err = %v", name, err)
+			continue
+		}
+		got := int32(-1)
+		if delay != nil {
+			got = *delay
+		}
+		// Durations are measured from slightly before resolveSchedule ran.
+		if got != tc.delay && got != tc.delay+1 {
+			t.Errorf("%s: delay = %d, want %d", name, got, tc.delay)
+		}
+		if at.IsZero() == tc.deliverAt {
+			t.Errorf("%s: deliverAt = %v", name, at)
+		}
+	}
+}
+
+func TestSchedule_LongDelayIsReenqueuedUntilDue(t *testing.T) {
+	fake := &fakeSQSClient{}
+	echoSent(fake)
+	withFakeClient(t, fake, "http://fake/retries")
+	p := &Provider{}
+	u, _ := url.Parse("sqs://retries")
+	ctx := context.Background()
+	opts := messaging.NewOptionsBuilder().Add(OptSchedule, 2*time.Hour).Build()
+
+	if err := p.SendCtx(ctx, u, newProviderMsg(t, p, "later"), opts...); err != nil {
+		t.Fatalf("SendCtx: %v", err)
+	}
+	sent := fake.sendCalls[0]
+	if sent.DelaySeconds != 900 {
+		t.Fatalf("DelaySeconds = %d", sent.DelaySeconds)
+	}
+	if _, ok := sent.MessageAttributes[AttrDeliverAt]; !ok {
+		t.Fatalf("deliver-at attribute missing: %v", sent.MessageAttributes)
+	}
+
+	// Not due: the message hops back onto the queue instead of being delivered.
+	if _, err := p.ReceiveCtx(ctx, u); err == nil {
+		t.Fatalf("expected no message before the delivery time")
+	}
+	if len(fake.sendCalls) != 2 || len(fake.delCalls) != 1 {
+		t.Fatalf("sends = %d, deletes = %d", len(fake.sendCalls), len(fake.delCalls))
+	}
+	hop := fake.sendCalls[1]
+	if *hop.MessageBody != "later" || hop.DelaySeconds != 900 || *hop.MessageAttributes[AttrDeliverAt].StringValue != *sent.MessageAttributes[AttrDeliverAt].StringValue {
+		t.Fatalf("re-enqueued message = %+v", hop)
+	}
+
+	// Due: delivered, without the attribute.
+	past := strconv.FormatInt(time.Now().Add(-time.Second).UnixMilli(), 10)
+	hop.MessageAttributes[AttrDeliverAt] = types.MessageAttributeValue{DataType: aws.String("Number"), StringValue: &past}
+	msg, err := p.ReceiveCtx(ctx, u)
+	if err != nil || msg.ReadAsStr() != "later" {
+		t.Fatalf("ReceiveCtx = %v", err)
+	}
+	if _, ok := msg.GetStrHeader(AttrDeliverAt); ok {
+		t.Fatalf("deliver-at attribute leaked into headers")
+	}
+}
+
+func TestSchedule_FIFOIsHeldByVisibility(t *testing.T) {
+	fake := &fakeSQSClient{}
+	p := &Provider{}
+	at := strconv.FormatInt(time.Now().Add(30*time.Hour).UnixMilli(), 10)
+	msg := types.Message{
+		MessageId:     aws.String("m1"),
+		Body:          aws.String("b"),
+		ReceiptHandle: aws.String("rh"),
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			AttrDeliverAt: {DataType: aws.String("Number"), StringValue: &at},
+		},
+	}
+	if !p.holdUntilDue(context.Background(), fake, "http://fake/jobs.fifo", &msg) {
+		t.Fatalf("message delivered early")
+	}
+	if len(fake.sendCalls) != 0 || len(fake.visCalls) != 1 || fake.visCalls[0].VisibilityTimeout != 43200 {
+		t.Fatalf("sends = %d, visibility = %+v", len(fake.sendCalls), fake.visCalls)
+	}
+}
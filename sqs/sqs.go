@@ -10,6 +10,7 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"oss.nandlabs.io/golly-aws/ratelimit"
 	"oss.nandlabs.io/golly/messaging"
 )
 
@@ -29,7 +30,8 @@ const (
 	OptMessageGroupId = "MessageGroupId"
 	// OptMessageDeduplicationId is the deduplication ID for FIFO queues.
 	OptMessageDeduplicationId = "MessageDeduplicationId"
-	// OptDelaySeconds is the message delay in seconds (0-900).
+	// OptDelaySeconds is the message delay in seconds (0-900). See
+	// OptSchedule for longer delays.
 	OptDelaySeconds = "DelaySeconds"
 
 	// defaultFIFOGroupId is the fallback MessageGroupId when a Keyed
@@ -39,10 +41,14 @@ const (
 
 var sqsSchemes = []string{SQSScheme}
 
-// sqsAPI is the subset of the AWS SQS client surface the provider relies on.
+// systemAttributeNames asks ReceiveMessage for every system attribute, so
+// the typed accessors on MessageSQS are populated.
+var systemAttributeNames = []types.MessageSystemAttributeName{types.MessageSystemAttributeNameAll}
+
+// API is the subset of the AWS SQS client surface the provider relies on.
 // It exists so tests can inject a fake without spinning up LocalStack — the
-// concrete *sqs.Client already satisfies this interface.
-type sqsAPI interface {
+// concrete *sqs.Client already satisfies this interface. See UseClient.
+type API interface {
 	SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error)
 	SendMessageBatch(ctx context.Context, params *sqs.SendMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error)
 	ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error)
@@ -59,7 +65,7 @@ type sqsAPI interface {
 // It is a package-level var so tests can inject a fake client + queue URL
 // without touching the AWS SDK. Production callers get the LocalStack /
 // real-AWS wiring via getSQSClient + resolveQueueURL.
-var resolveClient = func(u *url.URL) (sqsAPI, string, error) {
+var resolveClient = func(u *url.URL) (API, string, error) {
 	client, err := getSQSClient(u)
 	if err != nil {
 		return nil, "", err
@@ -71,12 +77,24 @@ var resolveClient = func(u *url.URL) (sqsAPI, string, error) {
 	return client, queueURL, nil
 }
 
+// UseClient makes every Provider send, receive and acknowledge through the
+// client and queue URL resolve returns for a queue URL, instead of the AWS
+// SDK, until restore is called. It is meant for tests, e.g. with the
+// in-memory queues of the snstest package's Emulator, and is not safe to call
+// while messages are in flight.
+func UseClient(resolve func(u *url.URL) (API, string, error)) (restore func()) {
+	prev := resolveClient
+	resolveClient = resolve
+	return func() { resolveClient = prev }
+}
+
 // sqsListenerEntry tracks a single registered listener so it can be
 // individually cancelled by name via RemoveNamedListener, or as part of
 // a per-host group via RemoveListeners.
 type sqsListenerEntry struct {
 	name   string // empty for unnamed listeners
 	cancel context.CancelFunc
+	state  *listenerState // nil: nothing to wait for on removal
 }
 
 // Provider implements the messaging.Provider interface for AWS SQS.
@@ -198,14 +216,6 @@ func (p *Provider) SendCtx(ctx context.Context, u *url.URL, msg messaging.Messag
 		return err
 	}
 
-	input := &sqs.SendMessageInput{
-		QueueUrl:    &queueURL,
-		MessageBody: strPtr(msg.ReadAsStr()),
-	}
-
-	// Apply message attributes from headers
-	input.MessageAttributes = buildMessageAttributes(msg)
-
 	// Apply options
 	optResolver := messaging.NewOptionsResolver(options...)
 
@@ -215,25 +225,60 @@ func (p *Provider) SendCtx(ctx context.Context, u *url.URL, msg messaging.Messag
 	if _, err := parseBrokerOptions(optResolver, isFIFOQueue(u.Host)); err != nil {
 		return err
 	}
-
+	delay, deliverAt, err := resolveSchedule(optResolver, queueURL)
+	if err != nil {
+		return err
+	}
 	var explicitGroupId *string
 	if v, ok := optResolver.Get(OptMessageGroupId); ok {
 		groupId := v.(string)
 		explicitGroupId = &groupId
 	}
-	input.MessageGroupId = resolveGroupId(msg, queueURL, explicitGroupId)
+	var explicitDedupId *string
 	if v, ok := optResolver.Get(OptMessageDeduplicationId); ok {
 		dedupId := v.(string)
-		input.MessageDeduplicationId = &dedupId
+		explicitDedupId = &dedupId
+	}
+	contentHash, _ := messaging.ResolveOptValue[bool](OptContentDeduplication, optResolver)
+	encoding, _ := messaging.ResolveOptValue[string](OptBodyEncoding, optResolver)
+
+	// Encode the body and apply message attributes from headers, then move
+	// the body to S3 if the queue offloads large payloads. From here on a
+	// failed send discards the offloaded payload.
+	body, attrs, err := encodeBody(msg, encoding)
+	var payload *payloadPointer
+	if err == nil {
+		body, attrs, payload, err = offloadPayload(ctx, u, body, withDeliverAt(attrs, deliverAt))
 	}
-	if v, ok := optResolver.Get(OptDelaySeconds); ok {
-		input.DelaySeconds = int32(v.(int))
+	if err != nil {
+		p.fireOnSend(u, msg, err, 0)
+		return err
+	}
+	input := &sqs.SendMessageInput{
+		QueueUrl:               &queueURL,
+		MessageBody:            &body,
+		MessageAttributes:      attrs,
+		MessageGroupId:         resolveGroupId(msg, queueURL, explicitGroupId),
+		MessageDeduplicationId: resolveDedupId(msg, msg.ReadAsStr(), queueURL, explicitDedupId, contentHash),
+	}
+	if delay != nil {
+		input.DelaySeconds = *delay
 	}
 
+	limiter, err := p.pace(ctx, u, queueURL, []string{derefStr(input.MessageGroupId)})
+	if err != nil {
+		discardPayload(ctx, payload)
+		p.fireOnSend(u, msg, err, 0)
+		return err
+	}
 	start := time.Now()
 	_, sendErr := client.SendMessage(ctx, input)
 	latency := time.Since(start)
 	if sendErr != nil {
+		if ratelimit.IsThrottle(sendErr) {
+			p.throttled(u, limiter)
+		}
+		discardPayload(ctx, payload)
 		sendErr = fmt.Errorf("sqs: send failed: %w", sendErr)
 	}
 	p.fireOnSend(u, msg, sendErr, latency)
@@ -253,7 +298,18 @@ func (p *Provider) SendBatch(u *url.URL, msgs []messaging.Message, options ...me
 }
 
 // SendBatchCtx is the context-aware variant of SendBatch. ctx is propagated
-// into every SendMessageBatch call; observer hooks fire per message.
+// into every SendMessageBatch call; observer hooks fire once per message
+// with that message's outcome.
+//
+// Entries that SQS rejects for a retriable reason are resent, up to
+// OptBatchRetries times. If some messages still fail, every chunk is
+// attempted and a *BatchError listing the failed messages is returned.
+//
+// A chunk that cannot be sent at all, because encoding, offloading or its
+// SendMessageBatch request failed, stops the batch. If nothing was sent
+// yet that error is returned; otherwise the *BatchError also lists every
+// unsent message, with the error as its Err, so the messages not listed
+// are exactly those SQS accepted.
 func (p *Provider) SendBatchCtx(ctx context.Context, u *url.URL, msgs []messaging.Message, options ...messaging.Option) error {
 	if len(msgs) == 0 {
 		return nil
@@ -275,10 +331,22 @@ func (p *Provider) SendBatchCtx(ctx context.Context, u *url.URL, msgs []messagin
 		dedupId := v.(string)
 		explicitDedupId = &dedupId
 	}
-	var explicitDelay *int32
-	if v, ok := optResolver.Get(OptDelaySeconds); ok {
-		d := int32(v.(int))
-		explicitDelay = &d
+	delay, deliverAt, err := resolveSchedule(optResolver, queueURL)
+	if err != nil {
+		return err
+	}
+	contentHash, _ := messaging.ResolveOptValue[bool](OptContentDeduplication, optResolver)
+	encoding, _ := messaging.ResolveOptValue[string](OptBodyEncoding, optResolver)
+	retries := defaultBatchRetries
+	if v, ok := messaging.ResolveOptValue[int](OptBatchRetries, optResolver); ok && v >= 0 {
+		retries = v
+	}
+
+	// A single deduplication ID shared by every entry would make SQS drop
+	// all but the first message of the batch.
+	if explicitDedupId != nil && len(msgs) > 1 && isFIFOQueue(queueURL) {
+		return fmt.Errorf("sqs: %s applies to a single message; set the %s header, implement Deduplicated or use %s for batches",
+			OptMessageDeduplicationId, HeaderMessageDeduplicationId, OptContentDeduplication)
 	}
 
 	// Validate broker-targeted options (golly v1.6.0) before batching.
@@ -288,6 +356,25 @@ func (p *Provider) SendBatchCtx(ctx context.Context, u *url.URL, msgs []messagin
 
 	// SQS limit is 10 messages per batch
 	const maxBatchSize = 10
+	var failed []*BatchEntryError
+	// stop ends the batch at msgs[from], the first message of a chunk that
+	// could not be sent, with cause err. Once earlier chunks were sent, the
+	// result is a *BatchError listing every unsent message along with the
+	// entries that failed so far; the messages up to sentTo went out in the
+	// failed request and count one attempt.
+	stop := func(from, sentTo int, err error) error {
+		if from == 0 {
+			return err
+		}
+		for k := from; k < len(msgs); k++ {
+			attempts := 0
+			if k < sentTo {
+				attempts = 1
+			}
+			failed = append(failed, batchService.Unsent(k, msgs[k], attempts, err))
+		}
+		return &BatchError{Failed: failed, Total: len(msgs)}
+	}
 	for i := 0; i < len(msgs); i += maxBatchSize {
 		end := i + maxBatchSize
 		if end > len(msgs) {
@@ -296,58 +383,56 @@ func (p *Provider) SendBatchCtx(ctx context.Context, u *url.URL, msgs []messagin
 		batch := msgs[i:end]
 
 		entries := make([]types.SendMessageBatchRequestEntry, len(batch))
+		// payloads[j] is the offloaded payload of batch[j], discarded
+		// unless SQS accepts the message.
+		payloads := make([]*payloadPointer, len(batch))
 		for j, msg := range batch {
 			id := fmt.Sprintf("msg-%d", i+j)
+			body, attrs, err := encodeBody(msg, encoding)
+			if err == nil {
+				body, attrs, payloads[j], err = offloadPayload(ctx, u, body, withDeliverAt(attrs, deliverAt))
+			}
+			if err != nil {
+				for _, ptr := range payloads[:j] {
+					discardPayload(ctx, ptr)
+				}
+				p.fireOnSend(u, msg, err, 0)
+				return stop(i, i, err)
+			}
 			entries[j] = types.SendMessageBatchRequestEntry{
 				Id:                &id,
-				MessageBody:       strPtr(msg.ReadAsStr()),
-				MessageAttributes: buildMessageAttributes(msg),
+				MessageBody:       &body,
+				MessageAttributes: attrs,
 			}
 			entries[j].MessageGroupId = resolveGroupId(msg, queueURL, explicitGroupId)
-			if explicitDedupId != nil {
-				entries[j].MessageDeduplicationId = explicitDedupId
-			}
-			if explicitDelay != nil {
-				entries[j].DelaySeconds = *explicitDelay
+			entries[j].MessageDeduplicationId = resolveDedupId(msg, msg.ReadAsStr(), queueURL, explicitDedupId, contentHash)
+			if delay != nil {
+				entries[j].DelaySeconds = *delay
 			}
 		}
 
-		start := time.Now()
-		output, sendErr := client.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{
-			QueueUrl: &queueURL,
-			Entries:  entries,
-		})
-		latency := time.Since(start)
-
+		chunkFailed, sendErr := p.sendChunk(ctx, client, u, queueURL, i, batch, entries, retries)
 		if sendErr != nil {
-			sendErr = fmt.Errorf("sqs: batch send failed: %w", sendErr)
-			// Fire per-message failure so callers can attribute errors.
-			for _, m := range batch {
-				p.fireOnSend(u, m, sendErr, latency)
+			for _, ptr := range payloads {
+				discardPayload(ctx, ptr)
 			}
-			return sendErr
-		}
-
-		var batchErr error
-		if len(output.Failed) > 0 {
-			batchErr = fmt.Errorf("sqs: %d messages failed in batch send: %s",
-				len(output.Failed), *output.Failed[0].Message)
-		}
-		for _, m := range batch {
-			p.fireOnSend(u, m, batchErr, latency)
+			return stop(i, end, sendErr)
 		}
-		if batchErr != nil {
-			return batchErr
+		for _, fe := range chunkFailed {
+			discardPayload(ctx, payloads[fe.Index-i])
 		}
-
-		logger.InfoF("SQS batch sent %d messages to %s", len(batch), queueURL)
+		failed = append(failed, chunkFailed...)
+		logger.InfoF("SQS batch sent %d of %d messages to %s", len(batch)-len(chunkFailed), len(batch), queueURL)
 	}
 
+	if len(failed) > 0 {
+		return &BatchError{Failed: failed, Total: len(msgs)}
+	}
 	return nil
 }
 
 // Receive receives a single message from the SQS queue.
-// Supports options: WaitTimeSeconds, VisibilityTimeout.
+// Supports options: WaitTimeSeconds, VisibilityTimeout, RetryPolicy.
 func (p *Provider) Receive(u *url.URL, options ...messaging.Option) (messaging.Message, error) {
 	return p.ReceiveCtx(context.Background(), u, options...)
 }
@@ -361,9 +446,10 @@ func (p *Provider) ReceiveCtx(ctx context.Context, u *url.URL, options ...messag
 	}
 
 	input := &sqs.ReceiveMessageInput{
-		QueueUrl:              &queueURL,
-		MaxNumberOfMessages:   1,
-		MessageAttributeNames: []string{"All"},
+		QueueUrl:                    &queueURL,
+		MaxNumberOfMessages:         1,
+		MessageAttributeNames:       []string{"All"},
+		MessageSystemAttributeNames: systemAttributeNames,
 	}
 
 	optResolver := messaging.NewOptionsResolver(options...)
@@ -381,6 +467,10 @@ func (p *Provider) ReceiveCtx(ctx context.Context, u *url.URL, options ...messag
 	if err := bo.validateRedrivePolicy(context.Background(), client, queueURL); err != nil {
 		return nil, err
 	}
+	retry, err := resolveRetryPolicy(optResolver)
+	if err != nil {
+		return nil, err
+	}
 
 	if v, ok := optResolver.Get(OptWaitTimeSeconds); ok {
 		input.WaitTimeSeconds = int32(v.(int))
@@ -398,18 +488,23 @@ func (p *Provider) ReceiveCtx(ctx context.Context, u *url.URL, options ...messag
 		return nil, wrapped
 	}
 
-	if len(output.Messages) == 0 {
+	if len(output.Messages) == 0 || p.holdUntilDue(ctx, client, queueURL, &output.Messages[0]) {
 		notFound := fmt.Errorf("sqs: no messages available")
 		return nil, notFound
 	}
 
-	msg := p.toMessage(output.Messages[0], queueURL)
+	msg, err := p.receivedMessage(ctx, u, client, output.Messages[0], queueURL)
+	if err != nil {
+		p.fireOnReceive(u, nil, err)
+		return nil, err
+	}
+	msg.retry = retry
 	p.fireOnReceive(u, msg, nil)
 	return msg, nil
 }
 
 // ReceiveBatch receives a batch of messages from the SQS queue.
-// Supports options: BatchSize (default 10), WaitTimeSeconds, VisibilityTimeout.
+// Supports options: BatchSize (default 10), WaitTimeSeconds, VisibilityTimeout, RetryPolicy.
 func (p *Provider) ReceiveBatch(u *url.URL, options ...messaging.Option) ([]messaging.Message, error) {
 	return p.ReceiveBatchCtx(context.Background(), u, options...)
 }
@@ -432,6 +527,10 @@ func (p *Provider) ReceiveBatchCtx(ctx context.Context, u *url.URL, options ...m
 	if err := bo.validateRedrivePolicy(context.Background(), client, queueURL); err != nil {
 		return nil, err
 	}
+	retry, err := resolveRetryPolicy(optResolver)
+	if err != nil {
+		return nil, err
+	}
 
 	maxMessages := int32(10)
 	if v, ok := optResolver.Get(OptBatchSize); ok {
@@ -445,9 +544,10 @@ func (p *Provider) ReceiveBatchCtx(ctx context.Context, u *url.URL, options ...m
 	}
 
 	input := &sqs.ReceiveMessageInput{
-		QueueUrl:              &queueURL,
-		MaxNumberOfMessages:   maxMessages,
-		MessageAttributeNames: []string{"All"},
+		QueueUrl:                    &queueURL,
+		MaxNumberOfMessages:         maxMessages,
+		MessageAttributeNames:       []string{"All"},
+		MessageSystemAttributeNames: systemAttributeNames,
 	}
 
 	if bo.hasVisibilityTimeout {
@@ -473,10 +573,29 @@ func (p *Provider) ReceiveBatchCtx(ctx context.Context, u *url.URL, options ...m
 		return nil, fmt.Errorf("sqs: no messages available")
 	}
 
-	msgs := make([]messaging.Message, len(output.Messages))
-	for i, sqsMsg := range output.Messages {
-		msgs[i] = p.toMessage(sqsMsg, queueURL)
-		p.fireOnReceive(u, msgs[i], nil)
+	msgs := make([]messaging.Message, 0, len(output.Messages))
+	var loadErr error
+	for _, sqsMsg := range output.Messages {
+		if p.holdUntilDue(ctx, client, queueURL, &sqsMsg) {
+			continue
+		}
+		msg, err := p.receivedMessage(ctx, u, client, sqsMsg, queueURL)
+		if err != nil {
+			// The message stays on the queue and is redelivered once its
+			// visibility timeout expires.
+			p.fireOnReceive(u, nil, err)
+			loadErr = err
+			continue
+		}
+		msg.retry = retry
+		p.fireOnReceive(u, msg, nil)
+		msgs = append(msgs, msg)
+	}
+	if len(msgs) == 0 {
+		if loadErr == nil {
+			loadErr = fmt.Errorf("sqs: no messages available")
+		}
+		return nil, loadErr
 	}
 
 	return msgs, nil
@@ -484,7 +603,9 @@ func (p *Provider) ReceiveBatchCtx(ctx context.Context, u *url.URL, options ...m
 
 // AddListener registers a listener that continuously polls the SQS queue for messages.
 // The listener runs in a goroutine and can be stopped by calling Close on the provider.
-// Supports options: WaitTimeSeconds, VisibilityTimeout, Timeout (total listener duration in seconds).
+// Supports options: WaitTimeSeconds, VisibilityTimeout, Timeout (total listener duration in seconds),
+// RetryPolicy (nack delay and poll-error backoff), DrainTimeout (shutdown wait) and
+// GroupConcurrency (parallel, per-group ordered handling on FIFO queues).
 func (p *Provider) AddListener(u *url.URL, listener func(msg messaging.Message), options ...messaging.Option) error {
 	return p.AddListenerCtx(context.Background(), u, listener, options...)
 }
@@ -510,6 +631,18 @@ func (p *Provider) AddListenerCtx(ctx context.Context, u *url.URL, listener func
 	if err := bo.validateRedrivePolicy(context.Background(), client, queueURL); err != nil {
 		return err
 	}
+	retry, err := resolveRetryPolicy(optResolver)
+	if err != nil {
+		return err
+	}
+	pollRetry := DefaultRetryPolicy
+	if retry != nil {
+		pollRetry = *retry
+	}
+	groups, err := resolveGroupConcurrency(optResolver, queueURL)
+	if err != nil {
+		return err
+	}
 
 	waitTime := int32(5)
 	if v, ok := optResolver.Get(OptWaitTimeSeconds); ok {
@@ -524,6 +657,15 @@ func (p *Provider) AddListenerCtx(ctx context.Context, u *url.URL, listener func
 		visibilityTimeout = int32(v.(int))
 	}
 
+	drainTimeout := DefaultDrainTimeout
+	if v, ok := optResolver.Get(OptDrainTimeout); ok {
+		d, ok := v.(time.Duration)
+		if !ok || d < 0 {
+			return fmt.Errorf("sqs: %s: expected non-negative time.Duration, got %v", OptDrainTimeout, v)
+		}
+		drainTimeout = d
+	}
+
 	pollCtx, cancel := context.WithCancel(ctx)
 	if v, ok := optResolver.Get(OptTimeout); ok {
 		timeout := time.Duration(v.(int)) * time.Second
@@ -541,12 +683,28 @@ func (p *Provider) AddListenerCtx(ctx context.Context, u *url.URL, listener func
 	if p.listeners == nil {
 		p.listeners = make(map[string][]sqsListenerEntry)
 	}
-	p.listeners[u.Host] = append(p.listeners[u.Host], sqsListenerEntry{name: listenerName, cancel: cancel})
+	state := newListenerState(u, client, queueURL, drainTimeout)
+	p.listeners[u.Host] = append(p.listeners[u.Host], sqsListenerEntry{name: listenerName, cancel: cancel, state: state})
 	p.mu.Unlock()
 
+	var grouped *groupDispatcher
+	if groups > 0 {
+		grouped = &groupDispatcher{p: p, u: u, client: client, queueURL: queueURL, state: state, retry: retry, listener: listener}
+		grouped.start(pollCtx, groups)
+	}
+
 	go func() {
+		defer close(state.done)
 		defer cancel()
+		if grouped != nil {
+			defer func() {
+				cancel()
+				grouped.stop()
+				state.release(p)
+			}()
+		}
 		logger.InfoF("SQS listener started for %s", queueURL)
+		failures := 0
 		for {
 			if p.closed.Load() {
 				return
@@ -560,10 +718,11 @@ func (p *Provider) AddListenerCtx(ctx context.Context, u *url.URL, listener func
 			}
 
 			input := &sqs.ReceiveMessageInput{
-				QueueUrl:              &queueURL,
-				MaxNumberOfMessages:   10,
-				WaitTimeSeconds:       waitTime,
-				MessageAttributeNames: []string{"All"},
+				QueueUrl:                    &queueURL,
+				MaxNumberOfMessages:         10,
+				WaitTimeSeconds:             waitTime,
+				MessageAttributeNames:       []string{"All"},
+				MessageSystemAttributeNames: systemAttributeNames,
 			}
 			if visibilityTimeout > 0 {
 				input.VisibilityTimeout = visibilityTimeout
@@ -575,68 +734,116 @@ func (p *Provider) AddListenerCtx(ctx context.Context, u *url.URL, listener func
 					return // context cancelled
 				}
 				p.fireOnReceive(u, nil, err)
-				logger.ErrorF("SQS listener receive error: %v", err)
-				time.Sleep(time.Second) // backoff on error
+				failures++
+				delay := pollRetry.Delay(failures)
+				logger.ErrorF("SQS listener receive error (retrying in %s): %v", delay, err)
+				p.firePollBackoff(u, err, failures, delay)
+				select {
+				case <-time.After(delay):
+				case <-pollCtx.Done():
+				}
 				continue
 			}
+			failures = 0
 
-			for _, sqsMsg := range output.Messages {
-				msg := p.toMessage(sqsMsg, queueURL)
-				p.fireOnReceive(u, msg, nil)
-				listener(msg)
+			state.buffer(output.Messages)
+			if grouped != nil {
+				grouped.dispatch(pollCtx, output.Messages)
+				continue
+			}
+			// Dispatch one message at a time from the buffer so that a
+			// shutdown stops between handlers and releases the rest.
+			for {
+				sqsMsg, ok := state.next(pollCtx)
+				if !ok {
+					break
+				}
+				p.dispatch(pollCtx, u, client, queueURL, sqsMsg, retry, listener)
+				state.finish(derefStr(sqsMsg.MessageId))
 			}
+			state.release(p)
 		}
 	}()
 
 	return nil
 }
 
-// Close stops all active listeners.
+// dispatch delivers one received message to a listener. It reports whether
+// the message was put back instead, because it is scheduled for later or
+// the listener nacked it.
+func (p *Provider) dispatch(ctx context.Context, u *url.URL, client API, queueURL string, sqsMsg types.Message, retry *RetryPolicy, listener func(msg messaging.Message)) bool {
+	if p.holdUntilDue(ctx, client, queueURL, &sqsMsg) {
+		return true
+	}
+	msg, err := p.receivedMessage(ctx, u, client, sqsMsg, queueURL)
+	if err != nil {
+		p.fireOnReceive(u, nil, err)
+		logger.ErrorF("SQS listener dropped message from %s: %v", queueURL, err)
+		return false
+	}
+	msg.retry = retry
+	p.fireOnReceive(u, msg, nil)
+	listener(msg)
+	return msg.nacked.Load()
+}
+
+// Close stops all active listeners and drains them; see CloseCtx.
 func (p *Provider) Close() error {
+	return p.CloseCtx(context.Background())
+}
+
+// CloseCtx stops all active listeners and waits for their in-flight
+// handlers, each for up to its OptDrainTimeout and at most until ctx is
+// done. Messages received but not yet dispatched are made visible again at
+// once. A *DrainError lists the messages that could not be finished.
+func (p *Provider) CloseCtx(ctx context.Context) error {
 	p.closed.Store(true)
 	p.mu.Lock()
-	defer p.mu.Unlock()
-	for _, entries := range p.listeners {
-		for _, e := range entries {
-			e.cancel()
-		}
+	var entries []sqsListenerEntry
+	for _, es := range p.listeners {
+		entries = append(entries, es...)
 	}
 	p.listeners = nil
-	return nil
+	p.mu.Unlock()
+	return p.drain(ctx, entries)
 }
 
-// RemoveListeners cancels every listener registered for the URL. Other
-// URLs are untouched. Idempotent — returns nil if no listeners are
-// registered for the URL. Implements messaging.ListenerRemover.
+// RemoveListeners cancels and drains every listener registered for the
+// URL; see RemoveListenersCtx. Implements messaging.ListenerRemover.
 func (p *Provider) RemoveListeners(u *url.URL) error {
+	return p.RemoveListenersCtx(context.Background(), u)
+}
+
+// RemoveListenersCtx cancels every listener registered for the URL and
+// drains them like CloseCtx. Other URLs are untouched. Idempotent —
+// returns nil if no listeners are registered for the URL.
+func (p *Provider) RemoveListenersCtx(ctx context.Context, u *url.URL) error {
 	p.mu.Lock()
-	defer p.mu.Unlock()
 	entries, ok := p.listeners[u.Host]
+	delete(p.listeners, u.Host)
+	p.mu.Unlock()
 	if !ok {
 		return nil
 	}
-	for _, e := range entries {
-		e.cancel()
-	}
-	delete(p.listeners, u.Host)
-	return nil
+	return p.drain(ctx, entries)
 }
 
-// RemoveNamedListener cancels listeners registered under the given name
-// for the URL. Other listeners on the same URL (including unnamed)
-// continue to receive. Idempotent.
+// RemoveNamedListener cancels and drains listeners registered under the
+// given name for the URL. Other listeners on the same URL (including
+// unnamed) continue to receive. Idempotent.
 // Implements messaging.ListenerRemover.
 func (p *Provider) RemoveNamedListener(u *url.URL, name string) error {
 	p.mu.Lock()
-	defer p.mu.Unlock()
 	entries, ok := p.listeners[u.Host]
 	if !ok {
+		p.mu.Unlock()
 		return nil
 	}
+	var removed []sqsListenerEntry
 	kept := entries[:0]
 	for _, e := range entries {
 		if e.name == name {
-			e.cancel()
+			removed = append(removed, e)
 			continue
 		}
 		kept = append(kept, e)
@@ -646,7 +853,8 @@ func (p *Provider) RemoveNamedListener(u *url.URL, name string) error {
 	} else {
 		p.listeners[u.Host] = kept
 	}
-	return nil
+	p.mu.Unlock()
+	return p.drain(context.Background(), removed)
 }
 
 // toMessage converts an SQS message to a MessageSQS.
@@ -667,19 +875,53 @@ func (p *Provider) toMessage(sqsMsg types.Message, queueURL string) *MessageSQS
 	if sqsMsg.ReceiptHandle != nil {
 		receiptHandle = *sqsMsg.ReceiptHandle
 	}
+	messageID := ""
+	if sqsMsg.MessageId != nil {
+		messageID = *sqsMsg.MessageId
+	}
 
 	return &MessageSQS{
-		BaseMessage:   baseMsg,
-		receiptHandle: receiptHandle,
-		queueURL:      queueURL,
-		provider:      p,
+		BaseMessage:      baseMsg,
+		receiptHandle:    receiptHandle,
+		queueURL:         queueURL,
+		provider:         p,
+		messageID:        messageID,
+		systemAttributes: sqsMsg.Attributes,
 	}
 }
 
-// deleteMessage deletes a message from the queue (acknowledges it).
-func (p *Provider) deleteMessage(queueURL, receiptHandle string) error {
+// receivedMessage turns a received SQS message into a MessageSQS, loading
+// an offloaded body from S3 and decoding an encoded body first.
+func (p *Provider) receivedMessage(ctx context.Context, u *url.URL, client API, sqsMsg types.Message, queueURL string) (*MessageSQS, error) {
+	ptr, err := resolvePayload(ctx, &sqsMsg)
+	if err != nil {
+		return nil, err
+	}
+	if err := decodeBody(&sqsMsg); err != nil {
+		return nil, err
+	}
+	msg := p.toMessage(sqsMsg, queueURL)
+	msg.client = client
+	msg.source = u
+	if ptr != nil && !keepPayloadOnAck(u) {
+		msg.payloadURL = ptr.url()
+	}
+	return msg, nil
+}
+
+// ackClient returns the client a message is acknowledged through: the one
+// it was received with, or the default client for messages built elsewhere.
+func ackClient(client API) (API, error) {
+	if client != nil {
+		return client, nil
+	}
 	u, _ := url.Parse("sqs://internal")
-	client, err := getSQSClient(u)
+	return getSQSClient(u)
+}
+
+// deleteMessage deletes a message from the queue (acknowledges it).
+func (p *Provider) deleteMessage(client API, queueURL, receiptHandle string) error {
+	client, err := ackClient(client)
 	if err != nil {
 		return err
 	}
@@ -694,9 +936,8 @@ func (p *Provider) deleteMessage(queueURL, receiptHandle string) error {
 }
 
 // changeVisibility changes the visibility timeout of a message.
-func (p *Provider) changeVisibility(queueURL, receiptHandle string, timeout int32) error {
-	u, _ := url.Parse("sqs://internal")
-	client, err := getSQSClient(u)
+func (p *Provider) changeVisibility(client API, queueURL, receiptHandle string, timeout int32) error {
+	client, err := ackClient(client)
 	if err != nil {
 		return err
 	}
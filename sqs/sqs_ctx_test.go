@@ -23,11 +23,17 @@ type fakeSQSClient struct {
 	sendCalls  []*awssqs.SendMessageInput
 	batchCalls []*awssqs.SendMessageBatchInput
 	recvCalls  []*awssqs.ReceiveMessageInput
+	delCalls   []*awssqs.DeleteMessageInput
+	visCalls   []*awssqs.ChangeMessageVisibilityInput
 
 	// sendFn overrides SendMessage behavior.
 	sendFn func(ctx context.Context, in *awssqs.SendMessageInput) (*awssqs.SendMessageOutput, error)
+	// batchFn overrides SendMessageBatch behavior; nil accepts every entry.
+	batchFn func(ctx context.Context, in *awssqs.SendMessageBatchInput) (*awssqs.SendMessageBatchOutput, error)
 	// recvFn overrides ReceiveMessage behavior; nil returns a canned msg.
 	recvFn func(ctx context.Context, in *awssqs.ReceiveMessageInput) (*awssqs.ReceiveMessageOutput, error)
+	// attrs is returned by GetQueueAttributes.
+	attrs map[string]string
 }
 
 func (f *fakeSQSClient) SendMessage(ctx context.Context, in *awssqs.SendMessageInput, _ ...func(*awssqs.Options)) (*awssqs.SendMessageOutput, error) {
@@ -45,6 +51,9 @@ func (f *fakeSQSClient) SendMessageBatch(ctx context.Context, in *awssqs.SendMes
 	f.mu.Lock()
 	f.batchCalls = append(f.batchCalls, in)
 	f.mu.Unlock()
+	if f.batchFn != nil {
+		return f.batchFn(ctx, in)
+	}
 	successful := make([]types.SendMessageBatchResultEntry, len(in.Entries))
 	for i, e := range in.Entries {
 		id := *e.Id
@@ -69,10 +78,16 @@ func (f *fakeSQSClient) ReceiveMessage(ctx context.Context, in *awssqs.ReceiveMe
 }
 
 func (f *fakeSQSClient) DeleteMessage(ctx context.Context, in *awssqs.DeleteMessageInput, _ ...func(*awssqs.Options)) (*awssqs.DeleteMessageOutput, error) {
+	f.mu.Lock()
+	f.delCalls = append(f.delCalls, in)
+	f.mu.Unlock()
 	return &awssqs.DeleteMessageOutput{}, nil
 }
 
 func (f *fakeSQSClient) ChangeMessageVisibility(ctx context.Context, in *awssqs.ChangeMessageVisibilityInput, _ ...func(*awssqs.Options)) (*awssqs.ChangeMessageVisibilityOutput, error) {
+	f.mu.Lock()
+	f.visCalls = append(f.visCalls, in)
+	f.mu.Unlock()
 	return &awssqs.ChangeMessageVisibilityOutput{}, nil
 }
 
@@ -81,20 +96,24 @@ func (f *fakeSQSClient) GetQueueUrl(ctx context.Context, in *awssqs.GetQueueUrlI
 	return &awssqs.GetQueueUrlOutput{QueueUrl: &q}, nil
 }
 
-// GetQueueAttributes returns an empty attribute set — enough for the
-// broker-options RedrivePolicy validator to treat the queue as
-// non-DLQ-configured, which is the default behavior these tests
-// expect. Tests that need a specific RedrivePolicy should override.
+// GetQueueAttributes returns attrs, by default an empty attribute set —
+// enough for the broker-options RedrivePolicy validator to treat the
+// queue as non-DLQ-configured, which is the default behavior these tests
+// expect. Tests that need specific attributes set attrs.
 func (f *fakeSQSClient) GetQueueAttributes(ctx context.Context, in *awssqs.GetQueueAttributesInput, _ ...func(*awssqs.Options)) (*awssqs.GetQueueAttributesOutput, error) {
-	return &awssqs.GetQueueAttributesOutput{Attributes: map[string]string{}}, nil
+	attrs := map[string]string{}
+	for k, v := range f.attrs {
+		attrs[k] = v
+	}
+	return &awssqs.GetQueueAttributesOutput{Attributes: attrs}, nil
 }
 
-// withFakeClient installs a fake sqsAPI + queue URL and restores the
+// withFakeClient installs a fake API + queue URL and restores the
 // previous resolveClient on cleanup.
-func withFakeClient(t *testing.T, client sqsAPI, queueURL string) {
+func withFakeClient(t *testing.T, client API, queueURL string) {
 	t.Helper()
 	prev := resolveClient
-	resolveClient = func(u *url.URL) (sqsAPI, string, error) {
+	resolveClient = func(u *url.URL) (API, string, error) {
 		return client, queueURL, nil
 	}
 	t.Cleanup(func() { resolveClient = prev })
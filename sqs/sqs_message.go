@@ -1,6 +1,15 @@
 package sqs
 
-import "oss.nandlabs.io/golly/messaging"
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"oss.nandlabs.io/golly/messaging"
+)
 
 // MessageSQS wraps BaseMessage and adds SQS-specific fields for Rsvp (acknowledgement).
 type MessageSQS struct {
@@ -11,19 +20,141 @@ type MessageSQS struct {
 	queueURL string
 	// provider is a back-reference used for Rsvp.
 	provider *Provider
+	// client is the client the message was received with; nil for
+	// messages created with NewMessage.
+	client API
+	// payloadURL is the S3 object holding an offloaded body, deleted once
+	// the message is acknowledged. Nil when there is nothing to clean up.
+	payloadURL *url.URL
+	// messageID is the SQS-assigned message ID.
+	messageID string
+	// systemAttributes holds the SQS system attributes of a received
+	// message, keyed by types.MessageSystemAttributeName.
+	systemAttributes map[string]string
+	// source is the sqs:// URL the message was received from, reported to
+	// the observer on Rsvp.
+	source *url.URL
+	// retry, if set, is the policy that delays redelivery on Rsvp(false).
+	retry *RetryPolicy
+	// nacked records an Rsvp(false), so a FIFO group listener can pause
+	// the message's group.
+	nacked atomic.Bool
 }
 
 // Rsvp acknowledges (deletes) or rejects the message.
-// If accept is true, the message is deleted from the queue.
-// If accept is false, the message visibility timeout is changed to 0 so it becomes immediately available for reprocessing.
+// If accept is true, the message is deleted from the queue, along with its
+// offloaded S3 payload unless the queue's LargePayloadConfig keeps it.
+// If accept is false, the message visibility timeout is changed so it becomes available for reprocessing:
+// to 0, or, for a message received with an OptRetryPolicy, to the policy's delay for its ReceiveCount.
+// The observer's OnAck or OnNack fires once the change succeeded.
 func (m *MessageSQS) Rsvp(accept bool, options ...messaging.Option) (err error) {
 	if m.provider == nil {
 		return nil
 	}
 	if accept {
-		err = m.provider.deleteMessage(m.queueURL, m.receiptHandle)
-	} else {
-		err = m.provider.changeVisibility(m.queueURL, m.receiptHandle, 0)
+		err = m.provider.deleteMessage(m.client, m.queueURL, m.receiptHandle)
+		if err == nil && m.payloadURL != nil {
+			err = deletePayload(context.Background(), m.payloadURL)
+		}
+		if err == nil {
+			m.provider.fireOnAck(m.source, m)
+		}
+		return
+	}
+	m.nacked.Store(true)
+	attempt := max(m.ReceiveCount(), 1)
+	var delay time.Duration
+	if m.retry != nil {
+		delay = m.retry.Delay(attempt)
+	}
+	err = m.provider.changeVisibility(m.client, m.queueURL, m.receiptHandle, visibilitySeconds(delay))
+	if err == nil {
+		m.provider.fireOnNack(m.source, m, attempt, delay)
 	}
 	return
 }
+
+// MessageId returns the ID SQS assigned to the message when it was sent.
+// Unlike Id, which is generated locally, it is stable across redeliveries.
+// It is empty for messages that were not received from SQS.
+func (m *MessageSQS) MessageId() string {
+	return m.messageID
+}
+
+// ReceiptHandle returns the handle of the current delivery.
+func (m *MessageSQS) ReceiptHandle() string {
+	return m.receiptHandle
+}
+
+// SystemAttribute returns a raw SQS system attribute of the message.
+func (m *MessageSQS) SystemAttribute(name types.MessageSystemAttributeName) (string, bool) {
+	v, ok := m.systemAttributes[string(name)]
+	return v, ok
+}
+
+// ReceiveCount returns ApproximateReceiveCount: how many times the message
+// has been received, including this delivery. It is 0 when unknown.
+func (m *MessageSQS) ReceiveCount() int {
+	n, _ := strconv.Atoi(m.systemAttributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)])
+	return n
+}
+
+// DeliveryAttempt returns the 1-based delivery attempt of this message,
+// the count that messaging.MaxDeliveryAttemptsOpt (and the queue's
+// RedrivePolicy maxReceiveCount) is measured against. SQS only tracks an
+// approximate receive count, so this is approximate too.
+func (m *MessageSQS) DeliveryAttempt() int {
+	return m.ReceiveCount()
+}
+
+// SentTimestamp returns when SQS accepted the message; time.Since of it is
+// the time the message spent in the queue. It is the zero time when unknown.
+func (m *MessageSQS) SentTimestamp() time.Time {
+	return m.timestampAttribute(types.MessageSystemAttributeNameSentTimestamp)
+}
+
+// FirstReceiveTimestamp returns when the message was first received
+// (ApproximateFirstReceiveTimestamp). It is the zero time when unknown.
+func (m *MessageSQS) FirstReceiveTimestamp() time.Time {
+	return m.timestampAttribute(types.MessageSystemAttributeNameApproximateFirstReceiveTimestamp)
+}
+
+// SenderId returns the IAM user or role ID of the sender.
+func (m *MessageSQS) SenderId() string {
+	return m.systemAttributes[string(types.MessageSystemAttributeNameSenderId)]
+}
+
+// SequenceNumber returns the FIFO sequence number of the message.
+func (m *MessageSQS) SequenceNumber() string {
+	return m.systemAttributes[string(types.MessageSystemAttributeNameSequenceNumber)]
+}
+
+// MessageGroupId returns the FIFO message group the message was sent to.
+func (m *MessageSQS) MessageGroupId() string {
+	return m.systemAttributes[string(types.MessageSystemAttributeNameMessageGroupId)]
+}
+
+// MessageDeduplicationId returns the FIFO deduplication ID of the message.
+func (m *MessageSQS) MessageDeduplicationId() string {
+	return m.systemAttributes[string(types.MessageSystemAttributeNameMessageDeduplicationId)]
+}
+
+// AWSTraceHeader returns the X-Ray trace header the message was sent with.
+func (m *MessageSQS) AWSTraceHeader() string {
+	return m.systemAttributes[string(types.MessageSystemAttributeNameAWSTraceHeader)]
+}
+
+// DeadLetterQueueSourceArn returns the ARN of the queue a dead-lettered
+// message was moved from.
+func (m *MessageSQS) DeadLetterQueueSourceArn() string {
+	return m.systemAttributes[string(types.MessageSystemAttributeNameDeadLetterQueueSourceArn)]
+}
+
+// timestampAttribute parses an epoch-milliseconds system attribute.
+func (m *MessageSQS) timestampAttribute(name types.MessageSystemAttributeName) time.Time {
+	ms, err := strconv.ParseInt(m.systemAttributes[string(name)], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
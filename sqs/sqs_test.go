@@ -1,9 +1,13 @@
 package sqs
 
 import (
+	"context"
 	"net/url"
 	"testing"
+	"time"
 
+	awssqs "github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
 	"oss.nandlabs.io/golly-aws/awscfg"
 	"oss.nandlabs.io/golly/messaging"
 )
@@ -108,6 +112,63 @@ func TestMessageSQSRsvpNoProvider(t *testing.T) {
 	}
 }
 
+func TestMessageSQSSystemAttributes(t *testing.T) {
+	fake := &fakeSQSClient{
+		recvFn: func(ctx context.Context, in *awssqs.ReceiveMessageInput) (*awssqs.ReceiveMessageOutput, error) {
+			return &awssqs.ReceiveMessageOutput{Messages: []types.Message{{
+				MessageId:     strPtr("5fea7756-0ea4-451a-a703-a558b933e274"),
+				ReceiptHandle: strPtr("rh"),
+				Body:          strPtr("body"),
+				Attributes: map[string]string{
+					"ApproximateReceiveCount":          "3",
+					"SentTimestamp":                    "1700000000000",
+					"ApproximateFirstReceiveTimestamp": "1700000000250",
+					"SequenceNumber":                   "18849496460467696128",
+					"MessageGroupId":                   "g1",
+					"MessageDeduplicationId":           "d1",
+					"SenderId":                         "AIDAEXAMPLE",
+				},
+			}}}, nil
+		},
+	}
+	withFakeClient(t, fake, "http://fake/q.fifo")
+	p := &Provider{}
+	u, _ := url.Parse("sqs://q.fifo")
+
+	m, err := p.ReceiveCtx(context.Background(), u)
+	if err != nil {
+		t.Fatalf("ReceiveCtx: %v", err)
+	}
+	names := fake.recvCalls[0].MessageSystemAttributeNames
+	if len(names) != 1 || names[0] != types.MessageSystemAttributeNameAll {
+		t.Fatalf("MessageSystemAttributeNames = %v", names)
+	}
+	msg := m.(*MessageSQS)
+	if msg.MessageId() != "5fea7756-0ea4-451a-a703-a558b933e274" || msg.ReceiptHandle() != "rh" {
+		t.Fatalf("MessageId = %q, ReceiptHandle = %q", msg.MessageId(), msg.ReceiptHandle())
+	}
+	if msg.ReceiveCount() != 3 || msg.DeliveryAttempt() != 3 {
+		t.Fatalf("ReceiveCount = %d, DeliveryAttempt = %d", msg.ReceiveCount(), msg.DeliveryAttempt())
+	}
+	if !msg.SentTimestamp().Equal(time.UnixMilli(1700000000000)) ||
+		msg.FirstReceiveTimestamp().Sub(msg.SentTimestamp()) != 250*time.Millisecond {
+		t.Fatalf("SentTimestamp = %v, FirstReceiveTimestamp = %v", msg.SentTimestamp(), msg.FirstReceiveTimestamp())
+	}
+	if msg.SequenceNumber() != "18849496460467696128" || msg.MessageGroupId() != "g1" ||
+		msg.MessageDeduplicationId() != "d1" || msg.SenderId() != "AIDAEXAMPLE" {
+		t.Fatalf("FIFO attributes = %q %q %q %q", msg.SequenceNumber(), msg.MessageGroupId(), msg.MessageDeduplicationId(), msg.SenderId())
+	}
+	if _, ok := msg.SystemAttribute(types.MessageSystemAttributeNameAWSTraceHeader); ok {
+		t.Fatalf("unexpected AWSTraceHeader")
+	}
+
+	// Messages built locally have no system attributes.
+	local, _ := p.NewMessage(SQSScheme)
+	if lm := local.(*MessageSQS); lm.DeliveryAttempt() != 0 || !lm.SentTimestamp().IsZero() || lm.MessageId() != "" {
+		t.Fatalf("local message has system attributes")
+	}
+}
+
 func TestProviderClose(t *testing.T) {
 	p := &Provider{}
 	if err := p.Close(); err != nil {